@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deb2000-sudo/trackshift/internal/diagnose"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+)
+
+func main() {
+	sessionsDir := flag.String("sessions-dir", "sessions", "session state directory of the sender or receiver")
+	store := flag.String("session-store", session.StoreJSON, "session state backend: json or bolt")
+	tempDir := flag.String("temp-dir", "", "receiver chunk directory to check received chunks and free space in")
+	logFile := flag.String("log-file", "", "sender or receiver log to search for errors")
+	eventLog := flag.String("event-log", "", "NDJSON event log written with -event-log")
+	peer := flag.String("peer", "", "receiver or relay address to dial, e.g. 10.0.0.2:9000")
+	control := flag.String("control", "", "receiver control API URL, e.g. http://10.0.0.2:9091")
+	orchestrator := flag.String("orchestrator", "", "orchestrator URL, e.g. http://localhost:8080")
+	orchestratorToken := flag.String("orchestrator-token", "", "API token for an orchestrator that requires one (default $TRACKSHIFT_ORCHESTRATOR_TOKEN)")
+	timeout := flag.Duration("timeout", diagnose.DefaultTimeout, "timeout of each network probe")
+	stallAfter := flag.Duration("stall-after", diagnose.DefaultStallAfter, "time without progress after which a transferring session counts as stalled")
+	jsonOut := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: diagnose [flags] <session-id>\n\nInspects a session's saved state, checkpoints, received chunks, logs and\ntelemetry, probes the peer and orchestrator, and ranks the probable causes\nof a stuck transfer with suggested next steps.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *orchestratorToken == "" {
+		*orchestratorToken = os.Getenv("TRACKSHIFT_ORCHESTRATOR_TOKEN")
+	}
+
+	rep := diagnose.Run(diagnose.Config{
+		SessionID:         flag.Arg(0),
+		SessionsDir:       *sessionsDir,
+		Store:             *store,
+		TempDir:           *tempDir,
+		LogFile:           *logFile,
+		EventLog:          *eventLog,
+		Peer:              *peer,
+		Control:           *control,
+		Orchestrator:      *orchestrator,
+		OrchestratorToken: *orchestratorToken,
+		Timeout:           *timeout,
+		StallAfter:        *stallAfter,
+	})
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatalf("encode report: %v", err)
+		}
+		return
+	}
+	printReport(os.Stdout, rep)
+}
+
+func printReport(w io.Writer, rep *diagnose.Report) {
+	fmt.Fprintf(w, "session %s", rep.SessionID)
+	if s := rep.Session; s != nil {
+		fmt.Fprintf(w, ": %s, %s, %d of %d chunks", s.File, s.Status, s.Completed, s.Chunks)
+		if s.ProtocolVersion != 0 {
+			fmt.Fprintf(w, ", protocol v%d", s.ProtocolVersion)
+		}
+	}
+	fmt.Fprint(w, "\n\n")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range rep.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Status, c.Detail)
+	}
+	tw.Flush()
+
+	if len(rep.Findings) == 0 {
+		fmt.Fprintln(w, "\nno probable cause found")
+		return
+	}
+	fmt.Fprintln(w, "\nprobable causes, most likely first:")
+	for i, f := range rep.Findings {
+		fmt.Fprintf(w, "\n%d. [%s, %d] %s\n", i+1, f.Cause, f.Score, f.Summary)
+		for _, e := range f.Evidence {
+			fmt.Fprintf(w, "   | %s\n", strings.TrimSpace(e))
+		}
+		for _, a := range f.Actions {
+			fmt.Fprintf(w, "   -> %s\n", a)
+		}
+	}
+}
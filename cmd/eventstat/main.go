@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+)
+
+func main() {
+	bucket := flag.Duration("bucket", time.Second, "time bucket width for the loss table")
+	jsonOut := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: eventstat [flags] [event-log.ndjson ...]\n\nReads NDJSON event logs written with -event-log (stdin if no files are given)\nand reports loss per time bucket and retransmissions by cause.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		readers := make([]io.Reader, 0, flag.NArg())
+		for _, path := range flag.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				log.Fatalf("open event log: %v", err)
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+		in = io.MultiReader(readers...)
+	}
+
+	rep, err := eventlog.Analyze(in, *bucket)
+	if err != nil {
+		log.Fatalf("analyze: %v", err)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatalf("encode report: %v", err)
+		}
+		return
+	}
+	printReport(os.Stdout, rep)
+}
+
+func printReport(w io.Writer, rep *eventlog.Report) {
+	if rep.Totals.Start.IsZero() {
+		fmt.Fprintln(w, "no events")
+		return
+	}
+	fmt.Fprintf(w, "%s - %s (%s)\n\n", rep.Start.Format("15:04:05.000"), rep.End.Format("15:04:05.000"), rep.End.Sub(rep.Start))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "offset\tsent\tacked\tnacked\tretx\trecv\trejected\tloss\t")
+	for _, b := range rep.Buckets {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.2f%%\t\n",
+			b.Start.Sub(rep.Start), b.Sent, b.Acked, b.Nacked, b.Retransmitted, b.Received, b.Rejected, 100*b.LossRate())
+	}
+	t := rep.Totals
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t%d\t%d\t%.2f%%\t\n",
+		t.Sent, t.Acked, t.Nacked, t.Retransmitted, t.Received, t.Rejected, 100*t.LossRate())
+	tw.Flush()
+
+	if rep.MeanRTTMs > 0 {
+		fmt.Fprintf(w, "\nmean ack RTT: %.2fms\n", rep.MeanRTTMs)
+	}
+	if len(rep.Causes) > 0 {
+		fmt.Fprintln(w, "\nretransmissions by cause:")
+		causes := make([]string, 0, len(rep.Causes))
+		for c := range rep.Causes {
+			causes = append(causes, c)
+		}
+		sort.Slice(causes, func(i, j int) bool { return rep.Causes[causes[i]] > rep.Causes[causes[j]] })
+		for _, c := range causes {
+			fmt.Fprintf(w, "  %-14s %d\n", c, rep.Causes[c])
+		}
+	}
+	if rep.Malformed > 0 {
+		fmt.Fprintf(w, "\n%d malformed lines skipped\n", rep.Malformed)
+	}
+}
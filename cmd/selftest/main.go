@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/internal/selftest"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+func main() {
+	size := flag.String("size", "1GB", "bytes processed by each stage")
+	profile := flag.String("profile", string(genfile.ProfileText), "test data profile: random, text, media, pattern or zero")
+	protocolFlag := flag.String("protocol", "tcp", "transport measured over loopback: tcp or udp")
+	compression := flag.String("compression", "auto", "chunk compression for the end-to-end stage: auto, zstd or none")
+	chunkSize := flag.String("chunk-size", "16MB", "size of the chunks sent over loopback")
+	dir := flag.String("dir", "", "directory for the disk stage's temporary file (default system temp dir)")
+	readPath := flag.String("read", "", "existing file to measure disk reads with, e.g. the real source of a transfer")
+	jsonOut := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: selftest [flags]\n\nMeasures disk, hashing, compression and loopback network throughput on this\nmachine and reports which stage limits a transfer.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	n, err := genfile.ParseSize(*size)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	cs, err := genfile.ParseSize(*chunkSize)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	rep, err := selftest.Run(selftest.Config{
+		Size:        n,
+		Profile:     genfile.Profile(*profile),
+		Protocol:    *protocolFlag,
+		Compression: *compression,
+		ChunkSize:   cs,
+		Dir:         *dir,
+		ReadPath:    *readPath,
+	})
+	if err != nil {
+		log.Fatalf("selftest: %v", err)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatalf("encode report: %v", err)
+		}
+		return
+	}
+	printReport(os.Stdout, rep)
+}
+
+func printReport(w io.Writer, rep *selftest.Report) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tthroughput\tbytes\ttime\tnote")
+	for _, r := range rep.Results {
+		fmt.Fprintf(tw, "%s\t%s/s\t%s\t%s\t%s\n",
+			r.Stage, utils.HumanBytes(int64(r.Rate())), utils.HumanBytes(r.Bytes), r.Duration.Round(time.Millisecond), r.Note)
+	}
+	tw.Flush()
+	if rep.Bottleneck != "" {
+		fmt.Fprintf(w, "\nlimiting stage: %s\n", rep.Bottleneck)
+	}
+}
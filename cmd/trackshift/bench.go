@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/internal/selftest"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// bench transfers generated data to an in-process receiver over loopback
+// with each protocol and chunk size and reports what each transfer cost,
+// to pick the settings that suit this machine.
+func bench(args []string) {
+	fs := cli.NewFlagSet("bench")
+	size := fs.String("size", "256MB", "bytes carried by each transfer")
+	profile := fs.String("profile", string(genfile.ProfileText), "test data profile: random, text, media, pattern or zero")
+	protocols := fs.String("protocols", "tcp,udp", "comma-separated transports to measure: tcp, udp")
+	chunkSizes := fs.String("chunk-sizes", "1MB,4MB,16MB,64MB", "comma-separated chunk sizes to measure")
+	compression := fs.String("compression", "zstd", "compression TCP transfers are measured with besides none: auto, zstd or none")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: trackshift bench [flags]
+
+Sends generated data to a receiver in this process over loopback, once per
+protocol and chunk size, and reports throughput, CPU time, allocations and,
+for TCP, what compression costs and saves. CPU time and allocations are
+those of sender and receiver together.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	n, err := genfile.ParseSize(*size)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	cfg := selftest.BenchConfig{Size: n, Profile: genfile.Profile(*profile), Compression: *compression}
+	for _, p := range strings.Split(*protocols, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.Protocols = append(cfg.Protocols, p)
+		}
+	}
+	for _, s := range strings.Split(*chunkSizes, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		cs, err := genfile.ParseSize(s)
+		if err != nil {
+			log.Fatalf("-chunk-sizes: %v", err)
+		}
+		cfg.ChunkSizes = append(cfg.ChunkSizes, cs)
+	}
+
+	rep, err := selftest.Bench(cfg)
+	if err != nil {
+		log.Fatalf("bench: %v", err)
+	}
+	if *asJSON {
+		printJSON(rep)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTOCOL\tCHUNK\tCOMPRESSION\tTHROUGHPUT\tCPU\tALLOCS\tALLOCATED\tRATIO\tCPU OVERHEAD\tNOTE")
+	for _, r := range rep.Results {
+		cpu, ratio, overhead := "-", "-", "-"
+		if r.CPU > 0 {
+			cpu = r.CPU.Round(time.Millisecond).String()
+		}
+		if r.Ratio() > 0 {
+			ratio = fmt.Sprintf("%.2fx", r.Ratio())
+		}
+		if r.CPUOverhead != 0 {
+			overhead = fmt.Sprintf("%+.0f%%", 100*r.CPUOverhead)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s/s\t%s\t%d\t%s\t%s\t%s\t%s\n", r.Protocol, utils.HumanBytes(r.ChunkSize), r.Compression,
+			utils.HumanBytes(int64(r.Rate())), cpu, r.Allocs, utils.HumanBytes(int64(r.AllocBytes)), ratio, overhead, r.Note)
+	}
+	w.Flush()
+	fmt.Println()
+	for _, r := range rep.Fastest {
+		fmt.Printf("Fastest over %s: %s chunks, compression %s, at %s/s\n", r.Protocol, utils.HumanBytes(r.ChunkSize), r.Compression, utils.HumanBytes(int64(r.Rate())))
+	}
+}
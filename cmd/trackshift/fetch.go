@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/fetch"
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// fetchFile downloads a file held by several receivers from all of them in
+// parallel, each serving distinct chunks through its control API.
+func fetchFile(args []string) {
+	fs := cli.NewFlagSet("fetch")
+	output := fs.String("o", "", "write the file here (default: its name, in the current directory)")
+	chunkSize := fs.String("chunk-size", "8MB", "size of the ranges requested from each source")
+	perSource := fs.Int("per-source", fetch.DefaultPerSource, "chunks requested from each source at a time")
+	maxFailures := fs.Int("max-failures", fetch.DefaultMaxFailures, "chunks in a row that may fail from one source before it is dropped")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: trackshift fetch [flags] SHA256 SOURCE...
+
+Downloads the file with the given content hash from every SOURCE at once.
+Sources are the control API URLs of receivers holding the file, e.g.
+http://10.0.0.5:9091; each serves distinct chunks, every chunk is checked
+against its hash, and a source that keeps failing is dropped.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cs, err := genfile.ParseSize(*chunkSize)
+	if err != nil {
+		log.Fatalf("-chunk-size: %v", err)
+	}
+	var sources []string
+	for _, s := range fs.Args()[1:] {
+		if !strings.Contains(s, "://") {
+			s = "http://" + s
+		}
+		sources = append(sources, strings.TrimSuffix(s, "/"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := fetch.Fetch(ctx, fetch.Options{
+		Sources:     sources,
+		Hash:        fs.Arg(0),
+		Output:      *output,
+		ChunkSize:   cs,
+		PerSource:   *perSource,
+		MaxFailures: *maxFailures,
+	})
+	if err != nil {
+		log.Fatalf("fetch: %v", err)
+	}
+	if *asJSON {
+		printJSON(res)
+		return
+	}
+
+	fmt.Printf("Fetched %s (%s) to %s in %s at %s/s\n", res.File.Name, utils.HumanBytes(res.File.Size), res.Path,
+		res.Duration.Round(time.Millisecond), utils.HumanBytes(int64(res.Rate())))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tCHUNKS\tBYTES\tFAILURES\tNOTE")
+	for _, s := range res.Sources {
+		note := ""
+		if s.Dropped {
+			note = "dropped: " + s.Error
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", s.URL, s.Chunks, utils.HumanBytes(s.Bytes), s.Failures, note)
+	}
+	w.Flush()
+}
@@ -0,0 +1,29 @@
+// Command trackshift sends, receives and relays file transfers and runs the
+// orchestrator, as subcommands of one binary.
+package main
+
+import (
+	"os"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/cli/orchestrate"
+	"github.com/deb2000-sudo/trackshift/internal/cli/receive"
+	"github.com/deb2000-sudo/trackshift/internal/cli/relay"
+	"github.com/deb2000-sudo/trackshift/internal/cli/send"
+)
+
+var commands = []cli.Command{
+	{Name: "send", Summary: "send files to one or more receivers", Run: send.Main},
+	{Name: "receive", Summary: "receive transfers", Run: receive.Main},
+	{Name: "relay", Summary: "forward or re-originate transfers between senders and receivers", Run: relay.Main},
+	{Name: "orchestrate", Summary: "serve the orchestrator API", Run: orchestrate.Main},
+	{Name: "sessions", Summary: "list, show, resume or purge the sessions kept in a session directory", Run: sessions},
+	{Name: "bench", Summary: "measure loopback transfers per protocol and chunk size to tune settings for this machine", Run: bench},
+	{Name: "fetch", Summary: "download a file from several receivers holding it at once, chunk by chunk", Run: fetchFile},
+	{Name: "verify", Summary: "check a delivery receipt, or audit a received file against its session", Run: verify},
+	{Name: "sync", Summary: "send a directory's entries to receivers and, with -watch, whatever is created or changed in it", Run: syncDir},
+}
+
+func main() {
+	cli.Main("trackshift", commands, os.Args[1:])
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/cli/send"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// sessions manages the sessions in a session directory: it lists them,
+// shows them, resumes a sender's session or purges them with their
+// checkpoints and temp chunks.
+func sessions(args []string) {
+	fs := cli.NewFlagSet("sessions")
+	sessionDir := fs.String("sessions-dir", "sessions", "session state directory, as -output-dir of send or -sessions-dir of receive")
+	sessionStore := fs.String("session-store", session.StoreJSON, "session state backend: json or bolt")
+	asJSON := fs.Bool("json", false, "print the list as JSON")
+	tempDir := fs.String("temp-dir", "received/temp", "receiver temp directory, as -temp-dir of receive; purge removes the chunks its sessions left there")
+	statuses := fs.String("status", "completed,failed", "comma-separated statuses of the sessions purge removes when given no IDs")
+	olderThan := fs.Duration("older-than", 0, "purge only sessions last updated longer ago than this")
+	dryRun := fs.Bool("dry-run", false, "list what purge would remove without removing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: trackshift sessions [flags] [command]
+
+Commands:
+  list                     list the sessions, newest first (the default)
+  show ID...               print sessions in full, without their chunk lists
+  resume ID [send flags]   resume a sender's session over the route it last took
+  purge [ID...]            remove the sessions named, or those selected by
+                           -status and -older-than, with their checkpoints
+                           and temp chunks
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store, err := session.OpenStore(*sessionStore, *sessionDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	mgr, err := session.NewSessionManagerWithStore(store)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer mgr.Close()
+
+	cmd, rest := "list", fs.Args()
+	if len(rest) > 0 {
+		switch rest[0] {
+		case "list", "show", "resume", "purge":
+			cmd, rest = rest[0], rest[1:]
+		default:
+			// Session IDs alone are shown, as before there were commands.
+			cmd = "show"
+		}
+	}
+	switch cmd {
+	case "list":
+		listSessions(mgr, *asJSON)
+	case "show":
+		if len(rest) == 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		for _, id := range rest {
+			s, err := mgr.GetSession(id)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			summary := *s
+			summary.Chunks = nil
+			printJSON(&summary)
+		}
+	case "resume":
+		if len(rest) == 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		sendArgs, err := resumeArgs(mgr, rest[0], rest[1:], *sessionDir, *sessionStore)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		// The sender holds the session directory while it runs. Its hints
+		// on how to resume quote os.Args, which become the send command.
+		mgr.Close()
+		os.Args = append([]string{os.Args[0], "send"}, sendArgs...)
+		send.Main(sendArgs)
+	case "purge":
+		opts := session.PurgeOptions{DryRun: *dryRun}
+		if *olderThan > 0 {
+			opts.Before = time.Now().Add(-*olderThan)
+		}
+		for _, s := range strings.Split(*statuses, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				opts.Statuses = append(opts.Statuses, models.SessionStatus(s))
+			}
+		}
+		if err := purgeSessions(mgr, rest, opts, *tempDir); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
+
+// listSessions prints the sessions kept by mgr, newest first.
+func listSessions(mgr *session.SessionManager, asJSON bool) {
+	list := mgr.ListSessions()
+	slices.SortFunc(list, func(a, b *models.TransferSession) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	if asJSON {
+		out := make([]models.TransferSession, len(list))
+		for i, s := range list {
+			out[i] = *s
+			out[i].Chunks = nil
+		}
+		printJSON(out)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tCHUNKS\tSIZE\tAGE\tUPDATED\tFILE")
+	for _, s := range list {
+		progress, _ := mgr.Progress(s.ID)
+		fmt.Fprintf(w, "%s\t%s\t%.0f%%\t%d/%d\t%s\t%s\t%s\t%s\n", s.ID, s.Status, progress, s.Completed, max(s.TotalChunks, len(s.Chunks)),
+			utils.HumanBytes(s.File.Size), formatAge(time.Since(s.CreatedAt)), s.UpdatedAt.Local().Format(time.DateTime), s.File.Name)
+	}
+	w.Flush()
+}
+
+// formatAge renders d to the largest whole unit, as in "45s", "12m", "5h"
+// or "3d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// resumeArgs returns the send arguments that resume session id: the flags
+// given, with the session directory and, unless given, the receiver and
+// relay of the route the session last took. The file must be given with
+// -file, as sessions do not record where it was read from.
+func resumeArgs(mgr *session.SessionManager, id string, flags []string, sessionDir, sessionStore string) ([]string, error) {
+	s, err := mgr.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status == models.SessionStatusCompleted {
+		return nil, fmt.Errorf("session %s is already complete", id)
+	}
+	if !hasFlag(flags, "file") {
+		return nil, fmt.Errorf("give -file with the path of %s, the file of session %s", s.File.Name, id)
+	}
+	args := []string{"-resume", id, "-output-dir", sessionDir, "-session-store", sessionStore}
+	if !hasFlag(flags, "receiver") {
+		if s.Route == nil {
+			return nil, fmt.Errorf("session %s has no recorded route; give -receiver", id)
+		}
+		args = append(args, "-receiver", s.Route.Receiver)
+		if s.Route.Relay != "" && !hasFlag(flags, "relays") {
+			args = append(args, "-relays", s.Route.Relay)
+		}
+	}
+	return append(args, flags...), nil
+}
+
+// hasFlag reports whether args set flag name, in any of the forms the flag
+// package accepts.
+func hasFlag(args []string, name string) bool {
+	for _, a := range args {
+		if a == "--" {
+			return false
+		}
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeSessions removes the sessions ids, or those selected by opts if
+// there are none, along with the chunks they left in tempDir.
+func purgeSessions(mgr *session.SessionManager, ids []string, opts session.PurgeOptions, tempDir string) error {
+	verb := "Purged"
+	if opts.DryRun {
+		verb = "Would purge"
+	}
+	var purged []string
+	if len(ids) == 0 {
+		list, err := mgr.Purge(opts)
+		for _, s := range list {
+			fmt.Printf("%s session %s (%s, %s)\n", verb, s.ID, s.File.Name, s.Status)
+			purged = append(purged, s.ID)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, id := range ids {
+			if !opts.DryRun {
+				if err := mgr.DeleteSession(id); err != nil {
+					return err
+				}
+			} else if _, err := mgr.GetSession(id); err != nil {
+				return err
+			}
+			fmt.Printf("%s session %s\n", verb, id)
+			purged = append(purged, id)
+		}
+	}
+
+	var files int
+	var size int64
+	for _, id := range purged {
+		paths, err := transport.TempFiles(tempDir, id)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			if !opts.DryRun {
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+			}
+			files++
+			size += info.Size()
+		}
+	}
+	if files > 0 {
+		fmt.Printf("%s %d temp files (%s) in %s\n", verb, files, utils.HumanBytes(size), tempDir)
+	}
+	return nil
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/dirsync"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+)
+
+// syncDir sends the entries of a directory with the send command and, with
+// -watch, keeps sending whatever is created or changed in it.
+func syncDir(args []string) {
+	fs := cli.NewFlagSet("sync")
+	watch := fs.Bool("watch", false, "keep watching the directory and send every file created or changed in it, until interrupted")
+	initial := fs.Bool("initial", true, "send every entry of the directory first; with -initial=false only what changes while watching is sent")
+	settle := fs.Duration("settle", dirsync.DefaultSettle, "how long a file must go unchanged before it is sent")
+	retry := fs.Duration("retry-interval", dirsync.DefaultRetry, "how long to wait before sending again an entry that failed")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: trackshift sync [flags] DIR [--] SEND-FLAGS...
+
+Sends each file and subdirectory of DIR with trackshift send and the
+SEND-FLAGS given, which must name a -receiver or -rendezvous. With -watch,
+files created or changed in DIR are sent once they have settled, with -delta
+so that only the changed chunks of a file travel; a change under a
+subdirectory sends the whole subdirectory again. Hidden and temporary files
+are skipped and deletions are not replicated. Run the receivers with
+-on-exists overwrite so changed files replace their earlier versions.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	sendArgs := fs.Args()[1:]
+	if len(sendArgs) > 0 && sendArgs[0] == "--" {
+		sendArgs = sendArgs[1:]
+	}
+	if err := checkSyncSendArgs(sendArgs); err != nil {
+		fmt.Fprintf(fs.Output(), "sync: %v\n", err)
+		os.Exit(2)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *watch {
+		log.Printf("Syncing %s; interrupt to stop", dir)
+	}
+	err = dirsync.Sync(ctx, dirsync.Options{
+		Dir:         dir,
+		Watch:       *watch,
+		SkipInitial: !*initial,
+		Settle:      *settle,
+		Retry:       *retry,
+		Send: func(ctx context.Context, entries []string) []string {
+			return sendEntries(ctx, self, entries, sendArgs)
+		},
+	})
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("Stopped syncing %s", dir)
+	case err != nil:
+		log.Fatalf("sync: %v", err)
+	}
+}
+
+// checkSyncSendArgs rejects send flags that sync sets itself or that make
+// no sense for it, and requires a destination.
+func checkSyncSendArgs(args []string) error {
+	dest := false
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		switch name {
+		case "file", "file-list", "resume", "report-file":
+			return fmt.Errorf("-%s is set by sync and cannot be given", name)
+		case "receiver", "rendezvous":
+			dest = true
+		}
+	}
+	if !dest {
+		return errors.New("the send flags must give a -receiver or -rendezvous")
+	}
+	return nil
+}
+
+// sendEntries sends entries with one run of the send command at self and
+// returns those not delivered to every receiver, judged by the report the
+// run writes.
+func sendEntries(ctx context.Context, self string, entries, sendArgs []string) []string {
+	rf, err := os.CreateTemp("", "trackshift-sync-*.ndjson")
+	if err != nil {
+		log.Printf("Sync: %v", err)
+		return entries
+	}
+	rf.Close()
+	defer os.Remove(rf.Name())
+
+	args := []string{"send", "-delta", "-report-file", rf.Name()}
+	for _, e := range entries {
+		args = append(args, "-file", e)
+	}
+	args = append(args, sendArgs...)
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	// Interrupt the send as a user would, so it saves its sessions.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	log.Printf("Sync: sending %s", strings.Join(entries, ", "))
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		log.Printf("Sync: send: %v", err)
+	}
+
+	summaries, err := readSummaries(rf.Name())
+	if err != nil {
+		log.Printf("Sync: read report: %v", err)
+		return entries
+	}
+	var failed []string
+	for _, e := range entries {
+		if !delivered(e, summaries) {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// readSummaries reads the summaries a send wrote to its report file.
+func readSummaries(path string) ([]report.Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []report.Summary
+	dec := json.NewDecoder(f)
+	for {
+		var s report.Summary
+		if err := dec.Decode(&s); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, s)
+	}
+}
+
+// delivered reports whether the send of entry completed to every receiver.
+// A directory is sent as a tar archive named after it.
+func delivered(entry string, summaries []report.Summary) bool {
+	name := filepath.Base(entry)
+	found := false
+	for _, s := range summaries {
+		if s.File != name && s.File != name+".tar" {
+			continue
+		}
+		if s.Status != report.StatusCompleted {
+			return false
+		}
+		found = true
+	}
+	return found
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/deb2000-sudo/trackshift/internal/audit"
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// verify checks the signature of a delivery receipt, against a trusted
+// receiver key if given, and that a local file matches the one it covers.
+// With -session it instead audits a received file or directory against
+// the hashes its session recorded.
+func verify(args []string) {
+	fs := cli.NewFlagSet("verify")
+	keyPath := fs.String("key", "", "the receiver's public key (receipt.key.pub); without it any valid signature is accepted and its fingerprint printed")
+	sessionID := fs.String("session", "", "audit the file or directory given against this session instead of checking a receipt")
+	sessionDir := fs.String("sessions-dir", "sessions", "session state directory of -session, as -sessions-dir of receive")
+	sessionStore := fs.String("session-store", session.StoreJSON, "session state backend of -session: json or bolt")
+	chunks := fs.Bool("chunks", false, "with -session, also re-hash each chunk's range and print a report per chunk")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: trackshift verify [flags] receipt.json [file]\n       trackshift verify -session ID [flags] path\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *sessionID != "" {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		auditSession(*sessionDir, *sessionStore, *sessionID, fs.Arg(0), *chunks)
+		return
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var r receipt.Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		log.Fatalf("%s: %v", fs.Arg(0), err)
+	}
+	var trusted ed25519.PublicKey
+	if *keyPath != "" {
+		if trusted, err = receipt.LoadPublicKey(*keyPath); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if err := r.Verify(trusted); err != nil {
+		log.Fatalf("%s: %v", fs.Arg(0), err)
+	}
+	fmt.Printf("Receipt for %s (%s, sha256 %s) signed by %s, key %s, at %s\n",
+		r.FileName, utils.HumanBytes(r.Size), r.FileHash, r.Receiver, receipt.Fingerprint(r.PublicKey), r.CompletedAt.Format("2006-01-02 15:04:05 MST"))
+
+	if fs.NArg() == 2 {
+		path := fs.Arg(1)
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		hash, err := utils.HashFileSHA256(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if info.Size() != r.Size || hash != r.FileHash {
+			log.Fatalf("%s does not match the receipt: %d bytes, sha256 %s", path, info.Size(), hash)
+		}
+		fmt.Printf("%s matches the receipt\n", path)
+	}
+}
+
+// auditSession re-hashes path, where session id was delivered, prints what
+// differs from the hashes the session recorded and exits with status 1 if
+// anything does.
+func auditSession(sessionDir, sessionStore, id, path string, chunks bool) {
+	store, err := session.OpenStore(sessionStore, sessionDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	mgr, err := session.NewSessionManagerWithStore(store)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sess, err := mgr.GetSession(id)
+	mgr.Close()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	r, err := audit.Session(sess, path, chunks)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Every file of a directory is listed; chunks only when asked for.
+	if len(r.Files) > 0 || chunks {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if len(r.Files) > 0 {
+			fmt.Fprintln(w, "FILE\tOFFSET\tSIZE\tRESULT")
+			for _, c := range r.Files {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Name, c.Offset, utils.HumanBytes(c.Size), auditResult(c))
+			}
+		}
+		if len(r.Chunks) > 0 {
+			if len(r.Files) > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, "CHUNK\tOFFSET\tSIZE\tRESULT")
+			for _, c := range r.Chunks {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Name, c.Offset, utils.HumanBytes(c.Size), auditResult(c))
+			}
+		}
+		w.Flush()
+	}
+	if chunks && len(r.Chunks) == 0 {
+		fmt.Printf("Session %s recorded no chunk hashes (sender sessions do not); only the whole transfer was checked\n", id)
+	}
+
+	if r.OK() {
+		fmt.Printf("%s matches session %s (%s, sha256 %s)\n", path, id, utils.HumanBytes(sess.File.Size), sess.File.Hash)
+		return
+	}
+	bad := 0
+	for _, c := range append(r.Files, r.Chunks...) {
+		if !c.OK() {
+			bad++
+		}
+	}
+	fmt.Printf("%s does not match session %s: ", path, id)
+	if r.Whole.Err != nil {
+		fmt.Printf("%v", r.Whole.Err)
+	} else {
+		fmt.Printf("sha256 %s, recorded %s", r.Whole.Got, r.Whole.Want)
+	}
+	switch {
+	case bad > 0:
+		fmt.Printf("; %d of %d ranges above differ\n", bad, len(r.Files)+len(r.Chunks))
+	case !chunks && len(sess.Chunks) > 0:
+		fmt.Printf("; run with -chunks to find the damaged chunks\n")
+	default:
+		fmt.Println()
+	}
+	os.Exit(1)
+}
+
+// auditResult describes the outcome of c for the audit report.
+func auditResult(c audit.Check) string {
+	switch {
+	case c.Err != nil:
+		return "error: " + c.Err.Error()
+	case !c.OK():
+		return "MISMATCH (sha256 " + c.Got + ")"
+	}
+	return "ok"
+}
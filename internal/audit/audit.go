@@ -0,0 +1,167 @@
+// Package audit checks a file or directory received earlier against what
+// its session recorded: the SHA-256 of the whole transfer, of each file of
+// a directory transfer and, optionally, of each chunk, so an operator who
+// suspects corruption after the fact can find where it is.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deb2000-sudo/trackshift/internal/manifest"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Check is the result of hashing one range of the received data.
+type Check struct {
+	// Name is the chunk ID or the file's path within the transfer.
+	Name string
+	// Offset and Size locate the range in the session stream.
+	Offset int64
+	Size   int64
+	// Want is the SHA-256 recorded in the session and Got the one of the
+	// data now on disk, both hex-encoded. Got is empty if Err is set.
+	Want string
+	Got  string
+	// Err is why the range could not be read, such as a missing file or
+	// one shorter than recorded.
+	Err error
+}
+
+// OK reports whether the range was read and matches its recorded hash.
+func (c Check) OK() bool { return c.Err == nil && c.Got == c.Want }
+
+// Report is the outcome of auditing one transfer.
+type Report struct {
+	Session string
+	Path    string
+	// Whole checks the transfer's data as a whole.
+	Whole Check
+	// Files checks each file of a directory transfer; it is empty for a
+	// single file.
+	Files []Check
+	// Chunks checks each chunk with a recorded hash, in offset order, if
+	// chunks were asked for. Sender sessions record none.
+	Chunks []Check
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	if !r.Whole.OK() {
+		return false
+	}
+	for _, checks := range [][]Check{r.Files, r.Chunks} {
+		for _, c := range checks {
+			if !c.OK() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Session audits path, where the transfer of sess was delivered: a file,
+// or the directory a directory transfer recreated. A directory packed as a
+// tar archive can only be audited as the archive, before extraction. With
+// chunks set, each chunk the session recorded a hash for is checked too.
+func Session(sess *models.TransferSession, path string, chunks bool) (*Report, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Session: sess.ID, Path: path}
+	var src io.ReaderAt
+	switch {
+	case sess.Manifest != nil:
+		if !info.IsDir() {
+			return nil, fmt.Errorf("session %s received the directory %s; %s is a file", sess.ID, sess.File.Name, path)
+		}
+		mr := manifest.NewReader(path, sess.Manifest)
+		defer mr.Close()
+		src = mr
+		for _, f := range sess.Manifest.Files() {
+			if f.Hash == "" {
+				continue
+			}
+			c := Check{Name: f.Name, Offset: f.Offset, Size: f.Size, Want: f.Hash}
+			c.Got, c.Err = hashFile(filepath.Join(path, filepath.FromSlash(f.Name)), f.Size)
+			r.Files = append(r.Files, c)
+		}
+	case info.IsDir():
+		if sess.File.Archive == models.ArchiveTar {
+			return nil, fmt.Errorf("session %s received %s as a tar archive; audit the archive, not where it was extracted", sess.ID, sess.File.Name)
+		}
+		return nil, fmt.Errorf("session %s received the file %s; %s is a directory", sess.ID, sess.File.Name, path)
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	r.Whole = hashRange(src, Check{Name: sess.File.Name, Size: sess.File.Size, Want: sess.File.Hash})
+	if r.Whole.Err == nil && sess.Manifest == nil && info.Size() != sess.File.Size {
+		r.Whole.Err = fmt.Errorf("file is %d bytes, session recorded %d", info.Size(), sess.File.Size)
+	}
+	if chunks {
+		r.Chunks = checkChunks(src, sess)
+	}
+	return r, nil
+}
+
+// checkChunks hashes the range of every chunk of sess with a recorded hash.
+func checkChunks(src io.ReaderAt, sess *models.TransferSession) []Check {
+	var checks []Check
+	for _, c := range sess.Chunks {
+		if c.IsParity || c.SHA256 == "" || c.Size <= 0 {
+			continue
+		}
+		checks = append(checks, Check{Name: c.ID, Offset: c.Offset, Size: c.Size, Want: c.SHA256})
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Offset < checks[j].Offset })
+	for i := range checks {
+		checks[i] = hashRange(src, checks[i])
+	}
+	return checks
+}
+
+// hashRange fills in c.Got, or c.Err, from the range of src c describes.
+func hashRange(src io.ReaderAt, c Check) Check {
+	h := sha256.New()
+	n, err := io.Copy(h, io.NewSectionReader(src, c.Offset, c.Size))
+	switch {
+	case err != nil:
+		c.Err = err
+	case n < c.Size:
+		c.Err = fmt.Errorf("only %d of %d bytes present", n, c.Size)
+	default:
+		c.Got = hex.EncodeToString(h.Sum(nil))
+	}
+	return c
+}
+
+// hashFile returns the SHA-256 of the file at path, which must be size
+// bytes long.
+func hashFile(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("file is %d bytes, session recorded %d", n, size)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
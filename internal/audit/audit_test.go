@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/manifest"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// fileSession returns a receiver session for data cut into chunks of size.
+func fileSession(data []byte, size int) *models.TransferSession {
+	sess := &models.TransferSession{
+		ID:     "s1",
+		File:   models.FileMetadata{Name: "f.bin", Size: int64(len(data)), Hash: sum(data)},
+		Chunks: make(map[string]*models.ChunkMetadata),
+	}
+	for i, off := 0, 0; off < len(data); i, off = i+1, off+size {
+		end := min(off+size, len(data))
+		id := strconv.Itoa(i)
+		sess.Chunks[id] = &models.ChunkMetadata{ID: id, Offset: int64(off), Size: int64(end - off), SHA256: sum(data[off:end])}
+	}
+	return sess
+}
+
+func TestSessionFile(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sess := fileSession(data, 3000)
+	path := filepath.Join(t.TempDir(), "f.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Session(sess, path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || len(r.Chunks) != 4 {
+		t.Fatalf("intact file: OK %v with %d chunks", r.OK(), len(r.Chunks))
+	}
+
+	// A flipped byte fails the file and the one chunk holding it.
+	data[4000] ^= 1
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = Session(sess, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.OK() || r.Whole.OK() {
+		t.Fatal("corrupt file passed")
+	}
+	for i, c := range r.Chunks {
+		if c.OK() != (i != 1) {
+			t.Errorf("chunk %s at %d: OK %v", c.Name, c.Offset, c.OK())
+		}
+	}
+
+	// A truncated file cannot cover the last chunk.
+	if err := os.Truncate(path, 8000); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = Session(sess, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.Whole.Err == nil || r.Chunks[3].Err == nil || r.Chunks[0].Err != nil {
+		t.Fatalf("truncated file: whole %v, first chunk %v, last chunk %v", r.Whole.Err, r.Chunks[0].Err, r.Chunks[3].Err)
+	}
+
+	if _, err := Session(sess, filepath.Dir(path), false); err == nil {
+		t.Fatal("auditing a file session against a directory succeeded")
+	}
+}
+
+func TestSessionDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "first file", "sub/b.txt": "second file"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, hash, err := manifest.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &models.TransferSession{ID: "s1", File: models.FileMetadata{Name: "tree", Size: m.TotalSize(), Hash: hash}, Manifest: m}
+
+	r, err := Session(sess, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || len(r.Files) != 2 {
+		t.Fatalf("intact tree: OK %v with %d files", r.OK(), len(r.Files))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("SECOND FILE"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = Session(sess, dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if r.OK() || !r.Files[0].OK() || r.Files[1].OK() || r.Files[1].Name != "sub/b.txt" {
+		t.Fatalf("changed file not pinpointed: %+v", r.Files)
+	}
+}
@@ -0,0 +1,161 @@
+// Package authtoken checks the bearer tokens senders present to a receiver
+// and enforces the limits each token carries: the largest transfer it may
+// send and the output subdirectory its files are confined to.
+package authtoken
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// ErrUnauthorized is returned for a missing or unknown token.
+var ErrUnauthorized = errors.New("invalid auth token")
+
+// ErrLimit is returned by Admit for transfers a token does not allow.
+var ErrLimit = errors.New("over the token's limit")
+
+// Token is a secret a receiver accepts and the limits of transfers sent
+// with it.
+type Token struct {
+	// Name identifies the token in logs without revealing it.
+	Name   string
+	Secret string
+	// MaxSize is the largest transfer, in bytes, the token may send; zero
+	// means no limit.
+	MaxSize int64
+	// Subdir, if set, confines the token's files to this directory under
+	// the receiver's output directory.
+	Subdir string
+}
+
+// Parse parses a token given as "SECRET[,max-size=SIZE][,subdir=DIR][,name=NAME]",
+// e.g. "s3cret,max-size=10GB,subdir=ci". The name defaults to the first
+// characters of the secret's SHA-256.
+func Parse(spec string) (Token, error) {
+	fields := strings.Split(spec, ",")
+	t := Token{Secret: strings.TrimSpace(fields[0])}
+	if t.Secret == "" {
+		return Token{}, errors.New("auth token: empty secret")
+	}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok {
+			return Token{}, fmt.Errorf("auth token: %q is not key=value", f)
+		}
+		switch key {
+		case "max-size":
+			n, err := genfile.ParseSize(value)
+			if err != nil || n <= 0 {
+				return Token{}, fmt.Errorf("auth token: invalid max-size %q", value)
+			}
+			t.MaxSize = n
+		case "subdir":
+			dir := filepath.Clean(filepath.FromSlash(value))
+			if !filepath.IsLocal(dir) {
+				return Token{}, fmt.Errorf("auth token: subdir %q must be a relative path inside the output directory", value)
+			}
+			t.Subdir = dir
+		case "name":
+			t.Name = value
+		default:
+			return Token{}, fmt.Errorf("auth token: unknown option %q (want max-size, subdir or name)", key)
+		}
+	}
+	if t.Name == "" {
+		sum := sha256.Sum256([]byte(t.Secret))
+		t.Name = fmt.Sprintf("%x", sum[:4])
+	}
+	return t, nil
+}
+
+// LoadFile reads tokens from path, one Parse spec per line. Blank lines
+// and lines starting with # are skipped. Keeping tokens in a file keeps
+// them out of process listings.
+func LoadFile(path string) ([]Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth tokens: %w", err)
+	}
+	defer f.Close()
+	var tokens []Token
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t, err := Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read auth tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// Set is the tokens a receiver accepts. A nil *Set requires no token.
+type Set struct {
+	tokens []Token
+}
+
+// NewSet returns the set of tokens, or nil if there are none. Two tokens
+// may not share a secret.
+func NewSet(tokens []Token) (*Set, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	for _, t := range tokens {
+		if seen[t.Secret] {
+			return nil, fmt.Errorf("auth token %s given twice", t.Name)
+		}
+		seen[t.Secret] = true
+	}
+	return &Set{tokens: tokens}, nil
+}
+
+// Verify returns the token with the given secret. Every token is compared
+// in constant time, so the time taken reveals nothing of the secrets.
+func (s *Set) Verify(secret string) (*Token, error) {
+	var found *Token
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(s.tokens[i].Secret), []byte(secret)) == 1 {
+			found = &s.tokens[i]
+		}
+	}
+	if found == nil {
+		return nil, ErrUnauthorized
+	}
+	return found, nil
+}
+
+// Admit checks a transfer of size bytes, to be written at dest, against the
+// token's limits. For a token confined to a subdirectory of outputDir, the
+// destination returned is moved there, keeping its base name, unless it
+// already lies inside it, as for a resumed session.
+func (t *Token) Admit(size int64, dest, outputDir string) (string, error) {
+	if t.MaxSize > 0 && size > t.MaxSize {
+		return "", fmt.Errorf("%w: %s is larger than token %s's limit of %s",
+			ErrLimit, utils.HumanBytes(size), t.Name, utils.HumanBytes(t.MaxSize))
+	}
+	if t.Subdir == "" {
+		return dest, nil
+	}
+	root := filepath.Join(outputDir, t.Subdir)
+	if rel, err := filepath.Rel(root, dest); err == nil && filepath.IsLocal(rel) {
+		return dest, nil
+	}
+	return filepath.Join(root, filepath.Base(dest)), nil
+}
@@ -0,0 +1,74 @@
+package authtoken
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tok, err := Parse("s3cret, max-size=10MB, subdir=ci/builds, name=ci")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := Token{Name: "ci", Secret: "s3cret", MaxSize: 10 << 20, Subdir: filepath.FromSlash("ci/builds")}
+	if tok != want {
+		t.Fatalf("Parse = %+v, want %+v", tok, want)
+	}
+	if tok, err := Parse("plain"); err != nil || tok.Name == "" || tok.Name == "plain" {
+		t.Fatalf("Parse(plain) = %+v, %v", tok, err)
+	}
+	for _, spec := range []string{"", ",subdir=x", "s,subdir=../up", "s,subdir=/abs", "s,max-size=lots", "s,colour=red", "s,novalue"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	data := "# deploy tokens\nalpha,name=a\n\nbeta,max-size=1GB\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadFile(path)
+	if err != nil || len(tokens) != 2 || tokens[1].MaxSize != 1<<30 {
+		t.Fatalf("LoadFile = %+v, %v", tokens, err)
+	}
+}
+
+func TestVerifyAndAdmit(t *testing.T) {
+	set, err := NewSet([]Token{{Name: "open", Secret: "a"}, {Name: "ci", Secret: "b", MaxSize: 100, Subdir: "ci"}})
+	if err != nil {
+		t.Fatalf("NewSet: %v", err)
+	}
+	if _, err := set.Verify("c"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Verify(unknown) = %v", err)
+	}
+	ci, err := set.Verify("b")
+	if err != nil || ci.Name != "ci" {
+		t.Fatalf("Verify = %+v, %v", ci, err)
+	}
+
+	out := filepath.Join("data", "out")
+	if _, err := ci.Admit(101, filepath.Join(out, "f"), out); !errors.Is(err, ErrLimit) {
+		t.Fatalf("Admit over the limit: %v", err)
+	}
+	dest, err := ci.Admit(100, filepath.Join(out, "report.pdf"), out)
+	if want := filepath.Join(out, "ci", "report.pdf"); err != nil || dest != want {
+		t.Fatalf("Admit = %q, %v; want %q", dest, err, want)
+	}
+	// A destination already inside the subdirectory stays put.
+	kept := filepath.Join(out, "ci", "report.1.pdf")
+	if dest, err := ci.Admit(1, kept, out); err != nil || dest != kept {
+		t.Fatalf("Admit(resumed) = %q, %v", dest, err)
+	}
+
+	if _, err := NewSet([]Token{{Name: "x", Secret: "a"}, {Name: "y", Secret: "a"}}); err == nil {
+		t.Fatal("NewSet accepted a repeated secret")
+	}
+	if s, err := NewSet(nil); s != nil || err != nil {
+		t.Fatalf("NewSet(nil) = %v, %v", s, err)
+	}
+}
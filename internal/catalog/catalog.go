@@ -0,0 +1,142 @@
+// Package catalog keeps a content-addressed index of the files a receiver
+// has assembled, so they can be served back out to other receivers.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrNotFound is returned by Lookup when no usable copy of a file is held.
+var ErrNotFound = errors.New("file not in catalog")
+
+// Entry records one assembled file.
+type Entry struct {
+	File    models.FileMetadata `json:"file"`
+	Path    string              `json:"path"`
+	AddedAt time.Time           `json:"added_at"`
+}
+
+// Catalog maps file hashes to assembled copies on disk. It is safe for
+// concurrent use and persisted as JSON after every change.
+type Catalog struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]*Entry // keyed by FileMetadata.Hash
+}
+
+// Open loads the catalog stored at path, or starts an empty one if the file
+// does not exist yet.
+func Open(path string) (*Catalog, error) {
+	c := &Catalog{path: path, entries: make(map[string]*Entry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read catalog: %w", err)
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode catalog %s: %w", path, err)
+	}
+	for _, e := range entries {
+		c.entries[e.File.Hash] = e
+	}
+	return c, nil
+}
+
+// Add records that the file described by file has been assembled at path.
+// A later copy of the same content replaces the earlier one.
+func (c *Catalog) Add(file models.FileMetadata, path string) error {
+	if file.Hash == "" {
+		return errors.New("catalog: file has no hash")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("catalog: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[file.Hash] = &Entry{File: file, Path: abs, AddedAt: time.Now()}
+	return c.saveLocked()
+}
+
+// Lookup returns the entry for hash if its file is still on disk with the
+// recorded size. Entries whose file is gone or changed are dropped.
+func (c *Catalog) Lookup(hash string) (*Entry, error) {
+	c.mu.RLock()
+	e, ok := c.entries[hash]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if fi, err := os.Stat(e.Path); err != nil || !fi.Mode().IsRegular() || fi.Size() != e.File.Size {
+		c.mu.Lock()
+		delete(c.entries, hash)
+		err := c.saveLocked()
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s no longer matches", ErrNotFound, e.Path)
+	}
+	out := *e
+	return &out, nil
+}
+
+// List returns all entries ordered by file name.
+func (c *Catalog) List() []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]Entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].File.Name < out[j].File.Name })
+	return out
+}
+
+// Hashes returns the hashes of all held files.
+func (c *Catalog) Hashes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]string, 0, len(c.entries))
+	for h := range c.entries {
+		out = append(out, h)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// saveLocked must be called with c.mu locked.
+func (c *Catalog) saveLocked() error {
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File.Hash < entries[j].File.Hash })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode catalog: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("create catalog dir: %w", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write catalog: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("write catalog: %w", err)
+	}
+	return nil
+}
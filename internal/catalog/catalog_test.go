@@ -0,0 +1,66 @@
+package catalog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestAddLookupAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.bin")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "catalog.json")
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	meta := models.FileMetadata{Name: "a.bin", Size: 5, Hash: "h1"}
+	if err := c.Add(meta, file); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	c, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	e, err := c.Lookup("h1")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if e.Path != file || e.File.Name != "a.bin" {
+		t.Fatalf("entry = %+v", e)
+	}
+	if _, err := c.Lookup("h2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup unknown: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLookupDropsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.bin")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(filepath.Join(dir, "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(models.FileMetadata{Name: "a.bin", Size: 5, Hash: "h1"}, file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Lookup("h1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if len(c.Hashes()) != 0 {
+		t.Fatalf("stale entry kept: %v", c.Hashes())
+	}
+}
@@ -0,0 +1,146 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// AdaptiveSizer adjusts the chunk size between chunks from live telemetry,
+// without any external service. The size doubles after a run of chunks sent
+// at a steady rate, since larger chunks cut per-chunk overhead, and halves
+// when data had to be retransmitted, packets were lost or the rate dropped
+// sharply, so less is at stake per chunk on a poor link. It is safe for
+// concurrent use.
+type AdaptiveSizer struct {
+	mu        sync.Mutex
+	min, max  int64
+	size      int64
+	telemetry *telemetry.TelemetryCollector
+
+	rate       float64 // moving average of the per-chunk send rate, bytes/s
+	steady     int     // consecutive chunks sent at or above the average rate
+	retransmit uint64  // retransmitted bytes at the previous observation
+	loss       float64 // windowed loss rate at the previous observation
+}
+
+// Adaptive sizing tuning.
+const (
+	// adaptiveSteadyChunks is how many chunks must keep up the average rate
+	// before the size grows.
+	adaptiveSteadyChunks = 3
+	// adaptiveRateWeight is the weight of the newest chunk in the moving
+	// average rate.
+	adaptiveRateWeight = 0.3
+	// adaptiveSlowFactor is the fraction of the average rate below which a
+	// chunk counts as a sharp drop.
+	adaptiveSlowFactor = 0.5
+)
+
+// NewAdaptiveSizer returns a sizer starting at initial, kept within the
+// config's [MinChunkSize, MaxChunkSize]. t may be nil, in which case only
+// send rates are taken into account.
+func (c *ChunkerConfig) NewAdaptiveSizer(initial int64, t *telemetry.TelemetryCollector) *AdaptiveSizer {
+	c.normalize()
+	a := &AdaptiveSizer{min: c.MinChunkSize, max: c.MaxChunkSize, size: c.clampSize(initial), telemetry: t}
+	if t != nil {
+		a.retransmit = t.Counters().RetransmitBytes
+		a.loss = t.WindowLossRate()
+	}
+	return a
+}
+
+// Size returns the size for the next chunk.
+func (a *AdaptiveSizer) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// Observe records that a chunk of n bytes took d to send and returns the
+// size for the next chunk.
+func (a *AdaptiveSizer) Observe(n int64, d time.Duration) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	degraded := false
+	if a.telemetry != nil {
+		retransmit, loss := a.telemetry.Counters().RetransmitBytes, a.telemetry.WindowLossRate()
+		degraded = retransmit > a.retransmit || loss > a.loss
+		a.retransmit, a.loss = retransmit, loss
+	}
+
+	if n > 0 && d > 0 {
+		rate := float64(n) / d.Seconds()
+		switch {
+		case a.rate == 0:
+			a.rate = rate
+		case rate < a.rate*adaptiveSlowFactor:
+			degraded = true
+		case rate >= a.rate:
+			a.steady++
+		default:
+			a.steady = 0
+		}
+		a.rate += adaptiveRateWeight * (rate - a.rate)
+	}
+
+	switch {
+	case degraded:
+		a.size = max(a.size/2, a.min)
+		a.steady = 0
+	case a.steady >= adaptiveSteadyChunks:
+		a.size = min(a.size*2, a.max)
+		a.steady = 0
+	}
+	return a.size
+}
+
+// AdaptiveChunks cuts the first size bytes of a source into consecutive
+// chunks whose sizes are chosen by a sizer as each chunk is taken, so a
+// sender can change the chunk size mid-transfer.
+type AdaptiveChunks struct {
+	src    io.ReaderAt
+	size   int64
+	sizer  *AdaptiveSizer
+	offset int64
+	index  int
+	now    time.Time
+	buf    []byte // reused to hash each chunk as it is cut
+}
+
+// NewAdaptiveChunks returns chunks over the first size bytes of src sized by
+// sizer.
+func NewAdaptiveChunks(src io.ReaderAt, size int64, sizer *AdaptiveSizer) *AdaptiveChunks {
+	return &AdaptiveChunks{src: src, size: size, sizer: sizer, now: time.Now()}
+}
+
+// Next reads and hashes the next chunk at the sizer's current size and
+// returns its metadata, or io.EOF once the source is exhausted.
+func (a *AdaptiveChunks) Next() (*models.ChunkMetadata, error) {
+	if a.offset >= a.size {
+		return nil, io.EOF
+	}
+	n := min(a.sizer.Size(), a.size-a.offset)
+	h := sha256.New()
+	if a.buf == nil {
+		a.buf = make([]byte, cdcReadSize)
+	}
+	copied, err := io.CopyBuffer(h, io.NewSectionReader(a.src, a.offset, n), a.buf)
+	if err != nil {
+		return nil, err
+	}
+	if copied < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var sum [32]byte
+	h.Sum(sum[:0])
+	meta := newChunkMeta(a.index, a.offset, n, sum, a.now)
+	a.index++
+	a.offset += n
+	return meta, nil
+}
@@ -0,0 +1,85 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+)
+
+func TestAdaptiveSizer(t *testing.T) {
+	cfg := ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 8192, DefaultChunkSize: 2048}
+	tel := telemetry.NewTelemetryCollector()
+	a := cfg.NewAdaptiveSizer(2048, tel)
+
+	// A steady rate grows the size until the maximum.
+	for i := 0; i < 12; i++ {
+		a.Observe(a.Size(), time.Duration(a.Size())*time.Microsecond)
+	}
+	if got := a.Size(); got != 8192 {
+		t.Fatalf("size after steady sends = %d, want 8192", got)
+	}
+
+	// A retransmit halves it.
+	tel.RecordRetransmitBytes(100)
+	if got := a.Observe(8192, 8192*time.Microsecond); got != 4096 {
+		t.Fatalf("size after retransmit = %d, want 4096", got)
+	}
+
+	// So does a sharp drop in rate, down to the minimum.
+	for i := 0; i < 5; i++ {
+		a.Observe(a.Size(), time.Duration(a.Size())*time.Second)
+	}
+	if got := a.Size(); got != 1024 {
+		t.Fatalf("size after slow sends = %d, want 1024", got)
+	}
+}
+
+func TestAdaptiveChunksCoverSource(t *testing.T) {
+	data := make([]byte, 50*1024+17)
+	rand.New(rand.NewSource(7)).Read(data)
+	cfg := ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 8192}
+	a := cfg.NewAdaptiveSizer(1024, nil)
+	chunks := NewAdaptiveChunks(bytes.NewReader(data), int64(len(data)), a)
+
+	var offset int64
+	sizes := map[int64]bool{}
+	for i := 0; ; i++ {
+		meta, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if meta.Offset != offset || meta.ID != fmt.Sprint(i) {
+			t.Fatalf("chunk %d: id %s offset %d, want offset %d", i, meta.ID, meta.Offset, offset)
+		}
+		sum := sha256.Sum256(data[meta.Offset : meta.Offset+meta.Size])
+		if meta.SHA256 != fmt.Sprintf("%x", sum) {
+			t.Fatalf("chunk %d: hash mismatch", i)
+		}
+		sizes[meta.Size] = true
+		offset += meta.Size
+		a.Observe(meta.Size, time.Millisecond)
+	}
+	if offset != int64(len(data)) {
+		t.Fatalf("chunks cover %d bytes, want %d", offset, len(data))
+	}
+	if len(sizes) < 3 {
+		t.Fatalf("chunk sizes %v did not change mid-stream", sizes)
+	}
+}
+
+func TestAdaptiveChunksShortSource(t *testing.T) {
+	cfg := ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 8192}
+	chunks := NewAdaptiveChunks(bytes.NewReader(make([]byte, 100)), 2000, cfg.NewAdaptiveSizer(1024, nil))
+	if _, err := chunks.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Next on short source: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,212 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"io"
+	"iter"
+	"math/bits"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// cdcReadSize is how much of the source is scanned per read.
+const cdcReadSize = 1 << 20
+
+// gear maps each byte to a random 64-bit value for the rolling hash. It is
+// generated from a fixed seed and must never change: chunk boundaries, and
+// with them dedup against earlier sessions, depend on it.
+var gear = func() [256]uint64 {
+	var t [256]uint64
+	x := uint64(0x7472616b73686966) // "trakshif"
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// cdcChunker places content-defined boundaries with FastCDC: a gear rolling
+// hash is tested against a stricter mask before the average size and a
+// looser one after it, which keeps chunk sizes close to the average.
+type cdcChunker struct {
+	cfg ChunkerConfig
+}
+
+// cdcParams are the size bounds and masks for one average chunk size.
+type cdcParams struct {
+	min, avg, max int64
+	maskS, maskL  uint64
+}
+
+// newCDCParams derives FastCDC parameters for an average chunk size of avg.
+// Chunks are at least avg/4 and at most 4*avg bytes, capped at maxSize.
+func newCDCParams(avg, maxSize int64) cdcParams {
+	b := bits.Len64(uint64(avg)) - 1 // log2(avg)
+	// The gear hash shifts left per byte, so its high bits depend on the
+	// most input; the masks select from the top.
+	mask := func(n int) uint64 {
+		n = min(max(n, 1), 63)
+		return ((uint64(1) << n) - 1) << (64 - n)
+	}
+	return cdcParams{
+		min:   avg / 4,
+		avg:   avg,
+		max:   min(avg*4, max(maxSize, avg)),
+		maskS: mask(b + 2),
+		maskL: mask(b - 2),
+	}
+}
+
+// ChunkFile splits the file at path into content-defined chunks averaging
+// chunkSize bytes.
+func (c *cdcChunker) ChunkFile(path string, chunkSize int64) ([]*models.ChunkMetadata, error) {
+	return chunkFile(c, path, chunkSize)
+}
+
+// ChunkReaderAt splits the first size bytes of r into content-defined
+// chunks averaging chunkSize bytes. The source is scanned once, in order,
+// hashing each chunk as its boundary is found.
+func (c *cdcChunker) ChunkReaderAt(r io.ReaderAt, size int64, chunkSize int64) ([]*models.ChunkMetadata, error) {
+	cut := &cdcCutter{p: newCDCParams(c.cfg.clampSize(chunkSize), c.cfg.MaxChunkSize)}
+	reader := io.NewSectionReader(r, 0, size)
+
+	var (
+		result []*models.ChunkMetadata
+		now    = time.Now()
+		h      = sha256.New()
+		start  int64 // offset of the current chunk
+		sum    [32]byte
+	)
+	emit := func() {
+		h.Sum(sum[:0])
+		result = append(result, newChunkMeta(len(result), start, cut.n, sum, now))
+		start += cut.n
+		cut.reset()
+		h.Reset()
+	}
+
+	buf := make([]byte, cdcReadSize)
+	for {
+		m, readErr := io.ReadFull(reader, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return nil, readErr
+		}
+		seg := buf[:m]
+		for len(seg) > 0 {
+			k := cut.scan(seg)
+			if k < 0 {
+				h.Write(seg)
+				break
+			}
+			h.Write(seg[:k])
+			emit()
+			seg = seg[k:]
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if cut.n > 0 {
+		emit()
+	}
+	return result, nil
+}
+
+// ChunkStream reads r to the end, yielding content-defined chunks averaging
+// chunkSize bytes as their boundaries are found. Each chunk's Data is only
+// valid until the next one is yielded.
+func (c *cdcChunker) ChunkStream(r io.Reader, chunkSize int64) iter.Seq2[StreamChunk, error] {
+	return func(yield func(StreamChunk, error) bool) {
+		cut := &cdcCutter{p: newCDCParams(c.cfg.clampSize(chunkSize), c.cfg.MaxChunkSize)}
+		var (
+			now    = time.Now()
+			index  int
+			offset int64
+			data   = make([]byte, 0, cut.p.max)
+			buf    = make([]byte, cdcReadSize)
+		)
+		emit := func() bool {
+			sum := sha256.Sum256(data)
+			sc := StreamChunk{Meta: newChunkMeta(index, offset, int64(len(data)), sum, now), Data: data}
+			index++
+			offset += int64(len(data))
+			data = data[:0]
+			cut.reset()
+			return yield(sc, nil)
+		}
+		for {
+			m, readErr := io.ReadFull(r, buf)
+			if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+				yield(StreamChunk{}, readErr)
+				return
+			}
+			seg := buf[:m]
+			for len(seg) > 0 {
+				k := cut.scan(seg)
+				if k < 0 {
+					data = append(data, seg...)
+					break
+				}
+				data = append(data, seg[:k]...)
+				if !emit() {
+					return
+				}
+				seg = seg[k:]
+			}
+			if readErr != nil {
+				break
+			}
+		}
+		if len(data) > 0 {
+			emit()
+		}
+	}
+}
+
+// cdcCutter finds FastCDC boundaries in data fed to it in pieces.
+type cdcCutter struct {
+	p  cdcParams
+	n  int64  // bytes in the current chunk
+	fp uint64 // rolling hash of the current chunk
+}
+
+// scan consumes seg up to and including the next boundary and returns the
+// number of bytes consumed, or -1 if seg holds no boundary and was consumed
+// entirely.
+func (c *cdcCutter) scan(seg []byte) int {
+	for i, b := range seg {
+		c.n++
+		if c.n <= c.p.min {
+			continue
+		}
+		c.fp = c.fp<<1 + gear[b]
+		var cut bool
+		switch {
+		case c.n >= c.p.max:
+			cut = true
+		case c.n < c.p.avg:
+			cut = c.fp&c.p.maskS == 0
+		default:
+			cut = c.fp&c.p.maskL == 0
+		}
+		if cut {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// reset starts a new chunk after a boundary.
+func (c *cdcCutter) reset() {
+	c.n, c.fp = 0, 0
+}
+
+// CalculateChunkHash computes the SHA-256 hash for a given chunk.
+func (c *cdcChunker) CalculateChunkHash(chunk []byte) [32]byte {
+	return sha256.Sum256(chunk)
+}
@@ -0,0 +1,70 @@
+package chunker
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func cdcChunks(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	cfg := ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 64 * 1024, DefaultChunkSize: 4096, Algorithm: AlgorithmFastCDC}
+	chunks, err := NewChunker(cfg).ChunkReaderAt(bytes.NewReader(data), int64(len(data)), 4096)
+	if err != nil {
+		t.Fatalf("ChunkReaderAt: %v", err)
+	}
+	var off int64
+	hashes := make(map[string]bool)
+	for i, c := range chunks {
+		if c.Offset != off {
+			t.Fatalf("chunk %d at offset %d, want %d", i, c.Offset, off)
+		}
+		if c.Size > 4*4096 || (c.Size < 1024 && i != len(chunks)-1) {
+			t.Fatalf("chunk %d size %d outside bounds", i, c.Size)
+		}
+		if sum := NewChunker(ChunkerConfig{}).CalculateChunkHash(data[c.Offset : c.Offset+c.Size]); c.SHA256 != fmt.Sprintf("%x", sum[:]) {
+			t.Fatalf("chunk %d hash mismatch", i)
+		}
+		off += c.Size
+		hashes[c.SHA256] = true
+	}
+	if off != int64(len(data)) {
+		t.Fatalf("chunks cover %d bytes, want %d", off, len(data))
+	}
+	return hashes
+}
+
+func TestFastCDCBoundariesSurviveInsertion(t *testing.T) {
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	before := cdcChunks(t, data)
+	if n := len(before); n < 64 || n > 256 {
+		t.Fatalf("got %d chunks for 512KiB at 4KiB average", n)
+	}
+
+	// Insert a few bytes near the start: fixed-size chunking would shift
+	// every later chunk, content-defined chunking only the one around it.
+	edited := append(append(append([]byte{}, data[:1000]...), "inserted"...), data[1000:]...)
+	after := cdcChunks(t, edited)
+	shared := 0
+	for h := range after {
+		if before[h] {
+			shared++
+		}
+	}
+	if shared < len(before)-3 {
+		t.Fatalf("only %d of %d chunks unchanged after a small insertion", shared, len(before))
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for in, want := range map[string]Algorithm{"": AlgorithmFixed, "fixed": AlgorithmFixed, "fastcdc": AlgorithmFastCDC} {
+		if got, err := ParseAlgorithm(in); err != nil || got != want {
+			t.Fatalf("ParseAlgorithm(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseAlgorithm("rabin"); err == nil {
+		t.Fatalf("expected error for unknown algorithm")
+	}
+}
@@ -2,18 +2,15 @@ package chunker
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
+	"iter"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
+	"github.com/deb2000-sudo/trackshift/internal/pipeline"
 	"github.com/deb2000-sudo/trackshift/internal/telemetry"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
@@ -27,6 +24,43 @@ type ChunkerConfig struct {
 	// Telemetry provides live network stats used by the AI optimizer.
 	// It is optional; if nil, the AI service will fall back to defaults.
 	Telemetry *telemetry.TelemetryCollector
+
+	// Optimizer chooses chunk sizes in ChooseChunkSizeAI. Nil means
+	// HeuristicOptimizer, which needs no network access.
+	Optimizer ChunkSizeOptimizer
+
+	// HashWorkers is the number of chunks hashed concurrently, each read
+	// with ReadAt at its own offset. The result is the same as with one
+	// worker; 0 or 1 reads and hashes the source sequentially. It applies
+	// to fixed-size chunking only.
+	HashWorkers int
+
+	// Algorithm selects how chunk boundaries are placed; empty means
+	// AlgorithmFixed.
+	Algorithm Algorithm
+}
+
+// Algorithm selects how a Chunker places chunk boundaries.
+type Algorithm string
+
+const (
+	// AlgorithmFixed cuts chunks of exactly the chunk size.
+	AlgorithmFixed Algorithm = "fixed"
+	// AlgorithmFastCDC cuts content-defined chunks averaging the chunk
+	// size, so an insertion or deletion only changes the chunks around it.
+	AlgorithmFastCDC Algorithm = "fastcdc"
+)
+
+// ParseAlgorithm validates a chunking algorithm name.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case "", AlgorithmFixed:
+		return AlgorithmFixed, nil
+	case AlgorithmFastCDC:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown chunking algorithm %q (want fixed or fastcdc)", s)
+	}
 }
 
 // normalize ensures sane defaults for the config.
@@ -73,225 +107,40 @@ func (c *ChunkerConfig) ChooseChunkSizeStatic(override int64) int64 {
 	return c.clampSize(override)
 }
 
-// ChooseChunkSizeAI applies a simple heuristic "AI" policy based on file size.
-// This is where a learned model is plugged in.
-// It first tries a Hugging Face model to predict an optimal chunk size (in MB)
-// based on file metadata. If that call fails for any reason, it falls back
-// to a simple heuristic:
-// - small files -> smaller chunks (better feedback)
-// - huge files  -> larger chunks (reduce overhead)
+// ChooseChunkSizeAI asks the configured Optimizer (HeuristicOptimizer if
+// none) for a chunk size for file, clamped to [MinChunkSize, MaxChunkSize].
+// If the optimizer fails or has no answer in time, the heuristic is used.
 func (c *ChunkerConfig) ChooseChunkSizeAI(file models.FileMetadata) int64 {
-	const (
-		MB = 1024 * 1024
-		GB = 1024 * 1024 * 1024
-	)
-
-	// First, try local ML optimizer service (XGBoost/LightGBM style).
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	if predicted, err := predictChunkSizeWithService(ctx, file, c.Telemetry); err == nil && predicted > 0 {
-		return c.clampSize(predicted)
-	}
-
-	// Next, try Hugging Face.
-	if predicted, err := predictChunkSizeWithHF(ctx, file); err == nil && predicted > 0 {
-		return c.clampSize(predicted)
-	}
-
-	// Fallback: original heuristic based on file size.
-	size := file.Size
-	var chosen int64
-
-	switch {
-	case size <= 100*MB:
-		// small file: smaller chunks (8MB) to get quick feedback and progress
-		chosen = 8 * MB
-	case size <= 1*GB:
-		// medium: balance between overhead and responsiveness
-		chosen = 32 * MB
-	case size <= 10*GB:
-		// large files: moderately large chunks
-		chosen = 64 * MB
-	default:
-		// very large: larger chunks to reduce number of round-trips
-		chosen = 128 * MB
-	}
-
-	return c.clampSize(chosen)
-}
-
-// hfRequest represents the JSON payload sent to Hugging Face Inference API.
-type hfRequest struct {
-	Inputs     string                 `json:"inputs"`
-	Parameters map[string]interface{} `json:"parameters,omitempty"`
-}
-
-// hfResponseItem represents a single item returned by text-generation models.
-type hfResponseItem struct {
-	GeneratedText string `json:"generated_text"`
-}
-
-// predictChunkSizeWithHF calls a Hugging Face text-to-text model (google/flan-t5-small)
-// to predict an optimal chunk size in bytes, based on file metadata.
-// It expects an environment variable HF_API_TOKEN to be set with the user's key.
-func predictChunkSizeWithHF(ctx context.Context, file models.FileMetadata) (int64, error) {
-	apiToken := os.Getenv("HF_API_TOKEN")
-	if apiToken == "" {
-		// If the token is not set, signal the caller to fall back.
-		return 0, fmt.Errorf("HF_API_TOKEN not set")
-	}
-
-	prompt := "You are a chunk size optimizer for file transfer.\n\n" +
-		"Given this file:\n" +
-		"- name: " + file.Name + "\n" +
-		"- size_bytes: " + strconv.FormatInt(file.Size, 10) + "\n" +
-		"- mime_type: " + file.MimeType + "\n\n" +
-		"Suggest an optimal chunk size in megabytes as a plain integer (no units, no extra text)."
-
-	reqBody := hfRequest{
-		Inputs: prompt,
-		Parameters: map[string]interface{}{
-			"max_new_tokens": 8,
-		},
-	}
-
-	bodyBytes, err := json.Marshal(reqBody)
-	if err != nil {
-		return 0, err
-	}
-
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		"https://api-inference.huggingface.co/models/google/flan-t5-small",
-		bytes.NewReader(bodyBytes),
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	httpReq.Header.Set("Authorization", "Bearer "+apiToken)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("huggingface returned status %s", resp.Status)
-	}
-
-	var hfResp []hfResponseItem
-	if err := json.NewDecoder(resp.Body).Decode(&hfResp); err != nil {
-		return 0, err
-	}
-	if len(hfResp) == 0 {
-		return 0, fmt.Errorf("empty response from huggingface")
-	}
-
-	text := strings.TrimSpace(hfResp[0].GeneratedText)
-
-	// Extract leading digits to get the integer megabyte value.
-	var digits strings.Builder
-	for _, r := range text {
-		if r >= '0' && r <= '9' {
-			digits.WriteRune(r)
-		} else if digits.Len() > 0 {
-			break
+	if c.Optimizer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), optimizerTimeout)
+		defer cancel()
+		if predicted, err := c.Optimizer.ChunkSize(ctx, file, c.Telemetry); err == nil && predicted > 0 {
+			return c.clampSize(predicted)
 		}
 	}
-
-	if digits.Len() == 0 {
-		return 0, fmt.Errorf("no integer found in model output: %q", text)
-	}
-
-	mb, err := strconv.ParseInt(digits.String(), 10, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	const MB = 1024 * 1024
-	return mb * MB, nil
-}
-
-// predictChunkSizeWithService calls the local optimizer service (implemented with
-// XGBoost/LightGBM in Python) to obtain an optimal chunk size in bytes.
-// The service is expected to listen on http://localhost:8000/predict-chunk-size.
-func predictChunkSizeWithService(ctx context.Context, file models.FileMetadata, t *telemetry.TelemetryCollector) (int64, error) {
-	type requestPayload struct {
-		SizeBytes              int64   `json:"size_bytes"`
-		MimeType               string  `json:"mime_type"`
-		EstimatedBandwidthMbps float64 `json:"estimated_bandwidth_mbps"`
-		LatencyMs              float64 `json:"latency_ms"`
-	}
-
-	type responsePayload struct {
-		ChunkSizeMB float64 `json:"chunk_size_mb"`
-	}
-
-	reqBody := requestPayload{
-		SizeBytes: file.Size,
-		MimeType:  file.MimeType,
-	}
-
-	// Use telemetry metrics when available; otherwise leave as zero and let the
-	// Python service apply its own defaults.
-	if t != nil {
-		reqBody.EstimatedBandwidthMbps = t.BandwidthMbps()
-		reqBody.LatencyMs = t.LatencyMs()
-	}
-
-	bodyBytes, err := json.Marshal(reqBody)
-	if err != nil {
-		return 0, err
-	}
-
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		"http://localhost:8000/predict-chunk-size",
-		bytes.NewReader(bodyBytes),
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("optimizer service returned status %s", resp.Status)
-	}
-
-	var parsed responsePayload
-	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
-		return 0, err
-	}
-
-	if parsed.ChunkSizeMB <= 0 {
-		return 0, fmt.Errorf("invalid chunk_size_mb from service: %f", parsed.ChunkSizeMB)
-	}
-
-	const MB = 1024 * 1024
-	return int64(parsed.ChunkSizeMB * MB), nil
+	size, _ := HeuristicOptimizer{}.ChunkSize(context.Background(), file, c.Telemetry)
+	return c.clampSize(size)
 }
 
 // Chunker defines the interface for splitting files into chunks.
 type Chunker interface {
 	ChunkFile(path string, chunkSize int64) ([]*models.ChunkMetadata, error)
+	ChunkReaderAt(r io.ReaderAt, size int64, chunkSize int64) ([]*models.ChunkMetadata, error)
+	// ChunkStream splits a source of unknown length, such as stdin or the
+	// output of another process, yielding each chunk with its data as soon
+	// as it is complete. Iteration stops after the first error.
+	ChunkStream(r io.Reader, chunkSize int64) iter.Seq2[StreamChunk, error]
 	CalculateChunkHash(chunk []byte) [32]byte
 }
 
+// StreamChunk is a chunk produced by ChunkStream together with its data.
+type StreamChunk struct {
+	Meta *models.ChunkMetadata
+	// Data is the chunk's content. It is reused for later chunks, so it is
+	// only valid until the iteration continues.
+	Data []byte
+}
+
 type fileChunker struct {
 	cfg ChunkerConfig
 }
@@ -299,16 +148,20 @@ type fileChunker struct {
 // NewChunker creates a new Chunker with the given config.
 func NewChunker(cfg ChunkerConfig) Chunker {
 	cfg.normalize()
+	if cfg.Algorithm == AlgorithmFastCDC {
+		return &cdcChunker{cfg: cfg}
+	}
 	return &fileChunker{cfg: cfg}
 }
 
 // ChunkFile splits the file at path into chunks of up to chunkSize bytes.
 // If chunkSize is <= 0, the DefaultChunkSize from config is used.
 func (c *fileChunker) ChunkFile(path string, chunkSize int64) ([]*models.ChunkMetadata, error) {
-	// Keep legacy behavior by clamping the provided size.
-	c.cfg.normalize()
-	chunkSize = c.cfg.clampSize(chunkSize)
+	return chunkFile(c, path, chunkSize)
+}
 
+// chunkFile runs c.ChunkReaderAt over the whole file at path.
+func chunkFile(c Chunker, path string, chunkSize int64) ([]*models.ChunkMetadata, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -319,8 +172,22 @@ func (c *fileChunker) ChunkFile(path string, chunkSize int64) ([]*models.ChunkMe
 	if err != nil {
 		return nil, err
 	}
+	return c.ChunkReaderAt(f, info.Size(), chunkSize)
+}
+
+// ChunkReaderAt splits the first size bytes of r into chunks of up to
+// chunkSize bytes. It is used for sources that are not a single file, such
+// as the concatenated contents of a directory.
+func (c *fileChunker) ChunkReaderAt(r io.ReaderAt, size int64, chunkSize int64) ([]*models.ChunkMetadata, error) {
+	// Keep legacy behavior by clamping the provided size.
+	c.cfg.normalize()
+	chunkSize = c.cfg.clampSize(chunkSize)
+
+	if c.cfg.HashWorkers > 1 {
+		return c.chunkConcurrent(r, size, chunkSize)
+	}
 
-	reader := bufio.NewReader(f)
+	reader := bufio.NewReader(io.NewSectionReader(r, 0, size))
 	var (
 		offset int64
 		index  int
@@ -341,26 +208,12 @@ func (c *fileChunker) ChunkFile(path string, chunkSize int64) ([]*models.ChunkMe
 
 		chunk := buf[:n]
 		hash := c.CalculateChunkHash(chunk)
-
-		meta := &models.ChunkMetadata{
-			ID:         fmt.Sprintf("%d", index),
-			Size:       int64(n),
-			Offset:     offset,
-			SHA256:     fmt.Sprintf("%x", hash[:]),
-			IsParity:   false,
-			Status:     models.ChunkStatusPending,
-			CreatedAt:  now,
-			UpdatedAt:  now,
-			SessionID:  "",
-			Priority:   0,
-			RetryCount: 0,
-		}
-		result = append(result, meta)
+		result = append(result, newChunkMeta(index, offset, int64(n), hash, now))
 
 		offset += int64(n)
 		index++
 
-		if offset >= info.Size() {
+		if offset >= size {
 			break
 		}
 
@@ -372,6 +225,88 @@ func (c *fileChunker) ChunkFile(path string, chunkSize int64) ([]*models.ChunkMe
 	return result, nil
 }
 
+// ChunkStream reads r to the end, yielding chunks of chunkSize bytes (the
+// last may be shorter). Only one chunk is held in memory at a time.
+func (c *fileChunker) ChunkStream(r io.Reader, chunkSize int64) iter.Seq2[StreamChunk, error] {
+	chunkSize = c.cfg.clampSize(chunkSize)
+	return func(yield func(StreamChunk, error) bool) {
+		buf := make([]byte, chunkSize)
+		now := time.Now()
+		var offset int64
+		for index := 0; ; index++ {
+			n, err := io.ReadFull(r, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				yield(StreamChunk{}, err)
+				return
+			}
+			if n > 0 {
+				sc := StreamChunk{Meta: newChunkMeta(index, offset, int64(n), c.CalculateChunkHash(buf[:n]), now), Data: buf[:n]}
+				if !yield(sc, nil) {
+					return
+				}
+				offset += int64(n)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// chunkConcurrent is ChunkReaderAt with cfg.HashWorkers chunks read and
+// hashed at once. Each worker streams its chunk through the hash, so memory
+// stays bounded however large the chunks are.
+func (c *fileChunker) chunkConcurrent(r io.ReaderAt, size int64, chunkSize int64) ([]*models.ChunkMetadata, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	n := int((size + chunkSize - 1) / chunkSize)
+	now := time.Now()
+	hashes := pipeline.Start(n, c.cfg.HashWorkers, func(i int) ([32]byte, error) {
+		var sum [32]byte
+		off := int64(i) * chunkSize
+		want := min(chunkSize, size-off)
+		h := sha256.New()
+		got, err := io.Copy(h, io.NewSectionReader(r, off, want))
+		if err == nil && got < want {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return sum, fmt.Errorf("hash chunk %d: %w", i, err)
+		}
+		h.Sum(sum[:0])
+		return sum, nil
+	})
+	defer hashes.Stop()
+
+	result := make([]*models.ChunkMetadata, 0, n)
+	for i := 0; i < n; i++ {
+		hash, err := hashes.Next(i)
+		if err != nil {
+			return nil, err
+		}
+		off := int64(i) * chunkSize
+		result = append(result, newChunkMeta(i, off, min(chunkSize, size-off), hash, now))
+	}
+	return result, nil
+}
+
+func newChunkMeta(index int, offset, size int64, hash [32]byte, now time.Time) *models.ChunkMetadata {
+	return &models.ChunkMetadata{
+		ID:         fmt.Sprintf("%d", index),
+		Size:       size,
+		Offset:     offset,
+		SHA256:     fmt.Sprintf("%x", hash[:]),
+		IsParity:   false,
+		Status:     models.ChunkStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		SessionID:  "",
+		Priority:   0,
+		RetryCount: 0,
+	}
+}
+
 // CalculateChunkHash computes the SHA-256 hash for a given chunk.
 func (c *fileChunker) CalculateChunkHash(chunk []byte) [32]byte {
 	return sha256.Sum256(chunk)
@@ -1,8 +1,13 @@
 package chunker
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
 	"os"
 	"testing"
+	"testing/iotest"
 )
 
 func writeTempFile(t *testing.T, size int64) string {
@@ -54,3 +59,81 @@ func TestChunkFileBasic(t *testing.T) {
 }
 
 
+
+func TestChunkReaderAtConcurrentMatchesSequential(t *testing.T) {
+	data := make([]byte, 10*1024+77)
+	rand.New(rand.NewSource(1)).Read(data)
+	cfg := ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 4096, DefaultChunkSize: 1024}
+
+	want, err := NewChunker(cfg).ChunkReaderAt(bytes.NewReader(data), int64(len(data)), 1024)
+	if err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+	cfg.HashWorkers = 4
+	got, err := NewChunker(cfg).ChunkReaderAt(bytes.NewReader(data), int64(len(data)), 1024)
+	if err != nil {
+		t.Fatalf("concurrent: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.ID != w.ID || g.Offset != w.Offset || g.Size != w.Size || g.SHA256 != w.SHA256 {
+			t.Fatalf("chunk %d = %+v, want %+v", i, g, w)
+		}
+	}
+
+	// A source shorter than the declared size is an error, not a short hash.
+	if _, err := NewChunker(cfg).ChunkReaderAt(bytes.NewReader(data[:5000]), int64(len(data)), 1024); err == nil {
+		t.Fatalf("expected error for truncated source")
+	}
+}
+
+func TestChunkStreamMatchesReaderAt(t *testing.T) {
+	data := make([]byte, 200*1024+5)
+	rand.New(rand.NewSource(4)).Read(data)
+	for _, alg := range []Algorithm{AlgorithmFixed, AlgorithmFastCDC} {
+		c := NewChunker(ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 64 * 1024, DefaultChunkSize: 4096, Algorithm: alg})
+		want, err := c.ChunkReaderAt(bytes.NewReader(data), int64(len(data)), 4096)
+		if err != nil {
+			t.Fatalf("%s: ChunkReaderAt: %v", alg, err)
+		}
+		var i int
+		// A plain io.Reader, so nothing can rely on ReadAt or a known size.
+		for sc, err := range c.ChunkStream(io.MultiReader(bytes.NewReader(data)), 4096) {
+			if err != nil {
+				t.Fatalf("%s: ChunkStream: %v", alg, err)
+			}
+			w := want[i]
+			if sc.Meta.Offset != w.Offset || sc.Meta.Size != w.Size || sc.Meta.SHA256 != w.SHA256 {
+				t.Fatalf("%s: chunk %d = %+v, want %+v", alg, i, sc.Meta, w)
+			}
+			if !bytes.Equal(sc.Data, data[w.Offset:w.Offset+w.Size]) {
+				t.Fatalf("%s: chunk %d data mismatch", alg, i)
+			}
+			i++
+		}
+		if i != len(want) {
+			t.Fatalf("%s: streamed %d chunks, want %d", alg, i, len(want))
+		}
+	}
+}
+
+func TestChunkStreamReadError(t *testing.T) {
+	boom := errors.New("boom")
+	r := io.MultiReader(bytes.NewReader(make([]byte, 1500)), iotest.ErrReader(boom))
+	c := NewChunker(ChunkerConfig{MinChunkSize: 1024, MaxChunkSize: 4096, DefaultChunkSize: 1024})
+	var chunks int
+	var got error
+	for _, err := range c.ChunkStream(r, 1024) {
+		if err != nil {
+			got = err
+			break
+		}
+		chunks++
+	}
+	if chunks != 1 || !errors.Is(got, boom) {
+		t.Fatalf("got %d chunks and error %v; want 1 chunk then boom", chunks, got)
+	}
+}
@@ -0,0 +1,262 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// optimizerTimeout bounds how long ChooseChunkSizeAI waits for an
+// optimizer before falling back to the heuristic.
+const optimizerTimeout = 2 * time.Second
+
+// ChunkSizeOptimizer proposes a chunk size in bytes for a file. t may be nil.
+// An error, or a size <= 0, means the optimizer has no answer.
+type ChunkSizeOptimizer interface {
+	ChunkSize(ctx context.Context, file models.FileMetadata, t *telemetry.TelemetryCollector) (int64, error)
+}
+
+// Optimizers tries each optimizer in order and returns the first answer.
+type Optimizers []ChunkSizeOptimizer
+
+// ChunkSize implements ChunkSizeOptimizer.
+func (o Optimizers) ChunkSize(ctx context.Context, file models.FileMetadata, t *telemetry.TelemetryCollector) (int64, error) {
+	err := fmt.Errorf("no chunk size optimizer configured")
+	for _, opt := range o {
+		var size int64
+		size, err = opt.ChunkSize(ctx, file, t)
+		if err == nil && size > 0 {
+			return size, nil
+		}
+	}
+	return 0, err
+}
+
+// HeuristicOptimizer picks a chunk size from the file size alone:
+// - small files -> smaller chunks (better feedback)
+// - huge files  -> larger chunks (reduce overhead)
+type HeuristicOptimizer struct{}
+
+// ChunkSize implements ChunkSizeOptimizer.
+func (HeuristicOptimizer) ChunkSize(_ context.Context, file models.FileMetadata, _ *telemetry.TelemetryCollector) (int64, error) {
+	const (
+		MB = 1024 * 1024
+		GB = 1024 * 1024 * 1024
+	)
+	switch size := file.Size; {
+	case size <= 100*MB:
+		// small file: smaller chunks (8MB) to get quick feedback and progress
+		return 8 * MB, nil
+	case size <= 1*GB:
+		// medium: balance between overhead and responsiveness
+		return 32 * MB, nil
+	case size <= 10*GB:
+		// large files: moderately large chunks
+		return 64 * MB, nil
+	default:
+		// very large: larger chunks to reduce number of round-trips
+		return 128 * MB, nil
+	}
+}
+
+// DefaultServiceURL is where ServiceOptimizer expects the optimizer service
+// by default.
+const DefaultServiceURL = "http://localhost:8000/predict-chunk-size"
+
+// ServiceOptimizer asks an optimizer service (the XGBoost/LightGBM model in
+// Python) for a chunk size, passing file metadata and live network stats.
+type ServiceOptimizer struct {
+	// URL of the predict endpoint; empty means DefaultServiceURL.
+	URL string
+	// Client defaults to one with a 2s timeout.
+	Client *http.Client
+}
+
+// ChunkSize implements ChunkSizeOptimizer.
+func (o ServiceOptimizer) ChunkSize(ctx context.Context, file models.FileMetadata, t *telemetry.TelemetryCollector) (int64, error) {
+	type requestPayload struct {
+		SizeBytes              int64   `json:"size_bytes"`
+		MimeType               string  `json:"mime_type"`
+		EstimatedBandwidthMbps float64 `json:"estimated_bandwidth_mbps"`
+		LatencyMs              float64 `json:"latency_ms"`
+	}
+
+	type responsePayload struct {
+		ChunkSizeMB float64 `json:"chunk_size_mb"`
+	}
+
+	reqBody := requestPayload{
+		SizeBytes: file.Size,
+		MimeType:  file.MimeType,
+	}
+
+	// Use telemetry metrics when available; otherwise leave as zero and let the
+	// Python service apply its own defaults.
+	if t != nil {
+		reqBody.EstimatedBandwidthMbps = t.BandwidthMbps()
+		reqBody.LatencyMs = t.LatencyMs()
+	}
+
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	url := o.URL
+	if url == "" {
+		url = DefaultServiceURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("optimizer service returned status %s", resp.Status)
+	}
+
+	var parsed responsePayload
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return 0, err
+	}
+
+	if parsed.ChunkSizeMB <= 0 {
+		return 0, fmt.Errorf("invalid chunk_size_mb from service: %f", parsed.ChunkSizeMB)
+	}
+
+	const MB = 1024 * 1024
+	return int64(parsed.ChunkSizeMB * MB), nil
+}
+
+// DefaultHuggingFaceURL is the inference endpoint HuggingFaceOptimizer uses
+// by default (google/flan-t5-small).
+const DefaultHuggingFaceURL = "https://api-inference.huggingface.co/models/google/flan-t5-small"
+
+// HuggingFaceOptimizer asks a Hugging Face text-to-text model to predict a
+// chunk size from file metadata.
+type HuggingFaceOptimizer struct {
+	// Token is the Hugging Face API token; without one the optimizer
+	// never answers.
+	Token string
+	// URL of the model's inference endpoint; empty means
+	// DefaultHuggingFaceURL.
+	URL string
+	// Client defaults to one with a 10s timeout.
+	Client *http.Client
+}
+
+// hfRequest represents the JSON payload sent to Hugging Face Inference API.
+type hfRequest struct {
+	Inputs     string                 `json:"inputs"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// hfResponseItem represents a single item returned by text-generation models.
+type hfResponseItem struct {
+	GeneratedText string `json:"generated_text"`
+}
+
+// ChunkSize implements ChunkSizeOptimizer.
+func (o HuggingFaceOptimizer) ChunkSize(ctx context.Context, file models.FileMetadata, _ *telemetry.TelemetryCollector) (int64, error) {
+	if o.Token == "" {
+		// If the token is not set, signal the caller to fall back.
+		return 0, fmt.Errorf("hugging face API token not set")
+	}
+
+	prompt := "You are a chunk size optimizer for file transfer.\n\n" +
+		"Given this file:\n" +
+		"- name: " + file.Name + "\n" +
+		"- size_bytes: " + strconv.FormatInt(file.Size, 10) + "\n" +
+		"- mime_type: " + file.MimeType + "\n\n" +
+		"Suggest an optimal chunk size in megabytes as a plain integer (no units, no extra text)."
+
+	reqBody := hfRequest{
+		Inputs: prompt,
+		Parameters: map[string]interface{}{
+			"max_new_tokens": 8,
+		},
+	}
+
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	url := o.URL
+	if url == "" {
+		url = DefaultHuggingFaceURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+o.Token)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("huggingface returned status %s", resp.Status)
+	}
+
+	var hfResp []hfResponseItem
+	if err := json.NewDecoder(resp.Body).Decode(&hfResp); err != nil {
+		return 0, err
+	}
+	if len(hfResp) == 0 {
+		return 0, fmt.Errorf("empty response from huggingface")
+	}
+
+	text := strings.TrimSpace(hfResp[0].GeneratedText)
+
+	// Extract leading digits to get the integer megabyte value.
+	var digits strings.Builder
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		} else if digits.Len() > 0 {
+			break
+		}
+	}
+
+	if digits.Len() == 0 {
+		return 0, fmt.Errorf("no integer found in model output: %q", text)
+	}
+
+	mb, err := strconv.ParseInt(digits.String(), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	const MB = 1024 * 1024
+	return mb * MB, nil
+}
@@ -0,0 +1,65 @@
+package chunker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+type failingOptimizer struct{ calls *int }
+
+func (o failingOptimizer) ChunkSize(context.Context, models.FileMetadata, *telemetry.TelemetryCollector) (int64, error) {
+	*o.calls++
+	return 0, errors.New("unavailable")
+}
+
+func TestChooseChunkSizeAIFallsBackToHeuristic(t *testing.T) {
+	const MB = 1024 * 1024
+	file := models.FileMetadata{Name: "a.bin", Size: 500 * MB}
+
+	cfg := ChunkerConfig{}
+	if got := cfg.ChooseChunkSizeAI(file); got != 32*MB {
+		t.Fatalf("default optimizer chose %d, want %d", got, 32*MB)
+	}
+
+	var calls int
+	cfg.Optimizer = Optimizers{failingOptimizer{&calls}, failingOptimizer{&calls}}
+	if got := cfg.ChooseChunkSizeAI(file); got != 32*MB {
+		t.Fatalf("failing optimizers chose %d, want heuristic %d", got, 32*MB)
+	}
+	if calls != 2 {
+		t.Fatalf("optimizers called %d times, want 2", calls)
+	}
+}
+
+func TestServiceOptimizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SizeBytes int64 `json:"size_bytes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SizeBytes != 1<<30 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"chunk_size_mb": 24}`))
+	}))
+	defer srv.Close()
+
+	var calls int
+	cfg := ChunkerConfig{Optimizer: Optimizers{failingOptimizer{&calls}, ServiceOptimizer{URL: srv.URL}}}
+	if got := cfg.ChooseChunkSizeAI(models.FileMetadata{Name: "a.bin", Size: 1 << 30}); got != 24*1024*1024 {
+		t.Fatalf("chose %d, want 24MB from the service", got)
+	}
+}
+
+func TestHuggingFaceOptimizerNeedsToken(t *testing.T) {
+	if _, err := (HuggingFaceOptimizer{}).ChunkSize(context.Background(), models.FileMetadata{Size: 1}, nil); err == nil {
+		t.Fatal("expected an error without a token")
+	}
+}
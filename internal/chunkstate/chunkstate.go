@@ -0,0 +1,311 @@
+// Package chunkstate tracks the state of every chunk of the sessions in
+// progress, so dashboards can render per-session chunk maps and operators can
+// spot systematic gaps while a transfer runs.
+package chunkstate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrUnknownSession is returned for sessions the tracker does not hold.
+var ErrUnknownSession = errors.New("no chunk map for that session")
+
+// DefaultKeep is how many finished sessions a tracker keeps maps of.
+const DefaultKeep = 32
+
+// State is where a chunk is in the receiver's pipeline.
+type State uint8
+
+const (
+	// Pending chunks are known but their data is missing, e.g. after it
+	// failed verification and awaits a resend.
+	Pending State = iota
+	// InFlight chunks are arriving.
+	InFlight
+	// Verified chunks arrived in full and matched their hash.
+	Verified
+	// Acked chunks are recorded as received in the receiver's session.
+	Acked
+	// Stored chunks are part of the delivered output.
+	Stored
+)
+
+var names = [...]string{"pending", "in_flight", "verified", "acked", "stored"}
+
+// symbols has one character per state, in State order, for Snapshot.States.
+const symbols = ".>vas"
+
+// String returns the state's name as used in APIs and event logs.
+func (s State) String() string {
+	if int(s) < len(names) {
+		return names[s]
+	}
+	return "unknown"
+}
+
+// Symbol returns the character standing for s in Snapshot.States.
+func (s State) Symbol() byte {
+	if int(s) < len(symbols) {
+		return symbols[s]
+	}
+	return '?'
+}
+
+// Legend maps each symbol of Snapshot.States to its state's name.
+func Legend() map[string]string {
+	out := make(map[string]string, len(names))
+	for i, n := range names {
+		out[string(symbols[i])] = n
+	}
+	return out
+}
+
+// Range is a span of the session's data.
+type Range struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Snapshot is a session's chunk map at one version.
+type Snapshot struct {
+	SessionID string    `json:"session_id"`
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Done      bool      `json:"done"`
+	Size      int64     `json:"size"`
+	Chunks    int       `json:"chunks"`
+	// Counts is the number of chunks in each state, by name.
+	Counts map[string]int `json:"counts"`
+	// States has one symbol per chunk in offset order; see Legend.
+	States string `json:"states,omitempty"`
+	// Gaps are the spans of the session's data no verified chunk covers.
+	Gaps []Range `json:"gaps,omitempty"`
+}
+
+type chunk struct {
+	offset, size int64
+	state        State
+}
+
+type session struct {
+	size    int64
+	chunks  map[string]*chunk
+	version uint64
+	updated time.Time
+	done    bool
+	changed chan struct{} // closed and replaced on every update
+}
+
+// touch records an update to s.
+func (s *session) touch(now time.Time) {
+	s.version++
+	s.updated = now
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Tracker holds the chunk maps of sessions in progress and of the last
+// finished ones. It is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	events   *eventlog.Logger
+	sessions map[string]*session
+	finished []string // finished sessions, oldest first
+	keep     int
+	now      func() time.Time
+}
+
+// NewTracker returns a tracker logging every state change to events, if
+// non-nil.
+func NewTracker(events *eventlog.Logger) *Tracker {
+	return &Tracker{
+		events:   events,
+		sessions: make(map[string]*session),
+		keep:     DefaultKeep,
+		now:      time.Now,
+	}
+}
+
+// sessionLocked returns the map of session id, creating it if needed.
+func (t *Tracker) sessionLocked(id string) *session {
+	s, ok := t.sessions[id]
+	if !ok {
+		s = &session{chunks: make(map[string]*chunk), updated: t.now(), changed: make(chan struct{})}
+		t.sessions[id] = s
+	}
+	return s
+}
+
+// Start begins the map of session id, whose data is size bytes.
+func (t *Tracker) Start(id string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.sessionLocked(id)
+	s.size = size
+	s.touch(t.now())
+}
+
+// Set records that chunk c of session id is in state st.
+func (t *Tracker) Set(id string, c *models.ChunkMetadata, st State) {
+	t.mu.Lock()
+	s := t.sessionLocked(id)
+	ch, ok := s.chunks[c.ID]
+	if !ok {
+		ch = &chunk{}
+		s.chunks[c.ID] = ch
+	}
+	ch.offset, ch.size, ch.state = c.Offset, c.Size, st
+	s.touch(t.now())
+	t.mu.Unlock()
+
+	t.events.Log(eventlog.Event{
+		Type:    eventlog.EventState,
+		Session: id,
+		Chunk:   c.ID,
+		Bytes:   c.Size,
+		State:   st.String(),
+	})
+}
+
+// Promote moves every chunk of session id that is in state from to state
+// to, e.g. once the output holding them is delivered.
+func (t *Tracker) Promote(id string, from, to State) {
+	t.mu.Lock()
+	s, ok := t.sessions[id]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	var moved []string
+	for cid, ch := range s.chunks {
+		if ch.state == from {
+			ch.state = to
+			moved = append(moved, cid)
+		}
+	}
+	if len(moved) > 0 {
+		s.touch(t.now())
+	}
+	t.mu.Unlock()
+
+	sort.Strings(moved)
+	for _, cid := range moved {
+		t.events.Log(eventlog.Event{Type: eventlog.EventState, Session: id, Chunk: cid, State: to.String()})
+	}
+}
+
+// Finish marks session id done. The maps of the oldest finished sessions
+// are dropped beyond the tracker's limit.
+func (t *Tracker) Finish(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[id]
+	if !ok || s.done {
+		return
+	}
+	s.done = true
+	s.touch(t.now())
+	t.finished = append(t.finished, id)
+	for len(t.finished) > t.keep {
+		delete(t.sessions, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+// snapshotLocked returns s's map; withStates includes the per-chunk symbols
+// and gaps.
+func snapshotLocked(id string, s *session, withStates bool) Snapshot {
+	snap := Snapshot{
+		SessionID: id,
+		Version:   s.version,
+		UpdatedAt: s.updated,
+		Done:      s.done,
+		Size:      s.size,
+		Chunks:    len(s.chunks),
+		Counts:    make(map[string]int, len(names)),
+	}
+	ordered := make([]*chunk, 0, len(s.chunks))
+	for _, ch := range s.chunks {
+		snap.Counts[ch.state.String()]++
+		ordered = append(ordered, ch)
+	}
+	if !withStates {
+		return snap
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].offset < ordered[j].offset })
+	var b strings.Builder
+	b.Grow(len(ordered))
+	var next int64
+	for _, ch := range ordered {
+		b.WriteByte(ch.state.Symbol())
+		if ch.state < Verified {
+			continue
+		}
+		if ch.offset > next {
+			snap.Gaps = append(snap.Gaps, Range{Offset: next, Length: ch.offset - next})
+		}
+		next = max(next, ch.offset+ch.size)
+	}
+	if next < s.size {
+		snap.Gaps = append(snap.Gaps, Range{Offset: next, Length: s.size - next})
+	}
+	snap.States = b.String()
+	return snap
+}
+
+// Snapshot returns the chunk map of session id.
+func (t *Tracker) Snapshot(id string) (Snapshot, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[id]
+	if !ok {
+		return Snapshot{}, ErrUnknownSession
+	}
+	return snapshotLocked(id, s, true), nil
+}
+
+// Sessions returns a summary of every session's map, without the per-chunk
+// states, ordered by session ID.
+func (t *Tracker) Sessions() []Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Snapshot, 0, len(t.sessions))
+	for id, s := range t.sessions {
+		out = append(out, snapshotLocked(id, s, false))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
+
+// Wait returns the chunk map of session id once its version is above
+// since, or the current one when the session is done or ctx ends.
+func (t *Tracker) Wait(ctx context.Context, id string, since uint64) (Snapshot, error) {
+	for {
+		t.mu.Lock()
+		s, ok := t.sessions[id]
+		if !ok {
+			t.mu.Unlock()
+			return Snapshot{}, ErrUnknownSession
+		}
+		if s.version > since || s.done {
+			snap := snapshotLocked(id, s, true)
+			t.mu.Unlock()
+			return snap, nil
+		}
+		changed := s.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return t.Snapshot(id)
+		}
+	}
+}
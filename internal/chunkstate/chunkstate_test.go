@@ -0,0 +1,116 @@
+package chunkstate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func meta(id string, offset, size int64) *models.ChunkMetadata {
+	return &models.ChunkMetadata{ID: id, Offset: offset, Size: size}
+}
+
+func TestSnapshot(t *testing.T) {
+	tr := NewTracker(nil)
+	tr.Start("s", 400)
+	tr.Set("s", meta("c2", 200, 100), InFlight)
+	tr.Set("s", meta("c0", 0, 100), Acked)
+	tr.Set("s", meta("c1", 100, 100), Pending)
+	tr.Set("s", meta("c3", 300, 100), Verified)
+
+	snap, err := tr.Snapshot("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.States != "a.>v" {
+		t.Fatalf("states = %q", snap.States)
+	}
+	if snap.Chunks != 4 || snap.Counts["acked"] != 1 || snap.Counts["in_flight"] != 1 {
+		t.Fatalf("counts = %d %v", snap.Chunks, snap.Counts)
+	}
+	want := []Range{{Offset: 100, Length: 200}}
+	if len(snap.Gaps) != 1 || snap.Gaps[0] != want[0] {
+		t.Fatalf("gaps = %+v, want %+v", snap.Gaps, want)
+	}
+
+	tr.Set("s", meta("c1", 100, 100), Acked)
+	tr.Set("s", meta("c2", 200, 100), Acked)
+	tr.Promote("s", Acked, Stored)
+	if snap, _ = tr.Snapshot("s"); snap.States != "sssv" || snap.Gaps != nil {
+		t.Fatalf("after promote: %q %+v", snap.States, snap.Gaps)
+	}
+	if sum := tr.Sessions(); len(sum) != 1 || sum[0].States != "" || sum[0].Counts["stored"] != 3 {
+		t.Fatalf("sessions = %+v", sum)
+	}
+	if _, err := tr.Snapshot("missing"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("Snapshot of an unknown session: %v", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	tr := NewTracker(nil)
+	tr.Start("s", 100)
+	snap, _ := tr.Snapshot("s")
+
+	done := make(chan Snapshot)
+	go func() {
+		got, err := tr.Wait(context.Background(), "s", snap.Version)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- got
+	}()
+	tr.Set("s", meta("c0", 0, 100), InFlight)
+	select {
+	case got := <-done:
+		if got.Version <= snap.Version || got.States != ">" {
+			t.Fatalf("Wait = %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after a change")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cur, _ := tr.Snapshot("s")
+	if got, err := tr.Wait(ctx, "s", cur.Version); err != nil || got.Version != cur.Version {
+		t.Fatalf("Wait on timeout = %+v, %v", got, err)
+	}
+}
+
+func TestFinishDropsOldSessions(t *testing.T) {
+	tr := NewTracker(nil)
+	tr.keep = 1
+	tr.Start("a", 1)
+	tr.Start("b", 1)
+	tr.Finish("a")
+	tr.Finish("b")
+	if _, err := tr.Snapshot("a"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("oldest finished session kept: %v", err)
+	}
+	if snap, err := tr.Snapshot("b"); err != nil || !snap.Done {
+		t.Fatalf("Snapshot of finished session = %+v, %v", snap, err)
+	}
+}
+
+func TestStateEvents(t *testing.T) {
+	var buf bytes.Buffer
+	l := eventlog.New(&buf)
+	tr := NewTracker(l)
+	tr.Set("s", meta("c0", 0, 10), InFlight)
+	tr.Set("s", meta("c0", 0, 10), Acked)
+	tr.Promote("s", Acked, Stored)
+	l.Flush()
+	out := buf.String()
+	for _, want := range []string{`"state":"in_flight"`, `"state":"acked"`, `"state":"stored"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("event log lacks %s:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package cli holds what the trackshift commands share: dispatching
+// subcommands, parsing their flags, taking defaults from the environment
+// and a config file, and setting up logging.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Command is a subcommand of the trackshift binary.
+type Command struct {
+	Name    string
+	Summary string
+	// Run runs the command with the arguments after its name. It exits the
+	// process on failure.
+	Run func(args []string)
+}
+
+// Main runs the command named by args[0] with the rest of args, or prints
+// the commands available and exits.
+func Main(prog string, cmds []Command, args []string) {
+	usage := func(w io.Writer) {
+		fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", prog)
+		for _, c := range cmds {
+			fmt.Fprintf(w, "  %-12s %s\n", c.Name, c.Summary)
+		}
+		fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", prog)
+	}
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	}
+	for _, c := range cmds {
+		if c.Name == args[0] {
+			c.Run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", prog, args[0])
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+// FlagSet is the flags of one command, with the flags every command takes:
+// -config and -log-file.
+type FlagSet struct {
+	*flag.FlagSet
+	config  *string
+	logFile *string
+}
+
+// NewFlagSet returns the flags of the command name, which exit the process
+// on a parse error.
+func NewFlagSet(name string) *FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return &FlagSet{
+		FlagSet: fs,
+		config:  fs.String("config", "", "YAML or TOML file of flag defaults, shared or under a section per command (default $"+ConfigEnv+", else ~/.trackshift.yaml, .yml or .toml if present)"),
+		logFile: fs.String("log-file", "", "also append the log to this file"),
+	}
+}
+
+// Parse parses args, fills in the flags not given from the environment
+// and the config file (see load), and sets up logging. It exits the
+// process on failure.
+func (fs *FlagSet) Parse(args []string) {
+	fs.FlagSet.Parse(args)
+	if err := fs.load(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if *fs.logFile != "" {
+		f, err := os.OpenFile(*fs.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("open log file: %v", err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stdout, f))
+	}
+}
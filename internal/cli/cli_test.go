@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlagSetConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	toml := write("trackshift.toml", `# shared
+orchestrator = "http://orch:8000"   # everywhere
+port = 1
+
+[send]
+receiver = 'rx:9090'
+workers = 8
+relays = ["relay-a:9001", "relay-b:9001"]
+
+[receive]
+port = 9091
+`)
+	yml := write(".trackshift.yaml", `orchestrator: http://orch:8000
+port: 1
+send:
+  receiver: rx:9090
+  workers: 8
+  relays: [relay-a:9001, relay-b:9001]
+receive:
+  port: 9091
+`)
+
+	for _, path := range []string{toml, ""} { // "" finds ~/.trackshift.yaml
+		fs := NewFlagSet("send")
+		orch := fs.String("orchestrator", "", "")
+		receiver := fs.String("receiver", "", "")
+		workers := fs.Int("workers", 1, "")
+		relays := fs.String("relays", "", "")
+		args := []string{"-workers", "2"}
+		if path != "" {
+			args = append(args, "-config", path)
+		}
+		// Flags given win over the environment, which wins over the file.
+		t.Setenv("TRACKSHIFT_WORKERS", "4")
+		t.Setenv("TRACKSHIFT_SEND_RECEIVER", "env:9090")
+		fs.Parse(args)
+		if *orch != "http://orch:8000" || *receiver != "env:9090" || *workers != 2 || *relays != "relay-a:9001,relay-b:9001" {
+			t.Fatalf("%s: orchestrator %q, receiver %q, workers %d, relays %q", path, *orch, *receiver, *workers, *relays)
+		}
+
+		fs = NewFlagSet("receive")
+		port := fs.Int("port", 9090, "")
+		fs.Parse([]string{"-config", cmp.Or(path, yml)})
+		if *port != 9091 {
+			t.Fatalf("%s: port = %d, want the receive setting", path, *port)
+		}
+	}
+
+	// Variables set for hooks are not settings.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TRACKSHIFT_FILE", "from-a-hook.bin")
+	fs := NewFlagSet("send")
+	file := fs.String("file", "", "")
+	fs.Parse(nil)
+	if *file != "" {
+		t.Fatalf("file = %q from the hook environment", *file)
+	}
+
+	// A section setting a flag its command lacks is a mistake.
+	fs = NewFlagSet("send")
+	fs.String("orchestrator", "", "")
+	*fs.config = toml
+	if err := fs.load(); err == nil || !strings.Contains(err.Error(), "no flag -receiver") {
+		t.Fatalf("load = %v, want an unknown flag error", err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	settings, err := parseTOML("c.toml", []byte(`
+ratio = 0.5
+verbose = true
+"chunk-size" = 16_777_216
+note = """two
+lines"""
+
+[send]
+relays = [ "relay-a:9001",
+  'relay-b:9001' ]  # across lines
+`))
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	want := []setting{
+		{name: "ratio", value: "0.5"},
+		{name: "verbose", value: "true"},
+		{name: "chunk-size", value: "16777216"},
+		{name: "note", value: "two\nlines"},
+		{section: "send", name: "relays", value: "relay-a:9001,relay-b:9001"},
+	}
+	if len(settings) != len(want) {
+		t.Fatalf("settings = %+v", settings)
+	}
+	for i, s := range settings {
+		if s != want[i] {
+			t.Errorf("setting %d = %+v, want %+v", i, s, want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"receiver = rx:9090",               // not TOML: strings are quoted
+		"[send]\nworkers = 1\nworkers = 2", // defined twice
+		"[send.tls]\ncert = \"c.pem\"",     // commands hold flags, not tables
+		"relays = [[\"a\"], [\"b\"]]",
+		"[[send]]\nworkers = 1",
+	} {
+		if _, err := parseTOML("c.toml", []byte(bad)); err == nil {
+			t.Errorf("parseTOML(%q) succeeded", bad)
+		}
+	}
+}
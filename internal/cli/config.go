@@ -0,0 +1,264 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnv names a config file to load when -config is not given.
+const ConfigEnv = "TRACKSHIFT_CONFIG"
+
+// envPrefix starts the environment variables that set flags.
+const envPrefix = "TRACKSHIFT_"
+
+// hookEnv are the variables set for hooks and archive commands (see
+// internal/hooks); they describe a session, not settings, so a trackshift
+// command run from a hook does not take them as flags.
+var hookEnv = map[string]bool{
+	"TRACKSHIFT_EVENT":   true,
+	"TRACKSHIFT_SESSION": true,
+	"TRACKSHIFT_FILE":    true,
+	"TRACKSHIFT_SIZE":    true,
+	"TRACKSHIFT_SHA256":  true,
+	"TRACKSHIFT_OUTPUT":  true,
+	"TRACKSHIFT_ERROR":   true,
+}
+
+// defaultConfigs are looked for in the home directory when no config file
+// is named.
+var defaultConfigs = []string{".trackshift.yaml", ".trackshift.yml", ".trackshift.toml"}
+
+// EnvNames returns the environment variables that set flag name of
+// command, most specific first: TRACKSHIFT_SEND_CHUNK_SIZE and
+// TRACKSHIFT_CHUNK_SIZE for -chunk-size of send.
+func EnvNames(command, name string) []string {
+	v := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	return []string{envPrefix + strings.ToUpper(command) + "_" + v, envPrefix + v}
+}
+
+// setting is one flag value read from a config file. section is the
+// command it is for, or empty for every command. line is where it was read,
+// if the format tells.
+type setting struct {
+	section, name, value string
+	line                 int
+}
+
+// at locates s in the config file at path, for errors: by line, else by
+// key.
+func (s setting) at(path string) string {
+	switch {
+	case s.line > 0:
+		return fmt.Sprintf("%s:%d", path, s.line)
+	case s.section != "":
+		return fmt.Sprintf("%s: %s.%s", path, s.section, s.name)
+	}
+	return fmt.Sprintf("%s: %s", path, s.name)
+}
+
+// load fills in the flags not given on the command line, first from the
+// environment (see EnvNames), then from the config file: the one given by
+// -config, else the first of defaultConfigs found. Settings outside any
+// section apply to every command that has the flag; those in a section
+// named after a command only to that command, which must have them.
+func (fs *FlagSet) load() error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		for _, env := range EnvNames(fs.Name(), f.Name) {
+			if v, ok := os.LookupEnv(env); ok && !hookEnv[env] {
+				if err = fs.Set(f.Name, v); err != nil {
+					err = fmt.Errorf("$%s: %v", env, err)
+				}
+				given[f.Name] = true
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	path := *fs.config
+	if path == "" {
+		path = defaultConfig()
+	}
+	if path == "" {
+		return nil
+	}
+	settings, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if s.section != "" && s.section != fs.Name() {
+			continue
+		}
+		name := strings.ReplaceAll(strings.TrimLeft(s.name, "-"), "_", "-")
+		switch {
+		case name == "config" || given[name]:
+		case fs.Lookup(name) == nil:
+			if s.section != "" {
+				return fmt.Errorf("%s: %s has no flag -%s", s.at(path), fs.Name(), name)
+			}
+		default:
+			if err := fs.Set(name, s.value); err != nil {
+				return fmt.Errorf("%s: -%s: %v", s.at(path), name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultConfig returns the first of defaultConfigs in the home directory,
+// or "" if there is none.
+func defaultConfig() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range defaultConfigs {
+		path := filepath.Join(home, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readConfig reads the settings of the config file at path: YAML if its
+// name ends in .yaml or .yml, TOML otherwise.
+func readConfig(path string) ([]setting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return parseYAML(path, data)
+	}
+	return parseTOML(path, data)
+}
+
+// parseYAML reads a mapping of flag names to values, and of command names
+// to such mappings. A list is a comma-separated flag value.
+func parseYAML(path string, data []byte) ([]setting, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	var settings []setting
+	var walk func(section string, m *yaml.Node) error
+	walk = func(section string, m *yaml.Node) error {
+		if m.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s:%d: want a mapping of flag names to values", path, m.Line)
+		}
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			key, val := m.Content[i], m.Content[i+1]
+			if val.Kind == yaml.MappingNode && section == "" {
+				if err := walk(key.Value, val); err != nil {
+					return err
+				}
+				continue
+			}
+			v, err := yamlValue(val)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s: %v", path, val.Line, key.Value, err)
+			}
+			settings = append(settings, setting{section: section, name: key.Value, value: v, line: key.Line})
+		}
+		return nil
+	}
+	return settings, walk("", doc.Content[0])
+}
+
+func yamlValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(n.Content))
+		for _, c := range n.Content {
+			if c.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("lists may hold only plain values")
+			}
+			items = append(items, c.Value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("want a value or a list of values")
+}
+
+// parseTOML reads a table of flag names to values, and of command names to
+// such tables. An array is a comma-separated flag value.
+func parseTOML(path string, data []byte) ([]setting, error) {
+	var doc map[string]any
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var settings []setting
+	for _, key := range md.Keys() {
+		var section string
+		var val any
+		switch len(key) {
+		case 1:
+			if _, ok := doc[key[0]].(map[string]any); ok {
+				continue // a command's table; its keys follow
+			}
+			val = doc[key[0]]
+		case 2:
+			table, ok := doc[key[0]].(map[string]any)
+			if !ok {
+				continue // inside an array of tables, refused below
+			}
+			section, val = key[0], table[key[1]]
+		default:
+			continue // nested deeper, refused with its parent
+		}
+		name := key[len(key)-1]
+		v, err := tomlValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+		settings = append(settings, setting{section: section, name: name, value: v})
+	}
+	return settings, nil
+}
+
+func tomlValue(v any) (string, error) {
+	switch v := v.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case []any, map[string]any:
+				return "", fmt.Errorf("lists may hold only plain values")
+			}
+			s, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any, []map[string]any:
+		return "", fmt.Errorf("want a value or a list of values")
+	case string:
+		return v, nil
+	}
+	return fmt.Sprint(v), nil
+}
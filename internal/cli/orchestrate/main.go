@@ -0,0 +1,67 @@
+// Package orchestrate implements the trackshift orchestrate command, which
+// serves the orchestrator API.
+package orchestrate
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+)
+
+// Main runs the orchestrate command with args, the arguments after its name.
+func Main(args []string) {
+	fs := cli.NewFlagSet("orchestrate")
+	dataDir := fs.String("data-dir", os.Getenv("ORCH_DATA_DIR"), "directory for the orchestrator database; empty keeps records in memory only")
+	reflectorAddr := fs.String("reflector-addr", os.Getenv("ORCH_REFLECTOR_ADDR"), "UDP address to tell rendezvous parties their public endpoint on, for hole punching (e.g. :3478); empty runs no reflector")
+	fs.Parse(args)
+
+	addr := ":8000"
+	if v := os.Getenv("ORCH_LISTEN_ADDR"); v != "" {
+		addr = v
+	}
+
+	svc := orchestrator.NewService()
+	if *dataDir != "" {
+		store, err := orchestrator.OpenBoltStore(*dataDir)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if svc, err = orchestrator.NewServiceWithStore(store); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	svc.ScalingWebhook = os.Getenv("ORCH_SCALING_WEBHOOK")
+	svc.AdminToken = os.Getenv("ORCH_ADMIN_TOKEN")
+	if svc.AdminToken == "" {
+		log.Printf("ORCH_ADMIN_TOKEN is not set: the API is open to anyone who can reach it")
+	}
+	if *reflectorAddr != "" {
+		addr, err := net.ResolveUDPAddr("udp", *reflectorAddr)
+		if err != nil {
+			log.Fatalf("-reflector-addr: %v", err)
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			log.Fatalf("reflector: %v", err)
+		}
+		svc.ReflectorPort = conn.LocalAddr().(*net.UDPAddr).Port
+		log.Printf("Reflector listening on %s/udp", conn.LocalAddr())
+		go func() {
+			if err := transport.ServeReflector(conn); err != nil {
+				log.Printf("reflector: %v", err)
+			}
+		}()
+	}
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux)
+
+	log.Printf("Orchestrator listening on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("orchestrator server error: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package receive
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+)
+
+// maxChunkMapWait bounds how long a chunk map request may wait for changes.
+const maxChunkMapWait = 5 * time.Minute
+
+// chunkMaps serves the state of every chunk of the sessions received, so
+// dashboards can render chunk heatmaps while transfers run.
+type chunkMaps struct {
+	tracker *chunkstate.Tracker
+}
+
+// registerRoutes registers the chunk map API on mux:
+//
+//	GET /api/v1/chunkmap                                  sessions with per-state chunk counts
+//	GET /api/v1/chunkmap/legend                           state of each symbol of a chunk map
+//	GET /api/v1/chunkmap/{id}?since=V&timeout=30s         one session's map; with since, waits up to timeout for a version above V
+func (c *chunkMaps) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/chunkmap", c.handleList)
+	mux.HandleFunc("/api/v1/chunkmap/", c.handleSession)
+}
+
+func (c *chunkMaps) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.tracker.Sessions())
+}
+
+func (c *chunkMaps) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/chunkmap/")
+	if id == "" || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if id == "legend" {
+		writeJSON(w, http.StatusOK, chunkstate.Legend())
+		return
+	}
+
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		snap, err := c.tracker.Snapshot(id)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, snap)
+		return
+	}
+	since, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be a version"})
+		return
+	}
+	wait := 30 * time.Second
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid timeout"})
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), min(wait, maxChunkMapWait))
+	defer cancel()
+	// Running out of time is not an error: the caller gets the current map
+	// and can ask again.
+	snap, err := c.tracker.Wait(ctx, id, since)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
@@ -0,0 +1,243 @@
+package receive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// errUnknownTransfer is returned for sessions that are not in flight or whose
+// sender does not accept rate control frames.
+var errUnknownTransfer = errors.New("no controllable transfer with that session ID")
+
+// errNoPrefetch is returned for prefetch hints to senders predating them.
+var errNoPrefetch = errors.New("the sender of this transfer does not accept prefetch hints")
+
+// rateControls tracks the connections of in-flight sessions whose sender
+// accepts rate control frames, so an operator can throttle them without
+// interrupting the transfer.
+type rateControls struct {
+	mu    sync.Mutex
+	conns map[string]*controlConn
+}
+
+// controlConn is the connection of one controllable session.
+type controlConn struct {
+	mu       sync.Mutex // guards done and rate
+	conn     *replyConn
+	rate     float64 // last rate requested, 0 if none
+	prefetch bool    // the sender accepts prefetch hints
+	done     bool    // removed; conn must no longer be written to
+}
+
+func newRateControls() *rateControls {
+	return &rateControls{conns: make(map[string]*controlConn)}
+}
+
+// add registers conn as the connection of session id; prefetch is whether
+// its sender accepts prefetch hints.
+func (c *rateControls) add(id string, conn *replyConn, prefetch bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[id] = &controlConn{conn: conn, prefetch: prefetch}
+}
+
+// remove forgets session id once its connection is done. Once it returns,
+// no control frame is being or will be written to the connection.
+func (c *rateControls) remove(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	cc, ok := c.conns[id]
+	delete(c.conns, id)
+	c.mu.Unlock()
+	if ok {
+		cc.mu.Lock()
+		cc.done = true
+		cc.mu.Unlock()
+	}
+}
+
+// setRate asks the sender of session id to send at bytesPerSec, or without
+// limit if it is zero.
+func (c *rateControls) setRate(id string, bytesPerSec float64) error {
+	c.mu.Lock()
+	cc, ok := c.conns[id]
+	c.mu.Unlock()
+	if !ok {
+		return errUnknownTransfer
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.done {
+		return errUnknownTransfer
+	}
+	err := cc.conn.send(func(ctx context.Context, conn net.Conn) error {
+		return transport.NewTCPSender().SendRateControl(ctx, conn, transport.RateControl{BytesPerSec: bytesPerSec})
+	})
+	if err != nil {
+		return err
+	}
+	cc.rate = bytesPerSec
+	return nil
+}
+
+// prefetch asks the sender of session id to send the chunks covering h
+// next.
+func (c *rateControls) prefetch(id string, h transport.PrefetchHint) error {
+	c.mu.Lock()
+	cc, ok := c.conns[id]
+	c.mu.Unlock()
+	if !ok {
+		return errUnknownTransfer
+	}
+	if !cc.prefetch {
+		return errNoPrefetch
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.done {
+		return errUnknownTransfer
+	}
+	return cc.conn.send(func(ctx context.Context, conn net.Conn) error {
+		return transport.NewTCPSender().SendPrefetch(ctx, conn, h)
+	})
+}
+
+// transferRate describes a controllable transfer in API responses.
+type transferRate struct {
+	SessionID   string  `json:"session_id"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+}
+
+func (c *rateControls) list() []transferRate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]transferRate, 0, len(c.conns))
+	for id, cc := range c.conns {
+		cc.mu.Lock()
+		out = append(out, transferRate{SessionID: id, BytesPerSec: cc.rate})
+		cc.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
+
+// registerRoutes registers the control API on mux:
+//
+//	GET  /api/v1/transfers                 in-flight controllable transfers
+//	POST /api/v1/transfers/{id}/rate       body {"limit": "10MB/s"}; "0" or "" removes the limit
+//	POST /api/v1/transfers/{id}/prefetch   body {"offset": 0, "length": 1048576}
+func (c *rateControls) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/transfers", c.handleList)
+	mux.HandleFunc("/api/v1/transfers/", c.handleTransfer)
+}
+
+func (c *rateControls) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.list())
+}
+
+// handleTransfer handles POST /api/v1/transfers/{id}/rate and
+// POST /api/v1/transfers/{id}/prefetch
+func (c *rateControls) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/transfers/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "rate":
+		c.handleRate(w, r, parts[0])
+	case "prefetch":
+		c.handlePrefetch(w, r, parts[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (c *rateControls) handleRate(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Limit string `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	rate, err := ratelimit.ParseRate(req.Limit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := c.setRate(id, rate); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errUnknownTransfer) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Session %s: send rate set to %s", id, formatRate(rate))
+	writeJSON(w, http.StatusOK, transferRate{SessionID: id, BytesPerSec: rate})
+}
+
+func (c *rateControls) handlePrefetch(w http.ResponseWriter, r *http.Request, id string) {
+	var h transport.PrefetchHint
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if h.Offset < 0 || h.Length <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "offset must be >= 0 and length > 0"})
+		return
+	}
+	if err := c.prefetch(id, h); err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errUnknownTransfer):
+			status = http.StatusNotFound
+		case errors.Is(err, errNoPrefetch):
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Session %s: prefetch %s at offset %d requested", id, utils.HumanBytes(h.Length), h.Offset)
+	writeJSON(w, http.StatusOK, map[string]any{"session_id": id, "offset": h.Offset, "length": h.Length})
+}
+
+func formatRate(bytesPerSec float64) string {
+	if bytesPerSec <= 0 {
+		return "unlimited"
+	}
+	return utils.HumanBytes(int64(bytesPerSec)) + "/s"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON error: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package receive
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+var (
+	// errDraining refuses sessions once the receiver is draining.
+	errDraining = errors.New("the receiver is draining and accepts no new sessions")
+	// errCancelled refuses a sender coming back for a cancelled session.
+	errCancelled = errors.New("the session was cancelled by the receiver")
+	// errUnknownSession is returned for sessions not being received.
+	errUnknownSession = errors.New("no session with that ID is being received")
+)
+
+// activeSessions tracks the sessions being received and the connections
+// carrying them, so that the control API can follow and cancel sessions and
+// drain the receiver when it is run as a long-lived service.
+type activeSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*activeSession
+	// cancelled holds the sender session IDs of cancelled sessions, which
+	// are refused if their sender reconnects to resume them.
+	cancelled map[string]bool
+	conns     int
+	draining  bool
+	// ln accepts the receiver's connections until it drains; idle is
+	// closed once the receiver is draining and the last connection ended.
+	ln   net.Listener
+	idle chan struct{}
+}
+
+// activeSession is one session being received.
+type activeSession struct {
+	progress inboundProgress
+	// cancel ends the connection carrying the session.
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// inboundProgress describes a session being received in API responses.
+type inboundProgress struct {
+	SessionID     string    `json:"session_id"`
+	SenderSession string    `json:"sender_session,omitempty"`
+	File          string    `json:"file"`
+	Size          int64     `json:"size"`
+	Received      int64     `json:"received"`
+	Percent       float64   `json:"percent"`
+	BytesPerSec   float64   `json:"bytes_per_sec"` // average since the session started
+	Sender        string    `json:"sender"`
+	Started       time.Time `json:"started"`
+}
+
+// drainStatus is the reply of the drain endpoint.
+type drainStatus struct {
+	Draining    bool `json:"draining"`
+	Connections int  `json:"connections"`
+	Sessions    int  `json:"sessions"`
+}
+
+func newActiveSessions() *activeSessions {
+	return &activeSessions{
+		sessions:  make(map[string]*activeSession),
+		cancelled: make(map[string]bool),
+		idle:      make(chan struct{}),
+	}
+}
+
+// serve records ln as the listener accepting the receiver's connections,
+// which is closed once the receiver drains.
+func (a *activeSessions) serve(ln net.Listener) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ln = ln
+	if a.draining {
+		ln.Close()
+	}
+}
+
+// connOpened counts a connection accepted, and reports false if the
+// receiver is draining, in which case the connection is to be closed.
+func (a *activeSessions) connOpened() bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.draining {
+		return false
+	}
+	a.conns++
+	return true
+}
+
+// connClosed counts a connection ended.
+func (a *activeSessions) connClosed() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns--
+	if a.draining && a.conns == 0 {
+		close(a.idle)
+	}
+}
+
+// admit reports why a session for the sender's session senderSession may
+// not be opened, if it may not.
+func (a *activeSessions) admit(senderSession string) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.draining:
+		return errDraining
+	case senderSession != "" && a.cancelled[senderSession]:
+		return errCancelled
+	}
+	return nil
+}
+
+// add registers sess, received from sender on a connection that cancel
+// ends.
+func (a *activeSessions) add(sess *models.TransferSession, sender string, started time.Time, cancel context.CancelFunc) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[sess.ID] = &activeSession{
+		progress: inboundProgress{
+			SessionID:     sess.ID,
+			SenderSession: sess.File.SenderSession,
+			File:          sess.File.Name,
+			Size:          sess.File.Size,
+			Sender:        sender,
+			Started:       started,
+		},
+		cancel: cancel,
+	}
+}
+
+// update records the bytes received for session id so far.
+func (a *activeSessions) update(id string, received int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s, ok := a.sessions[id]; ok {
+		s.progress.Received = received
+	}
+}
+
+// remove forgets session id as it ends and reports whether it was
+// cancelled.
+func (a *activeSessions) remove(id string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[id]
+	delete(a.sessions, id)
+	return ok && s.cancelled
+}
+
+// cancelSession ends session id by closing its connection, along with any
+// other session sharing it, and refuses its sender from then on.
+func (a *activeSessions) cancelSession(id string) error {
+	a.mu.Lock()
+	s, ok := a.sessions[id]
+	if ok {
+		s.cancelled = true
+		if s.progress.SenderSession != "" {
+			a.cancelled[s.progress.SenderSession] = true
+		}
+	}
+	a.mu.Unlock()
+	if !ok {
+		return errUnknownSession
+	}
+	s.cancel()
+	return nil
+}
+
+// drain stops accepting connections and sessions; the receiver exits once
+// the sessions in flight have ended.
+func (a *activeSessions) drain() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.draining {
+		return
+	}
+	a.draining = true
+	if a.ln != nil {
+		a.ln.Close()
+	}
+	if a.conns == 0 {
+		close(a.idle)
+	}
+}
+
+// isDraining reports whether drain was called.
+func (a *activeSessions) isDraining() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.draining
+}
+
+func (a *activeSessions) status() drainStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return drainStatus{Draining: a.draining, Connections: a.conns, Sessions: len(a.sessions)}
+}
+
+func (a *activeSessions) snapshot(s *activeSession, now time.Time) inboundProgress {
+	p := s.progress
+	if p.Size > 0 {
+		p.Percent = float64(p.Received) * 100 / float64(p.Size)
+	}
+	if d := now.Sub(p.Started).Seconds(); d > 0 {
+		p.BytesPerSec = float64(p.Received) / d
+	}
+	return p
+}
+
+func (a *activeSessions) list() []inboundProgress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	out := make([]inboundProgress, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		out = append(out, a.snapshot(s, now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+func (a *activeSessions) get(id string) (inboundProgress, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[id]
+	if !ok {
+		return inboundProgress{}, false
+	}
+	return a.snapshot(s, time.Now()), true
+}
+
+// registerRoutes registers the session control API on mux:
+//
+//	GET    /api/v1/inbound        sessions being received, with their progress
+//	GET    /api/v1/inbound/{id}   one session's progress
+//	DELETE /api/v1/inbound/{id}   cancel a session
+//	GET    /api/v1/drain          whether the receiver is draining
+//	POST   /api/v1/drain          stop accepting transfers and exit once those in flight end
+func (a *activeSessions) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/inbound", a.handleList)
+	mux.HandleFunc("/api/v1/inbound/", a.handleSession)
+	mux.HandleFunc("/api/v1/drain", a.handleDrain)
+}
+
+func (a *activeSessions) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.list())
+}
+
+// handleSession handles GET and DELETE /api/v1/inbound/{id}
+func (a *activeSessions) handleSession(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/inbound/")
+	if id == "" || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		p, ok := a.get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errUnknownSession.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	case http.MethodDelete:
+		if err := a.cancelSession(id); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("Session %s cancelled through the control API", id)
+		writeJSON(w, http.StatusOK, map[string]string{"session_id": id, "status": "cancelled"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *activeSessions) handleDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !a.isDraining() {
+			log.Printf("Draining: accepting no new transfers, exiting once %d sessions in flight end", a.status().Sessions)
+		}
+		a.drain()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.status())
+}
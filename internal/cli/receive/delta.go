@@ -0,0 +1,84 @@
+package receive
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// deltaBase is the earlier version of a file that chunks of a delta transfer
+// are copied from. It lives in the temp dir for the rest of the connection.
+type deltaBase struct {
+	f *os.File
+}
+
+// answerDelta handles a delta request for sess: it moves any earlier version
+// of the file aside, chunks it the way the sender chunks the new one and
+// replies with the chunk hashes. The returned base is nil if there is no
+// earlier version.
+func answerDelta(ctx context.Context, conn net.Conn, recv *transport.TCPReceiver, sess *models.TransferSession, req transport.DeltaRequest) (*deltaBase, error) {
+	reply := func(b transport.DeltaBase) error {
+		return transport.NewTCPSender().SendDeltaBase(ctx, conn, b)
+	}
+	alg, err := chunker.ParseAlgorithm(req.Algorithm)
+	if err != nil {
+		log.Printf("delta request: %v; sending no base", err)
+		return nil, reply(transport.DeltaBase{})
+	}
+	path, ok, err := recv.MoveDeltaBase(sess)
+	if err != nil {
+		log.Printf("delta request: %v; sending no base", err)
+		return nil, reply(transport.DeltaBase{})
+	}
+	if !ok {
+		log.Printf("Session %s: no earlier version of %s, receiving in full", sess.ID, sess.File.Name)
+		return nil, reply(transport.DeltaBase{})
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	base := &deltaBase{f: f}
+	info, err := f.Stat()
+	if err != nil {
+		base.close()
+		return nil, err
+	}
+	ch := chunker.NewChunker(chunker.ChunkerConfig{Algorithm: alg, HashWorkers: runtime.NumCPU()})
+	chunks, err := ch.ChunkReaderAt(f, info.Size(), req.ChunkSize)
+	if err != nil {
+		base.close()
+		return nil, fmt.Errorf("chunk delta base: %w", err)
+	}
+	out := transport.DeltaBase{Chunks: make([]transport.DeltaChunk, len(chunks))}
+	for i, c := range chunks {
+		out.Chunks[i] = transport.DeltaChunk{Offset: c.Offset, Size: c.Size, SHA256: c.SHA256}
+	}
+	log.Printf("Session %s: offering %d chunks (%s) of the earlier version of %s",
+		sess.ID, len(chunks), utils.HumanBytes(info.Size()), sess.File.Name)
+	if err := reply(out); err != nil {
+		base.close()
+		return nil, err
+	}
+	return base, nil
+}
+
+// close closes and removes the base file; a nil base is a no-op.
+func (b *deltaBase) close() {
+	if b == nil {
+		return
+	}
+	b.f.Close()
+	if err := os.Remove(b.f.Name()); err != nil {
+		log.Printf("remove delta base: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package receive
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/internal/authtoken"
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Options configures Serve: the receiver as embedded in another program,
+// with the defaults of the receive command for everything not set here.
+type Options struct {
+	// OutputDir, TempDir and SessionDir are as -output-dir, -temp-dir and
+	// -sessions-dir: "received", temp within it and "sessions" if empty.
+	OutputDir  string
+	TempDir    string
+	SessionDir string
+	// Direct writes chunks in place into the output file, as -store-mode
+	// direct, instead of assembling it from temp chunks at the end.
+	Direct bool
+	// AuthTokens, if any, are -auth-token specs senders must present one
+	// of.
+	AuthTokens []string
+	// NodeID names this receiver in the receipts it signs (default the
+	// hostname). The key is <SessionDir>/receipt.key, created if missing.
+	NodeID string
+
+	// OnProgress, if non-nil, is called as each chunk of a session is
+	// stored with the bytes stored so far.
+	OnProgress func(sess *models.TransferSession, received int64)
+	// OnSession, if non-nil, is called as each session ends, with where it
+	// was delivered or why it was not. Both are called from the goroutine
+	// receiving the session, one per connection.
+	OnSession func(sess *models.TransferSession, output string, err error)
+}
+
+// Serve receives transfers on ln until ctx is done, then closes ln and the
+// connections in progress, waits for their sessions to end and returns
+// ctx.Err(). It returns early if ln fails or the receiver cannot be set up.
+func Serve(ctx context.Context, ln net.Listener, opts Options) error {
+	outputDir := cmp.Or(opts.OutputDir, "received")
+	sessionDir := cmp.Or(opts.SessionDir, "sessions")
+	store, err := session.OpenStore(session.StoreJSON, sessionDir)
+	if err != nil {
+		return err
+	}
+	sessMgr, err := session.NewSessionManagerWithStore(store)
+	if err != nil {
+		return fmt.Errorf("create session manager: %w", err)
+	}
+	defer sessMgr.Close()
+
+	tokenList := make([]authtoken.Token, 0, len(opts.AuthTokens))
+	for _, spec := range opts.AuthTokens {
+		t, err := authtoken.Parse(spec)
+		if err != nil {
+			return err
+		}
+		tokenList = append(tokenList, t)
+	}
+	tokens, err := authtoken.NewSet(tokenList)
+	if err != nil {
+		return err
+	}
+
+	keyPath := filepath.Join(sessionDir, "receipt.key")
+	key, err := receipt.LoadOrCreateKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("receipt key: %w", err)
+	}
+	signer := &receiptSigner{key: key, node: opts.NodeID}
+	if signer.node == "" {
+		signer.node, _ = os.Hostname()
+	}
+	log.Printf("Receipts signed with key %s (public key in %s.pub)", receipt.Fingerprint(key.Public().(ed25519.PublicKey)), keyPath)
+
+	cfg := receiverConfig{
+		direct:     opts.Direct,
+		telemetry:  telemetry.NewTelemetryCollector(),
+		timeouts:   timeouts.Defaults(),
+		tokens:     tokens,
+		receipts:   signer,
+		claims:     newClaimSet(),
+		outputs:    newClaimSet(),
+		chunkmap:   chunkstate.NewTracker(nil),
+		onProgress: opts.OnProgress,
+		onSession:  opts.OnSession,
+	}
+	recv, err := transport.NewTCPReceiver(outputDir, opts.TempDir)
+	if err != nil {
+		return fmt.Errorf("create receiver: %w", err)
+	}
+	recv.Timeouts = cfg.timeouts
+	if !cfg.direct {
+		recoverSessions(recv, sessMgr)
+	}
+
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool)
+		wg    sync.WaitGroup
+	)
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	})
+	defer stop()
+	defer wg.Wait()
+
+	log.Printf("Receiver listening on %s (tcp)", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		mu.Lock()
+		if ctx.Err() != nil {
+			// Accepted as the connections in progress were being closed.
+			conn.Close()
+		}
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleConnection(ctx, conn, recv, sessMgr, cfg)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
@@ -0,0 +1,600 @@
+package receive
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/authtoken"
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/hooks"
+	"github.com/deb2000-sudo/trackshift/internal/objstore"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/internal/reservation"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// replyConn is a connection frames are written back to the sender on, from
+// the connection's goroutine and from the control API. Each frame is
+// written under its lock so frames of different sessions never interleave,
+// and none is written once ctx, the connection's context, is done.
+type replyConn struct {
+	net.Conn
+	ctx context.Context
+	mu  sync.Mutex
+}
+
+// send writes a frame with write while holding the connection's lock.
+func (c *replyConn) send(write func(context.Context, net.Conn) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return write(c.ctx, c.Conn)
+}
+
+// claimSet records names, such as session IDs or output paths, in use by
+// the connections receiving sessions.
+type claimSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func newClaimSet() *claimSet {
+	return &claimSet{names: make(map[string]bool)}
+}
+
+// claim reports whether name was free, taking it if so.
+func (c *claimSet) claim(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names[name] {
+		return false
+	}
+	c.names[name] = true
+	return true
+}
+
+func (c *claimSet) release(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.names, name)
+}
+
+// inbound is a session being received on a connection.
+type inbound struct {
+	// tag is the session ID the sender tags the session's frames with.
+	tag  string
+	sess *models.TransferSession
+	// prepared is set once the direct-mode output file has been created.
+	prepared bool
+	// resumed is set when sess was kept from an earlier attempt at the
+	// sender's session.
+	resumed bool
+	// controllable is set once the session is registered for rate control.
+	controllable bool
+	// delta is set once the sender asked for a delta transfer, and base is
+	// the earlier version of the file it is based on.
+	delta bool
+	base  *deltaBase
+	// authorized is set once the receiver's Authorizer accepted the session.
+	// That happens at the session's first frame after the handshake, when
+	// the file metadata and any manifest are known.
+	authorized bool
+	// admitted is set once the reservation ledger admitted the session, and
+	// reserved is the reservation it claimed, if any.
+	admitted bool
+	reserved *reservation.Reservation
+	// output is the destination claimed for the session, if any.
+	output string
+	// upload is the object the session is stored as in s3 mode, once its
+	// first chunk arrived.
+	upload objstore.Upload
+	// receiptReq is set if the sender asked for a delivery receipt, which is
+	// answered once the session ends: signed if the file was delivered,
+	// otherwise with failure.
+	receiptReq *transport.ReceiptRequest
+	failure    string
+	// delivered is set once the session's data is stored in full, which
+	// completes the session; otherwise it ends failed. delivery is where
+	// it was stored: the file or directory, or the chunk index.
+	delivered bool
+	delivery  string
+	// verified is set once the whole file delivered matched the sender's
+	// hash.
+	verified bool
+	// note is why a delivered session is reported unverified although
+	// nothing failed, e.g. because there was no hash to check it against.
+	note string
+	// finished is set once the receiver tried to deliver the session.
+	finished bool
+	// started is when the session was opened, received the file bytes
+	// stored since, and tally its retransmits and rejected chunks, for the
+	// session's summary report.
+	started  time.Time
+	received int64
+	tally    report.Tally
+	// reported is when the bytes received were last reported to the
+	// orchestrator; reporting is set while such a report is on its way, and
+	// reports waits for it.
+	reported  time.Time
+	reporting atomic.Bool
+	reports   sync.WaitGroup
+}
+
+// inboundConn holds the sessions received on one connection. A sender may
+// interleave several sessions on it by tagging every frame with its session
+// ID; untagged frames, as sent by older senders and relays, belong to the
+// session opened last.
+type inboundConn struct {
+	ctx context.Context
+	// cancel ends the connection, as when a session is cancelled.
+	cancel  context.CancelFunc
+	conn    *replyConn
+	recv    *transport.TCPReceiver
+	sessMgr *session.SessionManager
+	cfg     receiverConfig
+	// clockOffset is how far this receiver's clock is ahead of the sender's,
+	// as reported at the end of the time sync exchange.
+	clockOffset time.Duration
+	// token is the auth token the sender presented, whose limits apply to
+	// every session on the connection.
+	token *authtoken.Token
+
+	tags  map[string]*inbound
+	order []*inbound // in the order they were opened
+	last  *inbound
+}
+
+func newInboundConn(ctx context.Context, cancel context.CancelFunc, conn net.Conn, recv *transport.TCPReceiver, sessMgr *session.SessionManager, cfg receiverConfig) *inboundConn {
+	return &inboundConn{
+		ctx:     ctx,
+		cancel:  cancel,
+		conn:    &replyConn{Conn: conn, ctx: ctx},
+		recv:    recv,
+		sessMgr: sessMgr,
+		cfg:     cfg,
+		tags:    make(map[string]*inbound),
+	}
+}
+
+// session returns the session a frame tagged with id belongs to, or nil if
+// there is none. While a single session is open every frame is its own.
+func (c *inboundConn) session(id string) *inbound {
+	if in, ok := c.tags[id]; ok {
+		return in
+	}
+	if id == "" || len(c.order) == 1 {
+		return c.last
+	}
+	return nil
+}
+
+// open starts receiving the session described by fileMeta, whose frames
+// are tagged with tag, at the negotiated protocol version.
+func (c *inboundConn) open(tag string, fileMeta models.FileMetadata, version uint8) (*inbound, error) {
+	if err := c.cfg.active.admit(fileMeta.SenderSession); err != nil {
+		return nil, err
+	}
+	in := &inbound{tag: tag, failure: "transfer did not complete", started: time.Now()}
+	// Sessions received as temp chunks pick up where an earlier attempt at
+	// the sender's session left off.
+	if fileMeta.SenderSession != "" && c.cfg.store == nil && !c.cfg.direct && c.cfg.objects == nil {
+		in.sess, in.resumed = resumeSession(c.recv, c.sessMgr, c.cfg.claims, fileMeta)
+	}
+	if !in.resumed {
+		sess, err := newReceiverSession(c.sessMgr, fileMeta)
+		if err != nil {
+			return nil, err
+		}
+		in.sess = sess
+		c.cfg.claims.claim(sess.ID)
+	}
+	sess := in.sess
+	sess.ProtocolVersion = version
+	c.cfg.chunkmap.Start(sess.ID, sess.File.Size)
+	c.cfg.metrics.SessionStarted()
+	for _, ch := range sess.Chunks {
+		if ch.Status == models.ChunkStatusCompleted {
+			c.cfg.chunkmap.Set(sess.ID, ch, chunkstate.Acked)
+		}
+	}
+	if err := c.sessMgr.SetStatus(sess.ID, models.SessionStatusTransferring); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	if len(c.order) > 0 {
+		log.Printf("Session %s shares the connection from %s with %d more", sess.ID, c.conn.RemoteAddr(), len(c.order))
+	}
+	c.tags[tag] = in
+	c.order = append(c.order, in)
+	c.last = in
+	c.cfg.active.add(sess, c.conn.RemoteAddr().String(), in.started, c.cancel)
+	return in, nil
+}
+
+// authenticate checks the token in an auth frame against cfg.tokens and
+// answers it. A sender presenting a token to a receiver that requires none
+// is accepted without limits. The connection is to be closed if it returns
+// false.
+func (c *inboundConn) authenticate(data io.Reader) bool {
+	payload, err := io.ReadAll(data)
+	if err != nil {
+		log.Printf("read auth frame: %v", err)
+		return false
+	}
+	secret, err := transport.DecodeAuth(payload)
+	if err == nil && c.cfg.tokens != nil {
+		c.token, err = c.cfg.tokens.Verify(secret)
+	}
+	answered := c.conn.send(func(ctx context.Context, conn net.Conn) error {
+		return transport.NewTCPSender().AnswerAuth(ctx, conn, err)
+	})
+	if err != nil {
+		log.Printf("rejecting sender %s: %v", c.conn.RemoteAddr(), err)
+		return false
+	}
+	if answered != nil {
+		log.Printf("answer auth frame: %v", answered)
+		return false
+	}
+	if c.token != nil {
+		log.Printf("Sender %s authenticated with token %s", c.conn.RemoteAddr(), c.token.Name)
+	}
+	return true
+}
+
+// admitToken applies the limits of token, if any, to sess, moving its
+// destination into the token's subdirectory if it has one.
+func admitToken(token *authtoken.Token, recv *transport.TCPReceiver, sess *models.TransferSession) error {
+	if token == nil {
+		return nil
+	}
+	dest := recv.Destination(sess)
+	placed, err := token.Admit(sess.File.Size, dest, recv.OutputDir)
+	if err != nil || placed == dest {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(placed), 0o755); err != nil {
+		return err
+	}
+	sess.OutputPath = placed
+	return nil
+}
+
+// authorize checks in against the connection's token and asks the
+// receiver's Authorizer and the reservation ledger whether it may proceed,
+// once, and places and claims its destination. A
+// session turned down is failed.
+func (c *inboundConn) authorize(in *inbound) bool {
+	if in.authorized {
+		return true
+	}
+	sess := in.sess
+	err := admitToken(c.token, c.recv, sess)
+	if err == nil {
+		err = c.recv.Authorize(c.conn.Conn, sess)
+	}
+	if err == nil && c.cfg.objects != nil {
+		err = checkObject(sess)
+	}
+	if err == nil && c.cfg.store == nil && c.cfg.objects == nil {
+		dest := c.recv.Destination(sess)
+		if err = c.recv.PlaceOutput(sess, in.delta); err == nil {
+			if renamed := c.recv.Destination(sess); renamed != dest {
+				log.Printf("Session %s: %s exists; writing to %s", sess.ID, dest, renamed)
+			}
+			c.claimOutput(in)
+		}
+	}
+	if err == nil && c.cfg.reservations != nil {
+		if in.reserved, err = c.cfg.reservations.Admit(sess.ID, sess.File.Reservation, sess.File.Size); err == nil {
+			in.admitted = true
+		}
+	}
+	if err != nil {
+		log.Printf("Session %s from %s: %v", sess.ID, c.conn.RemoteAddr(), err)
+		in.failure = err.Error()
+		if err := c.sessMgr.SetStatus(sess.ID, models.SessionStatusFailed); err != nil {
+			log.Printf("save session: %v", err)
+		}
+		return false
+	}
+	if in.reserved != nil {
+		log.Printf("Session %s claimed reservation %s", sess.ID, in.reserved.ID)
+	}
+	in.authorized = true
+	return true
+}
+
+// claimOutput claims the destination of in, moving it to a path of its own
+// if another session being received writes there.
+func (c *inboundConn) claimOutput(in *inbound) {
+	dest := c.recv.Destination(in.sess)
+	if c.cfg.outputs.claim(dest) {
+		in.output = dest
+		return
+	}
+	// Session IDs are unique, so the variant is free.
+	in.output = transport.DistinctPath(dest, in.sess.ID)
+	c.cfg.outputs.claim(in.output)
+	in.sess.OutputPath = in.output
+	log.Printf("Session %s: %s is being received by another session; writing to %s", in.sess.ID, dest, in.output)
+	if err := c.sessMgr.SaveSession(in.sess); err != nil {
+		log.Printf("save session: %v", err)
+	}
+}
+
+// finish delivers the data of in once the connection is done: it writes
+// the chunk index, completes the object, finalizes the file written in
+// place, or assembles the file from its temp chunks.
+func (c *inboundConn) finish(in *inbound) {
+	sess, cfg, recv := in.sess, c.cfg, c.recv
+	in.finished = true
+	if cfg.store != nil {
+		indexPath, err := cfg.store.WriteIndex(sess)
+		if err != nil {
+			log.Printf("write chunk index: %v", err)
+			return
+		}
+		log.Printf("Stored %d chunks for session %s (index %s)", len(sess.Chunks), sess.ID, indexPath)
+		in.failure, in.delivered, in.delivery = "", true, indexPath
+		in.note = "receiver keeps chunks without assembling the file, so it was not verified as a whole"
+		return
+	}
+
+	if cfg.objects != nil {
+		c.finishObject(in)
+		return
+	}
+
+	if cfg.direct {
+		// Most of the whole-file hash was computed as chunks landed, so
+		// finalizing only has to cover what is left.
+		verified := recv.VerifiedPrefix(sess.ID)
+		outPath, err := recv.FinalizeOutput(c.ctx, sess)
+		if err != nil {
+			log.Printf("finalize output: %v", err)
+			in.failure = err.Error()
+			return
+		}
+		if outPath, err = finishOutput(outPath, recv.Destination(sess), sess.File, sess.Manifest, cfg.autoExtract, cfg.xattrs); err != nil {
+			log.Printf("%v", err)
+			in.failure = err.Error()
+			return
+		}
+		in.failure, in.delivered, in.delivery, in.verified = "", true, outPath, true
+		archiveOutput(cfg.archive, outPath, sess.File)
+		log.Printf("Wrote file in place at %s (%s, %s verified during transfer)",
+			outPath, utils.HumanBytes(sess.File.Size), utils.HumanBytes(verified))
+		return
+	}
+
+	// Chunks are verified as they arrive, but only their sum shows whether
+	// the sender got through the whole file.
+	stored := int64(0)
+	for _, c := range sess.Chunks {
+		stored += c.Size
+	}
+	if stored < sess.File.Size {
+		in.failure = fmt.Sprintf("only %s of %s received", utils.HumanBytes(stored), utils.HumanBytes(sess.File.Size))
+		log.Printf("Session %s: not assembling %s: %s", sess.ID, sess.File.Name, in.failure)
+		return
+	}
+	outPath, err := recv.AssembleFile(c.ctx, sess)
+	if err != nil {
+		log.Printf("assemble file: %v", err)
+		in.failure = err.Error()
+		return
+	}
+	if outPath, err = finishOutput(outPath, recv.Destination(sess), sess.File, sess.Manifest, cfg.autoExtract, cfg.xattrs); err != nil {
+		log.Printf("%v", err)
+		in.failure = err.Error()
+		return
+	}
+	in.failure, in.delivered, in.delivery, in.verified = "", true, outPath, true
+	archiveOutput(cfg.archive, outPath, sess.File)
+	log.Printf("Assembled file at %s (%s, last one-way delay %.1fms)",
+		outPath, utils.HumanBytes(sess.File.Size), cfg.telemetry.OneWayDelayMs())
+}
+
+// runHook runs the receiver's on-complete hook for in if it was delivered,
+// its on-failure hook otherwise. Hooks run in the background, so a slow
+// one holds up neither the sender nor the next session; failures are
+// logged.
+func (c *inboundConn) runHook(in *inbound) {
+	event, hook := hooks.Complete, c.cfg.onComplete
+	if !in.delivered {
+		event, hook = hooks.Failure, c.cfg.onFailure
+	}
+	if hook == nil {
+		return
+	}
+	p := hooks.NewPayload(event, in.sess)
+	p.Peer = c.conn.RemoteAddr().String()
+	p.Output = in.delivery
+	p.Verified = in.verified
+	if !in.delivered {
+		p.Error = in.failure
+		if p.Error == "" {
+			p.Error = "connection closed before the transfer completed"
+		}
+	}
+	go func() {
+		if err := hook.Run(p); err != nil {
+			log.Printf("Session %s: %s hook %s: %v", in.sess.ID, event, hook, err)
+			return
+		}
+		log.Printf("Session %s: ran %s hook %s", in.sess.ID, event, hook)
+	}()
+}
+
+// writeReport writes the summary of in to the receiver's report file, if
+// it has one.
+func (c *inboundConn) writeReport(in *inbound) {
+	if c.cfg.reports == nil {
+		return
+	}
+	s := report.Summary{
+		Role:         report.RoleReceiver,
+		Session:      in.sess.ID,
+		File:         in.sess.File.Name,
+		Peer:         c.conn.RemoteAddr().String(),
+		Status:       report.StatusCompleted,
+		Bytes:        in.sess.File.Size,
+		Transferred:  in.received,
+		StartedAt:    in.started,
+		Verification: report.Unverified,
+	}
+	switch {
+	case !in.delivered:
+		s.Status, s.Error = report.StatusFailed, in.failure
+		if in.finished {
+			s.Verification = report.Failed
+		}
+	case in.verified:
+		s.Verification = report.Verified
+	}
+	s.Note = in.note
+	in.tally.Fill(&s)
+	s.Finish(time.Now())
+	if err := c.cfg.reports.Write(s); err != nil {
+		log.Printf("write report: %v", err)
+	}
+}
+
+// reportInterval is how often the bytes received for a session are
+// reported to the orchestrator while it is received.
+const reportInterval = 2 * time.Second
+
+// reportProgress tells the orchestrator, if there is one, the bytes received
+// for in so far. It reports at most every reportInterval, and skips a report
+// while the previous one is on its way, so that a slow orchestrator never
+// holds up the transfer.
+func (c *inboundConn) reportProgress(in *inbound) {
+	if c.cfg.orchestrator == nil || time.Since(in.reported) < reportInterval {
+		return
+	}
+	if !in.reporting.CompareAndSwap(false, true) {
+		return
+	}
+	in.reported = time.Now()
+	received := in.received
+	in.reports.Add(1)
+	go func() {
+		defer in.reports.Done()
+		defer in.reporting.Store(false)
+		c.update(in, orchestrator.SessionUpdate{BytesReceived: &received})
+	}()
+}
+
+// reportSession tells the orchestrator, if there is one, the bytes received
+// for in and, if it was delivered, that it completed. The sender reports the
+// rest of the lifecycle; sessions it did not report there are skipped.
+func (c *inboundConn) reportSession(in *inbound) {
+	if c.cfg.orchestrator == nil {
+		return
+	}
+	received := in.received
+	u := orchestrator.SessionUpdate{BytesReceived: &received}
+	if in.delivered {
+		completed := models.SessionStatusCompleted
+		u.Status = &completed
+	}
+	go func() {
+		// A progress report still on its way must not land after this one.
+		in.reports.Wait()
+		c.update(in, u)
+	}()
+}
+
+// update sends u for in to the orchestrator, under the sender's session ID.
+func (c *inboundConn) update(in *inbound, u orchestrator.SessionUpdate) {
+	id := in.sess.File.SenderSession
+	if id == "" {
+		id = in.sess.ID
+	}
+	if _, err := c.cfg.orchestrator.UpdateSession(id, u); err != nil && !errors.Is(err, client.ErrNotFound) {
+		log.Printf("Session %s: report to orchestrator: %v", in.sess.ID, err)
+	}
+}
+
+// close ends every session of the connection, in the order they were
+// opened: a requested receipt is answered, and the session completes if it
+// was delivered and fails otherwise.
+func (c *inboundConn) close() {
+	for _, in := range c.order {
+		c.end(in)
+	}
+}
+
+func (c *inboundConn) end(in *inbound) {
+	sess, cfg := in.sess, c.cfg
+	if cfg.active.remove(sess.ID) && !in.delivered {
+		in.failure = errCancelled.Error()
+	}
+	if in.controllable {
+		cfg.controls.remove(sess.ID)
+	}
+	// An output written in place that was never finalized, e.g. because
+	// the connection dropped, is closed; a resume prepares it again.
+	if in.prepared {
+		if err := c.recv.DiscardOutput(sess.ID); err != nil {
+			log.Printf("Session %s: %v", sess.ID, err)
+		}
+	}
+	if in.receiptReq != nil {
+		// Only a file checked against the sender's hash is signed for.
+		why := in.failure
+		if why == "" && !in.verified {
+			why = cmp.Or(in.note, "the file was not verified against its hash")
+		}
+		cfg.receipts.answer(c.conn, sess, *in.receiptReq, why)
+	}
+	status := models.SessionStatusFailed
+	if in.delivered {
+		status = models.SessionStatusCompleted
+	}
+	if err := c.sessMgr.SetStatus(sess.ID, status); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	if cfg.onSession != nil {
+		var err error
+		if !in.delivered {
+			err = errors.New(cmp.Or(in.failure, "connection closed before the file was delivered"))
+		}
+		cfg.onSession(sess, in.delivery, err)
+	}
+	if in.admitted {
+		cfg.reservations.Done(sess.ID, in.delivered)
+	}
+	if in.delivered {
+		cfg.chunkmap.Promote(sess.ID, chunkstate.Acked, chunkstate.Stored)
+	}
+	// Other receivers fetch held files by hash, so only a file that
+	// matched it is offered.
+	if in.verified {
+		cfg.files.record(sess.File, in.delivery)
+	}
+	c.runHook(in)
+	c.writeReport(in)
+	c.reportSession(in)
+	cfg.metrics.SessionEnded()
+	cfg.chunkmap.Finish(sess.ID)
+	cfg.claims.release(sess.ID)
+	if in.output != "" {
+		cfg.outputs.release(in.output)
+	}
+	in.base.close()
+}
@@ -0,0 +1,310 @@
+package receive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/catalog"
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/hooks"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// newTestConn returns a connection receiving into a temp directory, with
+// cfg's zero fields set as the receive command sets them, and the sender's
+// end of it.
+func newTestConn(t *testing.T, cfg receiverConfig) (*inboundConn, net.Conn) {
+	t.Helper()
+	dir := t.TempDir()
+	recv, err := transport.NewTCPReceiver(filepath.Join(dir, "out"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("create receiver: %v", err)
+	}
+	sessMgr, err := session.NewSessionManager(filepath.Join(dir, "sessions"))
+	if err != nil {
+		t.Fatalf("create session manager: %v", err)
+	}
+	if cfg.receipts == nil {
+		key, err := receipt.LoadOrCreateKey(filepath.Join(dir, "receipt.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.receipts = &receiptSigner{key: key, node: "test"}
+	}
+	cfg.telemetry = telemetry.NewTelemetryCollector()
+	cfg.timeouts = timeouts.Defaults()
+	cfg.claims, cfg.outputs = newClaimSet(), newClaimSet()
+	cfg.chunkmap = chunkstate.NewTracker(nil)
+
+	sender, conn := net.Pipe()
+	t.Cleanup(func() { sender.Close(); conn.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newInboundConn(ctx, cancel, conn, recv, sessMgr, cfg), sender
+}
+
+// storeSession opens a session on c for data, claiming hash for the whole
+// file, and stores its chunks of chunkSize bytes as they would arrive.
+func storeSession(t *testing.T, c *inboundConn, data []byte, hash string, chunkSize int) *inbound {
+	t.Helper()
+	sess, err := c.sessMgr.CreateSession(models.FileMetadata{Name: "data.bin", Size: int64(len(data)), Hash: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(data); off += chunkSize {
+		part := data[off:min(off+chunkSize, len(data))]
+		sum := sha256.Sum256(part)
+		meta := &models.ChunkMetadata{
+			ID:        strconv.Itoa(off / chunkSize),
+			Size:      int64(len(part)),
+			Offset:    int64(off),
+			SHA256:    hex.EncodeToString(sum[:]),
+			SessionID: sess.ID,
+			Status:    models.ChunkStatusCompleted,
+		}
+		if _, err := c.recv.StoreChunkStream(c.ctx, sess.ID, meta, bytes.NewReader(part)); err != nil {
+			t.Fatal(err)
+		}
+		sess.Chunks[meta.ID] = meta
+	}
+	in := &inbound{tag: sess.ID, sess: sess, failure: "transfer did not complete", started: time.Now()}
+	c.order = append(c.order, in)
+	return in
+}
+
+// fileHash returns the hex SHA-256 of data.
+func fileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awaitReceiptReply ends the sessions of c and returns the receipt reply
+// the sender reads on sender, once the sessions ended.
+func awaitReceiptReply(t *testing.T, c *inboundConn, sender net.Conn) transport.ReceiptReply {
+	t.Helper()
+	closed := make(chan struct{})
+	go func() {
+		c.close()
+		close(closed)
+	}()
+	payload, meta, err := (&transport.TCPReceiver{}).Receive(context.Background(), sender)
+	if err != nil || meta.ID != transport.ReceiptFrameID {
+		t.Fatalf("receipt frame: %v, %v", meta, err)
+	}
+	var reply transport.ReceiptReply
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		t.Fatalf("decode receipt reply: %v", err)
+	}
+	<-closed
+	return reply
+}
+
+func TestReceiptOnlyForVerifiedFile(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift receipt "), 100)
+	for _, tc := range []struct {
+		name, hash string
+		signed     bool
+	}{
+		{"matching hash", fileHash(data), true},
+		{"wrong hash", fileHash([]byte("something else")), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, sender := newTestConn(t, receiverConfig{})
+			in := storeSession(t, c, data, tc.hash, 512)
+			in.receiptReq = &transport.ReceiptRequest{SessionID: "sender-1"}
+			c.finish(in)
+			if in.delivered != tc.signed || in.verified != tc.signed {
+				t.Fatalf("delivered %v, verified %v (%s)", in.delivered, in.verified, in.failure)
+			}
+
+			reply := awaitReceiptReply(t, c, sender)
+			if !tc.signed {
+				if reply.Receipt != nil || reply.Error == "" {
+					t.Fatalf("reply %+v for a file that did not match its hash", reply)
+				}
+				if _, err := os.Stat(c.recv.Destination(in.sess)); !os.IsNotExist(err) {
+					t.Fatalf("unverified file left at the destination: %v", err)
+				}
+				return
+			}
+			if reply.Receipt == nil || reply.Receipt.FileHash != tc.hash {
+				t.Fatalf("reply %+v, want a receipt for %s", reply, tc.hash)
+			}
+			got, err := os.ReadFile(in.delivery)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("delivered file differs: %v", err)
+			}
+		})
+	}
+}
+
+func TestCatalogHoldsOnlyVerifiedFiles(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift catalog "), 100)
+	for _, tc := range []struct {
+		name, hash string
+		// unverify drops the verification after delivery, as for a tus
+		// upload.
+		unverify bool
+		held     bool
+	}{
+		{"matching hash", fileHash(data), false, true},
+		{"wrong hash", fileHash([]byte("something else")), false, false},
+		{"delivered unverified", fileHash(data), true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			held, err := catalog.Open(filepath.Join(t.TempDir(), "catalog.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, _ := newTestConn(t, receiverConfig{files: &fileServer{catalog: held}})
+			in := storeSession(t, c, data, tc.hash, 512)
+			c.finish(in)
+			if tc.unverify {
+				in.verified = false
+			}
+			c.close()
+			if got := len(held.Hashes()); (got > 0) != tc.held {
+				t.Fatalf("catalog holds %d files, want held %v", got, tc.held)
+			}
+			if tc.held {
+				if e, err := held.Lookup(tc.hash); err != nil || e.Path != in.delivery {
+					t.Fatalf("catalog entry %+v, %v", e, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHookVerifiedFromHash(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift hook "), 100)
+	payloads := make(chan hooks.Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p hooks.Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		payloads <- p
+	}))
+	defer srv.Close()
+	hook := hooks.Parse(srv.URL, 0)
+
+	for _, tc := range []struct {
+		name string
+		// note marks the delivery unverified, as for a tus upload.
+		note     string
+		verified bool
+	}{
+		{"hashed", "", true},
+		{"noted", "no checksum", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := newTestConn(t, receiverConfig{onComplete: hook})
+			in := storeSession(t, c, data, fileHash(data), 512)
+			c.finish(in)
+			if tc.note != "" {
+				in.verified, in.note = false, tc.note
+			}
+			c.close()
+			select {
+			case p := <-payloads:
+				if p.Event != hooks.Complete || p.Verified != tc.verified {
+					t.Fatalf("hook got %s with verified %v, want %v", p.Event, p.Verified, tc.verified)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("hook did not run")
+			}
+		})
+	}
+}
+
+func TestReportVerificationFromHash(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift report "), 100)
+	for _, tc := range []struct {
+		name, hash, note     string
+		status, verification string
+	}{
+		{"hashed", fileHash(data), "", report.StatusCompleted, report.Verified},
+		{"noted", fileHash(data), "no checksum", report.StatusCompleted, report.Unverified},
+		{"wrong hash", fileHash([]byte("something else")), "", report.StatusFailed, report.Failed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "reports.jsonl")
+			reports, err := report.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reports.Close()
+			c, _ := newTestConn(t, receiverConfig{reports: reports})
+			in := storeSession(t, c, data, tc.hash, 512)
+			c.finish(in)
+			if tc.note != "" {
+				in.verified, in.note = false, tc.note
+			}
+			c.close()
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var s report.Summary
+			if err := json.Unmarshal(raw, &s); err != nil {
+				t.Fatalf("decode report: %v", err)
+			}
+			if s.Status != tc.status || s.Verification != tc.verification || s.Note != tc.note {
+				t.Fatalf("report %+v", s)
+			}
+		})
+	}
+}
+
+func TestChunkStoreDeliveryNoted(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift chunks "), 100)
+	store, err := transport.NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	reports, err := report.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reports.Close()
+	c, sender := newTestConn(t, receiverConfig{store: store, reports: reports})
+	in := storeSession(t, c, data, fileHash(data), 512)
+	in.receiptReq = &transport.ReceiptRequest{SessionID: "sender-1"}
+	c.finish(in)
+	if !in.delivered || in.failure != "" || in.note == "" {
+		t.Fatalf("delivered %v, failure %q, note %q", in.delivered, in.failure, in.note)
+	}
+
+	// Nothing failed, but without a whole-file check there is no receipt.
+	if reply := awaitReceiptReply(t, c, sender); reply.Receipt != nil || reply.Error != in.note {
+		t.Fatalf("reply %+v", reply)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s report.Summary
+	if err := json.Unmarshal(raw, &s); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if s.Status != report.StatusCompleted || s.Error != "" || s.Verification != report.Unverified || s.Note != in.note {
+		t.Fatalf("report %+v", s)
+	}
+}
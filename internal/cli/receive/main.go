@@ -0,0 +1,1006 @@
+// Package receive implements the trackshift receive command, which accepts
+// and stores transfers.
+package receive
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/authtoken"
+	"github.com/deb2000-sudo/trackshift/internal/catalog"
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/coldstore"
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/hooks"
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/manifest"
+	"github.com/deb2000-sudo/trackshift/internal/objstore"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/internal/reservation"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/internal/xattr"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// Main runs the receive command with args, the arguments after its name.
+func Main(args []string) {
+	fs := cli.NewFlagSet("receive")
+	port := fs.Int("port", 8080, "listening port")
+	listenHost := fs.String("listen", "", "address to listen on, e.g. 192.0.2.10 or ::1 (default all addresses)")
+	family := fs.String("family", transport.FamilyDual, "address family to accept connections over: dual (IPv4 and IPv6), ipv4 or ipv6")
+	outputDir := fs.String("output-dir", "received", "output directory for completed files")
+	tempDir := fs.String("temp-dir", "", "temporary directory for chunk storage")
+	sessionDir := fs.String("sessions-dir", "sessions", "session state directory")
+	sessionStore := fs.String("session-store", session.StoreJSON, "session state backend: json (one file per session) or bolt (one database, incremental chunk updates)")
+	protocolFlag := fs.String("protocol", "tcp", "transport protocol: tcp or udp")
+	storeMode := fs.String("store-mode", "assemble", "how to store received data: assemble (temp chunks joined at the end), direct (write chunks in place into a preallocated output file), chunks (chunk store layout, no assembly) or s3 (upload chunks as the parts of an object under -s3-url, nothing stored locally)")
+	s3URL := fs.String("s3-url", "", "with -store-mode s3, the bucket and key prefix objects are stored under: s3://bucket[/prefix]; credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Endpoint := fs.String("s3-endpoint", "", "URL of an S3-compatible service, e.g. http://127.0.0.1:9000 for MinIO (default AWS)")
+	s3Region := fs.String("s3-region", "", "region of the -s3-url bucket (default $AWS_REGION, then us-east-1)")
+	tusAddr := fs.String("tus-addr", "", "also accept uploads from tus clients (resumable HTTP uploads, as browsers make with tus-js-client or Uppy) at /files/ on this address, e.g. :1080; they are received like transfers from senders (-store-mode assemble)")
+	importDir := fs.String("import-dir", "", "import a session from a chunk directory (chunks + index.json), assemble it into output-dir and exit")
+	eventLog := fs.String("event-log", "", "write protocol events as NDJSON to this file (analyse with eventstat)")
+	autoExtract := fs.Bool("auto-extract", false, "unpack tar archives sent with -dir-mode tar into output-dir and remove the archive")
+	restoreXattrs := fs.Bool("xattrs", true, "restore extended attributes sent by the sender")
+	xattrInclude := fs.String("xattr-include", "", "comma-separated attribute name patterns to restore (default all)")
+	xattrExclude := fs.String("xattr-exclude", strings.Join(xattr.DefaultExclude, ","), "comma-separated attribute name patterns never to restore")
+	controlAddr := fs.String("control-addr", "", "serve the transfer control API (following, throttling and cancelling in-flight transfers, draining the receiver) on this address, e.g. 127.0.0.1:9091")
+	controlToken := fs.String("control-token", "", "bearer token required by control API requests that change anything, i.e. all but GET (default $TRACKSHIFT_CONTROL_TOKEN); without one -control-addr must be a loopback address")
+	readOnly := fs.Bool("read-only", false, "only serve previously received files to other receivers through the control API; accept no transfers (needs -control-addr)")
+	orchestratorURL := fs.String("orchestrator", "", "register the files held here with the orchestrator at this URL so it can route transfer requests to this node, and report each session received to it")
+	orchestratorToken := fs.String("orchestrator-token", "", "API token with the client scope for an -orchestrator that requires one (default $TRACKSHIFT_ORCHESTRATOR_TOKEN)")
+	nodeID := fs.String("node-id", "", "node ID for orchestrator registration (default hostname)")
+	rendezvousID := fs.String("rendezvous", "", "let senders behind NATs reach this receiver by UDP hole punching, registering under this rendezvous ID with the -orchestrator")
+	region := fs.String("region", "", "region of this node, used by the orchestrator to pick the nearest source")
+	advertiseURL := fs.String("advertise-url", "", "control API URL the orchestrator should use (default http://<control-addr>)")
+	archiveDir := fs.String("archive-dir", "", "after verification, copy each received file into this directory (e.g. an LTFS tape mount), read it back and record the checksum chain")
+	archiveExec := fs.String("archive-exec", "", "after verification, stream each received file into this shell command's stdin and record the checksum chain")
+	onComplete := fs.String("on-complete", "", "when a session is delivered and verified, run this shell command with the session as JSON on stdin, or POST the JSON to this http(s) URL")
+	onFailure := fs.String("on-failure", "", "when a session fails, run this shell command or POST to this URL, as -on-complete")
+	hookTimeout := fs.Duration("hook-timeout", hooks.DefaultTimeout, "how long an -on-complete or -on-failure hook may run, including webhook retries")
+	reportFile := fs.String("report-file", "", "as each session ends, append a JSON summary (bytes, throughput, retransmits, rejected chunks, verification) to this file, or - for stdout")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address, e.g. 127.0.0.1:9102")
+	allow := fs.String("allow", "", "comma-separated CIDRs allowed to connect (default all); replaceable at runtime through the control API")
+	deny := fs.String("deny", "", "comma-separated CIDRs refused, even if allowed")
+	var tokenList []authtoken.Token
+	fs.Func("auth-token", "require senders to present a token: SECRET[,max-size=SIZE][,subdir=DIR][,name=NAME]; repeat for several", func(spec string) error {
+		t, err := authtoken.Parse(spec)
+		tokenList = append(tokenList, t)
+		return err
+	})
+	authTokenFile := fs.String("auth-token-file", "", "file of -auth-token specs, one per line, keeping them out of process listings")
+	bandwidthCapacity := fs.String("bandwidth-capacity", "", "bandwidth that reservations' slots may add up to, e.g. 1GB/s or 10gbit (default unchecked)")
+	onExists := fs.String("on-exists", string(transport.ExistsRename), "when a received file's destination exists: rename (write beside it as name.1.ext), overwrite, or fail (refuse the transfer)")
+	receiptKey := fs.String("receipt-key", "", "Ed25519 key signing delivery receipts, created with its public key in <path>.pub if missing (default <sessions-dir>/receipt.key)")
+	var timeoutCfg timeouts.Config
+	fs.Func("timeouts", "socket timeouts, e.g. read=1m (off disables one)", timeoutCfg.Merge)
+	fs.Parse(args)
+
+	store, err := session.OpenStore(*sessionStore, *sessionDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sessMgr, err := session.NewSessionManagerWithStore(store)
+	if err != nil {
+		log.Fatalf("create session manager: %v", err)
+	}
+	defer sessMgr.Close()
+
+	var restore *xattr.Filter
+	if *restoreXattrs {
+		f, err := xattr.ParseFilter(*xattrInclude, *xattrExclude)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		restore = &f
+	}
+
+	tcpNetwork, err := transport.Network("tcp", *family)
+	if err != nil {
+		log.Fatalf("-family: %v", err)
+	}
+	udpNetwork, _ := transport.Network("udp", *family)
+	listenAddr := net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(*listenHost, "["), "]"), strconv.Itoa(*port))
+
+	if (*readOnly || *orchestratorURL != "") && *controlAddr == "" {
+		log.Fatalf("-read-only and -orchestrator need -control-addr")
+	}
+	// The control API cancels sessions, replaces -allow/-deny and sends
+	// held files on; other hosts may only reach it with a token.
+	if *controlAddr != "" && *controlToken == "" && !cli.Loopback(*controlAddr) {
+		log.Fatalf("-control-addr %s accepts other hosts; give -control-token, or listen on a loopback address", *controlAddr)
+	}
+	held, err := catalog.Open(filepath.Join(*sessionDir, "catalog", "catalog.json"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	files := &fileServer{catalog: held, timeouts: timeoutCfg}
+	var orch *client.OrchestratorClient
+	if *orchestratorURL != "" {
+		orch = client.NewOrchestratorClientWithTimeouts(*orchestratorURL, timeoutCfg.For(*orchestratorURL))
+		orch.Token = *orchestratorToken
+		if orch.Token == "" {
+			orch.Token = os.Getenv("TRACKSHIFT_ORCHESTRATOR_TOKEN")
+		}
+		files.registrar = &nodeRegistrar{
+			client:  orch,
+			catalog: held,
+			node: orchestrator.NodeInfo{
+				ID:           *nodeID,
+				ControlURL:   *advertiseURL,
+				ControlToken: *controlToken,
+				Region:       *region,
+			},
+		}
+		if files.registrar.node.ID == "" {
+			files.registrar.node.ID, _ = os.Hostname()
+		}
+		if files.registrar.node.ControlURL == "" {
+			files.registrar.node.ControlURL = defaultControlURL(*controlAddr)
+		}
+	}
+	if *rendezvousID != "" {
+		if orch == nil {
+			log.Fatalf("-rendezvous registers with the orchestrator; give its URL with -orchestrator")
+		}
+		go awaitSenders(orch, *rendezvousID, udpNetwork, listenAddr)
+	}
+
+	var archive coldstore.Target
+	switch {
+	case *archiveDir != "" && *archiveExec != "":
+		log.Fatalf("-archive-dir and -archive-exec are mutually exclusive")
+	case *archiveDir != "":
+		archive = coldstore.DirTarget{Dir: *archiveDir}
+	case *archiveExec != "":
+		archive = coldstore.ExecTarget{Command: *archiveExec}
+	}
+
+	if *importDir != "" {
+		runImport(*importDir, *outputDir, sessMgr, *autoExtract, restore, files, archive)
+		return
+	}
+
+	filter, err := ipfilter.New(ipfilter.Rules{Allow: ipfilter.ParseList(*allow), Deny: ipfilter.ParseList(*deny)})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if *authTokenFile != "" {
+		more, err := authtoken.LoadFile(*authTokenFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		tokenList = append(tokenList, more...)
+	}
+	tokens, err := authtoken.NewSet(tokenList)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if tokens != nil {
+		log.Printf("Senders must present one of %d auth tokens", len(tokenList))
+	}
+
+	if *receiptKey == "" {
+		*receiptKey = filepath.Join(*sessionDir, "receipt.key")
+	}
+	key, err := receipt.LoadOrCreateKey(*receiptKey)
+	if err != nil {
+		log.Fatalf("receipt key: %v", err)
+	}
+	signer := &receiptSigner{key: key, node: *nodeID}
+	if signer.node == "" {
+		signer.node, _ = os.Hostname()
+	}
+	if files.registrar != nil {
+		signer.orch = files.registrar.client
+	}
+	log.Printf("Receipts signed with key %s (public key in %s.pub)", receipt.Fingerprint(key.Public().(ed25519.PublicKey)), *receiptKey)
+
+	cfg := receiverConfig{
+		telemetry:   telemetry.NewTelemetryCollector(),
+		autoExtract: *autoExtract,
+		xattrs:      restore,
+		timeouts:    timeoutCfg.Default.WithDefaults(),
+		files:       files,
+		archive:     archive,
+		onComplete:  hooks.Parse(*onComplete, *hookTimeout),
+		onFailure:   hooks.Parse(*onFailure, *hookTimeout),
+		filter:      filter,
+		tokens:      tokens,
+		receipts:    signer,
+		claims:      newClaimSet(),
+		outputs:     newClaimSet(),
+
+		orchestrator: orch,
+	}
+	if *eventLog != "" {
+		if cfg.events, err = eventlog.Open(*eventLog); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer cfg.events.Close()
+	}
+	cfg.chunkmap = chunkstate.NewTracker(cfg.events)
+	if *reportFile != "" {
+		if cfg.reports, err = report.Open(*reportFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer cfg.reports.Close()
+	}
+	if *metricsAddr != "" {
+		cfg.metrics = telemetry.NewMetrics()
+		go func() {
+			if err := cfg.metrics.Serve(*metricsAddr); err != nil {
+				log.Fatalf("metrics endpoint: %v", err)
+			}
+		}()
+		log.Printf("Serving metrics on http://%s/metrics", *metricsAddr)
+	}
+	recv, err := transport.NewTCPReceiver(*outputDir, *tempDir)
+	if err != nil {
+		log.Fatalf("create receiver: %v", err)
+	}
+	recv.Timeouts = cfg.timeouts
+	if recv.OnExists, err = transport.ParseExistsPolicy(*onExists); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *controlAddr != "" {
+		mux := http.NewServeMux()
+		files.registerRoutes(mux)
+		if !*readOnly {
+			mux.Handle("/api/v1/ipfilter", filter.Handler())
+			cfg.controls = newRateControls()
+			cfg.controls.registerRoutes(mux)
+			cfg.active = newActiveSessions()
+			cfg.active.registerRoutes(mux)
+			(&outputPrefixes{recv: recv}).registerRoutes(mux)
+			(&chunkMaps{tracker: cfg.chunkmap}).registerRoutes(mux)
+			capacity, err := ratelimit.ParseRate(*bandwidthCapacity)
+			if err != nil {
+				log.Fatalf("-bandwidth-capacity: %v", err)
+			}
+			cfg.reservations = reservation.NewLedger(*outputDir, capacity)
+			mux.Handle("/api/v1/reservations", cfg.reservations.Handler())
+			mux.Handle("/api/v1/reservations/", cfg.reservations.Handler())
+		}
+		var handler http.Handler = mux
+		if *controlToken != "" {
+			handler = cli.RequireToken(*controlToken, mux)
+		}
+		serveControl := func() {
+			log.Printf("Control API listening on %s", *controlAddr)
+			if err := http.ListenAndServe(*controlAddr, handler); err != nil {
+				log.Fatalf("control API: %v", err)
+			}
+		}
+		if files.registrar != nil {
+			go files.registrar.run()
+		}
+		if *readOnly {
+			log.Printf("Read-only mode: serving %d held files, not accepting transfers", len(held.Hashes()))
+			serveControl()
+			return
+		}
+		go serveControl()
+	}
+	switch *storeMode {
+	case "assemble":
+	case "direct":
+		cfg.direct = true
+	case "chunks":
+		cfg.store, err = transport.NewChunkStore(*outputDir)
+		if err != nil {
+			log.Fatalf("create chunk store: %v", err)
+		}
+	case "s3":
+		if *s3URL == "" {
+			log.Fatalf("-store-mode s3 needs -s3-url")
+		}
+		if cfg.objects, err = objstore.NewS3(*s3URL, *s3Endpoint, *s3Region, objstore.EnvCredentials()); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("Storing received files as objects in %s", cfg.objects.Name())
+	default:
+		log.Fatalf("unknown store mode %q", *storeMode)
+	}
+	if *s3URL != "" && cfg.objects == nil {
+		log.Fatalf("-s3-url needs -store-mode s3")
+	}
+	if *tusAddr != "" && *storeMode != "assemble" {
+		log.Fatalf("-tus-addr needs -store-mode assemble")
+	}
+
+	if cfg.store == nil && !cfg.direct && cfg.objects == nil {
+		recoverSessions(recv, sessMgr)
+	}
+
+	if *tusAddr != "" {
+		go func() {
+			if err := serveTus(tcpNetwork, *tusAddr, recv, sessMgr, cfg); err != nil {
+				log.Fatalf("tus uploads: %v", err)
+			}
+		}()
+	}
+
+	switch *protocolFlag {
+	case "tcp":
+		runTCPReceiver(tcpNetwork, listenAddr, recv, sessMgr, cfg)
+	case "udp":
+		log.Println("UDP receiver mode not yet implemented; starting TCP receiver instead")
+		runTCPReceiver(tcpNetwork, listenAddr, recv, sessMgr, cfg)
+	default:
+		log.Fatalf("unknown protocol %q", *protocolFlag)
+	}
+}
+
+// receiverConfig holds per-connection settings derived from flags.
+type receiverConfig struct {
+	store     *transport.ChunkStore // non-nil in chunks mode
+	objects   objstore.Backend      // non-nil in s3 mode
+	direct    bool                  // write chunks in place into the output file
+	telemetry *telemetry.TelemetryCollector
+	// metrics, if non-nil, counts chunks and sessions for the metrics
+	// endpoint.
+	metrics *telemetry.Metrics
+	// reports, if non-nil, receives a summary of each session as it ends.
+	reports *report.Writer
+
+	// autoExtract unpacks tar archives generated by the sender.
+	autoExtract bool
+	// xattrs selects the extended attributes to restore; nil restores none.
+	xattrs *xattr.Filter
+	// events, if non-nil, receives an event per chunk received or rejected.
+	events *eventlog.Logger
+	// timeouts bounds how long a connection may stall mid-session.
+	timeouts timeouts.Set
+	// controls, if non-nil, lets the control API throttle in-flight senders.
+	controls *rateControls
+	// active, if non-nil, lets the control API follow and cancel the
+	// sessions being received and drain the receiver.
+	active *activeSessions
+	// files records verified files so they can be served to other receivers.
+	files *fileServer
+	// archive, if non-nil, receives a copy of every verified file.
+	archive coldstore.Target
+	// onComplete and onFailure, if non-nil, are run as each session ends,
+	// delivered or not.
+	onComplete *hooks.Hook
+	onFailure  *hooks.Hook
+	// filter refuses connections from peers outside the allowed networks.
+	filter *ipfilter.Filter
+	// tokens, if non-nil, are the auth tokens senders must present before
+	// their first session.
+	tokens *authtoken.Set
+	// receipts signs delivery receipts for senders that request them.
+	receipts *receiptSigner
+	// claims holds the sessions being received, so a session is resumed by
+	// one connection at a time.
+	claims *claimSet
+	// outputs holds the destinations of the sessions being received, so
+	// two sessions never write to the same path.
+	outputs *claimSet
+	// reservations, if non-nil, admits sessions against the disk capacity
+	// and bandwidth reserved through the control API.
+	reservations *reservation.Ledger
+	// chunkmap tracks the state of every chunk of the sessions received.
+	chunkmap *chunkstate.Tracker
+	// orchestrator, if non-nil, is told the bytes received and delivery
+	// of each session its sender reported there.
+	orchestrator *client.OrchestratorClient
+	// onProgress and onSession, if non-nil, are told the bytes received
+	// for each session as its chunks are stored, and how it ended; see
+	// Options.
+	onProgress func(sess *models.TransferSession, received int64)
+	onSession  func(sess *models.TransferSession, output string, err error)
+}
+
+func runTCPReceiver(network, addr string, recv *transport.TCPReceiver, sessMgr *session.SessionManager, cfg receiverConfig) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatalf("listen on %s: %v", addr, err)
+	}
+	ln = cfg.filter.Listener(ln)
+	defer ln.Close()
+	cfg.active.serve(ln)
+
+	log.Printf("Receiver listening on %s (%s)", ln.Addr(), network)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if cfg.active.isDraining() {
+				<-cfg.active.idle
+				log.Printf("Drained: no transfers left in flight, exiting")
+				return
+			}
+			log.Printf("accept error: %v", err)
+			continue
+		}
+		if !cfg.active.connOpened() {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer cfg.active.connClosed()
+			handleConnection(context.Background(), conn, recv, sessMgr, cfg)
+		}()
+	}
+}
+
+// handleConnection receives the sessions sent on conn, usually one. Frames
+// are matched to their session as described on inboundConn. Depending on
+// cfg, chunks are staged and assembled at the end, written in place, or kept
+// in a chunk store. Once ctx is done, or a session is cancelled through the
+// control API, reads and writes on conn fail and the sessions end
+// unfinished.
+func handleConnection(ctx context.Context, conn net.Conn, recv *transport.TCPReceiver, sessMgr *session.SessionManager, cfg receiverConfig) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer conn.Close()
+	defer cfg.events.Flush()
+
+	// Sessions are created on their file metadata frame and end with the
+	// connection.
+	c := newInboundConn(ctx, cancel, conn, recv, sessMgr, cfg)
+	defer c.close()
+
+	for {
+		meta, data, err := recv.ReceiveStream(ctx, conn)
+		receivedAt := time.Now()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if opErr, ok := err.(net.Error); ok && !opErr.Temporary() {
+				log.Printf("connection closed: %v", err)
+				break
+			}
+			log.Printf("receive error: %v", err)
+			break
+		}
+
+		if meta.ID == transport.AuthFrameID {
+			if !c.authenticate(data) {
+				return
+			}
+			continue
+		}
+		if cfg.tokens != nil && c.token == nil {
+			log.Printf("rejecting sender %s: no auth token", conn.RemoteAddr())
+			return
+		}
+
+		// Handle file metadata control frame
+		if meta.ID == "__filemeta__" {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read file metadata frame: %v", err)
+				return
+			}
+			var fileMeta models.FileMetadata
+			if err := json.Unmarshal(payload, &fileMeta); err != nil {
+				log.Printf("invalid file metadata frame: %v", err)
+				return
+			}
+			version, err := protocol.NegotiateVersion(protocol.CurrentVersion, fileMeta.ProtocolVersion)
+			if err != nil {
+				log.Printf("rejecting sender: %v", err)
+				return
+			}
+			name, err := transport.SafeName(fileMeta.Name)
+			if err != nil {
+				log.Printf("rejecting sender %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			if name != fileMeta.Name {
+				log.Printf("File name %q from %s re-rooted to %q", fileMeta.Name, conn.RemoteAddr(), name)
+				fileMeta.Name = name
+			}
+			tag := meta.SessionID
+			if tag == "" {
+				tag = fileMeta.SenderSession
+			}
+			if _, ok := c.tags[tag]; ok && tag != "" {
+				log.Printf("session %s opened twice on one connection", tag)
+				return
+			}
+			if _, err := c.open(tag, fileMeta, version); err != nil {
+				log.Printf("create session: %v", err)
+				return
+			}
+			// Senders announcing v12 or later wait to hear which version
+			// to speak.
+			if protocol.SupportsVersionReply(fileMeta.ProtocolVersion) {
+				err := c.conn.send(func(ctx context.Context, conn net.Conn) error {
+					return transport.NewTCPSender().SendVersion(ctx, conn, version)
+				})
+				if err != nil {
+					log.Printf("answer file metadata frame: %v", err)
+					return
+				}
+			}
+			continue
+		}
+
+		in := c.session(meta.SessionID)
+
+		if meta.ID == transport.ManifestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read manifest frame: %v", err)
+				return
+			}
+			if in == nil {
+				log.Printf("received manifest before file metadata; dropping")
+				continue
+			}
+			tree, err := transport.DecodeManifest(payload)
+			if err != nil {
+				log.Printf("invalid manifest frame: %v", err)
+				return
+			}
+			sess := in.sess
+			sess.Manifest = tree
+			sess.Files = tree.Files()
+			if err := sess.ValidateFiles(); err != nil {
+				log.Printf("Session %s: manifest lists invalid files: %v", sess.ID, err)
+				sess.Files = nil
+			}
+			if err := sessMgr.SaveSession(sess); err != nil {
+				log.Printf("save session: %v", err)
+			}
+			continue
+		}
+
+		if meta.ID == transport.TimeSyncFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read time sync frame: %v", err)
+				return
+			}
+			var offset time.Duration
+			var done bool
+			err = c.conn.send(func(ctx context.Context, conn net.Conn) (err error) {
+				offset, done, err = recv.AnswerTimeSync(ctx, conn, payload, receivedAt)
+				return err
+			})
+			if err != nil {
+				log.Printf("time sync: %v", err)
+				return
+			}
+			if done {
+				// The sender measured our clock relative to theirs.
+				c.clockOffset = offset
+			}
+			continue
+		}
+
+		if meta.ID == transport.PauseFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read pause frame: %v", err)
+				return
+			}
+			ps, err := transport.DecodePause(payload)
+			if err != nil {
+				log.Printf("%v", err)
+				return
+			}
+			if in == nil {
+				continue
+			}
+			// Repeated pause frames keep the connection alive; only state
+			// changes are recorded.
+			status, verb := models.SessionStatusTransferring, "resumed"
+			if ps.Paused {
+				status, verb = models.SessionStatusPaused, "paused"
+			}
+			if in.sess.Status != status {
+				log.Printf("Session %s %s by sender", in.sess.ID, verb)
+				if err := sessMgr.SetStatus(in.sess.ID, status); err != nil {
+					log.Printf("save session: %v", err)
+				}
+			}
+			continue
+		}
+
+		if meta.ID == transport.ReceiptRequestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read receipt request frame: %v", err)
+				return
+			}
+			req, err := transport.DecodeReceiptRequest(payload)
+			if err != nil {
+				log.Printf("%v", err)
+				return
+			}
+			if in != nil {
+				in.receiptReq = &req
+			}
+			continue
+		}
+
+		if meta.ID == transport.ResumeRequestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read resume request frame: %v", err)
+				return
+			}
+			if _, err := transport.DecodeResumeRequest(payload); err != nil {
+				log.Printf("%v", err)
+				return
+			}
+			if in == nil {
+				log.Printf("rejecting resume request before file metadata")
+				return
+			}
+			err = c.conn.send(func(ctx context.Context, conn net.Conn) error {
+				return answerResume(ctx, conn, in.sess, in.resumed)
+			})
+			if err != nil {
+				log.Printf("resume request: %v", err)
+				return
+			}
+			continue
+		}
+
+		if meta.ID == transport.DeltaRequestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read delta request frame: %v", err)
+				return
+			}
+			req, err := transport.DecodeDeltaRequest(payload)
+			if err != nil || in == nil {
+				log.Printf("rejecting delta request: %v", err)
+				return
+			}
+			// A delta transfer updates the existing file, whatever the
+			// policy for existing files.
+			in.delta = true
+			if !c.authorize(in) {
+				return
+			}
+			err = c.conn.send(func(ctx context.Context, conn net.Conn) (err error) {
+				if cfg.objects != nil {
+					// Earlier versions are in the object store, not here
+					// to copy chunks from.
+					log.Printf("Session %s: delta transfers need a local earlier version; receiving in full", in.sess.ID)
+					return transport.NewTCPSender().SendDeltaBase(ctx, conn, transport.DeltaBase{})
+				}
+				in.base, err = answerDelta(ctx, conn, recv, in.sess, req)
+				return err
+			})
+			if err != nil {
+				log.Printf("delta request: %v", err)
+				return
+			}
+			continue
+		}
+
+		// A copy frame stands for a chunk the sender knows we hold in the
+		// delta base; it is stored from there like one that was sent.
+		if meta.ID == transport.CopyFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				log.Printf("read copy frame: %v", err)
+				return
+			}
+			cp, err := transport.DecodeCopy(payload)
+			if err != nil {
+				log.Printf("%v", err)
+				return
+			}
+			if in == nil || in.base == nil {
+				log.Printf("copy frame for chunk %s without a delta base", cp.Chunk.ID)
+				return
+			}
+			meta, data = &cp.Chunk, io.NewSectionReader(in.base.f, cp.BaseOffset, cp.Chunk.Size)
+		}
+
+		if in == nil {
+			if meta.SessionID != "" && len(c.order) > 0 {
+				log.Printf("received data chunk for unknown session %s; dropping", meta.SessionID)
+			} else {
+				log.Printf("received data chunk before file metadata; dropping")
+			}
+			if _, err := io.Copy(io.Discard, data); err != nil {
+				break
+			}
+			continue
+		}
+		sess := in.sess
+
+		if !c.authorize(in) {
+			return
+		}
+
+		// Senders read rate control frames once the handshake is over, i.e.
+		// from the first data chunk on.
+		if cfg.controls != nil && !in.controllable && protocol.SupportsRateControl(sess.ProtocolVersion) {
+			cfg.controls.add(sess.ID, c.conn, protocol.SupportsPrefetch(sess.ProtocolVersion))
+			in.controllable = true
+			// A reserved bandwidth slot caps the sender at its rate.
+			if in.reserved != nil && in.reserved.BytesPerSec > 0 {
+				if err := cfg.controls.setRate(sess.ID, in.reserved.BytesPerSec); err != nil {
+					log.Printf("Session %s: apply reserved bandwidth: %v", sess.ID, err)
+				}
+			}
+		}
+
+		// The output is prepared on the first data chunk, once a manifest
+		// frame (if any) has decided where the stream is written.
+		if cfg.direct && !in.prepared {
+			if _, err := recv.PrepareOutput(sess); err != nil {
+				log.Printf("prepare output: %v", err)
+				return
+			}
+			in.prepared = true
+		}
+
+		// Chunk data is verified against its hash while it is written.
+		cfg.chunkmap.Set(sess.ID, meta, chunkstate.InFlight)
+		switch {
+		case cfg.store != nil:
+			_, err = cfg.store.StoreChunkStream(ctx, sess.ID, meta, data)
+		case cfg.direct:
+			err = recv.StoreChunkAt(ctx, sess, meta, data)
+		case cfg.objects != nil:
+			err = c.storeObject(ctx, in, meta, data)
+		default:
+			_, err = recv.StoreChunkStream(ctx, sess.ID, meta, data)
+		}
+		if errors.Is(err, errPartRejected) {
+			log.Printf("store chunk %s: %v", meta.ID, err)
+			cfg.chunkmap.Set(sess.ID, meta, chunkstate.Pending)
+			cfg.metrics.ChunkFailed()
+			in.tally.ChunkFailed(meta.ID, meta.Offset, err)
+			continue
+		}
+		if errors.Is(err, transport.ErrChunkHashMismatch) {
+			log.Printf("hash mismatch for chunk %s", meta.ID)
+			cfg.chunkmap.Set(sess.ID, meta, chunkstate.Pending)
+			cfg.metrics.ChunkFailed()
+			in.tally.ChunkFailed(meta.ID, meta.Offset, err)
+			cfg.events.Log(eventlog.Event{
+				Type:    eventlog.EventRejected,
+				Session: sess.ID,
+				Chunk:   meta.ID,
+				Bytes:   meta.Size,
+				Cause:   eventlog.CauseHashMismatch,
+			})
+			continue
+		}
+		if err != nil {
+			// The frame may be partially consumed, so the stream is no longer usable.
+			log.Printf("store chunk %s: %v", meta.ID, err)
+			cfg.chunkmap.Set(sess.ID, meta, chunkstate.Pending)
+			cfg.metrics.ChunkFailed()
+			in.tally.ChunkFailed(meta.ID, meta.Offset, err)
+			break
+		}
+
+		event := eventlog.Event{
+			Type:    eventlog.EventReceived,
+			Session: sess.ID,
+			Chunk:   meta.ID,
+			Bytes:   meta.Size,
+			AppData: meta.AppData,
+		}
+		if !meta.SentAt.IsZero() {
+			owd := telemetry.OneWayDelay(meta.SentAt, receivedAt, c.clockOffset)
+			if cfg.telemetry != nil {
+				cfg.telemetry.RecordOneWayDelay(owd)
+			}
+			cfg.metrics.ObserveLatency(owd)
+			event.DurationMs = eventlog.Millis(owd)
+		}
+		cfg.events.Log(event)
+		cfg.chunkmap.Set(sess.ID, meta, chunkstate.Verified)
+
+		meta.SessionID = sess.ID
+		if sess.Chunks == nil {
+			sess.Chunks = make(map[string]*models.ChunkMetadata)
+		}
+		if prev, ok := sess.Chunks[meta.ID]; ok {
+			// A chunk received again keeps its status so it is not
+			// counted twice.
+			meta.Status = prev.Status
+			cfg.metrics.ChunkRetried()
+			in.tally.Retransmit()
+		}
+		cfg.metrics.ChunkCompleted()
+		cfg.metrics.BytesReceived(meta.Size)
+		in.received += meta.Size
+		sess.Chunks[meta.ID] = meta
+		cfg.active.update(sess.ID, in.received)
+
+		if err := sessMgr.UpdateChunkStatus(sess.ID, meta.ID, models.ChunkStatusCompleted); err != nil {
+			log.Printf("update chunk status: %v", err)
+		} else {
+			cfg.chunkmap.Set(sess.ID, meta, chunkstate.Acked)
+		}
+		recv.ChunkReceived(sess, meta)
+		if in.admitted {
+			cfg.reservations.Wrote(sess.ID, meta.Size)
+		}
+		c.reportProgress(in)
+		if cfg.onProgress != nil {
+			cfg.onProgress(sess, in.received)
+		}
+	}
+
+	for _, in := range c.order {
+		c.finish(in)
+	}
+}
+
+// runImport builds a session from an existing chunk directory and assembles
+// it into outputDir, verifying every chunk and the whole-file hash. The
+// session completes only once the assembled file matches; otherwise it is
+// marked failed and the assembled file removed.
+func runImport(dir, outputDir string, sessMgr *session.SessionManager, autoExtract bool, restore *xattr.Filter, files *fileServer, archive coldstore.Target) {
+	idx, err := transport.ReadChunkIndex(dir)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	if err := idx.Verify(dir); err != nil {
+		log.Fatalf("import: verify chunks: %v", err)
+	}
+	sess, err := sessMgr.ImportSession(idx.SessionID, idx.File, idx.ChunkMetadata())
+	if err != nil {
+		log.Fatalf("import: create session: %v", err)
+	}
+	if err := sessMgr.SetStatus(sess.ID, models.SessionStatusTransferring); err != nil {
+		log.Fatalf("import: save session: %v", err)
+	}
+
+	var outPath string
+	fail := func(format string, a ...any) {
+		if outPath != "" {
+			os.Remove(outPath)
+		}
+		if err := sessMgr.SetStatus(sess.ID, models.SessionStatusFailed); err != nil {
+			log.Printf("import: save session: %v", err)
+		}
+		log.Fatalf("import: "+format, a...)
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		fail("create output dir: %v", err)
+	}
+	name, err := transport.SafeName(sess.File.Name)
+	if err != nil {
+		fail("%v", err)
+	}
+	dest := ""
+	outPath = filepath.Join(outputDir, name)
+	if idx.Manifest != nil {
+		outPath = filepath.Join(outputDir, sess.ID+".stream")
+		dest = filepath.Join(outputDir, idx.Manifest.Root)
+	}
+	if err := transport.AssembleChunkDir(dir, idx, outPath); err != nil {
+		fail("assemble: %v", err)
+	}
+	hash, err := utils.HashFileSHA256(outPath)
+	if err != nil {
+		fail("hash output: %v", err)
+	}
+	if hash != sess.File.Hash {
+		fail("file hash mismatch: expected %s, got %s", sess.File.Hash, hash)
+	}
+	final, err := finishOutput(outPath, dest, idx.File, idx.Manifest, autoExtract, restore)
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := sessMgr.SetStatus(sess.ID, models.SessionStatusCompleted); err != nil {
+		log.Fatalf("import: save session: %v", err)
+	}
+	files.record(idx.File, final)
+	archiveOutput(archive, final, idx.File)
+	log.Printf("Imported session %s: %d chunks assembled at %s (%s)",
+		sess.ID, sess.TotalChunks, final, utils.HumanBytes(sess.File.Size))
+}
+
+// finishOutput turns the received stream at outPath into its final form: a
+// directory tree recreated at treeDest, an unpacked archive next to outPath
+// (with autoExtract), or a single file. Extended attributes selected by
+// restore are applied. It returns the final path.
+func finishOutput(outPath, treeDest string, file models.FileMetadata, tree *models.Manifest, autoExtract bool, restore *xattr.Filter) (string, error) {
+	switch {
+	case tree != nil:
+		if err := extractTree(outPath, treeDest, tree, restore); err != nil {
+			return "", fmt.Errorf("extract directory: %w", err)
+		}
+		return treeDest, nil
+	case autoExtract && file.Archive == models.ArchiveTar:
+		dest, err := extractArchive(outPath, filepath.Dir(outPath), restore)
+		if err != nil {
+			return "", fmt.Errorf("extract archive: %w", err)
+		}
+		return dest, nil
+	}
+	if restore != nil && len(file.Xattrs) > 0 {
+		if err := xattr.Write(outPath, restore.Select(file.Xattrs)); err != nil {
+			return "", fmt.Errorf("restore extended attributes: %w", err)
+		}
+	}
+	return outPath, nil
+}
+
+// archiveOutput hands the verified file at outPath off to target, if set,
+// and writes the checksum chain next to it as outPath.archive.json.
+// Directories, from directory transfers or extracted archives, are not
+// handed off. Failures are logged; the received copy is kept either way.
+func archiveOutput(target coldstore.Target, outPath string, file models.FileMetadata) {
+	if target == nil {
+		return
+	}
+	if fi, err := os.Stat(outPath); err != nil || fi.IsDir() {
+		log.Printf("Cold-storage handoff skipped: %s is not a regular file", outPath)
+		return
+	}
+	rec, err := coldstore.Handoff(target, outPath, file)
+	if err != nil {
+		log.Printf("Cold-storage handoff of %s: %v", outPath, err)
+		return
+	}
+	if err := rec.Write(outPath + ".archive.json"); err != nil {
+		log.Printf("write archive record: %v", err)
+		return
+	}
+	log.Printf("Archived %s to %s (%d checksums in chain)", outPath, rec.Target, len(rec.Chain))
+}
+
+// extractTree recreates a directory transfer at dest from the assembled
+// session stream at streamPath, then removes the stream.
+func extractTree(streamPath, dest string, tree *models.Manifest, restore *xattr.Filter) error {
+	if err := manifest.ExtractFile(streamPath, dest, tree, restore); err != nil {
+		return err
+	}
+	if err := os.Remove(streamPath); err != nil {
+		log.Printf("remove assembled stream: %v", err)
+	}
+	return nil
+}
+
+// extractArchive unpacks a tar archive generated by the sender into
+// outputDir, then removes the archive. It returns the path of the unpacked
+// directory.
+func extractArchive(archivePath, outputDir string, restore *xattr.Filter) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	err = manifest.ExtractTar(f, outputDir, restore)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(archivePath); err != nil {
+		log.Printf("remove archive: %v", err)
+	}
+	return filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(archivePath), ".tar")), nil
+}
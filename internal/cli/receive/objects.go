@@ -0,0 +1,111 @@
+package receive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/internal/objstore"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// errPartRejected marks a chunk the object store failed to take. Its frame
+// was read in full, so the connection goes on and the sender sends the
+// chunk again.
+var errPartRejected = errors.New("object store rejected the chunk")
+
+// objectKey returns the key sess is stored under: its destination relative
+// to the output directory, which places it in an auth token's subdirectory.
+func objectKey(recv *transport.TCPReceiver, sess *models.TransferSession) string {
+	dest := recv.Destination(sess)
+	if rel, err := filepath.Rel(recv.OutputDir, dest); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(dest)
+}
+
+// checkObject reports why sess cannot be stored as an object, if it cannot.
+func checkObject(sess *models.TransferSession) error {
+	if sess.Manifest != nil {
+		return errors.New("the receiver stores objects, not directory trees; send directories with -dir-mode tar")
+	}
+	return nil
+}
+
+// storeObject uploads a chunk of in as a part of its object, starting the
+// upload with the first chunk. Unless the chunk cannot be a part of the
+// object, its frame is read in full whatever happens.
+func (c *inboundConn) storeObject(ctx context.Context, in *inbound, meta *models.ChunkMetadata, data io.Reader) error {
+	// A chunk that cannot be a part would be refused however often it was
+	// sent, so it fails the session.
+	if _, err := objstore.PartNumber(meta.Offset, meta.Size, in.sess.File.Size); err != nil {
+		in.failure = fmt.Sprintf("%v; send with a larger -chunk-size", err)
+		return err
+	}
+	if in.upload == nil {
+		up, err := c.cfg.objects.Begin(ctx, objectKey(c.recv, in.sess), in.sess.File.Size)
+		if err != nil {
+			if _, derr := io.Copy(io.Discard, data); derr != nil {
+				return derr
+			}
+			return fmt.Errorf("%w: %v", errPartRejected, err)
+		}
+		in.upload = up
+	}
+	err := in.upload.WritePart(ctx, meta.Offset, meta.Size, meta.SHA256, data)
+	switch {
+	case errors.Is(err, objstore.ErrChecksumMismatch):
+		return transport.ErrChunkHashMismatch
+	case err != nil && ctx.Err() == nil:
+		return fmt.Errorf("%w: %v", errPartRejected, err)
+	}
+	return err
+}
+
+// finishObject completes the object of in, once its parts cover it, and
+// checks it against the file hash if its parts arrived in order to be
+// hashed as they streamed; otherwise each part was checked on its own. An
+// object that cannot be completed is discarded.
+func (c *inboundConn) finishObject(in *inbound) {
+	sess, objects := in.sess, c.cfg.objects
+	if in.upload == nil {
+		if sess.File.Size > 0 {
+			return
+		}
+		var err error
+		if in.upload, err = objects.Begin(c.ctx, objectKey(c.recv, sess), 0); err != nil {
+			log.Printf("Session %s: %v", sess.ID, err)
+			in.failure = err.Error()
+			return
+		}
+	}
+	abort := func(why string) {
+		log.Printf("Session %s: not storing %s in %s: %s", sess.ID, sess.File.Name, objects.Name(), why)
+		in.failure = why
+		// The upload is discarded even if the connection was cancelled.
+		if err := in.upload.Abort(context.WithoutCancel(c.ctx)); err != nil {
+			log.Printf("Session %s: %v", sess.ID, err)
+		}
+	}
+	sum := in.upload.SHA256()
+	if sum != "" && !strings.EqualFold(sum, sess.File.Hash) {
+		abort(transport.ErrFileHashMismatch.Error())
+		return
+	}
+	location, err := in.upload.Complete(c.ctx)
+	if err != nil {
+		abort(err.Error())
+		return
+	}
+	in.failure, in.delivered, in.delivery, in.verified = "", true, location, sum != ""
+	if sum == "" {
+		in.note = "chunks arrived out of order, so the object was verified chunk by chunk but not as a whole"
+	}
+	log.Printf("Stored %s as %s (%s)", sess.File.Name, location, utils.HumanBytes(sess.File.Size))
+}
@@ -0,0 +1,92 @@
+package receive
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+)
+
+// maxPrefixWait bounds how long a prefix request may wait for data.
+const maxPrefixWait = 5 * time.Minute
+
+// outputPrefixes serves how much of each file being written in place
+// (-store-mode direct) is verified, so downstream tools can consume it while
+// the rest is still arriving.
+type outputPrefixes struct {
+	recv *transport.TCPReceiver
+}
+
+// registerRoutes registers the prefix API on mux:
+//
+//	GET /api/v1/outputs                            files being written in place
+//	GET /api/v1/outputs/{id}?min=N&timeout=30s     one file; with min, waits up to timeout for N verified bytes
+func (p *outputPrefixes) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/outputs", p.handleList)
+	mux.HandleFunc("/api/v1/outputs/", p.handleOutput)
+}
+
+func (p *outputPrefixes) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.recv.Outputs())
+}
+
+func (p *outputPrefixes) handleOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/outputs/")
+	if id == "" || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	out, err := p.recv.Output(id)
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+	if v := r.URL.Query().Get("min"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "min must be a byte offset"})
+			return
+		}
+		wait := 30 * time.Second
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid timeout"})
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), min(wait, maxPrefixWait))
+		defer cancel()
+		// Running out of time is not an error: the caller gets the prefix
+		// verified so far and can ask again.
+		out.Verified, err = p.recv.WaitVerifiedPrefix(ctx, id, n)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+			p.writeError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (p *outputPrefixes) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, transport.ErrNoOutput):
+		status = http.StatusNotFound
+	case errors.Is(err, transport.ErrFileHashMismatch):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,63 @@
+package receive
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// receiptSigner issues signed delivery receipts to senders that ask for
+// them, and posts each to the orchestrator if one is configured.
+type receiptSigner struct {
+	key  ed25519.PrivateKey
+	node string
+	orch *client.OrchestratorClient
+}
+
+// answer replies to a receipt request for sess on conn. If failure is
+// empty the file was verified and a signed receipt is sent; otherwise the
+// sender is told why there is none.
+func (rs *receiptSigner) answer(conn *replyConn, sess *models.TransferSession, req transport.ReceiptRequest, failure string) {
+	reply := transport.ReceiptReply{Error: failure}
+	if failure == "" {
+		r := &receipt.Receipt{
+			SessionID:       sess.ID,
+			SenderSessionID: req.SessionID,
+			FileName:        sess.File.Name,
+			FileHash:        sess.File.Hash,
+			Size:            sess.File.Size,
+			StartedAt:       sess.CreatedAt,
+			CompletedAt:     time.Now(),
+			Receiver:        rs.node,
+		}
+		if err := r.Sign(rs.key); err != nil {
+			reply.Error = "sign receipt: " + err.Error()
+		} else {
+			reply.Receipt = r
+		}
+	}
+	err := conn.send(func(ctx context.Context, conn net.Conn) error {
+		return transport.NewTCPSender().SendReceipt(ctx, conn, reply)
+	})
+	if err != nil {
+		log.Printf("Session %s: send receipt: %v", sess.ID, err)
+		return
+	}
+	if reply.Receipt == nil {
+		log.Printf("Session %s: told sender no receipt was issued: %s", sess.ID, reply.Error)
+		return
+	}
+	log.Printf("Session %s: sent signed receipt", sess.ID)
+	if rs.orch != nil {
+		if err := rs.orch.PostReceipt(reply.Receipt); err != nil {
+			log.Printf("Session %s: post receipt to orchestrator: %v", sess.ID, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package receive
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+)
+
+// rendezvousRetry is how long a receiver waits before registering for a
+// rendezvous again after the orchestrator could not be used.
+const rendezvousRetry = 30 * time.Second
+
+// awaitSenders keeps this receiver registered with orch as the receiver of
+// rendezvous id and punches a hole from addr on network, where it listens
+// for transfers, to every sender that registers for it, until the process
+// exits.
+func awaitSenders(orch *client.OrchestratorClient, id, network, addr string) {
+	laddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		log.Printf("rendezvous %s: %v", id, err)
+		return
+	}
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		log.Printf("rendezvous %s: %v", id, err)
+		return
+	}
+	defer conn.Close()
+	log.Printf("Rendezvous %s: waiting for senders through the orchestrator", id)
+	var since time.Time
+	for {
+		self := orchestrator.RendezvousParty{Role: orchestrator.RoleReceiver, Port: laddr.Port}
+		addr, peer, err := orch.Rendezvous(context.Background(), conn, id, self, since)
+		since = time.Now()
+		switch {
+		case err == nil:
+			log.Printf("Rendezvous %s: reached the sender at %s", id, addr)
+		case peer != nil:
+			log.Printf("Rendezvous %s: %v; the sender falls back to relays", id, err)
+		default:
+			log.Printf("%v", err)
+			time.Sleep(rendezvousRetry)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package receive
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// newReceiverSession creates the session receiving fileMeta under the
+// sender's session ID, so both sides name it alike, or under an ID of its
+// own if that one is taken, e.g. by a completed session sent again.
+func newReceiverSession(sessMgr *session.SessionManager, fileMeta models.FileMetadata) (*models.TransferSession, error) {
+	if fileMeta.SenderSession != "" {
+		sess, err := sessMgr.AdoptSession(fileMeta.SenderSession, fileMeta)
+		if err == nil {
+			return sess, nil
+		}
+		log.Printf("Sender session %s: %v; receiving under a new ID", fileMeta.SenderSession, err)
+	}
+	return sessMgr.CreateSession(fileMeta)
+}
+
+// recoverSessions checks the unfinished sessions kept for senders and tus
+// clients against their temp files once at startup: chunks whose files are
+// gone or damaged, e.g. by a crash mid-write, are marked pending so a
+// resuming sender sends them again.
+func recoverSessions(recv *transport.TCPReceiver, sessMgr *session.SessionManager) {
+	for _, s := range sessMgr.ListSessions() {
+		if s.File.SenderSession == "" && s.Origin != models.OriginTus || s.Status == models.SessionStatusCompleted {
+			continue
+		}
+		missing := recv.MissingChunks(s, true)
+		for _, id := range missing {
+			if err := sessMgr.UpdateChunkStatus(s.ID, id, models.ChunkStatusPending); err != nil {
+				log.Printf("Session %s: %v", s.ID, err)
+			}
+		}
+		if s.Completed > 0 || len(missing) > 0 {
+			log.Printf("Recovered session %s of %s: %d chunks held, %d lost",
+				s.ID, s.File.Name, s.Completed, len(missing))
+		}
+	}
+}
+
+// resumeSession returns the session this receiver kept for an earlier
+// attempt at the sender's session in fileMeta, with chunks whose temp files
+// are gone marked pending. ok is false if there is none to resume.
+func resumeSession(recv *transport.TCPReceiver, sessMgr *session.SessionManager, claims *claimSet, fileMeta models.FileMetadata) (*models.TransferSession, bool) {
+	sess, ok := sessMgr.FindBySender(fileMeta.SenderSession, fileMeta.Hash)
+	if !ok {
+		return nil, false
+	}
+	if !claims.claim(sess.ID) {
+		log.Printf("Session %s is still being received; starting a new one", sess.ID)
+		return nil, false
+	}
+	for _, id := range recv.MissingChunks(sess, false) {
+		if err := sessMgr.UpdateChunkStatus(sess.ID, id, models.ChunkStatusPending); err != nil {
+			log.Printf("Session %s: %v", sess.ID, err)
+		}
+	}
+	var held int64
+	for _, c := range sess.Chunks {
+		if c.Status == models.ChunkStatusCompleted {
+			held += c.Size
+		}
+	}
+	log.Printf("Resuming session %s for sender session %s (%s held)",
+		sess.ID, fileMeta.SenderSession, utils.HumanBytes(held))
+	return sess, true
+}
+
+// answerResume replies to a resume request with the chunks of sess that are
+// held, if sess was resumed.
+func answerResume(ctx context.Context, conn net.Conn, sess *models.TransferSession, resumed bool) error {
+	var held transport.HeldChunks
+	if resumed {
+		for _, c := range sess.Chunks {
+			if c.Status == models.ChunkStatusCompleted {
+				held.Chunks = append(held.Chunks, transport.HeldChunk{ID: c.ID, SHA256: c.SHA256})
+			}
+		}
+	}
+	return transport.NewTCPSender().SendHeldChunks(ctx, conn, held)
+}
@@ -0,0 +1,299 @@
+package receive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/catalog"
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/fetch"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// registerInterval is how often a node refreshes its orchestrator
+// registration.
+const registerInterval = time.Minute
+
+// fileServer sends files this receiver has already assembled to other
+// receivers, acting as their sender. Files are addressed by content hash.
+type fileServer struct {
+	catalog *catalog.Catalog
+	// timeouts supplies the socket timeouts for each destination.
+	timeouts timeouts.Config
+	// registrar, if non-nil, keeps the orchestrator told about held files.
+	registrar *nodeRegistrar
+
+	mu sync.Mutex
+	// manifests caches the chunk manifests built for fetch clients, keyed
+	// by file hash and chunk size.
+	manifests map[string]*fetch.Manifest
+}
+
+// maxManifests bounds the chunk manifests a fileServer keeps cached.
+const maxManifests = 64
+
+// record adds the assembled file at path to the catalog. Directory
+// transfers are not recorded since their session stream is not kept.
+func (fs *fileServer) record(file models.FileMetadata, path string) {
+	if fs == nil {
+		return
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	if err := fs.catalog.Add(file, path); err != nil {
+		log.Printf("record %s for serving: %v", path, err)
+		return
+	}
+	fs.registrar.register()
+}
+
+// registerRoutes registers the file serving API on mux:
+//
+//	GET  /api/v1/files                              files held, by hash
+//	POST /api/v1/files/{hash}/send                  body {"receiver": "host:port", "token": "..."}; sends in the background
+//	GET  /api/v1/files/{hash}/chunks?chunk_size=N   chunk manifest for trackshift fetch
+//	GET  /api/v1/files/{hash}/data                  file content; honours Range
+func (fs *fileServer) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/files", fs.handleList)
+	mux.HandleFunc("/api/v1/files/", fs.handleFile)
+}
+
+func (fs *fileServer) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, fs.catalog.List())
+}
+
+// handleFile dispatches requests under /api/v1/files/{hash}/.
+func (fs *fileServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/files/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "send":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fs.handleSend(w, r, parts[0])
+	case "chunks":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fs.handleChunks(w, r, parts[0])
+	case "data":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fs.handleData(w, r, parts[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// lookup returns the catalog entry for hash, or writes the error response
+// and returns nil.
+func (fs *fileServer) lookup(w http.ResponseWriter, hash string) *catalog.Entry {
+	entry, err := fs.catalog.Lookup(hash)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, catalog.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return nil
+	}
+	return entry
+}
+
+// handleChunks handles GET /api/v1/files/{hash}/chunks?chunk_size=N
+func (fs *fileServer) handleChunks(w http.ResponseWriter, r *http.Request, hash string) {
+	chunkSize := int64(fetch.DefaultChunkSize)
+	if v := r.URL.Query().Get("chunk_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk_size must be a positive number of bytes"})
+			return
+		}
+		chunkSize = max(n, fetch.MinChunkSize)
+	}
+	entry := fs.lookup(w, hash)
+	if entry == nil {
+		return
+	}
+	m, err := fs.manifest(entry, chunkSize)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// manifest returns the chunk manifest of entry at chunkSize, building it
+// on first use.
+func (fs *fileServer) manifest(entry *catalog.Entry, chunkSize int64) (*fetch.Manifest, error) {
+	key := entry.File.Hash + "/" + strconv.FormatInt(chunkSize, 10)
+	fs.mu.Lock()
+	m := fs.manifests[key]
+	fs.mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if m, err = fetch.NewManifest(f, entry.File, chunkSize); err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.manifests == nil || len(fs.manifests) >= maxManifests {
+		fs.manifests = make(map[string]*fetch.Manifest)
+	}
+	fs.manifests[key] = m
+	return m, nil
+}
+
+// handleData handles GET /api/v1/files/{hash}/data, serving byte ranges
+// of the file to fetch clients.
+func (fs *fileServer) handleData(w http.ResponseWriter, r *http.Request, hash string) {
+	entry := fs.lookup(w, hash)
+	if entry == nil {
+		return
+	}
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", entry.AddedAt, f)
+}
+
+// handleSend handles POST /api/v1/files/{hash}/send
+func (fs *fileServer) handleSend(w http.ResponseWriter, r *http.Request, hash string) {
+	var req struct {
+		Receiver string `json:"receiver"`
+		// Token is presented to receivers that require an auth token.
+		Token string `json:"token,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Receiver == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"receiver\": \"host:port\"}"})
+		return
+	}
+	entry := fs.lookup(w, hash)
+	if entry == nil {
+		return
+	}
+	go func() {
+		log.Printf("Serving %s (%s) to %s", entry.File.Name, utils.HumanBytes(entry.File.Size), req.Receiver)
+		if err := fs.send(context.Background(), entry, req.Receiver, req.Token); err != nil {
+			log.Printf("serve %s to %s: %v", entry.File.Name, req.Receiver, err)
+			return
+		}
+		log.Printf("Served %s to %s", entry.File.Name, req.Receiver)
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"hash": entry.File.Hash, "receiver": req.Receiver})
+}
+
+// send transfers the catalogued file to the receiver at addr as a new
+// session, authenticating with token if set.
+func (fs *fileServer) send(ctx context.Context, entry *catalog.Entry, addr, token string) error {
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := chunker.NewChunker(chunker.ChunkerConfig{}).ChunkReaderAt(f, entry.File.Size, 0)
+	if err != nil {
+		return fmt.Errorf("chunk file: %w", err)
+	}
+	file := entry.File
+	file.ProtocolVersion = protocol.CurrentVersion
+
+	sender := transport.NewTCPSender()
+	sender.Timeouts = fs.timeouts.For(addr)
+	conn, err := sender.Connect(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if token != "" {
+		if err := sender.Authenticate(ctx, conn, token); err != nil {
+			return err
+		}
+	}
+	return sender.SendFile(ctx, conn, f, file, chunks)
+}
+
+// nodeRegistrar announces this node and its held files to the orchestrator.
+type nodeRegistrar struct {
+	client  *client.OrchestratorClient
+	catalog *catalog.Catalog
+
+	mu   sync.Mutex
+	node orchestrator.NodeInfo
+}
+
+// register sends the current list of held files to the orchestrator.
+// Failures are logged and retried at the next interval.
+func (nr *nodeRegistrar) register() {
+	if nr == nil {
+		return
+	}
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	nr.node.Files = nr.catalog.Hashes()
+	if err := nr.client.RegisterNode(nr.node); err != nil {
+		log.Printf("register with orchestrator: %v", err)
+	}
+}
+
+// run registers the node now and then every registerInterval.
+func (nr *nodeRegistrar) run() {
+	for {
+		nr.register()
+		time.Sleep(registerInterval)
+	}
+}
+
+// defaultControlURL derives the URL other hosts use to reach a control API
+// listening on addr, substituting the hostname for an unspecified host.
+func defaultControlURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, _ = os.Hostname()
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
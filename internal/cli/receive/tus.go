@@ -0,0 +1,461 @@
+package receive
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/authtoken"
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// tusVersion is the version of the tus protocol served, which clients must
+// name in their Tus-Resumable header.
+const tusVersion = "1.0.0"
+
+// tusPath is the URL path uploads are created at; each upload is served at
+// tusPath followed by its session ID.
+const tusPath = "/files/"
+
+// tusChunkSize is the most of a PATCH body stored as one chunk. A body cut
+// off mid-way keeps what was read of it, so the client resumes from there.
+const tusChunkSize = 8 << 20
+
+// tusServer accepts files from tus clients, such as tus-js-client and Uppy
+// in browsers: an upload is created with a POST giving its length, and
+// PATCH requests append to it from the offset a HEAD request reports. Each
+// upload is a session whose chunks are stored as temp files, like those
+// from senders, and it is assembled and delivered once the last byte
+// arrives.
+type tusServer struct {
+	recv    *transport.TCPReceiver
+	sessMgr *session.SessionManager
+	cfg     receiverConfig
+}
+
+// tusConnKey is the context key under which the connection a request
+// arrived on is kept.
+type tusConnKey struct{}
+
+// serveTus serves tus uploads on addr until it fails.
+func serveTus(network, addr string, recv *transport.TCPReceiver, sessMgr *session.SessionManager, cfg receiverConfig) error {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	ln = cfg.filter.Listener(ln)
+	srv := &http.Server{
+		Handler:           &tusServer{recv: recv, sessMgr: sessMgr, cfg: cfg},
+		ReadHeaderTimeout: max(cfg.timeouts.Read, 0),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, tusConnKey{}, c)
+		},
+	}
+	log.Printf("Accepting tus uploads at http://%s%s", ln.Addr(), tusPath)
+	return srv.Serve(ln)
+}
+
+func (t *tusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Tus-Resumable", tusVersion)
+	// Browsers upload from pages served elsewhere.
+	if r.Header.Get("Origin") != "" {
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, X-HTTP-Method-Override, X-Requested-With")
+		h.Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Upload-Length, Upload-Offset")
+	}
+	if m := r.Header.Get("X-HTTP-Method-Override"); m != "" {
+		r.Method = strings.ToUpper(m)
+	}
+	if r.Method == http.MethodOptions {
+		h.Set("Tus-Version", tusVersion)
+		h.Set("Tus-Extension", "creation")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		h.Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	token, err := t.authenticate(r)
+	if err != nil {
+		log.Printf("Rejecting tus upload from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := strings.CutPrefix(r.URL.Path+"/", tusPath)
+	id = strings.TrimSuffix(id, "/")
+	switch {
+	case !ok || strings.Contains(id, "/"):
+		http.NotFound(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		t.create(w, r, token)
+	case id == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.Method == http.MethodHead:
+		t.head(w, id)
+	case r.Method == http.MethodPatch:
+		t.patch(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticate returns the auth token a request carries as a bearer token,
+// if the receiver requires one.
+func (t *tusServer) authenticate(r *http.Request) (*authtoken.Token, error) {
+	if t.cfg.tokens == nil {
+		return nil, nil
+	}
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errors.New("no auth token: send one as Authorization: Bearer TOKEN")
+	}
+	return t.cfg.tokens.Verify(secret)
+}
+
+// create starts an upload of Upload-Length bytes, named by the filename (or
+// name) key of its Upload-Metadata.
+func (t *tusServer) create(w http.ResponseWriter, r *http.Request, token *authtoken.Token) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "uploads must give their Upload-Length", http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "Upload-Length must be a positive number of bytes", http.StatusBadRequest)
+		return
+	}
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := cmp.Or(meta["filename"], meta["name"])
+	if name == "" {
+		http.Error(w, "Upload-Metadata must give the file's name as filename", http.StatusBadRequest)
+		return
+	}
+	if name, err = transport.SafeName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sess, err := t.sessMgr.CreateUpload(models.FileMetadata{Name: name, Size: size, MimeType: meta["filetype"]})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := admitToken(token, t.recv, sess); err != nil {
+		log.Printf("Session %s from %s: %v", sess.ID, r.RemoteAddr, err)
+		if err := t.sessMgr.SetStatus(sess.ID, models.SessionStatusFailed); err != nil {
+			log.Printf("save session: %v", err)
+		}
+		status := http.StatusForbidden
+		if errors.Is(err, authtoken.ErrLimit) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := t.sessMgr.SaveSession(sess); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	log.Printf("Session %s: tus upload of %s (%s) from %s", sess.ID, name, utils.HumanBytes(size), r.RemoteAddr)
+	w.Header().Set("Location", tusPath+sess.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated keys,
+// each followed by a space and its base64-encoded value, if it has one.
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for pair := range strings.SplitSeq(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+		if _, dup := meta[key]; dup {
+			return nil, fmt.Errorf("Upload-Metadata gives %s twice", key)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %s is not base64", key)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// upload returns the session of the tus upload with ID id.
+func (t *tusServer) upload(id string) (*models.TransferSession, bool) {
+	sess, err := t.sessMgr.GetSession(id)
+	if err != nil || sess.Origin != models.OriginTus {
+		return nil, false
+	}
+	return sess, true
+}
+
+// uploadOffset returns how much of an upload is held: the bytes its stored
+// chunks cover from the start of the file without a gap.
+func uploadOffset(sess *models.TransferSession) int64 {
+	chunks := make([]*models.ChunkMetadata, 0, len(sess.Chunks))
+	for _, c := range sess.Chunks {
+		chunks = append(chunks, c)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
+	var offset int64
+	for _, c := range chunks {
+		if c.Offset != offset || c.Status != models.ChunkStatusCompleted {
+			break
+		}
+		offset += c.Size
+	}
+	return offset
+}
+
+func (t *tusServer) head(w http.ResponseWriter, id string) {
+	sess, ok := t.upload(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	h := w.Header()
+	h.Set("Upload-Offset", strconv.FormatInt(uploadOffset(sess), 10))
+	h.Set("Upload-Length", strconv.FormatInt(sess.File.Size, 10))
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends the request body to upload id at the offset the request
+// gives, which must be the upload's. The upload is delivered once the body
+// completes it.
+func (t *tusServer) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a number of bytes", http.StatusBadRequest)
+		return
+	}
+	sess, ok := t.upload(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !t.cfg.claims.claim(sess.ID) {
+		http.Error(w, "the upload is being written by another request", http.StatusLocked)
+		return
+	}
+	defer t.cfg.claims.release(sess.ID)
+
+	held := uploadOffset(sess)
+	if offset != held {
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the %d bytes held", offset, held), http.StatusConflict)
+		return
+	}
+	if sess.Status == models.SessionStatusCompleted {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(held, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if sess.Status != models.SessionStatusTransferring {
+		if err := t.sessMgr.SetStatus(sess.ID, models.SessionStatusTransferring); err != nil {
+			log.Printf("save session: %v", err)
+		}
+	}
+	// Chunks past a gap, left by a chunk lost since, are sent again.
+	for id, c := range sess.Chunks {
+		if c.Offset >= held {
+			delete(sess.Chunks, id)
+		}
+	}
+	if err := t.sessMgr.RecomputeCounters(sess.ID); err != nil {
+		log.Printf("Session %s: %v", sess.ID, err)
+	}
+
+	held, err = t.append(w, r, sess, held)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(held, 10))
+	if err != nil {
+		log.Printf("Session %s: tus upload from %s: %v", sess.ID, r.RemoteAddr, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errTusTooLong) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if held == sess.File.Size {
+		if in := t.deliver(r, sess); !in.delivered {
+			http.Error(w, in.failure, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errTusTooLong is returned for PATCH bodies running past the length the
+// upload was created with.
+var errTusTooLong = errors.New("the body runs past Upload-Length")
+
+// append stores the request body as chunks of sess from offset on, and
+// returns the offset the stored chunks reach. The body must make progress
+// within the read timeout.
+func (t *tusServer) append(w http.ResponseWriter, r *http.Request, sess *models.TransferSession, offset int64) (int64, error) {
+	rc := http.NewResponseController(w)
+	size := sess.File.Size
+	buf := make([]byte, min(tusChunkSize, size-offset))
+	for offset < size {
+		if t.cfg.timeouts.Read > 0 {
+			rc.SetReadDeadline(time.Now().Add(t.cfg.timeouts.Read))
+		}
+		n, err := io.ReadFull(r.Body, buf[:min(int64(len(buf)), size-offset)])
+		if n > 0 {
+			if serr := t.storeChunk(r.Context(), sess, offset, buf[:n]); serr != nil {
+				return offset, serr
+			}
+			offset += int64(n)
+		}
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return offset, nil
+		case err != nil:
+			return offset, err
+		}
+	}
+	if n, _ := r.Body.Read(make([]byte, 1)); n > 0 {
+		return offset, errTusTooLong
+	}
+	return offset, nil
+}
+
+// storeChunk stores data as the chunk of sess at offset, numbered after the
+// chunks before it.
+func (t *tusServer) storeChunk(ctx context.Context, sess *models.TransferSession, offset int64, data []byte) error {
+	sum := sha256.Sum256(data)
+	now := time.Now()
+	meta := &models.ChunkMetadata{
+		ID:        strconv.Itoa(len(sess.Chunks)),
+		Size:      int64(len(data)),
+		Offset:    offset,
+		SHA256:    hex.EncodeToString(sum[:]),
+		CreatedAt: now,
+		UpdatedAt: now,
+		SessionID: sess.ID,
+	}
+	if _, err := t.recv.StoreChunkStream(ctx, sess.ID, meta, bytes.NewReader(data)); err != nil {
+		t.cfg.metrics.ChunkFailed()
+		return fmt.Errorf("store chunk %s: %w", meta.ID, err)
+	}
+	sess.Chunks[meta.ID] = meta
+	if err := t.sessMgr.UpdateChunkStatus(sess.ID, meta.ID, models.ChunkStatusCompleted); err != nil {
+		return err
+	}
+	t.cfg.events.Log(eventlog.Event{
+		Type:    eventlog.EventReceived,
+		Session: sess.ID,
+		Chunk:   meta.ID,
+		Bytes:   meta.Size,
+	})
+	t.cfg.metrics.ChunkCompleted()
+	t.cfg.metrics.BytesReceived(meta.Size)
+	return nil
+}
+
+// deliver hashes the upload sess, now complete, and delivers it like a
+// session from a sender: it is authorized and placed, assembled, and ended
+// with its hooks and report, on the connection r arrived on. Delivery goes
+// on if the client hangs up, and a PATCH at the end of the upload tries
+// again if it fails.
+func (t *tusServer) deliver(r *http.Request, sess *models.TransferSession) *inbound {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	conn, _ := r.Context().Value(tusConnKey{}).(net.Conn)
+	c := newInboundConn(ctx, cancel, conn, t.recv, t.sessMgr, t.cfg)
+	in := &inbound{
+		tag:      sess.ID,
+		sess:     sess,
+		failure:  "transfer did not complete",
+		started:  sess.CreatedAt,
+		received: sess.File.Size,
+	}
+	c.order = append(c.order, in)
+	t.cfg.metrics.SessionStarted()
+	defer c.close()
+
+	hash, err := t.hashUpload(ctx, sess)
+	if err != nil {
+		log.Printf("Session %s: hash upload: %v", sess.ID, err)
+		in.failure = err.Error()
+		return in
+	}
+	sess.File.Hash = hash
+	if err := t.sessMgr.SaveSession(sess); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	if !c.authorize(in) {
+		return in
+	}
+	c.finish(in)
+	if in.delivered {
+		// The hash was taken from the upload itself, so it matching the
+		// file assembled says nothing about what the client meant to send.
+		in.verified = false
+		in.note = "tus clients send no checksum, so the file was not verified against one"
+		log.Printf("Session %s: %s", sess.ID, in.note)
+	}
+	return in
+}
+
+// hashUpload returns the SHA-256 of the upload sess from its chunks.
+func (t *tusServer) hashUpload(ctx context.Context, sess *models.TransferSession) (string, error) {
+	chunks := make([]*models.ChunkMetadata, 0, len(sess.Chunks))
+	for _, c := range sess.Chunks {
+		chunks = append(chunks, c)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
+	h := sha256.New()
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		f, err := os.Open(t.recv.PartPath(sess.ID, c.ID))
+		if err != nil {
+			return "", err
+		}
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err == nil && n != c.Size {
+			err = fmt.Errorf("chunk %s holds %d bytes, want %d", c.ID, n, c.Size)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
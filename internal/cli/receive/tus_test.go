@@ -0,0 +1,231 @@
+package receive
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// tusFixture is a tus endpoint receiving into a temp directory.
+type tusFixture struct {
+	srv       *httptest.Server
+	sessMgr   *session.SessionManager
+	outputDir string
+	reports   string
+}
+
+func newTusFixture(t *testing.T) *tusFixture {
+	t.Helper()
+	dir := t.TempDir()
+	f := &tusFixture{outputDir: filepath.Join(dir, "out"), reports: filepath.Join(dir, "reports.jsonl")}
+	recv, err := transport.NewTCPReceiver(f.outputDir, filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("create receiver: %v", err)
+	}
+	if f.sessMgr, err = session.NewSessionManager(filepath.Join(dir, "sessions")); err != nil {
+		t.Fatalf("create session manager: %v", err)
+	}
+	cfg := receiverConfig{
+		telemetry: telemetry.NewTelemetryCollector(),
+		timeouts:  timeouts.Defaults(),
+		claims:    newClaimSet(),
+		outputs:   newClaimSet(),
+		chunkmap:  chunkstate.NewTracker(nil),
+	}
+	if cfg.reports, err = report.Open(f.reports); err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { cfg.reports.Close() })
+	f.srv = httptest.NewUnstartedServer(&tusServer{recv: recv, sessMgr: f.sessMgr, cfg: cfg})
+	f.srv.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, tusConnKey{}, c)
+	}
+	f.srv.Start()
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// do sends a tus request for path with the given headers and body.
+func (f *tusFixture) do(t *testing.T, method, path, body string, headers ...string) *http.Response {
+	t.Helper()
+	r, err := http.NewRequest(method, f.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := f.srv.Client().Do(r)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+// create starts an upload of size bytes named name and returns its path.
+func (f *tusFixture) create(t *testing.T, name string, size int) string {
+	t.Helper()
+	resp := f.do(t, http.MethodPost, tusPath, "",
+		"Upload-Length", strconv.Itoa(size),
+		"Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+// patch appends body to the upload at path from offset.
+func (f *tusFixture) patch(t *testing.T, path string, offset int, body string) *http.Response {
+	t.Helper()
+	return f.do(t, http.MethodPatch, path, body,
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", strconv.Itoa(offset))
+}
+
+// offset returns the Upload-Offset a HEAD request reports for path.
+func (f *tusFixture) offset(t *testing.T, path string) string {
+	t.Helper()
+	resp := f.do(t, http.MethodHead, path, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("head: status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Upload-Offset")
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	meta, err := parseTusMetadata("filename " + b64("a b.txt") + ", filetype " + b64("text/plain") + ",is_confidential")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(meta) != 3 || meta["filename"] != "a b.txt" || meta["filetype"] != "text/plain" || meta["is_confidential"] != "" {
+		t.Fatalf("metadata %q", meta)
+	}
+	if meta, err := parseTusMetadata(" "); err != nil || len(meta) != 0 {
+		t.Fatalf("empty header: %q, %v", meta, err)
+	}
+	for _, header := range []string{
+		"filename " + b64("a") + ",",
+		", filename " + b64("a"),
+		"filename " + b64("a") + ",filename " + b64("b"),
+		"filename not*base64",
+	} {
+		if _, err := parseTusMetadata(header); err == nil {
+			t.Errorf("%q: expected an error", header)
+		}
+	}
+}
+
+func TestTusCreate(t *testing.T) {
+	f := newTusFixture(t)
+	for _, tc := range []struct {
+		headers []string
+		want    int
+	}{
+		{[]string{"Upload-Length", "5", "Upload-Metadata", "filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain"))}, http.StatusBadRequest},
+		{[]string{"Upload-Length", "5", "Upload-Metadata", "filename %%%"}, http.StatusBadRequest},
+		{[]string{"Upload-Length", "0", "Upload-Metadata", "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt"))}, http.StatusBadRequest},
+		{[]string{"Upload-Length", "5", "Upload-Metadata", "name " + base64.StdEncoding.EncodeToString([]byte("a.txt"))}, http.StatusCreated},
+	} {
+		if resp := f.do(t, http.MethodPost, tusPath, "", tc.headers...); resp.StatusCode != tc.want {
+			t.Errorf("%q: status %d, want %d", tc.headers, resp.StatusCode, tc.want)
+		}
+	}
+	if resp := f.do(t, http.MethodPost, tusPath, "", "Tus-Resumable", "0.2.2"); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("old tus version: status %d", resp.StatusCode)
+	}
+}
+
+func TestTusPatchOffsetConflict(t *testing.T) {
+	f := newTusFixture(t)
+	path := f.create(t, "a.txt", 8)
+	if resp := f.patch(t, path, 2, "cdef"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("patch past the offset held: status %d", resp.StatusCode)
+	}
+	if resp := f.patch(t, path, 0, "abcd"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "4" {
+		t.Fatalf("patch: status %d, offset %s", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := f.patch(t, path, 0, "abcd"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("patch behind the offset held: status %d", resp.StatusCode)
+	}
+	if got := f.offset(t, path); got != "4" {
+		t.Fatalf("offset %s after conflicts, want 4", got)
+	}
+}
+
+func TestTusPatchResendsPastGap(t *testing.T) {
+	f := newTusFixture(t)
+	path := f.create(t, "a.txt", 16)
+	for i, part := range []string{"abcd", "efgh", "ijkl"} {
+		if resp := f.patch(t, path, 4*i, part); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("patch %d: status %d", i, resp.StatusCode)
+		}
+	}
+	// Losing the middle chunk leaves the upload held only up to it, and
+	// the chunk after it is sent again.
+	id := strings.TrimPrefix(path, tusPath)
+	if err := f.sessMgr.UpdateChunkStatus(id, "1", models.ChunkStatusFailed); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.offset(t, path); got != "4" {
+		t.Fatalf("offset %s with chunk 1 lost, want 4", got)
+	}
+	if resp := f.patch(t, path, 8, "mnop"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("patch past the gap: status %d", resp.StatusCode)
+	}
+	if resp := f.patch(t, path, 4, "efghijklmnop"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "16" {
+		t.Fatalf("resend: status %d, offset %s", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	got, err := os.ReadFile(filepath.Join(f.outputDir, "a.txt"))
+	if err != nil || string(got) != "abcdefghijklmnop" {
+		t.Fatalf("delivered %q, %v", got, err)
+	}
+	sess, err := f.sessMgr.GetSession(id)
+	if err != nil || sess.Status != models.SessionStatusCompleted || len(sess.Chunks) != 2 {
+		t.Fatalf("session %+v, %v", sess, err)
+	}
+	// No checksum to verify against is noted, not reported as a failure.
+	raw, err := os.ReadFile(f.reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s report.Summary
+	if err := json.Unmarshal(raw, &s); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if s.Status != report.StatusCompleted || s.Error != "" || s.Verification != report.Unverified || s.Note == "" {
+		t.Fatalf("report %+v", s)
+	}
+}
+
+func TestTusPatchTooLong(t *testing.T) {
+	f := newTusFixture(t)
+	path := f.create(t, "a.txt", 4)
+	if resp := f.patch(t, path, 0, "abcdef"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("overlong body: status %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(f.outputDir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("overlong upload was delivered: %v", err)
+	}
+}
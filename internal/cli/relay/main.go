@@ -0,0 +1,182 @@
+// Package relay implements the trackshift relay command, which runs a
+// forwarding or gateway relay.
+package relay
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/relay"
+)
+
+// Main runs the relay command with args, the arguments after its name.
+func Main(args []string) {
+	fs := cli.NewFlagSet("relay")
+	listenPort := fs.Int("listen-port", 9001, "UDP port to listen on")
+	forwardAddr := fs.String("forward-address", "127.0.0.1:9090", "UDP address for the packets of sessions without a route; empty drops them")
+	via := fs.String("via", "", "comma-separated relay addresses (host:port) to chain routed sessions through before their receivers, in forward mode")
+	relayID := fs.String("relay-id", "relay-1", "unique relay identifier")
+	orchestratorURL := fs.String("orchestrator-url", "", "orchestrator URL (optional)")
+	orchestratorToken := fs.String("orchestrator-token", "", "API token with the relay scope for an orchestrator that requires one (default $TRACKSHIFT_ORCHESTRATOR_TOKEN)")
+	follow := fs.Bool("follow-orchestrator", false, "apply the forward address, via hops and allow/deny rules set for this relay on the -orchestrator-url (PUT /api/v1/relays/{id}/config), checked with each heartbeat")
+	mode := fs.String("mode", "forward", "relay mode: forward (UDP packet relay) or gateway (terminate and re-originate TCP sessions)")
+	gatewayCompression := fs.String("gateway-compression", "auto", "outbound compression in gateway mode: auto, zstd or none")
+	region := fs.String("region", "", "region reported to the orchestrator")
+	advertise := fs.String("advertise-address", "", "address reported to the orchestrator (default: hostname:listen-port)")
+	capacity := fs.String("capacity", "", "bandwidth this relay can forward, e.g. 1gbit, for scaling recommendations")
+	capacityPPS := fs.Float64("capacity-pps", 0, "packets per second this relay can forward, for scaling recommendations")
+	allow := fs.String("allow", "", "comma-separated CIDRs allowed to send through this relay (default all)")
+	deny := fs.String("deny", "", "comma-separated CIDRs refused, even if allowed")
+	sessionRate := fs.String("session-rate", "", "bandwidth each session may use through this relay in forward mode, e.g. 100mbit; packets over it are dropped (default unlimited)")
+	sourceRate := fs.String("source-rate", "", "bandwidth each source address may use through this relay in forward mode, over all its sessions (default unlimited)")
+	simLoss := fs.Float64("simulate-loss", 0, "drop this fraction (0-1) of the packets relayed in forward mode at random, for testing loss recovery")
+	simLatency := fs.Duration("simulate-latency", 0, "delay every packet relayed in forward mode by this much, for testing")
+	simJitter := fs.Duration("simulate-jitter", 0, "vary -simulate-latency by up to this much either way, reordering packets")
+	adminAddr := fs.String("admin-addr", "", "serve the admin API (GET/PUT /api/v1/ipfilter to replace -allow/-deny at runtime, GET /api/v1/stats for the traffic by session and source in forward mode) on this address, e.g. 127.0.0.1:9092")
+	adminToken := fs.String("admin-token", "", "bearer token required by admin API requests that change anything, i.e. all but GET (default $TRACKSHIFT_ADMIN_TOKEN); without one -admin-addr must be a loopback address")
+	fs.Parse(args)
+	if *follow && *orchestratorURL == "" {
+		log.Fatalf("-follow-orchestrator needs -orchestrator-url")
+	}
+	if *orchestratorToken == "" {
+		*orchestratorToken = os.Getenv("TRACKSHIFT_ORCHESTRATOR_TOKEN")
+	}
+
+	listen := ":" + strconv.Itoa(*listenPort)
+
+	filter, err := ipfilter.New(ipfilter.Rules{Allow: ipfilter.ParseList(*allow), Deny: ipfilter.ParseList(*deny)})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	admin := http.NewServeMux()
+	admin.Handle("/api/v1/ipfilter", filter.Handler())
+
+	bps, err := ratelimit.ParseRate(*capacity)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	perSession, err := ratelimit.ParseRate(*sessionRate)
+	if err != nil {
+		log.Fatalf("-session-rate: %v", err)
+	}
+	perSource, err := ratelimit.ParseRate(*sourceRate)
+	if err != nil {
+		log.Fatalf("-source-rate: %v", err)
+	}
+	relayCapacity := orchestrator.RelayCapacity{PacketsPerSec: *capacityPPS, BytesPerSec: bps}
+	address := *advertise
+	if address == "" {
+		host, _ := os.Hostname()
+		address = net.JoinHostPort(host, strconv.Itoa(*listenPort))
+	}
+
+	if *mode == "gateway" {
+		serveAdmin(*adminAddr, *adminToken, admin)
+		runGateway(relay.GatewayConfig{
+			ListenAddr:        listen,
+			ForwardAddr:       *forwardAddr,
+			Compression:       *gatewayCompression,
+			Filter:            filter,
+			RelayID:           *relayID,
+			OrchestratorURL:   *orchestratorURL,
+			OrchestratorToken: *orchestratorToken,
+			Address:           address,
+			Region:            *region,
+			Capacity:          relayCapacity,
+
+			FollowOrchestrator: *follow,
+		})
+		return
+	}
+	if *mode != "forward" {
+		log.Fatalf("unknown relay mode %q", *mode)
+	}
+
+	fwd, err := relay.NewForwarder(listen, *forwardAddr, *relayID, *orchestratorURL)
+	if err != nil {
+		log.Fatalf("create forwarder: %v", err)
+	}
+	fwd.OrchestratorToken = *orchestratorToken
+	fwd.Filter = filter
+	fwd.Region = *region
+	fwd.Capacity = relayCapacity
+	fwd.Address = address
+	fwd.Via = ipfilter.ParseList(*via)
+	fwd.FollowOrchestrator = *follow
+	fwd.Usage.SetLimits(perSession, perSource)
+	fwd.Impair = relay.Impairment{Loss: *simLoss, Latency: *simLatency, Jitter: *simJitter}
+	if err := fwd.Impair.Validate(); err != nil {
+		log.Fatalf("simulated impairment: %v", err)
+	}
+	if fwd.Impair != (relay.Impairment{}) {
+		log.Printf("Relay %s simulating %v", *relayID, fwd.Impair)
+	}
+	admin.Handle("/api/v1/stats", fwd.Usage.Handler())
+	serveAdmin(*adminAddr, *adminToken, admin)
+
+	if *forwardAddr == "" {
+		log.Printf("Relay %s listening on %s, forwarding routed sessions only", *relayID, listen)
+	} else {
+		log.Printf("Relay %s listening on %s, forwarding to %s", *relayID, listen, *forwardAddr)
+	}
+	fwd.Start()
+
+	// graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	<-sigCh
+	log.Println("Shutting down relay...")
+	if err := fwd.Close(); err != nil {
+		log.Printf("error closing forwarder: %v", err)
+	}
+}
+
+// serveAdmin serves the admin API on addr, if set, with changes requiring
+// token.
+func serveAdmin(addr, token string, mux *http.ServeMux) {
+	if addr == "" {
+		return
+	}
+	// PUT /api/v1/ipfilter replaces who may send through the relay; other
+	// hosts may only reach it with a token.
+	var handler http.Handler = mux
+	switch {
+	case token != "":
+		handler = cli.RequireToken(token, mux)
+	case !cli.Loopback(addr):
+		log.Fatalf("-admin-addr %s accepts other hosts; give -admin-token, or listen on a loopback address", addr)
+	}
+	go func() {
+		log.Printf("Admin API listening on %s", addr)
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			log.Fatalf("admin API: %v", err)
+		}
+	}()
+}
+
+func runGateway(cfg relay.GatewayConfig) {
+	gw, err := relay.NewGateway(cfg)
+	if err != nil {
+		log.Fatalf("create gateway: %v", err)
+	}
+
+	log.Printf("Relay %s gateway listening on %s (tcp), re-originating to %s", cfg.RelayID, cfg.ListenAddr, cfg.ForwardAddr)
+	gw.Start(context.Background())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	<-sigCh
+	log.Println("Shutting down gateway...")
+	if err := gw.Close(); err != nil {
+		log.Printf("error closing gateway: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package send
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Branch modes: how the branches of a transfer to several receivers run.
+const (
+	branchConcurrent = "concurrent"
+	branchSequential = "sequential"
+)
+
+// branch is one destination of a transfer. A transfer to several receivers
+// chunks and hashes its source once and sends it through one branch per
+// receiver, each with its own session.
+type branch struct {
+	dest   string
+	sess   *models.TransferSession
+	chunks []*models.ChunkMetadata
+	opts   senderOptions
+	// started is when the branch's first attempt began.
+	started time.Time
+	// report, if set, reports the branch's session to the orchestrator.
+	report *sessionReporter
+}
+
+// sendFunc sends a session to a receiver over one connection.
+type sendFunc func(receiver string, src io.ReaderAt, fileMeta models.FileMetadata, sess *models.TransferSession,
+	sessMgr *session.SessionManager, chunkMetas []*models.ChunkMetadata, totalSize int64, opts senderOptions) error
+
+// run sends b, re-attempting a failed session as a resume up to retries
+// times.
+func (b *branch) run(send sendFunc, src io.ReaderAt, fileMeta models.FileMetadata, sessMgr *session.SessionManager, retries int) error {
+	// Whole-session retries back off far longer than per-chunk ones so a
+	// transient outage has time to clear; the circuit for the destination
+	// still opens after RetryManager.MaxRetries consecutive failures.
+	retry := transport.NewRetryManager()
+	retry.BaseBackoff = 5 * time.Second
+	retry.MaxBackoff = 5 * time.Minute
+	retry.Abort = b.opts.interrupted
+
+	b.started = time.Now()
+	return retry.Run(b.dest, retries, func(attempt int) error {
+		b.opts.tally.Attempt()
+		if attempt > 0 {
+			log.Printf("Retrying session %s as a resume (attempt %d of %d)", b.sess.ID, attempt, retries)
+		}
+		if err := sessMgr.SetStatus(b.sess.ID, models.SessionStatusTransferring); err != nil {
+			return err
+		}
+		err := b.sendRetrying(send, src, fileMeta, sessMgr)
+		status := models.SessionStatusCompleted
+		switch {
+		case errors.Is(err, errInterrupted):
+			status = models.SessionStatusPaused
+		case err != nil:
+			log.Printf("Session %s failed: %v", b.sess.ID, err)
+			status = models.SessionStatusFailed
+		}
+		if err := sessMgr.SetStatus(b.sess.ID, status); err != nil {
+			log.Printf("save session: %v", err)
+		}
+		if err := sessMgr.PersistCheckpoint(b.sess.ID); err != nil {
+			log.Printf("save checkpoint: %v", err)
+		}
+		return err
+	})
+}
+
+// runBranches runs every branch with run, all at once or one after another
+// by mode, and returns their errors in order. Sequential branches not
+// started before an interrupt fail with errInterrupted.
+func runBranches(branches []*branch, mode string, run func(*branch) error) []error {
+	errs := make([]error, len(branches))
+	if mode == branchSequential {
+		for i, b := range branches {
+			if isClosed(b.opts.interrupted) {
+				errs[i] = errInterrupted
+				continue
+			}
+			errs[i] = run(b)
+		}
+		return errs
+	}
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Go(func() { errs[i] = run(b) })
+	}
+	wg.Wait()
+	return errs
+}
+
+// cloneChunks copies a chunk list for another branch, which fills in the
+// per-session fields of its chunks as it sends them.
+func cloneChunks(chunks []*models.ChunkMetadata) []*models.ChunkMetadata {
+	out := make([]*models.ChunkMetadata, len(chunks))
+	for i, c := range chunks {
+		cp := *c
+		out[i] = &cp
+	}
+	return out
+}
+
+// branchPath returns the path of a file written per branch, such as a
+// receipt: path with the branch's session ID before the extension.
+func branchPath(path, sessionID string) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, ext), sessionID, ext)
+}
+
+// chunkCache shares chunks prepared for one branch with the branches sending
+// alongside it, so each chunk is read and compressed once however many
+// receivers it goes to. An entry is dropped once every other branch has
+// taken it. Beyond the cache's capacity the oldest entries are dropped, so a
+// branch far behind the others prepares its chunks itself.
+type chunkCache struct {
+	mu       sync.Mutex
+	sharers  int // branches taking each entry besides the one that put it
+	capacity int
+	entries  map[string]*cachedChunk
+	order    []string // entry IDs, oldest first; may hold IDs already taken
+}
+
+type cachedChunk struct {
+	out         outgoing
+	compression string
+	sha256      string
+	takes       int
+}
+
+func newChunkCache(branches, capacity int) *chunkCache {
+	return &chunkCache{
+		sharers:  branches - 1,
+		capacity: max(capacity, 1),
+		entries:  make(map[string]*cachedChunk),
+	}
+}
+
+// take returns meta's chunk as prepared by another branch, if cached, and
+// records the compression it was prepared with in meta. A nil cache holds
+// nothing.
+func (c *chunkCache) take(meta *models.ChunkMetadata) (outgoing, bool) {
+	if c == nil {
+		return outgoing{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[meta.ID]
+	if !ok {
+		return outgoing{}, false
+	}
+	if e.takes++; e.takes >= c.sharers {
+		delete(c.entries, meta.ID)
+	}
+	meta.Compression, meta.SHA256 = e.compression, e.sha256
+	out := outgoing{meta: meta, payload: e.out.payload}
+	if e.out.stream != nil {
+		out.stream = e.out.stream.WithMeta(meta)
+	}
+	return out, true
+}
+
+// put offers a chunk prepared for out.meta to the other branches.
+func (c *chunkCache) put(out outgoing) {
+	if c == nil || out.reuse || c.sharers < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := out.meta.ID
+	if _, ok := c.entries[id]; ok {
+		return
+	}
+	c.entries[id] = &cachedChunk{out: out, compression: out.meta.Compression, sha256: out.meta.SHA256}
+	c.order = append(c.order, id)
+	for len(c.order) > 0 && (c.entries[c.order[0]] == nil || len(c.entries) > c.capacity) {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
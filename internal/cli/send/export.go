@@ -0,0 +1,50 @@
+package send
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+)
+
+// exportSession writes session id to a portable archive at path, or
+// <id>.tsession if path is empty, for -import on another host.
+func exportSession(ctx context.Context, sessMgr *session.SessionManager, id, path string) error {
+	if path == "" {
+		path = id + ".tsession"
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := sessMgr.Export(ctx, id, f, nil); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Exported session %s to %s", id, path)
+	return nil
+}
+
+// importSession adds the session in the archive at path to the sessions
+// here, ready to be resumed.
+func importSession(ctx context.Context, sessMgr *session.SessionManager, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sess, _, err := sessMgr.Import(ctx, f)
+	if err != nil {
+		return fmt.Errorf("import %s: %w", path, err)
+	}
+	progress, _ := sessMgr.Progress(sess.ID)
+	log.Printf("Imported session %s (%s, %s, %.0f%% complete). Resume it with the -file and -receiver for it on this host and:\n  -resume %s",
+		sess.ID, sess.File.Name, sess.Status, progress, sess.ID)
+	return nil
+}
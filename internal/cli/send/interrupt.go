@@ -0,0 +1,100 @@
+package send
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// errInterrupted is returned by a transfer stopped by Ctrl+C.
+var errInterrupted = errors.New("interrupted")
+
+// exitInterrupted is the conventional exit status after SIGINT.
+const exitInterrupted = 130
+
+// notifyInterrupt returns a channel that is closed on the first Ctrl+C, so
+// the transfer can save its state before exiting. A second Ctrl+C exits at
+// once.
+func notifyInterrupt() <-chan struct{} {
+	interrupted := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		log.Println("\nInterrupt received, saving session state (interrupt again to exit immediately)...")
+		close(interrupted)
+		<-sig
+		os.Exit(exitInterrupted)
+	}()
+	return interrupted
+}
+
+// interruptContext returns a context that is canceled once interrupted is
+// closed, for the transport calls of a transfer.
+func interruptContext(interrupted <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// isClosed reports whether ch has been closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// resumeCommand returns the command line that resumes session id: the
+// original arguments with any -resume flag replaced, quoted for a POSIX shell.
+func resumeCommand(args []string, id string) string {
+	return commandWith(args, "resume", id)
+}
+
+// commandWith returns the original arguments with the flags given as name,
+// value pairs set, replacing any earlier values, quoted for a POSIX shell.
+// A flag given an empty value is removed.
+func commandWith(args []string, flags ...string) string {
+	set := make(map[string]bool, len(flags)/2)
+	for i := 0; i+1 < len(flags); i += 2 {
+		set[flags[i]] = true
+	}
+	out := []string{shellQuote(args[0])}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		name, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if strings.HasPrefix(a, "-") && set[name] {
+			if !hasValue {
+				i++ // skip the separate value
+			}
+			continue
+		}
+		out = append(out, shellQuote(a))
+	}
+	for i := 0; i+1 < len(flags); i += 2 {
+		if flags[i+1] != "" {
+			out = append(out, "-"+flags[i], shellQuote(flags[i+1]))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// shellQuote quotes s for a POSIX shell unless it is made only of characters
+// that need no quoting.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
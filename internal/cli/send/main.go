@@ -0,0 +1,1488 @@
+// Package send implements the trackshift send command, which transfers
+// files to one or more receivers.
+package send
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+	"github.com/deb2000-sudo/trackshift/internal/cli"
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/internal/manifest"
+	"github.com/deb2000-sudo/trackshift/internal/objstore"
+	"github.com/deb2000-sudo/trackshift/internal/pipeline"
+	"github.com/deb2000-sudo/trackshift/internal/proxy"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/internal/xattr"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// Main runs the send command with args, the arguments after its name.
+func Main(args []string) {
+	fs := cli.NewFlagSet("send")
+	var files []queuedFile
+	fs.Func("file", "input file or directory path (directories are sent recursively), or an s3:// or http(s):// URL of a file to read with range requests; repeat it to queue several files, each sent in sessions of its own", func(s string) error {
+		files = append(files, queuedFile{path: s})
+		return nil
+	})
+	importDir := fs.String("import-dir", "", "send the file held in a chunk directory with an index.json, as written by a receiver's -store-mode chunks or another tool, in the chunks its index lists, instead of -file")
+	fileList := fs.String("file-list", "", "queue the files and directories listed in this file, one path per line, each optionally followed by a tab and a priority for -queue-order priority")
+	queueConcurrency := fs.Int("queue-concurrency", 1, "with several files queued: how many are sent at once")
+	queueOrder := fs.String("queue-order", queueGiven, "with several files queued: the order they are sent in, given, smallest-first or priority (highest first, from -file-list)")
+	var dests []string
+	fs.Func("receiver", "receiver address (host:port); repeat it, or give a comma-separated list, to send the file to each, chunked and hashed once", func(s string) error {
+		for _, d := range splitList(s) {
+			if slices.Contains(dests, d) {
+				return fmt.Errorf("receiver %s given twice", d)
+			}
+			dests = append(dests, d)
+		}
+		return nil
+	})
+	branchMode := fs.String("branch-mode", branchConcurrent, "with several receivers: concurrent (all at once, sharing compressed chunks) or sequential (one after another)")
+	chunkSizeFlag := fs.Int64("chunk-size", 50*1024*1024, "chunk size in bytes (default 50MB)")
+	sessionDir := fs.String("output-dir", "sessions", "session state directory")
+	sessionStore := fs.String("session-store", session.StoreJSON, "session state backend: json (one file per session) or bolt (one database, incremental chunk updates)")
+	protocolFlag := fs.String("protocol", "tcp", "transport protocol: tcp or udp")
+	parallelStreams := fs.Int("parallel-streams", 32, "number of parallel streams for UDP")
+	resumeSession := fs.String("resume", "", "resume existing session ID instead of creating a new one")
+	chunkingMode := fs.String("chunking-mode", "static", "chunking mode: static, ai, or adaptive (ai's size to start with, adjusted between chunks from live throughput and retransmits)")
+	optimizerFlag := fs.String("optimizer", "heuristic", "comma-separated chunk size optimizers tried in order with -chunking-mode ai: heuristic, service or hf")
+	optimizerURL := fs.String("optimizer-url", chunker.DefaultServiceURL, "endpoint of the chunk size optimizer service")
+	chunkAlgorithm := fs.String("chunker", "fixed", "chunk boundaries: fixed (exactly the chunk size) or fastcdc (content-defined, averaging the chunk size)")
+	dirMode := fs.String("dir-mode", "manifest", "directory transfer mode: manifest (files recreated from a manifest) or tar (packed into a tar stream)")
+	compressionFlag := fs.String("compression", "auto", "chunk compression: auto, zstd or none")
+	protocolVersion := fs.Uint("protocol-version", uint(protocol.CurrentVersion), "wire protocol version for new sessions (1 for legacy receivers)")
+	eventLog := fs.String("event-log", "", "write protocol events as NDJSON to this file (analyse with eventstat)")
+	autoRetry := fs.Int("auto-retry", 0, "re-attempt a failed session as a resume up to N times with backoff")
+	chunkRetries := fs.Int("chunk-retries", 5, "when the connection drops or a chunk fails, reconnect and continue the session, failed chunk first, up to N times per chunk with backoff before the attempt fails (0 fails at once)")
+	var timeoutCfg timeouts.Config
+	fs.Func("timeouts", "socket timeouts, e.g. connect=5s,read=1m,write=30s,handshake=2s (off disables one)", timeoutCfg.Merge)
+	fs.Func("timeout-for", "per-destination timeouts as host[:port]=connect=5s,... (repeatable)", timeoutCfg.AddOverride)
+	keepXattrs := fs.Bool("xattrs", false, "send extended attributes (including macOS resource forks) for the receiver to restore")
+	xattrInclude := fs.String("xattr-include", "", "comma-separated attribute name patterns to send (default all)")
+	xattrExclude := fs.String("xattr-exclude", strings.Join(xattr.DefaultExclude, ","), "comma-separated attribute name patterns never to send")
+	relays := fs.String("relays", "", "comma-separated relay addresses to reach the receiver through, in order of preference (resumes prefer the relay last used)")
+	rendezvousID := fs.String("rendezvous", "", "reach a receiver behind a NAT that registered this rendezvous ID with the -orchestrator, by UDP hole punching, instead of giving -receiver; relays the orchestrator plans are used if punching fails")
+	s3Endpoint := fs.String("s3-endpoint", "", "URL of an S3-compatible service serving s3:// -file URLs, e.g. http://127.0.0.1:9000 for MinIO (default AWS)")
+	s3Region := fs.String("s3-region", "", "region of the buckets of s3:// -file URLs (default $AWS_REGION, then us-east-1)")
+	proxyFlag := fs.String("proxy", "", "make connections to receivers, relays and the orchestrator through this proxy: socks5://[user:password@]host:port or http://[user:password@]host:port (CONNECT)")
+	useRelays := fs.Bool("use-relays", false, "ask the -orchestrator for gateway relays to reach the receiver through, chosen by region, latency and load, tried after any -relays")
+	region := fs.String("region", "", "region of this sender, for -use-relays")
+	receiverRegion := fs.String("receiver-region", "", "region of the receiver, for -use-relays")
+	workers := fs.Int("workers", 2, "chunks read, hashed and compressed in parallel ahead of the network (each holds one chunk in memory; 1 streams from disk)")
+	delta := fs.Bool("delta", false, "only send chunks the receiver does not already hold in an earlier version of the file")
+	hashWorkers := fs.Int("hash-workers", runtime.NumCPU(), "chunks hashed in parallel when splitting the source before a transfer (1 reads it sequentially)")
+	adaptiveEffort := fs.Bool("adaptive-effort", false, "vary the zstd level per chunk: higher while chunks wait on the network, lower (down to none with -compression auto) while the network waits on compression (TCP, -workers above 1)")
+	maxBandwidth := fs.String("max-bandwidth", "", "cap the send rate, e.g. 100MB/s or 800mbit (default unlimited)")
+	receiptPath := fs.String("receipt", "", "ask the receiver for a signed delivery receipt and save it to this file (TCP, protocol v10)")
+	receiptKey := fs.String("receipt-key", "", "receiver public key (its receipt.key.pub) the receipt must be signed with (default any key, logged for checking)")
+	receiptTimeout := fs.Duration("receipt-timeout", 5*time.Minute, "how long to wait for the receiver to verify the file and issue the receipt")
+	exportID := fs.String("export", "", "write this session's state to a portable archive (see -export-to) and exit, to resume it on another host")
+	exportTo := fs.String("export-to", "", "archive path for -export (default <session-id>.tsession)")
+	importPath := fs.String("import", "", "add the session in an archive written by -export to -output-dir and exit")
+	authToken := fs.String("auth-token", "", "token to present to receivers that require one (default $TRACKSHIFT_AUTH_TOKEN, which keeps it out of process listings)")
+	reportFile := fs.String("report-file", "", "when the transfer ends, write a JSON summary per session (bytes, throughput, retransmits, chunk failures, verification) to this file, or - for stdout")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address while transferring, e.g. 127.0.0.1:9102")
+	orchestratorURL := fs.String("orchestrator", "", "report session status and progress to the orchestrator at this URL, through which it can be paused, resumed or cancelled")
+	orchestratorToken := fs.String("orchestrator-token", "", "API token with the client scope for an -orchestrator that requires one (default $TRACKSHIFT_ORCHESTRATOR_TOKEN)")
+	reservationID := fs.String("reservation", "", "claim this capacity reservation, made through the receiver's control API, for the transfer")
+	priorityFlag := fs.String("priority", priorityAuto, "chunk send order: offset, edges (the start and end of each file first, where media containers keep their headers and indexes) or auto (edges for audio and video files, else offset); retried chunks always go first (not with adaptive chunking)")
+	priorityEdge := fs.String("priority-edge", "4MB", "bytes at the start and at the end of a file sent first with -priority edges or auto")
+	fs.Parse(args)
+
+	if *exportID != "" || *importPath != "" {
+		store, err := session.OpenStore(*sessionStore, *sessionDir)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		sessMgr, err := session.NewSessionManagerWithStore(store)
+		if err != nil {
+			log.Fatalf("create session manager: %v", err)
+		}
+		defer sessMgr.Close()
+		if *importPath != "" {
+			err = importSession(context.Background(), sessMgr, *importPath)
+		} else {
+			err = exportSession(context.Background(), sessMgr, *exportID, *exportTo)
+		}
+		if err != nil {
+			sessMgr.Close()
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	var proxyURL *url.URL
+	if *proxyFlag != "" {
+		u, err := proxy.Parse(*proxyFlag)
+		if err != nil {
+			log.Fatalf("-proxy: %v", err)
+		}
+		proxyURL = u
+	}
+	newOrchestratorClient := func() *client.OrchestratorClient {
+		orch := client.NewOrchestratorClientWithTimeouts(*orchestratorURL, timeoutCfg.For(*orchestratorURL))
+		orch.Token = *orchestratorToken
+		if orch.Token == "" {
+			orch.Token = os.Getenv("TRACKSHIFT_ORCHESTRATOR_TOKEN")
+		}
+		if proxyURL != nil {
+			if err := proxy.SetHTTPClient(orch.HTTPClient, proxyURL); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+		return orch
+	}
+
+	if *fileList != "" {
+		listed, err := readFileList(*fileList)
+		if err != nil {
+			log.Fatalf("-file-list: %v", err)
+		}
+		files = append(files, listed...)
+	}
+	if *importDir != "" {
+		switch {
+		case len(files) > 0:
+			log.Fatalf("-import-dir sends the file of one chunk directory; give no -file or -file-list with it")
+		case *chunkingMode == "adaptive":
+			log.Fatalf("-import-dir sends the chunks its index lists; adaptive chunking cuts its own")
+		}
+		files = append(files, queuedFile{path: *importDir})
+	}
+	if *rendezvousID != "" && len(files) > 0 {
+		switch {
+		case len(dests) > 0:
+			log.Fatalf("-rendezvous finds the receiver; give no -receiver with it")
+		case *orchestratorURL == "":
+			log.Fatalf("-rendezvous meets the receiver through the orchestrator; give its URL with -orchestrator")
+		}
+		dest, needRelays := punchReceiver(newOrchestratorClient(), *rendezvousID)
+		dests = append(dests, dest)
+		*useRelays = *useRelays || needRelays
+	}
+	if len(files) == 0 || len(dests) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if len(dests) > 1 {
+		switch {
+		case *branchMode != branchConcurrent && *branchMode != branchSequential:
+			log.Fatalf("unknown branch mode %q", *branchMode)
+		case *resumeSession != "":
+			log.Fatalf("-resume continues one session; give it the single -receiver of that session")
+		case *chunkingMode == "adaptive":
+			log.Fatalf("several receivers share a chunk list prepared up front; adaptive chunking has none")
+		case *reservationID != "":
+			log.Fatalf("-reservation names a reservation on one receiver; give it a single -receiver")
+		}
+	}
+	// Several files are queued, each sent in sessions of its own.
+	queue := len(files) > 1
+	if queue {
+		switch {
+		case *resumeSession != "":
+			log.Fatalf("-resume continues one session; give it the single -file of that session")
+		case *reservationID != "":
+			log.Fatalf("-reservation is claimed by one transfer; give a single -file with it")
+		case *queueConcurrency < 1:
+			log.Fatalf("-queue-concurrency must be at least 1")
+		}
+		for i, f := range files {
+			if slices.ContainsFunc(files[:i], func(g queuedFile) bool { return g.path == f.path }) {
+				log.Fatalf("file %s queued twice", f.path)
+			}
+		}
+		sizeQueue(files)
+		if err := orderQueue(files, *queueOrder); err != nil {
+			log.Fatalf("-queue-order: %v", err)
+		}
+	}
+
+	switch *compressionFlag {
+	case "auto", models.CompressionZstd, models.CompressionNone:
+	default:
+		log.Fatalf("unknown compression %q", *compressionFlag)
+	}
+	if *dirMode != "manifest" && *dirMode != "tar" {
+		log.Fatalf("unknown directory mode %q", *dirMode)
+	}
+	switch *priorityFlag {
+	case priorityAuto, priorityOffset, priorityEdges:
+	default:
+		log.Fatalf("unknown priority policy %q", *priorityFlag)
+	}
+	edge, err := genfile.ParseSize(*priorityEdge)
+	if err != nil {
+		log.Fatalf("-priority-edge: %v", err)
+	}
+	rate, err := ratelimit.ParseRate(*maxBandwidth)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if rate > 0 {
+		log.Printf("Send rate capped at %s/s", utils.HumanBytes(int64(rate)))
+	}
+
+	// attrFilter is nil unless extended attributes are to be sent.
+	var attrFilter *xattr.Filter
+	if *keepXattrs {
+		f, err := xattr.ParseFilter(*xattrInclude, *xattrExclude)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		attrFilter = &f
+	}
+
+	algorithm, err := chunker.ParseAlgorithm(*chunkAlgorithm)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *chunkingMode == "adaptive" && algorithm != chunker.AlgorithmFixed {
+		log.Fatalf("adaptive chunking cuts fixed-size chunks; it cannot be combined with -chunker %s", algorithm)
+	}
+	optimizer, err := chunkOptimizer(*optimizerFlag, *optimizerURL)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	store, err := session.OpenStore(*sessionStore, *sessionDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sessMgr, err := session.NewSessionManagerWithStore(store)
+	if err != nil {
+		log.Fatalf("create session manager: %v", err)
+	}
+	defer sessMgr.Close()
+
+	var events *eventlog.Logger
+	if *eventLog != "" {
+		if events, err = eventlog.Open(*eventLog); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer events.Close()
+	}
+	var metrics *telemetry.Metrics
+	if *metricsAddr != "" {
+		metrics = telemetry.NewMetrics()
+		go func() {
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				log.Printf("metrics endpoint: %v", err)
+			}
+		}()
+	}
+
+	// With -orchestrator each branch's session is reported there, and can be
+	// paused or cancelled through it; with -use-relays it also plans the
+	// route.
+	var orch *client.OrchestratorClient
+	if *orchestratorURL != "" {
+		orch = newOrchestratorClient()
+	}
+	relayList := splitList(*relays)
+	if *useRelays {
+		if orch == nil {
+			log.Fatalf("-use-relays asks the orchestrator for relays; give its URL with -orchestrator")
+		}
+		relayList = append(relayList, plannedRelays(orch, *region, *receiverRegion)...)
+	}
+	var newEffort func() *crypto.EffortController
+	if *adaptiveEffort {
+		switch {
+		case *protocolFlag != "tcp":
+			log.Fatalf("-adaptive-effort needs -protocol tcp")
+		case *compressionFlag == models.CompressionNone:
+			log.Fatalf("-adaptive-effort has nothing to adjust with -compression none")
+		case *workers < 2 || *chunkingMode == "adaptive":
+			log.Fatalf("-adaptive-effort needs chunks prepared ahead of the network: -workers above 1 and no adaptive chunking")
+		}
+		// Forced zstd stays compressed; auto may stop compressing.
+		lowest := crypto.EffortOff
+		if *compressionFlag == models.CompressionZstd {
+			lowest = crypto.EffortFastest
+		}
+		newEffort = func() *crypto.EffortController {
+			return crypto.NewEffortController(crypto.EffortDefault, lowest, crypto.EffortBest)
+		}
+	}
+	var receiptOpts *receiptOptions
+	if *receiptPath != "" {
+		if *protocolFlag != "tcp" {
+			log.Fatalf("delivery receipts need -protocol tcp")
+		}
+		receiptOpts = &receiptOptions{path: *receiptPath, timeout: *receiptTimeout}
+		if *receiptKey != "" {
+			if receiptOpts.trusted, err = receipt.LoadPublicKey(*receiptKey); err != nil {
+				log.Fatalf("receipt key: %v", err)
+			}
+		}
+	}
+	token := *authToken
+	if token == "" {
+		token = os.Getenv("TRACKSHIFT_AUTH_TOKEN")
+	}
+
+	var send sendFunc
+	switch *protocolFlag {
+	case "tcp":
+		send = runTCPSender
+	case "udp":
+		send = runUDPSender
+	default:
+		log.Fatalf("unknown protocol %q", *protocolFlag)
+	}
+
+	// The breaker is shared by every file, branch and attempt.
+	breaker := transport.NewRetryManager()
+
+	// Ctrl+C stops the transfer but leaves the session resumable.
+	interrupted := notifyInterrupt()
+
+	remotes := remoteSource{s3Endpoint: *s3Endpoint, s3Region: *s3Region, proxyURL: proxyURL, timeouts: &timeoutCfg}
+
+	// sendPath chunks and hashes the file or directory at path once and
+	// sends it to every receiver, in a session per receiver. progress, if
+	// non-nil, is told the bytes sent, and hides the progress bar.
+	sendPath := func(path string, progress func(int64)) *fileResult {
+		res := &fileResult{path: path}
+		fail := func(format string, a ...any) *fileResult {
+			res.err = fmt.Errorf(format, a...)
+			return res
+		}
+
+		// A URL is read with range requests as it is hashed and chunked,
+		// never stored locally. A chunk directory is read from its chunk
+		// files, verified against its index first.
+		var remote *objstore.Remote
+		var chunkDir *transport.ChunkIndex
+		var info os.FileInfo
+		var err error
+		if *importDir != "" {
+			if chunkDir, err = transport.ReadChunkIndex(path); err != nil {
+				return fail("import: %w", err)
+			}
+			if err := chunkDir.Verify(path); err != nil {
+				return fail("import: verify chunks: %w", err)
+			}
+		} else if objstore.IsRemote(path) {
+			remote, err = remotes.open(context.Background(), path)
+			if err != nil {
+				return fail("open remote file: %w", err)
+			}
+		} else if info, err = os.Stat(path); err != nil {
+			return fail("stat input file: %w", err)
+		}
+
+		// A directory is sent either as the concatenation of its files in
+		// manifest order, or as a tar archive generated on the fly so that
+		// many small files share large chunks. FileMetadata describes that
+		// stream.
+		var tree *models.Manifest
+		var src io.ReaderAt
+		fileMeta := models.FileMetadata{Reservation: *reservationID}
+		switch {
+		case chunkDir != nil:
+			fileMeta.Name, fileMeta.Size = chunkDir.File.Name, chunkDir.File.Size
+		case remote != nil:
+			fileMeta.Name, fileMeta.Size = remote.Name(), remote.Size()
+		default:
+			fileMeta.Name, fileMeta.Size = info.Name(), info.Size()
+		}
+		switch {
+		case chunkDir != nil:
+			// The receiver checks the file the chunks assemble to against
+			// the hash the index records.
+			src = transport.NewChunkDirReader(path, chunkDir)
+			fileMeta.Hash, fileMeta.Archive, fileMeta.Xattrs = chunkDir.File.Hash, chunkDir.File.Archive, chunkDir.File.Xattrs
+			tree = chunkDir.Manifest
+			log.Printf("Chunk directory %s: %d chunks, %s", path, len(chunkDir.Chunks), utils.HumanBytes(fileMeta.Size))
+		case remote != nil:
+			src = remote
+			if fileMeta.Hash, err = utils.HashReaderSHA256(bufio.NewReaderSize(io.NewSectionReader(remote, 0, remote.Size()), remoteReadSize)); err != nil {
+				return fail("hash %s: %w", path, err)
+			}
+			log.Printf("Remote file %s: %s", path, utils.HumanBytes(fileMeta.Size))
+		case info.IsDir() && *dirMode == "tar":
+			scanned, err := manifest.Scan(path)
+			if err != nil {
+				return fail("scan directory: %w", err)
+			}
+			if attrFilter != nil {
+				if err := manifest.ReadXattrs(path, scanned, *attrFilter); err != nil {
+					return fail("read extended attributes: %w", err)
+				}
+			}
+			tr, err := manifest.NewTarReader(path, scanned)
+			if err != nil {
+				return fail("lay out tar archive: %w", err)
+			}
+			defer tr.Close()
+			src = tr
+			fileMeta.Name += ".tar"
+			fileMeta.Size = tr.Size()
+			fileMeta.Archive = models.ArchiveTar
+			if fileMeta.Hash, err = utils.HashReaderSHA256(io.NewSectionReader(tr, 0, tr.Size())); err != nil {
+				return fail("hash tar archive: %w", err)
+			}
+			log.Printf("Directory %s: %d entries as a %s tar stream", scanned.Root, len(scanned.Entries), utils.HumanBytes(fileMeta.Size))
+		case info.IsDir():
+			tree, fileMeta.Hash, err = manifest.Build(path)
+			if err != nil {
+				return fail("build manifest: %w", err)
+			}
+			if attrFilter != nil {
+				if err := manifest.ReadXattrs(path, tree, *attrFilter); err != nil {
+					return fail("read extended attributes: %w", err)
+				}
+			}
+			r := manifest.NewReader(path, tree)
+			defer r.Close()
+			src = r
+			fileMeta.Size = tree.TotalSize()
+			log.Printf("Directory %s: %d entries, %s", tree.Root, len(tree.Entries), utils.HumanBytes(fileMeta.Size))
+		default:
+			fileMeta.Hash, err = utils.HashFileSHA256(path)
+			if err != nil {
+				return fail("hash input file: %w", err)
+			}
+			if attrFilter != nil {
+				if fileMeta.Xattrs, err = xattr.Read(path, *attrFilter); err != nil {
+					return fail("read extended attributes: %w", err)
+				}
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return fail("open input file: %w", err)
+			}
+			defer f.Close()
+			src = f
+		}
+		res.fileMeta = fileMeta
+
+		var sess *models.TransferSession
+		if *resumeSession != "" {
+			sess, err = sessMgr.GetSession(*resumeSession)
+			if err != nil {
+				return fail("load session %s: %w", *resumeSession, err)
+			}
+			if sess.Status == models.SessionStatusCompleted {
+				return fail("session %s already completed", sess.ID)
+			}
+			// A session imported from another host must find the same data.
+			if sess.File.Hash != fileMeta.Hash {
+				return fail("session %s sends %s with SHA-256 %s; %s hashes to %s",
+					sess.ID, sess.File.Name, sess.File.Hash, path, fileMeta.Hash)
+			}
+			progress, _ := sessMgr.Progress(sess.ID)
+			log.Printf("Resuming session %s (%.0f%% complete)", sess.ID, progress)
+		} else {
+			sess, err = sessMgr.CreateSession(fileMeta)
+			if err != nil {
+				return fail("create session: %w", err)
+			}
+			v, err := protocol.NegotiateVersion(protocol.CurrentVersion, uint8(*protocolVersion))
+			if err != nil {
+				return fail("protocol version: %w", err)
+			}
+			sess.ProtocolVersion = v
+		}
+		if err := protocol.CheckVersion(sess.ProtocolVersion); err != nil {
+			return fail("session %s: %w", sess.ID, err)
+		}
+		fileMeta.ProtocolVersion = sess.ProtocolVersion
+		if tree != nil {
+			if !protocol.SupportsManifest(sess.ProtocolVersion) {
+				return fail("session %s: directory transfer needs protocol v%d, session uses v%d",
+					sess.ID, protocol.Version5, sess.ProtocolVersion)
+			}
+			sess.Manifest = tree
+			sess.Files = tree.Files()
+			if err := sess.ValidateFiles(); err != nil {
+				return fail("session %s: %w", sess.ID, err)
+			}
+		}
+		if receiptOpts != nil && !protocol.SupportsReceipts(sess.ProtocolVersion) {
+			return fail("protocol v%d session: delivery receipts need v%d", sess.ProtocolVersion, protocol.Version10)
+		}
+
+		// Receivers older than v2 always zstd-decode chunk payloads.
+		compression := *compressionFlag
+		if !protocol.SupportsChunkCompressionField(sess.ProtocolVersion) && compression != models.CompressionZstd {
+			log.Printf("Protocol v%d session: forcing zstd compression", sess.ProtocolVersion)
+			compression = models.CompressionZstd
+		}
+
+		// Create telemetry collector used by AI chunking and transport.
+		netTelemetry := telemetry.NewTelemetryCollector()
+		metrics.Watch(netTelemetry)
+
+		cfg := chunker.ChunkerConfig{
+			Telemetry:   netTelemetry,
+			HashWorkers: *hashWorkers,
+			Algorithm:   algorithm,
+			Optimizer:   optimizer,
+		}
+		// Decide chunk size either statically or using the AI heuristic.
+		var chosenChunkSize int64
+		switch {
+		case chunkDir != nil:
+			for _, e := range chunkDir.Chunks {
+				chosenChunkSize = max(chosenChunkSize, e.Size)
+			}
+			log.Printf("Chunk directory chunks of up to %s (%d bytes)", utils.HumanBytes(chosenChunkSize), chosenChunkSize)
+		case *chunkingMode == "ai" || *chunkingMode == "adaptive":
+			chosenChunkSize = cfg.ChooseChunkSizeAI(fileMeta)
+			log.Printf("AI chunking selected size: %s (%d bytes)", utils.HumanBytes(chosenChunkSize), chosenChunkSize)
+		default:
+			chosenChunkSize = cfg.ChooseChunkSizeStatic(*chunkSizeFlag)
+			log.Printf("Static chunking using size: %s (%d bytes)", utils.HumanBytes(chosenChunkSize), chosenChunkSize)
+		}
+		if *chunkingMode != "adaptive" {
+			// Chunks cut to another size would not match those already sent.
+			if sess.ChunkSize > 0 && sess.ChunkSize != chosenChunkSize {
+				log.Printf("Session %s keeps its chunk size of %s", sess.ID, utils.HumanBytes(sess.ChunkSize))
+				chosenChunkSize = sess.ChunkSize
+			}
+			sess.ChunkSize = chosenChunkSize
+		}
+
+		// Adaptive chunks are cut while sending, so there is no chunk list
+		// up front.
+		var adaptive *chunker.AdaptiveSizer
+		var chunkMetas []*models.ChunkMetadata
+		switch {
+		case *chunkingMode == "adaptive":
+			adaptive = cfg.NewAdaptiveSizer(chosenChunkSize, netTelemetry)
+		case chunkDir != nil:
+			// The chunks keep the boundaries and hashes of the index.
+			chunkMetas = chunkDir.ChunkMetadata()
+			for _, c := range chunkMetas {
+				c.Status, c.SessionID = models.ChunkStatusPending, ""
+			}
+		default:
+			ch := chunker.NewChunker(cfg)
+			if chunkMetas, err = ch.ChunkReaderAt(src, fileMeta.Size, chosenChunkSize); err != nil {
+				return fail("chunk file: %w", err)
+			}
+		}
+		sess.TotalChunks = len(chunkMetas)
+
+		if err := sessMgr.SaveSession(sess); err != nil {
+			return fail("save session: %w", err)
+		}
+
+		if adaptive != nil {
+			log.Printf("Starting transfer: %s (%s) to %s, adaptively sized chunks over %s\n",
+				fileMeta.Name, utils.HumanBytes(fileMeta.Size), strings.Join(dests, ", "), *protocolFlag)
+		} else {
+			log.Printf("Starting transfer: %s (%s) to %s, %d chunks over %s\n",
+				fileMeta.Name, utils.HumanBytes(fileMeta.Size), strings.Join(dests, ", "), len(chunkMetas), *protocolFlag)
+		}
+
+		opts := senderOptions{
+			compression:     compression,
+			parallelStreams: *parallelStreams,
+			telemetry:       netTelemetry,
+			events:          events,
+			metrics:         metrics,
+			timeouts:        &timeoutCfg,
+			relays:          relayList,
+			breaker:         breaker,
+			proxy:           proxyURL,
+			workers:         *workers,
+			chunkRetries:    *chunkRetries,
+			adaptive:        adaptive,
+			tally:           &report.Tally{},
+			authToken:       token,
+			receipt:         receiptOpts,
+			progress:        progress,
+			// The progress bar would garble a report written to stdout.
+			quiet:       *reportFile == "-" || progress != nil,
+			interrupted: interrupted,
+		}
+		if adaptive == nil {
+			if opts.priority, err = priorityPolicy(*priorityFlag, sess.AllFiles(), edge); err != nil {
+				return fail("%w", err)
+			}
+		}
+		if newEffort != nil {
+			opts.effort = newEffort()
+		}
+		// The limiter exists even without a cap so the receiver can impose
+		// one mid-transfer with a rate control frame.
+		opts.limiter = ratelimit.New(rate)
+		if *delta {
+			switch {
+			case sess.Manifest != nil:
+				log.Printf("Delta transfers apply to single files; sending the directory in full")
+			case adaptive != nil:
+				log.Printf("Delta transfers need a chunk list up front; adaptive chunking sends in full")
+			case chunkDir != nil:
+				log.Printf("Delta transfers match chunks cut by -chunker; sending the chunk directory in full")
+			case !protocol.SupportsDelta(sess.ProtocolVersion):
+				log.Printf("Protocol v%d session: delta transfers need v%d; sending in full", sess.ProtocolVersion, protocol.Version9)
+			default:
+				opts.delta = &transport.DeltaRequest{Algorithm: string(algorithm), ChunkSize: chosenChunkSize}
+			}
+		}
+
+		// Each further receiver gets a session branched from the prepared
+		// one, with its own copy of the chunk list and its own connection
+		// state.
+		branches := []*branch{{dest: dests[0], sess: sess, chunks: chunkMetas, opts: opts}}
+		for _, dest := range dests[1:] {
+			b, err := sessMgr.BranchSession(sess.ID)
+			if err != nil {
+				return fail("branch session: %w", err)
+			}
+			bo := opts
+			bo.telemetry = telemetry.NewTelemetryCollector()
+			bo.tally = &report.Tally{}
+			metrics.Watch(bo.telemetry)
+			bo.limiter = ratelimit.New(rate)
+			if newEffort != nil {
+				bo.effort = newEffort()
+			}
+			branches = append(branches, &branch{dest: dest, sess: b, chunks: cloneChunks(chunkMetas), opts: bo})
+		}
+		if len(branches) > 1 {
+			var cache *chunkCache
+			if *branchMode == branchConcurrent {
+				cache = newChunkCache(len(branches), *workers*len(branches))
+			}
+			for _, b := range branches {
+				log.Printf("Session %s sends to %s", b.sess.ID, b.dest)
+				// Progress bars of concurrent branches would overwrite each
+				// other.
+				b.opts.quiet = b.opts.quiet || *branchMode == branchConcurrent
+				b.opts.shared = cache
+			}
+		}
+		// Receipts of several sessions are saved per session.
+		if receiptOpts != nil && (len(branches) > 1 || queue) {
+			for _, b := range branches {
+				r := *receiptOpts
+				r.path = branchPath(r.path, b.sess.ID)
+				b.opts.receipt = &r
+			}
+		}
+
+		for _, b := range branches {
+			b.opts.pause = &pauseGate{}
+			if orch != nil {
+				b.report = newSessionReporter(orch, b.sess, sessMgr, b.opts.telemetry, b.opts.pause)
+				b.opts.interrupted = b.report.watch(interrupted)
+			}
+		}
+
+		res.branches = branches
+		res.errs = runBranches(branches, *branchMode, func(b *branch) error {
+			b.report.start(b.sess)
+			err := b.report.finish(b.run(send, src, fileMeta, sessMgr, *autoRetry))
+			if errors.Is(err, errCancelled) {
+				if err := sessMgr.SetStatus(b.sess.ID, models.SessionStatusFailed); err != nil {
+					log.Printf("save session: %v", err)
+				}
+			}
+			return err
+		})
+		return res
+	}
+
+	if queue {
+		sendQueue(files, dests, *queueConcurrency, interrupted, sendPath, *reportFile, events)
+		return
+	}
+
+	res := sendPath(files[0].path, nil)
+	if res.err != nil {
+		events.Close()
+		log.Fatalf("%v", res.err)
+	}
+	if *reportFile != "" {
+		writeReports(*reportFile, []*fileResult{res})
+	}
+	if len(res.branches) == 1 {
+		sess := res.branches[0].sess
+		err = res.errs[0]
+		if errors.Is(err, errCancelled) {
+			events.Close()
+			log.Fatalf("Session %s %v", sess.ID, err)
+		}
+		if errors.Is(err, errInterrupted) || (err != nil && isClosed(interrupted)) {
+			events.Close()
+			log.Printf("Transfer interrupted; session %s saved. Resume with:\n  %s", sess.ID, resumeCommand(os.Args, sess.ID))
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
+			events.Close()
+			log.Fatalf("transfer failed: %v; resume with:\n  %s", err, resumeCommand(os.Args, sess.ID))
+		}
+		return
+	}
+
+	failed, stopped := logOutcomes(res, func(b *branch) string {
+		return commandWith(os.Args, "receiver", b.dest, "resume", b.sess.ID)
+	})
+	events.Close()
+	switch {
+	case stopped > 0:
+		os.Exit(exitInterrupted)
+	case failed > 0:
+		log.Fatalf("transfer failed to %d of %d receivers", failed, len(res.branches))
+	}
+}
+
+// stepDown moves sess to version v, which the receiver picked below the one
+// announced, and drops the features of opts the receiver cannot take.
+func stepDown(sess *models.TransferSession, sessMgr *session.SessionManager, v uint8, opts *senderOptions) error {
+	switch {
+	case sess.Manifest != nil && !protocol.SupportsManifest(v):
+		return fmt.Errorf("receiver speaks protocol v%d; directory transfers need v%d", v, protocol.Version5)
+	case opts.receipt != nil && !protocol.SupportsReceipts(v):
+		return fmt.Errorf("receiver speaks protocol v%d; delivery receipts need v%d", v, protocol.Version10)
+	}
+	log.Printf("Receiver speaks protocol v%d; stepping down from v%d", v, sess.ProtocolVersion)
+	sess.ProtocolVersion = v
+	if err := sessMgr.SaveSession(sess); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	// Receivers older than v2 always zstd-decode chunk payloads.
+	if !protocol.SupportsChunkCompressionField(v) {
+		opts.compression, opts.effort = models.CompressionZstd, nil
+	}
+	if opts.delta != nil && !protocol.SupportsDelta(v) {
+		log.Printf("Protocol v%d session: delta transfers need v%d; sending in full", v, protocol.Version9)
+		opts.delta = nil
+	}
+	return nil
+}
+
+// compressForWire applies the selected compression mode to a chunk and returns
+// the payload together with the compression that was actually used. A nil
+// codec compresses at the default level.
+func compressForWire(mode string, data []byte, codec *crypto.Codec) ([]byte, string, error) {
+	if codec == nil {
+		codec = crypto.EffortDefault.Codec()
+	}
+	switch mode {
+	case models.CompressionNone:
+		return data, models.CompressionNone, nil
+	case models.CompressionZstd:
+		out, err := codec.Compress(data)
+		return out, models.CompressionZstd, err
+	default:
+		if !crypto.ShouldCompress(data) {
+			return data, models.CompressionNone, nil
+		}
+		out, err := codec.Compress(data)
+		return out, models.CompressionZstd, err
+	}
+}
+
+// senderOptions carries the transfer settings shared by the TCP and UDP
+// send paths.
+type senderOptions struct {
+	compression     string
+	parallelStreams int
+	telemetry       *telemetry.TelemetryCollector
+	events          *eventlog.Logger
+	// metrics, if non-nil, counts chunks and sessions for the metrics
+	// endpoint.
+	metrics *telemetry.Metrics
+	// tally, if non-nil, collects the session's retransmits and chunk
+	// failures for its summary report.
+	tally *report.Tally
+	// limiter caps the send rate; nil means unlimited. It is shared across
+	// session retries and may be adjusted while a transfer runs.
+	limiter *ratelimit.Limiter
+	// timeouts supplies the socket timeouts for each first hop.
+	timeouts *timeouts.Config
+	// relays are the relays the receiver may be reached through, in order
+	// of preference; empty for a direct connection.
+	relays []string
+	// breaker, shared by every branch and attempt, stops connecting to a
+	// first hop that keeps failing until a trial connection gets through.
+	breaker *transport.RetryManager
+	// proxy, if set, is the proxy connections to the receiver are made
+	// through.
+	proxy *url.URL
+	// workers is the number of chunks prepared ahead of the network in
+	// parallel; 1 streams each chunk straight from disk.
+	workers int
+	// chunkRetries is how often a failed chunk, or a run of connection
+	// failures, is retried over a new connection within one attempt.
+	chunkRetries int
+	// delta, if set, asks the receiver for the chunks of its earlier version
+	// of the file so that those are not sent again.
+	delta *transport.DeltaRequest
+	// adaptive, if set, sizes chunks as they are sent instead of using the
+	// chunk list; it carries what it learned across retries.
+	adaptive *chunker.AdaptiveSizer
+	// effort, if set, picks the compression level of each chunk prepared
+	// ahead of the network.
+	effort *crypto.EffortController
+	// receipt, if set, asks the receiver for a signed delivery receipt.
+	receipt *receiptOptions
+	// authToken, if set, is presented to the receiver before the session.
+	authToken string
+	// shared, if set, shares prepared chunks with the other branches of a
+	// transfer to several receivers.
+	shared *chunkCache
+	// quiet hides the progress bar.
+	quiet bool
+	// progress, if set, is told the bytes of the file sent as they are
+	// sent, for a display covering several transfers.
+	progress func(n int64)
+	// interrupted is closed on Ctrl+C, or on a cancel through the
+	// orchestrator, to stop the transfer.
+	interrupted <-chan struct{}
+	// pause holds the transfer between chunks while paused by a signal or
+	// through the orchestrator.
+	pause *pauseGate
+	// priority, if set, orders the chunks of the chunk list for sending.
+	priority transport.PriorityPolicy
+}
+
+func runTCPSender(receiver string, src io.ReaderAt, fileMeta models.FileMetadata, sess *models.TransferSession,
+	sessMgr *session.SessionManager, chunkMetas []*models.ChunkMetadata, totalSize int64, opts senderOptions) (err error) {
+	compression, netTelemetry, events, metrics := opts.compression, opts.telemetry, opts.events, opts.metrics
+	metrics.SessionStarted()
+	defer metrics.SessionEnded()
+
+	// An interrupt cancels ctx and closes the connection, failing whatever
+	// was being sent. The chunk in flight then goes back to pending for the
+	// resume to send.
+	ctx, cancel := interruptContext(opts.interrupted)
+	defer cancel()
+	var inFlight *models.ChunkMetadata
+	defer func() {
+		if err != nil && inFlight != nil {
+			// A retry of the session sends the chunk ahead of the others.
+			inFlight.RetryCount++
+			metrics.ChunkFailed()
+			opts.tally.ChunkFailed(inFlight.ID, inFlight.Offset, err)
+		}
+		if err == nil || !isClosed(opts.interrupted) {
+			return
+		}
+		if inFlight != nil {
+			if err := sessMgr.UpdateChunkStatus(sess.ID, inFlight.ID, models.ChunkStatusPending); err != nil {
+				log.Printf("update chunk status: %v", err)
+			}
+		}
+		err = errInterrupted
+	}()
+
+	sender := transport.NewTCPSender()
+	sender.Telemetry = netTelemetry
+	sender.Limiter = opts.limiter
+	sender.SessionID = sess.ID
+	sender.Proxy = opts.proxy
+	startDial := time.Now()
+	conn, err := connectRoute(ctx, sender, sess, sessMgr, receiver, opts.relays, opts.timeouts, opts.breaker, netTelemetry)
+	if err != nil {
+		return retryable(fmt.Errorf("connect to receiver: %w", err), nil)
+	}
+	defer conn.Close()
+
+	// Record a simple RTT measurement from TCP connect.
+	if netTelemetry != nil {
+		netTelemetry.RecordRTT(time.Since(startDial))
+	}
+
+	bar := progressbar.NewOptions64(
+		totalSize,
+		progressbar.OptionSetDescription("transferring"),
+		progressbar.OptionSetWidth(15),
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionSetPredictTime(false),
+		progressbar.OptionShowDescriptionAtLineEnd(),
+		progressbar.OptionClearOnFinish(),
+		progressbar.OptionSetVisibility(!opts.quiet),
+	)
+	advance := func(n int64) {
+		_ = bar.Add64(n)
+		if opts.progress != nil {
+			opts.progress(n)
+		}
+	}
+	progress := telemetry.NewProgress(netTelemetry, totalSize)
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	go refreshProgress(bar, progress, stopProgress)
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	done := make(chan struct{})
+	defer close(done)
+
+	// A pause signal is honoured between chunks; see pauseUntilResumed.
+	gate := opts.pause
+	if gate == nil {
+		gate = &pauseGate{}
+	}
+	pauseSignals := make(chan os.Signal, 1)
+	notifyPause(pauseSignals)
+	defer signal.Stop(pauseSignals)
+	go func() {
+		for {
+			select {
+			case <-pauseSignals:
+				if gate.toggle() {
+					log.Println("\nPause requested; pausing after the current chunk")
+				} else {
+					log.Println("\nResuming transfer")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	if pauseSignalName != "" {
+		log.Printf("Send %s (kill -USR1 %d) to pause or resume the transfer", pauseSignalName, os.Getpid())
+	}
+
+	if opts.authToken != "" {
+		if err := sender.Authenticate(ctx, conn, opts.authToken); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	// send file metadata frame first; our session ID lets the receiver pick
+	// up what it kept from an earlier attempt
+	fileMeta.SenderSession = sess.ID
+	metaPayload, err := json.Marshal(fileMeta)
+	if err != nil {
+		return fmt.Errorf("marshal file metadata: %w", err)
+	}
+	metaFrame := &models.ChunkMetadata{
+		ID:          "__filemeta__",
+		SessionID:   sess.ID,
+		Size:        int64(len(metaPayload)),
+		Offset:      0,
+		SHA256:      "",
+		IsParity:    false,
+		Status:      models.ChunkStatusPending,
+		Compression: models.CompressionZstd,
+	}
+	compMetaPayload, err := crypto.CompressChunk(metaPayload)
+	if err != nil {
+		return fmt.Errorf("compress file metadata frame: %w", err)
+	}
+	if err := sender.Send(ctx, conn, compMetaPayload, metaFrame); err != nil {
+		return retryable(fmt.Errorf("send file metadata frame: %w", err), nil)
+	}
+	// Receivers from v12 answer with the version they picked; older ones
+	// send nothing back, and only v1 is safe to speak to them.
+	if protocol.SupportsVersionReply(sess.ProtocolVersion) {
+		v, answered, err := sender.AwaitVersion(ctx, conn, sess.ProtocolVersion)
+		if err != nil {
+			return retryable(fmt.Errorf("version exchange: %w", err), nil)
+		}
+		if !answered {
+			log.Printf("Receiver did not answer protocol v%d; assuming a v1 receiver", sess.ProtocolVersion)
+		}
+		if v != sess.ProtocolVersion {
+			if err := stepDown(sess, sessMgr, v, &opts); err != nil {
+				return err
+			}
+			compression = opts.compression
+		}
+	}
+	if sess.Manifest != nil {
+		if err := sender.SendManifest(ctx, conn, sess.Manifest); err != nil {
+			return fmt.Errorf("send manifest frame: %w", err)
+		}
+	}
+
+	// Estimate the receiver clock offset so per-chunk timestamps yield
+	// meaningful one-way delays even with skewed clocks.
+	if protocol.SupportsTimeSync(sess.ProtocolVersion) {
+		offset, rtt, err := sender.SyncClock(ctx, conn, 5, sender.Timeouts.Handshake)
+		if err != nil {
+			log.Printf("time sync failed, assuming synchronized clocks: %v", err)
+		} else {
+			log.Printf("Receiver clock offset %v (rtt %v)", offset, rtt)
+			if netTelemetry != nil {
+				netTelemetry.SetClockOffset(offset)
+				netTelemetry.RecordRTT(rtt)
+			}
+		}
+	}
+
+	// A session with chunks sent before asks the receiver which it still
+	// holds from that attempt; those are not sent again. Adaptive chunks
+	// are cut afresh, so no earlier chunk can match them.
+	if sess.Completed > 0 && opts.adaptive == nil && protocol.SupportsResume(sess.ProtocolVersion) {
+		held, err := sender.RequestHeldChunks(ctx, conn, transport.ResumeRequest{SessionID: sess.ID})
+		if err != nil {
+			return fmt.Errorf("resume exchange: %w", err)
+		}
+		holds := make(map[string]string, len(held.Chunks))
+		for _, c := range held.Chunks {
+			holds[c.ID] = c.SHA256
+		}
+		remaining := make([]*models.ChunkMetadata, 0, len(chunkMetas))
+		var heldBytes int64
+		for _, meta := range chunkMetas {
+			if sum, ok := holds[meta.ID]; ok && sum == meta.SHA256 {
+				heldBytes += meta.Size
+				if err := sessMgr.UpdateChunkStatus(sess.ID, meta.ID, models.ChunkStatusCompleted); err != nil {
+					log.Printf("update chunk status: %v", err)
+				}
+				continue
+			}
+			remaining = append(remaining, meta)
+		}
+		if skipped := len(chunkMetas) - len(remaining); skipped > 0 {
+			log.Printf("Receiver holds %d chunks (%s) from the earlier attempt; sending the other %d",
+				skipped, utils.HumanBytes(heldBytes), len(remaining))
+			advance(heldBytes)
+		}
+		chunkMetas = remaining
+	}
+
+	// With a delta request the receiver reports the chunks it already holds
+	// in an earlier version of the file; those are copied on its side
+	// instead of being sent.
+	var baseOffsets map[string]int64
+	if opts.delta != nil {
+		base, err := sender.RequestDeltaBase(ctx, conn, *opts.delta)
+		if err != nil {
+			return fmt.Errorf("delta exchange: %w", err)
+		}
+		baseOffsets = make(map[string]int64, len(base.Chunks))
+		for _, c := range base.Chunks {
+			if _, ok := baseOffsets[c.SHA256]; !ok {
+				baseOffsets[c.SHA256] = c.Offset
+			}
+		}
+		var reused, reusedBytes int64
+		for _, meta := range chunkMetas {
+			if _, ok := baseOffsets[meta.SHA256]; ok {
+				reused++
+				reusedBytes += meta.Size
+			}
+		}
+		log.Printf("Delta: receiver holds %d chunks of an earlier version; %d of %d chunks (%s) need not be sent",
+			len(base.Chunks), reused, len(chunkMetas), utils.HumanBytes(reusedBytes))
+	}
+	// reuse returns a copy instruction for meta if the receiver has it.
+	reuse := func(meta *models.ChunkMetadata) (outgoing, bool) {
+		off, ok := baseOffsets[meta.SHA256]
+		return outgoing{meta: meta, reuse: ok, baseOffset: off}, ok
+	}
+
+	// Chunks go out by priority, then in offset order, unless the receiver
+	// asks for a range sooner with a prefetch hint. Adaptive chunks are cut
+	// in offset order as they are taken, so neither applies to them.
+	queue := transport.NewPrefetchQueue(chunkMetas)
+	if opts.priority != nil {
+		queue.Prioritize(opts.priority)
+	}
+	next := func() (*models.ChunkMetadata, error) {
+		idx, ok := queue.Next()
+		if !ok {
+			return nil, io.EOF
+		}
+		return chunkMetas[idx], nil
+	}
+	if opts.adaptive != nil {
+		next = chunker.NewAdaptiveChunks(src, totalSize, opts.adaptive).Next
+	}
+
+	// From here on the receiver may send control frames back to us.
+	receipts := make(chan transport.ReceiptReply, 1)
+	controlClosed := make(chan struct{})
+	if protocol.SupportsRateControl(sess.ProtocolVersion) && opts.limiter != nil {
+		handlers := transport.ControlHandlers{
+			Rate: func(rc transport.RateControl) {
+				opts.limiter.SetRate(rc.BytesPerSec)
+				if rc.BytesPerSec > 0 {
+					log.Printf("Receiver set the send rate to %s/s", utils.HumanBytes(int64(rc.BytesPerSec)))
+				} else {
+					log.Printf("Receiver removed the send rate limit")
+				}
+			},
+		}
+		if protocol.SupportsPrefetch(sess.ProtocolVersion) {
+			handlers.Prefetch = func(h transport.PrefetchHint) {
+				moved := queue.Hint(h)
+				log.Printf("Receiver prefetch %s at offset %d: %d chunks moved ahead",
+					utils.HumanBytes(h.Length), h.Offset, moved)
+			}
+		}
+		if opts.receipt != nil {
+			handlers.Receipt = func(r transport.ReceiptReply) {
+				select {
+				case receipts <- r:
+				default:
+				}
+			}
+		}
+		go func() {
+			defer close(controlClosed)
+			err := sender.ReadControlFrames(ctx, conn, handlers)
+			if err != nil && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				log.Printf("control channel: %v", err)
+			}
+		}()
+	}
+
+	streamed := protocol.SupportsStreamedFrames(sess.ProtocolVersion)
+	// With more than one worker, chunks are read, hashed and compressed ahead
+	// of the network so that CPU work overlaps transmission. A single worker
+	// streams each chunk straight from disk instead. Workers take chunks
+	// from the queue as they start, so a hint reaches the wire after at most
+	// the chunks already being prepared.
+	var ahead *pipeline.Ordered[outgoing]
+	if opts.workers > 1 && opts.adaptive == nil {
+		ahead = pipeline.Start(len(chunkMetas), opts.workers, func(int) (outgoing, error) {
+			idx, _ := queue.Next()
+			if out, ok := reuse(chunkMetas[idx]); ok {
+				return out, nil
+			}
+			if out, ok := opts.shared.take(chunkMetas[idx]); ok {
+				return out, nil
+			}
+			compression, codec := compression, (*crypto.Codec)(nil)
+			if opts.effort != nil {
+				if codec = opts.effort.Effort().Codec(); codec == nil {
+					compression = models.CompressionNone
+				}
+			}
+			out, err := prepareChunk(sender, src, chunkMetas[idx], streamed, compression, codec)
+			out.meta = chunkMetas[idx]
+			if err == nil {
+				opts.shared.put(out)
+			}
+			return out, err
+		})
+		defer ahead.Stop()
+	}
+	for i := 0; opts.adaptive != nil || i < len(chunkMetas); i++ {
+		if resumed := gate.pending(); resumed != nil {
+			if err := pauseUntilResumed(ctx, sender, conn, sess, sessMgr, resumed); err != nil {
+				return err
+			}
+		}
+
+		var out outgoing
+		if ahead != nil {
+			if opts.effort != nil && i > 0 {
+				// Chunks ready ahead of the network show which stage is the
+				// bottleneck; the chunk last sent still holds one slot.
+				if effort, changed := opts.effort.Observe(ahead.Ready(), opts.workers-1); changed {
+					log.Printf("Compression effort now %v", effort)
+				}
+			}
+			if out, err = ahead.Next(i); err != nil {
+				return err
+			}
+		} else {
+			meta, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("cut chunk %d: %w", i, err)
+			}
+			out, _ = reuse(meta)
+		}
+		meta := out.meta
+		meta.SessionID = sess.ID
+		meta.FileID = sess.FileIDAt(meta.Offset)
+		inFlight = meta
+
+		// Chunks already completed by an earlier attempt, or whose send
+		// failed before, are sent again, and count as retransmit overhead.
+		resent, cause := false, eventlog.CauseSessionRetry
+		if prev, ok := sess.Chunks[meta.ID]; ok && prev.Status == models.ChunkStatusCompleted {
+			resent = true
+		} else if meta.RetryCount > 0 {
+			resent, cause = true, eventlog.CauseChunkRetry
+		}
+		record := func(n int64) {
+			netTelemetry.RecordPayloadBytes(n)
+			if resent {
+				netTelemetry.RecordRetransmitBytes(n)
+			}
+			advance(n)
+		}
+
+		if ahead == nil && !streamed && !out.reuse {
+			if cached, ok := opts.shared.take(meta); ok {
+				out = cached
+			} else if out, err = prepareChunk(sender, src, meta, false, compression, nil); err != nil {
+				return err
+			} else {
+				out.meta = meta
+				opts.shared.put(out)
+			}
+		}
+
+		meta.SentAt = time.Now()
+		switch {
+		case out.reuse:
+			if err := sender.SendCopy(ctx, conn, meta, out.baseOffset); err != nil {
+				return retryable(fmt.Errorf("send copy of chunk %s: %w", meta.ID, err), meta)
+			}
+			advance(meta.Size)
+		case out.stream != nil:
+			if err := sender.SendPrepared(ctx, conn, out.stream, record); err != nil {
+				return retryable(fmt.Errorf("send chunk %s: %w", meta.ID, err), meta)
+			}
+		case streamed:
+			// Stream the chunk straight from disk; the chunker already
+			// recorded its hash, so memory stays bounded by the segment size.
+			setStreamCompression(meta, compression)
+			section := &countingReader{r: io.NewSectionReader(src, meta.Offset, meta.Size), record: record}
+			if err := sender.SendStream(ctx, conn, section, meta); err != nil {
+				return retryable(fmt.Errorf("send chunk %s: %w", meta.ID, err), meta)
+			}
+		default:
+			if err := sender.Send(ctx, conn, out.payload, meta); err != nil {
+				return retryable(fmt.Errorf("send chunk %s: %w", meta.ID, err), meta)
+			}
+			record(meta.Size)
+		}
+
+		event := eventlog.Event{
+			Type:       eventlog.EventSent,
+			Session:    sess.ID,
+			Chunk:      meta.ID,
+			Bytes:      meta.Size,
+			DurationMs: eventlog.Millis(time.Since(meta.SentAt)),
+		}
+		if resent {
+			event.Type = eventlog.EventRetransmitted
+			event.Cause = cause
+			metrics.ChunkRetried()
+			opts.tally.Retransmit()
+		}
+		events.Log(event)
+		metrics.ChunkCompleted()
+		metrics.ObserveLatency(time.Since(meta.SentAt))
+
+		if !out.reuse {
+			sess.BytesSent += meta.Size
+		}
+		if opts.adaptive != nil {
+			prev := opts.adaptive.Size()
+			if size := opts.adaptive.Observe(meta.Size, time.Since(meta.SentAt)); size != prev {
+				log.Printf("Adaptive chunking: next chunks %s", utils.HumanBytes(size))
+			}
+		}
+		if err := sessMgr.UpdateChunkStatus(sess.ID, meta.ID, models.ChunkStatusCompleted); err != nil {
+			log.Printf("update chunk status: %v", err)
+		}
+		inFlight = nil
+	}
+
+	_ = bar.Finish()
+	stats := progress.Sample(time.Now())
+	log.Printf("Transfer complete: %s of file data as %s on the wire (ratio %.2f), %s retransmitted.",
+		utils.HumanBytes(stats.Done), utils.HumanBytes(stats.WireBytes), stats.CompressionRatio(),
+		utils.HumanBytes(stats.RetransmitBytes))
+
+	// With a receipt requested, closing our side tells the receiver the
+	// session is over; it answers once the file is verified.
+	if opts.receipt != nil {
+		if err := sender.RequestReceipt(ctx, conn, transport.ReceiptRequest{SessionID: sess.ID}); err != nil {
+			return fmt.Errorf("request receipt: %w", err)
+		}
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			if err := cw.CloseWrite(); err != nil {
+				return fmt.Errorf("request receipt: %w", err)
+			}
+		}
+		log.Printf("Waiting for the receiver to verify the file and sign a receipt")
+		if err := awaitReceipt(receipts, controlClosed, fileMeta, opts.receipt); err != nil {
+			opts.tally.SetVerification(report.Failed)
+			return err
+		}
+		opts.tally.SetVerification(report.Verified)
+	}
+	return nil
+}
+
+// outgoing is a chunk read and encoded ahead of being sent.
+type outgoing struct {
+	meta    *models.ChunkMetadata    // the chunk, as taken from the send queue
+	stream  *transport.PreparedChunk // streamed frames (protocol v3 and later)
+	payload []byte                   // whole-chunk frames otherwise
+
+	// reuse is set when the receiver holds the chunk at baseOffset of its
+	// earlier version, so only a copy frame is sent.
+	reuse      bool
+	baseOffset int64
+}
+
+// prepareChunk reads, hashes and compresses meta's chunk of src for sending,
+// with codec or, if nil, at the default level. Streamed chunks were hashed by
+// the chunker and are compressed per segment.
+func prepareChunk(sender *transport.TCPSender, src io.ReaderAt, meta *models.ChunkMetadata, streamed bool, compression string, codec *crypto.Codec) (outgoing, error) {
+	section := io.NewSectionReader(src, meta.Offset, meta.Size)
+	if streamed {
+		setStreamCompression(meta, compression)
+		p, err := sender.PrepareStreamWith(section, meta, codec)
+		if err != nil {
+			return outgoing{}, fmt.Errorf("prepare chunk %s: %w", meta.ID, err)
+		}
+		return outgoing{stream: p}, nil
+	}
+
+	buf := make([]byte, meta.Size)
+	if _, err := io.ReadFull(section, buf); err != nil {
+		return outgoing{}, fmt.Errorf("read chunk at offset %d: %w", meta.Offset, err)
+	}
+
+	// hash original data
+	dataHash := crypto.HashChunk(buf)
+	meta.SHA256 = fmt.Sprintf("%x", dataHash[:])
+
+	// compress for transport, skipping data that doesn't shrink
+	payload, applied, err := compressForWire(compression, buf, codec)
+	if err != nil {
+		return outgoing{}, fmt.Errorf("compress chunk: %w", err)
+	}
+	meta.Compression = applied
+	return outgoing{payload: payload}, nil
+}
+
+// setStreamCompression resets meta.Compression for a streamed frame: the
+// selected mode, or empty so the transport samples the data for "auto".
+func setStreamCompression(meta *models.ChunkMetadata, compression string) {
+	meta.Compression = ""
+	if compression != "auto" {
+		meta.Compression = compression
+	}
+}
+
+// pauseUntilResumed marks sess paused, checkpoints it and blocks until
+// resumed is closed, or returns errInterrupted once ctx is done. The
+// receiver is told about the pause, and reminded periodically so it keeps the
+// idle connection open.
+func pauseUntilResumed(ctx context.Context, sender *transport.TCPSender, conn net.Conn, sess *models.TransferSession,
+	sessMgr *session.SessionManager, resumed <-chan struct{}) error {
+	if err := sessMgr.SetStatus(sess.ID, models.SessionStatusPaused); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	if err := sessMgr.PersistCheckpoint(sess.ID); err != nil {
+		log.Printf("save checkpoint: %v", err)
+	}
+	notify := protocol.SupportsPause(sess.ProtocolVersion)
+	if notify {
+		log.Printf("Session %s paused", sess.ID)
+	} else {
+		log.Printf("Session %s paused; the receiver predates pause frames and may drop the connection after its read timeout", sess.ID)
+	}
+
+	keepalive := time.NewTicker(pauseKeepalive)
+	defer keepalive.Stop()
+	for waiting := true; waiting; {
+		if notify {
+			if err := sender.SendPause(ctx, conn, true); err != nil {
+				return fmt.Errorf("send pause frame: %w", err)
+			}
+		}
+		select {
+		case <-resumed:
+			waiting = false
+		case <-ctx.Done():
+			return errInterrupted
+		case <-keepalive.C:
+		}
+	}
+
+	if notify {
+		if err := sender.SendPause(ctx, conn, false); err != nil {
+			return fmt.Errorf("send resume frame: %w", err)
+		}
+	}
+	if err := sessMgr.SetStatus(sess.ID, models.SessionStatusTransferring); err != nil {
+		log.Printf("save session: %v", err)
+	}
+	log.Printf("Session %s resumed", sess.ID)
+	return nil
+}
+
+// countingReader reports every read to record, so progress advances with the
+// data actually handed to the transport rather than once per chunk.
+type countingReader struct {
+	r      io.Reader
+	record func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.record(int64(n))
+	return n, err
+}
+
+// refreshProgress updates the bar description with smoothed statistics from
+// progress until stop is closed.
+func refreshProgress(bar *progressbar.ProgressBar, progress *telemetry.Progress, stop <-chan struct{}) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			bar.Describe(formatProgress(progress.Sample(now)))
+		}
+	}
+}
+
+// formatProgress renders stats as a one-line progress description.
+func formatProgress(s telemetry.ProgressStats) string {
+	eta := "--"
+	if s.ETA > 0 {
+		eta = s.ETA.Round(time.Second).String()
+	}
+	line := fmt.Sprintf("%s/%s %s/s ETA %s | wire %s/s (x%.2f)",
+		utils.HumanBytes(s.Done), utils.HumanBytes(s.Total), utils.HumanBytes(int64(s.Rate)), eta,
+		utils.HumanBytes(int64(s.WireRate)), s.CompressionRatio())
+	if s.RetransmitBytes > 0 {
+		line += fmt.Sprintf(" | retx %s", utils.HumanBytes(s.RetransmitBytes))
+	}
+	return line
+}
+
+func runUDPSender(receiver string, src io.ReaderAt, fileMeta models.FileMetadata, sess *models.TransferSession,
+	sessMgr *session.SessionManager, chunkMetas []*models.ChunkMetadata, totalSize int64, opts senderOptions) error {
+	// UDP implementation will be added in the next iteration; for now fall back to TCP
+	log.Println("UDP protocol not yet fully implemented; falling back to TCP for now")
+	return runTCPSender(receiver, src, fileMeta, sess, sessMgr, chunkMetas, totalSize, opts)
+}
@@ -0,0 +1,32 @@
+package send
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+)
+
+// chunkOptimizer builds the optimizer for -chunking-mode ai from a
+// comma-separated list of optimizers tried in order: heuristic, service
+// (the optimizer service at serviceURL) or hf (Hugging Face, with the token
+// in HF_API_TOKEN). The heuristic remains the fallback when all fail.
+func chunkOptimizer(names, serviceURL string) (chunker.ChunkSizeOptimizer, error) {
+	var opts chunker.Optimizers
+	for _, name := range splitList(names) {
+		switch name {
+		case "heuristic":
+			opts = append(opts, chunker.HeuristicOptimizer{})
+		case "service":
+			opts = append(opts, chunker.ServiceOptimizer{URL: serviceURL})
+		case "hf":
+			opts = append(opts, chunker.HuggingFaceOptimizer{Token: os.Getenv("HF_API_TOKEN")})
+		default:
+			return nil, fmt.Errorf("unknown chunk size optimizer %q (want heuristic, service or hf)", name)
+		}
+	}
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	return opts, nil
+}
@@ -0,0 +1,167 @@
+package send
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// reportInterval is how often a session's progress is reported to the
+// orchestrator, and so how soon a pause or cancel requested there is seen.
+const reportInterval = 2 * time.Second
+
+// errCancelled is returned by a transfer cancelled through the orchestrator.
+var errCancelled = errors.New("cancelled through the orchestrator")
+
+// sessionReporter keeps the orchestrator's record of one session up to date
+// and carries out the pause and cancel requests it hands back. A nil
+// *sessionReporter does nothing.
+type sessionReporter struct {
+	client    *client.OrchestratorClient
+	id        string
+	sessMgr   *session.SessionManager
+	telemetry *telemetry.TelemetryCollector
+	gate      *pauseGate
+
+	cancelled chan struct{} // closed on a cancel request
+	stop      chan struct{} // closed by finish
+	done      chan struct{} // closed when the report loop exits
+
+	mu       sync.Mutex
+	last     models.SessionStatus // last status reported, accepted or not
+	pausedBy bool                 // the current pause was requested there
+}
+
+func newSessionReporter(c *client.OrchestratorClient, sess *models.TransferSession, sessMgr *session.SessionManager, tel *telemetry.TelemetryCollector, gate *pauseGate) *sessionReporter {
+	return &sessionReporter{
+		client:    c,
+		id:        sess.ID,
+		sessMgr:   sessMgr,
+		telemetry: tel,
+		gate:      gate,
+		cancelled: make(chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// watch returns a channel closed once interrupted is or the session is
+// cancelled, to stop the transfer either way.
+func (r *sessionReporter) watch(interrupted <-chan struct{}) <-chan struct{} {
+	if r == nil {
+		return interrupted
+	}
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupted:
+		case <-r.cancelled:
+		}
+		close(stopped)
+	}()
+	return stopped
+}
+
+// start registers the session with the orchestrator and reports on it until
+// finish is called.
+func (r *sessionReporter) start(sess *models.TransferSession) {
+	if r == nil {
+		return
+	}
+	if err := r.client.RegisterSession(sess); err != nil {
+		log.Printf("orchestrator: register session %s: %v", r.id, err)
+	}
+	go func() {
+		defer close(r.done)
+		t := time.NewTicker(reportInterval)
+		defer t.Stop()
+		for {
+			status := models.SessionStatusTransferring
+			if r.gate.pending() != nil {
+				status = models.SessionStatusPaused
+			}
+			r.report(status)
+			select {
+			case <-t.C:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish stops reporting, reports how the transfer ended with err, and
+// returns err, or errCancelled if the session was cancelled.
+func (r *sessionReporter) finish(err error) error {
+	if r == nil {
+		return err
+	}
+	close(r.stop)
+	<-r.done
+	if isClosed(r.cancelled) {
+		err = errCancelled
+	}
+	status := models.SessionStatusCompleted
+	switch {
+	case errors.Is(err, errInterrupted):
+		status = models.SessionStatusPaused
+	case err != nil:
+		status = models.SessionStatusFailed
+	}
+	r.report(status)
+	return err
+}
+
+// report sends the session's status, if it changed, and progress, its bytes
+// sent and chunk counts, then acts on the control the orchestrator replies
+// with.
+func (r *sessionReporter) report(status models.SessionStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u := orchestrator.SessionUpdate{}
+	if status != r.last {
+		u.Status = &status
+		r.last = status
+	}
+	sent := int64(r.telemetry.Counters().PayloadBytes)
+	u.BytesSent = &sent
+	if total, completed, failed, err := r.sessMgr.Counts(r.id); err == nil {
+		u.TotalChunks, u.Completed, u.Failed = &total, &completed, &failed
+	}
+	sess, err := r.client.UpdateSession(r.id, u)
+	if err != nil && u.Status != nil {
+		// A refused status change, e.g. resuming a session cancelled
+		// there, still leaves the control to learn from a progress report.
+		log.Printf("orchestrator: report session %s as %s: %v", r.id, status, err)
+		u.Status = nil
+		sess, err = r.client.UpdateSession(r.id, u)
+	}
+	if err != nil {
+		log.Printf("orchestrator: report session %s: %v", r.id, err)
+		return
+	}
+	switch sess.Control {
+	case models.SessionControlCancel:
+		if !isClosed(r.cancelled) {
+			log.Printf("\nSession %s cancelled through the orchestrator; stopping", r.id)
+			close(r.cancelled)
+		}
+	case models.SessionControlPause:
+		if r.gate.set(true) {
+			r.pausedBy = true
+			log.Println("\nPause requested through the orchestrator; pausing after the current chunk")
+		}
+	default:
+		if r.pausedBy && r.gate.set(false) {
+			log.Println("\nResume requested through the orchestrator")
+		}
+		r.pausedBy = false
+	}
+}
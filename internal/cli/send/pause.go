@@ -0,0 +1,61 @@
+package send
+
+import (
+	"sync"
+	"time"
+)
+
+// pauseKeepalive is how often a paused sender repeats its pause frame so the
+// receiver does not time out the idle connection.
+const pauseKeepalive = 30 * time.Second
+
+// pauseGate records pause requests, which the send loop honours between
+// chunks.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // closed when a pause ends
+}
+
+// toggle pauses a running transfer or resumes a paused one and reports
+// whether it is now paused.
+func (g *pauseGate) toggle() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	} else {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+	return g.paused
+}
+
+// pending returns a channel that is closed once the transfer is resumed, or
+// nil if it is not paused.
+func (g *pauseGate) pending() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return nil
+	}
+	return g.resumed
+}
+
+// set pauses or resumes the transfer and reports whether that changed
+// anything.
+func (g *pauseGate) set(paused bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == paused {
+		return false
+	}
+	g.paused = paused
+	if paused {
+		g.resumed = make(chan struct{})
+	} else {
+		close(g.resumed)
+	}
+	return true
+}
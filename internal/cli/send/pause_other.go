@@ -0,0 +1,11 @@
+//go:build !unix
+
+package send
+
+import "os"
+
+// pauseSignalName is empty where no signal is available for pausing.
+const pauseSignalName = ""
+
+// notifyPause is a no-op on platforms without SIGUSR1.
+func notifyPause(c chan<- os.Signal) {}
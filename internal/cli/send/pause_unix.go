@@ -0,0 +1,17 @@
+//go:build unix
+
+package send
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// pauseSignalName is shown in log messages explaining how to pause.
+const pauseSignalName = "SIGUSR1"
+
+// notifyPause relays the signal that toggles pausing to c.
+func notifyPause(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,69 @@
+package send
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// Send orders selected with -priority.
+const (
+	priorityAuto   = "auto"
+	priorityOffset = "offset"
+	priorityEdges  = "edges"
+)
+
+// mediaExtensions are the file extensions of audio and video containers,
+// whose headers and indexes sit at either end of the file.
+var mediaExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".m4a": true, ".mov": true, ".mkv": true, ".webm": true,
+	".avi": true, ".mxf": true, ".ts": true, ".mts": true, ".mp3": true, ".flac": true,
+	".wav": true, ".ogg": true, ".opus": true,
+}
+
+// priorityPolicy returns the policy ordering the chunks of files for mode:
+// offset order, the first and last edge bytes of every file first, or with
+// auto those of media files only. Chunks retried after a failed attempt
+// are raised above the others of their priority either way.
+func priorityPolicy(mode string, files []models.FileMetadata, edge int64) (transport.PriorityPolicy, error) {
+	var base transport.PriorityPolicy = transport.OffsetOrder
+	switch mode {
+	case priorityOffset:
+	case priorityEdges:
+		base = transport.EdgesFirst{Files: files, Head: edge, Tail: edge}
+	case priorityAuto:
+		var media []models.FileMetadata
+		for _, f := range files {
+			if isMedia(f) {
+				media = append(media, f)
+			}
+		}
+		if len(media) > 0 {
+			base = transport.EdgesFirst{Files: media, Head: edge, Tail: edge}
+		}
+	default:
+		return nil, fmt.Errorf("unknown priority policy %q", mode)
+	}
+	if edges, ok := base.(transport.EdgesFirst); ok {
+		if len(edges.Files) == 1 {
+			log.Printf("Sending the first and last %s of %s first", utils.HumanBytes(edge), edges.Files[0].Name)
+		} else {
+			log.Printf("Sending the first and last %s of each of %d files first", utils.HumanBytes(edge), len(edges.Files))
+		}
+	}
+	return transport.RetriesFirst{Policy: base}, nil
+}
+
+// isMedia reports whether f is an audio or video file, by its MIME type if
+// known or else its extension.
+func isMedia(f models.FileMetadata) bool {
+	if f.MimeType != "" {
+		return strings.HasPrefix(f.MimeType, "video/") || strings.HasPrefix(f.MimeType, "audio/")
+	}
+	return mediaExtensions[strings.ToLower(filepath.Ext(f.Name))]
+}
@@ -0,0 +1,285 @@
+package send
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// Queue orders: the order in which the files given to one send command are
+// sent.
+const (
+	queueGiven    = "given"
+	queueSmallest = "smallest-first"
+	queuePriority = "priority"
+)
+
+// queuedFile is one file or directory of a send queue.
+type queuedFile struct {
+	path string
+	// priority orders the queue with -queue-order priority, highest first.
+	priority int
+	// size is the bytes to send, a directory's in total; 0 until sized.
+	size int64
+}
+
+// fileResult is how the transfer of one queued file ended: the outcome of
+// each of its branches, one per receiver, or err if it could not be
+// prepared and no branch ran.
+type fileResult struct {
+	path     string
+	fileMeta models.FileMetadata
+	branches []*branch
+	errs     []error
+	err      error
+}
+
+// ok reports whether the file reached every receiver.
+func (r *fileResult) ok() bool {
+	if r.err != nil {
+		return false
+	}
+	for _, err := range r.errs {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// readFileList reads the files listed at path, one per line. A line may end
+// with a tab and a priority for -queue-order priority; blank lines and lines
+// starting with # are skipped.
+func readFileList(path string) ([]queuedFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []queuedFile
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, prio, hasPrio := strings.Cut(line, "\t")
+		f := queuedFile{path: p}
+		if hasPrio {
+			if f.priority, err = strconv.Atoi(strings.TrimSpace(prio)); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad priority %q", path, i+1, prio)
+			}
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s lists no files", path)
+	}
+	return files, nil
+}
+
+// sizeQueue records the size of every file in files.
+func sizeQueue(files []queuedFile) {
+	for i := range files {
+		files[i].size = pathSize(files[i].path)
+	}
+}
+
+// pathSize returns the size of the file at path, or the total size of the
+// regular files under it if it is a directory. What cannot be read counts
+// as empty; sending it reports the error.
+func pathSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// orderQueue sorts sized files into the order they are to be sent in,
+// keeping the order they were given in among equals.
+func orderQueue(files []queuedFile, order string) error {
+	switch order {
+	case queueGiven:
+	case queueSmallest:
+		slices.SortStableFunc(files, func(a, b queuedFile) int { return cmp.Compare(a.size, b.size) })
+	case queuePriority:
+		slices.SortStableFunc(files, func(a, b queuedFile) int { return cmp.Compare(b.priority, a.priority) })
+	default:
+		return fmt.Errorf("unknown queue order %q (want given, smallest-first or priority)", order)
+	}
+	return nil
+}
+
+// runQueue sends files in order with send, at most concurrency at once, and
+// returns their results in the same order. Files not started before an
+// interrupt fail with errInterrupted.
+func runQueue(files []queuedFile, concurrency int, interrupted <-chan struct{}, send func(queuedFile) *fileResult) []*fileResult {
+	results := make([]*fileResult, len(files))
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, f := range files {
+		slots <- struct{}{}
+		if isClosed(interrupted) {
+			results[i] = &fileResult{path: f.path, err: errInterrupted}
+			<-slots
+			continue
+		}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			results[i] = send(f)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// queueProgress is the progress display of a send queue: one bar for the
+// bytes sent of all its files, described by how many have been sent. The
+// files' own progress bars are hidden.
+type queueProgress struct {
+	bar            *progressbar.ProgressBar
+	files          int
+	sent, finished atomic.Int32
+}
+
+// newQueueProgress displays the progress of files files of total bytes,
+// unless visible is false.
+func newQueueProgress(total int64, files int, visible bool) *queueProgress {
+	p := &queueProgress{files: files}
+	p.bar = progressbar.NewOptions64(
+		total,
+		progressbar.OptionSetDescription(p.describe()),
+		progressbar.OptionSetWidth(15),
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionSetPredictTime(false),
+		progressbar.OptionShowDescriptionAtLineEnd(),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionClearOnFinish(),
+		progressbar.OptionSetVisibility(visible),
+	)
+	return p
+}
+
+func (p *queueProgress) describe() string {
+	desc := fmt.Sprintf("%d of %d files sent", p.sent.Load(), p.files)
+	if failed := p.finished.Load() - p.sent.Load(); failed > 0 {
+		desc += fmt.Sprintf(", %d failed", failed)
+	}
+	return desc
+}
+
+// add counts n bytes sent.
+func (p *queueProgress) add(n int64) {
+	_ = p.bar.Add64(n)
+}
+
+// done counts a file finished, sent to every receiver or not.
+func (p *queueProgress) done(ok bool) {
+	if ok {
+		p.sent.Add(1)
+	}
+	p.finished.Add(1)
+	p.bar.Describe(p.describe())
+}
+
+func (p *queueProgress) close() {
+	_ = p.bar.Finish()
+}
+
+// logOutcomes logs how each branch of res ended, with the command resume
+// returns for those that did not complete, and returns how many failed and
+// how many were interrupted.
+func logOutcomes(res *fileResult, resume func(b *branch) string) (failed, stopped int) {
+	for i, b := range res.branches {
+		switch err := res.errs[i]; {
+		case errors.Is(err, errCancelled):
+			failed++
+			log.Printf("Transfer of %s to %s %v (session %s)", res.fileMeta.Name, b.dest, err, b.sess.ID)
+		case errors.Is(err, errInterrupted) || (err != nil && isClosed(b.opts.interrupted)):
+			stopped++
+			log.Printf("Transfer of %s to %s interrupted; session %s saved. Resume with:\n  %s", res.fileMeta.Name, b.dest, b.sess.ID, resume(b))
+		case err != nil:
+			failed++
+			log.Printf("Transfer of %s to %s failed: %v; resume with:\n  %s", res.fileMeta.Name, b.dest, err, resume(b))
+		default:
+			log.Printf("Transfer of %s to %s complete (session %s)", res.fileMeta.Name, b.dest, b.sess.ID)
+		}
+	}
+	return failed, stopped
+}
+
+// sendQueue sends the queued files to dests with sendPath, concurrency at a
+// time, behind one progress display, then logs how each ended and exits
+// as a single transfer would: with exitInterrupted if any was interrupted,
+// or failing if any failed.
+func sendQueue(files []queuedFile, dests []string, concurrency int, interrupted <-chan struct{},
+	sendPath func(path string, progress func(int64)) *fileResult, reportFile string, events *eventlog.Logger) {
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	log.Printf("Queued %d files (%s) for %s, %d at a time", len(files), utils.HumanBytes(total), strings.Join(dests, ", "), concurrency)
+	display := newQueueProgress(total*int64(len(dests)), len(files), reportFile != "-")
+	results := runQueue(files, concurrency, interrupted, func(f queuedFile) *fileResult {
+		res := sendPath(f.path, display.add)
+		display.done(res.ok())
+		return res
+	})
+	display.close()
+	if reportFile != "" {
+		writeReports(reportFile, results)
+	}
+
+	var sent, failed, stopped int
+	for _, res := range results {
+		switch {
+		case errors.Is(res.err, errInterrupted):
+			stopped++
+			log.Printf("File %s not sent: interrupted", res.path)
+		case res.err != nil:
+			failed++
+			log.Printf("File %s failed: %v", res.path, res.err)
+		default:
+			f, s := logOutcomes(res, func(b *branch) string {
+				return commandWith(os.Args, "file", res.path, "file-list", "", "receiver", b.dest, "resume", b.sess.ID)
+			})
+			switch {
+			case s > 0:
+				stopped++
+			case f > 0:
+				failed++
+			default:
+				sent++
+			}
+		}
+	}
+	log.Printf("Queue finished: %d of %d files sent, %d failed, %d interrupted", sent, len(results), failed, stopped)
+	events.Close()
+	switch {
+	case stopped > 0:
+		os.Exit(exitInterrupted)
+	case failed > 0:
+		log.Fatalf("transfer failed for %d of %d files", failed, len(results))
+	}
+}
@@ -0,0 +1,61 @@
+package send
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// receiptOptions asks the receiver for a signed delivery receipt.
+type receiptOptions struct {
+	// path is where the receipt is saved.
+	path string
+	// trusted, if set, is the only key receipts may be signed with.
+	trusted ed25519.PublicKey
+	// timeout bounds the wait for the receiver to verify the file.
+	timeout time.Duration
+}
+
+// awaitReceipt waits for the receiver's answer to a receipt request, checks
+// that the receipt is signed and covers fileMeta, and saves it. closed is
+// closed when the receiver stops sending control frames.
+func awaitReceipt(replies <-chan transport.ReceiptReply, closed <-chan struct{}, fileMeta models.FileMetadata, opts *receiptOptions) error {
+	var reply transport.ReceiptReply
+	select {
+	case reply = <-replies:
+	case <-closed:
+		select {
+		case reply = <-replies:
+		default:
+			return errors.New("receiver closed the connection without a receipt")
+		}
+	case <-time.After(opts.timeout):
+		return fmt.Errorf("no receipt from the receiver within %v", opts.timeout)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("receiver issued no receipt: %s", reply.Error)
+	}
+	r := reply.Receipt
+	if err := r.Verify(opts.trusted); err != nil {
+		return err
+	}
+	if r.FileHash != fileMeta.Hash || r.Size != fileMeta.Size {
+		return fmt.Errorf("receipt is for a different file (hash %s, %d bytes)", r.FileHash, r.Size)
+	}
+	if err := r.Write(opts.path); err != nil {
+		return fmt.Errorf("save receipt: %w", err)
+	}
+	trust := "untrusted key"
+	if opts.trusted != nil {
+		trust = "trusted key"
+	}
+	log.Printf("Delivery receipt from %s saved to %s (signed by %s %s)",
+		r.Receiver, opts.path, trust, receipt.Fingerprint(r.PublicKey))
+	return nil
+}
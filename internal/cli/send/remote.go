@@ -0,0 +1,48 @@
+package send
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/internal/objstore"
+	"github.com/deb2000-sudo/trackshift/internal/proxy"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+)
+
+// remoteReadSize is how much of a remote file is fetched per range request
+// while it is hashed; chunks are fetched a chunk at a time.
+const remoteReadSize = 8 << 20
+
+// remoteSource opens the s3:// and http(s):// URLs given to -file.
+type remoteSource struct {
+	s3Endpoint, s3Region string
+	proxyURL             *url.URL
+	timeouts             *timeouts.Config
+}
+
+// open opens the file at rawURL for reading with range requests.
+func (rs remoteSource) open(ctx context.Context, rawURL string) (*objstore.Remote, error) {
+	client := rs.timeouts.For(rawURL).HTTPClient()
+	// -timeouts http bounds orchestrator requests; ranges of a chunk take
+	// longer, and objstore bounds them itself.
+	client.Timeout = 0
+	if rs.proxyURL != nil {
+		if err := proxy.SetHTTPClient(client, rs.proxyURL); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(strings.ToLower(rawURL), "s3://") {
+		return objstore.OpenURL(ctx, client, rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s3, err := objstore.NewS3("s3://"+u.Host, rs.s3Endpoint, rs.s3Region, objstore.EnvCredentials())
+	if err != nil {
+		return nil, err
+	}
+	s3.Client = client
+	return s3.Open(ctx, strings.TrimPrefix(u.Path, "/"))
+}
@@ -0,0 +1,48 @@
+package send
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+)
+
+// rendezvousWait bounds how long a sender waits for the receiver of a
+// rendezvous to turn up at the orchestrator.
+const rendezvousWait = time.Minute
+
+// punchReceiver punches a hole to the receiver registered with orch for
+// rendezvous id and returns the address to send to. If punching fails the
+// receiver's public address is returned instead, to be tried before the
+// relays the caller must then add, and needRelays is set.
+func punchReceiver(orch *client.OrchestratorClient, id string) (dest string, needRelays bool) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		log.Fatalf("rendezvous %s: %v", id, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rendezvousWait)
+	defer cancel()
+	addr, peer, err := orch.Rendezvous(ctx, conn, id, orchestrator.RendezvousParty{Role: orchestrator.RoleSender}, time.Time{})
+	switch {
+	case err == nil:
+		dest = net.JoinHostPort(addr.IP.String(), strconv.Itoa(peer.Port))
+		log.Printf("Rendezvous %s: reached the receiver at %s", id, dest)
+		return dest, false
+	case errors.Is(err, transport.ErrPunchFailed) && peer != nil:
+		host, _, _ := net.SplitHostPort(peer.Candidates[0])
+		dest = net.JoinHostPort(host, strconv.Itoa(peer.Port))
+		log.Printf("Rendezvous %s: %v; falling back to relays to reach %s", id, err, dest)
+		return dest, true
+	default:
+		log.Fatalf("%v", err)
+		return "", false
+	}
+}
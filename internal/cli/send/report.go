@@ -0,0 +1,64 @@
+package send
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/report"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// writeReports writes a summary of each branch of every file in results to
+// the report file at path. Files that could not be prepared have no session
+// to summarise.
+func writeReports(path string, results []*fileResult) {
+	w, err := report.Open(path)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	defer w.Close()
+	now := time.Now()
+	for _, res := range results {
+		for i, b := range res.branches {
+			if err := w.Write(branchSummary(b, res.errs[i], res.fileMeta, now)); err != nil {
+				log.Printf("write report: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// branchSummary summarises b as of now. The byte counts come from the
+// branch's telemetry, so they cover this run only.
+func branchSummary(b *branch, err error, fileMeta models.FileMetadata, now time.Time) report.Summary {
+	c := b.opts.telemetry.Counters()
+	s := report.Summary{
+		Role:            report.RoleSender,
+		Session:         b.sess.ID,
+		File:            fileMeta.Name,
+		Peer:            b.dest,
+		Status:          report.StatusCompleted,
+		Bytes:           fileMeta.Size,
+		Transferred:     int64(c.PayloadBytes),
+		WireBytes:       int64(c.WireBytes),
+		RetransmitBytes: int64(c.RetransmitBytes),
+		StartedAt:       b.started,
+		Verification:    report.Unverified,
+	}
+	if s.StartedAt.IsZero() {
+		s.StartedAt = now
+	}
+	switch {
+	case errors.Is(err, errCancelled):
+		s.Status, s.Error = report.StatusFailed, err.Error()
+	case errors.Is(err, errInterrupted) || (err != nil && isClosed(b.opts.interrupted)):
+		s.Status, s.Error = report.StatusInterrupted, err.Error()
+	case err != nil:
+		s.Status, s.Error = report.StatusFailed, err.Error()
+	}
+	b.opts.tally.Fill(&s)
+	s.Finish(now)
+	return s
+}
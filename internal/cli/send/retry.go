@@ -0,0 +1,62 @@
+package send
+
+import (
+	"errors"
+	"io"
+	"log"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// retryableError is a failure of a send attempt that reconnecting may cure:
+// the connection could not be made, or dropped while chunk was being sent.
+type retryableError struct {
+	err error
+	// chunk is the chunk that failed, if any.
+	chunk *models.ChunkMetadata
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// retryable marks err, the failure of chunk or of the connection if chunk
+// is nil, as worth reconnecting for.
+func retryable(err error, chunk *models.ChunkMetadata) error {
+	return &retryableError{err: err, chunk: chunk}
+}
+
+// sendRetrying calls send for b and, when the connection or a chunk fails,
+// reconnects with backoff and continues the session, the failed chunk
+// first. It gives up once one chunk has failed, or attempts in a row have
+// failed without completing a chunk, more than b.opts.chunkRetries times.
+func (b *branch) sendRetrying(send sendFunc, src io.ReaderAt, fileMeta models.FileMetadata, sessMgr *session.SessionManager) error {
+	retry := transport.NewRetryManager()
+	retry.MaxRetries = b.opts.chunkRetries
+	retry.Abort = b.opts.interrupted
+
+	for strikes := 0; ; {
+		completed := b.sess.Completed
+		err := send(b.dest, src, fileMeta, b.sess, sessMgr, b.chunks, fileMeta.Size, b.opts)
+		var re *retryableError
+		if err == nil || !errors.As(err, &re) || isClosed(b.opts.interrupted) {
+			return err
+		}
+		if b.sess.Completed > completed {
+			strikes = 0
+		}
+		strikes++
+		if re.chunk != nil && re.chunk.RetryCount > retry.MaxRetries {
+			log.Printf("Chunk %s failed %d times; giving up", re.chunk.ID, re.chunk.RetryCount)
+			return err
+		}
+		if !retry.ShouldRetry(strikes-1, err) {
+			return err
+		}
+		log.Printf("Session %s: %v; reconnecting to continue (retry %d of %d)", b.sess.ID, err, strikes, retry.MaxRetries)
+		if !retry.Backoff(strikes) {
+			return errInterrupted
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package send
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// routeCandidates returns the routes to try in order: the route the session
+// last used, then each configured relay, or a direct connection to receiver
+// when no relays are configured.
+func routeCandidates(prev *models.Route, relays []string, receiver string) []models.Route {
+	var out []models.Route
+	add := func(r models.Route) {
+		for _, c := range out {
+			if c.Same(r) {
+				return
+			}
+		}
+		out = append(out, r)
+	}
+	if prev != nil && prev.Receiver == receiver {
+		add(models.Route{Relay: prev.Relay, Receiver: receiver})
+	}
+	for _, relay := range relays {
+		add(models.Route{Relay: relay, Receiver: receiver})
+	}
+	if len(relays) == 0 {
+		add(models.Route{Receiver: receiver})
+	}
+	return out
+}
+
+// connectRoute connects sender to the first reachable route for sess,
+// preferring the one it last used, and records the route taken in the
+// session. Timeouts are resolved for each first hop. Routes whose first hop
+// has failed repeatedly are skipped while breaker, if set, holds its circuit
+// open.
+func connectRoute(ctx context.Context, sender *transport.TCPSender, sess *models.TransferSession, sessMgr *session.SessionManager,
+	receiver string, relays []string, cfg *timeouts.Config, breaker *transport.RetryManager, netTelemetry *telemetry.TelemetryCollector) (net.Conn, error) {
+	var errs []error
+	for _, r := range routeCandidates(sess.Route, relays, receiver) {
+		hop := r.FirstHop()
+		if breaker != nil {
+			if err := breaker.Allow(hop); err != nil {
+				log.Printf("Route %s skipped: %v", r, err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		sender.Timeouts = cfg.For(hop)
+		conn, err := sender.Connect(ctx, hop)
+		if err != nil {
+			log.Printf("Route %s unavailable: %v", r, err)
+			errs = append(errs, err)
+			if breaker != nil && ctx.Err() == nil {
+				breaker.RecordFailure(hop, err)
+			}
+			continue
+		}
+		if breaker != nil {
+			breaker.RecordSuccess(hop)
+		}
+		if sess.Route == nil || !sess.Route.Same(r) {
+			if sess.Route != nil {
+				// The handshake on the new path starts from scratch; a clock
+				// offset measured over the old one no longer applies.
+				log.Printf("Session %s switching route from %s to %s; renegotiating", sess.ID, sess.Route, r)
+				if netTelemetry != nil {
+					netTelemetry.SetClockOffset(0)
+				}
+			}
+			r.Since = time.Now()
+			sess.Route = &r
+			if err := sessMgr.SaveSession(sess); err != nil {
+				log.Printf("save session: %v", err)
+			}
+		}
+		if r.Relay != "" {
+			log.Printf("Connected via %s", r)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("no route to %s: %w", receiver, errors.Join(errs...))
+}
+
+// plannedRelays returns the addresses of the gateway relays orch plans for
+// a route from region from to region to, best first. If there are none, or
+// orch cannot be asked, the transfer goes without them.
+func plannedRelays(orch *client.OrchestratorClient, from, to string) []string {
+	plan, err := orch.PlanRoute(from, to, orchestrator.RelayGateway, 0)
+	if err != nil {
+		log.Printf("Route planning unavailable: %v", err)
+		return nil
+	}
+	var out []string
+	for _, r := range plan.Relays {
+		out = append(out, r.Address)
+	}
+	if len(out) == 0 {
+		log.Printf("No relays available from region %q to %q", from, to)
+	} else {
+		log.Printf("Planned relays: %s", strings.Join(out, ", "))
+	}
+	return out
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
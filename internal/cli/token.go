@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequireToken wraps the handler of an admin or control API so that
+// requests changing anything, i.e. with any method but GET, HEAD and
+// OPTIONS, must present token as a bearer token. Reads stay open to
+// monitoring.
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="trackshift"`)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "missing or invalid token"})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Loopback reports whether a listening address only accepts connections
+// from this host.
+func Loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
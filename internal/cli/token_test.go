@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	h := RequireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, tc := range []struct {
+		method, auth string
+		want         int
+	}{
+		{http.MethodGet, "", http.StatusNoContent},
+		{http.MethodPut, "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodDelete, "s3cret", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer s3cret", http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(tc.method, "/api/v1/ipfilter", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s with %q: status %d, want %d", tc.method, tc.auth, w.Code, tc.want)
+		}
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9091": true,
+		"[::1]:9091":     true,
+		"localhost:9091": true,
+		":9091":          false,
+		"0.0.0.0:9091":   false,
+		"10.0.0.5:9091":  false,
+		"host:9091":      false,
+	} {
+		if got := Loopback(addr); got != want {
+			t.Errorf("Loopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
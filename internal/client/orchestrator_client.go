@@ -1,40 +1,63 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
+// ErrNotFound is returned for a session the orchestrator does not know.
+var ErrNotFound = errors.New("not found")
+
 // OrchestratorClient is a small HTTP client for the orchestrator service.
 type OrchestratorClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	// Token, if set, is presented as a bearer token with every request.
+	Token string
 }
 
 // NewOrchestratorClient creates a new client with reasonable defaults.
 func NewOrchestratorClient(baseURL string) *OrchestratorClient {
+	return NewOrchestratorClientWithTimeouts(baseURL, timeouts.Defaults())
+}
+
+// NewOrchestratorClientWithTimeouts creates a client whose connect, TLS
+// handshake, response header and request timeouts are taken from t, e.g.
+// from timeouts.Config.For(baseURL).
+func NewOrchestratorClientWithTimeouts(baseURL string, t timeouts.Set) *OrchestratorClient {
 	return &OrchestratorClient{
-		BaseURL: baseURL,
-		HTTPClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		BaseURL:    baseURL,
+		HTTPClient: t.WithDefaults().HTTPClient(),
 	}
 }
 
-// CreateSession creates a new transfer session.
-func (c *OrchestratorClient) CreateSession(file models.FileMetadata) (*models.TransferSession, error) {
-	body, err := json.Marshal(map[string]any{
-		"file": file,
-	})
+// CreateSession creates a new transfer session. For a multi-file session,
+// file describes the stream and files lists the files in it; see
+// models.TransferSession.Files.
+func (c *OrchestratorClient) CreateSession(file models.FileMetadata, files ...models.FileMetadata) (*models.TransferSession, error) {
+	req := map[string]any{"file": file}
+	if len(files) > 0 {
+		req["files"] = files
+	}
+	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.HTTPClient.Post(c.BaseURL+"/api/v1/session", "application/json", bytes.NewReader(body))
+	resp, err := c.post("/api/v1/session", bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +74,7 @@ func (c *OrchestratorClient) CreateSession(file models.FileMetadata) (*models.Tr
 
 // GetSession fetches a session by ID.
 func (c *OrchestratorClient) GetSession(id string) (*models.TransferSession, error) {
-	resp, err := c.HTTPClient.Get(c.BaseURL + "/api/v1/session/" + id)
+	resp, err := c.get("/api/v1/session/" + id)
 	if err != nil {
 		return nil, err
 	}
@@ -66,4 +89,383 @@ func (c *OrchestratorClient) GetSession(id string) (*models.TransferSession, err
 	return &sess, nil
 }
 
+// RegisterSession records a session created elsewhere, under its own ID,
+// so its endpoints can report on it. It is not an error if the
+// orchestrator already has it, e.g. when the session is resumed.
+func (c *OrchestratorClient) RegisterSession(sess *models.TransferSession) error {
+	req := map[string]any{"id": sess.ID, "file": sess.File}
+	if len(sess.Files) > 0 {
+		req["files"] = sess.Files
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.post("/api/v1/session", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// ListSessions returns a page of sessions, newest first, with one of the
+// given statuses (any if none). cursor is the NextCursor of the previous
+// page, or empty for the first; limit 0 uses the orchestrator's default.
+func (c *OrchestratorClient) ListSessions(statuses []models.SessionStatus, limit int, cursor string) (*orchestrator.SessionPage, error) {
+	q := url.Values{}
+	if len(statuses) > 0 {
+		names := make([]string, len(statuses))
+		for i, s := range statuses {
+			names[i] = string(s)
+		}
+		q.Set("status", strings.Join(names, ","))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	resp, err := c.get("/api/v1/sessions?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var page orchestrator.SessionPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// UpdateSession reports a session's status and progress, and returns the
+// session as the orchestrator now has it. Its Control field is the action
+// the endpoints have been asked to take.
+func (c *OrchestratorClient) UpdateSession(id string, u orchestrator.SessionUpdate) (*models.TransferSession, error) {
+	body, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPatch, c.BaseURL+"/api/v1/session/"+id, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.sessionRequest(req)
+}
+
+// PauseSession asks the session's sender to pause.
+func (c *OrchestratorClient) PauseSession(id string) (*models.TransferSession, error) {
+	return c.sessionAction(id, "pause")
+}
+
+// ResumeSession withdraws a pause request.
+func (c *OrchestratorClient) ResumeSession(id string) (*models.TransferSession, error) {
+	return c.sessionAction(id, "resume")
+}
+
+// CancelSession fails the session and asks its sender to stop.
+func (c *OrchestratorClient) CancelSession(id string) (*models.TransferSession, error) {
+	return c.sessionAction(id, "cancel")
+}
+
+// DeleteSession removes a session that is no longer in flight.
+func (c *OrchestratorClient) DeleteSession(id string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.BaseURL+"/api/v1/session/"+id, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// WatchSession streams the events of session id to fn, starting with a
+// snapshot of the session, until the stream ends (the session completed,
+// was cancelled or deleted), ctx is done or fn returns an error. The
+// orchestrator ends streams that fall far behind; callers that want to
+// keep watching call WatchSession again.
+func (c *OrchestratorClient) WatchSession(ctx context.Context, id string, fn func(orchestrator.SessionEvent) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v1/session/"+id+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// The stream outlives any overall request timeout.
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	stream := *c
+	stream.HTTPClient = &hc
+	resp, err := stream.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	var data []byte
+	for sc.Scan() {
+		line := sc.Bytes()
+		switch {
+		case len(line) == 0 && len(data) > 0:
+			var ev orchestrator.SessionEvent
+			if err := json.Unmarshal(data, &ev); err != nil {
+				return fmt.Errorf("decode session event: %w", err)
+			}
+			data = data[:0]
+			if err := fn(ev); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return sc.Err()
+}
+
+func (c *OrchestratorClient) sessionAction(id, action string) (*models.TransferSession, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/v1/session/"+id+"/"+action, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.sessionRequest(req)
+}
+
+// sessionRequest sends req and decodes the session in the reply.
+func (c *OrchestratorClient) sessionRequest(req *http.Request) (*models.TransferSession, error) {
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var sess models.TransferSession
+	if err := json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// do sends req, presenting c.Token if set.
+func (c *OrchestratorClient) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+func (c *OrchestratorClient) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *OrchestratorClient) post(path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+// responseError describes a failed request by its status and, if the
+// orchestrator gave one, its error message.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+		return fmt.Errorf("unexpected status: %s: %s", resp.Status, body.Error)
+	}
+	return fmt.Errorf("unexpected status: %s", resp.Status)
+}
+
+// RegisterNode announces a node and the files it can serve. Nodes call it
+// periodically to stay registered.
+func (c *OrchestratorClient) RegisterNode(node orchestrator.NodeInfo) error {
+	body, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	resp, err := c.post("/api/v1/nodes/register", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// RegisterRelay announces a relay with its advertised capacity and current
+// load. Relays call it on start, then send heartbeats.
+func (c *OrchestratorClient) RegisterRelay(relay orchestrator.RelayInfo) error {
+	body, err := json.Marshal(relay)
+	if err != nil {
+		return err
+	}
+	resp, err := c.post("/api/v1/relays/register", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// RelayHeartbeat reports a registered relay's load. It returns ErrNotFound
+// if the orchestrator does not know the relay, e.g. because it expired, in
+// which case the relay should register again.
+func (c *OrchestratorClient) RelayHeartbeat(id string, load orchestrator.RelayLoad) error {
+	body, err := json.Marshal(map[string]any{"load": load})
+	if err != nil {
+		return err
+	}
+	resp, err := c.post("/api/v1/relays/"+url.PathEscape(id)+"/heartbeat", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// RelayConfig returns the configuration set for a relay. It returns
+// ErrNotFound if none is set.
+func (c *OrchestratorClient) RelayConfig(id string) (*orchestrator.RelayConfig, error) {
+	resp, err := c.get("/api/v1/relays/" + url.PathEscape(id) + "/config")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var cfg orchestrator.RelayConfig
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// RelayRoutes returns the routes of the sessions in flight through a
+// registered relay. It returns ErrNotFound if the orchestrator does not
+// know the relay.
+func (c *OrchestratorClient) RelayRoutes(id string) ([]orchestrator.RelayRoute, error) {
+	resp, err := c.get("/api/v1/relays/" + url.PathEscape(id) + "/routes")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var routes []orchestrator.RelayRoute
+	if err := json.NewDecoder(resp.Body).Decode(&routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// PlanRoute asks for the relays of the given mode (any if empty) to reach
+// region to from region from through, best first. limit 0 uses the
+// orchestrator's default.
+func (c *OrchestratorClient) PlanRoute(from, to string, mode orchestrator.RelayMode, limit int) (*orchestrator.RoutePlan, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	if mode != "" {
+		q.Set("mode", string(mode))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	resp, err := c.get("/api/v1/route?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var plan orchestrator.RoutePlan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
 
+// PostReceipt records a signed delivery receipt with the orchestrator.
+func (c *OrchestratorClient) PostReceipt(r *receipt.Receipt) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := c.post("/api/v1/receipts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// RequestTransfer asks the orchestrator to have the nearest node holding the
+// file send it to req.Receiver, and returns the node that was chosen.
+func (c *OrchestratorClient) RequestTransfer(req orchestrator.TransferRequest) (*orchestrator.TransferAssignment, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post("/api/v1/transfers", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var a orchestrator.TransferAssignment
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+)
+
+const (
+	// rendezvousPoll is how often a party waiting for the other re-registers.
+	rendezvousPoll = time.Second
+	// punchTimeout bounds hole punching once both parties are registered.
+	punchTimeout = 10 * time.Second
+)
+
+// ReflectorAddr returns the address of the orchestrator's reflector, which
+// tells rendezvous parties their public UDP endpoint.
+func (c *OrchestratorClient) ReflectorAddr() (string, error) {
+	resp, err := c.get("/api/v1/rendezvous")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	var info orchestrator.RendezvousInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	if info.ReflectorPort == 0 {
+		return "", errors.New("the orchestrator runs no reflector")
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(u.Hostname(), strconv.Itoa(info.ReflectorPort)), nil
+}
+
+// RegisterRendezvous registers self as a party of rendezvous id and returns
+// the other party, or nil if it has not registered yet.
+func (c *OrchestratorClient) RegisterRendezvous(id string, self orchestrator.RendezvousParty) (*orchestrator.RendezvousParty, error) {
+	body, err := json.Marshal(self)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post("/api/v1/rendezvous/"+url.PathEscape(id), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var reply orchestrator.RendezvousReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	return reply.Peer, nil
+}
+
+// Rendezvous punches a hole from conn to the other party of rendezvous id.
+// It learns conn's public endpoint from the orchestrator's reflector,
+// registers it and conn's local addresses as self's candidates, waits for
+// the other party to register, ignoring registrations last renewed before
+// since, then punches to its candidates. It returns the address the peer
+// was reached at and its registration; on transport.ErrPunchFailed the
+// registration is returned too, for the caller to fall back on relays.
+func (c *OrchestratorClient) Rendezvous(ctx context.Context, conn *net.UDPConn, id string, self orchestrator.RendezvousParty, since time.Time) (*net.UDPAddr, *orchestrator.RendezvousParty, error) {
+	reflector, err := c.ReflectorAddr()
+	if err != nil {
+		return nil, nil, fmt.Errorf("rendezvous %s: %w", id, err)
+	}
+	dctx, cancel := context.WithTimeout(ctx, punchTimeout)
+	public, err := transport.DiscoverEndpoint(dctx, conn, reflector)
+	cancel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("rendezvous %s: %w", id, err)
+	}
+	self.Candidates = []string{public.String()}
+	for _, c := range transport.LocalCandidates(conn) {
+		if !slices.Contains(self.Candidates, c) {
+			self.Candidates = append(self.Candidates, c)
+		}
+	}
+
+	var peer *orchestrator.RendezvousParty
+	for {
+		if peer, err = c.RegisterRendezvous(id, self); err != nil {
+			return nil, nil, fmt.Errorf("rendezvous %s: %w", id, err)
+		}
+		if peer != nil && peer.LastSeen.After(since) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("rendezvous %s: waiting for the %s: %w", id, otherRole(self.Role), ctx.Err())
+		case <-time.After(rendezvousPoll):
+		}
+	}
+
+	log.Printf("Rendezvous %s: punching from %s to the %s at %v", id, public, peer.Role, peer.Candidates)
+	pctx, cancel := context.WithTimeout(ctx, punchTimeout)
+	defer cancel()
+	addr, err := transport.Punch(pctx, conn, id, peer.Candidates)
+	if err != nil {
+		return nil, peer, err
+	}
+	return addr, peer, nil
+}
+
+func otherRole(role string) string {
+	if role == orchestrator.RoleSender {
+		return orchestrator.RoleReceiver
+	}
+	return orchestrator.RoleSender
+}
@@ -0,0 +1,214 @@
+// Package coldstore hands received files off to archival storage, such as an
+// LTFS-mounted tape or a command that writes its standard input to an
+// archive, and records the checksum chain that ties the archived copy back
+// to the sender's hash.
+package coldstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrChecksumMismatch is returned when a stage of the handoff saw different
+// content than the one before it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum chain stages, in order.
+const (
+	// StageSender is the hash the sender computed and announced.
+	StageSender = "sender"
+	// StageHandoff is the hash of the bytes streamed into the target.
+	StageHandoff = "handoff"
+	// StageArchive is the hash of the archived copy as reported or read
+	// back by the target.
+	StageArchive = "archive"
+)
+
+// Link is one stage of a checksum chain. At is when the stage's hash was
+// recorded by the handoff.
+type Link struct {
+	Stage  string    `json:"stage"`
+	SHA256 string    `json:"sha256"`
+	At     time.Time `json:"at"`
+}
+
+// Record describes one handoff. It is written next to the received file so
+// the archived copy's integrity can be traced back to the sender.
+type Record struct {
+	File models.FileMetadata `json:"file"`
+	// Target names the kind of target and Location where the file went in
+	// it, as far as the target knows.
+	Target   string `json:"target"`
+	Location string `json:"location,omitempty"`
+	// Chain holds the file's hash at each stage; every link matches the
+	// sender's hash.
+	Chain      []Link    `json:"chain"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Target is archival storage a file can be handed off to.
+type Target interface {
+	// Name identifies the target in records and logs.
+	Name() string
+	// Archive stores the file read from r and returns its location in the
+	// target and the SHA-256 of the stored copy, or "" if the target cannot
+	// tell.
+	Archive(r io.Reader, file models.FileMetadata) (location, sha string, err error)
+}
+
+// Handoff streams the verified file at path, described by file, into t and
+// returns the checksum chain of the handoff. The file is hashed as it is
+// streamed, so a copy that changed since it was verified is caught without
+// reading it twice.
+func Handoff(t Target, path string, file models.FileMetadata) (*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rec := &Record{
+		File:   file,
+		Target: t.Name(),
+		Chain:  []Link{{Stage: StageSender, SHA256: file.Hash, At: time.Now()}},
+	}
+	h := sha256.New()
+	location, archived, err := t.Archive(io.TeeReader(f, h), file)
+	if err != nil {
+		return nil, fmt.Errorf("archive to %s: %w", t.Name(), err)
+	}
+	if err := rec.add(StageHandoff, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return nil, err
+	}
+	if archived != "" {
+		if err := rec.add(StageArchive, archived); err != nil {
+			return nil, err
+		}
+	}
+	rec.Location = location
+	rec.ArchivedAt = time.Now()
+	return rec, nil
+}
+
+// add appends a link for stage, which must match the sender's hash.
+func (r *Record) add(stage, sha string) error {
+	if sha != r.File.Hash {
+		return fmt.Errorf("%w at %s stage: expected %s, got %s", ErrChecksumMismatch, stage, r.File.Hash, sha)
+	}
+	r.Chain = append(r.Chain, Link{Stage: stage, SHA256: sha, At: time.Now()})
+	return nil
+}
+
+// Write saves the record as JSON at path.
+func (r *Record) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// DirTarget archives into a directory, typically the mount point of an LTFS
+// tape. Files are written under their transfer name, synced, and read back
+// to hash what the medium holds.
+type DirTarget struct {
+	Dir string
+}
+
+// Name implements Target.
+func (d DirTarget) Name() string { return "dir:" + d.Dir }
+
+// Archive implements Target.
+func (d DirTarget) Archive(r io.Reader, file models.FileMetadata) (string, string, error) {
+	dest := filepath.Join(d.Dir, filepath.Base(file.Name))
+	// Written under a temporary name so a partial copy is never mistaken
+	// for an archived one.
+	tmp := dest + ".partial"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", "", err
+	}
+
+	in, err := os.Open(dest)
+	if err != nil {
+		return "", "", fmt.Errorf("read back: %w", err)
+	}
+	defer in.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, in); err != nil {
+		return "", "", fmt.Errorf("read back: %w", err)
+	}
+	return dest, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sha256Line matches a hex SHA-256 at the start of a line of output, as
+// printed by sha256sum.
+var sha256Line = regexp.MustCompile(`(?m)^([0-9a-fA-F]{64})\b`)
+
+// ExecTarget archives by running Command through the shell with the file on
+// its standard input. The file's name, size and hash are passed in the
+// TRACKSHIFT_FILE, TRACKSHIFT_SIZE and TRACKSHIFT_SHA256 environment
+// variables. If the command prints a SHA-256 at the start of a line, as
+// sha256sum does, it is taken as the hash of the archived copy; the last
+// line of output otherwise is recorded as the location.
+type ExecTarget struct {
+	Command string
+}
+
+// Name implements Target.
+func (e ExecTarget) Name() string { return "exec:" + e.Command }
+
+// Archive implements Target.
+func (e ExecTarget) Archive(r io.Reader, file models.FileMetadata) (string, string, error) {
+	cmd := exec.Command("sh", "-c", e.Command)
+	cmd.Stdin = r
+	cmd.Env = append(os.Environ(),
+		"TRACKSHIFT_FILE="+file.Name,
+		"TRACKSHIFT_SIZE="+strconv.FormatInt(file.Size, 10),
+		"TRACKSHIFT_SHA256="+file.Hash,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return "", "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", "", err
+	}
+	out := bytes.TrimSpace(stdout.Bytes())
+	if m := sha256Line.FindSubmatch(out); m != nil {
+		return "", string(bytes.ToLower(m[1])), nil
+	}
+	if i := bytes.LastIndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	return string(out), "", nil
+}
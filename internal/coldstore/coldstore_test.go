@@ -0,0 +1,119 @@
+package coldstore
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// received writes a verified file and returns its path and metadata.
+func received(t *testing.T, content string) (string, models.FileMetadata) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := utils.HashFileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, models.FileMetadata{Name: "data.bin", Size: int64(len(content)), Hash: hash}
+}
+
+func stages(r *Record) []string {
+	var s []string
+	for _, l := range r.Chain {
+		s = append(s, l.Stage)
+	}
+	return s
+}
+
+func TestHandoffToDir(t *testing.T) {
+	path, file := received(t, "archive me")
+	dir := t.TempDir()
+	rec, err := Handoff(DirTarget{Dir: dir}, path, file)
+	if err != nil {
+		t.Fatalf("Handoff: %v", err)
+	}
+	if got := stages(rec); len(got) != 3 || got[2] != StageArchive {
+		t.Fatalf("chain stages = %v", got)
+	}
+	if rec.Location != filepath.Join(dir, "data.bin") {
+		t.Fatalf("location = %q", rec.Location)
+	}
+	if data, err := os.ReadFile(rec.Location); err != nil || string(data) != "archive me" {
+		t.Fatalf("archived copy = %q, %v", data, err)
+	}
+
+	out := path + ".archive.json"
+	if err := rec.Write(out); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Record
+	if err := json.Unmarshal(data, &back); err != nil || len(back.Chain) != 3 || back.Chain[0].SHA256 != file.Hash {
+		t.Fatalf("record round trip = %+v, %v", back, err)
+	}
+}
+
+func TestHandoffToExec(t *testing.T) {
+	path, file := received(t, "archive me")
+	dir := t.TempDir()
+	copyTo := filepath.Join(dir, "copy")
+
+	// A command that reports the hash of what it stored.
+	rec, err := Handoff(ExecTarget{Command: `tee "` + copyTo + `" | sha256sum`}, path, file)
+	if err != nil {
+		t.Fatalf("Handoff: %v", err)
+	}
+	if got := stages(rec); len(got) != 3 {
+		t.Fatalf("chain stages = %v", got)
+	}
+
+	// One that reports where it stored the file, using the environment.
+	rec, err = Handoff(ExecTarget{Command: `cat > "` + dir + `/$TRACKSHIFT_FILE" && echo "tape-7/$TRACKSHIFT_FILE"`}, path, file)
+	if err != nil {
+		t.Fatalf("Handoff: %v", err)
+	}
+	if got := stages(rec); len(got) != 2 || rec.Location != "tape-7/data.bin" {
+		t.Fatalf("chain stages = %v, location %q", got, rec.Location)
+	}
+
+	if _, err := Handoff(ExecTarget{Command: "cat >/dev/null; echo full >&2; exit 3"}, path, file); err == nil {
+		t.Fatal("failing command succeeded")
+	}
+}
+
+// corrupting is a target that stores something other than what it was given.
+type corrupting struct{}
+
+func (corrupting) Name() string { return "corrupting" }
+
+func (corrupting) Archive(r io.Reader, file models.FileMetadata) (string, string, error) {
+	_, err := io.Copy(io.Discard, r)
+	return "", "0000000000000000000000000000000000000000000000000000000000000000", err
+}
+
+func TestHandoffDetectsMismatch(t *testing.T) {
+	path, file := received(t, "archive me")
+	if _, err := Handoff(corrupting{}, path, file); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Handoff to corrupting target: %v", err)
+	}
+
+	// The received copy changed after it was verified.
+	if err := os.WriteFile(path, []byte("tampered!!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Handoff(DirTarget{Dir: t.TempDir()}, path, file); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Handoff of changed file: %v", err)
+	}
+}
@@ -3,35 +3,133 @@ package crypto
 import (
 	"crypto/sha256"
 	"fmt"
+	"sync"
 
 	"github.com/klauspost/compress/zstd"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
-// CompressChunk compresses the given data using zstd with a default level.
-func CompressChunk(data []byte) ([]byte, error) {
-	enc, err := zstd.NewWriter(nil)
-	if err != nil {
-		return nil, fmt.Errorf("create zstd encoder: %w", err)
+// Codec compresses and decompresses chunks with zstd, reusing encoder and
+// decoder instances across calls instead of creating one per chunk.
+// A Codec is safe for concurrent use.
+type Codec struct {
+	level    zstd.EncoderLevel
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+// NewCodec creates a Codec that compresses at the given zstd level.
+func NewCodec(level zstd.EncoderLevel) *Codec {
+	return &Codec{level: level}
+}
+
+// defaultCodec backs CompressChunk and DecompressChunk.
+var defaultCodec = NewCodec(zstd.SpeedDefault)
+
+// Level returns the zstd level used by Compress.
+func (c *Codec) Level() zstd.EncoderLevel {
+	return c.level
+}
+
+// Compress compresses data.
+func (c *Codec) Compress(data []byte) ([]byte, error) {
+	enc, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		enc, err = zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(c.level),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("create zstd encoder: %w", err)
+		}
 	}
-	defer enc.Close()
+	out := enc.EncodeAll(data, make([]byte, 0, len(data)/2))
+	c.encoders.Put(enc)
+	return out, nil
+}
 
-	out := enc.EncodeAll(data, nil)
+// Decompress decompresses zstd-compressed data.
+func (c *Codec) Decompress(data []byte) ([]byte, error) {
+	dec, ok := c.decoders.Get().(*zstd.Decoder)
+	if !ok {
+		var err error
+		dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("create zstd decoder: %w", err)
+		}
+	}
+	out, err := dec.DecodeAll(data, nil)
+	c.decoders.Put(dec)
+	if err != nil {
+		return nil, fmt.Errorf("zstd decode: %w", err)
+	}
 	return out, nil
 }
 
+// CompressChunk compresses the given data using zstd with a default level.
+func CompressChunk(data []byte) ([]byte, error) {
+	return defaultCodec.Compress(data)
+}
+
 // DecompressChunk decompresses zstd-compressed data.
 func DecompressChunk(data []byte) ([]byte, error) {
-	dec, err := zstd.NewReader(nil)
+	return defaultCodec.Decompress(data)
+}
+
+// compressSampleSize is how much of a chunk is trial-compressed when deciding
+// whether compression is worthwhile.
+const compressSampleSize = 64 * 1024
+
+// minCompressionSavings is the fraction of the sample that must be saved by
+// zstd for the full chunk to be compressed. Already-compressed media (mp4,
+// zip, jpeg) typically saves well under 1%.
+const minCompressionSavings = 0.05
+
+// ShouldCompress trial-compresses the first 64KB of data and reports whether
+// compressing the whole chunk is likely to pay off.
+func ShouldCompress(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	sample := data
+	if len(sample) > compressSampleSize {
+		sample = sample[:compressSampleSize]
+	}
+	comp, err := CompressChunk(sample)
 	if err != nil {
-		return nil, fmt.Errorf("create zstd decoder: %w", err)
+		return false
 	}
-	defer dec.Close()
+	saved := 1 - float64(len(comp))/float64(len(sample))
+	return saved >= minCompressionSavings
+}
 
-	out, err := dec.DecodeAll(data, nil)
+// CompressChunkAdaptive compresses data only when ShouldCompress reports a
+// meaningful gain. It returns the payload to send and the compression that was
+// applied (models.CompressionZstd or models.CompressionNone).
+func CompressChunkAdaptive(data []byte) ([]byte, string, error) {
+	if !ShouldCompress(data) {
+		return data, models.CompressionNone, nil
+	}
+	out, err := CompressChunk(data)
 	if err != nil {
-		return nil, fmt.Errorf("zstd decode: %w", err)
+		return nil, "", err
+	}
+	return out, models.CompressionZstd, nil
+}
+
+// DecodeChunk reverses the compression recorded for a chunk. An empty
+// compression value is treated as zstd, which is what senders predating
+// adaptive compression always applied.
+func DecodeChunk(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case models.CompressionNone:
+		return data, nil
+	case models.CompressionZstd, "":
+		return DecompressChunk(data)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
 	}
-	return out, nil
 }
 
 // HashChunk returns the SHA-256 hash of data as a fixed array.
@@ -44,5 +142,3 @@ func VerifyChunk(data []byte, expectedHash [32]byte) bool {
 	actual := HashChunk(data)
 	return actual == expectedHash
 }
-
-
@@ -2,7 +2,13 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/rand"
+	"fmt"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
 func TestCompressDecompressRoundTrip(t *testing.T) {
@@ -62,4 +68,138 @@ func BenchmarkDecompressChunk(b *testing.B) {
 	}
 }
 
+func TestCompressChunkAdaptiveSkipsRandomData(t *testing.T) {
+	random := make([]byte, 256*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	out, applied, err := CompressChunkAdaptive(random)
+	if err != nil {
+		t.Fatalf("CompressChunkAdaptive error: %v", err)
+	}
+	if applied != models.CompressionNone {
+		t.Fatalf("expected random data to be sent raw, got %q", applied)
+	}
+	if !bytes.Equal(out, random) {
+		t.Fatalf("expected raw payload to be returned unchanged")
+	}
+
+	text := bytes.Repeat([]byte("TrackShift adaptive compression "), 8*1024)
+	out, applied, err = CompressChunkAdaptive(text)
+	if err != nil {
+		t.Fatalf("CompressChunkAdaptive error: %v", err)
+	}
+	if applied != models.CompressionZstd {
+		t.Fatalf("expected repetitive data to be compressed, got %q", applied)
+	}
+	decoded, err := DecodeChunk(out, applied)
+	if err != nil {
+		t.Fatalf("DecodeChunk error: %v", err)
+	}
+	if !bytes.Equal(decoded, text) {
+		t.Fatalf("round-trip mismatch")
+	}
+}
+
+func TestCodecConcurrentRoundTrip(t *testing.T) {
+	codec := NewCodec(zstd.SpeedFastest)
+	done := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			data := bytes.Repeat([]byte{byte(i), 1, 2, 3}, 4096)
+			comp, err := codec.Compress(data)
+			if err != nil {
+				done <- err
+				return
+			}
+			out, err := codec.Decompress(comp)
+			if err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(out, data) {
+				done <- fmt.Errorf("round-trip mismatch in worker %d", i)
+				return
+			}
+			done <- nil
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// benchmarkChunk approximates a compressible chunk at the default 50MB size
+// scaled down to keep benchmark runs short.
+func benchmarkChunk() []byte {
+	return bytes.Repeat([]byte("TrackShift compression benchmark"), 256*1024) // 8MB
+}
 
+// BenchmarkCompressUnpooled measures the previous behaviour of creating a new
+// encoder for every chunk, for comparison with BenchmarkCodecCompress.
+func BenchmarkCompressUnpooled(b *testing.B) {
+	data := benchmarkChunk()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			b.Fatalf("NewWriter error: %v", err)
+		}
+		_ = enc.EncodeAll(data, nil)
+		enc.Close()
+	}
+}
+
+func BenchmarkCodecCompress(b *testing.B) {
+	data := benchmarkChunk()
+	codec := NewCodec(zstd.SpeedDefault)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Compress(data); err != nil {
+			b.Fatalf("Compress error: %v", err)
+		}
+	}
+}
+
+// BenchmarkDecompressUnpooled measures creating a new decoder per chunk.
+func BenchmarkDecompressUnpooled(b *testing.B) {
+	data := benchmarkChunk()
+	comp, err := CompressChunk(data)
+	if err != nil {
+		b.Fatalf("CompressChunk error: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			b.Fatalf("NewReader error: %v", err)
+		}
+		if _, err := dec.DecodeAll(comp, nil); err != nil {
+			b.Fatalf("DecodeAll error: %v", err)
+		}
+		dec.Close()
+	}
+}
+
+func BenchmarkCodecDecompress(b *testing.B) {
+	data := benchmarkChunk()
+	codec := NewCodec(zstd.SpeedDefault)
+	comp, err := codec.Compress(data)
+	if err != nil {
+		b.Fatalf("Compress error: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Decompress(comp); err != nil {
+			b.Fatalf("Decompress error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Effort is how much CPU is spent compressing a chunk, from EffortOff (not
+// compressed) to EffortBest. Receivers decode every effort alike.
+type Effort int
+
+// Compression efforts, in increasing CPU cost and ratio.
+const (
+	EffortOff Effort = iota
+	EffortFastest
+	EffortDefault
+	EffortBetter
+	EffortBest
+)
+
+// effortLevels maps efforts other than EffortOff to zstd levels.
+var effortLevels = [...]zstd.EncoderLevel{
+	EffortFastest: zstd.SpeedFastest,
+	EffortDefault: zstd.SpeedDefault,
+	EffortBetter:  zstd.SpeedBetterCompression,
+	EffortBest:    zstd.SpeedBestCompression,
+}
+
+// effortCodecs holds one codec per effort so encoders are reused across
+// chunks at the same effort.
+var effortCodecs = func() (c [len(effortLevels)]*Codec) {
+	for e := EffortFastest; e <= EffortBest; e++ {
+		c[e] = NewCodec(effortLevels[e])
+	}
+	c[EffortDefault] = defaultCodec
+	return c
+}()
+
+func (e Effort) String() string {
+	if e == EffortOff {
+		return "off"
+	}
+	if e < EffortOff || e > EffortBest {
+		return fmt.Sprintf("Effort(%d)", int(e))
+	}
+	return "zstd " + effortLevels[e].String()
+}
+
+// Codec returns the codec compressing at e, or nil for EffortOff.
+func (e Effort) Codec() *Codec {
+	if e <= EffortOff || e > EffortBest {
+		return nil
+	}
+	return effortCodecs[e]
+}
+
+// effortSteadyObservations is how many consecutive observations must point
+// the same way before the effort changes, so one slow read or burst on the
+// wire does not flip it.
+const effortSteadyObservations = 3
+
+// EffortController picks the compression effort for each chunk from the
+// depth of the queue between the stage preparing chunks and the network.
+// When chunks pile up waiting for the network, the link is the bottleneck
+// and spare CPU buys a better ratio, so effort goes up. When the network
+// finds nothing ready, compression holds it back and effort goes down, to no
+// compression at all if allowed. It is safe for concurrent use.
+type EffortController struct {
+	mu       sync.Mutex
+	min, max Effort
+	effort   Effort
+	votes    int // consecutive observations: > 0 network-bound, < 0 CPU-bound
+}
+
+// NewEffortController returns a controller starting at start and kept within
+// [min, max].
+func NewEffortController(start, min, max Effort) *EffortController {
+	return &EffortController{min: min, max: max, effort: clampEffort(start, min, max)}
+}
+
+func clampEffort(e, lo, hi Effort) Effort {
+	return max(lo, min(e, hi))
+}
+
+// Effort returns the effort for the next chunk to be prepared.
+func (c *EffortController) Effort() Effort {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.effort
+}
+
+// Observe records that the network took its next chunk with ready chunks
+// prepared out of the capacity of the queue, and returns the effort for the
+// chunks prepared from now on and whether it changed.
+func (c *EffortController) Observe(ready, capacity int) (Effort, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case ready == 0:
+		c.votes = min(c.votes, 0) - 1
+	case ready >= capacity:
+		c.votes = max(c.votes, 0) + 1
+	default:
+		c.votes = 0
+	}
+
+	prev := c.effort
+	switch {
+	case c.votes >= effortSteadyObservations:
+		c.effort = clampEffort(c.effort+1, c.min, c.max)
+		c.votes = 0
+	case c.votes <= -effortSteadyObservations:
+		c.effort = clampEffort(c.effort-1, c.min, c.max)
+		c.votes = 0
+	}
+	return c.effort, c.effort != prev
+}
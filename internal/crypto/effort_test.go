@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEffortControllerFollowsBottleneck(t *testing.T) {
+	c := NewEffortController(EffortDefault, EffortOff, EffortBetter)
+
+	// Chunks piling up behind the network raise the effort once the
+	// observations agree, up to the maximum.
+	for i := 0; i < 2; i++ {
+		if _, changed := c.Observe(3, 3); changed {
+			t.Fatalf("effort changed after %d observations", i+1)
+		}
+	}
+	if e, changed := c.Observe(3, 3); !changed || e != EffortBetter {
+		t.Fatalf("network-bound: effort %v, changed %v", e, changed)
+	}
+	for i := 0; i < 6; i++ {
+		c.Observe(3, 3)
+	}
+	if e := c.Effort(); e != EffortBetter {
+		t.Fatalf("effort %v above the maximum", e)
+	}
+
+	// A balanced queue resets the count.
+	c.Observe(0, 3)
+	c.Observe(0, 3)
+	c.Observe(1, 3)
+	if _, changed := c.Observe(0, 3); changed {
+		t.Fatal("effort changed across a balanced observation")
+	}
+
+	// A network that finds nothing ready lowers it, down to no compression.
+	for i := 0; i < 20; i++ {
+		c.Observe(0, 3)
+	}
+	if e := c.Effort(); e != EffortOff || e.Codec() != nil {
+		t.Fatalf("CPU-bound: effort %v", e)
+	}
+}
+
+func TestEffortCodecsRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift effort "), 4096)
+	for e := EffortFastest; e <= EffortBest; e++ {
+		comp, err := e.Codec().Compress(data)
+		if err != nil {
+			t.Fatalf("%v: %v", e, err)
+		}
+		out, err := DecompressChunk(comp)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Fatalf("%v: round trip failed: %v", e, err)
+		}
+	}
+}
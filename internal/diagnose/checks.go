@@ -0,0 +1,608 @@
+package diagnose
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunkstate"
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/reservation"
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// maxEvidence caps the log lines quoted per finding.
+const maxEvidence = 3
+
+// logTail is how many trailing lines are searched of a log that never
+// names the session.
+const logTail = 200
+
+func (d *diagnosis) checkSession() {
+	const name = "session state"
+	dir := d.cfg.SessionsDir
+	if dir == "" {
+		d.check(name, StatusSkipped, "no sessions directory given")
+		return
+	}
+	if _, err := os.Stat(dir); err != nil {
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:   CauseState,
+			Score:   60,
+			Summary: "the sessions directory cannot be read",
+			Actions: []string{"check the -sessions-dir path the sender or receiver was started with"},
+		})
+		return
+	}
+	store, err := session.OpenStore(d.cfg.Store, dir)
+	if err != nil {
+		// A bolt store is locked by the process using it.
+		d.check(name, StatusSkipped, err.Error())
+		return
+	}
+	defer store.Close()
+
+	if js, ok := store.(*session.JSONStore); ok {
+		d.checkFiles(js)
+	}
+	sess, err := store.Load(d.cfg.SessionID)
+	switch {
+	case errors.Is(err, session.ErrSchemaTooNew):
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:   CauseVersion,
+			Score:   90,
+			Summary: "the session was saved by a newer release than this one",
+			Actions: []string{"resume the session with the release that started it, or upgrade this node"},
+		})
+		return
+	case err != nil:
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:    CauseState,
+			Score:    80,
+			Summary:  "the session cannot be loaded",
+			Evidence: []string{err.Error()},
+			Actions: []string{
+				"check the session ID and the -sessions-dir and -session-store it was saved with",
+				"import the session from an export if one was taken",
+			},
+		})
+		return
+	}
+	d.sess = sess
+	d.rep.Session = &Summary{
+		File:            sess.File.Name,
+		Size:            sess.File.Size,
+		Status:          sess.Status,
+		Chunks:          sess.TotalChunks,
+		Completed:       sess.Completed,
+		Failed:          sess.Failed,
+		UpdatedAt:       sess.UpdatedAt,
+		ProtocolVersion: sess.ProtocolVersion,
+	}
+	d.check(name, StatusOK, fmt.Sprintf("%s, %d of %d chunks, updated %s ago",
+		sess.Status, sess.Completed, sess.TotalChunks, d.now.Sub(sess.UpdatedAt).Round(time.Second)))
+
+	idle := d.now.Sub(sess.UpdatedAt)
+	switch sess.Status {
+	case models.SessionStatusFailed:
+		d.find(Finding{
+			Cause:   CauseState,
+			Score:   50,
+			Summary: "the session is marked failed",
+			Actions: []string{"resume it with the sender's -resume " + sess.ID + " once its cause is fixed"},
+		})
+	case models.SessionStatusPaused:
+		d.find(Finding{
+			Cause:   CauseState,
+			Score:   70,
+			Summary: fmt.Sprintf("the session was paused %s ago", idle.Round(time.Second)),
+			Actions: []string{"resume it: signal the sender again, or run it with -resume " + sess.ID},
+		})
+	case models.SessionStatusTransferring, models.SessionStatusCreated:
+		if idle > d.cfg.StallAfter {
+			d.find(Finding{
+				Cause:    CauseNetwork,
+				Score:    40,
+				Summary:  fmt.Sprintf("no progress for %s", idle.Round(time.Second)),
+				Evidence: []string{fmt.Sprintf("last update %s", sess.UpdatedAt.Format("2006-01-02 15:04:05"))},
+				Actions:  []string{"check that the sender process is still running", "resume with -resume " + sess.ID + " if it exited"},
+			})
+		}
+	}
+
+	if v := sess.ProtocolVersion; v != 0 {
+		if err := protocol.CheckVersion(v); err != nil {
+			d.find(Finding{
+				Cause:    CauseVersion,
+				Score:    85,
+				Summary:  fmt.Sprintf("the session uses protocol v%d, which this release does not support", v),
+				Evidence: []string{err.Error()},
+				Actions:  []string{"resume it with a release that supports v" + fmt.Sprint(v)},
+			})
+		}
+	}
+	d.checkChunkErrors()
+}
+
+// checkFiles reports session files Load would skip over or recover from.
+func (d *diagnosis) checkFiles(js *session.JSONStore) {
+	const name = "session files"
+	files := js.Inspect(d.cfg.SessionID)
+	if len(files) == 0 {
+		d.check(name, StatusFail, "no files for this session")
+		return
+	}
+	var bad []string
+	currentBad := false
+	for _, f := range files {
+		if f.Err == "" {
+			continue
+		}
+		bad = append(bad, fmt.Sprintf("%s: %s", filepath.Base(f.Path), f.Err))
+		if filepath.Base(f.Path) == d.cfg.SessionID+".json" {
+			currentBad = true
+		}
+	}
+	if len(bad) == 0 {
+		d.check(name, StatusOK, fmt.Sprintf("%d files intact", len(files)))
+		return
+	}
+	d.check(name, StatusWarn, fmt.Sprintf("%d of %d files damaged", len(bad), len(files)))
+	score := 20
+	summary := "older copies of the session are damaged"
+	if currentBad {
+		score = 55
+		summary = "the current session file is damaged; progress comes from an older copy and the checkpoint"
+	}
+	d.find(Finding{
+		Cause:    CauseState,
+		Score:    score,
+		Summary:  summary,
+		Evidence: bad,
+		Actions: []string{
+			"check the disk holding the sessions directory for errors",
+			"resume the session; the next save rewrites the damaged file",
+		},
+	})
+}
+
+// checkChunkErrors classifies the errors recorded on chunks.
+func (d *diagnosis) checkChunkErrors() {
+	byCause := make(map[Cause][]string)
+	retries := 0
+	for _, c := range d.sess.Chunks {
+		retries += c.RetryCount
+		if c.Error == "" {
+			continue
+		}
+		cause, ok := classify(c.Error)
+		if !ok {
+			cause = CauseNetwork
+		}
+		byCause[cause] = append(byCause[cause], fmt.Sprintf("chunk %s: %s", c.ID, c.Error))
+	}
+	for _, cause := range causes(byCause) {
+		errs := byCause[cause]
+		sort.Strings(errs)
+		f := remedy(cause)
+		f.Score += min(5*len(errs), 25)
+		f.Summary = fmt.Sprintf("%d chunks recorded %s errors", len(errs), cause)
+		f.Evidence = errs[:min(len(errs), maxEvidence)]
+		d.find(f)
+	}
+	if retries > 0 && len(byCause) == 0 {
+		d.find(Finding{
+			Cause:   CauseNetwork,
+			Score:   min(10+retries, 35),
+			Summary: fmt.Sprintf("chunks were retried %d times", retries),
+			Actions: []string{"look for loss or resets on the path to the receiver"},
+		})
+	}
+}
+
+func (d *diagnosis) checkTempFiles() {
+	const name = "received chunks"
+	switch {
+	case d.cfg.TempDir == "":
+		d.check(name, StatusSkipped, "no temp directory given")
+		return
+	case d.sess == nil:
+		d.check(name, StatusSkipped, "session not loaded")
+		return
+	case d.sess.Status == models.SessionStatusCompleted:
+		d.check(name, StatusSkipped, "session complete; chunk files are removed")
+		return
+	}
+	r := &transport.TCPReceiver{TempDir: d.cfg.TempDir}
+	gone := r.MissingChunks(d.sess, false)
+	bad := r.MissingChunks(d.sess, true)
+	if len(bad) == 0 {
+		d.check(name, StatusOK, fmt.Sprintf("%d completed chunks on disk and verified", d.sess.Completed))
+		return
+	}
+	if len(gone) == d.sess.Completed {
+		// Sessions written in place keep no chunk files at all.
+		d.check(name, StatusWarn, "no chunk files found; the session may be written in place, or the temp directory is another one")
+		return
+	}
+	damaged := len(bad) - len(gone)
+	d.check(name, StatusFail, fmt.Sprintf("%d chunks missing, %d fail their hash", len(gone), damaged))
+	sort.Strings(bad)
+	d.find(Finding{
+		Cause:    CauseVerification,
+		Score:    75,
+		Summary:  fmt.Sprintf("%d chunks recorded as received are missing or damaged on disk", len(bad)),
+		Evidence: []string{"chunks " + strings.Join(bad[:min(len(bad), 10)], ", ")},
+		Actions: []string{
+			"restart the receiver: it re-checks chunk files at startup and asks for these again",
+			"check the disk holding " + d.cfg.TempDir + " for errors",
+		},
+	})
+}
+
+func (d *diagnosis) checkDisk() {
+	const name = "disk"
+	if d.cfg.TempDir == "" {
+		d.check(name, StatusSkipped, "no temp directory given")
+		return
+	}
+	probe, err := os.CreateTemp(d.cfg.TempDir, ".diagnose-*")
+	if err != nil {
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:    CauseDisk,
+			Score:    90,
+			Summary:  "the receiver's temp directory is not writable",
+			Evidence: []string{err.Error()},
+			Actions:  []string{"fix the permissions or mount of " + d.cfg.TempDir + ", then resume"},
+		})
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := reservation.DiskFree(d.cfg.TempDir)
+	if err != nil {
+		d.check(name, StatusOK, "writable; free space unknown")
+		return
+	}
+	detail := fmt.Sprintf("writable, %s free", utils.HumanBytes(free))
+	if d.sess == nil {
+		d.check(name, StatusOK, detail)
+		return
+	}
+	need := d.remaining()
+	if free >= need {
+		d.check(name, StatusOK, fmt.Sprintf("%s, %s still to receive", detail, utils.HumanBytes(need)))
+		return
+	}
+	d.check(name, StatusFail, fmt.Sprintf("%s, %s still to receive", detail, utils.HumanBytes(need)))
+	d.find(Finding{
+		Cause:    CauseDisk,
+		Score:    85,
+		Summary:  "not enough free space to finish the session",
+		Evidence: []string{fmt.Sprintf("%s free, %s to receive", utils.HumanBytes(free), utils.HumanBytes(need))},
+		Actions:  []string{fmt.Sprintf("free at least %s under %s, then resume", utils.HumanBytes(need-free), d.cfg.TempDir)},
+	})
+}
+
+func (d *diagnosis) checkLog() {
+	const name = "log"
+	if d.cfg.LogFile == "" {
+		d.check(name, StatusSkipped, "no log file given")
+		return
+	}
+	data, err := os.ReadFile(d.cfg.LogFile)
+	if err != nil {
+		d.check(name, StatusFail, err.Error())
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	// Most log lines carry no session ID, so everything from the first
+	// line naming the session on is searched; a log that never names it is
+	// searched near its end, where a stuck session stopped.
+	from := -1
+	for i, l := range lines {
+		if strings.Contains(l, d.cfg.SessionID) {
+			from = i
+			break
+		}
+	}
+	where := fmt.Sprintf("searched %d lines from the session's first mention", len(lines)-max(from, 0))
+	if from < 0 {
+		from = max(len(lines)-logTail, 0)
+		where = fmt.Sprintf("session not named; searched the last %d lines", len(lines)-from)
+	}
+	scope := lines[from:]
+
+	byCause := make(map[Cause][]string)
+	for _, l := range scope {
+		if cause, ok := classify(l); ok {
+			byCause[cause] = append(byCause[cause], strings.TrimSpace(l))
+		}
+	}
+	if len(byCause) == 0 {
+		d.check(name, StatusOK, where+", no errors")
+		return
+	}
+	d.check(name, StatusWarn, fmt.Sprintf("%s, %d error lines", where, countLines(byCause)))
+	for _, cause := range causes(byCause) {
+		found := byCause[cause]
+		f := remedy(cause)
+		f.Score += min(3*len(found), 20)
+		f.Summary = fmt.Sprintf("the log has %d %s errors", len(found), cause)
+		// The most recent lines say most about why the session stopped.
+		f.Evidence = found[max(len(found)-maxEvidence, 0):]
+		d.find(f)
+	}
+}
+
+func (d *diagnosis) checkEvents() {
+	const name = "telemetry"
+	if d.cfg.EventLog == "" {
+		d.check(name, StatusSkipped, "no event log given")
+		return
+	}
+	f, err := os.Open(d.cfg.EventLog)
+	if err != nil {
+		d.check(name, StatusFail, err.Error())
+		return
+	}
+	defer f.Close()
+
+	// Keep only the session's events; lines are matched on the raw JSON so
+	// malformed ones are still counted by Analyze.
+	var own bytes.Buffer
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if bytes.Contains(sc.Bytes(), []byte(d.cfg.SessionID)) {
+			own.Write(sc.Bytes())
+			own.WriteByte('\n')
+		}
+	}
+	if err := sc.Err(); err != nil {
+		d.check(name, StatusFail, err.Error())
+		return
+	}
+	rep, err := eventlog.Analyze(&own, time.Second)
+	if err != nil || rep.Totals.Start.IsZero() {
+		d.check(name, StatusWarn, "no events for the session")
+		return
+	}
+	t := rep.Totals
+	d.check(name, StatusOK, fmt.Sprintf("%d sent, %d received, %.2f%% lost or rejected",
+		t.Sent, t.Received, 100*t.LossRate()))
+
+	if n := rep.Causes[eventlog.CauseHashMismatch] + t.Rejected; n > 0 {
+		f := remedy(CauseVerification)
+		f.Score += min(2*n, 25)
+		f.Summary = fmt.Sprintf("%d chunks were rejected for failing their hash", n)
+		d.find(f)
+	}
+	if loss := t.LossRate(); loss > 0.05 {
+		f := remedy(CauseNetwork)
+		f.Score += min(int(loss*100), 30)
+		f.Summary = fmt.Sprintf("%.1f%% of transmissions were lost", 100*loss)
+		if rep.MeanRTTMs > 0 {
+			f.Evidence = []string{fmt.Sprintf("mean ack RTT %.1fms", rep.MeanRTTMs)}
+		}
+		d.find(f)
+	}
+	if n := rep.Causes[eventlog.CauseReplay]; n > 0 {
+		d.find(Finding{
+			Cause:   CauseNetwork,
+			Score:   25,
+			Summary: fmt.Sprintf("%d packets were dropped as replays", n),
+			Actions: []string{"check for a relay or middlebox duplicating packets, or two senders using the same session"},
+		})
+	}
+}
+
+func (d *diagnosis) checkPeer() {
+	const name = "peer"
+	if d.cfg.Peer == "" {
+		d.check(name, StatusSkipped, "no peer address given")
+		return
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", d.cfg.Peer, d.cfg.Timeout)
+	if err != nil {
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:    CauseNetwork,
+			Score:    80,
+			Summary:  "the peer " + d.cfg.Peer + " cannot be reached",
+			Evidence: []string{err.Error()},
+			Actions: []string{
+				"check that the receiver or relay is running and listening on that address",
+				"check firewalls and the receiver's -allow list between the two hosts",
+			},
+		})
+		return
+	}
+	conn.Close()
+	d.check(name, StatusOK, "reachable, connected in "+time.Since(start).Round(time.Millisecond).String())
+}
+
+func (d *diagnosis) checkControl() {
+	const name = "receiver API"
+	if d.cfg.Control == "" {
+		d.check(name, StatusSkipped, "no control URL given")
+		return
+	}
+	hc := &http.Client{Timeout: d.cfg.Timeout}
+	resp, err := hc.Get(strings.TrimRight(d.cfg.Control, "/") + "/api/v1/chunkmap/" + url.PathEscape(d.cfg.SessionID))
+	if err != nil {
+		d.check(name, StatusFail, err.Error())
+		d.find(Finding{
+			Cause:    CauseNetwork,
+			Score:    30,
+			Summary:  "the receiver's control API cannot be reached",
+			Evidence: []string{err.Error()},
+			Actions:  []string{"check that the receiver is running with -control-addr"},
+		})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		d.check(name, StatusWarn, "the receiver holds no chunk map for the session")
+		if d.sess != nil && d.sess.Status == models.SessionStatusTransferring {
+			d.find(Finding{
+				Cause:   CauseNetwork,
+				Score:   45,
+				Summary: "the session is saved as transferring but the receiver is not receiving it",
+				Actions: []string{"the connection was lost; resume the sender with -resume " + d.sess.ID},
+			})
+		}
+		return
+	}
+	var snap chunkstate.Snapshot
+	if resp.StatusCode != http.StatusOK {
+		d.check(name, StatusFail, "unexpected status: "+resp.Status)
+		return
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		d.check(name, StatusFail, "decode chunk map: "+err.Error())
+		return
+	}
+	detail := fmt.Sprintf("%d chunks, %d stored, %d gaps", snap.Chunks, snap.Counts["stored"], len(snap.Gaps))
+	if snap.Done {
+		detail += ", finished"
+	}
+	d.check(name, StatusOK, detail)
+	if !snap.Done && snap.Counts["pending"] > 0 {
+		d.find(Finding{
+			Cause:   CauseVerification,
+			Score:   35,
+			Summary: fmt.Sprintf("the receiver is waiting for %d chunks to be sent again", snap.Counts["pending"]),
+			Actions: []string{"check the sender is still running; pending chunks usually failed verification"},
+		})
+	}
+}
+
+func (d *diagnosis) checkOrchestrator() {
+	const name = "orchestrator"
+	if d.cfg.Orchestrator == "" {
+		d.check(name, StatusSkipped, "no orchestrator URL given")
+		return
+	}
+	c := client.NewOrchestratorClient(d.cfg.Orchestrator)
+	c.HTTPClient.Timeout = d.cfg.Timeout
+	c.Token = d.cfg.OrchestratorToken
+	sess, err := c.GetSession(d.cfg.SessionID)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, os.ErrDeadlineExceeded) {
+			d.find(Finding{
+				Cause:    CauseNetwork,
+				Score:    20,
+				Summary:  "the orchestrator cannot be reached",
+				Evidence: []string{err.Error()},
+				Actions:  []string{"check the orchestrator is running; senders need it to create and resume sessions"},
+			})
+		}
+		d.check(name, StatusFail, err.Error())
+		return
+	}
+	d.check(name, StatusOK, fmt.Sprintf("%s, %d of %d chunks", sess.Status, sess.Completed, sess.TotalChunks))
+	if d.sess != nil && sess.Status != d.sess.Status {
+		d.find(Finding{
+			Cause:    CauseState,
+			Score:    30,
+			Summary:  "the orchestrator and this node disagree on the session's status",
+			Evidence: []string{fmt.Sprintf("orchestrator: %s, local: %s", sess.Status, d.sess.Status)},
+			Actions:  []string{"resume the session so both sides record its current state"},
+		})
+	}
+}
+
+// patterns maps each cause to lowercase fragments of the errors it shows
+// up as. Version is matched first, as its errors may also mention
+// connections.
+var patterns = []struct {
+	cause Cause
+	words []string
+}{
+	{CauseVersion, []string{"unsupported protocol version", "newer release", "version mismatch", "needs v"}},
+	{CauseVerification, []string{"hash mismatch", "checksum", "corrupt", "verification failed"}},
+	{CauseDisk, []string{"no space left", "not enough unreserved disk", "disk quota", "read-only file system", "permission denied", "too many open files"}},
+	{CauseNetwork, []string{"connection refused", "connection reset", "broken pipe", "i/o timeout", "no route to host", "network is unreachable", "deadline exceeded", "unexpected eof", "circuit open"}},
+}
+
+// classify returns the cause an error message points to.
+func classify(msg string) (Cause, bool) {
+	msg = strings.ToLower(msg)
+	for _, p := range patterns {
+		for _, w := range p.words {
+			if strings.Contains(msg, w) {
+				return p.cause, true
+			}
+		}
+	}
+	return "", false
+}
+
+// remedy returns a finding for cause with its base score and the usual
+// next steps; callers fill in the summary and evidence.
+func remedy(cause Cause) Finding {
+	f := Finding{Cause: cause}
+	switch cause {
+	case CauseVersion:
+		f.Score = 60
+		f.Actions = []string{"run the same release on both ends, or one within the other's supported protocol versions"}
+	case CauseVerification:
+		f.Score = 45
+		f.Actions = []string{
+			"check the source file is not being modified while it is sent",
+			"check memory and disks on both ends; repeated hash failures on one host point at its hardware",
+		}
+	case CauseDisk:
+		f.Score = 50
+		f.Actions = []string{"free space or fix permissions on the receiver's output and temp directories, then resume"}
+	default:
+		f.Score = 35
+		f.Actions = []string{
+			"check connectivity between sender and receiver (firewalls, VPN, relay)",
+			"resume the session; completed chunks are kept",
+		}
+	}
+	return f
+}
+
+// causes returns the causes in m in the order of patterns.
+func causes(m map[Cause][]string) []Cause {
+	var out []Cause
+	for _, p := range patterns {
+		if len(m[p.cause]) > 0 {
+			out = append(out, p.cause)
+		}
+	}
+	return out
+}
+
+func countLines(m map[Cause][]string) int {
+	n := 0
+	for _, l := range m {
+		n += len(l)
+	}
+	return n
+}
@@ -0,0 +1,165 @@
+// Package diagnose inspects everything a node knows about one session — its
+// saved state, checkpoints, received chunks, logs and telemetry, and what
+// the peer and orchestrator report — and ranks the probable reasons it is
+// stuck, so an operator gets a next step from one command.
+package diagnose
+
+import (
+	"sort"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// DefaultTimeout bounds each network probe.
+const DefaultTimeout = 3 * time.Second
+
+// DefaultStallAfter is how long a transferring session may go without
+// progress before it counts as stalled.
+const DefaultStallAfter = 10 * time.Minute
+
+// Cause is a class of problem a finding blames.
+type Cause string
+
+const (
+	CauseNetwork      Cause = "network"
+	CauseDisk         Cause = "disk"
+	CauseVerification Cause = "verification"
+	CauseVersion      Cause = "version"
+	// CauseState covers the session's own records: missing or damaged
+	// session files and sessions left paused or failed.
+	CauseState Cause = "state"
+)
+
+// Status is the outcome of one check.
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusWarn    Status = "warn"
+	StatusFail    Status = "fail"
+	StatusSkipped Status = "skipped"
+)
+
+// Config says where to look. Only SessionID is required; every source left
+// empty is skipped.
+type Config struct {
+	SessionID string
+	// SessionsDir and Store locate the saved session, as the sender's or
+	// receiver's -sessions-dir and -session-store.
+	SessionsDir string
+	Store       string
+	// TempDir is the receiver's chunk directory, checked for the chunks
+	// the session records as received and for free space.
+	TempDir string
+	// LogFile is a sender or receiver log searched for errors.
+	LogFile string
+	// EventLog is an NDJSON event log written with -event-log.
+	EventLog string
+	// Peer is the address the sender dials: a receiver or relay.
+	Peer string
+	// Control is the base URL of the receiver's control API.
+	Control string
+	// Orchestrator is the base URL of the orchestrator, and
+	// OrchestratorToken the API token presented to it, if it needs one.
+	Orchestrator      string
+	OrchestratorToken string
+	// Timeout bounds each network probe (DefaultTimeout if zero).
+	Timeout time.Duration
+	// StallAfter is how long a transferring session may go without
+	// progress before it counts as stalled (DefaultStallAfter if zero).
+	StallAfter time.Duration
+}
+
+// Summary describes the session as saved.
+type Summary struct {
+	File            string               `json:"file"`
+	Size            int64                `json:"size"`
+	Status          models.SessionStatus `json:"status"`
+	Chunks          int                  `json:"chunks"`
+	Completed       int                  `json:"completed"`
+	Failed          int                  `json:"failed"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+	ProtocolVersion uint8                `json:"protocol_version,omitempty"`
+}
+
+// Check is the outcome of inspecting one source.
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Finding is a probable cause with what points to it and what to do next.
+// Score, from 1 to 100, ranks findings: higher is more likely to be the
+// reason the session is stuck.
+type Finding struct {
+	Cause    Cause    `json:"cause"`
+	Score    int      `json:"score"`
+	Summary  string   `json:"summary"`
+	Evidence []string `json:"evidence,omitempty"`
+	Actions  []string `json:"actions"`
+}
+
+// Report is the result of a diagnosis. Findings are ranked, most likely
+// first; none means nothing wrong was found.
+type Report struct {
+	SessionID string    `json:"session_id"`
+	Session   *Summary  `json:"session,omitempty"`
+	Checks    []Check   `json:"checks"`
+	Findings  []Finding `json:"findings"`
+}
+
+// diagnosis carries the state shared by the checks of one Run.
+type diagnosis struct {
+	cfg  Config
+	now  time.Time
+	rep  *Report
+	sess *models.TransferSession // nil if the session could not be loaded
+}
+
+// Run inspects every source cfg names and ranks the findings. Sources that
+// cannot be read become failed checks rather than errors, as an
+// unreachable peer or missing log is itself evidence.
+func Run(cfg Config) *Report {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.StallAfter <= 0 {
+		cfg.StallAfter = DefaultStallAfter
+	}
+	d := &diagnosis{cfg: cfg, now: time.Now(), rep: &Report{SessionID: cfg.SessionID}}
+	d.checkSession()
+	d.checkTempFiles()
+	d.checkDisk()
+	d.checkLog()
+	d.checkEvents()
+	d.checkPeer()
+	d.checkControl()
+	d.checkOrchestrator()
+
+	sort.SliceStable(d.rep.Findings, func(i, j int) bool {
+		return d.rep.Findings[i].Score > d.rep.Findings[j].Score
+	})
+	return d.rep
+}
+
+func (d *diagnosis) check(name string, status Status, detail string) {
+	d.rep.Checks = append(d.rep.Checks, Check{Name: name, Status: status, Detail: detail})
+}
+
+func (d *diagnosis) find(f Finding) {
+	f.Score = min(max(f.Score, 1), 100)
+	d.rep.Findings = append(d.rep.Findings, f)
+}
+
+// remaining is the number of bytes of the session not yet received.
+func (d *diagnosis) remaining() int64 {
+	var done int64
+	for _, c := range d.sess.Chunks {
+		if c.Status == models.ChunkStatusCompleted && !c.IsParity {
+			done += c.Size
+		}
+	}
+	return max(d.sess.File.Size-done, 0)
+}
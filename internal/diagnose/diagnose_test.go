@@ -0,0 +1,160 @@
+package diagnose
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// saveSession writes a transferring session of four 4-byte chunks, the
+// first two received into tempDir, last updated at updated.
+func saveSession(t *testing.T, dir, tempDir string, updated time.Time) *models.TransferSession {
+	t.Helper()
+	sess := &models.TransferSession{
+		ID:          "stuck",
+		File:        models.FileMetadata{Name: "data.bin", Size: 16, Hash: "abc"},
+		Status:      models.SessionStatusTransferring,
+		Chunks:      make(map[string]*models.ChunkMetadata),
+		CreatedAt:   updated.Add(-time.Hour),
+		UpdatedAt:   updated,
+		TotalChunks: 4,
+		Completed:   2,
+	}
+	for i := range 4 {
+		data := []byte(fmt.Sprintf("abc%d", i))
+		c := &models.ChunkMetadata{
+			ID:        fmt.Sprint(i),
+			SessionID: sess.ID,
+			Offset:    int64(4 * i),
+			Size:      4,
+			SHA256:    utils.HashBytesSHA256(data),
+			Status:    models.ChunkStatusPending,
+			CreatedAt: sess.CreatedAt,
+		}
+		if i < 2 {
+			c.Status = models.ChunkStatusCompleted
+			part := filepath.Join(tempDir, fmt.Sprintf("%s_%s.part", sess.ID, c.ID))
+			if err := os.WriteFile(part, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sess.Chunks[c.ID] = c
+	}
+	if err := session.NewJSONStore(dir).Save(sess); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	return sess
+}
+
+// closedAddr returns a local address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestRunRanksFindings(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	saveSession(t, dir, tempDir, time.Now().Add(-time.Hour))
+	// Damage a received chunk in place.
+	if err := os.WriteFile(filepath.Join(tempDir, "stuck_1.part"), []byte("XXXX"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(t.TempDir(), "sender.log")
+	log := "2026/10/16 10:00:00 Resuming session stuck (50% complete)\n" +
+		"2026/10/16 10:00:01 transfer: dial tcp 10.0.0.2:9000: connect: connection refused\n"
+	if err := os.WriteFile(logFile, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := Run(Config{
+		SessionID:   "stuck",
+		SessionsDir: dir,
+		TempDir:     tempDir,
+		LogFile:     logFile,
+		Peer:        closedAddr(t),
+		Timeout:     time.Second,
+	})
+	if rep.Session == nil || rep.Session.Completed != 2 {
+		t.Fatalf("session summary = %+v", rep.Session)
+	}
+	if len(rep.Findings) == 0 {
+		t.Fatal("no findings")
+	}
+	if top := rep.Findings[0]; top.Cause != CauseNetwork || !strings.Contains(top.Summary, "cannot be reached") {
+		t.Fatalf("top finding = %+v", top)
+	}
+	for i := 1; i < len(rep.Findings); i++ {
+		if rep.Findings[i].Score > rep.Findings[i-1].Score {
+			t.Fatalf("findings not ranked: %+v", rep.Findings)
+		}
+	}
+	var verification, stalled, logged bool
+	for _, f := range rep.Findings {
+		verification = verification || f.Cause == CauseVerification && strings.Contains(f.Evidence[0], "1")
+		stalled = stalled || strings.HasPrefix(f.Summary, "no progress")
+		logged = logged || f.Cause == CauseNetwork && strings.HasPrefix(f.Summary, "the log")
+		if len(f.Actions) == 0 {
+			t.Errorf("finding without actions: %+v", f)
+		}
+	}
+	if !verification || !stalled || !logged {
+		t.Fatalf("verification %v, stalled %v, log %v: %+v", verification, stalled, logged, rep.Findings)
+	}
+}
+
+func TestRunHealthySession(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	saveSession(t, dir, tempDir, time.Now())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	rep := Run(Config{SessionID: "stuck", SessionsDir: dir, TempDir: tempDir, Peer: ln.Addr().String()})
+	if len(rep.Findings) != 0 {
+		t.Fatalf("findings for a healthy session: %+v", rep.Findings)
+	}
+	for _, c := range rep.Checks {
+		if c.Status == StatusFail || c.Status == StatusWarn {
+			t.Errorf("check %s: %s %s", c.Name, c.Status, c.Detail)
+		}
+	}
+}
+
+func TestRunUnknownSession(t *testing.T) {
+	rep := Run(Config{SessionID: "nope", SessionsDir: t.TempDir()})
+	if len(rep.Findings) != 1 || rep.Findings[0].Cause != CauseState {
+		t.Fatalf("findings = %+v", rep.Findings)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for msg, want := range map[string]Cause{
+		"write chunk: no space left on device":              CauseDisk,
+		"chunk hash mismatch":                               CauseVerification,
+		"read: connection reset by peer":                    CauseNetwork,
+		"unsupported protocol version: 12 (supported 1-11)": CauseVersion,
+	} {
+		if got, ok := classify(msg); !ok || got != want {
+			t.Errorf("classify(%q) = %q, %v; want %q", msg, got, ok, want)
+		}
+	}
+	if got, ok := classify("Starting transfer"); ok {
+		t.Errorf("classify matched an ordinary line as %q", got)
+	}
+}
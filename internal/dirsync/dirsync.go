@@ -0,0 +1,294 @@
+// Package dirsync replicates a directory as it is written: Sync hands the
+// entries of the directory to a send function, then watches it and hands
+// over every entry created or changed once it has settled.
+//
+// Entries are the directory's top-level files and subdirectories; a change
+// anywhere under a subdirectory makes the whole subdirectory an entry to
+// send. Deletions are not replicated.
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultSettle is how long an entry must go unchanged before it is
+	// sent, so that files still being written are not sent half done.
+	DefaultSettle = 2 * time.Second
+	// DefaultRetry is how long an entry that failed to send waits before
+	// it is sent again.
+	DefaultRetry = 30 * time.Second
+)
+
+// SendFunc sends entries, paths of top-level entries of the synced
+// directory, and returns those that were not delivered.
+type SendFunc func(ctx context.Context, entries []string) (failed []string)
+
+// Options configures Sync.
+type Options struct {
+	Dir string
+	// Watch keeps watching Dir after the initial pass, until the context
+	// is done.
+	Watch bool
+	// SkipInitial sends only what changes while watching, instead of every
+	// entry of Dir first.
+	SkipInitial bool
+	// Settle and Retry default to DefaultSettle and DefaultRetry.
+	Settle time.Duration
+	Retry  time.Duration
+	Send   SendFunc
+}
+
+// Ignored reports whether an entry named name is left out of the sync:
+// hidden files and the temporary files editors and downloaders write
+// before renaming them into place.
+func Ignored(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part")
+}
+
+// stamp identifies the content of an entry well enough to tell whether it
+// changed since it was sent: a file's size and modification time, or the
+// totals of the files under a directory and the latest modification time.
+type stamp struct {
+	size  int64
+	files int
+	mod   time.Time
+}
+
+func stampOf(path string) (stamp, error) {
+	var st stamp
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(st.mod) {
+			st.mod = info.ModTime()
+		}
+		if info.Mode().IsRegular() {
+			st.size += info.Size()
+			st.files++
+		}
+		return nil
+	})
+	return st, err
+}
+
+type syncer struct {
+	opts    Options
+	watcher *fsnotify.Watcher
+	// dirty holds the entries to send, with when they last changed;
+	// retryAt when those that failed may be sent again.
+	dirty   map[string]time.Time
+	retryAt map[string]time.Time
+	// sent holds the stamp of each entry when it was last delivered.
+	sent map[string]stamp
+}
+
+// Sync sends every entry of opts.Dir with opts.Send, unless
+// opts.SkipInitial is set, then with opts.Watch keeps sending those created
+// or changed until ctx is done, and returns ctx.Err(). Without opts.Watch it
+// returns after the initial pass, with an error if any entry failed.
+func Sync(ctx context.Context, opts Options) error {
+	if opts.Settle <= 0 {
+		opts.Settle = DefaultSettle
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = DefaultRetry
+	}
+	info, err := os.Stat(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", opts.Dir)
+	}
+	s := &syncer{
+		opts:    opts,
+		dirty:   make(map[string]time.Time),
+		retryAt: make(map[string]time.Time),
+		sent:    make(map[string]stamp),
+	}
+
+	if opts.Watch {
+		// Watch before the initial pass so nothing written during it is
+		// missed.
+		if s.watcher, err = fsnotify.NewWatcher(); err != nil {
+			return err
+		}
+		defer s.watcher.Close()
+		if err := s.addTree(opts.Dir); err != nil {
+			return err
+		}
+	}
+	if !opts.SkipInitial {
+		if err := s.markAll(); err != nil {
+			return err
+		}
+		if failed := s.flush(ctx, time.Now()); len(failed) > 0 && !opts.Watch {
+			return fmt.Errorf("%d of the entries of %s were not sent", len(failed), opts.Dir)
+		}
+	}
+	if !opts.Watch {
+		return nil
+	}
+
+	tick := time.NewTicker(max(opts.Settle/4, 10*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			s.event(ev, time.Now())
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Changes were lost; look at every entry again.
+				log.Printf("Sync %s: %v; rescanning", opts.Dir, err)
+				if err := s.markAll(); err != nil {
+					log.Printf("Sync %s: %v", opts.Dir, err)
+				}
+				continue
+			}
+			log.Printf("Sync %s: watch: %v", opts.Dir, err)
+		case now := <-tick.C:
+			s.flush(ctx, now)
+		}
+	}
+}
+
+// markAll marks every entry of the directory to be sent.
+func (s *syncer) markAll() error {
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !Ignored(e.Name()) {
+			s.dirty[filepath.Join(s.opts.Dir, e.Name())] = time.Time{}
+		}
+	}
+	return nil
+}
+
+// addTree watches dir and every directory under it.
+func (s *syncer) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && Ignored(d.Name()) {
+			return filepath.SkipDir
+		}
+		return s.watcher.Add(path)
+	})
+}
+
+// entry returns the top-level entry of the synced directory that path is
+// or lies under, or "" if there is none or it is ignored.
+func (s *syncer) entry(path string) string {
+	rel, err := filepath.Rel(s.opts.Dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	name, _, _ := strings.Cut(rel, string(filepath.Separator))
+	if Ignored(name) {
+		return ""
+	}
+	return filepath.Join(s.opts.Dir, name)
+}
+
+// event records the change ev describes.
+func (s *syncer) event(ev fsnotify.Event, now time.Time) {
+	entry := s.entry(ev.Name)
+	if entry == "" || ev.Op == fsnotify.Chmod {
+		return
+	}
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			// A new directory, or one moved in, may already hold files.
+			if err := s.addTree(ev.Name); err != nil {
+				log.Printf("Sync %s: watch %s: %v", s.opts.Dir, ev.Name, err)
+			}
+		}
+	}
+	if _, err := os.Lstat(entry); errors.Is(err, fs.ErrNotExist) {
+		// Removed, or moved out: nothing to send.
+		delete(s.dirty, entry)
+		delete(s.sent, entry)
+		return
+	}
+	s.dirty[entry] = now
+}
+
+// flush sends the entries that have settled by now and may be sent, and
+// returns those that failed.
+func (s *syncer) flush(ctx context.Context, now time.Time) []string {
+	var ready []string
+	stamps := make(map[string]stamp)
+	for entry, changed := range s.dirty {
+		if now.Sub(changed) < s.opts.Settle || now.Before(s.retryAt[entry]) {
+			continue
+		}
+		st, err := stampOf(entry)
+		if err != nil {
+			// Gone, or unreadable until it changes again.
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Sync %s: %v", s.opts.Dir, err)
+			}
+			delete(s.dirty, entry)
+			continue
+		}
+		if prev, ok := s.sent[entry]; ok && prev == st {
+			delete(s.dirty, entry)
+			continue
+		}
+		ready = append(ready, entry)
+		stamps[entry] = st
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	slices.Sort(ready)
+	failed := s.opts.Send(ctx, ready)
+	for _, entry := range ready {
+		if slices.Contains(failed, entry) {
+			s.retryAt[entry] = time.Now().Add(s.opts.Retry)
+			continue
+		}
+		delete(s.retryAt, entry)
+		s.sent[entry] = stamps[entry]
+		// A change made while the entry was being sent stays to be sent.
+		if !s.dirty[entry].After(now) {
+			delete(s.dirty, entry)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,125 @@
+package dirsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a SendFunc that records each batch and fails the entries in
+// fail once each.
+type recorder struct {
+	mu      sync.Mutex
+	fail    map[string]bool
+	batches chan []string
+}
+
+func (r *recorder) send(_ context.Context, entries []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var failed []string
+	for _, e := range entries {
+		if r.fail[filepath.Base(e)] {
+			delete(r.fail, filepath.Base(e))
+			failed = append(failed, e)
+		}
+	}
+	r.batches <- entries
+	return failed
+}
+
+func (r *recorder) next(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	select {
+	case got := <-r.batches:
+		if !slices.Equal(got, want) {
+			t.Fatalf("sent %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nothing sent, want %v", want)
+	}
+}
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncWatch(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.bin"), "a")
+	write(t, filepath.Join(dir, "clips", "x.mxf"), "x")
+	write(t, filepath.Join(dir, ".hidden"), "h")
+
+	r := &recorder{fail: map[string]bool{"b.bin": true}, batches: make(chan []string, 16)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Sync(ctx, Options{Dir: dir, Watch: true, Settle: 50 * time.Millisecond, Retry: 200 * time.Millisecond, Send: r.send})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	r.next(t, dir, "a.bin", "clips")
+
+	// A file written under a subdirectory sends the subdirectory.
+	write(t, filepath.Join(dir, "clips", "day2", "y.mxf"), "y")
+	r.next(t, dir, "clips")
+
+	// A failed entry is sent again after the retry interval.
+	write(t, filepath.Join(dir, "b.bin"), "b")
+	r.next(t, dir, "b.bin")
+	r.next(t, dir, "b.bin")
+
+	// Ignored names are not sent; a changed file is.
+	write(t, filepath.Join(dir, "c.bin.part"), "partial")
+	write(t, filepath.Join(dir, "a.bin"), "a, changed")
+	r.next(t, dir, "a.bin")
+	select {
+	case got := <-r.batches:
+		t.Fatalf("unexpected batch %v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSyncOnce(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.bin"), "a")
+	write(t, filepath.Join(dir, "b.bin"), "b")
+	r := &recorder{fail: map[string]bool{"b.bin": true}, batches: make(chan []string, 1)}
+	if err := Sync(context.Background(), Options{Dir: dir, Send: r.send}); err == nil {
+		t.Error("Sync succeeded with an entry failing")
+	}
+	r.next(t, dir, "a.bin", "b.bin")
+	if err := Sync(context.Background(), Options{Dir: filepath.Join(dir, "a.bin"), Send: r.send}); err == nil {
+		t.Error("Sync accepted a file as the directory")
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	for name, want := range map[string]bool{
+		"film.mxf":      false,
+		".DS_Store":     true,
+		"film.mxf~":     true,
+		"film.mxf.part": true,
+		"upload.tmp":    true,
+	} {
+		if got := Ignored(name); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -42,4 +42,21 @@ func TestEncodeDecodeRoundTrip(t *testing.T) {
 	}
 }
 
-
+func TestParityShardsForLoss(t *testing.T) {
+	cases := []struct {
+		loss float64
+		want int
+	}{
+		{0, 0},
+		{0.001, 0},
+		{0.02, 1},
+		{0.10, 3},
+		{0.5, 15},
+		{1.0, 20},
+	}
+	for _, tc := range cases {
+		if got := ParityShardsForLoss(20, tc.loss); got != tc.want {
+			t.Fatalf("ParityShardsForLoss(20, %v) = %d, want %d", tc.loss, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Bucket counts the events of one time interval.
+type Bucket struct {
+	Start         time.Time `json:"start"`
+	Sent          int       `json:"sent"`
+	Acked         int       `json:"acked"`
+	Nacked        int       `json:"nacked"`
+	Retransmitted int       `json:"retransmitted"`
+	Received      int       `json:"received"`
+	Rejected      int       `json:"rejected"`
+}
+
+// LossRate returns the fraction of transmissions reported lost or rejected.
+// Sender logs are measured against packets sent, receiver logs against
+// chunks received.
+func (b Bucket) LossRate() float64 {
+	lost := b.Nacked + b.Rejected
+	attempts := b.Sent + b.Retransmitted
+	if attempts == 0 {
+		attempts = b.Received + b.Rejected
+	}
+	if attempts == 0 {
+		return 0
+	}
+	return float64(lost) / float64(attempts)
+}
+
+func (b *Bucket) add(e Event) {
+	switch e.Type {
+	case EventSent:
+		b.Sent++
+	case EventAcked:
+		b.Acked++
+	case EventNacked:
+		b.Nacked++
+	case EventRetransmitted:
+		b.Retransmitted++
+	case EventReceived:
+		b.Received++
+	case EventRejected:
+		b.Rejected++
+	}
+}
+
+// Report summarises an event log.
+type Report struct {
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	Bucket    time.Duration  `json:"bucket"`
+	Buckets   []Bucket       `json:"buckets"`
+	Totals    Bucket         `json:"totals"`
+	Causes    map[string]int `json:"retransmit_causes"` // retransmissions by cause
+	MeanRTTMs float64        `json:"mean_rtt_ms"`       // over acked events that carry an RTT
+	Malformed int            `json:"malformed"`         // lines that could not be parsed
+}
+
+// Analyze reads an NDJSON event log and aggregates it into buckets of the
+// given width, starting at the earliest event. Malformed lines are counted
+// and skipped, so a log truncated by a crash can still be analysed.
+func Analyze(r io.Reader, bucket time.Duration) (*Report, error) {
+	if bucket <= 0 {
+		return nil, errors.New("bucket width must be positive")
+	}
+	rep := &Report{Bucket: bucket, Causes: make(map[string]int)}
+
+	var events []Event
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil || e.Time.IsZero() {
+			rep.Malformed++
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read event log: %w", err)
+	}
+	if len(events) == 0 {
+		return rep, nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	rep.Start = events[0].Time
+	rep.End = events[len(events)-1].Time
+	n := int(rep.End.Sub(rep.Start)/bucket) + 1
+	rep.Buckets = make([]Bucket, n)
+	for i := range rep.Buckets {
+		rep.Buckets[i].Start = rep.Start.Add(time.Duration(i) * bucket)
+	}
+	rep.Totals.Start = rep.Start
+
+	var rttSum float64
+	var rttN int
+	for _, e := range events {
+		rep.Buckets[int(e.Time.Sub(rep.Start)/bucket)].add(e)
+		rep.Totals.add(e)
+		switch e.Type {
+		case EventRetransmitted:
+			cause := e.Cause
+			if cause == "" {
+				cause = "unknown"
+			}
+			rep.Causes[cause]++
+		case EventAcked:
+			if e.DurationMs > 0 {
+				rttSum += e.DurationMs
+				rttN++
+			}
+		}
+	}
+	if rttN > 0 {
+		rep.MeanRTTMs = rttSum / float64(rttN)
+	}
+	return rep, nil
+}
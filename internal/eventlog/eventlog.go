@@ -0,0 +1,140 @@
+// Package eventlog records protocol-level events as newline-delimited JSON
+// for offline analysis, and summarises such logs.
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened to a packet or chunk.
+type EventType string
+
+const (
+	EventSent          EventType = "sent"
+	EventAcked         EventType = "acked"
+	EventNacked        EventType = "nacked"
+	EventRetransmitted EventType = "retransmitted"
+	EventReceived      EventType = "received"
+	EventRejected      EventType = "rejected"
+	// EventState records a chunk moving to a new state on the receiver;
+	// Event.State names the state.
+	EventState EventType = "state"
+)
+
+// Retransmission and rejection causes recorded in Event.Cause.
+const (
+	CauseNack         = "nack"          // the receiver reported the packet missing
+	CauseTimeout      = "timeout"       // no acknowledgement within the retransmit timeout
+	CauseSessionRetry = "session_retry" // the whole session was re-attempted
+	CauseChunkRetry   = "chunk_retry"   // the chunk's send failed and it was sent over a new connection
+	CauseHashMismatch = "hash_mismatch" // the receiver rejected corrupted data
+	CauseReplay       = "replay"        // the receiver had already seen the packet
+)
+
+// Event is one line of an event log. Seq is set for UDP packets, Chunk for
+// chunk-level events.
+type Event struct {
+	Time    time.Time `json:"ts"`
+	Type    EventType `json:"type"`
+	Session string    `json:"session,omitempty"`
+	Chunk   string    `json:"chunk,omitempty"`
+	Seq     uint32    `json:"seq,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	// DurationMs is the RTT for acknowledgements, the send time for chunks
+	// and the one-way delay for received chunks.
+	DurationMs float64 `json:"duration_ms,omitempty"`
+	Cause      string  `json:"cause,omitempty"`
+	// AppData is the application metadata the sender attached to a chunk.
+	AppData []byte `json:"app_data,omitempty"`
+	// State is the chunk's new state for state events.
+	State string `json:"state,omitempty"`
+}
+
+// Logger writes events as NDJSON. A nil *Logger discards events, so callers
+// can log unconditionally and gate logging by whether a Logger was created.
+type Logger struct {
+	mu        sync.Mutex
+	w         *bufio.Writer
+	enc       *json.Encoder
+	closer    io.Closer
+	lastFlush time.Time
+}
+
+// flushInterval bounds how long events stay buffered, so a process that is
+// killed loses at most this much of its log.
+const flushInterval = time.Second
+
+// New returns a Logger writing to w.
+func New(w io.Writer) *Logger {
+	bw := bufio.NewWriter(w)
+	l := &Logger{w: bw, enc: json.NewEncoder(bw)}
+	if c, ok := w.(io.Closer); ok {
+		l.closer = c
+	}
+	return l
+}
+
+// Open returns a Logger appending to the file at path.
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open event log: %w", err)
+	}
+	return New(f), nil
+}
+
+// Log records e, stamping it with the current time if e.Time is zero.
+func (l *Logger) Log(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.enc.Encode(e)
+	if now := time.Now(); now.Sub(l.lastFlush) >= flushInterval {
+		_ = l.w.Flush()
+		l.lastFlush = now
+	}
+}
+
+// Flush writes buffered events to the underlying writer.
+func (l *Logger) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastFlush = time.Now()
+	return l.w.Flush()
+}
+
+// Close flushes buffered events and closes the underlying writer if it is
+// an io.Closer.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.w.Flush()
+	if l.closer != nil {
+		if cerr := l.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Millis converts d to fractional milliseconds for Event.DurationMs.
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,62 @@
+package eventlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogAndAnalyze(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// First second: 4 packets, 1 nacked and retransmitted.
+	for seq := uint32(1); seq <= 4; seq++ {
+		l.Log(Event{Time: t0.Add(time.Duration(seq) * 100 * time.Millisecond), Type: EventSent, Seq: seq, Bytes: 1000})
+	}
+	l.Log(Event{Time: t0.Add(500 * time.Millisecond), Type: EventAcked, Seq: 1, DurationMs: 20})
+	l.Log(Event{Time: t0.Add(600 * time.Millisecond), Type: EventNacked, Seq: 2})
+	l.Log(Event{Time: t0.Add(700 * time.Millisecond), Type: EventRetransmitted, Seq: 5, Cause: CauseNack})
+	// Third second: a timeout-driven retransmission.
+	l.Log(Event{Time: t0.Add(2500 * time.Millisecond), Type: EventRetransmitted, Seq: 6, Cause: CauseTimeout})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 8 {
+		t.Fatalf("wrote %d lines, want 8", lines)
+	}
+
+	buf.WriteString("{not json\n")
+	rep, err := Analyze(&buf, time.Second)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if rep.Malformed != 1 {
+		t.Fatalf("malformed = %d, want 1", rep.Malformed)
+	}
+	if len(rep.Buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(rep.Buckets))
+	}
+	if got := rep.Buckets[0].LossRate(); got != 0.2 {
+		t.Fatalf("bucket 0 loss = %v, want 0.2 (1 nack / 5 transmissions)", got)
+	}
+	if rep.Buckets[1].LossRate() != 0 || rep.Buckets[2].Retransmitted != 1 {
+		t.Fatalf("unexpected later buckets: %+v", rep.Buckets[1:])
+	}
+	if rep.Causes[CauseNack] != 1 || rep.Causes[CauseTimeout] != 1 {
+		t.Fatalf("causes = %v", rep.Causes)
+	}
+	if rep.MeanRTTMs != 20 {
+		t.Fatalf("mean RTT = %v, want 20", rep.MeanRTTMs)
+	}
+}
+
+func TestNilLoggerDiscards(t *testing.T) {
+	var l *Logger
+	l.Log(Event{Type: EventSent})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
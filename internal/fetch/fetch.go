@@ -0,0 +1,373 @@
+// Package fetch downloads a file that several receivers hold from all of
+// them at once. Each source serves distinct chunks, taken from a shared
+// queue as it finishes the previous ones, so a fast source carries more of
+// the file than a slow one and their throughput adds up. Every chunk is
+// checked against the hash in the file's chunk manifest before it is
+// written, and the whole file against its content hash at the end.
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+const (
+	// DefaultChunkSize is the chunk size of a fetch unless told otherwise.
+	DefaultChunkSize = 8 << 20
+	// MinChunkSize is the smallest chunk size a manifest is built with.
+	MinChunkSize = 64 << 10
+	// DefaultPerSource is the number of chunks requested from each source
+	// at a time.
+	DefaultPerSource = 2
+	// DefaultMaxFailures is how many chunks in a row may fail from one
+	// source before it is dropped.
+	DefaultMaxFailures = 3
+)
+
+// Manifest lists the chunks of a held file with their hashes.
+type Manifest struct {
+	File   models.FileMetadata     `json:"file"`
+	Chunks []*models.ChunkMetadata `json:"chunks"`
+}
+
+// NewManifest hashes the chunks of file, read from r, cut at chunkSize.
+func NewManifest(r io.ReaderAt, file models.FileMetadata, chunkSize int64) (*Manifest, error) {
+	if chunkSize < MinChunkSize {
+		chunkSize = MinChunkSize
+	}
+	c := chunker.NewChunker(chunker.ChunkerConfig{MinChunkSize: MinChunkSize, DefaultChunkSize: DefaultChunkSize})
+	chunks, err := c.ChunkReaderAt(r, file.Size, chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", file.Name, err)
+	}
+	return &Manifest{File: file, Chunks: chunks}, nil
+}
+
+// validate checks that m describes the file with content hash hash and
+// that its chunks cover the file end to end.
+func (m *Manifest) validate(hash string) error {
+	if m.File.Hash != hash {
+		return fmt.Errorf("manifest is for %q, not %q", m.File.Hash, hash)
+	}
+	var off int64
+	for i, c := range m.Chunks {
+		if c.Offset != off || c.Size <= 0 || c.SHA256 == "" {
+			return fmt.Errorf("manifest chunk %d at %d+%d does not follow on at %d", i, c.Offset, c.Size, off)
+		}
+		off += c.Size
+	}
+	if off != m.File.Size {
+		return fmt.Errorf("manifest chunks cover %d of %d bytes", off, m.File.Size)
+	}
+	return nil
+}
+
+// Options configures Fetch.
+type Options struct {
+	// Sources are the control API URLs of the receivers holding the file,
+	// e.g. http://10.0.0.5:9091.
+	Sources []string
+	// Hash is the SHA-256 content hash of the file.
+	Hash string
+	// Output is the path the file is written to; empty means the file's
+	// name in the current directory. It is assembled at Output+".partial"
+	// and renamed once verified.
+	Output string
+	// ChunkSize is the size of the ranges requested; zero means
+	// DefaultChunkSize.
+	ChunkSize int64
+	// PerSource is the number of chunks requested from each source at a
+	// time; zero means DefaultPerSource.
+	PerSource int
+	// MaxFailures is how many chunks in a row may fail from one source
+	// before it is dropped; zero means DefaultMaxFailures.
+	MaxFailures int
+	// Client makes the requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// SourceStats reports what one source contributed.
+type SourceStats struct {
+	URL    string `json:"url"`
+	Chunks int    `json:"chunks"`
+	Bytes  int64  `json:"bytes"`
+	// Failures counts chunks that could not be fetched from the source or
+	// did not match their hash.
+	Failures int `json:"failures"`
+	// Dropped is set if the source failed too often and was not used for
+	// the rest of the fetch; Error is its last failure.
+	Dropped bool   `json:"dropped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Result reports a completed fetch.
+type Result struct {
+	File     models.FileMetadata `json:"file"`
+	Path     string              `json:"path"`
+	Duration time.Duration       `json:"duration_ns"`
+	Sources  []SourceStats       `json:"sources"`
+}
+
+// Rate returns the throughput of the fetch in bytes per second.
+func (r *Result) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.File.Size) / r.Duration.Seconds()
+}
+
+// source is one receiver a fetch takes chunks from.
+type source struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	stats   SourceStats
+	inARow  int
+	dropped bool
+}
+
+// Fetch downloads the file opts.Hash from opts.Sources in parallel. The
+// chunk manifest is taken from the first source that serves one. A chunk
+// that fails or does not match its hash is put back for any source to
+// fetch again; Fetch fails only if every source has been dropped.
+func Fetch(ctx context.Context, opts Options) (*Result, error) {
+	if len(opts.Sources) == 0 {
+		return nil, errors.New("no sources")
+	}
+	if opts.Hash == "" {
+		return nil, errors.New("no file hash")
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.PerSource <= 0 {
+		opts.PerSource = DefaultPerSource
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = DefaultMaxFailures
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	sources := make([]*source, len(opts.Sources))
+	for i, u := range opts.Sources {
+		sources[i] = &source{url: u, client: client, stats: SourceStats{URL: u}}
+	}
+
+	start := time.Now()
+	var m *Manifest
+	var errs []error
+	for _, s := range sources {
+		var err error
+		if m, err = s.manifest(ctx, opts.Hash, opts.ChunkSize); err == nil {
+			break
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.url, err))
+	}
+	if m == nil {
+		return nil, fmt.Errorf("no source serves a manifest: %w", errors.Join(errs...))
+	}
+	log.Printf("Fetching %s (%s, %d chunks) from %d sources", m.File.Name, utils.HumanBytes(m.File.Size), len(m.Chunks), len(sources))
+
+	if opts.Output == "" {
+		opts.Output = filepath.Base(m.File.Name)
+		if opts.Output == "." || opts.Output == ".." || opts.Output == string(filepath.Separator) {
+			opts.Output = m.File.Hash
+		}
+	}
+	partial := opts.Output + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(partial) // no-op once renamed
+	if err := out.Truncate(m.File.Size); err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	todo := make(chan int, len(m.Chunks))
+	for i := range m.Chunks {
+		todo <- i
+	}
+	var remaining atomic.Int64
+	remaining.Store(int64(len(m.Chunks)))
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if len(m.Chunks) == 0 {
+		cancel() // an empty file: nothing to fetch
+	}
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		for range opts.PerSource {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.work(wctx, m, out, todo, &remaining, opts.MaxFailures, cancel)
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := &Result{File: m.File, Path: opts.Output}
+	for _, s := range sources {
+		res.Sources = append(res.Sources, s.stats)
+	}
+	if n := remaining.Load(); n > 0 {
+		return res, fmt.Errorf("all sources failed with %d of %d chunks missing", n, len(m.Chunks))
+	}
+
+	hash, err := utils.HashFileSHA256(partial)
+	if err != nil {
+		return nil, err
+	}
+	if hash != m.File.Hash {
+		return res, fmt.Errorf("assembled file has sha256 %s, want %s", hash, m.File.Hash)
+	}
+	if err := os.Rename(partial, opts.Output); err != nil {
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// work takes chunks from todo and fetches them from s into out until none
+// remain, ctx is done or s is dropped. The worker completing the last chunk
+// calls done.
+func (s *source) work(ctx context.Context, m *Manifest, out io.WriterAt, todo chan int, remaining *atomic.Int64, maxFailures int, done context.CancelFunc) {
+	for {
+		var i int
+		select {
+		case <-ctx.Done():
+			return
+		case i = <-todo:
+		}
+		if s.isDropped() {
+			todo <- i
+			return
+		}
+		chunk := m.Chunks[i]
+		err := s.fetchChunk(ctx, m.File.Hash, chunk, out)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			todo <- i
+			if s.fail(err, maxFailures) {
+				log.Printf("Dropping source %s after %d failures in a row: %v", s.url, maxFailures, err)
+				return
+			}
+			continue
+		}
+		s.succeed(chunk.Size)
+		if remaining.Add(-1) == 0 {
+			done()
+			return
+		}
+	}
+}
+
+// fetchChunk requests chunk's byte range of the file hash from s, checks it
+// against the chunk's hash and writes it to out.
+func (s *source) fetchChunk(ctx context.Context, hash string, chunk *models.ChunkMetadata, out io.WriterAt) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/api/v1/files/"+url.PathEscape(hash)+"/data", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", chunk.Offset, chunk.Offset+chunk.Size-1))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("chunk at %d: unexpected status: %s", chunk.Offset, resp.Status)
+	}
+	buf := make([]byte, chunk.Size)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("chunk at %d: %w", chunk.Offset, err)
+	}
+	if got := utils.HashBytesSHA256(buf); got != chunk.SHA256 {
+		return fmt.Errorf("chunk at %d has sha256 %s, want %s", chunk.Offset, got, chunk.SHA256)
+	}
+	_, err = out.WriteAt(buf, chunk.Offset)
+	return err
+}
+
+// manifest requests the chunk manifest of the file hash from s.
+func (s *source) manifest(ctx context.Context, hash string, chunkSize int64) (*Manifest, error) {
+	u := s.url + "/api/v1/files/" + url.PathEscape(hash) + "/chunks?chunk_size=" + strconv.FormatInt(chunkSize, 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var m Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if err := m.validate(hash); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *source) isDropped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *source) succeed(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Chunks++
+	s.stats.Bytes += n
+	s.inARow = 0
+}
+
+// fail records a failed chunk and reports whether s is now dropped.
+func (s *source) fail(err error, maxFailures int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Failures++
+	s.stats.Error = err.Error()
+	s.inARow++
+	if s.inARow >= maxFailures && !s.dropped {
+		s.dropped = true
+		s.stats.Dropped = true
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// testSource serves data as a receiver's file API does, flipping a byte of
+// every range it serves if corrupt is set.
+func testSource(t *testing.T, data []byte, corrupt bool) *httptest.Server {
+	t.Helper()
+	file := models.FileMetadata{Name: "blob.bin", Size: int64(len(data)), Hash: utils.HashBytesSHA256(data)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/chunks"):
+			cs, _ := strconv.ParseInt(r.URL.Query().Get("chunk_size"), 10, 64)
+			m, err := NewManifest(bytes.NewReader(data), file, cs)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(m)
+		case strings.HasSuffix(r.URL.Path, "/data"):
+			body := data
+			if corrupt {
+				body = bytes.Clone(data)
+				for i := range body {
+					body[i] ^= 0xff
+				}
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestFetchFromSeveralSources(t *testing.T) {
+	data := testData(3*MinChunkSize + 1234)
+	good1, good2, bad := testSource(t, data, false), testSource(t, data, false), testSource(t, data, true)
+	out := filepath.Join(t.TempDir(), "out.bin")
+
+	res, err := Fetch(context.Background(), Options{
+		Sources:   []string{good1.URL, bad.URL, good2.URL},
+		Hash:      utils.HashBytesSHA256(data),
+		Output:    out,
+		ChunkSize: MinChunkSize,
+		PerSource: 1,
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("fetched file differs from the source")
+	}
+	var total int64
+	for _, s := range res.Sources {
+		total += s.Bytes
+	}
+	if total != int64(len(data)) {
+		t.Errorf("sources contributed %d bytes, want %d", total, len(data))
+	}
+	if s := res.Sources[1]; s.Chunks != 0 || s.Bytes != 0 {
+		t.Errorf("corrupting source contributed: %+v", s)
+	}
+	if _, err := os.Stat(out + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestFetchFailsWhenAllSourcesFail(t *testing.T) {
+	data := testData(2 * MinChunkSize)
+	bad := testSource(t, data, true)
+	out := filepath.Join(t.TempDir(), "out.bin")
+
+	res, err := Fetch(context.Background(), Options{Sources: []string{bad.URL}, Hash: utils.HashBytesSHA256(data), Output: out, ChunkSize: MinChunkSize})
+	if err == nil {
+		t.Fatal("Fetch succeeded from a corrupting source")
+	}
+	if res == nil || !res.Sources[0].Dropped {
+		t.Errorf("source not dropped: %+v", res)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output written despite failure: %v", err)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	data := testData(2*MinChunkSize + 10)
+	file := models.FileMetadata{Name: "blob.bin", Size: int64(len(data)), Hash: utils.HashBytesSHA256(data)}
+	m, err := NewManifest(bytes.NewReader(data), file, MinChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(m.Chunks))
+	}
+	if err := m.validate(file.Hash); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	if err := m.validate("other"); err == nil {
+		t.Error("manifest accepted for another hash")
+	}
+	m.Chunks = m.Chunks[:2]
+	if err := m.validate(file.Hash); err == nil {
+		t.Error("manifest accepted with a chunk missing")
+	}
+}
@@ -0,0 +1,283 @@
+// Package genfile generates deterministic test files of arbitrary size with
+// selectable compressibility, so performance reports and bug scenarios can be
+// reproduced byte for byte from a profile, a size and a seed.
+package genfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BlockSize is the unit content is generated in. Each block depends only on
+// the seed and its index, so any range can be produced without generating
+// what precedes it.
+const BlockSize = 64 * 1024
+
+// Profile selects what generated content looks like to a compressor.
+type Profile string
+
+const (
+	// ProfileRandom is uniformly random and incompressible.
+	ProfileRandom Profile = "random"
+	// ProfileText is lines of words from a small vocabulary and compresses
+	// roughly as well as log files or source code.
+	ProfileText Profile = "text"
+	// ProfileMedia is mostly random with frame headers and stuffing
+	// bytes, like already-compressed audio or video containers.
+	ProfileMedia Profile = "media"
+	// ProfilePattern repeats Options.Pattern and compresses almost entirely.
+	ProfilePattern Profile = "pattern"
+	// ProfileZero is all zero bytes, written as a fully sparse file.
+	ProfileZero Profile = "zero"
+)
+
+// Profiles lists the supported profiles.
+var Profiles = []Profile{ProfileRandom, ProfileText, ProfileMedia, ProfilePattern, ProfileZero}
+
+// DefaultPattern is repeated by ProfilePattern when no pattern is given.
+const DefaultPattern = "trackshift"
+
+// mediaFrame is the spacing of the frame headers in ProfileMedia content.
+const mediaFrame = 4096
+
+// mediaStuffing is the length of the padding ending each media frame.
+const mediaStuffing = 192
+
+// holeSalt separates the stream deciding which blocks are holes from the
+// content streams, so changing HoleRatio leaves the other blocks unchanged.
+const holeSalt = 0x686f6c65
+
+var words = strings.Fields(`the of and to in is for on that with as by at from
+	chunk session relay receiver sender packet frame stream window ack retry
+	timeout offset hash block file buffer queue worker route network latency
+	bandwidth transfer resume checkpoint error status request response data`)
+
+// Options configures a Generator.
+type Options struct {
+	// Size is the length of the generated content in bytes.
+	Size int64
+	// Seed selects the content; the same options always produce the same
+	// bytes.
+	Seed uint64
+	// Profile selects the kind of content; empty means ProfileRandom.
+	Profile Profile
+	// Pattern is repeated by ProfilePattern; empty means DefaultPattern.
+	Pattern string
+	// HoleRatio is the fraction of blocks, chosen by the seed, that are all
+	// zeros. WriteFile leaves them as holes in the output file.
+	HoleRatio float64
+}
+
+// Generator produces the content described by its Options. It is safe for
+// concurrent use.
+type Generator struct {
+	opts Options
+}
+
+// New validates opts and returns a Generator for them.
+func New(opts Options) (*Generator, error) {
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("invalid size %d", opts.Size)
+	}
+	if opts.Profile == "" {
+		opts.Profile = ProfileRandom
+	}
+	known := false
+	for _, p := range Profiles {
+		known = known || p == opts.Profile
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown profile %q", opts.Profile)
+	}
+	if opts.Pattern == "" {
+		opts.Pattern = DefaultPattern
+	}
+	if opts.HoleRatio < 0 || opts.HoleRatio > 1 {
+		return nil, fmt.Errorf("hole ratio %v out of range [0,1]", opts.HoleRatio)
+	}
+	return &Generator{opts: opts}, nil
+}
+
+// Size returns the length of the generated content.
+func (g *Generator) Size() int64 { return g.opts.Size }
+
+// ReadAt implements io.ReaderAt over the generated content.
+func (g *Generator) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= g.opts.Size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if rem := g.opts.Size - off; int64(want) > rem {
+		want = int(rem)
+	}
+	var block [BlockSize]byte
+	n := 0
+	for n < want {
+		pos := off + int64(n)
+		idx := pos / BlockSize
+		if pos%BlockSize == 0 && want-n >= BlockSize {
+			g.fill(p[n:n+BlockSize], idx)
+			n += BlockSize
+			continue
+		}
+		g.fill(block[:], idx)
+		n += copy(p[n:want], block[pos%BlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// NewReader returns a reader over the whole generated content.
+func (g *Generator) NewReader() io.Reader {
+	return io.NewSectionReader(g, 0, g.opts.Size)
+}
+
+// IsHole reports whether block idx is all zeros and may be left unwritten.
+func (g *Generator) IsHole(idx int64) bool {
+	if g.opts.Profile == ProfileZero {
+		return true
+	}
+	if g.opts.HoleRatio == 0 {
+		return false
+	}
+	r := rand.New(rand.NewPCG(g.opts.Seed^holeSalt, uint64(idx)))
+	return r.Float64() < g.opts.HoleRatio
+}
+
+// WriteFile writes the generated content to path, truncating it first.
+// Hole blocks are skipped rather than written, so on filesystems that
+// support it the result is sparse.
+func (g *Generator) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := g.writeTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (g *Generator) writeTo(f *os.File) error {
+	buf := make([]byte, BlockSize)
+	for off := int64(0); off < g.opts.Size; off += BlockSize {
+		n := int64(BlockSize)
+		if rem := g.opts.Size - off; rem < n {
+			n = rem
+		}
+		if g.IsHole(off / BlockSize) {
+			continue
+		}
+		g.fill(buf, off/BlockSize)
+		if _, err := f.WriteAt(buf[:n], off); err != nil {
+			return err
+		}
+	}
+	// Extends the file over trailing holes.
+	return f.Truncate(g.opts.Size)
+}
+
+// fill writes the content of block idx into b, which holds BlockSize bytes.
+func (g *Generator) fill(b []byte, idx int64) {
+	if g.IsHole(idx) {
+		clear(b)
+		return
+	}
+	r := rand.New(rand.NewPCG(g.opts.Seed, uint64(idx)))
+	switch g.opts.Profile {
+	case ProfileRandom:
+		fillRandom(b, r)
+	case ProfileText:
+		fillText(b, r)
+	case ProfileMedia:
+		fillRandom(b, r)
+		for f := 0; f+mediaFrame <= len(b); f += mediaFrame {
+			hdr := b[f : f+16]
+			copy(hdr, "\x00\x00\x01\xbaTSFRAME")
+			binary.BigEndian.PutUint32(hdr[12:], uint32(idx*BlockSize/mediaFrame)+uint32(f/mediaFrame))
+			// Stuffing bytes, as in MPEG-TS, leave a few percent for a
+			// compressor.
+			stuff := b[f+mediaFrame-mediaStuffing : f+mediaFrame]
+			for i := range stuff {
+				stuff[i] = 0xff
+			}
+		}
+	case ProfilePattern:
+		pat := g.opts.Pattern
+		start := int((idx * BlockSize) % int64(len(pat)))
+		for i := range b {
+			b[i] = pat[(start+i)%len(pat)]
+		}
+	}
+}
+
+func fillRandom(b []byte, r *rand.Rand) {
+	for i := 0; i < len(b); i += 8 {
+		var w [8]byte
+		binary.LittleEndian.PutUint64(w[:], r.Uint64())
+		copy(b[i:], w[:])
+	}
+}
+
+func fillText(b []byte, r *rand.Rand) {
+	n, line := 0, 0
+	for n < len(b) {
+		w := words[r.IntN(len(words))]
+		n += copy(b[n:], w)
+		line += len(w) + 1
+		if n >= len(b) {
+			break
+		}
+		if line > 60+r.IntN(20) {
+			b[n], line = '\n', 0
+		} else {
+			b[n] = ' '
+		}
+		n++
+	}
+}
+
+// ParseSize parses a size such as "10GB", "512KiB" or "1.5T" and returns it
+// in bytes. Units are powers of 1024, matching utils.HumanBytes; a bare
+// number is bytes.
+func ParseSize(s string) (int64, error) {
+	in := strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(in, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := in, ""
+	if i >= 0 {
+		num, unit = in[:i], strings.TrimSpace(in[i:])
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	var mult float64
+	switch unit {
+	case "", "b":
+		mult = 1
+	case "k", "kb", "kib":
+		mult = 1 << 10
+	case "m", "mb", "mib":
+		mult = 1 << 20
+	case "g", "gb", "gib":
+		mult = 1 << 30
+	case "t", "tb", "tib":
+		mult = 1 << 40
+	default:
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	return int64(v * mult), nil
+}
@@ -0,0 +1,130 @@
+package genfile
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func generate(t *testing.T, opts Options) []byte {
+	t.Helper()
+	g, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	b, err := io.ReadAll(g.NewReader())
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if int64(len(b)) != opts.Size {
+		t.Fatalf("generated %d bytes, want %d", len(b), opts.Size)
+	}
+	return b
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, p := range Profiles {
+		opts := Options{Size: 3*BlockSize + 123, Seed: 7, Profile: p, HoleRatio: 0.3}
+		a, b := generate(t, opts), generate(t, opts)
+		if !bytes.Equal(a, b) {
+			t.Fatalf("%s: same options produced different content", p)
+		}
+	}
+	a := generate(t, Options{Size: BlockSize, Seed: 1})
+	b := generate(t, Options{Size: BlockSize, Seed: 2})
+	if bytes.Equal(a, b) {
+		t.Fatalf("different seeds produced the same content")
+	}
+}
+
+func TestReadAtMatchesSequential(t *testing.T) {
+	opts := Options{Size: 2*BlockSize + 999, Seed: 3, Profile: ProfileText}
+	want := generate(t, opts)
+	g, _ := New(opts)
+	for _, r := range []struct{ off, n int64 }{{0, 10}, {BlockSize - 5, 10}, {BlockSize, BlockSize}, {opts.Size - 20, 20}} {
+		got := make([]byte, r.n)
+		if _, err := g.ReadAt(got, r.off); err != nil {
+			t.Fatalf("ReadAt(%d): %v", r.off, err)
+		}
+		if !bytes.Equal(got, want[r.off:r.off+r.n]) {
+			t.Fatalf("ReadAt(%d, %d) differs from sequential content", r.off, r.n)
+		}
+	}
+	if n, err := g.ReadAt(make([]byte, 100), opts.Size-10); n != 10 || err != io.EOF {
+		t.Fatalf("short ReadAt = %d, %v; want 10, EOF", n, err)
+	}
+}
+
+func TestCompressibility(t *testing.T) {
+	ratio := func(p Profile) float64 {
+		b := generate(t, Options{Size: 4 * BlockSize, Seed: 1, Profile: p})
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(b)
+		w.Close()
+		return float64(len(b)) / float64(buf.Len())
+	}
+	random, media, text, pattern := ratio(ProfileRandom), ratio(ProfileMedia), ratio(ProfileText), ratio(ProfilePattern)
+	if random > 1.01 {
+		t.Fatalf("random compressed %.2fx", random)
+	}
+	if !(random < media && media < text && text < pattern) {
+		t.Fatalf("ratios not ordered: random %.2f media %.2f text %.2f pattern %.2f", random, media, text, pattern)
+	}
+	if text < 2 {
+		t.Fatalf("text compressed only %.2fx", text)
+	}
+}
+
+func TestWriteFileSparse(t *testing.T) {
+	opts := Options{Size: 10*BlockSize + 17, Seed: 5, Profile: ProfileRandom, HoleRatio: 0.5}
+	want := generate(t, opts)
+	g, _ := New(opts)
+	path := filepath.Join(t.TempDir(), "out.bin")
+	if err := g.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("written file differs from generated content")
+	}
+
+	zero, _ := New(Options{Size: 5 * BlockSize, Profile: ProfileZero})
+	if err := zero.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile zero: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 5*BlockSize {
+		t.Fatalf("zero file size = %v, %v", fi.Size(), err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":      0,
+		"4096":   4096,
+		"512KiB": 512 << 10,
+		"10GB":   10 << 30,
+		"1.5t":   3 << 39,
+		"2 mb":   2 << 20,
+	}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		if err != nil {
+			t.Fatalf("ParseSize(%q): %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("ParseSize(%q) = %d, want %d", in, got, want)
+		}
+	}
+	for _, bad := range []string{"", "big", "-1GB", "3 parsecs"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Fatalf("ParseSize(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package hooks tells other programs about sessions a receiver finished:
+// a shell command is run, or a webhook is posted to, with the session as
+// JSON, so ingestion pipelines need not poll the output directory.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// DefaultTimeout bounds a hook's run, including a webhook's retries.
+const DefaultTimeout = time.Minute
+
+// webhookAttempts is how many times a webhook is posted before giving up;
+// only network errors and 5xx answers are retried.
+const webhookAttempts = 3
+
+// Event says how a session ended.
+type Event string
+
+const (
+	// Complete sessions have their data delivered in full.
+	Complete Event = "complete"
+	// Failure sessions ended without delivering their data.
+	Failure Event = "failure"
+)
+
+// Payload is the JSON a hook receives.
+type Payload struct {
+	Event Event     `json:"event"`
+	Time  time.Time `json:"time"`
+	// Session is the receiver's session, without its chunk list.
+	Session *models.TransferSession `json:"session"`
+	// Output is where the data was delivered: the file or directory, or
+	// the chunk index for a receiver keeping chunks.
+	Output string `json:"output,omitempty"`
+	// Verified is set once the whole file matched the sender's hash.
+	Verified bool `json:"verified"`
+	// Error says why the session failed.
+	Error string `json:"error,omitempty"`
+	// Peer is the address the session arrived from.
+	Peer string `json:"peer,omitempty"`
+}
+
+// NewPayload returns the payload for sess, dropping its chunks, which can
+// run to many thousands and say nothing about the outcome.
+func NewPayload(event Event, sess *models.TransferSession) Payload {
+	s := *sess
+	s.Chunks = nil
+	return Payload{Event: event, Time: time.Now(), Session: &s}
+}
+
+// Hook is a command or webhook run when a session ends.
+type Hook struct {
+	// Command is run through the shell with the payload on its standard
+	// input; URL is posted the payload instead. One of them is set.
+	Command string
+	URL     string
+	// Timeout bounds a run (DefaultTimeout if zero).
+	Timeout time.Duration
+
+	client *http.Client
+}
+
+// Parse returns the hook for spec: a webhook for http and https URLs, a
+// shell command otherwise. An empty spec yields nil, which runs nothing.
+func Parse(spec string, timeout time.Duration) *Hook {
+	if spec == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &Hook{URL: spec, Timeout: timeout, client: &http.Client{}}
+	}
+	return &Hook{Command: spec, Timeout: timeout}
+}
+
+// String names the hook for logs.
+func (h *Hook) String() string {
+	if h.URL != "" {
+		return "webhook " + h.URL
+	}
+	return "command " + strconv.Quote(h.Command)
+}
+
+// Run runs the hook for p and waits for it. A nil *Hook does nothing.
+func (h *Hook) Run(p Payload) error {
+	if h == nil {
+		return nil
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("encode hook payload: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	if h.URL != "" {
+		return h.post(ctx, body)
+	}
+	return h.exec(ctx, body, p)
+}
+
+// exec runs the command with the payload on its standard input. The main
+// fields are also passed in TRACKSHIFT_* environment variables for short
+// scripts, named as for cold-storage handoff commands.
+func (h *Hook) exec(ctx context.Context, body []byte, p Payload) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TRACKSHIFT_EVENT="+string(p.Event),
+		"TRACKSHIFT_SESSION="+p.Session.ID,
+		"TRACKSHIFT_FILE="+p.Session.File.Name,
+		"TRACKSHIFT_SIZE="+strconv.FormatInt(p.Session.File.Size, 10),
+		"TRACKSHIFT_SHA256="+p.Session.File.Hash,
+		"TRACKSHIFT_OUTPUT="+p.Output,
+		"TRACKSHIFT_ERROR="+p.Error,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// post sends the payload to the webhook, retrying failures that may be
+// transient with a short backoff.
+func (h *Hook) post(ctx context.Context, body []byte) error {
+	var err error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+			}
+		}
+		var retry bool
+		if retry, err = h.postOnce(ctx, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (h *Hook) postOnce(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
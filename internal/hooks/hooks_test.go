@@ -0,0 +1,100 @@
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func testSession() *models.TransferSession {
+	return &models.TransferSession{
+		ID:     "s1",
+		File:   models.FileMetadata{Name: "data.bin", Size: 42, Hash: "abc"},
+		Status: models.SessionStatusCompleted,
+		Chunks: map[string]*models.ChunkMetadata{"0": {ID: "0"}},
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payload.json")
+	h := Parse(`cat > "$DEST"; echo "$TRACKSHIFT_EVENT $TRACKSHIFT_SESSION $TRACKSHIFT_OUTPUT" >> "$DEST.env"`, time.Minute)
+	t.Setenv("DEST", out)
+	p := NewPayload(Complete, testSession())
+	p.Output, p.Verified = "/data/data.bin", true
+	if err := h.Run(p); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Payload
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if got.Event != Complete || got.Session.ID != "s1" || got.Session.Chunks != nil || !got.Verified {
+		t.Fatalf("payload = %+v", got)
+	}
+	env, _ := os.ReadFile(out + ".env")
+	if strings.TrimSpace(string(env)) != "complete s1 /data/data.bin" {
+		t.Fatalf("environment = %q", env)
+	}
+
+	if err := Parse("echo nope >&2; exit 3", time.Minute).Run(p); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("failing command: %v", err)
+	}
+	if err := Parse("sleep 5", 50*time.Millisecond).Run(p); err == nil {
+		t.Fatal("command outlived its timeout")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var calls atomic.Int32
+	var got Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	p := NewPayload(Failure, testSession())
+	p.Error = "connection closed"
+	if err := Parse(srv.URL, time.Minute).Run(p); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if calls.Load() != 2 || got.Event != Failure || got.Error != "connection closed" {
+		t.Fatalf("%d calls, payload %+v", calls.Load(), got)
+	}
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	calls.Store(0)
+	if err := Parse(rejecting.URL, time.Minute).Run(p); err == nil || calls.Load() != 1 {
+		t.Fatalf("client error retried or accepted: %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestNilHook(t *testing.T) {
+	if h := Parse("", 0); h != nil {
+		t.Fatalf("Parse(\"\") = %v", h)
+	}
+	var h *Hook
+	if err := h.Run(NewPayload(Complete, testSession())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package ipfilter restricts listeners to peers whose addresses match CIDR
+// allow and deny lists. The lists can be replaced while listeners are
+// running, e.g. through an admin API.
+package ipfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// Rules are the lists a Filter enforces. Entries are CIDR prefixes such as
+// "10.0.0.0/8" or single addresses. A peer matching a Deny entry is refused;
+// otherwise it is admitted if Allow is empty or it matches an Allow entry.
+type Rules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// compiled is a parsed set of rules.
+type compiled struct {
+	rules       Rules
+	allow, deny []netip.Prefix
+}
+
+// Filter admits or refuses peers by address. A nil *Filter admits everyone.
+// It is safe for concurrent use.
+type Filter struct {
+	c atomic.Pointer[compiled]
+}
+
+// New returns a filter enforcing r.
+func New(r Rules) (*Filter, error) {
+	f := &Filter{}
+	if err := f.Set(r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set replaces the filter's rules. Peers already admitted, such as open TCP
+// connections, are not affected. On error the previous rules stay in force.
+func (f *Filter) Set(r Rules) error {
+	c := &compiled{rules: Rules{Allow: []string{}, Deny: []string{}}}
+	for _, s := range r.Allow {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("allow: %w", err)
+		}
+		c.allow = append(c.allow, p)
+		c.rules.Allow = append(c.rules.Allow, p.String())
+	}
+	for _, s := range r.Deny {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("deny: %w", err)
+		}
+		c.deny = append(c.deny, p)
+		c.rules.Deny = append(c.rules.Deny, p.String())
+	}
+	f.c.Store(c)
+	return nil
+}
+
+// Rules returns the rules in force, normalized.
+func (f *Filter) Rules() Rules {
+	if f == nil {
+		return Rules{Allow: []string{}, Deny: []string{}}
+	}
+	return f.c.Load().rules
+}
+
+// parsePrefix parses a CIDR prefix or a single address. IPv6 addresses may
+// be bracketed, as in host:port notation.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		if addr, bits, ok := strings.Cut(s[1:], "]"); ok {
+			s = addr + bits
+		}
+	}
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// ParseList splits a comma-separated list of prefixes, as taken by command
+// line flags, dropping empty entries.
+func ParseList(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// AllowedIP reports whether the filter admits ip.
+func (f *Filter) AllowedIP(ip netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	c := f.c.Load()
+	ip = ip.Unmap()
+	for _, p := range c.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(c.allow) == 0 {
+		return true
+	}
+	for _, p := range c.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the filter admits the peer at addr. Addresses
+// other than TCP and UDP ones, e.g. of in-memory pipes, are admitted.
+func (f *Filter) Allowed(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return true
+	}
+	nip, ok := netip.AddrFromSlice(ip)
+	return ok && f.AllowedIP(nip)
+}
+
+// Listener returns ln with connections from refused peers closed as they
+// are accepted. A nil filter returns ln itself.
+func (f *Filter) Listener(ln net.Listener) net.Listener {
+	if f == nil {
+		return ln
+	}
+	return &listener{Listener: ln, f: f}
+}
+
+type listener struct {
+	net.Listener
+	f *Filter
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.f.Allowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		log.Printf("ipfilter: refused connection from %s", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// Handler serves the filter's rules for hot reloading; f must not be nil:
+//
+//	GET /  the rules in force
+//	PUT /  body {"allow": [...], "deny": [...]} replaces them
+func (f *Filter) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var rules Rules
+			if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := f.Set(rules); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			rules = f.Rules()
+			log.Printf("ipfilter: rules replaced (allow %v, deny %v)", rules.Allow, rules.Deny)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, f.Rules())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON error: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowedIP(t *testing.T) {
+	f, err := New(Rules{Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"}, Deny: []string{"10.9.0.0/16"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":         true,
+		"10.9.1.1":         false, // deny wins over allow
+		"192.0.2.7":        true,
+		"192.0.2.8":        false,
+		"::ffff:10.1.2.3":  true, // IPv4-mapped addresses match IPv4 rules
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"203.0.113.1":      false,
+		"::ffff:10.9.0.10": false,
+	} {
+		if got := f.AllowedIP(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("AllowedIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	// Without an allow list everyone not denied is admitted, and a nil
+	// filter admits everyone.
+	open, _ := New(Rules{Deny: []string{"203.0.113.0/24"}})
+	if !open.AllowedIP(netip.MustParseAddr("198.51.100.1")) || open.AllowedIP(netip.MustParseAddr("203.0.113.9")) {
+		t.Error("deny-only filter")
+	}
+	// Bracketed IPv6 literals are accepted as in host:port notation.
+	v6, err := New(Rules{Allow: []string{"[2001:db8::7]", "[2001:db8:1::]/48"}})
+	if err != nil {
+		t.Fatalf("New with bracketed addresses: %v", err)
+	}
+	if !v6.AllowedIP(netip.MustParseAddr("2001:db8::7")) || !v6.AllowedIP(netip.MustParseAddr("2001:db8:1::9")) {
+		t.Error("bracketed IPv6 rules")
+	}
+	var none *Filter
+	if !none.Allowed(&net.UDPAddr{IP: net.ParseIP("203.0.113.9")}) {
+		t.Error("nil filter refused a peer")
+	}
+}
+
+func TestSetKeepsRulesOnError(t *testing.T) {
+	f, _ := New(Rules{Allow: []string{"10.0.0.1/8"}})
+	if err := f.Set(Rules{Deny: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("Set accepted an invalid prefix")
+	}
+	if got := f.Rules(); len(got.Allow) != 1 || got.Allow[0] != "10.0.0.0/8" {
+		t.Fatalf("rules after failed Set = %+v", got)
+	}
+}
+
+func TestListenerRefusesPeers(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := New(Rules{Deny: []string{"127.0.0.0/8"}})
+	ln := f.Listener(inner)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	// The refused connection is closed by the listener.
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("refused connection was not closed")
+	}
+	c.Close()
+
+	// Hot reload admits the next one.
+	if err := f.Set(Rules{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err = net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case a := <-accepted:
+		a.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("allowed connection not accepted")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	f, _ := New(Rules{})
+	srv := httptest.NewServer(f.Handler())
+	defer srv.Close()
+
+	put := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := put(`{"allow": ["10.0.0.0/8"], "deny": ["10.1.0.0/16"]}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT status %s", resp.Status)
+	}
+	if f.AllowedIP(netip.MustParseAddr("192.0.2.1")) || !f.AllowedIP(netip.MustParseAddr("10.2.0.1")) {
+		t.Fatal("rules not applied")
+	}
+	if resp := put(`{"allow": ["bogus"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("PUT of invalid rules: status %s", resp.Status)
+	}
+	if resp, err := http.Post(srv.URL, "application/json", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package manifest maps a directory tree onto the single byte stream that a
+// transfer session carries, and recreates the tree on the receiving side.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/internal/xattr"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Scan walks root and returns a manifest of its directories and regular
+// files without hashing their contents. Symlinks and other special files are
+// skipped.
+func Scan(root string) (*models.Manifest, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	m := &models.Manifest{Root: filepath.Base(abs)}
+	var offset int64
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := models.ManifestEntry{
+			Path: filepath.ToSlash(rel),
+			Mode: uint32(info.Mode().Perm()),
+		}
+		switch {
+		case d.IsDir():
+			entry.Dir = true
+		case info.Mode().IsRegular():
+			entry.Size = info.Size()
+			entry.Offset = offset
+			offset += entry.Size
+		default:
+			return nil
+		}
+		m.Entries = append(m.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return m, nil
+}
+
+// Build is like Scan but also hashes every file, and returns the hex-encoded
+// SHA-256 of the concatenated file data.
+func Build(root string) (*models.Manifest, string, error) {
+	m, err := Scan(root)
+	if err != nil {
+		return nil, "", err
+	}
+	total := sha256.New()
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		if e.Dir {
+			continue
+		}
+		if e.Hash, err = hashInto(filepath.Join(root, filepath.FromSlash(e.Path)), total, e.Size); err != nil {
+			return nil, "", err
+		}
+	}
+	return m, hex.EncodeToString(total.Sum(nil)), nil
+}
+
+// hashInto returns the SHA-256 of the first size bytes of the file at p
+// while also feeding them to total. Files that shrank since the scan are
+// reported as errors.
+func hashInto(p string, total io.Writer, size int64) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(h, total), f, size); err != nil {
+		return "", fmt.Errorf("hash %s: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Reader exposes the files of a manifest as one io.ReaderAt over the session
+// stream. It keeps at most one file open at a time and is safe for
+// concurrent use.
+type Reader struct {
+	root  string
+	files []models.ManifestEntry
+
+	mu      sync.Mutex
+	current int // index into files of the open file, or -1
+	f       *os.File
+}
+
+// NewReader returns a Reader over the files listed in m, rooted at root.
+func NewReader(root string, m *models.Manifest) *Reader {
+	r := &Reader{root: root, current: -1}
+	for _, e := range m.Entries {
+		if !e.Dir && e.Size > 0 {
+			r.files = append(r.files, e)
+		}
+	}
+	return r
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i := sort.Search(len(r.files), func(i int) bool {
+			return r.files[i].Offset+r.files[i].Size > pos
+		})
+		if i == len(r.files) {
+			return n, io.EOF
+		}
+		f, err := r.open(i)
+		if err != nil {
+			return n, err
+		}
+		e := r.files[i]
+		want := len(p) - n
+		if left := e.Offset + e.Size - pos; int64(want) > left {
+			want = int(left)
+		}
+		m, err := f.ReadAt(p[n:n+want], pos-e.Offset)
+		n += m
+		if err != nil && !(errors.Is(err, io.EOF) && m == want) {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("%s: file shrank since manifest was built", e.Path)
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// open makes files[i] the current open file.
+func (r *Reader) open(i int) (*os.File, error) {
+	if r.current == i {
+		return r.f, nil
+	}
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+		r.current = -1
+	}
+	f, err := os.Open(filepath.Join(r.root, filepath.FromSlash(r.files[i].Path)))
+	if err != nil {
+		return nil, err
+	}
+	r.f, r.current = f, i
+	return f, nil
+}
+
+// Close closes the currently open file, if any.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f, r.current = nil, -1
+	return err
+}
+
+// ReadXattrs records in m the extended attributes of each entry under root
+// selected by f.
+func ReadXattrs(root string, m *models.Manifest, f xattr.Filter) error {
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		attrs, err := xattr.Read(filepath.Join(root, filepath.FromSlash(e.Path)), f)
+		if err != nil {
+			return err
+		}
+		e.Xattrs = attrs
+	}
+	return nil
+}
+
+// restoreXattrs sets the attributes selected by restore on target. A nil
+// restore leaves attributes alone.
+func restoreXattrs(target string, attrs map[string][]byte, restore *xattr.Filter) error {
+	if restore == nil || len(attrs) == 0 {
+		return nil
+	}
+	return xattr.Write(target, restore.Select(attrs))
+}
+
+// Extract recreates the tree described by m under dest, reading file data in
+// manifest order from src and verifying every file against its hash. If
+// restore is non-nil, the entries' extended attributes selected by it are
+// set as well.
+func Extract(src io.Reader, dest string, m *models.Manifest, restore *xattr.Filter) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	for _, e := range m.Entries {
+		target, err := entryPath(dest, e.Path)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(e.Mode).Perm()
+		if e.Dir {
+			if err := os.MkdirAll(target, mode|0o700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := extractFile(src, target, mode, e, restore); err != nil {
+			return fmt.Errorf("extract %s: %w", e.Path, err)
+		}
+	}
+	// Apply directory modes last so read-only directories can still be filled.
+	for _, e := range m.Entries {
+		if e.Dir {
+			target, _ := entryPath(dest, e.Path)
+			if err := restoreXattrs(target, e.Xattrs, restore); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, os.FileMode(e.Mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func extractFile(src io.Reader, target string, mode os.FileMode, e models.ManifestEntry, restore *xattr.Filter) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(f, h), src, e.Size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != e.Hash {
+		return fmt.Errorf("hash mismatch: expected %s, got %s", e.Hash, got)
+	}
+	if err := restoreXattrs(target, e.Xattrs, restore); err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
+
+// ExtractFile recreates the tree described by m under dest from the session
+// stream stored at streamPath.
+func ExtractFile(streamPath, dest string, m *models.Manifest, restore *xattr.Filter) error {
+	f, err := os.Open(streamPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Extract(f, dest, m, restore)
+}
+
+// entryPath joins a manifest path onto dest, refusing paths that would
+// escape it.
+func entryPath(dest, p string) (string, error) {
+	clean := path.Clean(p)
+	if p == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid manifest path %q", p)
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean)), nil
+}
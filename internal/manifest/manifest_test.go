@@ -0,0 +1,111 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildReadExtractRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"empty":         "",
+		"sub/b.txt":     "bravo bravo",
+		"sub/deep/c.go": "package c\n",
+	}
+	writeTree(t, src, files)
+	if err := os.Mkdir(filepath.Join(src, "emptydir"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	m, hash, err := Build(src)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if m.Root != "data" {
+		t.Fatalf("root = %q", m.Root)
+	}
+	if m.TotalSize() != int64(len("alpha")+len("bravo bravo")+len("package c\n")) {
+		t.Fatalf("total size = %d", m.TotalSize())
+	}
+
+	// Reading the whole stream in odd-sized pieces must match the hash.
+	r := NewReader(src, m)
+	defer r.Close()
+	var stream bytes.Buffer
+	buf := make([]byte, 3)
+	for off := int64(0); off < m.TotalSize(); {
+		n, err := r.ReadAt(buf, off)
+		stream.Write(buf[:n])
+		off += int64(n)
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+	}
+	if sum := sha256.Sum256(stream.Bytes()); hex.EncodeToString(sum[:]) != hash {
+		t.Fatal("stream does not match manifest hash")
+	}
+
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := Extract(bytes.NewReader(stream.Bytes()), dest, m, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Fatalf("%s = %q, want %q", name, got, content)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dest, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if info, err := os.Stat(filepath.Join(dest, "emptydir")); err != nil || !info.IsDir() {
+		t.Fatalf("emptydir not recreated: %v", err)
+	}
+}
+
+func TestExtractRejectsEscapingPaths(t *testing.T) {
+	m := &models.Manifest{Root: "x", Entries: []models.ManifestEntry{{Path: "../evil", Size: 0}}}
+	if err := Extract(bytes.NewReader(nil), t.TempDir(), m, nil); err == nil {
+		t.Fatal("expected error for path escaping destination")
+	}
+}
+
+func TestExtractDetectsCorruption(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "d")
+	writeTree(t, src, map[string]string{"f": "hello"})
+	m, _, err := Build(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(bytes.NewReader([]byte("jello")), t.TempDir(), m, nil); err == nil {
+		t.Fatal("expected hash mismatch")
+	}
+}
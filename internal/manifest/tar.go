@@ -0,0 +1,254 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/internal/xattr"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+const tarBlockSize = 512
+
+// paxXattrPrefix introduces extended attributes in PAX records, as written
+// by GNU and BSD tar.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// tarSegment is a contiguous piece of a generated tar archive: either bytes
+// held in memory (headers, padding, trailer) or a range of a source file.
+type tarSegment struct {
+	off  int64
+	size int64
+	data []byte // in-memory content, nil for file segments
+	path string // source file, for file segments
+}
+
+// TarReader presents a directory as an uncompressed tar archive through
+// io.ReaderAt without staging the archive on disk. Headers are generated up
+// front and file data is read from the tree on demand, so many small files
+// can be chunked as one large stream. It is safe for concurrent use.
+type TarReader struct {
+	segs []tarSegment
+	size int64
+
+	mu   sync.Mutex
+	path string // path of the open file
+	f    *os.File
+}
+
+// NewTarReader lays out a tar archive of the entries in m, rooted at root.
+// Entries are stored under m.Root, as `tar -C parent -c root` would.
+func NewTarReader(root string, m *models.Manifest) (*TarReader, error) {
+	t := &TarReader{}
+	var pending bytes.Buffer
+	flush := func() {
+		if pending.Len() == 0 {
+			return
+		}
+		t.segs = append(t.segs, tarSegment{off: t.size, size: int64(pending.Len()), data: bytes.Clone(pending.Bytes())})
+		t.size += int64(pending.Len())
+		pending.Reset()
+	}
+
+	for _, e := range m.Entries {
+		src := filepath.Join(root, filepath.FromSlash(e.Path))
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		hdr := &tar.Header{
+			Name:    m.Root + "/" + e.Path,
+			Mode:    int64(e.Mode),
+			ModTime: info.ModTime(),
+		}
+		if len(e.Xattrs) > 0 {
+			hdr.Format = tar.FormatPAX
+			hdr.PAXRecords = make(map[string]string, len(e.Xattrs))
+			for name, v := range e.Xattrs {
+				hdr.PAXRecords[paxXattrPrefix+name] = string(v)
+			}
+		}
+		if e.Dir {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		} else {
+			if info.Size() != e.Size {
+				return nil, fmt.Errorf("%s: size changed since scan", e.Path)
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = e.Size
+		}
+		// A fresh writer per entry emits just the header blocks; the data is
+		// spliced in from the source file.
+		if err := tar.NewWriter(&pending).WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("tar header for %s: %w", e.Path, err)
+		}
+		if hdr.Size > 0 {
+			flush()
+			t.segs = append(t.segs, tarSegment{off: t.size, size: hdr.Size, path: src})
+			t.size += hdr.Size
+			if rem := hdr.Size % tarBlockSize; rem != 0 {
+				pending.Write(make([]byte, tarBlockSize-rem))
+			}
+		}
+	}
+	pending.Write(make([]byte, 2*tarBlockSize)) // end-of-archive marker
+	flush()
+	return t, nil
+}
+
+// Size returns the length of the archive.
+func (t *TarReader) Size() int64 { return t.size }
+
+// ReadAt implements io.ReaderAt.
+func (t *TarReader) ReadAt(p []byte, off int64) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i := sort.Search(len(t.segs), func(i int) bool { return t.segs[i].off+t.segs[i].size > pos })
+		if i == len(t.segs) {
+			return n, io.EOF
+		}
+		seg := t.segs[i]
+		want := len(p) - n
+		if left := seg.off + seg.size - pos; int64(want) > left {
+			want = int(left)
+		}
+		if seg.data != nil {
+			n += copy(p[n:n+want], seg.data[pos-seg.off:])
+			continue
+		}
+		f, err := t.open(seg.path)
+		if err != nil {
+			return n, err
+		}
+		m, err := f.ReadAt(p[n:n+want], pos-seg.off)
+		n += m
+		if err != nil && !(errors.Is(err, io.EOF) && m == want) {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("%s: file shrank since archive was laid out", seg.path)
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// open makes path the current open file.
+func (t *TarReader) open(path string) (*os.File, error) {
+	if t.f != nil && t.path == path {
+		return t.f, nil
+	}
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t.f, t.path = f, path
+	return f, nil
+}
+
+// Close closes the currently open file, if any.
+func (t *TarReader) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+// ExtractTar unpacks the directories and regular files of a tar archive
+// under dest. Other entry types are skipped, and entries that would land
+// outside dest are rejected. If restore is non-nil, extended attributes
+// recorded in the archive and selected by it are set on the extracted files.
+func ExtractTar(src io.Reader, dest string, restore *xattr.Filter) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(src)
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		target, err := entryPath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("extract %s: %w", hdr.Name, err)
+			}
+			if err := restoreXattrs(target, tarXattrs(hdr), restore); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+		}
+	}
+	// Apply directory modes last so read-only directories can still be filled.
+	for _, hdr := range dirs {
+		target, _ := entryPath(dest, hdr.Name)
+		if err := restoreXattrs(target, tarXattrs(hdr), restore); err != nil {
+			return err
+		}
+		if err := os.Chmod(target, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarXattrs returns the extended attributes recorded for hdr.
+func tarXattrs(hdr *tar.Header) map[string][]byte {
+	var attrs map[string][]byte
+	for k, v := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(k, paxXattrPrefix); ok {
+			if attrs == nil {
+				attrs = make(map[string][]byte)
+			}
+			attrs[name] = []byte(v)
+		}
+	}
+	return attrs
+}
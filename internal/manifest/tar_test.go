@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/xattr"
+)
+
+func TestTarReaderRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "many")
+	files := map[string]string{
+		"one.txt":         "1",
+		"blocks.bin":      strings.Repeat("x", 2*tarBlockSize), // no padding needed
+		"sub/empty":       "",
+		"sub/two.txt":     "two",
+		"sub/sub/333.txt": strings.Repeat("3", 700),
+	}
+	writeTree(t, src, files)
+
+	m, err := Scan(src)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	tr, err := NewTarReader(src, m)
+	if err != nil {
+		t.Fatalf("NewTarReader: %v", err)
+	}
+	defer tr.Close()
+	if tr.Size()%tarBlockSize != 0 {
+		t.Fatalf("archive size %d is not block aligned", tr.Size())
+	}
+
+	archive, err := io.ReadAll(io.NewSectionReader(tr, 0, tr.Size()))
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+
+	// The generated archive must parse with archive/tar.
+	got := map[string]string{}
+	rd := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("parse archive: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(rd)
+			got[strings.TrimPrefix(hdr.Name, "many/")] = string(data)
+		}
+	}
+	for name, content := range files {
+		if got[name] != content {
+			t.Fatalf("%s: got %q, want %q", name, got[name], content)
+		}
+	}
+
+	dest := t.TempDir()
+	if err := ExtractTar(bytes.NewReader(archive), dest, nil); err != nil {
+		t.Fatalf("ExtractTar: %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dest, "many", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("extracted %s = %q", name, data)
+		}
+	}
+}
+
+func TestExtractTarRejectsEscapingPaths(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Size: 1, Mode: 0o644})
+	_, _ = tw.Write([]byte("x"))
+	_ = tw.Close()
+	if err := ExtractTar(&buf, t.TempDir(), nil); err == nil {
+		t.Fatal("expected error for path escaping destination")
+	}
+}
+
+func TestTarCarriesXattrs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "tagged")
+	writeTree(t, src, map[string]string{"clip.mov": "frames"})
+	if err := xattr.Write(filepath.Join(src, "clip.mov"), map[string][]byte{"user.reel": []byte("A001")}); err != nil {
+		t.Skipf("extended attributes unavailable: %v", err)
+	}
+
+	m, err := Scan(src)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if err := ReadXattrs(src, m, xattr.Filter{Include: []string{"user.*"}}); err != nil {
+		t.Fatalf("ReadXattrs: %v", err)
+	}
+	tr, err := NewTarReader(src, m)
+	if err != nil {
+		t.Fatalf("NewTarReader: %v", err)
+	}
+	defer tr.Close()
+
+	dest := t.TempDir()
+	restore := xattr.Filter{}
+	if err := ExtractTar(io.NewSectionReader(tr, 0, tr.Size()), dest, &restore); err != nil {
+		t.Fatalf("ExtractTar: %v", err)
+	}
+	got, err := xattr.Read(filepath.Join(dest, "tagged", "clip.mov"), xattr.Filter{Include: []string{"user.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got["user.reel"]) != "A001" {
+		t.Fatalf("restored attributes = %v", got)
+	}
+}
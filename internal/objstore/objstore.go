@@ -0,0 +1,68 @@
+// Package objstore stores received files as objects in object storage, part
+// by part as their chunks arrive, so they land there without being staged
+// on the receiver's disk. It also reads remote files to send with range
+// requests, so they need not be staged on the sender's disk either.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksumMismatch is returned when data does not match the hash it was
+// stored under.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Limits of multipart uploads: every part but the last must hold at least
+// MinPartSize bytes, and an object has at most MaxParts parts.
+const (
+	MinPartSize = 5 << 20
+	MaxParts    = 10000
+)
+
+// Backend is object storage received files are written to.
+type Backend interface {
+	// Name identifies the backend in logs, e.g. s3://bucket/prefix.
+	Name() string
+	// Begin starts storing an object of size bytes under key, a
+	// slash-separated path relative to the backend's prefix.
+	Begin(ctx context.Context, key string, size int64) (Upload, error)
+}
+
+// Upload is an object being stored part by part. Parts may be written in
+// any order, and a part written again replaces the earlier one.
+type Upload interface {
+	// WritePart stores the size bytes of the object at offset, read from r,
+	// whose SHA-256 is sha256 (hex). It reads r to its end, even when the
+	// part could not be stored, so a stream carrying the data stays usable.
+	WritePart(ctx context.Context, offset, size int64, sha256 string, r io.Reader) error
+	// SHA256 returns the SHA-256 (hex) of the object once every byte was
+	// written, or "" if its parts arrived out of order and it could not be
+	// hashed as they streamed.
+	SHA256() string
+	// Complete makes the object out of the parts written, which must cover
+	// it, and returns its location.
+	Complete(ctx context.Context) (string, error)
+	// Abort discards the parts written.
+	Abort(ctx context.Context) error
+}
+
+// PartNumber returns the number of the part holding the size bytes at
+// offset of an object of total bytes. Numbers are proportional to offsets,
+// so parts of any size can be numbered as they arrive, in the order they
+// make up the object, as long as each but the last is large enough to get a
+// number of its own.
+func PartNumber(offset, size, total int64) (int, error) {
+	switch {
+	case offset < 0 || size < 0 || offset+size > total:
+		return 0, fmt.Errorf("part [%d, %d) outside object size %d", offset, offset+size, total)
+	case offset+size < total && size < max(MinPartSize, (total+MaxParts-1)/MaxParts):
+		return 0, fmt.Errorf("part of %d bytes at offset %d is too small: all but the last part of a %d-byte object need at least %d bytes",
+			size, offset, total, max(MinPartSize, (total+MaxParts-1)/MaxParts))
+	case total == 0:
+		return 1, nil
+	}
+	return int(offset*MaxParts/total) + 1, nil
+}
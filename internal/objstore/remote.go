@@ -0,0 +1,251 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrObjectChanged is returned when a remote object changes while it is
+// being read.
+var ErrObjectChanged = errors.New("object changed while it was being read")
+
+// errNoRanges is returned when a server sends whole files in answer to
+// range requests.
+var errNoRanges = errors.New("the server does not answer range requests")
+
+const (
+	// remoteAttempts is how often a range request is tried before a read
+	// fails.
+	remoteAttempts = 3
+	// remoteTimeout bounds a single range request.
+	remoteTimeout = 2 * time.Minute
+)
+
+// Remote is an object read with HTTP range requests, so it can be sent
+// without being copied to local disk first: an object in S3, or a file
+// served over HTTP(S). Every read is pinned to the version of the object
+// that was opened.
+type Remote struct {
+	name string
+	size int64
+	// get returns the n bytes at off of the object.
+	get func(ctx context.Context, off, n int64) ([]byte, error)
+}
+
+// IsRemote reports whether path is an s3://, http:// or https:// URL rather
+// than a local path.
+func IsRemote(path string) bool {
+	for _, scheme := range []string{"s3://", "http://", "https://"} {
+		if len(path) > len(scheme) && strings.EqualFold(path[:len(scheme)], scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the last element of the object's key or URL path.
+func (r *Remote) Name() string { return r.name }
+
+// Size returns the size of the object in bytes.
+func (r *Remote) Size() int64 { return r.size }
+
+// ReadAt reads len(p) bytes at off with one range request, which is tried
+// again if it fails on the way.
+func (r *Remote) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%s: negative offset", r.name)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := min(int64(len(p)), r.size-off)
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+		data, err := r.get(ctx, off, n)
+		cancel()
+		if err == nil && int64(len(data)) != n {
+			err = fmt.Errorf("%s: got %d bytes at offset %d, want %d", r.name, len(data), off, n)
+		}
+		if err == nil {
+			copy(p, data)
+			break
+		}
+		if attempt == remoteAttempts || !retryable(err) {
+			return 0, err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	if n < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// retryable reports whether a request that failed with err may succeed if
+// it is sent again: it failed on the network or the server was busy.
+func retryable(err error) bool {
+	var s3Err *S3Error
+	var httpErr *HTTPError
+	switch {
+	case errors.Is(err, ErrObjectChanged), errors.Is(err, errNoRanges):
+		return false
+	case errors.As(err, &s3Err):
+		return s3Err.Status >= 500 || s3Err.Status == http.StatusTooManyRequests
+	case errors.As(err, &httpErr):
+		return httpErr.Status >= 500 || httpErr.Status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// byteRange returns the Range header value for the n bytes at off.
+func byteRange(off, n int64) string {
+	return fmt.Sprintf("bytes=%d-%d", off, off+n-1)
+}
+
+// Open opens the object under key for reading.
+func (s *S3) Open(ctx context.Context, key string) (*Remote, error) {
+	key = s.Prefix + key
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("s3://%s/%s names no object", s.Bucket, key)
+	}
+	resp, _, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("s3://%s/%s: no object size in the response", s.Bucket, key)
+	}
+	etag := resp.Header.Get("ETag")
+	return &Remote{name: path.Base(key), size: resp.ContentLength, get: func(ctx context.Context, off, n int64) ([]byte, error) {
+		hdr := http.Header{"Range": {byteRange(off, n)}}
+		if etag != "" {
+			hdr.Set("If-Match", etag)
+		}
+		_, data, err := s.do(ctx, http.MethodGet, key, nil, hdr, nil, 0, "")
+		var s3Err *S3Error
+		if errors.As(err, &s3Err) && s3Err.Status == http.StatusPreconditionFailed {
+			return nil, fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, ErrObjectChanged)
+		}
+		return data, err
+	}}, nil
+}
+
+// HTTPError is an unsuccessful response from an HTTP server.
+type HTTPError struct {
+	URL    string
+	Status int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d %s", e.URL, e.Status, http.StatusText(e.Status))
+}
+
+// OpenURL opens the file served at rawURL, an http:// or https:// URL, for
+// reading with client. The server must answer range requests and give the
+// file's size.
+func OpenURL(ctx context.Context, client *http.Client, rawURL string) (*Remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("URL %q: want http:// or https://", rawURL)
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("%s names no file", rawURL)
+	}
+	size, etag, err := statURL(ctx, client, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{name: name, size: size, get: func(ctx context.Context, off, n int64) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", byteRange(off, n))
+		// Weak tags, such as servers give compressed files, cannot be
+		// matched.
+		if etag != "" && !strings.HasPrefix(etag, "W/") {
+			req.Header.Set("If-Match", etag)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return nil, fmt.Errorf("%s: %w", rawURL, ErrObjectChanged)
+		case resp.StatusCode == http.StatusOK && (off != 0 || n != size):
+			return nil, fmt.Errorf("%s: %w", rawURL, errNoRanges)
+		case resp.StatusCode/100 != 2:
+			return nil, &HTTPError{URL: rawURL, Status: resp.StatusCode}
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, n+1))
+	}}, nil
+}
+
+// statURL returns the size and entity tag of the file at rawURL. Servers that
+// refuse HEAD requests, as presigned URLs do, are asked for the file's first
+// byte instead.
+func statURL(ctx context.Context, client *http.Client, rawURL string) (int64, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusMethodNotAllowed:
+		return probeURL(ctx, client, rawURL)
+	case resp.StatusCode/100 != 2:
+		return 0, "", &HTTPError{URL: rawURL, Status: resp.StatusCode}
+	case resp.ContentLength < 0:
+		return 0, "", fmt.Errorf("%s: the server did not give the file's size", rawURL)
+	case resp.Header.Get("Accept-Ranges") == "none":
+		return 0, "", fmt.Errorf("%s: %w", rawURL, errNoRanges)
+	}
+	return resp.ContentLength, resp.Header.Get("ETag"), nil
+}
+
+// probeURL returns the size and entity tag of the file at rawURL from the
+// Content-Range of a request for its first byte.
+func probeURL(ctx context.Context, client *http.Client, rawURL string) (int64, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Range", byteRange(0, 1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if _, err := fmt.Sscan(total, &size); err != nil {
+			return 0, "", fmt.Errorf("%s: the server did not give the file's size", rawURL)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Only an empty file has no first byte.
+	case http.StatusOK:
+		return 0, "", fmt.Errorf("%s: %w", rawURL, errNoRanges)
+	default:
+		return 0, "", &HTTPError{URL: rawURL, Status: resp.StatusCode}
+	}
+	return size, resp.Header.Get("ETag"), nil
+}
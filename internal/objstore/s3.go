@@ -0,0 +1,410 @@
+package objstore
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 is an S3-compatible bucket objects are uploaded to with multipart
+// uploads, one part per chunk.
+type S3 struct {
+	// Endpoint is the service URL. Objects are addressed by path under it
+	// (https://host/bucket/key) if PathStyle is set, otherwise under a
+	// bucket subdomain (https://bucket.host/key).
+	Endpoint  *url.URL
+	PathStyle bool
+	Bucket    string
+	// Prefix is prepended to every key, e.g. "incoming/".
+	Prefix      string
+	Region      string
+	Credentials Credentials
+	Client      *http.Client
+}
+
+// NewS3 returns the S3 backend for rawURL, s3://bucket[/prefix], in region.
+// endpoint is the URL of an S3-compatible service such as MinIO, addressed
+// path-style; if it is empty, AWS's endpoint for the region is used. region
+// defaults to $AWS_REGION, then us-east-1.
+func NewS3(rawURL, endpoint, region string, creds Credentials) (*S3, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("S3 URL %q: want s3://bucket[/prefix]", rawURL)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("no S3 credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	s := &S3{
+		Bucket:      u.Host,
+		Prefix:      strings.TrimPrefix(u.Path, "/"),
+		Region:      region,
+		Credentials: creds,
+		Client:      http.DefaultClient,
+	}
+	if s.Prefix != "" && !strings.HasSuffix(s.Prefix, "/") {
+		s.Prefix += "/"
+	}
+	if s.Region == "" {
+		s.Region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	}
+	if endpoint == "" {
+		s.Endpoint = &url.URL{Scheme: "https", Host: "s3." + s.Region + ".amazonaws.com"}
+		return s, nil
+	}
+	if s.Endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("S3 endpoint: %w", err)
+	}
+	if s.Endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint %q: want a URL such as http://127.0.0.1:9000", endpoint)
+	}
+	s.PathStyle = true
+	return s, nil
+}
+
+// Name returns the backend's s3:// URL.
+func (s *S3) Name() string {
+	return "s3://" + s.Bucket + "/" + s.Prefix
+}
+
+// objectURL returns the URL of the object under key, with query q.
+func (s *S3) objectURL(key string, q url.Values) *url.URL {
+	u := *s.Endpoint
+	p := "/" + key
+	if s.PathStyle {
+		p = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + p
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path, u.RawPath = p, uriEncode(p, false)
+	u.RawQuery = canonicalQuery(q)
+	return &u
+}
+
+// S3Error is an error response from S3.
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3: HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("S3: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap lets errors.Is match ErrChecksumMismatch for uploads S3 rejected
+// because their data did not match its hash.
+func (e *S3Error) Unwrap() error {
+	switch e.Code {
+	case "XAmzContentSHA256Mismatch", "BadDigest":
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// do sends a signed request for the object under key, with the headers in
+// hdr, and returns a successful response and its body. body, if not nil,
+// holds length bytes hashing to payloadHash.
+func (s *S3) do(ctx context.Context, method, key string, q url.Values, hdr http.Header, body io.Reader, length int64, payloadHash string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, q).String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = length
+	if body == nil {
+		payloadHash = emptySHA256
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, s.Credentials, s.Region, "s3", payloadHash, time.Now())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A request that fails once S3 has started answering, as completing a
+	// multipart upload can, still answers 200 with an error document. The
+	// body of a GET is object data, whatever it looks like.
+	if resp.StatusCode/100 != 2 || method != http.MethodGet && bytes.HasPrefix(bytes.TrimSpace(skipXMLDecl(data)), []byte("<Error>")) {
+		e := &S3Error{Status: resp.StatusCode}
+		xml.Unmarshal(data, e)
+		return nil, nil, e
+	}
+	return resp, data, nil
+}
+
+// skipXMLDecl returns data without a leading XML declaration.
+func skipXMLDecl(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<?xml")) {
+		if i := bytes.Index(data, []byte("?>")); i >= 0 {
+			return data[i+2:]
+		}
+	}
+	return data
+}
+
+// Begin starts the upload of the object under key. The multipart upload is
+// created with its first part, so a session that sends no data leaves none
+// behind.
+func (s *S3) Begin(ctx context.Context, key string, size int64) (Upload, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("object size %d", size)
+	}
+	return &s3Upload{s3: s, key: s.Prefix + key, size: size, hash: sha256.New(), parts: make(map[int]s3Part)}, nil
+}
+
+// s3Part is a part uploaded.
+type s3Part struct {
+	offset, size int64
+	etag         string
+}
+
+type s3Upload struct {
+	s3   *S3
+	key  string
+	size int64
+
+	mu sync.Mutex
+	// id is the multipart upload's ID once it is created.
+	id    string
+	parts map[int]s3Part
+	// hash covers the first hashed bytes of the object, made of the parts
+	// that were uploaded in order.
+	hash   hash.Hash
+	hashed int64
+}
+
+// partBody is the body of a part upload. It hashes what it reads, and stops
+// reading once done is called, as the HTTP client may still read a body
+// after the request has returned.
+type partBody struct {
+	mu   sync.Mutex
+	r    io.Reader
+	hash hash.Hash
+	done bool
+}
+
+func (b *partBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// finish stops the body and reads the rest of the part's data, and returns
+// the hash of all of it.
+func (b *partBody) finish() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	_, err := io.Copy(b.hash, b.r)
+	return hex.EncodeToString(b.hash.Sum(nil)), err
+}
+
+func (u *s3Upload) WritePart(ctx context.Context, offset, size int64, sum string, src io.Reader) (err error) {
+	r := io.LimitReader(src, size)
+	defer func() {
+		n, derr := io.Copy(io.Discard, src)
+		switch {
+		case err != nil:
+		case derr != nil:
+			err = derr
+		case n > 0:
+			err = fmt.Errorf("part at offset %d: more than %d bytes", offset, size)
+		}
+	}()
+	num, err := PartNumber(offset, size, u.size)
+	if err != nil {
+		return err
+	}
+	if err := u.create(ctx); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	if p, ok := u.parts[num]; ok && p.offset != offset {
+		u.mu.Unlock()
+		return fmt.Errorf("part %d at offset %d overlaps the part at offset %d", num, offset, p.offset)
+	}
+	// Parts arriving in order are hashed into the object's hash as they
+	// stream. The hash is forked so a part that fails leaves it as it was.
+	var objHash hash.Hash
+	if offset == u.hashed {
+		objHash = cloneHash(u.hash)
+	}
+	id := u.id
+	u.mu.Unlock()
+
+	h := sha256.New()
+	if objHash != nil {
+		h = &teeHash{Hash: h, also: objHash}
+	}
+	body := &partBody{r: r, hash: h}
+	q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {id}}
+	resp, _, err := u.s3.do(ctx, http.MethodPut, u.key, q, nil, body, size, sum)
+	got, ferr := body.finish()
+	switch {
+	case ferr != nil:
+		return ferr
+	case got != strings.ToLower(sum):
+		return fmt.Errorf("part %d: %w", num, ErrChecksumMismatch)
+	case err != nil:
+		return fmt.Errorf("part %d: %w", num, err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.parts[num] = s3Part{offset: offset, size: size, etag: resp.Header.Get("ETag")}
+	if objHash != nil && offset == u.hashed {
+		u.hash, u.hashed = objHash, offset+size
+	}
+	return nil
+}
+
+// create creates the multipart upload, once.
+func (u *s3Upload) create(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.id != "" {
+		return nil
+	}
+	_, data, err := u.s3.do(ctx, http.MethodPost, u.key, url.Values{"uploads": {""}}, nil, nil, 0, "")
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &res); err != nil || res.UploadID == "" {
+		return fmt.Errorf("create multipart upload: no upload ID in %q", data)
+	}
+	u.id = res.UploadID
+	return nil
+}
+
+func (u *s3Upload) SHA256() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.hashed != u.size {
+		return ""
+	}
+	return hex.EncodeToString(cloneHash(u.hash).Sum(nil))
+}
+
+func (u *s3Upload) Complete(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	location := "s3://" + u.s3.Bucket + "/" + u.key
+	if u.size == 0 && u.id == "" {
+		// An empty object has no parts to upload.
+		if _, _, err := u.s3.do(ctx, http.MethodPut, u.key, nil, nil, http.NoBody, 0, emptySHA256); err != nil {
+			return "", err
+		}
+		return location, nil
+	}
+
+	nums := make([]int, 0, len(u.parts))
+	for n := range u.parts {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var covered int64
+	for _, n := range nums {
+		if p := u.parts[n]; p.offset == covered {
+			covered += p.size
+		}
+	}
+	if covered != u.size {
+		return "", fmt.Errorf("object incomplete: the parts cover %d of its %d bytes", covered, u.size)
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, n := range nums {
+		doc.Parts = append(doc.Parts, part{n, u.parts[n].etag})
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	_, _, err = u.s3.do(ctx, http.MethodPost, u.key, url.Values{"uploadId": {u.id}}, nil, bytes.NewReader(body), int64(len(body)), sha256Hex(body))
+	if err != nil {
+		return "", fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return location, nil
+}
+
+func (u *s3Upload) Abort(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.id == "" {
+		return nil
+	}
+	if _, _, err := u.s3.do(ctx, http.MethodDelete, u.key, url.Values{"uploadId": {u.id}}, nil, nil, 0, ""); err != nil {
+		return fmt.Errorf("abort multipart upload: %w", err)
+	}
+	u.id = ""
+	return nil
+}
+
+// cloneHash returns a copy of h, a SHA-256 hash, in the same state.
+func cloneHash(h hash.Hash) hash.Hash {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	c := sha256.New()
+	if err := c.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// teeHash is a hash that also writes what it hashes to another.
+type teeHash struct {
+	hash.Hash
+	also hash.Hash
+}
+
+func (t *teeHash) Write(p []byte) (int, error) {
+	t.also.Write(p)
+	return t.Hash.Write(p)
+}
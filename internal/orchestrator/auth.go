@@ -0,0 +1,215 @@
+package orchestrator
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope is what an API token may do.
+type Scope string
+
+const (
+	// ScopeClient covers the endpoints senders, receivers and nodes use:
+	// sessions, transfers, receipts, nodes and route planning.
+	ScopeClient Scope = "client"
+	// ScopeRelay covers relay registration and heartbeats.
+	ScopeRelay Scope = "relay"
+	// ScopeAdmin covers every endpoint, including token management.
+	ScopeAdmin Scope = "admin"
+)
+
+// APIToken is a token issued through POST /api/v1/tokens. Only a hash of
+// its secret is kept; the secret itself is returned once, when the token
+// is created.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	// Hash is the hex SHA-256 of the secret. It is stored, never returned.
+	Hash string `json:"hash,omitempty"`
+}
+
+// allows reports whether the token grants scope.
+func (t *APIToken) allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, ScopeAdmin) || slices.Contains(t.Scopes, scope)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorize checks the bearer token of r against scope. It returns the
+// status to refuse the request with, or 0 to let it through. Without an
+// AdminToken every request but those taking the admin scope goes through.
+func (s *Service) authorize(r *http.Request, scope Scope) int {
+	if s.AdminToken == "" {
+		if scope == ScopeAdmin {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || secret == "" {
+		return http.StatusUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.AdminToken)) == 1 {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// Every token is compared, in constant time, so the time taken reveals
+	// nothing of the hashes held.
+	hash := []byte(hashSecret(secret))
+	var found *APIToken
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			found = t
+		}
+	}
+	switch {
+	case found == nil:
+		return http.StatusUnauthorized
+	case !found.allows(scope):
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+// require wraps h so that it only serves requests whose token grants scope.
+func (s *Service) require(scope Scope, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch s.authorize(r, scope) {
+		case http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="trackshift"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API token"})
+		case http.StatusForbidden:
+			msg := fmt.Sprintf("API token lacks the %s scope", scope)
+			if s.AdminToken == "" {
+				msg = "this endpoint takes the admin token; start the orchestrator with one"
+			}
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": msg})
+		default:
+			h(w, r)
+		}
+	}
+}
+
+// handleTokens handles POST and GET /api/v1/tokens: issuing a token and
+// listing the tokens issued, without their secrets.
+func (s *Service) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		out := make([]APIToken, 0, len(s.tokens))
+		for _, t := range s.tokens {
+			reply := *t
+			reply.Hash = ""
+			out = append(out, reply)
+		}
+		s.mu.RUnlock()
+		slices.SortFunc(out, func(a, b APIToken) int { return a.CreatedAt.Compare(b.CreatedAt) })
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		s.handleTokenCreate(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string  `json:"name"`
+		Scopes []Scope `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "a token needs at least one scope"})
+		return
+	}
+	for _, sc := range req.Scopes {
+		switch sc {
+		case ScopeClient, ScopeRelay, ScopeAdmin:
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown scope %q", sc)})
+			return
+		}
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	secret := hex.EncodeToString(raw)
+	t := &APIToken{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		CreatedAt: time.Now(),
+		Hash:      hashSecret(secret),
+	}
+
+	s.mu.Lock()
+	err := s.store.SaveToken(t)
+	if err == nil {
+		s.tokens[t.ID] = t
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("API token %s (%s) issued with scopes %v", t.ID, t.Name, t.Scopes)
+	reply := struct {
+		APIToken
+		Secret string `json:"secret"`
+	}{APIToken: *t, Secret: secret}
+	reply.Hash = ""
+	writeJSON(w, http.StatusCreated, reply)
+}
+
+// handleToken handles DELETE /api/v1/tokens/{id}, revoking the token.
+func (s *Service) handleToken(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/tokens/")
+	if id == "" || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	_, ok := s.tokens[id]
+	var err error
+	if ok {
+		if err = s.store.DeleteToken(id); err == nil {
+			delete(s.tokens, id)
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("API token %s revoked", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
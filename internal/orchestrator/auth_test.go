@@ -0,0 +1,128 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPITokens(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenBoltStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServiceWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(token, method, path string, body any) *http.Response {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	relay := map[string]string{"id": "r1", "address": "r1:9000"}
+
+	// Without an admin token the API is open, but no tokens can be issued.
+	if code := do("", http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("open API = %d", code)
+	}
+	if code := do("", http.MethodPost, "/api/v1/tokens", map[string]any{"scopes": []Scope{ScopeClient}}).StatusCode; code != http.StatusForbidden {
+		t.Fatalf("issue without admin token = %d, want 403", code)
+	}
+
+	s.AdminToken = "admin-secret"
+	if code := do("", http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusUnauthorized {
+		t.Fatalf("no token = %d, want 401", code)
+	}
+	if code := do("wrong", http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusUnauthorized {
+		t.Fatalf("wrong token = %d, want 401", code)
+	}
+	if code := do("admin-secret", http.MethodPost, "/api/v1/tokens", map[string]any{"scopes": []Scope{"root"}}).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("unknown scope = %d, want 400", code)
+	}
+	issue := func(scope Scope) (id, secret string) {
+		t.Helper()
+		resp := do("admin-secret", http.MethodPost, "/api/v1/tokens", map[string]any{"name": string(scope), "scopes": []Scope{scope}})
+		var tok struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+			Hash   string `json:"hash"`
+		}
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("issue %s = %d", scope, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.Secret == "" || tok.Hash != "" {
+			t.Fatalf("issued %+v (%v)", tok, err)
+		}
+		return tok.ID, tok.Secret
+	}
+	clientID, clientSecret := issue(ScopeClient)
+	_, relaySecret := issue(ScopeRelay)
+
+	if code := do(clientSecret, http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("client lists sessions = %d", code)
+	}
+	if code := do(clientSecret, http.MethodPost, "/api/v1/relays/register", relay).StatusCode; code != http.StatusForbidden {
+		t.Fatalf("client registers relay = %d, want 403", code)
+	}
+	if code := do(relaySecret, http.MethodPost, "/api/v1/relays/register", relay).StatusCode; code != http.StatusOK {
+		t.Fatalf("relay registers = %d", code)
+	}
+	if code := do(relaySecret, http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusForbidden {
+		t.Fatalf("relay lists sessions = %d, want 403", code)
+	}
+	if code := do(clientSecret, http.MethodGet, "/api/v1/tokens", nil).StatusCode; code != http.StatusForbidden {
+		t.Fatalf("client lists tokens = %d, want 403", code)
+	}
+
+	// Tokens survive a restart; revoked ones stop working at once.
+	s.Close()
+	if store, err = OpenBoltStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewServiceWithStore(store); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AdminToken = "admin-secret"
+	mux = http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv.Config.Handler = mux
+
+	var listed []APIToken
+	if err := json.NewDecoder(do("admin-secret", http.MethodGet, "/api/v1/tokens", nil).Body).Decode(&listed); err != nil || len(listed) != 2 {
+		t.Fatalf("listed %v (%v)", listed, err)
+	}
+	if code := do("admin-secret", http.MethodDelete, "/api/v1/tokens/"+clientID, nil).StatusCode; code != http.StatusNoContent {
+		t.Fatalf("revoke = %d", code)
+	}
+	if code := do(clientSecret, http.MethodGet, "/api/v1/sessions", nil).StatusCode; code != http.StatusUnauthorized {
+		t.Fatalf("revoked token = %d, want 401", code)
+	}
+	if code := do("admin-secret", http.MethodDelete, "/api/v1/tokens/"+clientID, nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("revoke again = %d, want 404", code)
+	}
+}
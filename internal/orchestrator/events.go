@@ -0,0 +1,183 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// SessionEventType names what a SessionEvent reports.
+type SessionEventType string
+
+const (
+	// EventSnapshot starts every stream with the session as it stands.
+	EventSnapshot SessionEventType = "snapshot"
+	// EventProgress reports changed chunk or byte counts.
+	EventProgress SessionEventType = "progress"
+	// EventStatus reports a change of status.
+	EventStatus SessionEventType = "status"
+	// EventControl reports a pause, resume or cancel request.
+	EventControl SessionEventType = "control"
+	// EventDeleted ends the stream of a deleted session.
+	EventDeleted SessionEventType = "deleted"
+)
+
+// SessionEvent is one event of GET /api/v1/session/{id}/events.
+type SessionEvent struct {
+	Type SessionEventType `json:"type"`
+	// Session is the session after the change; it is nil for EventDeleted.
+	Session *models.TransferSession `json:"session,omitempty"`
+	// From is the previous status, for EventStatus.
+	From models.SessionStatus `json:"from,omitempty"`
+	// NewlyCompleted is the number of chunks completed since the previous
+	// report, for EventProgress.
+	NewlyCompleted int `json:"newly_completed,omitempty"`
+}
+
+// final reports whether no event follows ev: the session was deleted, or
+// its status is now completed or cancelled, which it cannot be taken out
+// of. A status change is published after the other changes it came with.
+func (ev *SessionEvent) final() bool {
+	switch ev.Type {
+	case EventDeleted:
+		return true
+	case EventSnapshot, EventStatus:
+	default:
+		return false
+	}
+	return ev.Session.Status == models.SessionStatusCompleted ||
+		(ev.Session.Status == models.SessionStatusFailed && ev.Session.Control == models.SessionControlCancel)
+}
+
+// sessionEvents describes the change from prev to next.
+func sessionEvents(prev, next *models.TransferSession) []SessionEvent {
+	var out []SessionEvent
+	if next.Completed != prev.Completed || next.Failed != prev.Failed || next.TotalChunks != prev.TotalChunks ||
+		next.BytesSent != prev.BytesSent || next.BytesReceived != prev.BytesReceived {
+		out = append(out, SessionEvent{Type: EventProgress, Session: next, NewlyCompleted: max(next.Completed-prev.Completed, 0)})
+	}
+	if next.Control != prev.Control {
+		out = append(out, SessionEvent{Type: EventControl, Session: next})
+	}
+	// The status comes last, so a stream it ends has the other changes.
+	if next.Status != prev.Status {
+		out = append(out, SessionEvent{Type: EventStatus, Session: next, From: prev.Status})
+	}
+	return out
+}
+
+// eventBacklog is how many events a stream may fall behind by. A stream
+// further behind is ended; its client reconnects to a fresh snapshot.
+const eventBacklog = 64
+
+// eventKeepalive is how often an idle stream gets a comment line, so that
+// proxies do not time it out.
+const eventKeepalive = 15 * time.Second
+
+// publishLocked sends events to the streams watching session id. s.mu must
+// be held for writing.
+func (s *Service) publishLocked(id string, events ...SessionEvent) {
+	for ch := range s.watchers[id] {
+		for _, ev := range events {
+			select {
+			case ch <- ev:
+				continue
+			default:
+			}
+			s.unwatchLocked(id, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// endWatchesLocked ends the streams watching session id with a final
+// event. s.mu must be held for writing.
+func (s *Service) endWatchesLocked(id string, ev SessionEvent) {
+	s.publishLocked(id, ev)
+	for ch := range s.watchers[id] {
+		close(ch)
+	}
+	delete(s.watchers, id)
+}
+
+func (s *Service) unwatchLocked(id string, ch chan SessionEvent) {
+	delete(s.watchers[id], ch)
+	if len(s.watchers[id]) == 0 {
+		delete(s.watchers, id)
+	}
+}
+
+// handleSessionEvents handles GET /api/v1/session/{id}/events, streaming
+// the session's events as server-sent events, starting with a snapshot.
+// The stream ends once the session is completed, cancelled or deleted.
+func (s *Service) handleSessionEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+	ch := make(chan SessionEvent, eventBacklog)
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	if ok {
+		if s.watchers[id] == nil {
+			s.watchers[id] = make(map[chan SessionEvent]struct{})
+		}
+		s.watchers[id][ch] = struct{}{}
+	}
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.watchers[id][ch]; ok {
+			s.unwatchLocked(id, ch)
+		}
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	seq := 0
+	send := func(ev SessionEvent) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		seq++
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", seq, ev.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	ev := SessionEvent{Type: EventSnapshot, Session: sess}
+	if send(ev) != nil || ev.final() {
+		return
+	}
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok || send(ev) != nil || ev.final() {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
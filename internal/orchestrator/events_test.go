@@ -0,0 +1,125 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSessionEvents(t *testing.T) {
+	s := NewService()
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(method, path string, body any) int {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	file := models.FileMetadata{ID: "f", Name: "a.bin", Size: 10, Hash: "h"}
+	for _, id := range []string{"s1", "s2"} {
+		if code := do(http.MethodPost, "/api/v1/session", map[string]any{"id": id, "file": file}); code != http.StatusCreated {
+			t.Fatalf("create %s = %d", id, code)
+		}
+	}
+
+	watch := func(id string) (*bufio.Reader, io.Closer) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/session/" + id + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("watch %s = %d %s", id, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body), resp.Body
+	}
+	// next returns the next event, or nil once the stream ends.
+	next := func(r *bufio.Reader) *SessionEvent {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var ev SessionEvent
+				if err := json.Unmarshal([]byte(data), &ev); err != nil {
+					t.Fatal(err)
+				}
+				return &ev
+			}
+		}
+	}
+
+	r, body := watch("s1")
+	defer body.Close()
+	if ev := next(r); ev == nil || ev.Type != EventSnapshot || ev.Session.Status != models.SessionStatusCreated {
+		t.Fatalf("first event = %+v", ev)
+	}
+	do(http.MethodPatch, "/api/v1/session/s1", map[string]any{"status": models.SessionStatusTransferring, "total_chunks": 5, "completed": 2})
+	if ev := next(r); ev == nil || ev.Type != EventProgress || ev.NewlyCompleted != 2 {
+		t.Fatalf("progress event = %+v", ev)
+	}
+	if ev := next(r); ev == nil || ev.Type != EventStatus || ev.From != models.SessionStatusCreated || ev.Session.Status != models.SessionStatusTransferring {
+		t.Fatalf("status event = %+v", ev)
+	}
+	do(http.MethodPost, "/api/v1/session/s1/pause", nil)
+	if ev := next(r); ev == nil || ev.Type != EventControl || ev.Session.Control != models.SessionControlPause {
+		t.Fatalf("control event = %+v", ev)
+	}
+	do(http.MethodPatch, "/api/v1/session/s1", map[string]any{"status": models.SessionStatusCompleted, "completed": 5})
+	if ev := next(r); ev == nil || ev.Type != EventProgress || ev.NewlyCompleted != 3 {
+		t.Fatalf("final progress event = %+v", ev)
+	}
+	// Completing the session drops the pause request.
+	if ev := next(r); ev == nil || ev.Type != EventControl || ev.Session.Control != "" {
+		t.Fatalf("control cleared event = %+v", ev)
+	}
+	if ev := next(r); ev == nil || ev.Type != EventStatus || ev.Session.Status != models.SessionStatusCompleted {
+		t.Fatalf("completion event = %+v", ev)
+	}
+	if ev := next(r); ev != nil {
+		t.Fatalf("stream continued after completion: %+v", ev)
+	}
+
+	// Deleting a session ends its streams.
+	r2, body2 := watch("s2")
+	defer body2.Close()
+	next(r2)
+	if code := do(http.MethodDelete, "/api/v1/session/s2", nil); code != http.StatusNoContent {
+		t.Fatalf("delete = %d", code)
+	}
+	if ev := next(r2); ev == nil || ev.Type != EventDeleted {
+		t.Fatalf("delete event = %+v", ev)
+	}
+	if ev := next(r2); ev != nil {
+		t.Fatalf("stream continued after deletion: %+v", ev)
+	}
+	if resp, err := http.Get(srv.URL + "/api/v1/session/nope/events"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("watch unknown session = %v %v", resp.StatusCode, err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.watchers) != 0 {
+		t.Fatalf("streams left registered: %v", s.watchers)
+	}
+}
@@ -0,0 +1,206 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/session"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// SessionUpdate is the body of PATCH /api/v1/session/{id}, sent by a
+// session's endpoints as it progresses. Fields left out are unchanged.
+type SessionUpdate struct {
+	Status        *models.SessionStatus `json:"status,omitempty"`
+	TotalChunks   *int                  `json:"total_chunks,omitempty"`
+	Completed     *int                  `json:"completed,omitempty"`
+	Failed        *int                  `json:"failed,omitempty"`
+	BytesSent     *int64                `json:"bytes_sent,omitempty"`
+	BytesReceived *int64                `json:"bytes_received,omitempty"`
+}
+
+// validate checks u against the session it applies to, without changing it.
+func (u *SessionUpdate) validate(sess *models.TransferSession) error {
+	for _, n := range []*int{u.TotalChunks, u.Completed, u.Failed} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("chunk counts must be non-negative")
+		}
+	}
+	for _, n := range []*int64{u.BytesSent, u.BytesReceived} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("byte counts must be non-negative")
+		}
+	}
+	if u.Status == nil {
+		return nil
+	}
+	to := *u.Status
+	if sess.Control == models.SessionControlCancel && to != models.SessionStatusFailed {
+		return fmt.Errorf("session %s was cancelled", sess.ID)
+	}
+	if !session.CanTransition(sess.Status, to) {
+		return fmt.Errorf("%w: from %s to %s", session.ErrIllegalTransition, sess.Status, to)
+	}
+	return nil
+}
+
+// apply writes u into sess.
+func (u *SessionUpdate) apply(sess *models.TransferSession, now time.Time) {
+	if u.Status != nil && *u.Status != sess.Status {
+		sess.Status = *u.Status
+		switch sess.Status {
+		case models.SessionStatusCompleted:
+			sess.CompletedAt = &now
+			sess.Control = ""
+		case models.SessionStatusFailed:
+			// A pause no longer applies; a cancel stays recorded so the
+			// session is not resumed.
+			if sess.Control == models.SessionControlPause {
+				sess.Control = ""
+			}
+		}
+	}
+	set := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&sess.TotalChunks, u.TotalChunks)
+	set(&sess.Completed, u.Completed)
+	set(&sess.Failed, u.Failed)
+	if u.BytesSent != nil {
+		sess.BytesSent = *u.BytesSent
+	}
+	if u.BytesReceived != nil {
+		sess.BytesReceived = *u.BytesReceived
+	}
+	sess.UpdatedAt = now
+}
+
+// handleSessionUpdate handles PATCH /api/v1/session/{id}. The reply is the
+// updated session, whose control field tells the endpoint what it has been
+// asked to do.
+func (s *Service) handleSessionUpdate(w http.ResponseWriter, r *http.Request, id string) {
+	var u SessionUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	s.changeSession(w, id, func(sess *models.TransferSession) (int, error) {
+		if err := u.validate(sess); err != nil {
+			if u.Status != nil {
+				return http.StatusConflict, err
+			}
+			return http.StatusBadRequest, err
+		}
+		u.apply(sess, time.Now())
+		return http.StatusOK, nil
+	})
+}
+
+// handleSessionControl handles POST /api/v1/session/{id}/{pause,resume,cancel}.
+// Pausing and resuming only record the request, which the sender carries
+// out on its next progress report. Cancelling also fails the session at
+// once, so a session whose endpoints are gone does not stay in flight.
+func (s *Service) handleSessionControl(w http.ResponseWriter, id, action string) {
+	var change func(sess *models.TransferSession) (int, error)
+	switch action {
+	case "pause":
+		change = func(sess *models.TransferSession) (int, error) {
+			if sess.Status != models.SessionStatusTransferring && sess.Status != models.SessionStatusPaused {
+				return http.StatusConflict, fmt.Errorf("session %s is %s; only a transferring session can be paused", id, sess.Status)
+			}
+			if sess.Control == models.SessionControlCancel {
+				return http.StatusConflict, fmt.Errorf("session %s was cancelled", id)
+			}
+			sess.Control = models.SessionControlPause
+			return http.StatusOK, nil
+		}
+	case "resume":
+		change = func(sess *models.TransferSession) (int, error) {
+			if sess.Control != models.SessionControlPause {
+				return http.StatusConflict, fmt.Errorf("session %s has no pause to resume from", id)
+			}
+			sess.Control = ""
+			return http.StatusOK, nil
+		}
+	case "cancel":
+		change = func(sess *models.TransferSession) (int, error) {
+			if !session.CanTransition(sess.Status, models.SessionStatusFailed) {
+				return http.StatusConflict, fmt.Errorf("session %s is %s and cannot be cancelled", id, sess.Status)
+			}
+			sess.Status = models.SessionStatusFailed
+			sess.Control = models.SessionControlCancel
+			return http.StatusOK, nil
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.changeSession(w, id, func(sess *models.TransferSession) (int, error) {
+		status, err := change(sess)
+		if err == nil {
+			sess.UpdatedAt = time.Now()
+		}
+		return status, err
+	})
+}
+
+// changeSession applies change to a copy of session id and, if it succeeds,
+// saves the copy and replies with it. Otherwise the session is left as it
+// was and the reply carries the error with the status change returned.
+func (s *Service) changeSession(w http.ResponseWriter, id string, change func(*models.TransferSession) (int, error)) {
+	s.mu.Lock()
+	cur, ok := s.sessions[id]
+	if !ok {
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	next := *cur
+	status, err := change(&next)
+	if err == nil {
+		if err = s.store.SaveSession(&next); err == nil {
+			s.sessions[id] = &next
+			s.publishLocked(id, sessionEvents(cur, &next)...)
+		} else {
+			status = http.StatusInternalServerError
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, status, &next)
+}
+
+// handleSessionDelete handles DELETE /api/v1/session/{id}. Sessions still
+// in flight must be cancelled first.
+func (s *Service) handleSessionDelete(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	var err error
+	status := http.StatusNoContent
+	switch {
+	case !ok:
+		status = http.StatusNotFound
+	case sess.Status == models.SessionStatusTransferring || sess.Status == models.SessionStatusPaused:
+		status, err = http.StatusConflict, fmt.Errorf("session %s is %s; cancel it before deleting it", id, sess.Status)
+	default:
+		if err = s.store.DeleteSession(id); err == nil {
+			delete(s.sessions, id)
+			s.endWatchesLocked(id, SessionEvent{Type: EventDeleted})
+		} else {
+			status = http.StatusInternalServerError
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(status)
+}
@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	s := NewService()
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(method, path string, body any) (int, models.TransferSession) {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var sess models.TransferSession
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			if err := json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, sess
+	}
+	status := func(st models.SessionStatus) map[string]any { return map[string]any{"status": st} }
+	file := models.FileMetadata{ID: "f", Name: "a.bin", Size: 10, Hash: "h"}
+
+	if code, sess := do(http.MethodPost, "/api/v1/session", map[string]any{"id": "s1", "file": file}); code != http.StatusCreated || sess.ID != "s1" {
+		t.Fatalf("create with ID = %d %q", code, sess.ID)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/session", map[string]any{"id": "s1", "file": file}); code != http.StatusConflict {
+		t.Fatalf("create duplicate = %d, want 409", code)
+	}
+	if code, _ := do(http.MethodPost, "/api/v1/session/s1/pause", nil); code != http.StatusConflict {
+		t.Fatalf("pause created session = %d, want 409", code)
+	}
+	if code, _ := do(http.MethodPatch, "/api/v1/session/s1", status(models.SessionStatusCompleted)); code != http.StatusConflict {
+		t.Fatalf("created -> completed = %d, want 409", code)
+	}
+	if code, _ := do(http.MethodPatch, "/api/v1/session/s1", map[string]any{"bytes_sent": -1}); code != http.StatusBadRequest {
+		t.Fatalf("negative bytes = %d, want 400", code)
+	}
+
+	code, sess := do(http.MethodPatch, "/api/v1/session/s1", map[string]any{"status": models.SessionStatusTransferring, "bytes_sent": 4})
+	if code != http.StatusOK || sess.Status != models.SessionStatusTransferring || sess.BytesSent != 4 {
+		t.Fatalf("update = %d %+v", code, sess)
+	}
+	if code, sess = do(http.MethodPost, "/api/v1/session/s1/pause", nil); code != http.StatusOK || sess.Control != models.SessionControlPause {
+		t.Fatalf("pause = %d control %q", code, sess.Control)
+	}
+	// The sender learns of the pause from its next report.
+	if code, sess = do(http.MethodPatch, "/api/v1/session/s1", map[string]any{"bytes_sent": 6}); code != http.StatusOK || sess.Control != models.SessionControlPause {
+		t.Fatalf("report while pause requested = %d control %q", code, sess.Control)
+	}
+	do(http.MethodPatch, "/api/v1/session/s1", status(models.SessionStatusPaused))
+	if code, sess = do(http.MethodPost, "/api/v1/session/s1/resume", nil); code != http.StatusOK || sess.Control != "" {
+		t.Fatalf("resume = %d control %q", code, sess.Control)
+	}
+	if code, _ := do(http.MethodDelete, "/api/v1/session/s1", nil); code != http.StatusConflict {
+		t.Fatalf("delete paused session = %d, want 409", code)
+	}
+
+	if code, sess = do(http.MethodPost, "/api/v1/session/s1/cancel", nil); code != http.StatusOK || sess.Status != models.SessionStatusFailed || sess.Control != models.SessionControlCancel {
+		t.Fatalf("cancel = %d %s control %q", code, sess.Status, sess.Control)
+	}
+	// A cancelled session is not resumed, though failed ones may be.
+	if code, _ := do(http.MethodPatch, "/api/v1/session/s1", status(models.SessionStatusTransferring)); code != http.StatusConflict {
+		t.Fatalf("resume cancelled session = %d, want 409", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/v1/session/s1", nil); code != http.StatusNoContent {
+		t.Fatalf("delete = %d, want 204", code)
+	}
+	if code, _ := do(http.MethodGet, "/api/v1/session/s1", nil); code != http.StatusNotFound {
+		t.Fatalf("get deleted = %d, want 404", code)
+	}
+}
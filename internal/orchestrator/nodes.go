@@ -0,0 +1,192 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// nodeTTL is how long a node registration stays valid. Nodes re-register
+// well within it while they are up.
+const nodeTTL = 5 * time.Minute
+
+// NodeInfo describes a receiver that can serve files it has already
+// assembled back out to other receivers.
+type NodeInfo struct {
+	ID string `json:"id"`
+	// ControlURL is the base URL of the node's control API.
+	ControlURL string `json:"control_url"`
+	// ControlToken, if set, is presented to the control API as a bearer
+	// token. It is never listed.
+	ControlToken string    `json:"control_token,omitempty"`
+	Region       string    `json:"region,omitempty"`
+	Files        []string  `json:"files"` // SHA-256 hashes of the files held
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// listed returns a copy of n without its control token.
+func (n *NodeInfo) listed() *NodeInfo {
+	out := *n
+	out.ControlToken = ""
+	return &out
+}
+
+// holds reports whether n holds the file with the given hash.
+func (n *NodeInfo) holds(hash string) bool {
+	for _, h := range n.Files {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// TransferRequest asks for the file with hash Hash to be delivered to the
+// receiver at Receiver. Region, if set, is where that receiver is located
+// and is used to pick the nearest source.
+type TransferRequest struct {
+	Hash     string `json:"hash"`
+	Receiver string `json:"receiver"`
+	Region   string `json:"region,omitempty"`
+}
+
+// TransferAssignment reports the node that is sending a requested file.
+type TransferAssignment struct {
+	TransferRequest
+	Node string `json:"node"`
+}
+
+// handleNodeRegister handles POST /api/v1/nodes/register
+func (s *Service) handleNodeRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req NodeInfo
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.ControlURL == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.ControlURL = strings.TrimSuffix(req.ControlURL, "/")
+	req.LastSeen = time.Now()
+
+	s.mu.Lock()
+	s.nodes[req.ID] = &req
+	err := s.store.SaveNode(&req)
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req.listed())
+}
+
+// handleNodesList handles GET /api/v1/nodes
+func (s *Service) handleNodesList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	out := make([]*NodeInfo, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		out = append(out, n.listed())
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleTransferRequest handles POST /api/v1/transfers. The file is sent by
+// the nearest node that holds it; if that node cannot be reached the next
+// one is tried.
+func (s *Service) handleTransferRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Hash == "" || req.Receiver == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	candidates := s.sourcesFor(req.Hash, req.Region, time.Now())
+	if len(candidates) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no node holds that file"})
+		return
+	}
+	var errs []string
+	for _, n := range candidates {
+		if err := s.startServe(n, req); err != nil {
+			log.Printf("node %s cannot serve %s: %v", n.ID, req.Hash, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", n.ID, err))
+			continue
+		}
+		writeJSON(w, http.StatusAccepted, TransferAssignment{TransferRequest: req, Node: n.ID})
+		return
+	}
+	writeJSON(w, http.StatusBadGateway, map[string]string{"error": strings.Join(errs, "; ")})
+}
+
+// sourcesFor returns the live nodes holding hash, nearest first: nodes in
+// region come before the rest, and more recently seen nodes before others.
+func (s *Service) sourcesFor(hash, region string, now time.Time) []NodeInfo {
+	s.mu.RLock()
+	var out []NodeInfo
+	for _, n := range s.nodes {
+		if now.Sub(n.LastSeen) <= nodeTTL && n.holds(hash) {
+			out = append(out, *n)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		li, lj := region != "" && out[i].Region == region, region != "" && out[j].Region == region
+		if li != lj {
+			return li
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
+}
+
+// startServe asks node n to send the requested file.
+func (s *Service) startServe(n NodeInfo, req TransferRequest) error {
+	body, err := json.Marshal(map[string]string{"receiver": req.Receiver})
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, n.ControlURL+"/api/v1/files/"+req.Hash+"/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if n.ControlToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+n.ControlToken)
+	}
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
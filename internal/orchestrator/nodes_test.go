@@ -0,0 +1,63 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNodeControlToken(t *testing.T) {
+	// The node refuses requests without its control token.
+	var sent string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer node-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sent = r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer node.Close()
+
+	s := NewService()
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(path string, v any) *http.Response {
+		t.Helper()
+		body, _ := json.Marshal(v)
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := post("/api/v1/nodes/register", NodeInfo{ID: "node-1", ControlURL: node.URL, ControlToken: "node-secret", Files: []string{"abc"}})
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "node-secret") {
+		t.Fatalf("registration answered with the control token: %s", body)
+	}
+
+	resp = post("/api/v1/transfers", TransferRequest{Hash: "abc", Receiver: "10.0.0.7:8080"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || sent != "/api/v1/files/abc/send" {
+		t.Fatalf("transfer request: %s, node saw %q", resp.Status, sent)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "node-1") || strings.Contains(string(body), "node-secret") {
+		t.Fatalf("node list: %s", body)
+	}
+}
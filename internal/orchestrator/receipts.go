@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+)
+
+// handleReceipts handles POST /api/v1/receipts, which records a delivery
+// receipt posted by a receiver, and GET /api/v1/receipts, which lists them.
+// GET takes optional session_id (either side's session) and file_hash
+// filters.
+func (s *Service) handleReceipts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var rec receipt.Receipt
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// Receipts are kept as proof, so only correctly signed ones are.
+		if err := rec.Verify(nil); err != nil || rec.SessionID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid receipt"})
+			return
+		}
+		key := rec.Receiver + "/" + rec.SessionID
+		s.mu.Lock()
+		err := s.store.SaveReceipt(key, &rec)
+		if err == nil {
+			s.receipts[key] = &rec
+		}
+		s.mu.Unlock()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, &rec)
+	case http.MethodGet:
+		session, hash := r.URL.Query().Get("session_id"), r.URL.Query().Get("file_hash")
+		s.mu.RLock()
+		out := make([]*receipt.Receipt, 0, len(s.receipts))
+		for _, rec := range s.receipts {
+			if session != "" && rec.SessionID != session && rec.SenderSessionID != session {
+				continue
+			}
+			if hash != "" && rec.FileHash != hash {
+				continue
+			}
+			out = append(out, rec)
+		}
+		s.mu.RUnlock()
+		sort.Slice(out, func(i, j int) bool { return out[i].CompletedAt.Before(out[j].CompletedAt) })
+		writeJSON(w, http.StatusOK, out)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,85 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+)
+
+func TestReceipts(t *testing.T) {
+	s := NewService()
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(r *receipt.Receipt) int {
+		body, _ := json.Marshal(r)
+		resp, err := http.Post(srv.URL+"/api/v1/receipts", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	now := time.Now()
+	for i, id := range []string{"r-1", "r-2"} {
+		r := &receipt.Receipt{
+			SessionID:       id,
+			SenderSessionID: "s-" + id,
+			FileHash:        "hash-" + id,
+			StartedAt:       now,
+			CompletedAt:     now.Add(time.Duration(i) * time.Second),
+			Receiver:        "node-a",
+		}
+		if err := r.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		if code := post(r); code != http.StatusOK {
+			t.Fatalf("POST receipt %s: status %d", id, code)
+		}
+	}
+
+	// Receipts whose signature does not verify are refused.
+	forged := &receipt.Receipt{SessionID: "r-3", Receiver: "node-a"}
+	if err := forged.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	forged.Size = 1 << 30
+	if code := post(forged); code != http.StatusBadRequest {
+		t.Fatalf("POST forged receipt: status %d", code)
+	}
+
+	get := func(query string) []receipt.Receipt {
+		resp, err := http.Get(srv.URL + "/api/v1/receipts" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out []receipt.Receipt
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if all := get(""); len(all) != 2 || all[0].SessionID != "r-1" {
+		t.Fatalf("all receipts = %+v", all)
+	}
+	if bySender := get("?session_id=s-r-2"); len(bySender) != 1 || bySender[0].SessionID != "r-2" {
+		t.Fatalf("receipts by sender session = %+v", bySender)
+	}
+	if byHash := get("?file_hash=hash-r-1"); len(byHash) != 1 || byHash[0].Verify(nil) != nil {
+		t.Fatalf("receipts by hash = %+v", byHash)
+	}
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+)
+
+// RelayConfig is the forwarding configuration set for a relay on the
+// orchestrator, so that a fleet of relays can be managed in one place.
+// Relays started to follow it fetch it with each heartbeat; what it leaves
+// empty keeps the relay's own settings.
+type RelayConfig struct {
+	RelayID string `json:"relay_id"`
+	// ForwardAddress replaces the relay's forward address: where a
+	// forwarding relay sends sessions without a route, or where a gateway
+	// re-originates sessions.
+	ForwardAddress string `json:"forward_address,omitempty"`
+	// Via replaces the hops a forwarding relay chains routed sessions
+	// through.
+	Via []string `json:"via,omitempty"`
+	// Filter replaces the relay's allow and deny rules.
+	Filter    *ipfilter.Rules `json:"filter,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// validate checks the addresses and rules of c.
+func (c *RelayConfig) validate() error {
+	for _, addr := range append([]string{c.ForwardAddress}, c.Via...) {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+	}
+	if c.Filter != nil {
+		if _, err := ipfilter.New(*c.Filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleRelayConfig handles /api/v1/relays/{id}/config: GET returns the
+// configuration set for the relay, which need not be registered yet, PUT
+// sets it and DELETE removes it. Setting and removing take the admin scope.
+func (s *Service) handleRelayConfig(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		c, ok := s.relayConfigs[id]
+		s.mu.RUnlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configuration set for relay"})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodPut:
+		s.require(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) { s.putRelayConfig(w, r, id) })(w, r)
+	case http.MethodDelete:
+		s.require(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) { s.deleteRelayConfig(w, id) })(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) putRelayConfig(w http.ResponseWriter, r *http.Request, id string) {
+	var c RelayConfig
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := c.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	c.RelayID, c.UpdatedAt = id, time.Now()
+
+	s.mu.Lock()
+	err := s.store.SaveRelayConfig(&c)
+	if err == nil {
+		s.relayConfigs[id] = &c
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Relay %s configuration set", id)
+	writeJSON(w, http.StatusOK, &c)
+}
+
+func (s *Service) deleteRelayConfig(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	_, ok := s.relayConfigs[id]
+	var err error
+	if ok {
+		if err = s.store.DeleteRelayConfig(id); err == nil {
+			delete(s.relayConfigs, id)
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configuration set for relay"})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("Relay %s configuration removed", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,89 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+)
+
+func TestRelayConfig(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*Service, *httptest.Server) {
+		t.Helper()
+		store, err := OpenBoltStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewServiceWithStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AdminToken = "admin-secret"
+		mux := http.NewServeMux()
+		s.RegisterRoutes(mux)
+		return s, httptest.NewServer(mux)
+	}
+	s, srv := open()
+	do := func(token, method string, body any) *http.Response {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+"/api/v1/relays/edge-1/config", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if code := do("admin-secret", http.MethodGet, nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("config before any is set = %d, want 404", code)
+	}
+	cfg := RelayConfig{ForwardAddress: "10.0.0.9:9090", Via: []string{"relay-b:9001"}, Filter: &ipfilter.Rules{Allow: []string{"10.0.0.0/8"}}}
+	if code := do("", http.MethodPut, cfg).StatusCode; code != http.StatusUnauthorized {
+		t.Fatalf("set without a token = %d, want 401", code)
+	}
+	if code := do("admin-secret", http.MethodPut, RelayConfig{ForwardAddress: "no-port"}).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("set with a bad address = %d, want 400", code)
+	}
+	if code := do("admin-secret", http.MethodPut, RelayConfig{Filter: &ipfilter.Rules{Deny: []string{"not-a-cidr"}}}).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("set with bad rules = %d, want 400", code)
+	}
+	if code := do("admin-secret", http.MethodPut, cfg).StatusCode; code != http.StatusOK {
+		t.Fatalf("set = %d", code)
+	}
+
+	// The configuration survives a restart.
+	srv.Close()
+	s.Close()
+	s, srv = open()
+	defer s.Close()
+	defer srv.Close()
+	resp := do("admin-secret", http.MethodGet, nil)
+	var got RelayConfig
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.RelayID != "edge-1" || got.ForwardAddress != cfg.ForwardAddress || !slices.Equal(got.Via, cfg.Via) || got.Filter == nil {
+		t.Fatalf("config = %+v", got)
+	}
+
+	if code := do("admin-secret", http.MethodDelete, nil).StatusCode; code != http.StatusNoContent {
+		t.Fatalf("delete = %d, want 204", code)
+	}
+	if code := do("admin-secret", http.MethodGet, nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("config after delete = %d, want 404", code)
+	}
+}
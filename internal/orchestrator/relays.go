@@ -0,0 +1,122 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// relayHealthyWithin is how recently a relay must have been heard from to
+// be healthy: two missed heartbeats, with some slack, make it stale. Relays
+// silent for relayTTL are expired.
+const relayHealthyWithin = 70 * time.Second
+
+// RelayHealth is how recently a relay was heard from.
+type RelayHealth string
+
+const (
+	RelayHealthy RelayHealth = "healthy"
+	// RelayStale relays missed heartbeats; they are left out of scaling
+	// recommendations and expired once silent for relayTTL.
+	RelayStale RelayHealth = "stale"
+)
+
+// RelayMode is how a relay carries traffic.
+type RelayMode string
+
+const (
+	// RelayForward relays UDP packets untouched. Relays that registered
+	// without a mode are forwarders.
+	RelayForward RelayMode = "forward"
+	// RelayGateway terminates TCP sessions and re-originates them; it is
+	// what senders connect through.
+	RelayGateway RelayMode = "gateway"
+)
+
+// health returns r's health as of now.
+func (r *RelayInfo) health(now time.Time) RelayHealth {
+	if now.Sub(r.LastSeen) <= relayHealthyWithin {
+		return RelayHealthy
+	}
+	return RelayStale
+}
+
+// expireRelaysLocked forgets the relays not heard from within relayTTL of
+// now. s.mu must be held for writing.
+func (s *Service) expireRelaysLocked(now time.Time) {
+	for id, r := range s.relays {
+		if now.Sub(r.LastSeen) <= relayTTL {
+			continue
+		}
+		if err := s.store.DeleteRelay(id); err != nil {
+			log.Printf("expire relay %s: %v", id, err)
+			continue
+		}
+		delete(s.relays, id)
+		log.Printf("Relay %s expired (last seen %s)", id, r.LastSeen.Format(time.RFC3339))
+	}
+}
+
+// handleRelay handles POST /api/v1/relays/{id}/heartbeat, with which a
+// registered relay reports its load, GET /api/v1/relays/{id}/routes and
+// /api/v1/relays/{id}/config. An unknown or expired relay gets 404 on a
+// heartbeat and must register again.
+func (s *Service) handleRelay(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/relays/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "heartbeat":
+	case "routes":
+		s.handleRelayRoutes(w, r, parts[0])
+		return
+	case "config":
+		s.handleRelayConfig(w, r, parts[0])
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Load RelayLoad `json:"load"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.expireRelaysLocked(now)
+	cur, ok := s.relays[parts[0]]
+	var info RelayInfo
+	var err error
+	if ok {
+		info = *cur
+		info.Load, info.LastSeen = req.Load, now
+		if err = s.store.SaveRelay(&info); err == nil {
+			s.relays[info.ID] = &info
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "relay not registered"})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	s.notifyScaling(now)
+	reply := info
+	reply.Health = RelayHealthy
+	writeJSON(w, http.StatusOK, &reply)
+}
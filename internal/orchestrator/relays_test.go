@@ -0,0 +1,85 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelayHeartbeatAndExpiry(t *testing.T) {
+	s := NewService()
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(path string, v any) (int, RelayInfo) {
+		t.Helper()
+		body, _ := json.Marshal(v)
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var info RelayInfo
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, info
+	}
+	list := func() map[string]RelayInfo {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/relays")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var relays []RelayInfo
+		if err := json.NewDecoder(resp.Body).Decode(&relays); err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]RelayInfo)
+		for _, r := range relays {
+			out[r.ID] = r
+		}
+		return out
+	}
+	heartbeat := map[string]any{"load": RelayLoad{PacketsPerSec: 10, BytesPerSec: 1000, LatencyMs: 0.25}}
+
+	if code, _ := post("/api/v1/relays/r1/heartbeat", heartbeat); code != http.StatusNotFound {
+		t.Fatalf("heartbeat before registering = %d, want 404", code)
+	}
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if code, _ := post("/api/v1/relays/register", map[string]string{"id": id, "address": id + ":9000"}); code != http.StatusOK {
+			t.Fatalf("register %s = %d", id, code)
+		}
+	}
+	code, info := post("/api/v1/relays/r1/heartbeat", heartbeat)
+	if code != http.StatusOK || info.Load.LatencyMs != 0.25 || info.Address != "r1:9000" || info.Health != RelayHealthy {
+		t.Fatalf("heartbeat = %d %+v", code, info)
+	}
+
+	// Age r2 past the missed heartbeats and r3 past the TTL.
+	now := time.Now()
+	s.mu.Lock()
+	s.relays["r2"].LastSeen = now.Add(-relayHealthyWithin - time.Second)
+	s.relays["r3"].LastSeen = now.Add(-relayTTL - time.Second)
+	s.mu.Unlock()
+
+	relays := list()
+	if relays["r1"].Health != RelayHealthy || relays["r2"].Health != RelayStale {
+		t.Fatalf("health = %q, %q", relays["r1"].Health, relays["r2"].Health)
+	}
+	if _, ok := relays["r3"]; ok || len(relays) != 2 {
+		t.Fatalf("expired relay still listed: %v", relays)
+	}
+	// An expired relay is told to register again.
+	if code, _ := post("/api/v1/relays/r3/heartbeat", heartbeat); code != http.StatusNotFound {
+		t.Fatalf("heartbeat after expiry = %d, want 404", code)
+	}
+}
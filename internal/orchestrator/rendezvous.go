@@ -0,0 +1,113 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rendezvousTTL is how long a rendezvous registration stays valid. Parties
+// re-register while they wait for each other.
+const rendezvousTTL = 2 * time.Minute
+
+// Rendezvous roles.
+const (
+	RoleSender   = "sender"
+	RoleReceiver = "receiver"
+)
+
+// RendezvousParty is one side of a rendezvous between a sender and a
+// receiver that punch a hole through the NATs between them.
+type RendezvousParty struct {
+	Role string `json:"role"`
+	// Candidates are the UDP addresses the party can be punched to: its
+	// public endpoint as seen by the reflector, then its local addresses.
+	Candidates []string `json:"candidates"`
+	// Port is the port a receiver accepts transfers on.
+	Port     int       `json:"port,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// RendezvousReply answers a rendezvous registration with the other party,
+// once it has registered too.
+type RendezvousReply struct {
+	Peer *RendezvousParty `json:"peer,omitempty"`
+}
+
+// RendezvousInfo tells parties where the reflector that reports their
+// public UDP endpoint listens, on the orchestrator's host.
+type RendezvousInfo struct {
+	// ReflectorPort is zero if the orchestrator runs no reflector.
+	ReflectorPort int `json:"reflector_port"`
+}
+
+// handleRendezvousInfo handles GET /api/v1/rendezvous
+func (s *Service) handleRendezvousInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, RendezvousInfo{ReflectorPort: s.ReflectorPort})
+}
+
+// handleRendezvous handles POST /api/v1/rendezvous/{id}, registering one
+// party and replying with the other if it has registered.
+func (s *Service) handleRendezvous(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/rendezvous/")
+	if id == "" || strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var req RendezvousParty
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	var other string
+	switch req.Role {
+	case RoleSender:
+		other = RoleReceiver
+	case RoleReceiver:
+		other = RoleSender
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `role must be "sender" or "receiver"`})
+		return
+	}
+	if len(req.Candidates) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no candidates"})
+		return
+	}
+	now := time.Now()
+	req.LastSeen = now
+
+	s.mu.Lock()
+	for rid, parties := range s.rendezvous {
+		for role, p := range parties {
+			if now.Sub(p.LastSeen) > rendezvousTTL {
+				delete(parties, role)
+			}
+		}
+		if len(parties) == 0 {
+			delete(s.rendezvous, rid)
+		}
+	}
+	parties := s.rendezvous[id]
+	if parties == nil {
+		parties = make(map[string]*RendezvousParty)
+		s.rendezvous[id] = parties
+	}
+	parties[req.Role] = &req
+	var reply RendezvousReply
+	if p := parties[other]; p != nil {
+		peer := *p
+		reply.Peer = &peer
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, reply)
+}
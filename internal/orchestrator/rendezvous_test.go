@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRendezvousExchangesCandidates(t *testing.T) {
+	s := NewService()
+	s.ReflectorPort = 3478
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	register := func(id string, p RendezvousParty) (int, RendezvousReply) {
+		t.Helper()
+		body, _ := json.Marshal(p)
+		resp, err := http.Post(srv.URL+"/api/v1/rendezvous/"+id, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var reply RendezvousReply
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, reply
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/rendezvous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info RendezvousInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if info.ReflectorPort != 3478 {
+		t.Errorf("reflector port %d, want 3478", info.ReflectorPort)
+	}
+
+	recv := RendezvousParty{Role: RoleReceiver, Candidates: []string{"203.0.113.7:40001", "10.0.0.7:8080"}, Port: 8080}
+	if code, reply := register("rv1", recv); code != http.StatusOK || reply.Peer != nil {
+		t.Fatalf("first registration = %d, %+v; want 200 without a peer", code, reply)
+	}
+	code, reply := register("rv1", RendezvousParty{Role: RoleSender, Candidates: []string{"198.51.100.2:50000"}})
+	if code != http.StatusOK || reply.Peer == nil {
+		t.Fatalf("sender registration = %d, %+v; want the receiver", code, reply)
+	}
+	if reply.Peer.Port != 8080 || len(reply.Peer.Candidates) != 2 || reply.Peer.Candidates[0] != "203.0.113.7:40001" {
+		t.Errorf("peer %+v, want %+v", reply.Peer, recv)
+	}
+	if _, reply := register("rv1", recv); reply.Peer == nil || reply.Peer.Candidates[0] != "198.51.100.2:50000" {
+		t.Errorf("receiver re-registration got peer %+v, want the sender", reply.Peer)
+	}
+	if _, reply := register("rv2", recv); reply.Peer != nil {
+		t.Errorf("peer %+v leaked across rendezvous IDs", reply.Peer)
+	}
+	if code, _ := register("rv1", RendezvousParty{Role: "relay", Candidates: []string{"x:1"}}); code != http.StatusBadRequest {
+		t.Errorf("unknown role = %d, want 400", code)
+	}
+}
@@ -0,0 +1,165 @@
+package orchestrator
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// DefaultRouteRelays is how many relays GET /api/v1/route returns unless
+// asked for another number.
+const DefaultRouteRelays = 3
+
+// RoutePlan is the answer of GET /api/v1/route: the relays to reach region
+// To from region From through, best first. Senders try them in order and
+// connect directly when there are none.
+type RoutePlan struct {
+	From   string      `json:"from,omitempty"`
+	To     string      `json:"to,omitempty"`
+	Relays []RelayInfo `json:"relays"`
+}
+
+// RelayRoute tells a forwarding relay where to send the packets of a
+// session: its receiver.
+type RelayRoute struct {
+	Session     string `json:"session"`
+	Destination string `json:"destination"`
+}
+
+// handleRelayRoutes handles GET /api/v1/relays/{id}/routes: the sessions in
+// flight whose route goes through the relay, as registered by their
+// senders, with their receivers. Forwarding relays poll it to route their
+// packets by session.
+func (s *Service) handleRelayRoutes(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	relay, ok := s.relays[id]
+	out := []RelayRoute{}
+	if ok {
+		for _, sess := range s.sessions {
+			if sess.Status == models.SessionStatusCompleted || sess.Status == models.SessionStatusFailed {
+				continue
+			}
+			if rt := sess.Route; rt != nil && rt.Relay == relay.Address && rt.Receiver != "" {
+				out = append(out, RelayRoute{Session: sess.ID, Destination: rt.Receiver})
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "relay not registered"})
+		return
+	}
+	slices.SortFunc(out, func(a, b RelayRoute) int { return cmp.Compare(a.Session, b.Session) })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// routeRank orders candidate relays for a route. Relays loaded past the
+// scaling policy's ScaleUpAbove go last; then relays in the sender's
+// region come first, as the hop to them is shortest, followed by those in
+// the receiver's, then the rest. Ties go to the lowest reported latency,
+// then the lowest saturation.
+type routeRank struct {
+	overloaded bool
+	region     int
+	latency    float64
+	saturation float64
+	id         string
+}
+
+func (s *Service) rankRelay(r *RelayInfo, from, to string) routeRank {
+	rank := routeRank{region: 2, latency: r.Load.LatencyMs, id: r.ID}
+	switch {
+	case r.Region != "" && r.Region == from:
+		rank.region = 0
+	case r.Region != "" && r.Region == to:
+		rank.region = 1
+	}
+	if sat, ok := r.saturation(); ok {
+		rank.saturation = sat
+		rank.overloaded = sat > s.ScalingPolicy.ScaleUpAbove
+	}
+	return rank
+}
+
+func compareRank(a, b routeRank) int {
+	if a.overloaded != b.overloaded {
+		if a.overloaded {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Or(
+		cmp.Compare(a.region, b.region),
+		cmp.Compare(a.latency, b.latency),
+		cmp.Compare(a.saturation, b.saturation),
+		cmp.Compare(a.id, b.id),
+	)
+}
+
+// planRoute picks up to limit healthy relays, of the given mode if set,
+// for a route from region from to region to.
+func (s *Service) planRoute(from, to string, mode RelayMode, limit int, now time.Time) RoutePlan {
+	type candidate struct {
+		info RelayInfo
+		rank routeRank
+	}
+	s.mu.Lock()
+	s.expireRelaysLocked(now)
+	var candidates []candidate
+	for _, r := range s.relays {
+		if r.Address == "" || r.health(now) != RelayHealthy {
+			continue
+		}
+		if mode != "" && cmp.Or(r.Mode, RelayForward) != mode {
+			continue
+		}
+		info := *r
+		info.Health = RelayHealthy
+		candidates = append(candidates, candidate{info, s.rankRelay(r, from, to)})
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(candidates, func(a, b candidate) int { return compareRank(a.rank, b.rank) })
+	plan := RoutePlan{From: from, To: to, Relays: make([]RelayInfo, 0, min(len(candidates), limit))}
+	for _, c := range candidates[:min(len(candidates), limit)] {
+		plan.Relays = append(plan.Relays, c.info)
+	}
+	return plan
+}
+
+// handleRoute handles GET /api/v1/route?from=&to=&mode=&limit=. from and
+// to are the regions of the sender and receiver, mode keeps only relays of
+// that mode, and limit defaults to DefaultRouteRelays.
+func (s *Service) handleRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	mode := RelayMode(q.Get("mode"))
+	switch mode {
+	case "", RelayForward, RelayGateway:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown relay mode %q", mode)})
+		return
+	}
+	limit := DefaultRouteRelays
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.planRoute(q.Get("from"), q.Get("to"), mode, limit, time.Now()))
+}
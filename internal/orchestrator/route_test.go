@@ -0,0 +1,117 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestRoutePlan(t *testing.T) {
+	s := NewService()
+	now := time.Now()
+	capacity := RelayCapacity{BytesPerSec: 100}
+	for _, r := range []RelayInfo{
+		{ID: "far", Address: "far:1", Region: "ap", Mode: RelayGateway},
+		{ID: "dst", Address: "dst:1", Region: "eu", Mode: RelayGateway},
+		{ID: "src-slow", Address: "src-slow:1", Region: "us", Mode: RelayGateway, Load: RelayLoad{LatencyMs: 5}},
+		{ID: "src-fast", Address: "src-fast:1", Region: "us", Mode: RelayGateway, Load: RelayLoad{LatencyMs: 1}},
+		{ID: "src-busy", Address: "src-busy:1", Region: "us", Mode: RelayGateway, Capacity: capacity, Load: RelayLoad{BytesPerSec: 95}},
+		{ID: "src-udp", Address: "src-udp:1", Region: "us", Load: RelayLoad{LatencyMs: 3}},
+		{ID: "src-stale", Address: "src-stale:1", Region: "us", Mode: RelayGateway, LastSeen: now.Add(-relayHealthyWithin - time.Second)},
+	} {
+		if r.LastSeen.IsZero() {
+			r.LastSeen = now
+		}
+		s.relays[r.ID] = &r
+	}
+	s.ScalingPolicy = DefaultScalingPolicy
+
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	route := func(q url.Values) (int, []string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/route?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var plan RoutePlan
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, r := range plan.Relays {
+			ids = append(ids, r.ID)
+		}
+		return resp.StatusCode, ids
+	}
+
+	code, ids := route(url.Values{"from": {"us"}, "to": {"eu"}, "mode": {"gateway"}, "limit": {"10"}})
+	if want := []string{"src-fast", "src-slow", "dst", "far", "src-busy"}; code != http.StatusOK || !slices.Equal(ids, want) {
+		t.Fatalf("route = %d %v, want %v", code, ids, want)
+	}
+	if _, ids := route(url.Values{"from": {"us"}, "to": {"eu"}}); !slices.Equal(ids, []string{"src-fast", "src-udp", "src-slow"}) {
+		t.Fatalf("default route = %v", ids)
+	}
+	if _, ids := route(url.Values{"mode": {"forward"}}); !slices.Equal(ids, []string{"src-udp"}) {
+		t.Fatalf("forward relays = %v", ids)
+	}
+	for _, bad := range []url.Values{{"mode": {"tunnel"}}, {"limit": {"0"}}} {
+		if code, _ := route(bad); code != http.StatusBadRequest {
+			t.Fatalf("route %v = %d, want 400", bad, code)
+		}
+	}
+}
+
+func TestRelayRoutes(t *testing.T) {
+	s := NewService()
+	s.relays["edge"] = &RelayInfo{ID: "edge", Address: "edge:9001", LastSeen: time.Now()}
+	for _, sess := range []*models.TransferSession{
+		{ID: "b", Status: models.SessionStatusTransferring, Route: &models.Route{Relay: "edge:9001", Receiver: "rx2:9090"}},
+		{ID: "a", Status: models.SessionStatusCreated, Route: &models.Route{Relay: "edge:9001", Receiver: "rx1:9090"}},
+		{ID: "done", Status: models.SessionStatusCompleted, Route: &models.Route{Relay: "edge:9001", Receiver: "rx1:9090"}},
+		{ID: "other", Status: models.SessionStatusTransferring, Route: &models.Route{Relay: "core:9001", Receiver: "rx1:9090"}},
+		{ID: "direct", Status: models.SessionStatusTransferring, Route: &models.Route{Receiver: "rx1:9090"}},
+	} {
+		s.sessions[sess.ID] = sess
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/relays/edge/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var routes []RelayRoute
+	if err := json.NewDecoder(resp.Body).Decode(&routes); err != nil {
+		t.Fatal(err)
+	}
+	want := []RelayRoute{{Session: "a", Destination: "rx1:9090"}, {Session: "b", Destination: "rx2:9090"}}
+	if !slices.Equal(routes, want) {
+		t.Fatalf("routes = %v, want %v", routes, want)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/relays/gone/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("routes of an unknown relay = %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,198 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// relayTTL is how long a relay report stays valid. Relays report every 30
+// seconds while they are up, and are expired once silent for this long.
+const relayTTL = 2 * time.Minute
+
+// RelayCapacity is the traffic a relay advertises it can forward. Zero
+// fields are unknown.
+type RelayCapacity struct {
+	PacketsPerSec float64 `json:"packets_per_sec,omitempty"`
+	BytesPerSec   float64 `json:"bytes_per_sec,omitempty"`
+}
+
+// RelayLoad is the traffic a relay forwarded over its last report interval.
+type RelayLoad struct {
+	PacketsPerSec float64 `json:"packets_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+	// LatencyMs is the mean time a packet spent in the relay, from being
+	// read to being forwarded.
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+}
+
+// saturation returns the larger of the relay's packet and byte load as a
+// fraction of its capacity, and false if it advertised no capacity.
+func (r *RelayInfo) saturation() (float64, bool) {
+	var sat float64
+	known := false
+	if c := r.Capacity.PacketsPerSec; c > 0 {
+		sat, known = max(sat, r.Load.PacketsPerSec/c), true
+	}
+	if c := r.Capacity.BytesPerSec; c > 0 {
+		sat, known = max(sat, r.Load.BytesPerSec/c), true
+	}
+	return sat, known
+}
+
+// ScalingAction is what a deployment should do with a region's relay fleet.
+type ScalingAction string
+
+const (
+	ScaleUp   ScalingAction = "scale_up"
+	ScaleDown ScalingAction = "scale_down"
+	Steady    ScalingAction = "steady"
+)
+
+// ScalingPolicy sets the saturation thresholds for scaling recommendations.
+// Saturation is load as a fraction of advertised capacity.
+type ScalingPolicy struct {
+	// ScaleUpAbove is the mean saturation above which more relays are
+	// recommended.
+	ScaleUpAbove float64
+	// ScaleDownBelow is the mean saturation below which fewer relays are
+	// recommended.
+	ScaleDownBelow float64
+	// Target is the mean saturation the recommended relay count aims for.
+	Target float64
+	// MinRelays is the smallest fleet recommended per region.
+	MinRelays int
+}
+
+// DefaultScalingPolicy scales up above 80% saturation and down below 30%,
+// aiming for 60%.
+var DefaultScalingPolicy = ScalingPolicy{ScaleUpAbove: 0.8, ScaleDownBelow: 0.3, Target: 0.6, MinRelays: 1}
+
+// ScalingRecommendation is the recommended relay count for one region.
+type ScalingRecommendation struct {
+	Region string `json:"region"`
+	// Relays is the number of live relays that advertised a capacity.
+	Relays int `json:"relays"`
+	// Saturation is their mean saturation.
+	Saturation float64       `json:"saturation"`
+	Desired    int           `json:"desired"`
+	Action     ScalingAction `json:"action"`
+}
+
+// recommend computes a recommendation per region from the relays reported
+// within relayTTL of now. Relays that advertised no capacity are skipped.
+func (p ScalingPolicy) recommend(relays []RelayInfo, now time.Time) []ScalingRecommendation {
+	type fleet struct {
+		n   int
+		sum float64
+	}
+	fleets := make(map[string]*fleet)
+	for i := range relays {
+		r := &relays[i]
+		sat, ok := r.saturation()
+		if !ok || now.Sub(r.LastSeen) > relayTTL {
+			continue
+		}
+		f := fleets[r.Region]
+		if f == nil {
+			f = &fleet{}
+			fleets[r.Region] = f
+		}
+		f.n++
+		f.sum += sat
+	}
+
+	out := make([]ScalingRecommendation, 0, len(fleets))
+	for region, f := range fleets {
+		rec := ScalingRecommendation{
+			Region:     region,
+			Relays:     f.n,
+			Saturation: f.sum / float64(f.n),
+			Desired:    f.n,
+			Action:     Steady,
+		}
+		// The load of the whole fleet, in relays' worth of capacity, spread
+		// so that each relay runs at the target.
+		desired := max(int(math.Ceil(f.sum/p.Target)), p.MinRelays)
+		switch {
+		case rec.Saturation > p.ScaleUpAbove && desired > f.n:
+			rec.Desired, rec.Action = desired, ScaleUp
+		case rec.Saturation < p.ScaleDownBelow && desired < f.n:
+			rec.Desired, rec.Action = desired, ScaleDown
+		}
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Region < out[j].Region })
+	return out
+}
+
+// scaling returns the current recommendations.
+func (s *Service) scaling(now time.Time) []ScalingRecommendation {
+	s.mu.RLock()
+	relays := make([]RelayInfo, 0, len(s.relays))
+	for _, r := range s.relays {
+		relays = append(relays, *r)
+	}
+	s.mu.RUnlock()
+	return s.ScalingPolicy.recommend(relays, now)
+}
+
+// handleRelayScaling handles GET /api/v1/relays/scaling
+func (s *Service) handleRelayScaling(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.scaling(time.Now()))
+}
+
+// notifyScaling posts each recommendation whose action changed since the
+// last notification to ScalingWebhook, if set. Regions start out steady.
+func (s *Service) notifyScaling(now time.Time) {
+	if s.ScalingWebhook == "" {
+		return
+	}
+	recs := s.scaling(now)
+	var changed []ScalingRecommendation
+	s.mu.Lock()
+	for _, rec := range recs {
+		last, ok := s.scalingActions[rec.Region]
+		if !ok {
+			last = Steady
+		}
+		if last != rec.Action {
+			s.scalingActions[rec.Region] = rec.Action
+			changed = append(changed, rec)
+		}
+	}
+	s.mu.Unlock()
+	for _, rec := range changed {
+		go func() {
+			if err := s.postScaling(rec); err != nil {
+				log.Printf("scaling webhook for region %q: %v", rec.Region, err)
+			}
+		}()
+	}
+}
+
+// postScaling sends one recommendation to ScalingWebhook.
+func (s *Service) postScaling(rec ScalingRecommendation) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Post(s.ScalingWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func relay(id, region string, capacity, load float64, seen time.Time) RelayInfo {
+	return RelayInfo{
+		ID:       id,
+		Region:   region,
+		Capacity: RelayCapacity{BytesPerSec: capacity},
+		Load:     RelayLoad{BytesPerSec: load},
+		LastSeen: seen,
+	}
+}
+
+func TestScalingRecommend(t *testing.T) {
+	now := time.Now()
+	relays := []RelayInfo{
+		// eu: two relays at 90%, which the target of 60% spreads over three.
+		relay("eu-1", "eu", 100, 90, now),
+		relay("eu-2", "eu", 100, 90, now),
+		// us: three relays at 12.5%, which fit on the minimum of one.
+		relay("us-1", "us", 1000, 125, now),
+		relay("us-2", "us", 1000, 125, now),
+		relay("us-3", "us", 1000, 125, now),
+		// ap: half loaded.
+		relay("ap-1", "ap", 100, 50, now),
+		// Stale and capacity-less relays are left out.
+		relay("ap-2", "ap", 100, 100, now.Add(-2*relayTTL)),
+		relay("ap-3", "ap", 0, 100, now),
+	}
+	got := DefaultScalingPolicy.recommend(relays, now)
+	want := []ScalingRecommendation{
+		{Region: "ap", Relays: 1, Saturation: 0.5, Desired: 1, Action: Steady},
+		{Region: "eu", Relays: 2, Saturation: 0.9, Desired: 3, Action: ScaleUp},
+		{Region: "us", Relays: 3, Saturation: 0.125, Desired: 1, Action: ScaleDown},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("recommendation %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestScalingSaturationUsesBusiestResource(t *testing.T) {
+	r := RelayInfo{
+		Capacity: RelayCapacity{PacketsPerSec: 1000, BytesPerSec: 1 << 20},
+		Load:     RelayLoad{PacketsPerSec: 900, BytesPerSec: 1 << 18},
+	}
+	if sat, ok := r.saturation(); !ok || sat != 0.9 {
+		t.Fatalf("saturation = %v, %v; want 0.9", sat, ok)
+	}
+}
+
+func TestScalingWebhook(t *testing.T) {
+	recs := make(chan ScalingRecommendation, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec ScalingRecommendation
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		recs <- rec
+	}))
+	defer hook.Close()
+
+	s := NewService()
+	s.ScalingWebhook = hook.URL
+	now := time.Now()
+	r := relay("eu-1", "eu", 100, 95, now)
+	s.relays[r.ID] = &r
+
+	s.notifyScaling(now)
+	select {
+	case rec := <-recs:
+		if rec.Region != "eu" || rec.Action != ScaleUp || rec.Desired != 2 {
+			t.Fatalf("webhook got %+v", rec)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	// An unchanged action is not posted again.
+	s.notifyScaling(now)
+	select {
+	case rec := <-recs:
+		t.Fatalf("webhook called again with %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -9,38 +9,145 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
-// Service implements a minimal in-memory orchestrator.
+// Service implements a minimal orchestrator. Its records are held in
+// memory and, when it has a persistent Store, saved there as they change.
 type Service struct {
 	mu       sync.RWMutex
+	store    Store
 	sessions map[string]*models.TransferSession
 	relays   map[string]*RelayInfo
+	nodes    map[string]*NodeInfo
+	// receipts holds the delivery receipts posted by receivers, keyed by
+	// receiver and session.
+	receipts map[string]*receipt.Receipt
+	// tokens holds the API tokens issued, by ID.
+	tokens map[string]*APIToken
+	// relayConfigs holds the configurations set for relays, by relay ID.
+	relayConfigs map[string]*RelayConfig
+	// watchers holds the event streams open per session.
+	watchers map[string]map[chan SessionEvent]struct{}
+	// rendezvous holds the parties registered per rendezvous ID, by role.
+	// They are short-lived and not stored.
+	rendezvous map[string]map[string]*RendezvousParty
+
+	// httpClient is used to ask nodes to serve files they hold.
+	httpClient *http.Client
+
+	// AdminToken, if set, is required, or a token issued with it, for
+	// every API request; see Scope. Without it the API is open and no
+	// tokens can be issued.
+	AdminToken string
+
+	// ScalingPolicy sets when relay fleets should grow or shrink.
+	ScalingPolicy ScalingPolicy
+	// ScalingWebhook, if set, receives a POST with a ScalingRecommendation
+	// whenever the recommended action for a region changes.
+	ScalingWebhook string
+	// scalingActions is the action last sent to the webhook per region.
+	scalingActions map[string]ScalingAction
+
+	// ReflectorPort is the UDP port on this host where a reflector, see
+	// transport.ServeReflector, tells rendezvous parties their public
+	// endpoint; zero if none runs.
+	ReflectorPort int
 }
 
 // RelayInfo holds basic information about a registered relay.
 type RelayInfo struct {
-	ID        string    `json:"id"`
-	Address   string    `json:"address"`
-	Region    string    `json:"region,omitempty"`
-	LastSeen  time.Time `json:"last_seen"`
+	ID       string    `json:"id"`
+	Address  string    `json:"address"`
+	Region   string    `json:"region,omitempty"`
+	Mode     RelayMode `json:"mode,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// Capacity is advertised by the relay; Load is what it reported
+	// forwarding in its last heartbeat.
+	Capacity RelayCapacity `json:"capacity"`
+	Load     RelayLoad     `json:"load"`
+
+	// Health is set in replies from LastSeen; it is not stored.
+	Health RelayHealth `json:"health,omitempty"`
 }
 
-// NewService creates a new orchestrator Service.
+// NewService creates a new orchestrator Service that keeps its records in
+// memory only.
 func NewService() *Service {
+	return newService(memoryStore{})
+}
+
+// NewServiceWithStore creates a Service that persists its records in store,
+// starting from the records store already holds. Sessions that were still
+// in flight when the last run stopped are kept in their recorded status,
+// so their senders and receivers can carry on reporting against them.
+func NewServiceWithStore(store Store) (*Service, error) {
+	recs, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range recs.Skipped {
+		log.Printf("orchestrator store: skipping unreadable record %s", key)
+	}
+	s := newService(store)
+	s.sessions, s.relays, s.nodes, s.receipts, s.tokens = recs.Sessions, recs.Relays, recs.Nodes, recs.Receipts, recs.Tokens
+	s.relayConfigs = recs.RelayConfigs
+	inFlight := 0
+	for _, sess := range s.sessions {
+		if sess.Status != models.SessionStatusCompleted && sess.Status != models.SessionStatusFailed {
+			inFlight++
+		}
+	}
+	log.Printf("orchestrator store: recovered %d sessions (%d in flight), %d relays, %d nodes, %d receipts, %d API tokens, %d relay configs",
+		len(s.sessions), inFlight, len(s.relays), len(s.nodes), len(s.receipts), len(s.tokens), len(s.relayConfigs))
+	return s, nil
+}
+
+func newService(store Store) *Service {
 	return &Service{
+		store:    store,
 		sessions: make(map[string]*models.TransferSession),
 		relays:   make(map[string]*RelayInfo),
+		nodes:    make(map[string]*NodeInfo),
+		receipts: make(map[string]*receipt.Receipt),
+		tokens:   make(map[string]*APIToken),
+		watchers: make(map[string]map[chan SessionEvent]struct{}),
+
+		relayConfigs: make(map[string]*RelayConfig),
+		rendezvous:   make(map[string]map[string]*RendezvousParty),
+
+		httpClient:     timeouts.Defaults().HTTPClient(),
+		ScalingPolicy:  DefaultScalingPolicy,
+		scalingActions: make(map[string]ScalingAction),
 	}
 }
 
+// Close releases the Service's store.
+func (s *Service) Close() error {
+	return s.store.Close()
+}
+
 // RegisterRoutes registers HTTP handlers on the given mux.
 func (s *Service) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/api/v1/session", s.handleSessionCreate)
-	mux.HandleFunc("/api/v1/session/", s.handleSessionGet)
-	mux.HandleFunc("/api/v1/relays/register", s.handleRelayRegister)
-	mux.HandleFunc("/api/v1/relays", s.handleRelaysList)
+	mux.HandleFunc("/api/v1/session", s.require(ScopeClient, s.handleSessionCreate))
+	mux.HandleFunc("/api/v1/session/", s.require(ScopeClient, s.handleSession))
+	mux.HandleFunc("/api/v1/sessions", s.require(ScopeClient, s.handleSessionsList))
+	mux.HandleFunc("/api/v1/relays/register", s.require(ScopeRelay, s.handleRelayRegister))
+	mux.HandleFunc("/api/v1/relays", s.require(ScopeClient, s.handleRelaysList))
+	mux.HandleFunc("/api/v1/relays/", s.require(ScopeRelay, s.handleRelay))
+	mux.HandleFunc("/api/v1/relays/scaling", s.require(ScopeClient, s.handleRelayScaling))
+	mux.HandleFunc("/api/v1/route", s.require(ScopeClient, s.handleRoute))
+	mux.HandleFunc("/api/v1/nodes/register", s.require(ScopeClient, s.handleNodeRegister))
+	mux.HandleFunc("/api/v1/nodes", s.require(ScopeClient, s.handleNodesList))
+	mux.HandleFunc("/api/v1/transfers", s.require(ScopeClient, s.handleTransferRequest))
+	mux.HandleFunc("/api/v1/rendezvous", s.require(ScopeClient, s.handleRendezvousInfo))
+	mux.HandleFunc("/api/v1/rendezvous/", s.require(ScopeClient, s.handleRendezvous))
+	mux.HandleFunc("/api/v1/receipts", s.require(ScopeClient, s.handleReceipts))
+	mux.HandleFunc("/api/v1/tokens", s.require(ScopeAdmin, s.handleTokens))
+	mux.HandleFunc("/api/v1/tokens/", s.require(ScopeAdmin, s.handleToken))
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -51,14 +158,17 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	}
 }
 
-// handleSessionCreate handles POST /api/v1/session
+// handleSessionCreate handles POST /api/v1/session. A sender may pass the
+// ID of its own session as "id" so both sides name it alike.
 func (s *Service) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 	var req struct {
-		File models.FileMetadata `json:"file"`
+		ID    string                `json:"id"`
+		File  models.FileMetadata   `json:"file"`
+		Files []models.FileMetadata `json:"files"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -69,38 +179,93 @@ func (s *Service) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.NewString()
+	id := req.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
 	now := time.Now()
 	sess := &models.TransferSession{
 		ID:        id,
 		File:      req.File,
+		Files:     req.Files,
 		Status:    models.SessionStatusCreated,
 		Chunks:    make(map[string]*models.ChunkMetadata),
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
+	if err := sess.ValidateFiles(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
 
 	s.mu.Lock()
-	s.sessions[id] = sess
+	_, exists := s.sessions[id]
+	var err error
+	if !exists {
+		if err = s.store.SaveSession(sess); err == nil {
+			s.sessions[id] = sess
+		}
+	}
 	s.mu.Unlock()
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "session " + id + " already exists"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
 
 	writeJSON(w, http.StatusCreated, sess)
 }
 
-// handleSessionGet handles GET /api/v1/session/:id
-func (s *Service) handleSessionGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-	// url path: /api/v1/session/{id}
+// handleSession handles the requests on one session:
+//
+//	GET    /api/v1/session/{id}
+//	PATCH  /api/v1/session/{id}          status and progress, from its endpoints
+//	DELETE /api/v1/session/{id}
+//	POST   /api/v1/session/{id}/pause
+//	POST   /api/v1/session/{id}/resume
+//	POST   /api/v1/session/{id}/cancel
+//	GET    /api/v1/session/{id}/events   server-sent events as it changes
+func (s *Service) handleSession(w http.ResponseWriter, r *http.Request) {
+	// url path: /api/v1/session/{id}[/action]
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/session/"), "/")
-	if len(parts) < 1 || parts[0] == "" {
+	if len(parts) > 2 || parts[0] == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	id := parts[0]
+	if len(parts) == 2 && parts[1] == "events" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSessionEvents(w, r, id)
+		return
+	}
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleSessionControl(w, id, parts[1])
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.handleSessionGet(w, id)
+	case http.MethodPatch:
+		s.handleSessionUpdate(w, r, id)
+	case http.MethodDelete:
+		s.handleSessionDelete(w, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
 
+// handleSessionGet handles GET /api/v1/session/{id}
+func (s *Service) handleSessionGet(w http.ResponseWriter, id string) {
 	s.mu.RLock()
 	sess, ok := s.sessions[id]
 	s.mu.RUnlock()
@@ -111,16 +276,20 @@ func (s *Service) handleSessionGet(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, sess)
 }
 
-// handleRelayRegister handles POST /api/v1/relays/register
+// handleRelayRegister handles POST /api/v1/relays/register. Relays register
+// on start, and again whenever a heartbeat finds them unknown.
 func (s *Service) handleRelayRegister(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 	var req struct {
-		ID      string `json:"id"`
-		Address string `json:"address"`
-		Region  string `json:"region,omitempty"`
+		ID       string        `json:"id"`
+		Address  string        `json:"address"`
+		Region   string        `json:"region,omitempty"`
+		Mode     RelayMode     `json:"mode,omitempty"`
+		Capacity RelayCapacity `json:"capacity"`
+		Load     RelayLoad     `json:"load"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -130,19 +299,37 @@ func (s *Service) handleRelayRegister(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	switch req.Mode {
+	case "", RelayForward, RelayGateway:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	info := &RelayInfo{
 		ID:       req.ID,
 		Address:  req.Address,
 		Region:   req.Region,
+		Mode:     req.Mode,
 		LastSeen: time.Now(),
+		Capacity: req.Capacity,
+		Load:     req.Load,
 	}
 
 	s.mu.Lock()
+	s.expireRelaysLocked(info.LastSeen)
 	s.relays[req.ID] = info
+	err := s.store.SaveRelay(info)
 	s.mu.Unlock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	s.notifyScaling(info.LastSeen)
 
-	writeJSON(w, http.StatusOK, info)
+	reply := *info
+	reply.Health = RelayHealthy
+	writeJSON(w, http.StatusOK, &reply)
 }
 
 // handleRelaysList handles GET /api/v1/relays
@@ -151,12 +338,16 @@ func (s *Service) handleRelaysList(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	s.mu.RLock()
-	out := make([]*RelayInfo, 0, len(s.relays))
+	now := time.Now()
+	s.mu.Lock()
+	s.expireRelaysLocked(now)
+	out := make([]RelayInfo, 0, len(s.relays))
 	for _, v := range s.relays {
-		out = append(out, v)
+		info := *v
+		info.Health = info.health(now)
+		out = append(out, info)
 	}
-	s.mu.RUnlock()
+	s.mu.Unlock()
 	writeJSON(w, http.StatusOK, out)
 }
 
@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Page sizes of GET /api/v1/sessions.
+const (
+	DefaultSessionPage = 50
+	MaxSessionPage     = 500
+)
+
+// SessionPage is one page of GET /api/v1/sessions. NextCursor, if set,
+// fetches the page after it.
+type SessionPage struct {
+	Sessions   []*models.TransferSession `json:"sessions"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+// sessionCursor is the position after the last session of a page. Sessions
+// are listed newest first, by creation time and then ID, so a cursor stays
+// valid as sessions are added or deleted.
+type sessionCursor struct {
+	created time.Time
+	id      string
+}
+
+func (c sessionCursor) String() string {
+	raw := strconv.FormatInt(c.created.UnixNano(), 10) + ":" + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseSessionCursor(s string) (sessionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return sessionCursor{}, fmt.Errorf("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return sessionCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return sessionCursor{created: time.Unix(0, n), id: id}, nil
+}
+
+// sessionBefore reports whether a sorts before b, newest first.
+func sessionBefore(a, b sessionCursor) bool {
+	if !a.created.Equal(b.created) {
+		return a.created.After(b.created)
+	}
+	return a.id > b.id
+}
+
+func cursorOf(sess *models.TransferSession) sessionCursor {
+	return sessionCursor{created: sess.CreatedAt, id: sess.ID}
+}
+
+// handleSessionsList handles GET /api/v1/sessions?status=&limit=&cursor=.
+// status is a comma-separated list of statuses to keep; limit defaults to
+// DefaultSessionPage and is capped at MaxSessionPage.
+func (s *Service) handleSessionsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	var statuses []models.SessionStatus
+	if v := q.Get("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			status := models.SessionStatus(strings.TrimSpace(st))
+			switch status {
+			case models.SessionStatusCreated, models.SessionStatusTransferring, models.SessionStatusPaused,
+				models.SessionStatusCompleted, models.SessionStatusFailed:
+				statuses = append(statuses, status)
+			default:
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown session status %q", st)})
+				return
+			}
+		}
+	}
+	limit := DefaultSessionPage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, MaxSessionPage)
+	}
+	var after *sessionCursor
+	if v := q.Get("cursor"); v != "" {
+		c, err := parseSessionCursor(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		after = &c
+	}
+
+	s.mu.RLock()
+	matched := make([]*models.TransferSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if len(statuses) > 0 && !slices.Contains(statuses, sess.Status) {
+			continue
+		}
+		if after != nil && !sessionBefore(*after, cursorOf(sess)) {
+			continue
+		}
+		matched = append(matched, sess)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(matched, func(a, b *models.TransferSession) int {
+		switch {
+		case sessionBefore(cursorOf(a), cursorOf(b)):
+			return -1
+		case sessionBefore(cursorOf(b), cursorOf(a)):
+			return 1
+		}
+		return 0
+	})
+	page := SessionPage{Sessions: matched}
+	if len(matched) > limit {
+		page.Sessions = matched[:limit]
+		page.NextCursor = cursorOf(matched[limit-1]).String()
+	}
+	writeJSON(w, http.StatusOK, page)
+}
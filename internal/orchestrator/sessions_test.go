@@ -0,0 +1,88 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSessionsList(t *testing.T) {
+	s := NewService()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	statuses := []models.SessionStatus{
+		models.SessionStatusCompleted, models.SessionStatusTransferring, models.SessionStatusFailed,
+		models.SessionStatusTransferring, models.SessionStatusPaused,
+	}
+	for i, st := range statuses {
+		id := fmt.Sprintf("s%d", i)
+		s.sessions[id] = &models.TransferSession{ID: id, Status: st, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+	}
+	// Sessions created at the same instant are ordered by ID.
+	s.sessions["s5"] = &models.TransferSession{ID: "s5", Status: models.SessionStatusCompleted, CreatedAt: base.Add(4 * time.Minute)}
+
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	list := func(q url.Values) (int, SessionPage) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/sessions?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var page SessionPage
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, page
+	}
+	ids := func(page SessionPage) []string {
+		var out []string
+		for _, sess := range page.Sessions {
+			out = append(out, sess.ID)
+		}
+		return out
+	}
+
+	var all []string
+	q := url.Values{"limit": {"2"}}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("paging does not end")
+		}
+		code, page := list(q)
+		if code != http.StatusOK {
+			t.Fatalf("list = %d", code)
+		}
+		all = append(all, ids(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+	if want := []string{"s5", "s4", "s3", "s2", "s1", "s0"}; !slices.Equal(all, want) {
+		t.Fatalf("paged sessions = %v, want %v", all, want)
+	}
+
+	code, page := list(url.Values{"status": {"transferring,paused"}})
+	if want := []string{"s4", "s3", "s1"}; code != http.StatusOK || !slices.Equal(ids(page), want) || page.NextCursor != "" {
+		t.Fatalf("filtered = %d %v next %q, want %v", code, ids(page), page.NextCursor, want)
+	}
+
+	for _, bad := range []url.Values{{"status": {"running"}}, {"limit": {"0"}}, {"cursor": {"!!"}}} {
+		if code, _ := list(bad); code != http.StatusBadRequest {
+			t.Fatalf("list %v = %d, want 400", bad, code)
+		}
+	}
+}
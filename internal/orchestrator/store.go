@@ -0,0 +1,335 @@
+package orchestrator
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists the orchestrator's sessions, relays, nodes, receipts, API
+// tokens and relay configurations, so a restart picks up where the last run
+// stopped. Records are saved whole each time they change.
+type Store interface {
+	// Load reads every record held.
+	Load() (*Records, error)
+	SaveSession(s *models.TransferSession) error
+	DeleteSession(id string) error
+	SaveRelay(r *RelayInfo) error
+	DeleteRelay(id string) error
+	SaveNode(n *NodeInfo) error
+	// SaveReceipt saves r under key, its receiver and session.
+	SaveReceipt(key string, r *receipt.Receipt) error
+	SaveToken(t *APIToken) error
+	DeleteToken(id string) error
+	SaveRelayConfig(c *RelayConfig) error
+	DeleteRelayConfig(relayID string) error
+	// Close releases the store.
+	Close() error
+}
+
+// Records are the contents of a Store.
+type Records struct {
+	Sessions map[string]*models.TransferSession
+	Relays   map[string]*RelayInfo
+	Nodes    map[string]*NodeInfo
+	Receipts map[string]*receipt.Receipt
+	Tokens   map[string]*APIToken
+	// RelayConfigs holds the relay configurations, by relay ID.
+	RelayConfigs map[string]*RelayConfig
+	// Skipped lists the records that could not be decoded, as
+	// bucket/key, so one damaged record does not keep the rest from
+	// loading.
+	Skipped []string
+}
+
+func newRecords() *Records {
+	return &Records{
+		Sessions: make(map[string]*models.TransferSession),
+		Relays:   make(map[string]*RelayInfo),
+		Nodes:    make(map[string]*NodeInfo),
+		Receipts: make(map[string]*receipt.Receipt),
+		Tokens:   make(map[string]*APIToken),
+
+		RelayConfigs: make(map[string]*RelayConfig),
+	}
+}
+
+// memoryStore keeps nothing: records live only in the Service's maps.
+type memoryStore struct{}
+
+func (memoryStore) Load() (*Records, error)                    { return newRecords(), nil }
+func (memoryStore) SaveSession(*models.TransferSession) error  { return nil }
+func (memoryStore) DeleteSession(string) error                 { return nil }
+func (memoryStore) SaveRelay(*RelayInfo) error                 { return nil }
+func (memoryStore) DeleteRelay(string) error                   { return nil }
+func (memoryStore) SaveNode(*NodeInfo) error                   { return nil }
+func (memoryStore) SaveReceipt(string, *receipt.Receipt) error { return nil }
+func (memoryStore) SaveToken(*APIToken) error                  { return nil }
+func (memoryStore) DeleteToken(string) error                   { return nil }
+func (memoryStore) SaveRelayConfig(*RelayConfig) error         { return nil }
+func (memoryStore) DeleteRelayConfig(string) error             { return nil }
+func (memoryStore) Close() error                               { return nil }
+
+// boltFile is the name of the orchestrator database in its data directory.
+const boltFile = "orchestrator.db"
+
+// StoreSchemaVersion is the layout of the database written by this build.
+// Databases from older releases are migrated when opened.
+//
+// Schema history:
+//
+//	0  empty database
+//	1  sessions, relays, nodes and receipts buckets of JSON records
+//	2  tokens bucket of API tokens
+//	3  relayconfigs bucket of relay configurations
+const StoreSchemaVersion = 3
+
+// ErrStoreTooNew is returned for a database written by a newer release. It
+// is left untouched so that release can still use it.
+var ErrStoreTooNew = errors.New("orchestrator database written by a newer release")
+
+// Bolt layout: the meta bucket holds the schema version; the others map
+// record IDs to the record encoded as JSON.
+var (
+	metaBucket     = []byte("meta")
+	schemaKey      = []byte("schema")
+	sessionsBucket = []byte("sessions")
+	relaysBucket   = []byte("relays")
+	nodesBucket    = []byte("nodes")
+	receiptsBucket = []byte("receipts")
+	tokensBucket   = []byte("tokens")
+	configsBucket  = []byte("relayconfigs")
+)
+
+// storeMigrations[v] upgrades the database from schema v to v+1.
+var storeMigrations = []func(tx *bolt.Tx) error{
+	0: func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sessionsBucket, relaysBucket, nodesBucket, receiptsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	1: func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	},
+	2: func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(configsBucket)
+		return err
+	},
+}
+
+// BoltStore keeps the orchestrator's records in a bolt database. Every save
+// is a transaction committed to disk.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the database in dir, creating dir if
+// needed, and migrates it to StoreSchemaVersion. The database is locked
+// while open; a second process opening it fails after a second.
+func OpenBoltStore(dir string) (*BoltStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	path := filepath.Join(dir, boltFile)
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open orchestrator store %s: in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open orchestrator store: %w", err)
+	}
+	if err := db.Update(migrateStore); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// migrateStore brings the database up to StoreSchemaVersion.
+func migrateStore(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	var v uint64
+	if data := meta.Get(schemaKey); data != nil {
+		if len(data) != 8 {
+			return fmt.Errorf("orchestrator store: invalid schema version record")
+		}
+		v = binary.BigEndian.Uint64(data)
+	}
+	if v > StoreSchemaVersion {
+		return fmt.Errorf("%w: schema %d, this build reads up to %d", ErrStoreTooNew, v, StoreSchemaVersion)
+	}
+	for ; v < StoreSchemaVersion; v++ {
+		if err := storeMigrations[v](tx); err != nil {
+			return fmt.Errorf("migrate orchestrator store schema %d to %d: %w", v, v+1, err)
+		}
+	}
+	return meta.Put(schemaKey, binary.BigEndian.AppendUint64(nil, v))
+}
+
+// Load implements Store.
+func (b *BoltStore) Load() (*Records, error) {
+	recs := newRecords()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		load := func(bucket []byte, decode func(k string, v []byte) error) error {
+			return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				if err := decode(string(k), v); err != nil {
+					recs.Skipped = append(recs.Skipped, string(bucket)+"/"+string(k))
+				}
+				return nil
+			})
+		}
+		err := load(sessionsBucket, func(k string, v []byte) error {
+			var s models.TransferSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if err := s.Validate(); err != nil {
+				return err
+			}
+			recs.Sessions[k] = &s
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = load(relaysBucket, func(k string, v []byte) error {
+			var r RelayInfo
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			recs.Relays[k] = &r
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = load(nodesBucket, func(k string, v []byte) error {
+			var n NodeInfo
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			recs.Nodes[k] = &n
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = load(receiptsBucket, func(k string, v []byte) error {
+			var r receipt.Receipt
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			recs.Receipts[k] = &r
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = load(tokensBucket, func(k string, v []byte) error {
+			var t APIToken
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if t.Hash == "" {
+				return errors.New("token without a hash")
+			}
+			recs.Tokens[k] = &t
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return load(configsBucket, func(k string, v []byte) error {
+			var c RelayConfig
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			recs.RelayConfigs[k] = &c
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load orchestrator store: %w", err)
+	}
+	return recs, nil
+}
+
+func (b *BoltStore) put(bucket []byte, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// SaveSession implements Store.
+func (b *BoltStore) SaveSession(s *models.TransferSession) error {
+	return b.put(sessionsBucket, s.ID, s)
+}
+
+// DeleteSession implements Store.
+func (b *BoltStore) DeleteSession(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+// SaveRelay implements Store.
+func (b *BoltStore) SaveRelay(r *RelayInfo) error { return b.put(relaysBucket, r.ID, r) }
+
+// DeleteRelay implements Store.
+func (b *BoltStore) DeleteRelay(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(relaysBucket).Delete([]byte(id))
+	})
+}
+
+// SaveNode implements Store.
+func (b *BoltStore) SaveNode(n *NodeInfo) error { return b.put(nodesBucket, n.ID, n) }
+
+// SaveReceipt implements Store.
+func (b *BoltStore) SaveReceipt(key string, r *receipt.Receipt) error {
+	return b.put(receiptsBucket, key, r)
+}
+
+// SaveToken implements Store.
+func (b *BoltStore) SaveToken(t *APIToken) error { return b.put(tokensBucket, t.ID, t) }
+
+// DeleteToken implements Store.
+func (b *BoltStore) DeleteToken(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Delete([]byte(id))
+	})
+}
+
+// SaveRelayConfig implements Store.
+func (b *BoltStore) SaveRelayConfig(c *RelayConfig) error {
+	return b.put(configsBucket, c.RelayID, c)
+}
+
+// DeleteRelayConfig implements Store.
+func (b *BoltStore) DeleteRelayConfig(relayID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(configsBucket).Delete([]byte(relayID))
+	})
+}
+
+// Close implements Store.
+func (b *BoltStore) Close() error { return b.db.Close() }
@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreRecoversAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenBoltStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServiceWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+
+	post := func(path string, v any) *http.Response {
+		body, _ := json.Marshal(v)
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := post("/api/v1/session", map[string]any{
+		"file": models.FileMetadata{ID: "f1", Name: "a.bin", Size: 10, Hash: "h"},
+	})
+	var sess models.TransferSession
+	if err := json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	post("/api/v1/relays/register", map[string]string{"id": "relay-1", "address": "10.0.0.1:9000"}).Body.Close()
+	post("/api/v1/nodes/register", map[string]string{"id": "node-1", "control_url": "http://node-1/"}).Body.Close()
+	srv.Close()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBoltStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s, err = NewServiceWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := s.sessions[sess.ID]
+	if !ok || got.File.Name != "a.bin" || got.Status != models.SessionStatusCreated {
+		t.Fatalf("recovered session = %+v", got)
+	}
+	if r := s.relays["relay-1"]; r == nil || r.Address != "10.0.0.1:9000" {
+		t.Fatalf("recovered relay = %+v", r)
+	}
+	if n := s.nodes["node-1"]; n == nil || n.ControlURL != "http://node-1" {
+		t.Fatalf("recovered node = %+v", n)
+	}
+}
+
+func TestBoltStoreSkipsUnreadableRecords(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenBoltStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &models.TransferSession{ID: "good", File: models.FileMetadata{ID: "f", Name: "f", Size: 1, Hash: "h"}, Status: models.SessionStatusTransferring}
+	if err := store.SaveSession(good); err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte("bad"), []byte("{not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if recs.Sessions["good"] == nil || len(recs.Sessions) != 1 {
+		t.Fatalf("sessions = %v", recs.Sessions)
+	}
+	if len(recs.Skipped) != 1 || recs.Skipped[0] != "sessions/bad" {
+		t.Fatalf("skipped = %v", recs.Skipped)
+	}
+}
+
+func TestBoltStoreRejectsNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenBoltStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(schemaKey, binary.BigEndian.AppendUint64(nil, StoreSchemaVersion+1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if _, err := OpenBoltStore(dir); !errors.Is(err, ErrStoreTooNew) {
+		t.Fatalf("OpenBoltStore = %v, want ErrStoreTooNew", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package pipeline prepares work items on a bounded pool of goroutines ahead
+// of a consumer that must handle them in order, such as a sender encoding
+// chunks while an earlier one is on the wire.
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type result[T any] struct {
+	v   T
+	err error
+}
+
+// Ordered prepares items 0..n-1 concurrently and hands them out in index
+// order. At most workers items are being prepared or waiting to be consumed
+// at any time, which bounds the memory held by prepared items.
+type Ordered[T any] struct {
+	results []chan result[T]
+	slots   chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+
+	dispatched chan struct{} // closed once no more items will be started
+	running    sync.WaitGroup
+	ready      atomic.Int32 // items prepared but not yet consumed
+}
+
+// Start begins preparing n items with prepare on up to workers goroutines
+// (at least one).
+func Start[T any](n, workers int, prepare func(i int) (T, error)) *Ordered[T] {
+	workers = max(workers, 1)
+	p := &Ordered[T]{
+		results: make([]chan result[T], n),
+		slots:   make(chan struct{}, workers),
+		stop:    make(chan struct{}),
+
+		dispatched: make(chan struct{}),
+	}
+	for i := range p.results {
+		p.results[i] = make(chan result[T], 1)
+	}
+	go func() {
+		defer close(p.dispatched)
+		for i := 0; i < n; i++ {
+			select {
+			case p.slots <- struct{}{}:
+			case <-p.stop:
+				return
+			}
+			p.running.Add(1)
+			go func(i int) {
+				defer p.running.Done()
+				v, err := prepare(i)
+				p.ready.Add(1)
+				p.results[i] <- result[T]{v, err}
+			}(i)
+		}
+	}()
+	return p
+}
+
+// Next waits for item i, which must be the item after the one last returned,
+// and releases the slot of that previous item. Items must be consumed in
+// order.
+func (p *Ordered[T]) Next(i int) (T, error) {
+	if i > 0 {
+		<-p.slots
+	}
+	r := <-p.results[i]
+	p.ready.Add(-1)
+	return r.v, r.err
+}
+
+// Ready returns the number of items prepared and waiting to be consumed. A
+// consumer that keeps finding none is waiting on preparation; one that keeps
+// finding every slot filled is the slower stage.
+func (p *Ordered[T]) Ready() int {
+	return int(p.ready.Load())
+}
+
+// Stop ends preparation of further items and waits for those already being
+// prepared, whose results are discarded. Once it returns, prepare is no
+// longer running.
+func (p *Ordered[T]) Stop() {
+	p.once.Do(func() { close(p.stop) })
+	<-p.dispatched
+	p.running.Wait()
+}
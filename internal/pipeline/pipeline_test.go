@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedDeliversInOrder(t *testing.T) {
+	p := Start(20, 4, func(i int) (int, error) {
+		// Later items finish first.
+		time.Sleep(time.Duration(20-i) * 100 * time.Microsecond)
+		return i * i, nil
+	})
+	defer p.Stop()
+	for i := 0; i < 20; i++ {
+		v, err := p.Next(i)
+		if err != nil || v != i*i {
+			t.Fatalf("item %d = %d, %v", i, v, err)
+		}
+	}
+}
+
+func TestOrderedBoundsWorkAhead(t *testing.T) {
+	var started atomic.Int32
+	p := Start(10, 3, func(i int) (int, error) {
+		started.Add(1)
+		return i, nil
+	})
+	defer p.Stop()
+
+	if _, err := p.Next(0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// Item 0 is held by the consumer, so only items 1 and 2 may be prepared.
+	if n := started.Load(); n != 3 {
+		t.Fatalf("%d items prepared while consuming the first, want 3", n)
+	}
+	if _, err := p.Next(1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := started.Load(); n != 4 {
+		t.Fatalf("%d items prepared after consuming two, want 4", n)
+	}
+}
+
+func TestOrderedReportsErrors(t *testing.T) {
+	boom := errors.New("boom")
+	p := Start(3, 2, func(i int) (string, error) {
+		if i == 1 {
+			return "", boom
+		}
+		return "ok", nil
+	})
+	defer p.Stop()
+	if _, err := p.Next(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Next(1); !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+}
+
+func TestStopWaitsForRunningItems(t *testing.T) {
+	var running atomic.Int32
+	release := make(chan struct{})
+	p := Start(5, 2, func(i int) (int, error) {
+		running.Add(1)
+		<-release
+		running.Add(-1)
+		return i, nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	p.Stop()
+	if n := running.Load(); n != 0 {
+		t.Fatalf("%d items still being prepared after Stop", n)
+	}
+}
+
+func TestReadyCountsPreparedItems(t *testing.T) {
+	release := make(chan struct{})
+	p := Start(6, 3, func(i int) (int, error) {
+		if i > 0 {
+			<-release
+		}
+		return i, nil
+	})
+	defer p.Stop()
+	if _, err := p.Next(0); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Ready(); n != 0 {
+		t.Fatalf("Ready = %d while items are being prepared, want 0", n)
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Ready() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Ready = %d, want 2", p.Ready())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := p.Next(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Ready(); n > 2 {
+		t.Fatalf("Ready = %d after consuming one, want at most 2", n)
+	}
+}
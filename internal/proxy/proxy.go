@@ -0,0 +1,275 @@
+// Package proxy makes outbound TCP connections through a SOCKS5 or HTTP
+// CONNECT proxy, with optional authentication, for senders in networks
+// that allow no direct connections out.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContextDialer makes the connection to the proxy itself.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Parse parses a proxy URL: socks5://[user:password@]host:port, which
+// also accepts socks5h, or http://[user:password@]host:port for a proxy
+// that supports CONNECT. The port defaults to 1080 and 8080 respectively.
+func Parse(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %q: %w", raw, err)
+	}
+	var port string
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		port = "1080"
+	case "http":
+		port = "8080"
+	default:
+		return nil, fmt.Errorf("proxy %q: scheme must be socks5, socks5h or http", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %q: no host", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// Dialer connects to addresses through the proxy at URL.
+type Dialer struct {
+	URL *url.URL
+	// Forward connects to the proxy; nil means a zero net.Dialer.
+	Forward ContextDialer
+}
+
+// DialContext connects to address through the proxy. Host names are
+// resolved by the proxy. The handshake with the proxy is bounded by ctx.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("proxy: network %s not supported", network)
+	}
+	forward := d.Forward
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+	conn, err := forward.DialContext(ctx, "tcp", d.URL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", d.URL.Redacted(), err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	raw := conn
+	stop := context.AfterFunc(ctx, func() { raw.SetDeadline(time.Unix(1, 0)) })
+	if d.URL.Scheme == "http" {
+		conn, err = connectHTTP(conn, d.URL, address)
+	} else {
+		err = connectSOCKS5(conn, d.URL, address)
+	}
+	if !stop() {
+		err = errors.Join(err, ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s to %s: %w", d.URL.Redacted(), address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// SOCKS5 protocol values, from RFC 1928 and RFC 1929.
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUserPass     = 2
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+// connectSOCKS5 asks the SOCKS5 proxy on conn to connect to address.
+func connectSOCKS5(conn net.Conn, u *url.URL, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("bad port %q", portStr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if u.User != nil {
+		methods = []byte{socksUserPass}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 proxy (version %d)", reply[0])
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if u.User == nil {
+			return errors.New("proxy requires a user name and password")
+		}
+		name := u.User.Username()
+		pass, _ := u.User.Password()
+		if len(name) > 255 || len(pass) > 255 {
+			return errors.New("proxy user name or password too long")
+		}
+		req := append([]byte{1, byte(len(name))}, name...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("proxy rejected the user name and password")
+		}
+	case socksNoAcceptable:
+		return errors.New("proxy accepts none of the offered authentication methods")
+	default:
+		return fmt.Errorf("proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		req = append(append(req, socksDomain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, socksIPv4), ip4...)
+	} else {
+		req = append(append(req, socksIPv6), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("proxy refused the connection: %s", socksError(head[1]))
+	}
+	// Skip the bound address and port the proxy reports.
+	var skip int
+	switch head[3] {
+	case socksIPv4:
+		skip = net.IPv4len + 2
+	case socksIPv6:
+		skip = net.IPv6len + 2
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("proxy replied with address type %d", head[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip))
+	return err
+}
+
+// socksError describes a SOCKS5 reply code.
+func socksError(code byte) string {
+	switch code {
+	case 1:
+		return "general failure"
+	case 2:
+		return "not allowed by ruleset"
+	case 3:
+		return "network unreachable"
+	case 4:
+		return "host unreachable"
+	case 5:
+		return "connection refused"
+	case 6:
+		return "TTL expired"
+	case 7:
+		return "command not supported"
+	case 8:
+		return "address type not supported"
+	}
+	return "reply " + strconv.Itoa(int(code))
+}
+
+// connectHTTP asks the HTTP proxy on conn to CONNECT to address. It returns
+// the connection to use from then on, which replays anything the proxy sent
+// after its response.
+func connectHTTP(conn net.Conn, u *url.URL, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads first what was buffered past the proxy's response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// SetHTTPClient routes the requests of c through the proxy at u. c must use
+// an *http.Transport, as the clients from timeouts.Set.HTTPClient do.
+func SetHTTPClient(c *http.Client, u *url.URL) error {
+	tr, ok := c.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("proxy: client transport is not an *http.Transport")
+	}
+	tr.Proxy = http.ProxyURL(u)
+	return nil
+}
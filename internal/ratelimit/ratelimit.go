@@ -0,0 +1,217 @@
+// Package ratelimit provides a token-bucket bandwidth limiter shared by the
+// transports.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minBurst is the smallest bucket size, large enough for any UDP datagram
+// and a streamed-frame segment header.
+const minBurst = 32 * 1024
+
+// burstWindow is how much traffic at the configured rate may be sent at
+// once after the link has been idle.
+const burstWindow = 50 * time.Millisecond
+
+// Limiter is a token bucket measured in bytes. The rate can be changed at
+// any time with SetRate and takes effect for the next write. A nil *Limiter
+// or a rate of zero imposes no limit.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// New returns a Limiter allowing bytesPerSec bytes per second.
+func New(bytesPerSec float64) *Limiter {
+	l := &Limiter{now: time.Now, sleep: sleep}
+	l.SetRate(bytesPerSec)
+	l.tokens = l.burst()
+	return l
+}
+
+// SetRate changes the allowed rate. Zero or a negative value removes the
+// limit.
+func (l *Limiter) SetRate(bytesPerSec float64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	l.rate = bytesPerSec
+	if b := l.burst(); l.tokens > b {
+		l.tokens = b
+	}
+}
+
+// Rate returns the allowed rate in bytes per second, or 0 if unlimited.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Burst returns the largest write that WaitN admits without going into
+// debt. Callers splitting large writes should use pieces of at most this
+// size so that rate changes apply promptly.
+func (l *Limiter) Burst() int {
+	if l == nil {
+		return minBurst
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.burst())
+}
+
+// WaitN blocks until n bytes may be sent. Requests larger than the burst
+// are admitted by borrowing against future tokens, so later callers wait
+// for the debt to be repaid. If ctx is done first, the tokens are given
+// back and ctx.Err() returned.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill()
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if err := l.sleep(ctx, wait); err != nil {
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AllowN reports whether n bytes may be sent now, taking their tokens if
+// so. Unlike WaitN it never borrows, so a caller that drops what is refused
+// is held to the rate, as a relay policing traffic it cannot delay.
+func (l *Limiter) AllowN(n int) bool {
+	if l == nil || n <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	l.refill()
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// refill adds the tokens accumulated since the last call. l.mu must be held.
+func (l *Limiter) refill() {
+	now := l.now()
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if b := l.burst(); l.tokens > b {
+			l.tokens = b
+		}
+	}
+	l.last = now
+}
+
+// burst returns the bucket capacity. l.mu must be held.
+func (l *Limiter) burst() float64 {
+	b := l.rate * burstWindow.Seconds()
+	if b < minBurst {
+		b = minBurst
+	}
+	return b
+}
+
+// ParseRate parses a bandwidth such as "100MB/s", "512KiB/s", "800mbit" or
+// "1.5Gbps" and returns it in bytes per second. Byte units (B, KB, MB, GB,
+// and their KiB forms) are powers of 1024, matching utils.HumanBytes; bit
+// units (kbit, mbit, gbit, or kbps, mbps, gbps) are powers of 1000, as is
+// usual for link speeds. A trailing "/s" is optional and a bare number is
+// bytes per second. An empty string or "0" means unlimited.
+func ParseRate(s string) (float64, error) {
+	in := strings.TrimSpace(s)
+	if in == "" {
+		return 0, nil
+	}
+	unit := strings.ToLower(in)
+	unit = strings.TrimSuffix(unit, "/s")
+	i := strings.IndexFunc(unit, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num := unit
+	if i >= 0 {
+		num, unit = unit[:i], strings.TrimSpace(unit[i:])
+	} else {
+		unit = ""
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+
+	var mult float64
+	switch unit {
+	case "", "b":
+		mult = 1
+	case "k", "kb", "kib":
+		mult = 1 << 10
+	case "m", "mb", "mib":
+		mult = 1 << 20
+	case "g", "gb", "gib":
+		mult = 1 << 30
+	case "bit", "bps":
+		mult = 1.0 / 8
+	case "kbit", "kbps":
+		mult = 1e3 / 8
+	case "mbit", "mbps":
+		mult = 1e6 / 8
+	case "gbit", "gbps":
+		mult = 1e9 / 8
+	default:
+		return 0, fmt.Errorf("invalid bandwidth %q: unknown unit %q", s, unit)
+	}
+	return v * mult, nil
+}
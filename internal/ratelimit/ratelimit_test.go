@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]float64{
+		"":         0,
+		"0":        0,
+		"1000":     1000,
+		"100MB/s":  100 << 20,
+		"512KiB/s": 512 << 10,
+		"800mbit":  100e6,
+		"1.5Gbps":  1.5e9 / 8,
+		"2 gb":     2 << 30,
+	}
+	for in, want := range cases {
+		got, err := ParseRate(in)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("ParseRate(%q) = %v, want %v", in, got, want)
+		}
+	}
+	for _, bad := range []string{"fast", "10furlongs", "-5MB", "MB/s"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Fatalf("ParseRate(%q): expected error", bad)
+		}
+	}
+}
+
+// fakeClock advances only when the limiter sleeps.
+type fakeClock struct{ t time.Time }
+
+func newTestLimiter(rate float64) (*Limiter, *fakeClock) {
+	c := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &Limiter{now: func() time.Time { return c.t }, sleep: func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.t = c.t.Add(d)
+		return nil
+	}}
+	l.SetRate(rate)
+	l.tokens = l.burst()
+	return l, c
+}
+
+func TestLimiterRate(t *testing.T) {
+	ctx := context.Background()
+	const rate = 1 << 20
+	l, c := newTestLimiter(rate)
+	start := c.t
+	burst := l.Burst()
+
+	total := 4 << 20
+	for sent := 0; sent < total; sent += 1500 {
+		l.WaitN(ctx, 1500)
+	}
+	elapsed := c.t.Sub(start)
+	want := time.Duration(float64(total-burst) / rate * float64(time.Second))
+	if diff := elapsed - want; diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+		t.Fatalf("sending %d bytes at %d B/s took %v, want about %v", total, rate, elapsed, want)
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	ctx := context.Background()
+	l, c := newTestLimiter(1000)
+	l.WaitN(ctx, l.Burst()) // drain the bucket
+
+	start := c.t
+	l.SetRate(0)
+	l.WaitN(ctx, 1<<30)
+	if c.t != start {
+		t.Fatal("unlimited limiter should not wait")
+	}
+
+	l.SetRate(1 << 20)
+	l.WaitN(ctx, 1<<20)
+	if got := c.t.Sub(start); got < 900*time.Millisecond || got > time.Second {
+		t.Fatalf("waited %v after raising the rate, want just under 1s", got)
+	}
+
+	var nilLimiter *Limiter
+	nilLimiter.WaitN(ctx, 100)
+	nilLimiter.SetRate(5)
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l, c := newTestLimiter(1000)
+	l.WaitN(context.Background(), l.Burst()) // drain the bucket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := c.t
+	if err := l.WaitN(ctx, 500); err != context.Canceled {
+		t.Fatalf("WaitN = %v, want context.Canceled", err)
+	}
+	// The canceled request left no debt behind.
+	l.WaitN(context.Background(), 500)
+	if got := c.t.Sub(start); got != 500*time.Millisecond {
+		t.Fatalf("waited %v after a canceled wait, want 500ms", got)
+	}
+}
+
+func TestLimiterAllowN(t *testing.T) {
+	l, c := newTestLimiter(1 << 20)
+	burst := l.Burst()
+	if !l.AllowN(burst) {
+		t.Fatal("a full bucket refused its burst")
+	}
+	if l.AllowN(1500) {
+		t.Fatal("an empty bucket admitted a packet")
+	}
+	c.t = c.t.Add(10 * time.Millisecond)
+	if !l.AllowN(1500) {
+		t.Fatal("refilled bucket refused a packet")
+	}
+
+	var nilLimiter *Limiter
+	if !nilLimiter.AllowN(1 << 30) {
+		t.Fatal("nil limiter refused")
+	}
+}
@@ -0,0 +1,180 @@
+// Package receipt implements delivery receipts: statements signed by a
+// receiver that it received and verified a file, which senders keep as
+// proof of delivery.
+package receipt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrBadSignature is returned when a receipt's signature does not verify.
+var ErrBadSignature = errors.New("receipt signature invalid")
+
+// ErrUntrustedSigner is returned when a receipt is validly signed by a key
+// other than the one expected.
+var ErrUntrustedSigner = errors.New("receipt signed by an untrusted key")
+
+// signingContext is prepended to the encoded receipt before signing, so a
+// receipt signature cannot be passed off as a signature of anything else.
+const signingContext = "trackshift receipt v1\n"
+
+// Receipt states that Receiver received and verified a file.
+type Receipt struct {
+	// SessionID is the receiver's session and SenderSessionID the sender's,
+	// if it gave one.
+	SessionID       string `json:"session_id"`
+	SenderSessionID string `json:"sender_session_id,omitempty"`
+	FileName        string `json:"file_name"`
+	// FileHash is the SHA-256 of the file, checked by the receiver against
+	// the data it received.
+	FileHash    string    `json:"file_hash"`
+	Size        int64     `json:"size"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	// Receiver names the receiving node, and PublicKey is the key the
+	// receipt is signed with.
+	Receiver  string            `json:"receiver"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature,omitempty"`
+}
+
+// signedBytes returns what the signature covers: the receipt without its
+// signature.
+func (r *Receipt) signedBytes() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(signingContext), data...), nil
+}
+
+// Sign sets r's public key and signature from key. Timestamps are stored in
+// UTC so the receipt encodes the same wherever it is verified.
+func (r *Receipt) Sign(key ed25519.PrivateKey) error {
+	r.StartedAt, r.CompletedAt = r.StartedAt.UTC(), r.CompletedAt.UTC()
+	r.PublicKey = key.Public().(ed25519.PublicKey)
+	msg, err := r.signedBytes()
+	if err != nil {
+		return fmt.Errorf("encode receipt: %w", err)
+	}
+	r.Signature = ed25519.Sign(key, msg)
+	return nil
+}
+
+// Verify checks r's signature. If trusted is non-nil the receipt must also be
+// signed by that key; otherwise any correctly signed receipt is accepted and
+// the caller should check PublicKey's fingerprint itself.
+func (r *Receipt) Verify(trusted ed25519.PublicKey) error {
+	if len(r.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: bad public key", ErrBadSignature)
+	}
+	msg, err := r.signedBytes()
+	if err != nil {
+		return fmt.Errorf("encode receipt: %w", err)
+	}
+	if !ed25519.Verify(r.PublicKey, msg, r.Signature) {
+		return ErrBadSignature
+	}
+	if trusted != nil && !trusted.Equal(r.PublicKey) {
+		return fmt.Errorf("%w: %s", ErrUntrustedSigner, Fingerprint(r.PublicKey))
+	}
+	return nil
+}
+
+// Write saves r as JSON at path.
+func (r *Receipt) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Fingerprint identifies a public key in logs: the first 16 bytes of its
+// SHA-256, in hex.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:16])
+}
+
+// LoadOrCreateKey reads the PEM-encoded Ed25519 private key at path. If
+// there is none, a new key is generated and saved there, with its public key
+// next to it in path.pub for distribution to senders.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key found", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+func createKey(path string) (ed25519.PrivateKey, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("save key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		return nil, fmt.Errorf("save public key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadPublicKey reads a PEM-encoded Ed25519 public key, as written next to a
+// receiver's key by LoadOrCreateKey.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key found", path)
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return pub, nil
+}
@@ -0,0 +1,76 @@
+package receipt
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "receiver.key")
+	key, err := LoadOrCreateKey(keyPath)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKey: %v", err)
+	}
+	// The key is saved and loaded back the same.
+	again, err := LoadOrCreateKey(keyPath)
+	if err != nil || !again.Equal(key) {
+		t.Fatalf("reloaded key differs: %v", err)
+	}
+	pub, err := LoadPublicKey(keyPath + ".pub")
+	if err != nil {
+		t.Fatalf("LoadPublicKey: %v", err)
+	}
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 6, time.FixedZone("X", 3600))
+	r := &Receipt{
+		SessionID:   "r-1",
+		FileName:    "data.bin",
+		FileHash:    "abc",
+		Size:        1024,
+		StartedAt:   start,
+		CompletedAt: start.Add(time.Minute),
+		Receiver:    "node-a",
+	}
+	if err := r.Sign(key); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := r.Verify(pub); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// A receipt survives a JSON round trip, as it makes between peers.
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Receipt
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if err := back.Verify(pub); err != nil {
+		t.Fatalf("Verify after round trip: %v", err)
+	}
+
+	back.Size++
+	if err := back.Verify(nil); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify of altered receipt: %v", err)
+	}
+
+	other, err := LoadOrCreateKey(filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sign(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(pub); !errors.Is(err, ErrUntrustedSigner) {
+		t.Fatalf("Verify with the wrong trusted key: %v", err)
+	}
+	if err := r.Verify(nil); err != nil {
+		t.Fatalf("Verify without a trusted key: %v", err)
+	}
+}
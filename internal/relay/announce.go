@@ -0,0 +1,170 @@
+package relay
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/client"
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+)
+
+// heartbeatInterval is how often a relay reports its load.
+const heartbeatInterval = 30 * time.Second
+
+// loadMeter counts what a relay forwards, for its heartbeats. A packet is
+// a datagram for a Forwarder and a chunk for a Gateway.
+type loadMeter struct {
+	packets atomic.Uint64 // packets forwarded
+	bytes   atomic.Uint64 // bytes forwarded
+	delay   atomic.Uint64 // nanoseconds packets spent between read and forward
+}
+
+// record counts one packet of n bytes read at read and just forwarded.
+func (m *loadMeter) record(n int, read time.Time) {
+	m.delay.Add(uint64(time.Since(read)))
+	m.packets.Add(1)
+	m.bytes.Add(uint64(n))
+}
+
+// announcer keeps a relay registered with the orchestrator and reports its
+// load there. A nil orch makes it a no-op.
+type announcer struct {
+	orch       *client.OrchestratorClient
+	info       orchestrator.RelayInfo
+	registered atomic.Bool
+
+	// apply, if set, is passed the configuration set for the relay on the
+	// orchestrator whenever it changes, and nil once it is removed; applied
+	// is when the configuration in force was set.
+	apply   func(*orchestrator.RelayConfig)
+	applied time.Time
+}
+
+func newAnnouncer(orchestratorURL, token string, info orchestrator.RelayInfo) *announcer {
+	a := &announcer{info: info}
+	if orchestratorURL != "" {
+		a.orch = client.NewOrchestratorClient(orchestratorURL)
+		a.orch.Token = token
+	}
+	return a
+}
+
+// run registers the relay, then sends heartbeats reporting the load m
+// measured since the previous one for the orchestrator's health checks,
+// scaling recommendations and route planning, until closed is closed. Each
+// heartbeat is logged with where the relay forwards to, target, and
+// followed by a check of the relay's configuration.
+func (a *announcer) run(m *loadMeter, target func() string, closed <-chan struct{}) {
+	last, lastPackets, lastBytes, lastDelay := time.Now(), m.packets.Load(), m.bytes.Load(), m.delay.Load()
+	a.register(orchestrator.RelayLoad{})
+	a.follow()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			packets, bytes, delay := m.packets.Load(), m.bytes.Load(), m.delay.Load()
+			secs := now.Sub(last).Seconds()
+			load := orchestrator.RelayLoad{
+				PacketsPerSec: float64(packets-lastPackets) / secs,
+				BytesPerSec:   float64(bytes-lastBytes) / secs,
+			}
+			if packets > lastPackets {
+				load.LatencyMs = float64(delay-lastDelay) / float64(packets-lastPackets) / 1e6
+			}
+			last, lastPackets, lastBytes, lastDelay = now, packets, bytes, delay
+			log.Printf("[relay %s] heartbeat (forwarding to %s, %.0f pkt/s, %.0f B/s, %.3f ms)",
+				a.info.ID, target(), load.PacketsPerSec, load.BytesPerSec, load.LatencyMs)
+			a.heartbeat(load)
+			a.follow()
+		case <-closed:
+			return
+		}
+	}
+}
+
+// register registers the relay with its load. Failures are logged and
+// retried at the next heartbeat.
+func (a *announcer) register(load orchestrator.RelayLoad) {
+	if a.orch == nil {
+		return
+	}
+	info := a.info
+	info.Load = load
+	if err := a.orch.RegisterRelay(info); err != nil {
+		log.Printf("[relay %s] register with orchestrator: %v", a.info.ID, err)
+		a.registered.Store(false)
+		return
+	}
+	a.registered.Store(true)
+}
+
+// heartbeat reports load, registering first if the relay is not registered
+// or the orchestrator no longer knows it.
+func (a *announcer) heartbeat(load orchestrator.RelayLoad) {
+	if a.orch == nil {
+		return
+	}
+	if !a.registered.Load() {
+		a.register(load)
+		return
+	}
+	err := a.orch.RelayHeartbeat(a.info.ID, load)
+	if errors.Is(err, client.ErrNotFound) {
+		log.Printf("[relay %s] unknown to the orchestrator; registering again", a.info.ID)
+		a.register(load)
+		return
+	}
+	if err != nil {
+		log.Printf("[relay %s] heartbeat to orchestrator: %v", a.info.ID, err)
+	}
+}
+
+// follow fetches the configuration set for the relay and passes it to
+// apply if it changed. Failures are logged and retried at the next
+// heartbeat.
+func (a *announcer) follow() {
+	if a.orch == nil || a.apply == nil {
+		return
+	}
+	cfg, err := a.orch.RelayConfig(a.info.ID)
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		if !a.applied.IsZero() {
+			log.Printf("[relay %s] configuration removed on the orchestrator; back to local settings", a.info.ID)
+			a.applied = time.Time{}
+			a.apply(nil)
+		}
+	case err != nil:
+		log.Printf("[relay %s] fetch configuration from orchestrator: %v", a.info.ID, err)
+	case !cfg.UpdatedAt.Equal(a.applied):
+		log.Printf("[relay %s] applying configuration set on the orchestrator at %s", a.info.ID, cfg.UpdatedAt.Format(time.RFC3339))
+		a.applied = cfg.UpdatedAt
+		a.apply(cfg)
+	}
+}
+
+// routes returns the routes of the sessions in flight through the relay.
+// It returns none while the relay is not registered, or without an
+// orchestrator.
+func (a *announcer) routes() ([]orchestrator.RelayRoute, error) {
+	if a.orch == nil || !a.registered.Load() {
+		return nil, nil
+	}
+	return a.orch.RelayRoutes(a.info.ID)
+}
+
+// applyFilter sets the rules of filter to those of c, the configuration set
+// on the orchestrator, if it has any, or else to own, the relay's own.
+func applyFilter(relayID string, filter *ipfilter.Filter, own ipfilter.Rules, c *orchestrator.RelayConfig) {
+	rules := own
+	if c != nil && c.Filter != nil {
+		rules = *c.Filter
+	}
+	if err := filter.Set(rules); err != nil {
+		log.Printf("[relay %s] configured filter: %v", relayID, err)
+	}
+}
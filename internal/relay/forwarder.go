@@ -1,33 +1,93 @@
 package relay
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
 )
 
-// Forwarder is a minimal UDP packet forwarder used by edge relays.
+// Forwarder is a UDP packet forwarder used by edge relays. It routes the
+// packets of each session to that session's receiver, as set by a route
+// packet from the sender (see protocol.PacketTypeRoute) or by the sessions
+// registered with the orchestrator, and the receiver's ACKs, NACKs and
+// other packets back to the sender of the packets they answer. Other
+// packets go to ForwardAddr.
 type Forwarder struct {
-	ListenAddr      *net.UDPAddr
+	ListenAddr *net.UDPAddr
+	// ForwardAddr receives the packets of sessions without a route, and
+	// datagrams of other protocols. If nil, they are dropped.
 	ForwardAddr     *net.UDPAddr
 	RelayID         string
 	OrchestratorURL string
+	// OrchestratorToken is presented to the orchestrator, if it needs one.
+	OrchestratorToken string
+
+	// Address and Region are reported to the orchestrator along with
+	// Capacity, the traffic this relay can forward, against which the
+	// orchestrator measures its load.
+	Address  string
+	Region   string
+	Capacity orchestrator.RelayCapacity
+
+	// Filter, if set, drops packets from refused peers. As route packets
+	// redirect sessions, only trusted senders should be let through.
+	Filter *ipfilter.Filter
+
+	// Routes holds the route of each session.
+	Routes *RouteTable
+	// Usage counts the traffic relayed by session and by source, and
+	// enforces the rate limits set on it.
+	Usage *Usage
+	// Via lists relays, as host:port, that the sessions routed by route
+	// packets and by the orchestrator are chained through before their
+	// receivers, e.g. to steer around a bad path.
+	Via []string
+	// FollowOrchestrator makes the relay apply the configuration set for it
+	// on the orchestrator (see orchestrator.RelayConfig) over ForwardAddr,
+	// Via and Filter, checking for changes with each heartbeat.
+	FollowOrchestrator bool
+	// Impair, if set, simulates loss, latency and jitter on the packets
+	// relayed, for testing.
+	Impair Impairment
+
+	// rules holds the forward address and via hops in force.
+	rules atomic.Pointer[forwardRules]
+	load  loadMeter
 
 	conn   *net.UDPConn
 	closed chan struct{}
 	wg     sync.WaitGroup
 }
 
-// NewForwarder creates a new Forwarder.
+// forwardRules are the settings of a Forwarder the orchestrator may change
+// while it runs.
+type forwardRules struct {
+	forward *net.UDPAddr
+	via     []string
+}
+
+// NewForwarder creates a new Forwarder. An empty forward address makes it
+// relay only the sessions it has routes for.
 func NewForwarder(listen, forward, relayID, orchestratorURL string) (*Forwarder, error) {
 	laddr, err := net.ResolveUDPAddr("udp", listen)
 	if err != nil {
 		return nil, err
 	}
-	faddr, err := net.ResolveUDPAddr("udp", forward)
-	if err != nil {
-		return nil, err
+	var faddr *net.UDPAddr
+	if forward != "" {
+		if faddr, err = net.ResolveUDPAddr("udp", forward); err != nil {
+			return nil, err
+		}
 	}
 	conn, err := net.ListenUDP("udp", laddr)
 	if err != nil {
@@ -38,6 +98,8 @@ func NewForwarder(listen, forward, relayID, orchestratorURL string) (*Forwarder,
 		ForwardAddr:     faddr,
 		RelayID:         relayID,
 		OrchestratorURL: orchestratorURL,
+		Routes:          NewRouteTable(),
+		Usage:           NewUsage(),
 		conn:            conn,
 		closed:          make(chan struct{}),
 	}, nil
@@ -45,12 +107,20 @@ func NewForwarder(listen, forward, relayID, orchestratorURL string) (*Forwarder,
 
 // Start begins forwarding packets until Close is called.
 func (f *Forwarder) Start() {
+	f.rules.Store(&forwardRules{forward: f.ForwardAddr, via: f.Via})
+	if f.FollowOrchestrator && f.Filter == nil {
+		// The orchestrator may set rules to enforce.
+		f.Filter, _ = ipfilter.New(ipfilter.Rules{})
+	}
+	ownFilter := f.Filter.Rules()
+
 	f.wg.Add(1)
 	go func() {
 		defer f.wg.Done()
 		buf := make([]byte, 64*1024+256)
 		for {
 			n, addr, err := f.conn.ReadFromUDP(buf)
+			read := time.Now()
 			if err != nil {
 				select {
 				case <-f.closed:
@@ -60,28 +130,207 @@ func (f *Forwarder) Start() {
 					continue
 				}
 			}
-			// best-effort forward
-			if _, err := f.conn.WriteToUDP(buf[:n], f.ForwardAddr); err != nil {
-				log.Printf("[relay %s] forward error to %v: %v", f.RelayID, f.ForwardAddr, err)
+			if !f.Filter.Allowed(addr) {
+				continue
 			}
+			dest := f.rules.Load().forward
+			var header *protocol.PacketHeader
+			if h, ok := protocol.PeekHeader(buf[:n]); ok {
+				if h.Type == protocol.PacketTypeRoute {
+					f.route(buf[:n], addr)
+					continue
+				}
+				dest = f.Routes.next(h, answered(h, buf[:n]), addr, dest, read)
+				header = &h
+			}
+			if dest == nil || f.Impair.drop() || !f.Usage.admit(header, addr, n, read) {
+				continue
+			}
+			if d := f.Impair.delay(); d > 0 {
+				data := slices.Clone(buf[:n])
+				time.AfterFunc(d, func() { f.send(data, dest, read) })
+				continue
+			}
+			f.send(buf[:n], dest, read)
 		}
 	}()
 
-	// heartbeat/metrics ticker placeholder
-	f.wg.Add(1)
+	// The relay registers with the orchestrator, if configured, and reports
+	// its load there.
+	addr := f.Address
+	if addr == "" {
+		addr = f.ListenAddr.String()
+	}
+	a := newAnnouncer(f.OrchestratorURL, f.OrchestratorToken, orchestrator.RelayInfo{
+		ID:       f.RelayID,
+		Mode:     orchestrator.RelayForward,
+		Address:  addr,
+		Region:   f.Region,
+		Capacity: f.Capacity,
+	})
+	if f.FollowOrchestrator {
+		a.apply = func(c *orchestrator.RelayConfig) { f.configure(c, ownFilter) }
+	}
+	target := func() string {
+		if forward := f.rules.Load().forward; forward != nil {
+			return forward.String()
+		}
+		return "routed sessions"
+	}
+	f.wg.Add(2)
 	go func() {
 		defer f.wg.Done()
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				log.Printf("[relay %s] heartbeat (forwarding to %s)", f.RelayID, f.ForwardAddr.String())
-			case <-f.closed:
-				return
+		a.run(&f.load, target, f.closed)
+	}()
+	go func() {
+		defer f.wg.Done()
+		f.refreshRoutes(a)
+	}()
+}
+
+// send forwards data, read at read, to dest, best-effort.
+func (f *Forwarder) send(data []byte, dest *net.UDPAddr, read time.Time) {
+	if _, err := f.conn.WriteToUDP(data, dest); err != nil {
+		select {
+		case <-f.closed:
+		default:
+			log.Printf("[relay %s] forward error to %v: %v", f.RelayID, dest, err)
+		}
+		return
+	}
+	f.load.record(len(data), read)
+}
+
+// route applies a route packet from addr.
+func (f *Forwarder) route(data []byte, addr *net.UDPAddr) {
+	p, err := protocol.DeserializePacket(data)
+	if err != nil {
+		log.Printf("[relay %s] route packet from %v: %v", f.RelayID, addr, err)
+		return
+	}
+	hops, err := protocol.DecodeRoute(p.Payload)
+	if err == nil {
+		err = f.setRoute(p.SessionID, hops)
+	}
+	if err != nil {
+		log.Printf("[relay %s] route packet from %v: %v", f.RelayID, addr, err)
+	}
+}
+
+// setRoute routes session through hops, after the relays of Via: to the
+// first hop, which is passed a route packet with the rest.
+func (f *Forwarder) setRoute(session [16]byte, hops []string) error {
+	hops = append(slices.Clone(f.rules.Load().via), hops...)
+	if len(hops) > protocol.MaxRouteHops {
+		return fmt.Errorf("route of %d hops, at most %d allowed", len(hops), protocol.MaxRouteHops)
+	}
+	next, err := net.ResolveUDPAddr("udp", hops[0])
+	if err != nil {
+		return err
+	}
+	f.Routes.Set(session, next)
+	if len(hops) == 1 {
+		return nil
+	}
+	raw, err := protocol.SerializePacket(&protocol.Packet{
+		Version:   protocol.CurrentVersion,
+		Type:      protocol.PacketTypeRoute,
+		SessionID: session,
+		Payload:   protocol.EncodeRoute(hops[1:]...),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.conn.WriteToUDP(raw, next)
+	return err
+}
+
+// mergeRoutes routes the sessions of routes, as fetched from the
+// orchestrator. Chained routes are set again each time, so the relays down
+// the chain keep them even if a route packet was lost.
+func (f *Forwarder) mergeRoutes(routes []orchestrator.RelayRoute) {
+	for _, rt := range routes {
+		id, err := uuid.Parse(rt.Session)
+		if err == nil {
+			err = f.mergeRoute(id, rt.Destination)
+		}
+		if err != nil {
+			log.Printf("[relay %s] route of session %q: %v", f.RelayID, rt.Session, err)
+		}
+	}
+}
+
+func (f *Forwarder) mergeRoute(session [16]byte, dest string) error {
+	if len(f.rules.Load().via) == 0 {
+		addr, err := net.ResolveUDPAddr("udp", dest)
+		if err != nil {
+			return err
+		}
+		if cur, ok := f.Routes.Lookup(session); ok && sameAddr(cur, addr) {
+			return nil
+		}
+	}
+	return f.setRoute(session, []string{dest})
+}
+
+// configure applies c, the configuration set on the orchestrator, over the
+// relay's own settings, or goes back to them if c is nil. ownFilter holds
+// the filter rules the relay started with.
+func (f *Forwarder) configure(c *orchestrator.RelayConfig, ownFilter ipfilter.Rules) {
+	r := forwardRules{forward: f.ForwardAddr, via: f.Via}
+	if c != nil {
+		if c.ForwardAddress != "" {
+			addr, err := net.ResolveUDPAddr("udp", c.ForwardAddress)
+			if err != nil {
+				log.Printf("[relay %s] configured forward address: %v", f.RelayID, err)
+			} else {
+				r.forward = addr
 			}
 		}
-	}()
+		if c.Via != nil {
+			r.via = c.Via
+		}
+	}
+	f.rules.Store(&r)
+	applyFilter(f.RelayID, f.Filter, ownFilter, c)
+}
+
+// answered returns the sequence number of the data packet a packet with
+// header h answers: the one an ACK acknowledges, or the first a NACK
+// reports missing.
+func answered(h protocol.PacketHeader, data []byte) uint32 {
+	if h.Type != protocol.PacketTypeNack {
+		return h.Seq
+	}
+	p, err := protocol.DeserializePacket(data)
+	if err != nil {
+		return h.Seq
+	}
+	if seqs, err := protocol.DecodeNack(p.Payload); err == nil && len(seqs) > 0 {
+		return seqs[0]
+	}
+	return h.Seq
+}
+
+// refreshRoutes expires idle routes and merges the routes the orchestrator
+// knows of, if there is one, every routeRefresh until Close is called.
+func (f *Forwarder) refreshRoutes(a *announcer) {
+	ticker := time.NewTicker(routeRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			f.Routes.expire(now)
+			f.Usage.expire(now)
+			if routes, err := a.routes(); err != nil {
+				log.Printf("[relay %s] fetch routes from orchestrator: %v", f.RelayID, err)
+			} else {
+				f.mergeRoutes(routes)
+			}
+		case <-f.closed:
+			return
+		}
+	}
 }
 
 // Close stops forwarding and closes the socket.
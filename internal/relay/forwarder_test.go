@@ -0,0 +1,276 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+func TestForwarderRoutesBySession(t *testing.T) {
+	fwd, err := NewForwarder("127.0.0.1:0", "", "edge", "")
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	fwd.Start()
+	defer fwd.Close()
+	relayAddr := fwd.conn.LocalAddr().(*net.UDPAddr)
+
+	listen := func() *net.UDPConn {
+		t.Helper()
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	rx1, rx2, tx := listen(), listen(), listen()
+	send := func(c *net.UDPConn, to *net.UDPAddr, typ protocol.PacketType, session byte, payload []byte) {
+		t.Helper()
+		raw, err := protocol.SerializePacket(&protocol.Packet{
+			Version:   protocol.CurrentVersion,
+			Type:      typ,
+			SessionID: [16]byte{session},
+			Payload:   payload,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.WriteToUDP(raw, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func(c *net.UDPConn) (*protocol.Packet, *net.UDPAddr) {
+		t.Helper()
+		buf := make([]byte, 2048)
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := c.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		p, err := protocol.DeserializePacket(buf[:n])
+		if err != nil {
+			t.Fatalf("deserialize: %v", err)
+		}
+		return p, from
+	}
+
+	send(tx, relayAddr, protocol.PacketTypeRoute, 1, protocol.EncodeRoute(rx1.LocalAddr().String()))
+	send(tx, relayAddr, protocol.PacketTypeRoute, 2, protocol.EncodeRoute(rx2.LocalAddr().String()))
+	// A session without a route has nowhere to go without a forward address.
+	send(tx, relayAddr, protocol.PacketTypeData, 3, []byte("lost"))
+	send(tx, relayAddr, protocol.PacketTypeData, 1, []byte("one"))
+	send(tx, relayAddr, protocol.PacketTypeData, 2, []byte("two"))
+
+	if p, _ := recv(rx1); p.SessionID[0] != 1 || string(p.Payload) != "one" {
+		t.Fatalf("receiver 1 got session %d %q", p.SessionID[0], p.Payload)
+	}
+	p, from := recv(rx2)
+	if p.SessionID[0] != 2 || string(p.Payload) != "two" {
+		t.Fatalf("receiver 2 got session %d %q", p.SessionID[0], p.Payload)
+	}
+	if fwd.Routes.Len() != 2 {
+		t.Fatalf("%d routes, want 2", fwd.Routes.Len())
+	}
+
+	// The receiver's feedback goes back to the sender.
+	send(rx2, from, protocol.PacketTypeAck, 2, nil)
+	if p, _ := recv(tx); p.Type != protocol.PacketTypeAck || p.SessionID[0] != 2 {
+		t.Fatalf("sender got %v of session %d", p.Type, p.SessionID[0])
+	}
+}
+
+func TestForwarderChainsRelays(t *testing.T) {
+	listen := func() *net.UDPConn {
+		t.Helper()
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	relay := func(via ...string) *Forwarder {
+		t.Helper()
+		f, err := NewForwarder("127.0.0.1:0", "", "relay", "")
+		if err != nil {
+			t.Fatalf("NewForwarder: %v", err)
+		}
+		f.Via = via
+		f.Start()
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	packet := func(typ protocol.PacketType, session byte, payload []byte) []byte {
+		raw, err := protocol.SerializePacket(&protocol.Packet{Version: protocol.CurrentVersion, Type: typ, SessionID: [16]byte{session}, Seq: 1, Payload: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	read := func(c *net.UDPConn) (*protocol.Packet, *net.UDPAddr) {
+		t.Helper()
+		buf := make([]byte, 2048)
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := c.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		p, err := protocol.DeserializePacket(buf[:n])
+		if err != nil {
+			t.Fatalf("deserialize: %v", err)
+		}
+		return p, from
+	}
+
+	rx, tx := listen(), listen()
+	relayB := relay()
+	addrB := relayB.conn.LocalAddr().String()
+	for name, tc := range map[string]struct {
+		relayA *Forwarder
+		hops   []string
+	}{
+		// The hops come with the route packet, or from relay A's Via.
+		"route packet": {relay(), []string{addrB, rx.LocalAddr().String()}},
+		"configured":   {relay(addrB), []string{rx.LocalAddr().String()}},
+	} {
+		session := byte(len(name))
+		addrA := tc.relayA.conn.LocalAddr().(*net.UDPAddr)
+		tx.WriteToUDP(packet(protocol.PacketTypeRoute, session, protocol.EncodeRoute(tc.hops...)), addrA)
+		// The route packet for relay B goes ahead of the data on the same path.
+		tx.WriteToUDP(packet(protocol.PacketTypeData, session, []byte(name)), addrA)
+
+		p, from := read(rx)
+		if p.SessionID[0] != session || string(p.Payload) != name {
+			t.Fatalf("%s: receiver got session %d %q", name, p.SessionID[0], p.Payload)
+		}
+		if from.String() != addrB {
+			t.Fatalf("%s: data came from %v, want relay B at %s", name, from, addrB)
+		}
+		rx.WriteToUDP(packet(protocol.PacketTypeAck, session, nil), from)
+		if p, _ := read(tx); p.Type != protocol.PacketTypeAck || p.SessionID[0] != session {
+			t.Fatalf("%s: sender got %v of session %d", name, p.Type, p.SessionID[0])
+		}
+	}
+}
+
+func TestRouteTableExpires(t *testing.T) {
+	rt := NewRouteTable()
+	dest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 9090}
+	rt.Set([16]byte{1}, dest)
+	now := time.Now()
+	h := protocol.PacketHeader{Type: protocol.PacketTypeData, SessionID: [16]byte{1}}
+	if got := rt.next(h, 0, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}, nil, now); !sameAddr(got, dest) {
+		t.Fatalf("next = %v, want %v", got, dest)
+	}
+	rt.expire(now.Add(routeIdle / 2))
+	if _, ok := rt.Lookup([16]byte{1}); !ok {
+		t.Fatal("route expired while in use")
+	}
+	rt.expire(now.Add(routeIdle + time.Second))
+	if _, ok := rt.Lookup([16]byte{1}); ok {
+		t.Fatal("idle route kept")
+	}
+}
+
+func TestRouteTableReturnsFeedbackToItsSender(t *testing.T) {
+	rt := NewRouteTable()
+	rx := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 9090}
+	txA := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+	txB := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 5000}
+	session := [16]byte{1}
+	rt.Set(session, rx)
+	now := time.Now()
+	data := func(seq uint32, from *net.UDPAddr) {
+		h := protocol.PacketHeader{Type: protocol.PacketTypeData, SessionID: session, Seq: seq}
+		if got := rt.next(h, seq, from, nil, now); !sameAddr(got, rx) {
+			t.Fatalf("data %d from %v went to %v", seq, from, got)
+		}
+	}
+	// The session is sent from two addresses, e.g. two paths of one sender.
+	data(1, txA)
+	data(2, txB)
+	data(3, txA)
+
+	ack := protocol.PacketHeader{Type: protocol.PacketTypeAck, SessionID: session, Seq: 2}
+	if got := rt.next(ack, 2, rx, nil, now); !sameAddr(got, txB) {
+		t.Fatalf("ACK of 2 went to %v, want %v", got, txB)
+	}
+	// An acknowledged packet is forgotten; later feedback on it goes to the
+	// latest sender.
+	if got := rt.next(ack, 2, rx, nil, now); !sameAddr(got, txA) {
+		t.Fatalf("repeated ACK of 2 went to %v, want %v", got, txA)
+	}
+	// ACKs and NACKs go back even from an address other than the receiver's.
+	nack := protocol.PacketHeader{Type: protocol.PacketTypeNack, SessionID: session}
+	other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 7000}
+	if got := rt.next(nack, 1, other, nil, now); !sameAddr(got, txA) {
+		t.Fatalf("NACK of 1 went to %v, want %v", got, txA)
+	}
+	// Feedback on an unknown session goes nowhere.
+	if got := rt.next(protocol.PacketHeader{Type: protocol.PacketTypeAck, SessionID: [16]byte{2}}, 1, rx, rx, now); got != nil {
+		t.Fatalf("ACK of an unknown session went to %v", got)
+	}
+}
+
+func TestForwarderFollowsOrchestratorConfig(t *testing.T) {
+	orch := orchestrator.NewService()
+	mux := http.NewServeMux()
+	orch.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Configuration changes take the admin token.
+	orch.AdminToken = "admin-secret"
+	put := func(cfg orchestrator.RelayConfig) {
+		t.Helper()
+		body, _ := json.Marshal(cfg)
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/v1/relays/edge/config", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("set config = %d", resp.StatusCode)
+		}
+	}
+	put(orchestrator.RelayConfig{ForwardAddress: "127.0.0.1:9999", Filter: &ipfilter.Rules{Deny: []string{"10.0.0.0/8"}}})
+
+	fwd, err := NewForwarder("127.0.0.1:0", "127.0.0.1:9090", "edge", srv.URL)
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	fwd.OrchestratorToken = "admin-secret"
+	fwd.FollowOrchestrator = true
+	fwd.Start()
+	defer fwd.Close()
+
+	// The configuration is fetched once the relay has registered.
+	deadline := time.Now().Add(2 * time.Second)
+	for fwd.rules.Load().forward.Port != 9999 {
+		if time.Now().After(deadline) {
+			t.Fatalf("forward address = %v, want the configured one", fwd.rules.Load().forward)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rules := fwd.Filter.Rules(); !slices.Equal(rules.Deny, []string{"10.0.0.0/8"}) {
+		t.Fatalf("filter rules = %+v", rules)
+	}
+
+	// Removing the configuration restores the relay's own settings.
+	fwd.configure(nil, ipfilter.Rules{})
+	if got := fwd.rules.Load().forward; got.Port != 9090 || len(fwd.Filter.Rules().Deny) != 0 {
+		t.Fatalf("after removal: forward %v, filter %+v", got, fwd.Filter.Rules())
+	}
+}
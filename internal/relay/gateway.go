@@ -0,0 +1,444 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
+	"github.com/deb2000-sudo/trackshift/internal/orchestrator"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// GatewayConfig configures a Gateway.
+type GatewayConfig struct {
+	// ListenAddr is the TCP address senders connect to.
+	ListenAddr string
+	// ForwardAddr is the TCP address of the downstream receiver.
+	ForwardAddr string
+	// Compression is applied on the outbound leg: "auto", "zstd" or "none".
+	Compression string
+	// Filter, if set, refuses connections from peers it does not admit.
+	Filter *ipfilter.Filter
+
+	// RelayID, Address, Region and Capacity are reported to the orchestrator
+	// at OrchestratorURL, if set, with the chunks and bytes forwarded, so
+	// that senders can be routed through the gateway. Address defaults to
+	// the listening address. OrchestratorToken is presented there, if the
+	// orchestrator needs one.
+	RelayID           string
+	OrchestratorURL   string
+	OrchestratorToken string
+	Address           string
+	Region            string
+	Capacity          orchestrator.RelayCapacity
+	// FollowOrchestrator makes the gateway apply the configuration set for
+	// it on the orchestrator (see orchestrator.RelayConfig) over ForwardAddr
+	// and Filter, checking for changes with each heartbeat. Sessions already
+	// relayed keep their downstream connection.
+	FollowOrchestrator bool
+}
+
+// Gateway is a trusted TCP relay that terminates the inbound session leg,
+// verifies every chunk, and originates a new leg downstream with its own
+// compression policy. It is used to cross boundaries between networks with
+// different policies, unlike Forwarder which relays packets untouched.
+type Gateway struct {
+	cfg GatewayConfig
+	ln  net.Listener
+	// forward is the downstream address in force.
+	forward atomic.Pointer[string]
+	// breaker refuses sessions at once while the downstream receiver keeps
+	// failing to connect, instead of dialing it for each.
+	breaker *transport.RetryManager
+	load    loadMeter
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewGateway creates a Gateway listening on cfg.ListenAddr.
+func NewGateway(cfg GatewayConfig) (*Gateway, error) {
+	if cfg.ForwardAddr == "" {
+		return nil, fmt.Errorf("forward address must not be empty")
+	}
+	switch cfg.Compression {
+	case "":
+		cfg.Compression = "auto"
+	case "auto", models.CompressionZstd, models.CompressionNone:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+	if cfg.FollowOrchestrator && cfg.Filter == nil {
+		// The orchestrator may set rules to enforce.
+		cfg.Filter, _ = ipfilter.New(ipfilter.Rules{})
+	}
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	g := &Gateway{
+		cfg:     cfg,
+		ln:      cfg.Filter.Listener(ln),
+		breaker: transport.NewRetryManager(),
+		closed:  make(chan struct{}),
+	}
+	g.forward.Store(&g.cfg.ForwardAddr)
+	return g, nil
+}
+
+// Addr returns the address the gateway is listening on.
+func (g *Gateway) Addr() net.Addr {
+	return g.ln.Addr()
+}
+
+// Start accepts inbound sessions until Close is called. Sessions are relayed
+// under ctx: once it is done, those in progress are cut off.
+func (g *Gateway) Start(ctx context.Context) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		for {
+			conn, err := g.ln.Accept()
+			if err != nil {
+				select {
+				case <-g.closed:
+					return
+				default:
+					log.Printf("[gateway] accept error: %v", err)
+					continue
+				}
+			}
+			g.wg.Add(1)
+			go func() {
+				defer g.wg.Done()
+				if err := g.handle(ctx, conn); err != nil {
+					log.Printf("[gateway] session from %s: %v", conn.RemoteAddr(), err)
+				}
+			}()
+		}
+	}()
+
+	addr := g.cfg.Address
+	if addr == "" {
+		addr = g.ln.Addr().String()
+	}
+	a := newAnnouncer(g.cfg.OrchestratorURL, g.cfg.OrchestratorToken, orchestrator.RelayInfo{
+		ID:       g.cfg.RelayID,
+		Mode:     orchestrator.RelayGateway,
+		Address:  addr,
+		Region:   g.cfg.Region,
+		Capacity: g.cfg.Capacity,
+	})
+	if g.cfg.FollowOrchestrator {
+		ownFilter := g.cfg.Filter.Rules()
+		a.apply = func(c *orchestrator.RelayConfig) { g.configure(c, ownFilter) }
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		a.run(&g.load, func() string { return *g.forward.Load() }, g.closed)
+	}()
+}
+
+// configure applies c, the configuration set on the orchestrator, over the
+// gateway's own settings, or goes back to them if c is nil. ownFilter holds
+// the filter rules the gateway started with.
+func (g *Gateway) configure(c *orchestrator.RelayConfig, ownFilter ipfilter.Rules) {
+	forward := g.cfg.ForwardAddr
+	if c != nil && c.ForwardAddress != "" {
+		forward = c.ForwardAddress
+	}
+	g.forward.Store(&forward)
+	applyFilter(g.cfg.RelayID, g.cfg.Filter, ownFilter, c)
+}
+
+// Close stops accepting sessions and waits for active ones to finish.
+func (g *Gateway) Close() error {
+	close(g.closed)
+	err := g.ln.Close()
+	g.wg.Wait()
+	return err
+}
+
+// handle relays one inbound session to the downstream receiver.
+func (g *Gateway) handle(ctx context.Context, in net.Conn) error {
+	defer in.Close()
+
+	forward := *g.forward.Load()
+	if err := g.breaker.Allow(forward); err != nil {
+		return err
+	}
+	sender := transport.NewTCPSender()
+	out, err := sender.Connect(ctx, forward)
+	if err != nil {
+		if ctx.Err() == nil {
+			g.breaker.RecordFailure(forward, err)
+		}
+		return err
+	}
+	g.breaker.RecordSuccess(forward)
+	defer out.Close()
+	// Frames the receiver sends back, such as rate control, go straight
+	// through to the sender.
+	back := make(chan struct{})
+	versions := make(chan uint8, 1)
+	go func() {
+		defer close(back)
+		relayBack(ctx, in, out, versions)
+	}()
+
+	recv := &transport.TCPReceiver{}
+	version := protocol.Version1
+	receiptRequested := false
+	for {
+		meta, data, err := recv.ReceiveStream(ctx, in)
+		if err == io.EOF {
+			// The receipt the sender waits for comes once the receiver sees
+			// the end of the session too.
+			if receiptRequested {
+				if cw, ok := out.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
+				<-back
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receive: %w", err)
+		}
+
+		if meta.ID == "__filemeta__" {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read file metadata frame: %w", err)
+			}
+			var fileMeta models.FileMetadata
+			if err := json.Unmarshal(payload, &fileMeta); err != nil {
+				return fmt.Errorf("invalid file metadata frame: %w", err)
+			}
+			// The outbound leg speaks the same version the sender announced,
+			// so a legacy downstream receiver keeps working.
+			if version, err = protocol.NegotiateVersion(protocol.CurrentVersion, fileMeta.ProtocolVersion); err != nil {
+				return err
+			}
+			comp, err := crypto.CompressChunk(payload)
+			if err != nil {
+				return fmt.Errorf("compress file metadata frame: %w", err)
+			}
+			meta.Compression = models.CompressionZstd
+			if err := sender.Send(ctx, out, comp, meta); err != nil {
+				return fmt.Errorf("forward file metadata frame: %w", err)
+			}
+			// The sender hears the receiver's answer on the return path
+			// and steps down with it, as the outbound leg must too.
+			if protocol.SupportsVersionReply(fileMeta.ProtocolVersion) {
+				if version, err = awaitVersion(versions, back, version, sender.Timeouts.Handshake); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if meta.ID == transport.ManifestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read manifest frame: %w", err)
+			}
+			tree, err := transport.DecodeManifest(payload)
+			if err != nil {
+				return err
+			}
+			if err := sender.SendManifest(ctx, out, tree); err != nil {
+				return fmt.Errorf("forward manifest frame: %w", err)
+			}
+			continue
+		}
+
+		if meta.ID == transport.PauseFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read pause frame: %w", err)
+			}
+			ps, err := transport.DecodePause(payload)
+			if err != nil {
+				return err
+			}
+			if err := sender.SendPause(ctx, out, ps.Paused); err != nil {
+				return fmt.Errorf("forward pause frame: %w", err)
+			}
+			continue
+		}
+
+		// The receiver's answers come back on the return path.
+		if meta.ID == transport.AuthFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read auth frame: %w", err)
+			}
+			token, err := transport.DecodeAuth(payload)
+			if err != nil {
+				return err
+			}
+			if err := sender.SendAuth(ctx, out, token); err != nil {
+				return fmt.Errorf("forward auth frame: %w", err)
+			}
+			continue
+		}
+
+		if meta.ID == transport.ResumeRequestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read resume request frame: %w", err)
+			}
+			req, err := transport.DecodeResumeRequest(payload)
+			if err != nil {
+				return err
+			}
+			if err := sender.SendResumeRequest(ctx, out, req); err != nil {
+				return fmt.Errorf("forward resume request frame: %w", err)
+			}
+			continue
+		}
+
+		if meta.ID == transport.ReceiptRequestFrameID {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read receipt request frame: %w", err)
+			}
+			req, err := transport.DecodeReceiptRequest(payload)
+			if err != nil {
+				return err
+			}
+			if err := sender.RequestReceipt(ctx, out, req); err != nil {
+				return fmt.Errorf("forward receipt request frame: %w", err)
+			}
+			receiptRequested = true
+			continue
+		}
+
+		// Other control frames, such as clock sync, pass through as they
+		// are; their answers come back on the return path.
+		if strings.HasPrefix(meta.ID, "__") {
+			payload, err := io.ReadAll(data)
+			if err != nil {
+				return fmt.Errorf("read %s frame: %w", meta.ID, err)
+			}
+			meta.Size, meta.Compression = int64(len(payload)), models.CompressionNone
+			if err := sender.Send(ctx, out, payload, meta); err != nil {
+				return fmt.Errorf("forward %s frame: %w", meta.ID, err)
+			}
+			continue
+		}
+
+		read, size := time.Now(), meta.Size
+		if err := g.forwardChunk(ctx, sender, out, version, meta, data); err != nil {
+			return fmt.Errorf("chunk %s: %w", meta.ID, err)
+		}
+		g.load.record(int(size), read)
+	}
+}
+
+// relayBack passes the frames the receiver sends back on out to the sender on
+// in until out is closed, noting on versions the version the receiver picked
+// for the session.
+func relayBack(ctx context.Context, in, out net.Conn, versions chan<- uint8) {
+	sender, recv := transport.NewTCPSender(), &transport.TCPReceiver{}
+	for {
+		data, meta, err := recv.Receive(ctx, out)
+		if err != nil {
+			return
+		}
+		if meta.ID == transport.VersionFrameID {
+			if reply, err := transport.DecodeVersion(data); err == nil {
+				select {
+				case versions <- reply.Version:
+				default:
+				}
+			}
+		}
+		meta.Size, meta.Compression = int64(len(data)), models.CompressionNone
+		if err := sender.Send(ctx, in, data, meta); err != nil {
+			return
+		}
+	}
+}
+
+// awaitVersion returns the version the receiver picked for a session the
+// gateway announced at version, or protocol.Version1 if it stays silent for
+// wait, as receivers predating the exchange do.
+func awaitVersion(versions <-chan uint8, back <-chan struct{}, version uint8, wait time.Duration) (uint8, error) {
+	if wait <= 0 {
+		wait = timeouts.Defaults().Handshake
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case v := <-versions:
+		if err := protocol.CheckVersion(v); err != nil {
+			return 0, err
+		}
+		return min(v, version), nil
+	case <-back:
+		return 0, errors.New("receiver closed the connection")
+	case <-timer.C:
+		log.Printf("Receiver did not answer protocol v%d; assuming a v1 receiver", version)
+		return protocol.Version1, nil
+	}
+}
+
+// forwardChunk verifies an inbound chunk and re-originates it downstream.
+// The chunk is read whole and checked before any of it is forwarded, so a
+// corrupt chunk never reaches the receiver.
+func (g *Gateway) forwardChunk(ctx context.Context, sender *transport.TCPSender, out net.Conn, version uint8, meta *models.ChunkMetadata, data io.Reader) error {
+	compression := g.cfg.Compression
+	if !protocol.SupportsChunkCompressionField(version) {
+		compression = models.CompressionZstd
+	}
+
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if got := crypto.HashChunk(buf); hex.EncodeToString(got[:]) != meta.SHA256 {
+		return transport.ErrChunkHashMismatch
+	}
+	if protocol.SupportsStreamedFrames(version) {
+		meta.Compression = ""
+		if compression != "auto" {
+			meta.Compression = compression
+		}
+		return sender.SendStream(ctx, out, bytes.NewReader(buf), meta)
+	}
+
+	var payload []byte
+	switch compression {
+	case models.CompressionNone:
+		payload, meta.Compression = buf, models.CompressionNone
+	case models.CompressionZstd:
+		payload, err = crypto.CompressChunk(buf)
+		meta.Compression = models.CompressionZstd
+	default:
+		payload, meta.Compression, err = crypto.CompressChunkAdaptive(buf)
+	}
+	if err != nil {
+		return err
+	}
+	if err := sender.Send(ctx, out, payload, meta); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+func TestGatewayRecompresses(t *testing.T) {
+	downstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer downstream.Close()
+
+	gw, err := NewGateway(GatewayConfig{
+		ListenAddr:  "127.0.0.1:0",
+		ForwardAddr: downstream.Addr().String(),
+		Compression: models.CompressionNone,
+	})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	gw.Start(context.Background())
+	defer gw.Close()
+
+	data := bytes.Repeat([]byte("gateway chunk "), 4096)
+	h := crypto.HashChunk(data)
+	chunk := &models.ChunkMetadata{ID: "0", Size: int64(len(data)), SHA256: fmt.Sprintf("%x", h[:]), Compression: models.CompressionZstd}
+
+	go func() {
+		sender := transport.NewTCPSender()
+		conn, err := sender.Connect(context.Background(), gw.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		payload, _ := json.Marshal(models.FileMetadata{Name: "a.bin", Size: int64(len(data)), Hash: "abc", ProtocolVersion: protocol.CurrentVersion})
+		comp, _ := crypto.CompressChunk(payload)
+		_ = sender.Send(context.Background(), conn, comp, &models.ChunkMetadata{ID: "__filemeta__", Compression: models.CompressionZstd})
+		_ = sender.SendStream(context.Background(), conn, bytes.NewReader(data), chunk)
+	}()
+
+	conn, err := downstream.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+
+	recv := &transport.TCPReceiver{}
+	_, meta, err := recv.Receive(context.Background(), conn)
+	if err != nil || meta.ID != "__filemeta__" {
+		t.Fatalf("expected file metadata frame, got %v (%v)", meta, err)
+	}
+	if err := transport.NewTCPSender().SendVersion(context.Background(), conn, protocol.CurrentVersion); err != nil {
+		t.Fatalf("SendVersion: %v", err)
+	}
+	got, meta, err := recv.Receive(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Receive chunk: %v", err)
+	}
+	if meta.Compression != models.CompressionNone {
+		t.Fatalf("expected gateway to re-originate uncompressed, got %q", meta.Compression)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("chunk data mismatch")
+	}
+}
+
+func TestGatewayDropsCorruptChunk(t *testing.T) {
+	downstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer downstream.Close()
+
+	gw, err := NewGateway(GatewayConfig{ListenAddr: "127.0.0.1:0", ForwardAddr: downstream.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	gw.Start(context.Background())
+	defer gw.Close()
+
+	data := bytes.Repeat([]byte("gateway chunk "), 4096)
+	h := crypto.HashChunk(data)
+	chunk := &models.ChunkMetadata{ID: "0", Size: int64(len(data)), SHA256: fmt.Sprintf("%x", h[:])}
+	go func() {
+		sender := transport.NewTCPSender()
+		conn, err := sender.Connect(context.Background(), gw.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		payload, _ := json.Marshal(models.FileMetadata{Name: "a.bin", Size: int64(len(data)), Hash: "abc", ProtocolVersion: protocol.CurrentVersion})
+		comp, _ := crypto.CompressChunk(payload)
+		_ = sender.Send(context.Background(), conn, comp, &models.ChunkMetadata{ID: "__filemeta__", Compression: models.CompressionZstd})
+		// The bytes on the wire no longer match the hash in the metadata.
+		corrupt := bytes.Clone(data)
+		corrupt[len(corrupt)-1] ^= 0xff
+		_ = sender.SendStream(context.Background(), conn, bytes.NewReader(corrupt), chunk)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := downstream.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	recv := &transport.TCPReceiver{}
+	if _, meta, err := recv.Receive(context.Background(), conn); err != nil || meta.ID != "__filemeta__" {
+		t.Fatalf("expected file metadata frame, got %v (%v)", meta, err)
+	}
+	if err := transport.NewTCPSender().SendVersion(context.Background(), conn, protocol.CurrentVersion); err != nil {
+		t.Fatalf("SendVersion: %v", err)
+	}
+	// Not a byte of the corrupt chunk is forwarded: the gateway ends the
+	// session before sending its frame.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var b [1]byte
+	if n, err := conn.Read(b[:]); n != 0 || err != io.EOF {
+		t.Fatalf("expected the gateway to close the session, read %d bytes (%v)", n, err)
+	}
+}
+
+func TestGatewayPassesClockSync(t *testing.T) {
+	downstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer downstream.Close()
+
+	gw, err := NewGateway(GatewayConfig{ListenAddr: "127.0.0.1:0", ForwardAddr: downstream.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	gw.Start(context.Background())
+	defer gw.Close()
+
+	go func() {
+		conn, err := downstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		recv := &transport.TCPReceiver{}
+		for {
+			payload, meta, err := recv.Receive(context.Background(), conn)
+			if err != nil || meta.ID != transport.TimeSyncFrameID {
+				return
+			}
+			if _, done, err := recv.AnswerTimeSync(context.Background(), conn, payload, time.Now()); err != nil || done {
+				return
+			}
+		}
+	}()
+
+	sender := transport.NewTCPSender()
+	conn, err := sender.Connect(context.Background(), gw.Addr().String())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	if _, rtt, err := sender.SyncClock(context.Background(), conn, 3, 5*time.Second); err != nil || rtt <= 0 {
+		t.Fatalf("SyncClock through gateway: rtt %v, %v", rtt, err)
+	}
+}
+
+func TestGatewayPassesVersionReply(t *testing.T) {
+	downstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer downstream.Close()
+
+	gw, err := NewGateway(GatewayConfig{ListenAddr: "127.0.0.1:0", ForwardAddr: downstream.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	gw.Start(context.Background())
+	defer gw.Close()
+
+	go func() {
+		conn, err := downstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, meta, err := (&transport.TCPReceiver{}).Receive(context.Background(), conn); err != nil || meta.ID != "__filemeta__" {
+			return
+		}
+		_ = transport.NewTCPSender().SendVersion(context.Background(), conn, protocol.Version9)
+		io.Copy(io.Discard, conn)
+	}()
+
+	sender := transport.NewTCPSender()
+	conn, err := sender.Connect(context.Background(), gw.Addr().String())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	payload, _ := json.Marshal(models.FileMetadata{Name: "a.bin", Size: 1, Hash: "abc", ProtocolVersion: protocol.CurrentVersion})
+	comp, _ := crypto.CompressChunk(payload)
+	if err := sender.Send(context.Background(), conn, comp, &models.ChunkMetadata{ID: "__filemeta__", Compression: models.CompressionZstd}); err != nil {
+		t.Fatalf("send file metadata: %v", err)
+	}
+	v, answered, err := sender.AwaitVersion(context.Background(), conn, protocol.CurrentVersion)
+	if err != nil || !answered || v != protocol.Version9 {
+		t.Fatalf("AwaitVersion through gateway = v%d, %v, %v; want v%d", v, answered, err, protocol.Version9)
+	}
+}
+
+func TestGatewayStepsDownForLegacyReceiver(t *testing.T) {
+	downstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer downstream.Close()
+
+	gw, err := NewGateway(GatewayConfig{ListenAddr: "127.0.0.1:0", ForwardAddr: downstream.Addr().String(), Compression: models.CompressionNone})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	gw.Start(context.Background())
+	defer gw.Close()
+
+	data := bytes.Repeat([]byte("legacy chunk "), 4096)
+	h := crypto.HashChunk(data)
+	chunk := &models.ChunkMetadata{ID: "0", Size: int64(len(data)), SHA256: fmt.Sprintf("%x", h[:])}
+	go func() {
+		sender := transport.NewTCPSender()
+		conn, err := sender.Connect(context.Background(), gw.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		payload, _ := json.Marshal(models.FileMetadata{Name: "a.bin", Size: int64(len(data)), Hash: "abc", ProtocolVersion: protocol.CurrentVersion})
+		comp, _ := crypto.CompressChunk(payload)
+		_ = sender.Send(context.Background(), conn, comp, &models.ChunkMetadata{ID: "__filemeta__", Compression: models.CompressionZstd})
+		_ = sender.SendStream(context.Background(), conn, bytes.NewReader(data), chunk)
+	}()
+
+	conn, err := downstream.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	// Read the way a v1 receiver does, answering nothing: every frame is a
+	// whole zstd payload.
+	for _, want := range []string{"__filemeta__", "0"} {
+		var metaLen uint32
+		if err := binary.Read(conn, binary.BigEndian, &metaLen); err != nil {
+			t.Fatalf("read meta length: %v", err)
+		}
+		metaBytes := make([]byte, metaLen)
+		if _, err := io.ReadFull(conn, metaBytes); err != nil {
+			t.Fatalf("read meta: %v", err)
+		}
+		var meta models.ChunkMetadata
+		if err := json.Unmarshal(metaBytes, &meta); err != nil || meta.ID != want {
+			t.Fatalf("frame %q (%v), want %q", meta.ID, err, want)
+		}
+		var dataLen uint64
+		if err := binary.Read(conn, binary.BigEndian, &dataLen); err != nil {
+			t.Fatalf("read data length: %v", err)
+		}
+		if dataLen > 1<<30 {
+			t.Fatalf("frame %s is streamed; v1 receivers cannot read it", want)
+		}
+		body := make([]byte, dataLen)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Fatalf("read data: %v", err)
+		}
+		got, err := crypto.DecompressChunk(body)
+		if err != nil {
+			t.Fatalf("frame %s: v1 decode: %v", want, err)
+		}
+		if want == "0" && !bytes.Equal(got, data) {
+			t.Fatal("chunk data mismatch")
+		}
+	}
+}
+
+func TestGatewayStopsDialingDeadReceiver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+
+	gw, err := NewGateway(GatewayConfig{ListenAddr: "127.0.0.1:0", ForwardAddr: dead})
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	defer gw.Close()
+	gw.breaker.MaxRetries = 0 // open after the first failure
+
+	in, _ := net.Pipe()
+	if err := gw.handle(context.Background(), in); err == nil || errors.Is(err, transport.ErrCircuitOpen) {
+		t.Fatalf("first session: %v, want a dial error", err)
+	}
+	in, _ = net.Pipe()
+	if err := gw.handle(context.Background(), in); !errors.Is(err, transport.ErrCircuitOpen) {
+		t.Fatalf("second session: %v, want ErrCircuitOpen", err)
+	}
+}
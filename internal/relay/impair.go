@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Impairment makes a Forwarder behave like a bad network, dropping and
+// delaying packets at random, to exercise FEC, retransmission and
+// congestion control without external tools like tc. The zero value
+// impairs nothing.
+type Impairment struct {
+	// Loss is the probability, from 0 to 1, that a packet is dropped.
+	Loss float64
+	// Latency is added to every packet, varied by up to Jitter either
+	// way, so that packets may also arrive out of order.
+	Latency time.Duration
+	Jitter  time.Duration
+}
+
+// Validate reports whether the impairment is possible.
+func (i Impairment) Validate() error {
+	if i.Loss < 0 || i.Loss > 1 {
+		return fmt.Errorf("loss %v is not a probability between 0 and 1", i.Loss)
+	}
+	if i.Latency < 0 || i.Jitter < 0 {
+		return fmt.Errorf("negative latency %v or jitter %v", i.Latency, i.Jitter)
+	}
+	return nil
+}
+
+// String describes the impairment for logs.
+func (i Impairment) String() string {
+	return fmt.Sprintf("%.1f%% loss, %v latency, %v jitter", i.Loss*100, i.Latency, i.Jitter)
+}
+
+// drop reports whether the next packet is lost.
+func (i Impairment) drop() bool {
+	return i.Loss > 0 && rand.Float64() < i.Loss
+}
+
+// delay returns how long to hold the next packet.
+func (i Impairment) delay() time.Duration {
+	d := i.Latency
+	if i.Jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*i.Jitter)+1)) - i.Jitter
+	}
+	return max(d, 0)
+}
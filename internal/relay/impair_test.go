@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestImpairmentValidate(t *testing.T) {
+	for _, bad := range []Impairment{{Loss: -0.1}, {Loss: 1.5}, {Latency: -time.Second}, {Jitter: -time.Millisecond}} {
+		if bad.Validate() == nil {
+			t.Fatalf("%+v accepted", bad)
+		}
+	}
+	i := Impairment{Loss: 0.2, Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond}
+	if err := i.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for range 1000 {
+		if d := i.delay(); d < 30*time.Millisecond || d > 70*time.Millisecond {
+			t.Fatalf("delay %v outside 50ms ± 20ms", d)
+		}
+	}
+	if d := (Impairment{Latency: time.Millisecond, Jitter: time.Second}).delay(); d < 0 {
+		t.Fatalf("negative delay %v", d)
+	}
+	if (Impairment{}).drop() || !(Impairment{Loss: 1}).drop() {
+		t.Fatal("loss of 0 or 1 not applied")
+	}
+}
+
+func TestForwarderImpairs(t *testing.T) {
+	rx, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rx.Close()
+	fwd, err := NewForwarder("127.0.0.1:0", rx.LocalAddr().String(), "impaired", "")
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+	fwd.Impair = Impairment{Latency: 100 * time.Millisecond}
+	fwd.Start()
+	defer fwd.Close()
+
+	tx, err := net.DialUDP("udp", nil, fwd.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	start := time.Now()
+	tx.Write([]byte("late"))
+	buf := make([]byte, 64)
+	rx.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := rx.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf[:n]) != "late" {
+		t.Fatalf("got %q", buf[:n])
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("packet arrived after %v, want at least 100ms", elapsed)
+	}
+}
@@ -0,0 +1,135 @@
+package relay
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// routeIdle is how long a session's route is kept after its last packet.
+const routeIdle = 10 * time.Minute
+
+// routeRefresh is how often a Forwarder expires idle routes and fetches the
+// routes the orchestrator knows of.
+const routeRefresh = 10 * time.Second
+
+// maxTrackedSeqs bounds how many unacknowledged data packets a route
+// remembers the sender of. Past it the record starts over; feedback on the
+// packets forgotten goes to the session's latest sender.
+const maxTrackedSeqs = 8192
+
+// route is where the packets of one session go.
+type route struct {
+	dest *net.UDPAddr // the receiver
+	// last is the sender heard from last, and senders the sender of each
+	// data packet not yet acknowledged, by sequence number, so that the
+	// feedback on a session sent from several addresses reaches the one
+	// that sent the packet.
+	last    *net.UDPAddr
+	senders map[uint32]*net.UDPAddr
+	seen    time.Time
+}
+
+// sender returns the sender feedback on packet seq goes to, forgetting
+// seq if forget is set.
+func (r *route) sender(seq uint32, forget bool) *net.UDPAddr {
+	if from, ok := r.senders[seq]; ok {
+		if forget {
+			delete(r.senders, seq)
+		}
+		return from
+	}
+	return r.last
+}
+
+// RouteTable maps sessions to the receivers a Forwarder relays their packets
+// to, so that one relay carries transfers to many receivers, and keeps the
+// return path of their ACKs and NACKs to the senders. It is safe for
+// concurrent use.
+type RouteTable struct {
+	mu     sync.Mutex
+	routes map[[16]byte]*route
+}
+
+// NewRouteTable returns an empty RouteTable.
+func NewRouteTable() *RouteTable {
+	return &RouteTable{routes: make(map[[16]byte]*route)}
+}
+
+// Set routes the packets of session to dest.
+func (t *RouteTable) Set(session [16]byte, dest *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r, ok := t.routes[session]; ok {
+		r.dest, r.seen = dest, time.Now()
+		return
+	}
+	t.routes[session] = &route{dest: dest, seen: time.Now()}
+}
+
+// Lookup returns the receiver the packets of session are routed to.
+func (t *RouteTable) Lookup(session [16]byte) (*net.UDPAddr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[session]
+	if !ok {
+		return nil, false
+	}
+	return r.dest, true
+}
+
+// Len returns the number of sessions routed.
+func (t *RouteTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.routes)
+}
+
+// next returns where a packet with header h that came from from goes.
+// ACKs and NACKs, and other packets from the session's receiver, go back to
+// the sender of the packet they answer, seq, or else to the session's latest
+// sender. Other packets go to the receiver, with fallback, if set, the
+// receiver of a session without a route. next returns nil if the packet has
+// nowhere to go.
+func (t *RouteTable) next(h protocol.PacketHeader, seq uint32, from, fallback *net.UDPAddr, now time.Time) *net.UDPAddr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[h.SessionID]
+	feedback := h.Type == protocol.PacketTypeAck || h.Type == protocol.PacketTypeNack
+	if !ok {
+		if fallback == nil || feedback {
+			return nil
+		}
+		r = &route{dest: fallback}
+		t.routes[h.SessionID] = r
+	}
+	r.seen = now
+	if feedback || sameAddr(from, r.dest) {
+		return r.sender(seq, h.Type == protocol.PacketTypeAck)
+	}
+	r.last = from
+	if h.Type == protocol.PacketTypeData {
+		if r.senders == nil || len(r.senders) >= maxTrackedSeqs {
+			r.senders = make(map[uint32]*net.UDPAddr)
+		}
+		r.senders[h.Seq] = from
+	}
+	return r.dest
+}
+
+// expire forgets the routes without packets for routeIdle as of now.
+func (t *RouteTable) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, r := range t.routes {
+		if now.Sub(r.seen) > routeIdle {
+			delete(t.routes, id)
+		}
+	}
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.Port == b.Port && a.IP.Equal(b.IP)
+}
@@ -0,0 +1,217 @@
+package relay
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// TrafficCounters count the packets a relay forwarded and those it dropped
+// for going over a rate limit.
+type TrafficCounters struct {
+	Packets      uint64 `json:"packets"`
+	Bytes        uint64 `json:"bytes"`
+	Dropped      uint64 `json:"dropped"`
+	DroppedBytes uint64 `json:"dropped_bytes"`
+}
+
+func (c *TrafficCounters) count(n int, ok bool) {
+	if ok {
+		c.Packets++
+		c.Bytes += uint64(n)
+	} else {
+		c.Dropped++
+		c.DroppedBytes += uint64(n)
+	}
+}
+
+// SessionUsage is the traffic of one session through a relay.
+type SessionUsage struct {
+	Session string `json:"session"`
+	// Source is the address the session was last sent from.
+	Source string `json:"source"`
+	TrafficCounters
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// SourceUsage is the traffic from one address through a relay, over all
+// its sessions.
+type SourceUsage struct {
+	Source string `json:"source"`
+	TrafficCounters
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// UsageStats is what Usage.Stats reports. Sessions and sources are listed
+// heaviest first.
+type UsageStats struct {
+	// SessionRate and SourceRate are the limits in force, in bytes per
+	// second; zero is unlimited.
+	SessionRate float64         `json:"session_rate"`
+	SourceRate  float64         `json:"source_rate"`
+	Total       TrafficCounters `json:"total"`
+	Sessions    []SessionUsage  `json:"sessions"`
+	Sources     []SourceUsage   `json:"sources"`
+}
+
+// usage is the traffic of one session or source and the limiter holding it
+// to its rate.
+type usage struct {
+	TrafficCounters
+	limit *ratelimit.Limiter
+	from  string
+	seen  time.Time
+}
+
+// Usage counts the traffic a Forwarder relays, in all, by session and by
+// source address, and drops what goes over the rate limits set per session
+// and per source, so that operators can see and cap which transfers use a
+// relay. The zero value is not usable; call NewUsage. It is safe for
+// concurrent use.
+type Usage struct {
+	mu          sync.Mutex
+	sessionRate float64
+	sourceRate  float64
+	total       TrafficCounters
+	sessions    map[[16]byte]*usage
+	sources     map[netip.Addr]*usage
+}
+
+// NewUsage returns a Usage without rate limits.
+func NewUsage() *Usage {
+	return &Usage{
+		sessions: make(map[[16]byte]*usage),
+		sources:  make(map[netip.Addr]*usage),
+	}
+}
+
+// SetLimits sets the rates, in bytes per second, that each session and
+// each source address may send at. Zero removes a limit.
+func (u *Usage) SetLimits(perSession, perSource float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sessionRate, u.sourceRate = perSession, perSource
+	for _, s := range u.sessions {
+		s.limit.SetRate(perSession)
+	}
+	for _, s := range u.sources {
+		s.limit.SetRate(perSource)
+	}
+}
+
+// admit counts a packet of n bytes from from, with header h if it is a
+// trackshift packet, and reports whether it is within the rate limits.
+// ACKs and NACKs are counted but never dropped, as losing them only makes
+// the sender resend more.
+func (u *Usage) admit(h *protocol.PacketHeader, from *net.UDPAddr, n int, now time.Time) bool {
+	src := from.AddrPort().Addr().Unmap()
+	police := h == nil || (h.Type != protocol.PacketTypeAck && h.Type != protocol.PacketTypeNack)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	source, ok := u.sources[src]
+	if !ok {
+		source = &usage{limit: ratelimit.New(u.sourceRate)}
+		u.sources[src] = source
+	}
+	var session *usage
+	if h != nil {
+		if session, ok = u.sessions[h.SessionID]; !ok {
+			session = &usage{limit: ratelimit.New(u.sessionRate)}
+			u.sessions[h.SessionID] = session
+		}
+	}
+	// A session over its limit does not eat into its source's.
+	allowed := !police || ((session == nil || session.limit.AllowN(n)) && source.limit.AllowN(n))
+	u.total.count(n, allowed)
+	source.count(n, allowed)
+	source.seen = now
+	if session != nil {
+		session.count(n, allowed)
+		session.seen = now
+		if police {
+			session.from = from.String()
+		}
+	}
+	return allowed
+}
+
+// expire forgets the sessions and sources without packets for routeIdle
+// as of now. The total keeps their traffic.
+func (u *Usage) expire(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, s := range u.sessions {
+		if now.Sub(s.seen) > routeIdle {
+			delete(u.sessions, id)
+		}
+	}
+	for src, s := range u.sources {
+		if now.Sub(s.seen) > routeIdle {
+			delete(u.sources, src)
+		}
+	}
+}
+
+// Stats returns the traffic counted so far.
+func (u *Usage) Stats() UsageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	st := UsageStats{
+		SessionRate: u.sessionRate,
+		SourceRate:  u.sourceRate,
+		Total:       u.total,
+		Sessions:    make([]SessionUsage, 0, len(u.sessions)),
+		Sources:     make([]SourceUsage, 0, len(u.sources)),
+	}
+	for id, s := range u.sessions {
+		st.Sessions = append(st.Sessions, SessionUsage{
+			Session:         uuid.UUID(id).String(),
+			Source:          s.from,
+			TrafficCounters: s.TrafficCounters,
+			LastSeen:        s.seen,
+		})
+	}
+	for src, s := range u.sources {
+		st.Sources = append(st.Sources, SourceUsage{
+			Source:          src.String(),
+			TrafficCounters: s.TrafficCounters,
+			LastSeen:        s.seen,
+		})
+	}
+	slices.SortFunc(st.Sessions, func(a, b SessionUsage) int { return heavier(a.TrafficCounters, b.TrafficCounters) })
+	slices.SortFunc(st.Sources, func(a, b SourceUsage) int { return heavier(a.TrafficCounters, b.TrafficCounters) })
+	return st
+}
+
+// heavier orders counters by the bytes they carried, most first.
+func heavier(a, b TrafficCounters) int {
+	switch {
+	case a.Bytes+a.DroppedBytes > b.Bytes+b.DroppedBytes:
+		return -1
+	case a.Bytes+a.DroppedBytes < b.Bytes+b.DroppedBytes:
+		return 1
+	}
+	return 0
+}
+
+// Handler serves the traffic counted so far on GET, as UsageStats.
+func (u *Usage) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u.Stats())
+	})
+}
@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+func TestUsageCountsAndLimits(t *testing.T) {
+	u := NewUsage()
+	// Bursts of 5 packets per session and 7 per source.
+	u.SetLimits(1e6, 1.4e6)
+	txA := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+	txB := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 5000}
+	now := time.Now()
+	send := func(session byte, from *net.UDPAddr) bool {
+		h := &protocol.PacketHeader{Type: protocol.PacketTypeData, SessionID: [16]byte{session}}
+		return u.admit(h, from, 10000, now)
+	}
+
+	// Session 1 runs into its own limit, sessions 2 and 3 into their
+	// source's.
+	sent := map[byte]int{}
+	for range 10 {
+		for _, s := range []struct {
+			session byte
+			from    *net.UDPAddr
+		}{{1, txA}, {2, txB}, {3, txB}} {
+			if send(s.session, s.from) {
+				sent[s.session]++
+			}
+		}
+	}
+	if sent[1] != 5 || sent[2]+sent[3] != 7 {
+		t.Fatalf("admitted %v packets, want 5 of session 1 and 7 of sessions 2 and 3", sent)
+	}
+	// Feedback is not held back.
+	ack := &protocol.PacketHeader{Type: protocol.PacketTypeAck, SessionID: [16]byte{1}}
+	if !u.admit(ack, txB, 100, now) {
+		t.Fatal("ACK dropped")
+	}
+
+	rec := httptest.NewRecorder()
+	u.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var st UsageStats
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	want := TrafficCounters{Packets: 13, Bytes: 120100, Dropped: 18, DroppedBytes: 180000}
+	if st.Total != want {
+		t.Fatalf("total = %+v, want %+v", st.Total, want)
+	}
+	if len(st.Sessions) != 3 || st.Sessions[0].Packets != 6 || st.Sessions[0].Source != txA.String() {
+		t.Fatalf("sessions = %+v", st.Sessions)
+	}
+	if len(st.Sources) != 2 || st.Sources[0].Source != "10.0.0.3" || st.Sources[0].Dropped != 13 {
+		t.Fatalf("sources = %+v", st.Sources)
+	}
+
+	u.expire(now.Add(routeIdle + time.Second))
+	if st := u.Stats(); len(st.Sessions) != 0 || len(st.Sources) != 0 || st.Total != want {
+		t.Fatalf("after expiry: %+v", st)
+	}
+}
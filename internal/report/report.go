@@ -0,0 +1,201 @@
+// Package report builds the machine-readable summary a sender or receiver
+// writes as each session ends, for CI jobs and other automation that would
+// otherwise scrape the log.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Roles of the process writing a summary.
+const (
+	RoleSender   = "sender"
+	RoleReceiver = "receiver"
+)
+
+// Statuses of a session at the end of the run.
+const (
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// Verification outcomes. A sender only learns that the receiver verified
+// the file from a delivery receipt, so without one it reports unverified.
+const (
+	Verified   = "verified"
+	Unverified = "unverified"
+	Failed     = "failed"
+)
+
+// ChunkFailure is a chunk that failed to send, or that the receiver
+// rejected or failed to store.
+type ChunkFailure struct {
+	Chunk  string `json:"chunk"`
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+}
+
+// Summary describes how one session went.
+type Summary struct {
+	Role    string `json:"role"`
+	Session string `json:"session"`
+	File    string `json:"file"`
+	// Peer is the receiver a sender sent to, the sender a receiver heard
+	// from.
+	Peer   string `json:"peer,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// Bytes is the size of the file; Transferred is how much of it was
+	// sent or received in this run, counting retransmits, and WireBytes
+	// what that took on the wire, where known.
+	Bytes       int64 `json:"bytes"`
+	Transferred int64 `json:"bytes_transferred"`
+	WireBytes   int64 `json:"wire_bytes,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Elapsed    float64   `json:"elapsed_seconds"`
+	// Throughput is bytes transferred per second.
+	Throughput float64 `json:"throughput_bytes_per_sec"`
+	// CompressionRatio is wire bytes per file byte transferred, below 1
+	// when compression saved bandwidth.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+
+	// Retransmits counts chunks sent, or received, again; Attempts the
+	// session attempts a sender made.
+	Retransmits     int            `json:"retransmits"`
+	RetransmitBytes int64          `json:"retransmit_bytes,omitempty"`
+	Attempts        int            `json:"attempts,omitempty"`
+	ChunkFailures   []ChunkFailure `json:"chunk_failures,omitempty"`
+
+	Verification string `json:"verification"`
+	// Note says why a completed session is unverified, where that is not
+	// a failure.
+	Note string `json:"note,omitempty"`
+}
+
+// Finish stamps s as finished at t and derives the elapsed time, throughput
+// and compression ratio from the counts filled in.
+func (s *Summary) Finish(t time.Time) {
+	s.FinishedAt = t
+	if d := t.Sub(s.StartedAt); d > 0 {
+		s.Elapsed = d.Seconds()
+		s.Throughput = float64(s.Transferred) / s.Elapsed
+	}
+	if s.WireBytes > 0 && s.Transferred > 0 {
+		s.CompressionRatio = float64(s.WireBytes) / float64(s.Transferred)
+	}
+}
+
+// Tally collects the per-chunk outcomes of a session while it runs. A nil
+// *Tally discards them.
+type Tally struct {
+	mu          sync.Mutex
+	attempts    int
+	retransmits int
+	failures    []ChunkFailure
+	// verification, if set, overrides the summary's verification outcome.
+	verification string
+}
+
+// Attempt records the start of a session attempt.
+func (t *Tally) Attempt() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts++
+}
+
+// Retransmit records a chunk sent, or received, again.
+func (t *Tally) Retransmit() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retransmits++
+}
+
+// ChunkFailed records that the chunk at offset failed with err.
+func (t *Tally) ChunkFailed(chunk string, offset int64, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = append(t.failures, ChunkFailure{Chunk: chunk, Offset: offset, Error: err.Error()})
+}
+
+// SetVerification records how verification of the session went, for
+// outcomes only known while it runs, such as a delivery receipt.
+func (t *Tally) SetVerification(v string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.verification = v
+}
+
+// Fill copies the tally into s.
+func (t *Tally) Fill(s *Summary) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.Attempts = t.attempts
+	s.Retransmits = t.retransmits
+	s.ChunkFailures = append([]ChunkFailure(nil), t.failures...)
+	if t.verification != "" {
+		s.Verification = t.verification
+	}
+}
+
+// Writer writes summaries as newline-delimited JSON, one line per session.
+// A nil *Writer discards them.
+type Writer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// Open returns a Writer appending to the file at path, or writing to
+// standard output if path is "-".
+func Open(path string) (*Writer, error) {
+	if path == "-" {
+		return &Writer{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open report file: %w", err)
+	}
+	return &Writer{enc: json.NewEncoder(f), closer: f}, nil
+}
+
+// Write writes s.
+func (w *Writer) Write(s Summary) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(s)
+}
+
+// Close closes the report file.
+func (w *Writer) Close() error {
+	if w == nil || w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
@@ -0,0 +1,78 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummaryFinish(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := Summary{StartedAt: start, Transferred: 1000, WireBytes: 250}
+	s.Finish(start.Add(2 * time.Second))
+	if s.Elapsed != 2 || s.Throughput != 500 || s.CompressionRatio != 0.25 {
+		t.Fatalf("Finish = elapsed %v, throughput %v, ratio %v", s.Elapsed, s.Throughput, s.CompressionRatio)
+	}
+
+	// Nothing transferred yields no ratio rather than a division by zero.
+	empty := Summary{StartedAt: start, WireBytes: 10}
+	empty.Finish(start.Add(time.Second))
+	if empty.CompressionRatio != 0 || empty.Throughput != 0 {
+		t.Fatalf("empty summary = %+v", empty)
+	}
+}
+
+func TestTallyAndWriter(t *testing.T) {
+	var tally Tally
+	tally.Attempt()
+	tally.Attempt()
+	tally.Retransmit()
+	tally.ChunkFailed("3", 4096, errors.New("broken pipe"))
+	tally.SetVerification(Verified)
+
+	s := Summary{Role: RoleSender, Session: "s1", Status: StatusCompleted, Verification: Unverified}
+	tally.Fill(&s)
+	if s.Attempts != 2 || s.Retransmits != 1 || len(s.ChunkFailures) != 1 || s.ChunkFailures[0].Offset != 4096 || s.Verification != Verified {
+		t.Fatalf("Fill = %+v", s)
+	}
+	var none *Tally
+	none.Retransmit()
+	none.Fill(&s)
+
+	path := filepath.Join(t.TempDir(), "report.ndjson")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"s1", "s2"} {
+		s.Session = id
+		if err := w.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var got Summary
+		if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+			t.Fatalf("decode %q: %v", sc.Text(), err)
+		}
+		ids = append(ids, got.Session)
+	}
+	if len(ids) != 2 || ids[1] != "s2" {
+		t.Fatalf("report lines = %v", ids)
+	}
+}
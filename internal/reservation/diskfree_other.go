@@ -0,0 +1,11 @@
+//go:build !unix
+
+package reservation
+
+import "errors"
+
+// DiskFree is not implemented on this platform; ledgers then skip disk
+// checks.
+func DiskFree(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,115 @@
+package reservation
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+// Request is the body of a reservation request. The window runs from Start
+// (default now) to End, or for Duration if End is not given.
+type Request struct {
+	Holder string `json:"holder,omitempty"`
+	Bytes  int64  `json:"bytes"`
+	// Bandwidth is a rate such as "100MB/s"; empty reserves no slot.
+	Bandwidth string    `json:"bandwidth,omitempty"`
+	Start     time.Time `json:"start,omitempty"`
+	End       time.Time `json:"end,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+}
+
+// reservation converts req to a reservation.
+func (req Request) reservation(now time.Time) (Reservation, error) {
+	rate, err := ratelimit.ParseRate(req.Bandwidth)
+	if err != nil {
+		return Reservation{}, err
+	}
+	r := Reservation{Holder: req.Holder, Bytes: req.Bytes, BytesPerSec: rate, Start: req.Start, End: req.End}
+	if r.Start.IsZero() {
+		r.Start = now
+	}
+	if r.End.IsZero() {
+		if req.Duration == "" {
+			return Reservation{}, errors.New("give an end or a duration")
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return Reservation{}, err
+		}
+		r.End = r.Start.Add(d)
+	}
+	return r, nil
+}
+
+// Handler serves the ledger on mux paths /api/v1/reservations and
+// /api/v1/reservations/:
+//
+//	GET    /api/v1/reservations        reservations in force
+//	POST   /api/v1/reservations        body Request; 201 with the reservation, 409 if it does not fit
+//	GET    /api/v1/reservations/{id}   one reservation
+//	DELETE /api/v1/reservations/{id}   cancels it
+func (l *Ledger) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/reservations"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, l.List())
+		case id == "" && r.Method == http.MethodPost:
+			l.handleReserve(w, r)
+		case id != "" && r.Method == http.MethodGet:
+			for _, res := range l.List() {
+				if res.ID == id {
+					writeJSON(w, http.StatusOK, res)
+					return
+				}
+			}
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrUnknown.Error()})
+		case id != "" && r.Method == http.MethodDelete:
+			if err := l.Cancel(id); err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Reservation %s cancelled", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (l *Ledger) handleReserve(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	res, err := req.reservation(l.now())
+	if err == nil {
+		res, err = l.Reserve(res)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrInsufficientDisk) || errors.Is(err, ErrInsufficientBandwidth) {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Reservation %s: %s until %s for %q", res.ID, utils.HumanBytes(res.Bytes),
+		res.End.Format(time.RFC3339), res.Holder)
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON error: %v", err)
+	}
+}
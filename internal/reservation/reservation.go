@@ -0,0 +1,276 @@
+// Package reservation lets senders and orchestrators reserve disk capacity
+// and a bandwidth slot on a receiver for a time window before a large
+// transfer. The receiver admits each session against the ledger, so a
+// transfer that was promised space does not run out of it half way.
+package reservation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInsufficientDisk is returned when the free disk space, less what is
+	// reserved or still to be written by admitted sessions, is too small.
+	ErrInsufficientDisk = errors.New("not enough unreserved disk space")
+	// ErrInsufficientBandwidth is returned when the bandwidth slots of
+	// overlapping reservations would exceed the receiver's capacity.
+	ErrInsufficientBandwidth = errors.New("not enough unreserved bandwidth")
+	// ErrUnknown is returned for reservation IDs the ledger does not hold.
+	ErrUnknown = errors.New("no such reservation")
+	// ErrNotActive is returned when a session claims a reservation outside
+	// its window.
+	ErrNotActive = errors.New("reservation is not active")
+	// ErrClaimed is returned when a session claims a reservation held by
+	// another session.
+	ErrClaimed = errors.New("reservation is held by another session")
+	// ErrTooLarge is returned when a session is larger than its reservation.
+	ErrTooLarge = errors.New("transfer is larger than its reservation")
+)
+
+// Reservation holds disk capacity, and optionally a bandwidth slot, for a
+// transfer starting within [Start, End).
+type Reservation struct {
+	ID string `json:"id"`
+	// Holder is a free-form label, e.g. the sender or orchestrator job the
+	// reservation was made for.
+	Holder      string    `json:"holder,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	BytesPerSec float64   `json:"bytes_per_sec,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// SessionID is the receiver session holding the reservation, if any.
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// overlaps reports whether r's window overlaps [start, end).
+func (r *Reservation) overlaps(start, end time.Time) bool {
+	return r.Start.Before(end) && start.Before(r.End)
+}
+
+// admitted is a session admitted by the ledger.
+type admitted struct {
+	remaining   int64  // bytes still to be written
+	reservation string // ID of the reservation it claimed, if any
+}
+
+// Ledger tracks reservations and the sessions admitted against them. It is
+// safe for concurrent use.
+type Ledger struct {
+	mu sync.Mutex
+	// free returns the free disk space; errors.ErrUnsupported disables
+	// disk checks.
+	free func() (int64, error)
+	// bandwidth is the receiver's capacity shared by bandwidth slots; zero
+	// leaves slots unchecked.
+	bandwidth    float64
+	now          func() time.Time
+	reservations map[string]*Reservation
+	sessions     map[string]*admitted
+}
+
+// NewLedger returns a ledger for the file system holding dir. bandwidth is
+// the capacity, in bytes per second, that bandwidth slots may add up to;
+// zero leaves them unchecked.
+func NewLedger(dir string, bandwidth float64) *Ledger {
+	return newLedger(func() (int64, error) { return DiskFree(dir) }, bandwidth)
+}
+
+func newLedger(free func() (int64, error), bandwidth float64) *Ledger {
+	return &Ledger{
+		free:         free,
+		bandwidth:    bandwidth,
+		now:          time.Now,
+		reservations: make(map[string]*Reservation),
+		sessions:     make(map[string]*admitted),
+	}
+}
+
+// expireLocked drops reservations whose window has passed and that no
+// session holds.
+func (l *Ledger) expireLocked(now time.Time) {
+	for id, r := range l.reservations {
+		if r.SessionID == "" && !now.Before(r.End) {
+			delete(l.reservations, id)
+		}
+	}
+}
+
+// outstandingLocked returns the disk space promised but not yet used: the
+// size of unclaimed reservations and what admitted sessions have still to
+// write.
+func (l *Ledger) outstandingLocked() int64 {
+	var n int64
+	for _, r := range l.reservations {
+		if r.SessionID == "" {
+			n += r.Bytes
+		}
+	}
+	for _, s := range l.sessions {
+		n += s.remaining
+	}
+	return n
+}
+
+// checkDiskLocked returns ErrInsufficientDisk unless n more bytes fit in the
+// free disk space besides what is outstanding.
+func (l *Ledger) checkDiskLocked(n int64) error {
+	free, err := l.free()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check free disk space: %w", err)
+	}
+	if avail := free - l.outstandingLocked(); n > avail {
+		return fmt.Errorf("%w: %d bytes requested, %d available", ErrInsufficientDisk, n, max(avail, 0))
+	}
+	return nil
+}
+
+// Reserve records r, giving it an ID, if its bytes fit on disk and its
+// bandwidth slot fits the capacity alongside the reservations overlapping
+// its window. A zero Start means now.
+func (l *Ledger) Reserve(r Reservation) (Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.expireLocked(now)
+	if r.Start.IsZero() {
+		r.Start = now
+	}
+	switch {
+	case r.Bytes <= 0:
+		return Reservation{}, errors.New("bytes must be positive")
+	case r.BytesPerSec < 0:
+		return Reservation{}, errors.New("bandwidth must not be negative")
+	case !r.End.After(r.Start):
+		return Reservation{}, errors.New("end must be after start")
+	case !r.End.After(now):
+		return Reservation{}, errors.New("window has already ended")
+	}
+	if err := l.checkDiskLocked(r.Bytes); err != nil {
+		return Reservation{}, err
+	}
+	if l.bandwidth > 0 && r.BytesPerSec > 0 {
+		used := r.BytesPerSec
+		for _, o := range l.reservations {
+			if o.overlaps(r.Start, r.End) {
+				used += o.BytesPerSec
+			}
+		}
+		if used > l.bandwidth {
+			return Reservation{}, fmt.Errorf("%w: %.0f bytes/s requested, %.0f available",
+				ErrInsufficientBandwidth, r.BytesPerSec, max(l.bandwidth-(used-r.BytesPerSec), 0))
+		}
+	}
+	r.ID = uuid.NewString()
+	r.SessionID = ""
+	l.reservations[r.ID] = &r
+	return r, nil
+}
+
+// Cancel drops reservation id. A session holding it keeps running.
+func (l *Ledger) Cancel(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.reservations[id]; !ok {
+		return ErrUnknown
+	}
+	delete(l.reservations, id)
+	for _, s := range l.sessions {
+		if s.reservation == id {
+			s.reservation = ""
+		}
+	}
+	return nil
+}
+
+// List returns the reservations in force, ordered by start.
+func (l *Ledger) List() []Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expireLocked(l.now())
+	out := make([]Reservation, 0, len(l.reservations))
+	for _, r := range l.reservations {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Start.Equal(out[j].Start) {
+			return out[i].Start.Before(out[j].Start)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Admit decides whether session sessionID, about to write size bytes, may
+// proceed. With a reservation ID the session claims that reservation, which
+// must be active, unheld and large enough, and it is returned so its
+// bandwidth slot can be applied. Without one the session must fit in the
+// disk space nobody has reserved.
+func (l *Ledger) Admit(sessionID, reservationID string, size int64) (*Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.expireLocked(now)
+	if reservationID == "" {
+		if err := l.checkDiskLocked(size); err != nil {
+			return nil, err
+		}
+		l.sessions[sessionID] = &admitted{remaining: size}
+		return nil, nil
+	}
+	r, ok := l.reservations[reservationID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w %s", ErrUnknown, reservationID)
+	case now.Before(r.Start) || !now.Before(r.End):
+		return nil, fmt.Errorf("%w: window is %s to %s", ErrNotActive,
+			r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	case r.SessionID != "" && r.SessionID != sessionID:
+		return nil, fmt.Errorf("%w %s", ErrClaimed, r.SessionID)
+	case size > r.Bytes:
+		return nil, fmt.Errorf("%w: %d bytes, %d reserved", ErrTooLarge, size, r.Bytes)
+	}
+	r.SessionID = sessionID
+	l.sessions[sessionID] = &admitted{remaining: size, reservation: r.ID}
+	claimed := *r
+	return &claimed, nil
+}
+
+// Wrote records that session sessionID wrote n more bytes.
+func (l *Ledger) Wrote(sessionID string, n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.sessions[sessionID]; ok {
+		s.remaining = max(s.remaining-n, 0)
+	}
+}
+
+// Done records the end of session sessionID. A reservation it held is used
+// up if the data was delivered; otherwise it is released for a resume to
+// claim within its window.
+func (l *Ledger) Done(sessionID string, delivered bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.sessions[sessionID]
+	if !ok {
+		return
+	}
+	delete(l.sessions, sessionID)
+	r, ok := l.reservations[s.reservation]
+	if !ok {
+		return
+	}
+	if delivered {
+		delete(l.reservations, r.ID)
+	} else {
+		r.SessionID = ""
+	}
+}
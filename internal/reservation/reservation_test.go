@@ -0,0 +1,150 @@
+package reservation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLedger returns a ledger with free bytes of disk, bandwidth capacity
+// and a clock the test moves.
+func testLedger(free int64, bandwidth float64) (*Ledger, *time.Time) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newLedger(func() (int64, error) { return free, nil }, bandwidth)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestReserveChecksCapacity(t *testing.T) {
+	l, now := testLedger(1000, 100)
+	a, err := l.Reserve(Reservation{Bytes: 600, BytesPerSec: 60, End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if a.ID == "" || !a.Start.Equal(*now) {
+		t.Fatalf("reservation = %+v", a)
+	}
+	if _, err := l.Reserve(Reservation{Bytes: 500, End: now.Add(time.Hour)}); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("over-reserving disk: %v", err)
+	}
+	if _, err := l.Reserve(Reservation{Bytes: 100, BytesPerSec: 50, End: now.Add(time.Hour)}); !errors.Is(err, ErrInsufficientBandwidth) {
+		t.Fatalf("over-reserving bandwidth: %v", err)
+	}
+	// A slot after the first window ends has the bandwidth to itself.
+	later := now.Add(2 * time.Hour)
+	if _, err := l.Reserve(Reservation{Bytes: 100, BytesPerSec: 50, Start: later, End: later.Add(time.Hour)}); err != nil {
+		t.Fatalf("Reserve of a later window: %v", err)
+	}
+	if _, err := l.Reserve(Reservation{Bytes: 1, End: now.Add(-time.Minute)}); err == nil {
+		t.Fatal("Reserve of a window in the past succeeded")
+	}
+
+	// Unclaimed reservations lapse with their window.
+	*now = now.Add(4 * time.Hour)
+	if got := l.List(); len(got) != 0 {
+		t.Fatalf("expired reservations listed: %+v", got)
+	}
+	if err := l.Cancel(a.ID); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("Cancel of an expired reservation: %v", err)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	l, now := testLedger(1000, 0)
+	r, err := l.Reserve(Reservation{Bytes: 700, Start: now.Add(time.Minute), End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions without a reservation only get the unreserved space.
+	if _, err := l.Admit("s1", "", 400); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("Admit beyond unreserved space: %v", err)
+	}
+	if _, err := l.Admit("s1", "", 300); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+	if _, err := l.Admit("s2", "", 1); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("Admit while s1 has the space: %v", err)
+	}
+	// Written bytes show in the free space instead of being counted twice.
+	l.Wrote("s1", 300)
+	l.free = func() (int64, error) { return 700, nil }
+	if _, err := l.Admit("s2", "", 1); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("Admit with only reserved space left: %v", err)
+	}
+	l.Done("s1", true)
+
+	if _, err := l.Admit("s3", r.ID, 500); !errors.Is(err, ErrNotActive) {
+		t.Fatalf("Admit before the window: %v", err)
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, err := l.Admit("s3", "missing", 500); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("Admit with an unknown reservation: %v", err)
+	}
+	if _, err := l.Admit("s3", r.ID, 800); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Admit larger than the reservation: %v", err)
+	}
+	got, err := l.Admit("s3", r.ID, 500)
+	if err != nil || got.ID != r.ID {
+		t.Fatalf("Admit = %+v, %v", got, err)
+	}
+	if _, err := l.Admit("s4", r.ID, 500); !errors.Is(err, ErrClaimed) {
+		t.Fatalf("Admit of a held reservation: %v", err)
+	}
+
+	// A failed session releases the reservation for a resume; a delivered
+	// one uses it up.
+	l.Done("s3", false)
+	if _, err := l.Admit("s4", r.ID, 500); err != nil {
+		t.Fatalf("Admit after release: %v", err)
+	}
+	l.Done("s4", true)
+	if len(l.List()) != 0 {
+		t.Fatalf("used reservation still listed: %+v", l.List())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	l, _ := testLedger(1<<30, 0)
+	srv := httptest.NewServer(l.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/v1/reservations", "application/json",
+		strings.NewReader(`{"holder": "job-1", "bytes": 1048576, "bandwidth": "10MB/s", "duration": "1h"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Reservation
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: %s, %v", resp.Status, err)
+	}
+	if r.BytesPerSec != 10<<20 || r.End.Sub(r.Start) != time.Hour || r.Holder != "job-1" {
+		t.Fatalf("reservation = %+v", r)
+	}
+
+	resp, err = http.Post(srv.URL+"/api/v1/reservations", "application/json",
+		strings.NewReader(`{"bytes": 1073741824, "duration": "1h"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("POST beyond capacity: %s", resp.Status)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/v1/reservations/"+r.ID, nil)
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: %v %v", resp, err)
+	}
+	resp, err = http.Get(srv.URL + "/api/v1/reservations/" + r.ID)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET of a cancelled reservation: %v %v", resp, err)
+	}
+	resp.Body.Close()
+}
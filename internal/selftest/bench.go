@@ -0,0 +1,262 @@
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// DefaultBenchChunkSizes are the chunk sizes Bench compares unless told
+// otherwise.
+var DefaultBenchChunkSizes = []int64{1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+// BenchConfig selects what Bench measures.
+type BenchConfig struct {
+	// Size is the number of bytes each loopback transfer carries.
+	Size int64
+	// Profile is the kind of test data; it matters for compression.
+	Profile genfile.Profile
+	// Protocols are the transports measured: "tcp", "udp" or both, the
+	// default.
+	Protocols []string
+	// ChunkSizes are the chunk sizes each protocol is measured with; empty
+	// means DefaultBenchChunkSizes.
+	ChunkSizes []int64
+	// Compression is the chunk compression TCP transfers are measured with
+	// besides none: auto or zstd, the default. None measures TCP
+	// uncompressed only.
+	Compression string
+}
+
+// BenchResult is one loopback transfer. CPU time and allocations are those
+// of the whole process, sender and receiver together.
+type BenchResult struct {
+	Protocol    string        `json:"protocol"`
+	ChunkSize   int64         `json:"chunk_size"`
+	Compression string        `json:"compression"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration_ns"`
+	// CPU is the user and system CPU time used; zero where the platform
+	// does not report it.
+	CPU time.Duration `json:"cpu_ns,omitempty"`
+	// Allocs and AllocBytes count the heap allocations made.
+	Allocs     uint64 `json:"allocs"`
+	AllocBytes uint64 `json:"alloc_bytes"`
+	// WireBytes is what crossed the connection, for TCP.
+	WireBytes int64 `json:"wire_bytes,omitempty"`
+	// CPUOverhead is, for compressed transfers, the CPU time compression
+	// added as a fraction of that of the uncompressed transfer with the
+	// same chunk size.
+	CPUOverhead float64 `json:"cpu_overhead,omitempty"`
+	Note        string  `json:"note,omitempty"`
+}
+
+// Rate returns the throughput of the transfer in bytes per second.
+func (r BenchResult) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Ratio returns how many times smaller than the data the bytes on the wire
+// were, or 0 if they were not counted.
+func (r BenchResult) Ratio() float64 {
+	if r.WireBytes <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / float64(r.WireBytes)
+}
+
+// BenchReport holds the results of a benchmark in the order they were
+// measured.
+type BenchReport struct {
+	Results []BenchResult `json:"results"`
+	// Fastest is the transfer with the highest throughput per protocol.
+	Fastest []BenchResult `json:"fastest"`
+}
+
+// Bench transfers cfg.Size bytes of generated data to an in-process
+// receiver over loopback with each protocol and chunk size, TCP both
+// uncompressed and with cfg.Compression, measuring each transfer.
+func Bench(cfg BenchConfig) (*BenchReport, error) {
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", cfg.Size)
+	}
+	if len(cfg.Protocols) == 0 {
+		cfg.Protocols = []string{"tcp", "udp"}
+	}
+	for _, p := range cfg.Protocols {
+		if p != "tcp" && p != "udp" {
+			return nil, fmt.Errorf("unknown protocol %q", p)
+		}
+	}
+	if len(cfg.ChunkSizes) == 0 {
+		cfg.ChunkSizes = DefaultBenchChunkSizes
+	}
+	for _, cs := range cfg.ChunkSizes {
+		if cs <= 0 {
+			return nil, fmt.Errorf("invalid chunk size %d", cs)
+		}
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = models.CompressionZstd
+	}
+	switch cfg.Compression {
+	case "auto", models.CompressionZstd, models.CompressionNone:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	gen, err := genfile.New(genfile.Options{Size: cfg.Size, Seed: 1, Profile: cfg.Profile})
+	if err != nil {
+		return nil, err
+	}
+	sample := make([]byte, min(cfg.Size, bufferSize))
+	if _, err := gen.ReadAt(sample, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	src := &repeatReader{buf: sample}
+
+	rep := &BenchReport{}
+	for _, proto := range cfg.Protocols {
+		fastest := -1
+		for _, cs := range cfg.ChunkSizes {
+			var results []BenchResult
+			if proto == "udp" {
+				r, err := measure(func() (BenchResult, error) { return udpChunkLoopback(cfg.Size, cs, src) })
+				if err != nil {
+					return nil, fmt.Errorf("udp, %d-byte chunks: %w", cs, err)
+				}
+				results = append(results, r)
+			} else {
+				compressions := []string{models.CompressionNone}
+				if cfg.Compression != models.CompressionNone {
+					compressions = append(compressions, cfg.Compression)
+				}
+				for _, c := range compressions {
+					r, err := measure(func() (BenchResult, error) { return tcpBench(cfg.Size, cs, c, src) })
+					if err != nil {
+						return nil, fmt.Errorf("tcp, %d-byte chunks, compression %s: %w", cs, c, err)
+					}
+					results = append(results, r)
+				}
+				if raw := results[0]; len(results) > 1 && raw.CPU > 0 {
+					results[1].CPUOverhead = float64(results[1].CPU-raw.CPU) / float64(raw.CPU)
+				}
+			}
+			for _, r := range results {
+				if fastest < 0 || r.Rate() > rep.Results[fastest].Rate() {
+					fastest = len(rep.Results)
+				}
+				rep.Results = append(rep.Results, r)
+			}
+		}
+		rep.Fastest = append(rep.Fastest, rep.Results[fastest])
+	}
+	return rep, nil
+}
+
+// measure runs one transfer and fills in the CPU time and allocations it
+// took. Garbage from earlier transfers is collected first so it does not
+// count against this one.
+func measure(run func() (BenchResult, error)) (BenchResult, error) {
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpu, cpuOK := processCPU()
+
+	r, err := run()
+	if err != nil {
+		return r, err
+	}
+
+	if end, ok := processCPU(); ok && cpuOK {
+		r.CPU = end - cpu
+	}
+	runtime.ReadMemStats(&after)
+	r.Allocs = after.Mallocs - before.Mallocs
+	r.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	return r, nil
+}
+
+// tcpBench streams size bytes of src over TCP with the given chunk size and
+// compression, hashing them on the receiving side as a receiver does.
+func tcpBench(size, chunkSize int64, compression string, src *repeatReader) (BenchResult, error) {
+	streamed := compression
+	if streamed == "auto" {
+		streamed = "" // SendStream samples each chunk
+	}
+	res, wire, err := tcpLoopback(Config{Size: size, ChunkSize: chunkSize}, src, streamed, true)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	return BenchResult{Protocol: "tcp", ChunkSize: chunkSize, Compression: compression,
+		Bytes: res.Bytes, Duration: res.Duration, WireBytes: wire, Note: res.Note}, nil
+}
+
+// udpChunkLoopback sends size bytes of src to an in-process receiver as
+// erasure-coded UDP chunks of chunkSize and reports the rate at which the
+// receiver rebuilt their blocks. Loopback UDP drops packets when the
+// receiver falls behind; only the blocks rebuilt count and the loss is
+// noted.
+func udpChunkLoopback(size, chunkSize int64, src *repeatReader) (BenchResult, error) {
+	recv, err := transport.NewUDPReceiver(0)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	defer recv.Close()
+	var got, last atomic.Int64
+	fec := transport.NewFECAssembler()
+	recv.Handler = func(p *protocol.Packet, _ *net.UDPAddr) {
+		block, err := fec.AddPacket(p)
+		if err != nil || block == nil {
+			return
+		}
+		got.Add(int64(len(block.Data)))
+		last.Store(time.Now().UnixNano())
+	}
+	recv.Start()
+
+	port := recv.Addr().(*net.UDPAddr).Port
+	sender, err := transport.NewUDPSender(transport.UDPSenderConfig{RemoteAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))})
+	if err != nil {
+		return BenchResult{}, err
+	}
+	defer sender.Close()
+
+	var session [16]byte
+	buf := make([]byte, min(chunkSize, size))
+	start := time.Now()
+	for i, off := uint64(0), int64(0); off < size; i, off = i+1, off+chunkSize {
+		n, _ := src.ReadAt(buf[:min(chunkSize, size-off)], off)
+		if err := sender.SendChunkFEC(context.Background(), session, i, buf[:n], 0); err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				continue // ECONNREFUSED and friends: count as loss
+			}
+			return BenchResult{}, fmt.Errorf("udp send: %w", err)
+		}
+	}
+	time.Sleep(udpDrain)
+
+	received := got.Load()
+	elapsed := time.Duration(last.Load() - start.UnixNano())
+	if received == 0 || elapsed <= 0 {
+		return BenchResult{}, fmt.Errorf("udp loopback: no packets received")
+	}
+	loss := 100 * (1 - float64(received)/float64(size))
+	return BenchResult{Protocol: "udp", ChunkSize: chunkSize, Compression: models.CompressionNone,
+		Bytes: received, Duration: elapsed, Note: fmt.Sprintf("udp unpaced, %.1f%% lost", loss)}, nil
+}
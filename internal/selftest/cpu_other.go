@@ -0,0 +1,11 @@
+//go:build !unix
+
+package selftest
+
+import "time"
+
+// processCPU is not implemented on this platform; benchmarks then report
+// no CPU time.
+func processCPU() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,394 @@
+// Package selftest measures the throughput of each stage a transfer goes
+// through on the local machine — disk, hashing, compression and a loopback
+// connection — so users can tell which one limits their real transfers.
+package selftest
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// bufferSize bounds the in-memory sample the CPU and network stages cycle
+// over, so large test sizes do not need that much memory.
+const bufferSize = 64 * 1024 * 1024
+
+// udpPayload is the data carried per UDP packet in the network stage.
+const udpPayload = 32 * 1024
+
+// udpDrain is how long the UDP stage waits for stragglers after sending.
+const udpDrain = 200 * time.Millisecond
+
+// Config selects what the self-test measures.
+type Config struct {
+	// Size is the number of bytes each stage processes.
+	Size int64
+	// Profile is the kind of test data; it matters for compression.
+	Profile genfile.Profile
+	// Protocol is the transport measured over loopback: "tcp" or "udp".
+	Protocol string
+	// Compression is the chunk compression of the end-to-end stage: auto,
+	// zstd or none, as for the sender.
+	Compression string
+	// ChunkSize is the size of the chunks sent in the network stages.
+	ChunkSize int64
+	// Dir is where the disk stage writes its temporary file; empty means
+	// the system temporary directory.
+	Dir string
+	// ReadPath, if set, is an existing file the disk read stage reads
+	// instead of the one it wrote, e.g. the real source of a transfer.
+	ReadPath string
+}
+
+// Result is the measured throughput of one stage.
+type Result struct {
+	Stage    string        `json:"stage"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	// Note carries stage-specific detail such as a compression ratio.
+	Note string `json:"note,omitempty"`
+}
+
+// Rate returns the throughput of the stage in bytes per second.
+func (r Result) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Report holds the results of a self-test in the order they were measured.
+type Report struct {
+	Results []Result `json:"results"`
+	// Bottleneck is the slowest stage a transfer from disk depends on.
+	Bottleneck string `json:"bottleneck"`
+}
+
+// Run measures each stage in turn.
+func Run(cfg Config) (*Report, error) {
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", cfg.Size)
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 16 * 1024 * 1024
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = "tcp"
+	}
+	if cfg.Protocol != "tcp" && cfg.Protocol != "udp" {
+		return nil, fmt.Errorf("unknown protocol %q", cfg.Protocol)
+	}
+	gen, err := genfile.New(genfile.Options{Size: cfg.Size, Seed: 1, Profile: cfg.Profile})
+	if err != nil {
+		return nil, err
+	}
+	sample := make([]byte, min(cfg.Size, bufferSize))
+	if _, err := gen.ReadAt(sample, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	src := &repeatReader{buf: sample}
+
+	rep := &Report{}
+	stages := []func(Config, *genfile.Generator, *repeatReader) ([]Result, error){
+		diskStage, hashStage, compressStage, networkStage,
+	}
+	for _, stage := range stages {
+		res, err := stage(cfg, gen, src)
+		if err != nil {
+			return nil, err
+		}
+		rep.Results = append(rep.Results, res...)
+	}
+
+	// A transfer reads, hashes and sends every byte; compression only
+	// matters when it is forced or auto would choose it for this data.
+	compresses := cfg.Compression == models.CompressionZstd ||
+		(cfg.Compression != models.CompressionNone && crypto.ShouldCompress(sample[:min(len(sample), transport.DefaultSegmentSize)]))
+	var slowest *Result
+	for i := range rep.Results {
+		r := &rep.Results[i]
+		if r.Stage == stageDiskWrite || r.Stage == stageEndToEnd {
+			continue
+		}
+		if r.Stage == stageCompress && !compresses {
+			continue
+		}
+		if slowest == nil || r.Rate() < slowest.Rate() {
+			slowest = r
+		}
+	}
+	if slowest != nil {
+		rep.Bottleneck = slowest.Stage
+	}
+	return rep, nil
+}
+
+const (
+	stageDiskWrite = "disk write"
+	stageDiskRead  = "disk read"
+	stageHash      = "hash (sha256)"
+	stageCompress  = "compress (zstd)"
+	stageNetwork   = "loopback network"
+	stageEndToEnd  = "end-to-end"
+)
+
+// diskStage writes the test data to a temporary file, syncs it and reads it
+// back (or reads cfg.ReadPath). A read straight after the write is likely
+// served from the page cache.
+func diskStage(cfg Config, gen *genfile.Generator, _ *repeatReader) ([]Result, error) {
+	f, err := os.CreateTemp(cfg.Dir, "trackshift-selftest-*.bin")
+	if err != nil {
+		return nil, fmt.Errorf("disk stage: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	start := time.Now()
+	if _, err := io.Copy(f, gen.NewReader()); err != nil {
+		return nil, fmt.Errorf("disk stage: write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return nil, fmt.Errorf("disk stage: sync: %w", err)
+	}
+	write := Result{Stage: stageDiskWrite, Bytes: cfg.Size, Duration: time.Since(start), Note: "includes fsync"}
+
+	path, note := f.Name(), "likely from page cache"
+	if cfg.ReadPath != "" {
+		path, note = cfg.ReadPath, cfg.ReadPath
+	}
+	rf, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("disk stage: %w", err)
+	}
+	defer rf.Close()
+	start = time.Now()
+	n, err := io.Copy(io.Discard, rf)
+	if err != nil {
+		return nil, fmt.Errorf("disk stage: read: %w", err)
+	}
+	read := Result{Stage: stageDiskRead, Bytes: n, Duration: time.Since(start), Note: note}
+	return []Result{write, read}, nil
+}
+
+// hashStage measures chunk hashing on one core.
+func hashStage(cfg Config, _ *genfile.Generator, src *repeatReader) ([]Result, error) {
+	h := sha256.New()
+	start := time.Now()
+	if _, err := io.Copy(h, io.NewSectionReader(src, 0, cfg.Size)); err != nil {
+		return nil, err
+	}
+	h.Sum(nil)
+	return []Result{{Stage: stageHash, Bytes: cfg.Size, Duration: time.Since(start), Note: "one core"}}, nil
+}
+
+// compressStage measures zstd compression of stream segments on one core.
+func compressStage(cfg Config, _ *genfile.Generator, src *repeatReader) ([]Result, error) {
+	seg := make([]byte, transport.DefaultSegmentSize)
+	var out int64
+	start := time.Now()
+	for off := int64(0); off < cfg.Size; off += int64(len(seg)) {
+		n, _ := src.ReadAt(seg[:min(int64(len(seg)), cfg.Size-off)], off)
+		c, err := crypto.CompressChunk(seg[:n])
+		if err != nil {
+			return nil, err
+		}
+		out += int64(len(c))
+	}
+	ratio := float64(cfg.Size) / float64(max(out, 1))
+	return []Result{{Stage: stageCompress, Bytes: cfg.Size, Duration: time.Since(start),
+		Note: fmt.Sprintf("one core, ratio %.2fx", ratio)}}, nil
+}
+
+// networkStage measures the loopback transport raw and, for TCP, end to end
+// with the configured compression and receiver-side hashing.
+func networkStage(cfg Config, _ *genfile.Generator, src *repeatReader) ([]Result, error) {
+	if cfg.Protocol == "udp" {
+		r, err := udpLoopback(cfg, src)
+		if err != nil {
+			return nil, err
+		}
+		return []Result{r}, nil
+	}
+	raw, _, err := tcpLoopback(cfg, src, models.CompressionNone, false)
+	if err != nil {
+		return nil, err
+	}
+	raw.Stage = stageNetwork
+	raw.Note = "tcp, uncompressed"
+
+	compression := cfg.Compression
+	if compression == "" || compression == "auto" {
+		compression = "" // SendStream samples each chunk
+	}
+	e2e, _, err := tcpLoopback(cfg, src, compression, true)
+	if err != nil {
+		return nil, err
+	}
+	e2e.Stage = stageEndToEnd
+	return []Result{raw, e2e}, nil
+}
+
+// tcpLoopback streams cfg.Size bytes of src to an in-process receiver in
+// chunks of cfg.ChunkSize and returns the time until the receiver has
+// decoded, and with verify hashed, all of it, along with the bytes that
+// crossed the connection.
+func tcpLoopback(cfg Config, src *repeatReader, compression string, verify bool) (Result, int64, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return Result{}, 0, err
+	}
+	defer ln.Close()
+
+	type recvResult struct {
+		n     int64
+		wire  int64
+		comps map[string]int
+		err   error
+	}
+	done := make(chan recvResult, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- recvResult{err: err}
+			return
+		}
+		defer conn.Close()
+		counted := &countingConn{Conn: conn}
+		recv := &transport.TCPReceiver{}
+		res := recvResult{comps: make(map[string]int)}
+		for res.n < cfg.Size {
+			meta, data, err := recv.ReceiveStream(context.Background(), counted)
+			if err != nil {
+				res.err = err
+				break
+			}
+			var w io.Writer = io.Discard
+			if verify {
+				w = sha256.New()
+			}
+			n, err := io.Copy(w, data)
+			res.n += n
+			res.comps[meta.Compression]++
+			if err != nil {
+				res.err = err
+				break
+			}
+		}
+		res.wire = counted.n
+		done <- res
+	}()
+
+	sender := transport.NewTCPSender()
+	conn, err := sender.Connect(context.Background(), ln.Addr().String())
+	if err != nil {
+		return Result{}, 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	for i, off := 0, int64(0); off < cfg.Size; i, off = i+1, off+cfg.ChunkSize {
+		size := min(cfg.ChunkSize, cfg.Size-off)
+		meta := &models.ChunkMetadata{ID: strconv.Itoa(i), Offset: off, Size: size, Compression: compression}
+		if err := sender.SendStream(context.Background(), conn, io.NewSectionReader(src, off, size), meta); err != nil {
+			return Result{}, 0, fmt.Errorf("loopback send: %w", err)
+		}
+	}
+	res := <-done
+	elapsed := time.Since(start)
+	if res.err != nil {
+		return Result{}, 0, fmt.Errorf("loopback receive: %w", res.err)
+	}
+	note := "tcp"
+	for c, n := range res.comps {
+		note += fmt.Sprintf(", %d chunks %s", n, c)
+	}
+	return Result{Bytes: res.n, Duration: elapsed, Note: note}, res.wire, nil
+}
+
+// countingConn counts the bytes read from a connection.
+type countingConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// udpLoopback sends cfg.Size bytes of src as UDP data packets to an
+// in-process receiver and reports the rate at which they arrived. Loopback
+// UDP drops packets when the receiver falls behind; the loss is noted.
+func udpLoopback(cfg Config, src *repeatReader) (Result, error) {
+	recv, err := transport.NewUDPReceiver(0)
+	if err != nil {
+		return Result{}, err
+	}
+	defer recv.Close()
+	var got, last atomic.Int64
+	recv.Handler = func(p *protocol.Packet, _ *net.UDPAddr) {
+		got.Add(int64(len(p.Payload)))
+		last.Store(time.Now().UnixNano())
+	}
+	recv.Start()
+
+	port := recv.Addr().(*net.UDPAddr).Port
+	sender, err := transport.NewUDPSender(transport.UDPSenderConfig{RemoteAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))})
+	if err != nil {
+		return Result{}, err
+	}
+	defer sender.Close()
+
+	var session [16]byte
+	buf := make([]byte, udpPayload)
+	start := time.Now()
+	for off := int64(0); off < cfg.Size; off += udpPayload {
+		n, _ := src.ReadAt(buf[:min(udpPayload, cfg.Size-off)], off)
+		if err := sender.SendChunk(context.Background(), session, uint64(off/cfg.ChunkSize), buf[:n], 0); err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				continue // ECONNREFUSED and friends: count as loss
+			}
+			return Result{}, fmt.Errorf("udp send: %w", err)
+		}
+	}
+	time.Sleep(udpDrain)
+
+	received := got.Load()
+	elapsed := time.Duration(last.Load() - start.UnixNano())
+	if received == 0 || elapsed <= 0 {
+		return Result{}, fmt.Errorf("udp loopback: no packets received")
+	}
+	loss := 100 * (1 - float64(received)/float64(cfg.Size))
+	return Result{Stage: stageNetwork, Bytes: received, Duration: elapsed,
+		Note: fmt.Sprintf("udp unpaced, %.1f%% lost", loss)}, nil
+}
+
+// repeatReader is an endless io.ReaderAt cycling over buf, so stages can
+// process more data than is held in memory without generating it on the
+// fly.
+type repeatReader struct {
+	buf []byte
+}
+
+func (r *repeatReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r.buf[(off+int64(n))%int64(len(r.buf)):])
+	}
+	return n, nil
+}
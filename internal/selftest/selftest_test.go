@@ -0,0 +1,95 @@
+package selftest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/genfile"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestRunTCP(t *testing.T) {
+	rep, err := Run(Config{
+		Size:        4 << 20,
+		Profile:     genfile.ProfileText,
+		Protocol:    "tcp",
+		Compression: models.CompressionZstd,
+		ChunkSize:   1 << 20,
+		Dir:         t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []string{stageDiskWrite, stageDiskRead, stageHash, stageCompress, stageNetwork, stageEndToEnd}
+	if len(rep.Results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(rep.Results), len(want), rep.Results)
+	}
+	for i, r := range rep.Results {
+		if r.Stage != want[i] {
+			t.Fatalf("result %d is %q, want %q", i, r.Stage, want[i])
+		}
+		if r.Bytes != 4<<20 || r.Rate() <= 0 {
+			t.Fatalf("%s: %d bytes at %.0f B/s", r.Stage, r.Bytes, r.Rate())
+		}
+	}
+	if rep.Bottleneck == "" || rep.Bottleneck == stageEndToEnd || rep.Bottleneck == stageDiskWrite {
+		t.Fatalf("unexpected bottleneck %q", rep.Bottleneck)
+	}
+}
+
+func TestRunRejectsUnknownProtocol(t *testing.T) {
+	if _, err := Run(Config{Size: 1024, Protocol: "quic"}); err == nil {
+		t.Fatalf("expected error for unknown protocol")
+	}
+}
+
+func TestRepeatReader(t *testing.T) {
+	r := &repeatReader{buf: []byte("abc")}
+	got := make([]byte, 7)
+	r.ReadAt(got, 2)
+	if !bytes.Equal(got, []byte("cabcabc")) {
+		t.Fatalf("ReadAt = %q", got)
+	}
+}
+
+func TestBench(t *testing.T) {
+	rep, err := Bench(BenchConfig{
+		Size:       4 << 20,
+		Profile:    genfile.ProfileText,
+		ChunkSizes: []int64{1 << 20, 4 << 20},
+	})
+	if err != nil {
+		t.Fatalf("Bench: %v", err)
+	}
+	// Per chunk size: TCP uncompressed and zstd, then UDP.
+	if len(rep.Results) != 6 || len(rep.Fastest) != 2 {
+		t.Fatalf("got %d results and %d fastest", len(rep.Results), len(rep.Fastest))
+	}
+	for _, r := range rep.Results {
+		if r.Rate() <= 0 || r.Allocs == 0 {
+			t.Fatalf("%s %d %s: %.0f B/s, %d allocs", r.Protocol, r.ChunkSize, r.Compression, r.Rate(), r.Allocs)
+		}
+		if r.Protocol == "tcp" && r.Bytes != 4<<20 {
+			t.Fatalf("tcp transfer carried %d bytes", r.Bytes)
+		}
+	}
+	// Text compresses, so zstd puts fewer bytes on the wire.
+	if raw, zstd := rep.Results[0], rep.Results[1]; zstd.Compression != models.CompressionZstd || zstd.Ratio() <= raw.Ratio() {
+		t.Fatalf("ratio %.2f with %s, %.2f without", zstd.Ratio(), zstd.Compression, raw.Ratio())
+	}
+	if rep.Fastest[0].Protocol != "tcp" || rep.Fastest[1].Protocol != "udp" {
+		t.Fatalf("fastest: %+v", rep.Fastest)
+	}
+}
+
+func TestBenchRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []BenchConfig{
+		{Size: 1024, Protocols: []string{"quic"}},
+		{Size: 1024, ChunkSizes: []int64{0}},
+		{Size: 1024, Compression: "lz4"},
+	} {
+		if _, err := Bench(cfg); err == nil {
+			t.Errorf("Bench(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltFile is the name of the bolt database in a sessions directory.
+const boltFile = "sessions.db"
+
+// Bolt layout: the sessions bucket maps session IDs to the session encoded
+// as JSON without its chunks, and the chunks bucket holds a bucket per
+// session mapping chunk IDs to their JSON metadata.
+var (
+	sessionsBucket = []byte("sessions")
+	chunksBucket   = []byte("chunks")
+)
+
+// BoltStore keeps sessions in a bolt database, one row per chunk, so a chunk
+// status change writes that chunk and the session's counters rather than
+// the whole session. Every write is a transaction committed to disk, so no
+// backups or checkpoints are needed to recover from a crash.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the bolt database at path. The database is
+// locked while open; a second process opening it fails after a second.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open session store %s: in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open session store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sessionsBucket, chunksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init session store: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// IDs implements Store.
+func (b *BoltStore) IDs() ([]string, error) {
+	var ids []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Load implements Store.
+func (b *BoltStore) Load(id string) (*models.TransferSession, error) {
+	var s models.TransferSession
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("session %s not found", id)
+		}
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		s.Chunks = make(map[string]*models.ChunkMetadata)
+		chunks := tx.Bucket(chunksBucket).Bucket([]byte(id))
+		if chunks == nil {
+			return nil
+		}
+		return chunks.ForEach(func(k, v []byte) error {
+			var c models.ChunkMetadata
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("%w: chunk %s: %v", ErrCorrupt, k, err)
+			}
+			s.Chunks[string(k)] = &c
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(&s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Save implements Store, replacing every chunk row of the session.
+func (b *BoltStore) Save(s *models.TransferSession) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := putSession(tx, s); err != nil {
+			return err
+		}
+		all := tx.Bucket(chunksBucket)
+		if err := all.DeleteBucket([]byte(s.ID)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		chunks, err := all.CreateBucket([]byte(s.ID))
+		if err != nil {
+			return err
+		}
+		for id, c := range s.Chunks {
+			if err := putChunk(chunks, id, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveChunk implements Store.
+func (b *BoltStore) SaveChunk(s *models.TransferSession, chunk *models.ChunkMetadata) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := putSession(tx, s); err != nil {
+			return err
+		}
+		chunks, err := tx.Bucket(chunksBucket).CreateBucketIfNotExists([]byte(s.ID))
+		if err != nil {
+			return err
+		}
+		return putChunk(chunks, chunk.ID, chunk)
+	})
+}
+
+// Checkpoint implements Store. Bolt writes are durable as they happen, so
+// there is nothing to do.
+func (b *BoltStore) Checkpoint(*models.TransferSession) error { return nil }
+
+// Delete implements Store.
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		err := tx.Bucket(chunksBucket).DeleteBucket([]byte(id))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Close implements Store.
+func (b *BoltStore) Close() error { return b.db.Close() }
+
+// putSession writes s without its chunks.
+func putSession(tx *bolt.Tx, s *models.TransferSession) error {
+	row := *s
+	row.Chunks = nil
+	data, err := json.Marshal(&row)
+	if err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+	return tx.Bucket(sessionsBucket).Put([]byte(s.ID), data)
+}
+
+// putChunk writes one chunk row.
+func putChunk(chunks *bolt.Bucket, id string, c *models.ChunkMetadata) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode chunk %s: %w", id, err)
+	}
+	return chunks.Put([]byte(id), data)
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func openBoltManager(t *testing.T, dir string) *SessionManager {
+	t.Helper()
+	store, err := OpenStore(StoreBolt, dir)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	mgr, err := NewSessionManagerWithStore(store)
+	if err != nil {
+		t.Fatalf("NewSessionManagerWithStore: %v", err)
+	}
+	t.Cleanup(func() { mgr.Close() })
+	return mgr
+}
+
+func TestBoltStorePersistsChunkUpdates(t *testing.T) {
+	dir := t.TempDir()
+	mgr := openBoltManager(t, dir)
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	s.Chunks["chunk-1"] = &models.ChunkMetadata{ID: "chunk-1", Size: 512, SHA256: "aa", SessionID: s.ID}
+	s.Chunks["chunk-2"] = &models.ChunkMetadata{ID: "chunk-2", Size: 512, Offset: 512, SHA256: "bb", SessionID: s.ID}
+	s.TotalChunks = 2
+	if err := mgr.SaveSession(s); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if err := mgr.UpdateChunkStatus(s.ID, "chunk-2", models.ChunkStatusCompleted); err != nil {
+		t.Fatalf("UpdateChunkStatus: %v", err)
+	}
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	mgr2 := openBoltManager(t, dir)
+	s2, err := mgr2.GetSession(s.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if s2.Completed != 1 || s2.TotalChunks != 2 || s2.SchemaVersion != SchemaVersion {
+		t.Fatalf("reloaded session: completed=%d total=%d schema=%d", s2.Completed, s2.TotalChunks, s2.SchemaVersion)
+	}
+	if c := s2.Chunks["chunk-2"]; c == nil || c.Status != models.ChunkStatusCompleted || c.SHA256 != "bb" {
+		t.Fatalf("chunk-2 = %+v", c)
+	}
+	if missing := mgr2.GetMissingChunks(s.ID); len(missing) != 1 || missing[0] != "chunk-1" {
+		t.Fatalf("missing chunks = %v", missing)
+	}
+
+	// A full save replaces the chunk rows.
+	delete(s2.Chunks, "chunk-1")
+	if err := mgr2.SaveSession(s2); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	s3, err := mgr2.LoadSession(s.ID)
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if _, ok := s3.Chunks["chunk-1"]; ok || len(s3.Chunks) != 1 {
+		t.Fatalf("chunks after full save = %v", s3.Chunks)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	for _, kind := range []string{"", StoreJSON, StoreBolt} {
+		store, err := OpenStore(kind, dir)
+		if err != nil {
+			t.Fatalf("OpenStore(%q): %v", kind, err)
+		}
+		store.Close()
+	}
+	if _, err := OpenStore("sqlite", dir); err == nil {
+		t.Fatal("OpenStore accepted an unknown kind")
+	}
+}
@@ -0,0 +1,249 @@
+package session
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ExportFormat identifies session archives written by Export.
+const ExportFormat = "trackshift-session/1"
+
+// ErrSessionExists is returned by Import for a session this manager
+// already holds.
+var ErrSessionExists = errors.New("session already exists")
+
+// Names of the entries of a session archive, in the order written.
+const (
+	exportHeaderName     = "header.json"
+	exportSessionName    = "session.json"
+	exportCheckpointName = "checkpoint.json"
+	exportInventoryName  = "inventory.json"
+)
+
+// exportHeader opens a session archive and lists the SHA-256 of every
+// other entry, so a damaged archive is refused rather than imported.
+type exportHeader struct {
+	Format        string            `json:"format"`
+	SchemaVersion int               `json:"schema_version"`
+	SessionID     string            `json:"session_id"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	Host          string            `json:"host,omitempty"`
+	Entries       map[string]string `json:"entries"` // name -> hex SHA-256
+}
+
+// checkpointOf returns the checkpoint of s's progress.
+func checkpointOf(s *models.TransferSession) SessionCheckpoint {
+	cp := SessionCheckpoint{
+		SchemaVersion:  SchemaVersion,
+		SessionID:      s.ID,
+		TotalChunks:    s.TotalChunks,
+		LastUpdateTime: s.UpdatedAt,
+	}
+	for id, ch := range s.Chunks {
+		if ch.Status == models.ChunkStatusCompleted {
+			cp.CompletedChunks = append(cp.CompletedChunks, id)
+		} else {
+			cp.PendingChunks = append(cp.PendingChunks, id)
+		}
+	}
+	return cp
+}
+
+// Export writes session id to w as a portable archive: a tar holding the
+// session, its checkpoint and, if inventory is non-nil, a chunk inventory
+// such as the sender's chunk list, so the session can be imported and
+// resumed on another host. It stops between entries once ctx is done.
+func (m *SessionManager) Export(ctx context.Context, id string, w io.Writer, inventory []*models.ChunkMetadata) error {
+	m.mu.RLock()
+	s, ok := m.sessions[id]
+	var sessData, cpData []byte
+	var err error
+	if ok {
+		if sessData, err = json.MarshalIndent(s, "", "  "); err == nil {
+			cpData, err = json.MarshalIndent(checkpointOf(s), "", "  ")
+		}
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+
+	entries := []struct {
+		name string
+		data []byte
+	}{{exportSessionName, sessData}, {exportCheckpointName, cpData}}
+	if inventory != nil {
+		data, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode chunk inventory: %w", err)
+		}
+		entries = append(entries, struct {
+			name string
+			data []byte
+		}{exportInventoryName, data})
+	}
+
+	hdr := exportHeader{
+		Format:        ExportFormat,
+		SchemaVersion: SchemaVersion,
+		SessionID:     id,
+		ExportedAt:    time.Now().UTC(),
+		Entries:       make(map[string]string, len(entries)),
+	}
+	hdr.Host, _ = os.Hostname()
+	for _, e := range entries {
+		sum := sha256.Sum256(e.data)
+		hdr.Entries[e.name] = hex.EncodeToString(sum[:])
+	}
+	hdrData, err := json.MarshalIndent(&hdr, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode archive header: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	write := func(name string, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o600,
+			Size:    int64(len(data)),
+			ModTime: hdr.ExportedAt,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(exportHeaderName, hdrData); err != nil {
+		return fmt.Errorf("write session archive: %w", err)
+	}
+	for _, e := range entries {
+		if err := write(e.name, e.data); err != nil {
+			return fmt.Errorf("write session archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write session archive: %w", err)
+	}
+	return nil
+}
+
+// Import reads a session archive written by Export, adds the session to the
+// manager and saves it, ready to be resumed. It returns the session and the
+// archive's chunk inventory, which is nil if none was exported. Sessions
+// the manager already holds are refused with ErrSessionExists. Reading the
+// archive stops once ctx is done.
+func (m *SessionManager) Import(ctx context.Context, r io.Reader) (*models.TransferSession, []*models.ChunkMetadata, error) {
+	tr := tar.NewReader(r)
+	var hdr *exportHeader
+	data := make(map[string][]byte)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read session archive: %w", err)
+		}
+		// Entries are small JSON documents; anything else is not ours.
+		if th.Size > 1<<30 {
+			return nil, nil, fmt.Errorf("read session archive: entry %s too large", th.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read session archive: %w", err)
+		}
+		if hdr == nil {
+			if th.Name != exportHeaderName {
+				return nil, nil, fmt.Errorf("not a session archive: starts with %s", th.Name)
+			}
+			hdr = &exportHeader{}
+			if err := json.Unmarshal(b, hdr); err != nil {
+				return nil, nil, fmt.Errorf("read archive header: %w", err)
+			}
+			if hdr.Format != ExportFormat {
+				return nil, nil, fmt.Errorf("unsupported session archive format %q", hdr.Format)
+			}
+			continue
+		}
+		want, ok := hdr.Entries[th.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("session archive: unexpected entry %s", th.Name)
+		}
+		if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != want {
+			return nil, nil, fmt.Errorf("%w: archive entry %s fails its checksum", ErrCorrupt, th.Name)
+		}
+		data[th.Name] = b
+	}
+	if hdr == nil {
+		return nil, nil, errors.New("not a session archive: empty")
+	}
+	for name := range hdr.Entries {
+		if data[name] == nil {
+			return nil, nil, fmt.Errorf("%w: archive entry %s missing", ErrCorrupt, name)
+		}
+	}
+	if data[exportSessionName] == nil {
+		return nil, nil, fmt.Errorf("%w: archive holds no session", ErrCorrupt)
+	}
+
+	var s models.TransferSession
+	if err := json.Unmarshal(data[exportSessionName], &s); err != nil {
+		return nil, nil, fmt.Errorf("decode session: %w", err)
+	}
+	if err := migrate(&s); err != nil {
+		return nil, nil, err
+	}
+	if s.Chunks == nil {
+		s.Chunks = make(map[string]*models.ChunkMetadata)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if b := data[exportCheckpointName]; b != nil {
+		var cp SessionCheckpoint
+		if err := json.Unmarshal(b, &cp); err != nil {
+			return nil, nil, fmt.Errorf("decode checkpoint: %w", err)
+		}
+		applyCheckpointTo(&s, &cp)
+	}
+	recount(&s)
+	var inventory []*models.ChunkMetadata
+	if b := data[exportInventoryName]; b != nil {
+		if err := json.Unmarshal(b, &inventory); err != nil {
+			return nil, nil, fmt.Errorf("decode chunk inventory: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[s.ID]; exists || m.loadErrs[s.ID] != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrSessionExists, s.ID)
+	}
+	if err := m.saveLocked(&s); err != nil {
+		return nil, nil, err
+	}
+	if err := m.store.Checkpoint(&s); err != nil {
+		return nil, nil, err
+	}
+	m.sessions[s.ID] = &s
+	return &s, inventory, nil
+}
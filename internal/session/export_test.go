@@ -0,0 +1,82 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestExportImport(t *testing.T) {
+	src := newTempManager(t)
+	s, err := src.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	s.TotalChunks = 2
+	s.ProtocolVersion = 10
+	if err := src.UpdateChunkStatus(s.ID, "chunk-1", models.ChunkStatusCompleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetStatus(s.ID, models.SessionStatusTransferring); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetStatus(s.ID, models.SessionStatusPaused); err != nil {
+		t.Fatal(err)
+	}
+	inventory := []*models.ChunkMetadata{
+		{ID: "chunk-1", Size: 512, SHA256: "aa"},
+		{ID: "chunk-2", Size: 512, Offset: 512, SHA256: "bb"},
+	}
+
+	var archive bytes.Buffer
+	if err := src.Export(context.Background(), s.ID, &archive, inventory); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	data := archive.Bytes()
+
+	// The archive moves to a manager with another backend, as on a
+	// different host.
+	dst := openBoltManager(t, t.TempDir())
+	got, inv, err := dst.Import(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if got.ID != s.ID || got.Status != models.SessionStatusPaused || got.ProtocolVersion != 10 {
+		t.Fatalf("imported session = %+v", got)
+	}
+	if got.Completed != 1 || got.Chunks["chunk-1"].Status != models.ChunkStatusCompleted {
+		t.Fatalf("imported progress: %d completed, chunks %+v", got.Completed, got.Chunks)
+	}
+	if len(inv) != 2 || inv[1].SHA256 != "bb" {
+		t.Fatalf("inventory = %+v", inv)
+	}
+	if loaded, err := dst.LoadSession(s.ID); err != nil || loaded.Completed != 1 {
+		t.Fatalf("imported session not saved: %v", err)
+	}
+	if _, _, err := dst.Import(context.Background(), bytes.NewReader(data)); !errors.Is(err, ErrSessionExists) {
+		t.Fatalf("second Import: %v", err)
+	}
+
+	// Without an inventory there is none to import.
+	archive.Reset()
+	if err := src.Export(context.Background(), s.ID, &archive, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, inv, err := newTempManager(t).Import(context.Background(), &archive); err != nil || inv != nil {
+		t.Fatalf("Import without inventory: %v, %v", inv, err)
+	}
+
+	// A damaged archive is refused.
+	bad := bytes.Clone(data)
+	i := bytes.Index(bad, []byte(`"chunk-1"`))
+	bad[i+1] = 'X'
+	if _, _, err := newTempManager(t).Import(context.Background(), bytes.NewReader(bad)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Import of a damaged archive: %v", err)
+	}
+	if err := src.Export(context.Background(), "missing", &archive, nil); err == nil {
+		t.Fatal("Export of an unknown session succeeded")
+	}
+}
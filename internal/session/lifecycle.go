@@ -0,0 +1,56 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrIllegalTransition is returned by SetStatus for a status change the
+// session lifecycle does not allow.
+var ErrIllegalTransition = errors.New("illegal session status transition")
+
+// transitions lists the statuses each status may change to. A failed session
+// can be resumed; a completed one is final.
+var transitions = map[models.SessionStatus][]models.SessionStatus{
+	models.SessionStatusCreated:      {models.SessionStatusTransferring, models.SessionStatusFailed},
+	models.SessionStatusTransferring: {models.SessionStatusPaused, models.SessionStatusCompleted, models.SessionStatusFailed},
+	models.SessionStatusPaused:       {models.SessionStatusTransferring, models.SessionStatusFailed},
+	models.SessionStatusFailed:       {models.SessionStatusTransferring},
+	models.SessionStatusCompleted:    nil,
+}
+
+// CanTransition reports whether a session may change from status from to
+// status to. Keeping the current status is always allowed.
+func CanTransition(from, to models.SessionStatus) bool {
+	return from == to || slices.Contains(transitions[from], to)
+}
+
+// SetStatus moves a session to status and persists it. Completing a session
+// stamps CompletedAt. Setting the current status again changes nothing; a
+// change the lifecycle does not allow returns ErrIllegalTransition.
+func (m *SessionManager) SetStatus(sessionID string, status models.SessionStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	if s.Status == status {
+		return nil
+	}
+	if !CanTransition(s.Status, status) {
+		return fmt.Errorf("%w: session %s from %s to %s", ErrIllegalTransition, sessionID, s.Status, status)
+	}
+	now := time.Now()
+	s.Status = status
+	s.UpdatedAt = now
+	if status == models.SessionStatusCompleted {
+		s.CompletedAt = &now
+	}
+	return m.saveLocked(s)
+}
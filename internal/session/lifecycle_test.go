@@ -0,0 +1,87 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSetStatusEnforcesLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	if err := mgr.SetStatus(s.ID, models.SessionStatusPaused); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("created -> paused: %v", err)
+	}
+	for _, status := range []models.SessionStatus{
+		models.SessionStatusTransferring,
+		models.SessionStatusPaused,
+		models.SessionStatusPaused, // unchanged
+		models.SessionStatusTransferring,
+		models.SessionStatusFailed,
+		models.SessionStatusTransferring, // resumed after failing
+	} {
+		if err := mgr.SetStatus(s.ID, status); err != nil {
+			t.Fatalf("SetStatus(%s): %v", status, err)
+		}
+	}
+	if s.CompletedAt != nil {
+		t.Fatal("CompletedAt stamped before completion")
+	}
+	if err := mgr.SetStatus(s.ID, models.SessionStatusCompleted); err != nil {
+		t.Fatalf("SetStatus(completed): %v", err)
+	}
+	if s.CompletedAt == nil {
+		t.Fatal("CompletedAt not stamped")
+	}
+	if err := mgr.SetStatus(s.ID, models.SessionStatusTransferring); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("completed -> transferring: %v", err)
+	}
+
+	// The status is persisted.
+	mgr2, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager 2: %v", err)
+	}
+	s2, err := mgr2.GetSession(s.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if s2.Status != models.SessionStatusCompleted || s2.CompletedAt == nil {
+		t.Fatalf("reloaded session: status %s, completed at %v", s2.Status, s2.CompletedAt)
+	}
+}
+
+func TestChunkCountersFollowTransitions(t *testing.T) {
+	mgr := newTempManager(t)
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	for _, step := range []struct {
+		chunk             string
+		status            models.ChunkStatus
+		completed, failed int
+	}{
+		{"c1", models.ChunkStatusCompleted, 1, 0},
+		{"c1", models.ChunkStatusCompleted, 1, 0}, // sent again
+		{"c2", models.ChunkStatusFailed, 1, 1},
+		{"c2", models.ChunkStatusCompleted, 2, 0}, // retried
+	} {
+		if err := mgr.UpdateChunkStatus(s.ID, step.chunk, step.status); err != nil {
+			t.Fatalf("UpdateChunkStatus: %v", err)
+		}
+		if s.Completed != step.completed || s.Failed != step.failed {
+			t.Fatalf("after %s %s: completed %d, failed %d; want %d, %d",
+				step.chunk, step.status, s.Completed, s.Failed, step.completed, step.failed)
+		}
+	}
+}
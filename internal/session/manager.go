@@ -1,27 +1,33 @@
 package session
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/google/uuid"
 )
 
-// SessionManager manages in-memory sessions and persists them to disk.
+// SessionManager manages in-memory sessions and persists them in a Store.
+// Only Export and Import, which stream archives, take a context: recording
+// progress is never cancelled, so that a transfer stopped by cancellation
+// still saves the state it resumes from.
 type SessionManager struct {
 	mu       sync.RWMutex
 	sessions map[string]*models.TransferSession
-	baseDir  string
+	store    Store
+	// loadErrs records why sessions found in the store at startup could not
+	// be loaded, so looking one up reports the cause rather than "not found".
+	loadErrs map[string]error
 }
 
 // SessionCheckpoint is a lightweight snapshot of session progress.
 type SessionCheckpoint struct {
+	SchemaVersion   int       `json:"schema_version,omitempty"`
 	SessionID       string    `json:"session_id"`
 	CompletedChunks []string  `json:"completed_chunks"`
 	PendingChunks   []string  `json:"pending_chunks"`
@@ -29,19 +35,23 @@ type SessionCheckpoint struct {
 	LastUpdateTime  time.Time `json:"last_update_time"`
 }
 
-// NewSessionManager creates a new SessionManager using baseDir for persistence.
-// Existing session files in baseDir are loaded on startup.
+// NewSessionManager creates a new SessionManager keeping sessions as JSON
+// files in baseDir. Existing session files in baseDir are loaded on startup.
 func NewSessionManager(baseDir string) (*SessionManager, error) {
-	if baseDir == "" {
-		return nil, errors.New("baseDir must not be empty")
-	}
-	if err := os.MkdirAll(baseDir, 0o755); err != nil {
-		return nil, fmt.Errorf("creating sessions dir: %w", err)
+	store, err := OpenStore(StoreJSON, baseDir)
+	if err != nil {
+		return nil, err
 	}
+	return NewSessionManagerWithStore(store)
+}
 
+// NewSessionManagerWithStore creates a new SessionManager persisting
+// sessions in store. Sessions already in the store are loaded on startup.
+func NewSessionManagerWithStore(store Store) (*SessionManager, error) {
 	mgr := &SessionManager{
 		sessions: make(map[string]*models.TransferSession),
-		baseDir:  baseDir,
+		store:    store,
+		loadErrs: make(map[string]error),
 	}
 	if err := mgr.loadExisting(); err != nil {
 		return nil, err
@@ -49,26 +59,23 @@ func NewSessionManager(baseDir string) (*SessionManager, error) {
 	return mgr, nil
 }
 
-// loadExisting loads any existing session JSON files from baseDir.
+// loadExisting loads the sessions persisted in the store.
 func (m *SessionManager) loadExisting() error {
-	entries, err := os.ReadDir(m.baseDir)
+	ids, err := m.store.IDs()
 	if err != nil {
-		return fmt.Errorf("read sessions dir: %w", err)
+		return err
 	}
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		if filepath.Ext(e.Name()) != ".json" {
-			continue
-		}
-		id := e.Name()[:len(e.Name())-len(".json")]
+	for _, id := range ids {
 		s, err := m.LoadSession(id)
 		if err != nil {
 			// best-effort: log-style error via fmt, but continue
 			fmt.Fprintf(os.Stderr, "failed to load session %s: %v\n", id, err)
+			m.loadErrs[id] = err
 			continue
 		}
+		// Releases that counted every completion call may have saved
+		// inflated counters.
+		recount(s)
 		m.sessions[id] = s
 	}
 	return nil
@@ -79,9 +86,87 @@ func (m *SessionManager) CreateSession(fileInfo models.FileMetadata) (*models.Tr
 	if err := fileInfo.Validate(); err != nil {
 		return nil, err
 	}
-	id := uuid.NewString()
+	s, err := newSession(uuid.NewString(), fileInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s
+	m.mu.Unlock()
+
+	if err := m.SaveSession(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CreateUpload creates and persists a session receiving fileInfo as a tus
+// upload. Its hash may be empty until the upload is complete.
+func (m *SessionManager) CreateUpload(fileInfo models.FileMetadata) (*models.TransferSession, error) {
 	now := time.Now()
+	s := &models.TransferSession{
+		ID:        uuid.NewString(),
+		File:      fileInfo,
+		Status:    models.SessionStatusCreated,
+		Chunks:    make(map[string]*models.ChunkMetadata),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Origin:    models.OriginTus,
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s
+	m.mu.Unlock()
+
+	if err := m.SaveSession(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AdoptSession creates and persists a new session under id, the ID a peer
+// gave the same transfer, so both sides name it alike. id must be a UUID in
+// canonical form. IDs the manager holds, failed to load or finds in its
+// store are refused with ErrSessionExists.
+func (m *SessionManager) AdoptSession(id string, fileInfo models.FileMetadata) (*models.TransferSession, error) {
+	if err := fileInfo.Validate(); err != nil {
+		return nil, err
+	}
+	if u, err := uuid.Parse(id); err != nil || u.String() != id {
+		return nil, fmt.Errorf("session ID %q is not a UUID", id)
+	}
+	s, err := newSession(id, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[id]; exists || m.loadErrs[id] != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSessionExists, id)
+	}
+	// Another process sharing the directory may have saved it since.
+	ids, err := m.store.IDs()
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(ids, id) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionExists, id)
+	}
+	if err := m.saveLocked(s); err != nil {
+		return nil, err
+	}
+	m.sessions[id] = s
+	return s, nil
+}
 
+// newSession returns a new session with ID id sending fileInfo.
+func newSession(id string, fileInfo models.FileMetadata) (*models.TransferSession, error) {
+	now := time.Now()
 	s := &models.TransferSession{
 		ID:          id,
 		File:        fileInfo,
@@ -94,12 +179,110 @@ func (m *SessionManager) CreateSession(fileInfo models.FileMetadata) (*models.Tr
 	if err := s.Validate(); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
 
+// BranchSession creates a session sending the same data as session id to
+// another destination, so the work of preparing it is not repeated. The
+// branch copies the file, file list, manifest, protocol version, chunk
+// size and chunk list, with every chunk pending; its route, counters and status start
+// afresh.
+func (m *SessionManager) BranchSession(id string) (*models.TransferSession, error) {
 	m.mu.Lock()
-	m.sessions[id] = s
-	m.mu.Unlock()
+	defer m.mu.Unlock()
 
-	if err := m.SaveSession(s); err != nil {
+	src, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+	now := time.Now()
+	s := &models.TransferSession{
+		ID:              uuid.NewString(),
+		File:            src.File,
+		Files:           src.Files,
+		Manifest:        src.Manifest,
+		Status:          models.SessionStatusCreated,
+		Chunks:          make(map[string]*models.ChunkMetadata, len(src.Chunks)),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		TotalChunks:     src.TotalChunks,
+		ProtocolVersion: src.ProtocolVersion,
+		ChunkSize:       src.ChunkSize,
+		BranchOf:        src.ID,
+	}
+	for cid, c := range src.Chunks {
+		branched := *c
+		branched.SessionID = s.ID
+		branched.Status = models.ChunkStatusPending
+		branched.CreatedAt, branched.UpdatedAt = now, now
+		branched.SentAt = time.Time{}
+		s.Chunks[cid] = &branched
+	}
+	if err := m.saveLocked(s); err != nil {
+		return nil, err
+	}
+	m.sessions[s.ID] = s
+	return s, nil
+}
+
+// ImportSession builds a session from chunks produced elsewhere (e.g. a
+// chunk store written by another node), with every chunk completed. The
+// chunks must cover the file contiguously. The session stays created until
+// the caller has checked the assembled file and completes it with SetStatus.
+// If id is empty or already known, a new ID is generated.
+func (m *SessionManager) ImportSession(id string, fileInfo models.FileMetadata, chunks []*models.ChunkMetadata) (*models.TransferSession, error) {
+	if err := fileInfo.Validate(); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*models.ChunkMetadata, len(chunks))
+	copy(sorted, chunks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	var next int64
+	for _, c := range sorted {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("chunk %s: %w", c.ID, err)
+		}
+		if c.Offset != next {
+			return nil, fmt.Errorf("chunk %s: expected offset %d, got %d", c.ID, next, c.Offset)
+		}
+		next += c.Size
+	}
+	if next != fileInfo.Size {
+		return nil, fmt.Errorf("chunks cover %d bytes, file size is %d", next, fileInfo.Size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// IDs of sessions that failed to load are not reused either: their
+	// files may belong to a newer release.
+	if _, exists := m.sessions[id]; id == "" || exists || m.loadErrs[id] != nil {
+		id = uuid.NewString()
+	}
+	now := time.Now()
+	s := &models.TransferSession{
+		ID:          id,
+		File:        fileInfo,
+		Status:      models.SessionStatusCreated,
+		Chunks:      make(map[string]*models.ChunkMetadata, len(sorted)),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		TotalChunks: len(sorted),
+		Completed:   len(sorted),
+	}
+	for _, c := range sorted {
+		c.SessionID = id
+		c.Status = models.ChunkStatusCompleted
+		s.Chunks[c.ID] = c
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.sessions[id] = s
+	if err := m.saveLocked(s); err != nil {
 		return nil, err
 	}
 	return s, nil
@@ -112,12 +295,36 @@ func (m *SessionManager) GetSession(id string) (*models.TransferSession, error)
 
 	s, ok := m.sessions[id]
 	if !ok {
+		if err := m.loadErrs[id]; err != nil {
+			return nil, fmt.Errorf("session %s: %w", id, err)
+		}
 		return nil, fmt.Errorf("session %s not found", id)
 	}
 	return s, nil
 }
 
-// UpdateChunkStatus updates the status of a chunk in a session and persists the session.
+// FindBySender returns the unfinished session a receiver keeps for the
+// sender's session senderID sending the file with hash, if there is one.
+// The most recently updated wins should there be several.
+func (m *SessionManager) FindBySender(senderID, hash string) (*models.TransferSession, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *models.TransferSession
+	for _, s := range m.sessions {
+		if senderID == "" || s.File.SenderSession != senderID || s.File.Hash != hash ||
+			s.Status == models.SessionStatusCompleted {
+			continue
+		}
+		if found == nil || s.UpdatedAt.After(found.UpdatedAt) {
+			found = s
+		}
+	}
+	return found, found != nil
+}
+
+// UpdateChunkStatus updates the status of a chunk in a session and persists
+// the change.
 func (m *SessionManager) UpdateChunkStatus(sessionID, chunkID string, status models.ChunkStatus) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -137,21 +344,102 @@ func (m *SessionManager) UpdateChunkStatus(sessionID, chunkID string, status mod
 		s.Chunks[chunkID] = chunk
 	}
 
+	// The counters follow chunks into and out of each status, so a chunk
+	// sent again or retried after failing is not counted twice.
+	if prev := chunk.Status; prev != status {
+		switch prev {
+		case models.ChunkStatusCompleted:
+			s.Completed = max(s.Completed-1, 0)
+		case models.ChunkStatusFailed:
+			s.Failed = max(s.Failed-1, 0)
+		}
+		switch status {
+		case models.ChunkStatusCompleted:
+			s.Completed++
+		case models.ChunkStatusFailed:
+			s.Failed++
+		}
+	}
 	chunk.Status = status
 	chunk.UpdatedAt = time.Now()
+	s.UpdatedAt = time.Now()
 
-	switch status {
-	case models.ChunkStatusCompleted:
-		s.Completed++
-	case models.ChunkStatusFailed:
-		s.Failed++
+	if err := s.Validate(); err != nil {
+		return err
 	}
-	s.UpdatedAt = time.Now()
+	if err := m.store.SaveChunk(s, chunk); err != nil {
+		return fmt.Errorf("save chunk: %w", err)
+	}
+	return nil
+}
 
+// RecomputeCounters rederives a session's Completed and Failed counters from
+// its chunk states and persists the session.
+func (m *SessionManager) RecomputeCounters(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	recount(s)
 	return m.saveLocked(s)
 }
 
-// SaveSession persists the given session to disk.
+// recount sets s's counters from its chunk states.
+func recount(s *models.TransferSession) {
+	s.Completed, s.Failed = 0, 0
+	for _, c := range s.Chunks {
+		switch c.Status {
+		case models.ChunkStatusCompleted:
+			s.Completed++
+		case models.ChunkStatusFailed:
+			s.Failed++
+		}
+	}
+}
+
+// Progress returns how much of a session is done, in percent, from the
+// share of its chunks that are completed. A completed session is at 100
+// even if it recorded no chunks.
+func (m *SessionManager) Progress(sessionID string) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return 0, fmt.Errorf("session %s not found", sessionID)
+	}
+	if s.Status == models.SessionStatusCompleted {
+		return 100, nil
+	}
+	done := 0
+	for _, c := range s.Chunks {
+		if c.Status == models.ChunkStatusCompleted {
+			done++
+		}
+	}
+	// Adaptively chunked sessions do not know their chunk count up front.
+	total := max(s.TotalChunks, len(s.Chunks))
+	if total == 0 {
+		return 0, nil
+	}
+	return 100 * float64(min(done, total)) / float64(total), nil
+}
+
+// Counts returns a session's chunk count and how many of its chunks are
+// completed and failed. Adaptively chunked sessions count the chunks seen
+// so far until their total is known.
+func (m *SessionManager) Counts(sessionID string) (total, completed, failed int, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("session %s not found", sessionID)
+	}
+	return max(s.TotalChunks, len(s.Chunks)), s.Completed, s.Failed, nil
+}
+
+// SaveSession persists the given session.
 func (m *SessionManager) SaveSession(session *models.TransferSession) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -163,45 +451,16 @@ func (m *SessionManager) saveLocked(session *models.TransferSession) error {
 	if err := session.Validate(); err != nil {
 		return err
 	}
-	path := filepath.Join(m.baseDir, session.ID+".json")
-
-	tmpPath := path + ".tmp"
-	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("open temp session file: %w", err)
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(session); err != nil {
-		f.Close()
-		return fmt.Errorf("encode session: %w", err)
-	}
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("close temp session file: %w", err)
-	}
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("atomic rename session file: %w", err)
+	session.SchemaVersion = SchemaVersion
+	if err := m.store.Save(session); err != nil {
+		return fmt.Errorf("save session: %w", err)
 	}
 	return nil
 }
 
-// LoadSession loads a session from disk by ID.
+// LoadSession loads a session from the store by ID.
 func (m *SessionManager) LoadSession(id string) (*models.TransferSession, error) {
-	path := filepath.Join(m.baseDir, id+".json")
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("open session file: %w", err)
-	}
-	defer f.Close()
-
-	var s models.TransferSession
-	if err := json.NewDecoder(f).Decode(&s); err != nil {
-		return nil, fmt.Errorf("decode session: %w", err)
-	}
-	if err := s.Validate(); err != nil {
-		return nil, err
-	}
-	return &s, nil
+	return m.store.Load(id)
 }
 
 // ListSessions returns all known sessions in memory.
@@ -216,47 +475,23 @@ func (m *SessionManager) ListSessions() []*models.TransferSession {
 	return out
 }
 
-// PersistCheckpoint writes a checkpoint file for the given session.
+// PersistCheckpoint records the given session's progress, for stores that
+// need it; see Store.Checkpoint.
 func (m *SessionManager) PersistCheckpoint(sessionID string) error {
-	m.mu.RLock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	s, ok := m.sessions[sessionID]
-	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
+	return m.store.Checkpoint(s)
+}
 
-	var completed, pending []string
-	for id, ch := range s.Chunks {
-		switch ch.Status {
-		case models.ChunkStatusCompleted:
-			completed = append(completed, id)
-		default:
-			pending = append(pending, id)
-		}
-	}
-
-	cp := SessionCheckpoint{
-		SessionID:       s.ID,
-		CompletedChunks: completed,
-		PendingChunks:   pending,
-		TotalChunks:     s.TotalChunks,
-		LastUpdateTime:  time.Now(),
-	}
-
-	path := filepath.Join(m.baseDir, s.ID+".checkpoint.json")
-	tmp := path + ".tmp"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
-	if err != nil {
-		return err
-	}
-	if err := json.NewEncoder(f).Encode(&cp); err != nil {
-		f.Close()
-		return err
-	}
-	if err := f.Close(); err != nil {
-		return err
-	}
-	return os.Rename(tmp, path)
+// Close closes the manager's store.
+func (m *SessionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store.Close()
 }
 
 // GetMissingChunks returns IDs of chunks that are not completed.
@@ -275,5 +510,3 @@ func (m *SessionManager) GetMissingChunks(sessionID string) []string {
 	}
 	return missing
 }
-
-
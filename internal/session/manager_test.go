@@ -1,6 +1,8 @@
 package session
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"sync"
@@ -112,4 +114,368 @@ func TestConcurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+func TestImportSession(t *testing.T) {
+	mgr := newTempManager(t)
+	file := models.FileMetadata{Name: "test.bin", Size: 20, Hash: "abc"}
+
+	chunks := []*models.ChunkMetadata{
+		{ID: "1", Offset: 10, Size: 10, SHA256: "b", Status: models.ChunkStatusPending},
+		{ID: "0", Offset: 0, Size: 10, SHA256: "a", Status: models.ChunkStatusPending},
+	}
+	s, err := mgr.ImportSession("imported", file, chunks)
+	if err != nil {
+		t.Fatalf("ImportSession: %v", err)
+	}
+	// The session completes only once the assembled file has been checked.
+	if s.ID != "imported" || s.Status != models.SessionStatusCreated || s.CompletedAt != nil {
+		t.Fatalf("unexpected session: id=%s status=%s", s.ID, s.Status)
+	}
+	if s.TotalChunks != 2 || s.Completed != 2 {
+		t.Fatalf("expected 2/2 chunks, got %d/%d", s.Completed, s.TotalChunks)
+	}
+	for _, status := range []models.SessionStatus{models.SessionStatusTransferring, models.SessionStatusCompleted} {
+		if err := mgr.SetStatus(s.ID, status); err != nil {
+			t.Fatalf("SetStatus(%s): %v", status, err)
+		}
+	}
+
+	gap := []*models.ChunkMetadata{
+		{ID: "0", Offset: 0, Size: 10, SHA256: "a", Status: models.ChunkStatusPending},
+	}
+	if _, err := mgr.ImportSession("", file, gap); err == nil {
+		t.Fatalf("expected error for chunks not covering the file")
+	}
+}
+
+func TestLoadFallsBackToValidCopy(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	for _, id := range []string{"chunk-1", "chunk-2"} {
+		if err := mgr.UpdateChunkStatus(s.ID, id, models.ChunkStatusCompleted); err != nil {
+			t.Fatalf("UpdateChunkStatus: %v", err)
+		}
+	}
+	if err := mgr.PersistCheckpoint(s.ID); err != nil {
+		t.Fatalf("PersistCheckpoint: %v", err)
+	}
+
+	// Simulate a crash halfway through writing the current file: it is
+	// truncated, and the newest rotated copy predates chunk-2.
+	path := filepath.Join(dir, s.ID+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected a rotated copy: %v", err)
+	}
+
+	mgr2, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager 2: %v", err)
+	}
+	if n := len(mgr2.ListSessions()); n != 1 {
+		t.Fatalf("loaded %d sessions, want 1 (checkpoint files are not sessions)", n)
+	}
+	s2, err := mgr2.GetSession(s.ID)
+	if err != nil {
+		t.Fatalf("GetSession after corruption: %v", err)
+	}
+	// The checkpoint restores progress the rotated copy is missing.
+	if s2.Completed != 2 || s2.Chunks["chunk-2"].Status != models.ChunkStatusCompleted {
+		t.Fatalf("recovered session has completed=%d chunks=%v", s2.Completed, s2.Chunks)
+	}
+}
+
+func TestLoadDetectsChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	mgr.store.(*JSONStore).Backups = 0
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	path := filepath.Join(dir, s.ID+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte inside the JSON while keeping it well-formed.
+	corrupted := bytes.Replace(data, []byte(`"test.bin"`), []byte(`"tesT.bin"`), 1)
+	if err := os.WriteFile(path, corrupted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.LoadSession(s.ID); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("LoadSession: got %v, want ErrCorrupt", err)
+	}
+
+	// Files written before checksum footers still load.
+	legacy := data[:bytes.LastIndex(data, []byte(checksumPrefix))]
+	if err := os.WriteFile(path, legacy, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.LoadSession(s.ID); err != nil {
+		t.Fatalf("LoadSession of legacy file: %v", err)
+	}
+}
 
+func TestInspectReportsDamagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := mgr.SaveSession(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.PersistCheckpoint(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, s.ID+".json")
+	if err := os.WriteFile(path, []byte("{trunc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := mgr.store.(*JSONStore).Inspect(s.ID)
+	damaged := make(map[string]bool)
+	for _, c := range checks {
+		damaged[filepath.Base(c.Path)] = c.Err != ""
+	}
+	want := map[string]bool{s.ID + ".json": true, s.ID + ".json.1": false, s.ID + ".checkpoint.json": false}
+	if len(damaged) != len(want) {
+		t.Fatalf("Inspect = %+v", checks)
+	}
+	for name, bad := range want {
+		if got, ok := damaged[name]; !ok || got != bad {
+			t.Errorf("%s: damaged = %v (found %v), want %v", name, got, ok, bad)
+		}
+	}
+}
+
+func TestRecomputeCountersAndProgress(t *testing.T) {
+	mgr := newTempManager(t)
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	s.TotalChunks = 4
+	for i, status := range []models.ChunkStatus{models.ChunkStatusCompleted, models.ChunkStatusCompleted, models.ChunkStatusFailed} {
+		if err := mgr.UpdateChunkStatus(s.ID, string(rune('a'+i)), status); err != nil {
+			t.Fatalf("UpdateChunkStatus: %v", err)
+		}
+	}
+	// Retries of a completed chunk do not inflate the counters.
+	for i := 0; i < 3; i++ {
+		if err := mgr.UpdateChunkStatus(s.ID, "a", models.ChunkStatusCompleted); err != nil {
+			t.Fatalf("UpdateChunkStatus: %v", err)
+		}
+	}
+	if s.Completed != 2 || s.Failed != 1 {
+		t.Fatalf("completed %d, failed %d; want 2, 1", s.Completed, s.Failed)
+	}
+	if p, err := mgr.Progress(s.ID); err != nil || p != 50 {
+		t.Fatalf("Progress = %v, %v; want 50", p, err)
+	}
+
+	// Counters saved by an older release are corrected.
+	s.Completed, s.Failed = 9, 0
+	if err := mgr.RecomputeCounters(s.ID); err != nil {
+		t.Fatalf("RecomputeCounters: %v", err)
+	}
+	if s.Completed != 2 || s.Failed != 1 {
+		t.Fatalf("after recompute: completed %d, failed %d; want 2, 1", s.Completed, s.Failed)
+	}
+	if _, err := mgr.Progress("missing"); err == nil {
+		t.Fatal("Progress of an unknown session succeeded")
+	}
+}
+
+func TestCounts(t *testing.T) {
+	mgr := newTempManager(t)
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	for i, status := range []models.ChunkStatus{models.ChunkStatusCompleted, models.ChunkStatusFailed, models.ChunkStatusPending} {
+		if err := mgr.UpdateChunkStatus(s.ID, string(rune('a'+i)), status); err != nil {
+			t.Fatalf("UpdateChunkStatus: %v", err)
+		}
+	}
+	// Without a known total, the chunks seen so far are counted.
+	if total, completed, failed, err := mgr.Counts(s.ID); err != nil || total != 3 || completed != 1 || failed != 1 {
+		t.Fatalf("Counts = %d, %d, %d, %v; want 3, 1, 1", total, completed, failed, err)
+	}
+	s.TotalChunks = 8
+	if total, _, _, _ := mgr.Counts(s.ID); total != 8 {
+		t.Fatalf("total = %d; want 8", total)
+	}
+	if _, _, _, err := mgr.Counts("missing"); err == nil {
+		t.Fatal("Counts of an unknown session succeeded")
+	}
+}
+
+func TestBranchSession(t *testing.T) {
+	mgr := newTempManager(t)
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	s.ProtocolVersion = 10
+	s.TotalChunks = 2
+	s.Route = &models.Route{}
+	for _, id := range []string{"c1", "c2"} {
+		if err := mgr.UpdateChunkStatus(s.ID, id, models.ChunkStatusCompleted); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := mgr.BranchSession(s.ID)
+	if err != nil {
+		t.Fatalf("BranchSession: %v", err)
+	}
+	if b.ID == s.ID || b.BranchOf != s.ID {
+		t.Fatalf("branch %s of %s has BranchOf %q", b.ID, s.ID, b.BranchOf)
+	}
+	if b.File.Hash != s.File.Hash || b.ProtocolVersion != 10 || b.TotalChunks != 2 || b.Route != nil {
+		t.Fatalf("branch = %+v", b)
+	}
+	if b.Status != models.SessionStatusCreated || b.Completed != 0 {
+		t.Fatalf("branch status %s with %d completed", b.Status, b.Completed)
+	}
+	if len(b.Chunks) != 2 || b.Chunks["c1"].Status != models.ChunkStatusPending || b.Chunks["c1"].SessionID != b.ID {
+		t.Fatalf("branch chunks = %+v", b.Chunks["c1"])
+	}
+	// The branch's chunks are its own.
+	if s.Chunks["c1"].Status != models.ChunkStatusCompleted {
+		t.Fatal("branching changed the source session's chunks")
+	}
+	if _, err := mgr.LoadSession(b.ID); err != nil {
+		t.Fatalf("branch not saved: %v", err)
+	}
+	if _, err := mgr.BranchSession("missing"); err == nil {
+		t.Fatal("BranchSession of an unknown session succeeded")
+	}
+}
+
+func TestFindBySender(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := models.FileMetadata{Name: "a.bin", Size: 10, Hash: "h1", SenderSession: "sender-1"}
+	s, err := mgr.CreateSession(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mgr.FindBySender("", "h1"); ok {
+		t.Fatal("found a session for an empty sender ID")
+	}
+	if _, ok := mgr.FindBySender("sender-1", "h2"); ok {
+		t.Fatal("found a session sending another file")
+	}
+
+	// The session survives a restart.
+	mgr, err = NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := mgr.FindBySender("sender-1", "h1")
+	if !ok || got.ID != s.ID {
+		t.Fatalf("FindBySender = %v, %v", got, ok)
+	}
+	for _, st := range []models.SessionStatus{models.SessionStatusTransferring, models.SessionStatusCompleted} {
+		if err := mgr.SetStatus(s.ID, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := mgr.FindBySender("sender-1", "h1"); ok {
+		t.Fatal("found a completed session")
+	}
+}
+
+func TestAdoptSession(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := models.FileMetadata{Name: "a.bin", Size: 10, Hash: "h1"}
+	const id = "0b6f4b7e-3f0a-4c59-9d43-6a1f0e2d8c11"
+	s, err := mgr.AdoptSession(id, file)
+	if err != nil || s.ID != id {
+		t.Fatalf("AdoptSession = %v, %v", s, err)
+	}
+	if _, err := mgr.AdoptSession(id, file); !errors.Is(err, ErrSessionExists) {
+		t.Fatalf("AdoptSession of a held ID: %v", err)
+	}
+	for _, bad := range []string{"", "../escape", "{0b6f4b7e-3f0a-4c59-9d43-6a1f0e2d8c11}"} {
+		if _, err := mgr.AdoptSession(bad, file); err == nil {
+			t.Fatalf("AdoptSession(%q) succeeded", bad)
+		}
+	}
+
+	// A session another process saved in the same directory is not taken
+	// over.
+	other, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id2 = "7d1c2e5a-9b3f-4e8d-a6c2-1f0b9e8d7c6a"
+	if _, err := mgr.AdoptSession(id2, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.AdoptSession(id2, file); !errors.Is(err, ErrSessionExists) {
+		t.Fatalf("AdoptSession of an ID saved by another manager: %v", err)
+	}
+}
+
+func TestCreateUpload(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := mgr.CreateUpload(models.FileMetadata{Name: "upload.bin", Size: 100})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	if s.Origin != models.OriginTus {
+		t.Errorf("Origin = %q", s.Origin)
+	}
+	s.Chunks["0"] = &models.ChunkMetadata{ID: "0", Size: 60, SHA256: "x"}
+	if err := mgr.UpdateChunkStatus(s.ID, "0", models.ChunkStatusCompleted); err != nil {
+		t.Fatalf("UpdateChunkStatus of an unhashed upload: %v", err)
+	}
+
+	// The upload survives a restart without its hash.
+	again, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := again.GetSession(s.ID)
+	if err != nil || got.Origin != models.OriginTus || got.Chunks["0"].Size != 60 {
+		t.Fatalf("reloaded upload = %+v, %v", got, err)
+	}
+
+	if _, err := mgr.CreateUpload(models.FileMetadata{Name: "empty.bin"}); err == nil {
+		t.Error("CreateUpload accepted an empty file")
+	}
+}
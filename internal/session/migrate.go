@@ -0,0 +1,74 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// SchemaVersion is the layout of session and checkpoint files written by
+// this build. Files from older releases are migrated when loaded and
+// rewritten in this layout the next time they are saved.
+//
+// Schema history:
+//
+//	0  no schema field; sessions without a protocol version were v1 and
+//	   their chunks carry no compression (always zstd)
+//	1  schema field added; protocol version and chunk compression explicit
+const SchemaVersion = 1
+
+// ErrSchemaTooNew is returned for session files written by a newer release
+// than this one. They are left untouched so that release can still resume
+// them.
+var ErrSchemaTooNew = errors.New("session file written by a newer release")
+
+// migrations[v] upgrades a session from schema v to v+1.
+var migrations = []func(*models.TransferSession) error{
+	0: migrateV0,
+}
+
+// migrate brings s up to SchemaVersion in place.
+func migrate(s *models.TransferSession) error {
+	if s.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: schema %d, this build reads up to %d; upgrade trackshift to resume it",
+			ErrSchemaTooNew, s.SchemaVersion, SchemaVersion)
+	}
+	if s.SchemaVersion < 0 {
+		return fmt.Errorf("%w: invalid schema version %d", ErrCorrupt, s.SchemaVersion)
+	}
+	for v := s.SchemaVersion; v < SchemaVersion; v++ {
+		if err := migrations[v](s); err != nil {
+			return fmt.Errorf("migrate session schema %d to %d: %w", v, v+1, err)
+		}
+		s.SchemaVersion = v + 1
+	}
+	return nil
+}
+
+// migrateV0 makes the implicit defaults of unversioned session files
+// explicit.
+func migrateV0(s *models.TransferSession) error {
+	if s.ProtocolVersion == 0 {
+		// Sessions written before version negotiation were always v1.
+		s.ProtocolVersion = protocol.Version1
+	}
+	for id, c := range s.Chunks {
+		if c == nil {
+			return fmt.Errorf("chunk %s has no metadata", id)
+		}
+		if c.ID == "" {
+			c.ID = id
+		} else if c.ID != id {
+			return fmt.Errorf("chunk %s stored under key %s", c.ID, id)
+		}
+		if c.SessionID == "" {
+			c.SessionID = s.ID
+		}
+		if c.Compression == "" && !protocol.SupportsChunkCompressionField(s.ProtocolVersion) {
+			c.Compression = models.CompressionZstd
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// v0Session is a session file as written by releases before schema versions,
+// protocol negotiation and checksum footers.
+const v0Session = `{
+  "id": "old-1",
+  "file": {"name": "test.bin", "size": 1024, "hash": "abc"},
+  "status": "transferring",
+  "chunks": {
+    "chunk-1": {"size": 512, "offset": 0, "sha256": "aa", "status": "completed"},
+    "chunk-2": {"id": "chunk-2", "size": 512, "offset": 512, "sha256": "bb", "status": "pending"}
+  },
+  "created_at": "2024-01-02T03:04:05Z",
+  "updated_at": "2024-01-02T03:04:05Z",
+  "total_chunks": 2,
+  "completed": 1
+}
+`
+
+func TestMigrateV0Session(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old-1.json"), []byte(v0Session), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	s, err := mgr.GetSession("old-1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if s.SchemaVersion != SchemaVersion || s.ProtocolVersion != protocol.Version1 {
+		t.Fatalf("schema %d protocol %d, want %d and v1", s.SchemaVersion, s.ProtocolVersion, SchemaVersion)
+	}
+	c := s.Chunks["chunk-1"]
+	if c.ID != "chunk-1" || c.SessionID != "old-1" || c.Compression != models.CompressionZstd {
+		t.Fatalf("chunk-1 not migrated: %+v", c)
+	}
+
+	// Saving writes the current schema, which loads without migration.
+	if err := mgr.SaveSession(s); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "old-1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"schema_version": 1`) {
+		t.Fatalf("saved session lacks schema version:\n%s", data)
+	}
+}
+
+func TestNewerSchemaIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "test.bin", Size: 1024, Hash: "abc"})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	// A second save leaves a rotated copy behind, which must not be used
+	// in place of the newer file.
+	if err := mgr.SaveSession(s); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	path := filepath.Join(dir, s.ID+".json")
+	newer := strings.Replace(v0Session, `"id": "old-1",`, `"id": "`+s.ID+`", "schema_version": 99,`, 1)
+	if err := os.WriteFile(path, []byte(newer), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr2, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatalf("NewSessionManager 2: %v", err)
+	}
+	if _, err := mgr2.GetSession(s.ID); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("GetSession: got %v, want ErrSchemaTooNew", err)
+	}
+	if _, err := mgr2.ImportSession(s.ID, s.File, []*models.ChunkMetadata{{ID: "c", Size: 1024, SHA256: "cc", Status: models.ChunkStatusCompleted}}); err != nil {
+		t.Fatalf("ImportSession: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != newer {
+		t.Fatal("newer session file was modified")
+	}
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// DefaultBackups is the number of previous copies kept of each session file.
+const DefaultBackups = 3
+
+// checksumPrefix starts the footer line appended to session and checkpoint
+// files: "#sha256:<hex of everything before the footer>".
+const checksumPrefix = "#sha256:"
+
+// ErrCorrupt is returned when a session file fails its checksum or cannot be
+// decoded.
+var ErrCorrupt = errors.New("session file corrupt")
+
+// backupPath returns the path of the i-th most recent previous copy of path.
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// writeChecked atomically replaces path with the JSON encoding of v followed
+// by a checksum footer. The file being replaced is kept as path.1, shifting
+// older copies up to path.<backups>.
+func writeChecked(path string, v any, backups int) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.WriteString(checksumPrefix + hex.EncodeToString(sum[:]) + "\n")
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open temp file: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	// Make the new contents durable before they replace the old ones, so a
+	// crash never leaves a renamed but empty file behind.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	if backups > 0 {
+		for i := backups - 1; i >= 1; i-- {
+			_ = os.Rename(backupPath(path, i), backupPath(path, i+1))
+		}
+		if err := os.Rename(path, backupPath(path, 1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate previous copy: %w", err)
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("atomic rename: %w", err)
+	}
+	return nil
+}
+
+// readChecked decodes the JSON in path into v after verifying its checksum
+// footer. Files written before footers were introduced are accepted if they
+// decode cleanly.
+func readChecked(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	body := data
+	trimmed := bytes.TrimRight(data, "\n")
+	if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 && bytes.HasPrefix(trimmed[i+1:], []byte(checksumPrefix)) {
+		body = data[:i+1]
+		want := strings.TrimPrefix(string(trimmed[i+1:]), checksumPrefix)
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != want {
+			return fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+		}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return nil
+}
+
+// loadSessionFile reads, migrates and validates a single session file.
+func loadSessionFile(path string) (*models.TransferSession, error) {
+	var s models.TransferSession
+	if err := readChecked(path, &s); err != nil {
+		return nil, err
+	}
+	if err := migrate(&s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// applyCheckpoint marks chunks completed according to the session's
+// checkpoint if it is newer than s, which happens when s was recovered from
+// an older copy.
+func (j *JSONStore) applyCheckpoint(s *models.TransferSession) {
+	var cp SessionCheckpoint
+	if err := readChecked(j.checkpointPath(s.ID), &cp); err != nil {
+		return
+	}
+	applyCheckpointTo(s, &cp)
+}
+
+// applyCheckpointTo marks chunks completed according to cp if it is a
+// checkpoint of s newer than s.
+func applyCheckpointTo(s *models.TransferSession, cp *SessionCheckpoint) {
+	if cp.SchemaVersion > SchemaVersion || cp.SessionID != s.ID || !cp.LastUpdateTime.After(s.UpdatedAt) {
+		return
+	}
+	for _, id := range cp.CompletedChunks {
+		chunk, ok := s.Chunks[id]
+		if !ok {
+			chunk = &models.ChunkMetadata{ID: id, SessionID: s.ID, CreatedAt: cp.LastUpdateTime}
+			s.Chunks[id] = chunk
+		}
+		if chunk.Status != models.ChunkStatusCompleted {
+			chunk.Status = models.ChunkStatusCompleted
+			chunk.UpdatedAt = cp.LastUpdateTime
+			s.Completed++
+		}
+	}
+	if cp.TotalChunks > s.TotalChunks {
+		s.TotalChunks = cp.TotalChunks
+	}
+	s.UpdatedAt = cp.LastUpdateTime
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// DeleteSession removes session id from the manager and its store, with
+// everything the store kept for it. A session that failed to load can be
+// deleted too.
+func (m *SessionManager) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, loaded := m.sessions[id]
+	if _, failed := m.loadErrs[id]; !loaded && !failed {
+		return fmt.Errorf("session %s not found", id)
+	}
+	if err := m.store.Delete(id); err != nil {
+		return err
+	}
+	delete(m.sessions, id)
+	delete(m.loadErrs, id)
+	return nil
+}
+
+// PurgeOptions selects the sessions Purge deletes.
+type PurgeOptions struct {
+	// Statuses, if any, limits the purge to sessions in one of them.
+	Statuses []models.SessionStatus
+	// Before, if set, limits the purge to sessions last updated before it.
+	Before time.Time
+	// DryRun selects the sessions without deleting them.
+	DryRun bool
+}
+
+// Purge deletes the sessions selected by opts, as DeleteSession does, and
+// returns them. Sessions that failed to load are never selected; delete
+// them by ID. On error the sessions deleted so far are returned with it.
+func (m *SessionManager) Purge(opts PurgeOptions) ([]*models.TransferSession, error) {
+	var selected []*models.TransferSession
+	for _, s := range m.ListSessions() {
+		if len(opts.Statuses) > 0 && !slices.Contains(opts.Statuses, s.Status) {
+			continue
+		}
+		if !opts.Before.IsZero() && !s.UpdatedAt.Before(opts.Before) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	if opts.DryRun {
+		return selected, nil
+	}
+	for i, s := range selected {
+		if err := m.DeleteSession(s.ID); err != nil {
+			return selected[:i], err
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestPurge(t *testing.T) {
+	for _, kind := range []string{StoreJSON, StoreBolt} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := OpenStore(kind, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mgr, err := NewSessionManagerWithStore(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file := models.FileMetadata{Name: "f.bin", Size: 10, Hash: "abc"}
+			done, _ := mgr.CreateSession(file)
+			failed, _ := mgr.CreateSession(file)
+			live, _ := mgr.CreateSession(file)
+			for _, s := range []*models.TransferSession{done, failed, live} {
+				if err := mgr.SetStatus(s.ID, models.SessionStatusTransferring); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := mgr.SetStatus(done.ID, models.SessionStatusCompleted); err != nil {
+				t.Fatal(err)
+			}
+			if err := mgr.SetStatus(failed.ID, models.SessionStatusFailed); err != nil {
+				t.Fatal(err)
+			}
+			if err := mgr.PersistCheckpoint(done.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			finished := []models.SessionStatus{models.SessionStatusCompleted, models.SessionStatusFailed}
+			got, err := mgr.Purge(PurgeOptions{Statuses: finished, Before: time.Now().Add(-time.Hour)})
+			if err != nil || len(got) != 0 {
+				t.Fatalf("purge of old sessions = %d, %v; want none", len(got), err)
+			}
+			got, err = mgr.Purge(PurgeOptions{Statuses: finished, DryRun: true})
+			if err != nil || len(got) != 2 || len(mgr.ListSessions()) != 3 {
+				t.Fatalf("dry run = %d, %v with %d sessions left", len(got), err, len(mgr.ListSessions()))
+			}
+			got, err = mgr.Purge(PurgeOptions{Statuses: finished})
+			if err != nil || len(got) != 2 {
+				t.Fatalf("purge = %d, %v", len(got), err)
+			}
+			if _, err := mgr.GetSession(done.ID); err == nil {
+				t.Fatal("completed session still there")
+			}
+			if err := mgr.DeleteSession(done.ID); err == nil {
+				t.Fatal("deleting a purged session succeeded")
+			}
+			mgr.Close()
+
+			// Nothing of the purged sessions is left to be loaded again.
+			if kind == StoreJSON {
+				entries, _ := os.ReadDir(dir)
+				for _, e := range entries {
+					if !strings.HasPrefix(e.Name(), live.ID) {
+						t.Errorf("left behind %s", e.Name())
+					}
+				}
+			}
+			store, err = OpenStore(kind, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mgr, err = NewSessionManagerWithStore(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mgr.Close()
+			if list := mgr.ListSessions(); len(list) != 1 || list[0].ID != live.ID {
+				t.Fatalf("sessions after reopening: %d", len(list))
+			}
+		})
+	}
+}
+
+func TestDeleteSessionThatFailedToLoad(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := mgr.CreateSession(models.FileMetadata{Name: "f.bin", Size: 10, Hash: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, s.ID+".json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err = NewSessionManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.GetSession(s.ID); err == nil {
+		t.Fatal("corrupt session loaded")
+	}
+	if err := mgr.DeleteSession(s.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("%d files left", len(entries))
+	}
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Store persists sessions for a SessionManager. The manager serializes calls
+// to it, so implementations need not be safe for concurrent use.
+type Store interface {
+	// IDs lists the sessions held by the store.
+	IDs() ([]string, error)
+	// Load reads, migrates and validates one session.
+	Load(id string) (*models.TransferSession, error)
+	// Save persists the whole session.
+	Save(s *models.TransferSession) error
+	// SaveChunk persists a change to one chunk of s, along with the
+	// session's counters and timestamps.
+	SaveChunk(s *models.TransferSession, chunk *models.ChunkMetadata) error
+	// Checkpoint records s's chunk progress for stores that cannot persist
+	// it cheaply otherwise; it may be a no-op.
+	Checkpoint(s *models.TransferSession) error
+	// Delete removes everything kept for session id. Deleting a session
+	// the store does not hold is not an error.
+	Delete(id string) error
+	// Close releases the store.
+	Close() error
+}
+
+// Store kinds accepted by OpenStore.
+const (
+	StoreJSON = "json"
+	StoreBolt = "bolt"
+)
+
+// OpenStore opens the session store of the given kind in dir, creating dir
+// if needed.
+func OpenStore(kind, dir string) (Store, error) {
+	if dir == "" {
+		return nil, errors.New("session dir must not be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating sessions dir: %w", err)
+	}
+	switch kind {
+	case StoreJSON, "":
+		return NewJSONStore(dir), nil
+	case StoreBolt:
+		return OpenBoltStore(filepath.Join(dir, boltFile))
+	}
+	return nil, fmt.Errorf("unknown session store %q (want %s or %s)", kind, StoreJSON, StoreBolt)
+}
+
+// JSONStore keeps each session in its own checksummed JSON file, rewritten
+// whole on every change, with rotated copies and checkpoint files to
+// recover from a corrupted write.
+type JSONStore struct {
+	dir string
+
+	// Backups is the number of previous copies kept of each session file
+	// for recovery from a corrupted write. Defaults to DefaultBackups.
+	Backups int
+}
+
+// NewJSONStore returns a JSON store in dir, which must exist.
+func NewJSONStore(dir string) *JSONStore {
+	return &JSONStore{dir: dir, Backups: DefaultBackups}
+}
+
+// IDs implements Store. A session is found by its current file or any
+// rotated copy, so one whose current file was lost in a crash is still
+// recovered.
+func (j *JSONStore) IDs() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read sessions dir: %w", err)
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		id, ok := sessionFileID(e.Name())
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// sessionFileID returns the session ID for a session file name of the form
+// <id>.json or <id>.json.<n>. Checkpoint and temporary files are skipped.
+func sessionFileID(name string) (string, bool) {
+	i := strings.Index(name, ".json")
+	if i <= 0 || strings.HasSuffix(name[:i], ".checkpoint") {
+		return "", false
+	}
+	rest := name[i+len(".json"):]
+	if rest != "" {
+		n, ok := strings.CutPrefix(rest, ".")
+		if _, err := strconv.Atoi(n); !ok || err != nil {
+			return "", false
+		}
+	}
+	return name[:i], true
+}
+
+func (j *JSONStore) sessionPath(id string) string {
+	return filepath.Join(j.dir, id+".json")
+}
+
+func (j *JSONStore) checkpointPath(id string) string {
+	return filepath.Join(j.dir, id+".checkpoint.json")
+}
+
+// Load implements Store. If the current file is missing or fails its
+// checksum, the newest valid rotated copy is used, brought up to date with
+// the session's checkpoint file.
+func (j *JSONStore) Load(id string) (*models.TransferSession, error) {
+	path := j.sessionPath(id)
+	var firstErr error
+	for i := 0; i <= j.Backups; i++ {
+		p := path
+		if i > 0 {
+			p = backupPath(path, i)
+		}
+		s, err := loadSessionFile(p)
+		if errors.Is(err, ErrSchemaTooNew) {
+			// An older copy would lose the newer release's progress, and
+			// saving it would overwrite that release's files.
+			return nil, err
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("load session file: %w", err)
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "session %s: recovered from %s (%v)\n", id, filepath.Base(p), firstErr)
+		}
+		j.applyCheckpoint(s)
+		return s, nil
+	}
+	return nil, firstErr
+}
+
+// FileCheck is the state of one file backing a JSON session.
+type FileCheck struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Err     string    `json:"error,omitempty"` // why the file is unusable; empty if intact
+}
+
+// Inspect checks each file kept for session id — the current file, its
+// rotated copies and its checkpoint — so damage that Load recovers from
+// silently can be reported. Files that do not exist are left out.
+func (j *JSONStore) Inspect(id string) []FileCheck {
+	path := j.sessionPath(id)
+	paths := []string{path}
+	for i := 1; i <= j.Backups; i++ {
+		paths = append(paths, backupPath(path, i))
+	}
+	paths = append(paths, j.checkpointPath(id))
+
+	var checks []FileCheck
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if p == j.checkpointPath(id) {
+			var cp SessionCheckpoint
+			err = readChecked(p, &cp)
+		} else {
+			_, err = loadSessionFile(p)
+		}
+		check := FileCheck{Path: p, ModTime: info.ModTime()}
+		if err != nil {
+			check.Err = err.Error()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Save implements Store.
+func (j *JSONStore) Save(s *models.TransferSession) error {
+	return writeChecked(j.sessionPath(s.ID), s, j.Backups)
+}
+
+// SaveChunk implements Store by rewriting the whole session file.
+func (j *JSONStore) SaveChunk(s *models.TransferSession, _ *models.ChunkMetadata) error {
+	return j.Save(s)
+}
+
+// Checkpoint implements Store by writing a checkpoint file, which restores
+// progress lost if the session file has to be recovered from an older copy.
+func (j *JSONStore) Checkpoint(s *models.TransferSession) error {
+	var completed, pending []string
+	for id, ch := range s.Chunks {
+		switch ch.Status {
+		case models.ChunkStatusCompleted:
+			completed = append(completed, id)
+		default:
+			pending = append(pending, id)
+		}
+	}
+
+	cp := SessionCheckpoint{
+		SchemaVersion:   SchemaVersion,
+		SessionID:       s.ID,
+		CompletedChunks: completed,
+		PendingChunks:   pending,
+		TotalChunks:     s.TotalChunks,
+		LastUpdateTime:  time.Now(),
+	}
+	return writeChecked(j.checkpointPath(s.ID), &cp, 0)
+}
+
+// Delete implements Store, removing the session file with all its rotated
+// copies, its checkpoint and any write a crash left unfinished.
+func (j *JSONStore) Delete(id string) error {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return fmt.Errorf("read sessions dir: %w", err)
+	}
+	checkpoint := filepath.Base(j.checkpointPath(id))
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".tmp")
+		if got, ok := sessionFileID(name); e.IsDir() || (!ok || got != id) && name != checkpoint {
+			continue
+		}
+		if err := os.Remove(filepath.Join(j.dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("delete session %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Close implements Store.
+func (j *JSONStore) Close() error { return nil }
@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"errors"
+	"time"
+)
+
+// ClockSample is one NTP-style time exchange between a local and a remote
+// peer: T1 local send, T2 remote receive, T3 remote send, T4 local receive.
+// T2 and T3 are read from the remote clock.
+type ClockSample struct {
+	T1, T2, T3, T4 time.Time
+}
+
+// Offset estimates how far the remote clock is ahead of the local clock.
+func (s ClockSample) Offset() time.Duration {
+	return (s.T2.Sub(s.T1) + s.T3.Sub(s.T4)) / 2
+}
+
+// RTT returns the round-trip time of the exchange, excluding the time the
+// remote peer spent before answering.
+func (s ClockSample) RTT() time.Duration {
+	return s.T4.Sub(s.T1) - s.T3.Sub(s.T2)
+}
+
+// EstimateClockOffset returns the offset and RTT of the sample with the
+// lowest RTT, which is the one least distorted by queueing delay.
+func EstimateClockOffset(samples []ClockSample) (offset, rtt time.Duration, err error) {
+	if len(samples) == 0 {
+		return 0, 0, errors.New("no clock samples")
+	}
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s.RTT() < best.RTT() {
+			best = s
+		}
+	}
+	return best.Offset(), best.RTT(), nil
+}
+
+// OneWayDelay returns the delay between sentAt (sender clock) and receivedAt
+// (receiver clock), corrected by offset, the amount the receiver clock is
+// ahead of the sender clock.
+func OneWayDelay(sentAt, receivedAt time.Time, offset time.Duration) time.Duration {
+	return receivedAt.Sub(sentAt) - offset
+}
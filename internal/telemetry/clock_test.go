@@ -0,0 +1,44 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateClockOffset(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	skew := 3 * time.Second // remote clock ahead of local
+
+	sample := func(start time.Duration, up, down time.Duration) ClockSample {
+		t1 := base.Add(start)
+		t2 := t1.Add(up).Add(skew)
+		t3 := t2.Add(time.Millisecond)
+		t4 := t3.Add(-skew).Add(down)
+		return ClockSample{T1: t1, T2: t2, T3: t3, T4: t4}
+	}
+
+	samples := []ClockSample{
+		sample(0, 40*time.Millisecond, 10*time.Millisecond), // queued, asymmetric
+		sample(time.Second, 10*time.Millisecond, 10*time.Millisecond),
+	}
+	offset, rtt, err := EstimateClockOffset(samples)
+	if err != nil {
+		t.Fatalf("EstimateClockOffset: %v", err)
+	}
+	if offset != skew {
+		t.Fatalf("expected offset %v, got %v", skew, offset)
+	}
+	if rtt != 20*time.Millisecond {
+		t.Fatalf("expected rtt 20ms, got %v", rtt)
+	}
+
+	sentAt := base
+	receivedAt := base.Add(skew).Add(15 * time.Millisecond)
+	if owd := OneWayDelay(sentAt, receivedAt, offset); owd != 15*time.Millisecond {
+		t.Fatalf("expected one-way delay 15ms, got %v", owd)
+	}
+
+	if _, _, err := EstimateClockOffset(nil); err == nil {
+		t.Fatalf("expected error for no samples")
+	}
+}
@@ -5,20 +5,58 @@ import (
 	"time"
 )
 
-// TelemetryCollector tracks simple network metrics used by the AI optimizer.
-// It is intentionally lightweight: a single instance per sender process.
+// Window is the span over which BandwidthMbps and WindowLossRate look back.
+const Window = 10 * time.Second
+
+// windowSlot is the granularity of the sliding windows.
+const windowSlot = time.Second
+
+// TelemetryCollector tracks the network signals used by the chunk size
+// optimizers, the adaptive chunker and UDP congestion control. It is
+// intentionally lightweight: a single instance per sender process.
 type TelemetryCollector struct {
 	mu sync.RWMutex
 
-	windowStart time.Time
-	bytesSent   uint64
-	lastRTT     time.Duration
+	bytesSent uint64
+	lastRTT   time.Duration
+	// sentWindow counts the bytes sent over the last Window.
+	sentWindow *slidingWindow
+
+	// srtt and rttVar are the smoothed RTT and its mean deviation, as
+	// computed for TCP's retransmission timer (RFC 6298).
+	srtt   time.Duration
+	rttVar time.Duration
+
+	// jitter is the smoothed variation between consecutive delay samples,
+	// RTTs or one-way delays (RFC 3550), and lastDelay the latest sample.
+	jitter    time.Duration
+	lastDelay time.Duration
+	hasDelay  bool
+
+	// clockOffset is how far the peer clock is ahead of ours, as estimated
+	// by the time sync exchange at session start.
+	clockOffset time.Duration
+	lastOWD     time.Duration
+
+	packetsSent uint64
+	packetsLost uint64
+	// packetWindow and lossWindow count the packets sent and reported lost
+	// over the last Window.
+	packetWindow *slidingWindow
+	lossWindow   *slidingWindow
+
+	// payloadBytes counts file bytes handed to the transport before
+	// compression; retransmitBytes is the part of it that was sent before.
+	payloadBytes    uint64
+	retransmitBytes uint64
 }
 
-// NewTelemetryCollector creates a new collector with an initialized time window.
+// NewTelemetryCollector creates a new collector with empty windows.
 func NewTelemetryCollector() *TelemetryCollector {
 	return &TelemetryCollector{
-		windowStart: time.Now(),
+		sentWindow:   newSlidingWindow(Window, windowSlot),
+		packetWindow: newSlidingWindow(Window, windowSlot),
+		lossWindow:   newSlidingWindow(Window, windowSlot),
 	}
 }
 
@@ -27,12 +65,18 @@ func (t *TelemetryCollector) RecordBytesSent(n int) {
 	if n <= 0 {
 		return
 	}
+	t.recordBytesSent(time.Now(), n)
+}
+
+func (t *TelemetryCollector) recordBytesSent(now time.Time, n int) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.bytesSent += uint64(n)
+	t.sentWindow.add(now, uint64(n))
 }
 
-// RecordRTT records the latest round-trip time measurement.
+// RecordRTT records a round-trip time measurement, updating the smoothed
+// RTT, its variance and the jitter.
 func (t *TelemetryCollector) RecordRTT(d time.Duration) {
 	if d <= 0 {
 		return
@@ -40,34 +84,199 @@ func (t *TelemetryCollector) RecordRTT(d time.Duration) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.lastRTT = d
+	if t.srtt == 0 {
+		t.srtt, t.rttVar = d, d/2
+	} else {
+		t.rttVar += (absDuration(t.srtt-d) - t.rttVar) / 4
+		t.srtt += (d - t.srtt) / 8
+	}
+	t.observeDelay(d)
+}
+
+// observeDelay folds a delay sample into the jitter. The caller holds t.mu.
+func (t *TelemetryCollector) observeDelay(d time.Duration) {
+	if t.hasDelay {
+		t.jitter += (absDuration(d-t.lastDelay) - t.jitter) / 16
+	}
+	t.lastDelay, t.hasDelay = d, true
 }
 
-// BandwidthMbps returns a very simple estimate of bandwidth in megabits per second
-// based on bytes sent in the current window divided by elapsed time.
-// If not enough data is available, it returns 0.
+// BandwidthMbps returns the send rate over the last Window in megabits per
+// second, or over the time since the first byte if that is shorter. If
+// nothing was sent in that time, it returns 0.
 func (t *TelemetryCollector) BandwidthMbps() float64 {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	return t.bandwidthMbps(time.Now())
+}
 
-	elapsed := time.Since(t.windowStart).Seconds()
-	if elapsed <= 0 || t.bytesSent == 0 {
+func (t *TelemetryCollector) bandwidthMbps(now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bytes, covered := t.sentWindow.sum(now)
+	if bytes == 0 || covered <= 0 {
 		return 0
 	}
-
 	// bits per second -> megabits per second
-	bps := float64(t.bytesSent*8) / elapsed
-	return bps / 1e6
+	return float64(bytes*8) / covered.Seconds() / 1e6
 }
 
-// LatencyMs returns the last recorded RTT in milliseconds.
-// If no RTT has been recorded yet, it returns 0.
+// LatencyMs returns the smoothed RTT in milliseconds. If no RTT has been
+// recorded yet, it returns 0.
 func (t *TelemetryCollector) LatencyMs() float64 {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	if t.lastRTT <= 0 {
+	return float64(t.srtt) / float64(time.Millisecond)
+}
+
+// SmoothedRTT returns the smoothed RTT and its mean deviation, both zero
+// until an RTT is recorded.
+func (t *TelemetryCollector) SmoothedRTT() (srtt, variance time.Duration) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.srtt, t.rttVar
+}
+
+// Jitter returns the smoothed variation between consecutive delay samples:
+// RTTs on a sender, one-way delays on a receiver.
+func (t *TelemetryCollector) Jitter() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.jitter
+}
+
+// SetClockOffset records the estimated offset of the peer clock.
+func (t *TelemetryCollector) SetClockOffset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clockOffset = d
+}
+
+// ClockOffset returns the estimated offset of the peer clock.
+func (t *TelemetryCollector) ClockOffset() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.clockOffset
+}
+
+// RecordOneWayDelay records a skew-corrected one-way delay measurement.
+// Negative values (residual estimation error) are clamped to zero.
+func (t *TelemetryCollector) RecordOneWayDelay(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastOWD = d
+	t.observeDelay(d)
+}
+
+// OneWayDelayMs returns the last recorded one-way delay in milliseconds.
+func (t *TelemetryCollector) OneWayDelayMs() float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return float64(t.lastOWD) / float64(time.Millisecond)
+}
+
+// RecordPacketsSent records that n packets were put on the wire.
+func (t *TelemetryCollector) RecordPacketsSent(n int) {
+	if n <= 0 {
+		return
+	}
+	t.recordPackets(time.Now(), n, 0)
+}
+
+func (t *TelemetryCollector) recordPackets(now time.Time, sent, lost int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.packetsSent += uint64(sent)
+	t.packetsLost += uint64(lost)
+	t.packetWindow.add(now, uint64(sent))
+	t.lossWindow.add(now, uint64(lost))
+}
+
+// RecordPacketsLost records that n packets were reported lost by the peer.
+func (t *TelemetryCollector) RecordPacketsLost(n int) {
+	if n <= 0 {
+		return
+	}
+	t.recordPackets(time.Now(), 0, n)
+}
+
+// LossRate returns the fraction of sent packets reported lost over the
+// whole transfer, in [0, 1]. If nothing has been sent yet, it returns 0.
+func (t *TelemetryCollector) LossRate() float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.packetsSent == 0 {
+		return 0
+	}
+	rate := float64(t.packetsLost) / float64(t.packetsSent)
+	if rate > 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// WindowLossRate returns the fraction of packets reported lost over the
+// last Window, in [0, 1], so loss that has cleared stops counting. If
+// nothing was sent in that time, it returns 0.
+func (t *TelemetryCollector) WindowLossRate() float64 {
+	return t.windowLossRate(time.Now())
+}
+
+func (t *TelemetryCollector) windowLossRate(now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sent, _ := t.packetWindow.sum(now)
+	lost, _ := t.lossWindow.sum(now)
+	if sent == 0 {
 		return 0
 	}
-	return float64(t.lastRTT.Milliseconds())
+	return min(float64(lost)/float64(sent), 1)
 }
 
+// RecordPayloadBytes records that n bytes of file data were handed to the
+// transport, before compression.
+func (t *TelemetryCollector) RecordPayloadBytes(n int64) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.payloadBytes += uint64(n)
+}
 
+// RecordRetransmitBytes records that n bytes of file data were sent again.
+// They should also be recorded with RecordPayloadBytes.
+func (t *TelemetryCollector) RecordRetransmitBytes(n int64) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retransmitBytes += uint64(n)
+}
+
+// Counters is a point-in-time copy of the collector's byte counters.
+type Counters struct {
+	WireBytes       uint64 // bytes written to the network, after compression
+	PayloadBytes    uint64 // file bytes handed to the transport
+	RetransmitBytes uint64 // file bytes that were sent more than once
+}
+
+// Counters returns the current byte counters.
+func (t *TelemetryCollector) Counters() Counters {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return Counters{
+		WireBytes:       t.bytesSent,
+		PayloadBytes:    t.payloadBytes,
+		RetransmitBytes: t.retransmitBytes,
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
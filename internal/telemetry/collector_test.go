@@ -0,0 +1,74 @@
+package telemetry
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBandwidthWindow(t *testing.T) {
+	c := NewTelemetryCollector()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 1 MB per second for 20 seconds, then nothing for 5.
+	for i := 0; i < 20; i++ {
+		c.recordBytesSent(start.Add(time.Duration(i)*time.Second), 1_000_000)
+	}
+	if got := c.bandwidthMbps(start.Add(19500 * time.Millisecond)); math.Abs(got-8) > 0.01 {
+		t.Fatalf("steady bandwidth = %.3f Mbps, want 8", got)
+	}
+	// Five idle seconds drop out half the window.
+	if got := c.bandwidthMbps(start.Add(24500 * time.Millisecond)); math.Abs(got-4) > 0.01 {
+		t.Fatalf("bandwidth after idling = %.3f Mbps, want 4", got)
+	}
+	if got := c.bandwidthMbps(start.Add(time.Minute)); got != 0 {
+		t.Fatalf("bandwidth long after the last byte = %.3f Mbps", got)
+	}
+
+	// A single count early on is spread over at least one slot.
+	fresh := NewTelemetryCollector()
+	fresh.recordBytesSent(start, 125_000)
+	if got := fresh.bandwidthMbps(start.Add(time.Millisecond)); got != 1 {
+		t.Fatalf("first-byte bandwidth = %.3f Mbps, want 1", got)
+	}
+}
+
+func TestSmoothedRTTAndJitter(t *testing.T) {
+	c := NewTelemetryCollector()
+	c.RecordRTT(100 * time.Millisecond)
+	if srtt, v := c.SmoothedRTT(); srtt != 100*time.Millisecond || v != 50*time.Millisecond {
+		t.Fatalf("first sample: srtt %v var %v", srtt, v)
+	}
+	c.RecordRTT(180 * time.Millisecond)
+	// RFC 6298: rttvar = 3/4*50 + 1/4*80, srtt = 7/8*100 + 1/8*180.
+	if srtt, v := c.SmoothedRTT(); srtt != 110*time.Millisecond || v != 57500*time.Microsecond {
+		t.Fatalf("second sample: srtt %v var %v", srtt, v)
+	}
+	if c.LatencyMs() != 110 {
+		t.Fatalf("LatencyMs = %v", c.LatencyMs())
+	}
+	// RFC 3550: jitter moves 1/16 of the way to each delay difference.
+	if j := c.Jitter(); j != 5*time.Millisecond {
+		t.Fatalf("jitter = %v, want 5ms", j)
+	}
+}
+
+func TestWindowLossRate(t *testing.T) {
+	c := NewTelemetryCollector()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.recordPackets(start, 100, 20)
+	for i := 1; i <= 15; i++ {
+		c.recordPackets(start.Add(time.Duration(i)*time.Second), 100, 0)
+	}
+	if got := c.LossRate(); math.Abs(got-20.0/1600) > 1e-9 {
+		t.Fatalf("lifetime loss = %v", got)
+	}
+	// The burst at the start has left the window.
+	if got := c.windowLossRate(start.Add(15 * time.Second)); got != 0 {
+		t.Fatalf("windowed loss after the burst = %v", got)
+	}
+	// Ten one-second slots end at 16s, holding the packets sent from 7s.
+	c.recordPackets(start.Add(16*time.Second), 0, 45)
+	if got := c.windowLossRate(start.Add(16 * time.Second)); math.Abs(got-0.05) > 1e-9 {
+		t.Fatalf("windowed loss = %v, want 0.05", got)
+	}
+}
@@ -0,0 +1,191 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the chunk latency
+// histogram: from a chunk crossing a LAN to one stalled behind a slow link.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counts what a sender or receiver process transferred, for
+// scraping by Prometheus. A nil *Metrics discards everything, so callers
+// can record unconditionally and gate export by whether one was created.
+type Metrics struct {
+	bytesReceived   atomic.Uint64
+	chunksCompleted atomic.Uint64
+	chunksFailed    atomic.Uint64
+	chunksRetried   atomic.Uint64
+	activeSessions  atomic.Int64
+
+	mu sync.Mutex
+	// collectors supply the bytes sent, before and after compression.
+	collectors []*TelemetryCollector
+	// latency counts chunk latencies per bucket of latencyBuckets, the last
+	// slot counting those above every bound.
+	latency      []uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{latency: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// Watch adds the byte counters of t to the bytes sent, so a process with a
+// collector per destination exports their sum.
+func (m *Metrics) Watch(t *TelemetryCollector) {
+	if m == nil || t == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, t)
+}
+
+// ChunkCompleted records a chunk sent or stored in full.
+func (m *Metrics) ChunkCompleted() {
+	if m != nil {
+		m.chunksCompleted.Add(1)
+	}
+}
+
+// ChunkFailed records a chunk that failed to send, or that was rejected or
+// failed to store.
+func (m *Metrics) ChunkFailed() {
+	if m != nil {
+		m.chunksFailed.Add(1)
+	}
+}
+
+// ChunkRetried records a chunk sent, or received, again.
+func (m *Metrics) ChunkRetried() {
+	if m != nil {
+		m.chunksRetried.Add(1)
+	}
+}
+
+// BytesReceived records n bytes of file data stored by a receiver.
+func (m *Metrics) BytesReceived(n int64) {
+	if m != nil && n > 0 {
+		m.bytesReceived.Add(uint64(n))
+	}
+}
+
+// SessionStarted and SessionEnded track the sessions in progress.
+func (m *Metrics) SessionStarted() {
+	if m != nil {
+		m.activeSessions.Add(1)
+	}
+}
+
+// SessionEnded undoes SessionStarted.
+func (m *Metrics) SessionEnded() {
+	if m != nil {
+		m.activeSessions.Add(-1)
+	}
+}
+
+// ObserveLatency records how long a chunk took: the time to send it on a
+// sender, its one-way delay on a receiver.
+func (m *Metrics) ObserveLatency(d time.Duration) {
+	if m == nil {
+		return
+	}
+	s := d.Seconds()
+	i := 0
+	for i < len(latencyBuckets) && s > latencyBuckets[i] {
+		i++
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency[i]++
+	m.latencySum += s
+	m.latencyCount++
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	var sent Counters
+	for _, t := range m.collectors {
+		c := t.Counters()
+		sent.WireBytes += c.WireBytes
+		sent.PayloadBytes += c.PayloadBytes
+		sent.RetransmitBytes += c.RetransmitBytes
+	}
+	buckets := append([]uint64(nil), m.latency...)
+	sum, count := m.latencySum, m.latencyCount
+	m.mu.Unlock()
+
+	ew := &errWriter{w: w}
+	counter := func(name, help string, v uint64) {
+		ew.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	gauge := func(name, help string, v string) {
+		ew.printf("# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, v)
+	}
+	counter("trackshift_bytes_sent_total", "Bytes written to the network, after compression.", sent.WireBytes)
+	counter("trackshift_payload_bytes_sent_total", "File bytes handed to the transport, before compression.", sent.PayloadBytes)
+	counter("trackshift_retransmit_bytes_total", "File bytes sent more than once.", sent.RetransmitBytes)
+	counter("trackshift_bytes_received_total", "File bytes received and stored.", m.bytesReceived.Load())
+	counter("trackshift_chunks_completed_total", "Chunks sent or stored in full.", m.chunksCompleted.Load())
+	counter("trackshift_chunks_failed_total", "Chunks that failed to send, or were rejected or failed to store.", m.chunksFailed.Load())
+	counter("trackshift_chunks_retried_total", "Chunks sent or received again.", m.chunksRetried.Load())
+	ratio := 0.0
+	if sent.PayloadBytes > 0 {
+		ratio = float64(sent.WireBytes) / float64(sent.PayloadBytes)
+	}
+	gauge("trackshift_compression_ratio", "Bytes on the wire per file byte sent, below 1 when compression saves bandwidth (0 before anything is sent).", formatFloat(ratio))
+	gauge("trackshift_active_sessions", "Sessions in progress.", strconv.FormatInt(m.activeSessions.Load(), 10))
+
+	const hist = "trackshift_chunk_latency_seconds"
+	ew.printf("# HELP %s Time to send a chunk on senders, its one-way delay on receivers.\n# TYPE %s histogram\n", hist, hist)
+	var cum uint64
+	for i, le := range latencyBuckets {
+		cum += buckets[i]
+		ew.printf("%s_bucket{le=\"%s\"} %d\n", hist, formatFloat(le), cum)
+	}
+	ew.printf("%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", hist, count, hist, formatFloat(sum), hist, count)
+	return ew.n, ew.err
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// Serve serves the metrics at /metrics on addr until the listener fails.
+func (m *Metrics) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m)
+	return http.ListenAndServe(addr, mux)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// errWriter keeps the first write error, so a run of writes is checked once.
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...any) {
+	if ew.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
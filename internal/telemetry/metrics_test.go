@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := NewMetrics()
+	c := NewTelemetryCollector()
+	c.RecordBytesSent(500)
+	c.RecordPayloadBytes(1000)
+	m.Watch(c)
+	m.SessionStarted()
+	m.ChunkCompleted()
+	m.ChunkCompleted()
+	m.ChunkFailed()
+	m.ChunkRetried()
+	m.BytesReceived(42)
+	m.ObserveLatency(3 * time.Millisecond)
+	m.ObserveLatency(2 * time.Minute)
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"trackshift_bytes_sent_total 500\n",
+		"trackshift_payload_bytes_sent_total 1000\n",
+		"trackshift_bytes_received_total 42\n",
+		"trackshift_chunks_completed_total 2\n",
+		"trackshift_chunks_failed_total 1\n",
+		"trackshift_chunks_retried_total 1\n",
+		"trackshift_compression_ratio 0.5\n",
+		"trackshift_active_sessions 1\n",
+		"# TYPE trackshift_chunk_latency_seconds histogram\n",
+		`trackshift_chunk_latency_seconds_bucket{le="0.001"} 0` + "\n",
+		`trackshift_chunk_latency_seconds_bucket{le="0.005"} 1` + "\n",
+		`trackshift_chunk_latency_seconds_bucket{le="60"} 1` + "\n",
+		`trackshift_chunk_latency_seconds_bucket{le="+Inf"} 2` + "\n",
+		"trackshift_chunk_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ChunkCompleted()
+	m.SessionStarted()
+	m.ObserveLatency(time.Second)
+	m.Watch(NewTelemetryCollector())
+}
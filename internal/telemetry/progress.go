@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultSmoothing is the time constant of the progress rate average.
+const DefaultSmoothing = 5 * time.Second
+
+// Progress derives display statistics for one transfer attempt from a
+// TelemetryCollector. Rates are exponentially weighted averages of the
+// collector's byte counters rather than of individual write returns, so
+// large chunks and bursty socket writes do not make the ETA jump around.
+type Progress struct {
+	tel       *TelemetryCollector
+	total     int64
+	smoothing time.Duration
+
+	mu       sync.Mutex
+	base     Counters // collector counters when the attempt started
+	lastAt   time.Time
+	last     Counters
+	rate     float64 // smoothed payload bytes per second
+	wireRate float64 // smoothed wire bytes per second
+}
+
+// ProgressStats is a snapshot returned by Progress.Sample. Byte counts cover
+// the current attempt only.
+type ProgressStats struct {
+	Done            int64         // file bytes sent, including retransmits
+	Total           int64         // file bytes to send
+	WireBytes       int64         // bytes put on the wire
+	RetransmitBytes int64         // file bytes sent again
+	Rate            float64       // smoothed file bytes per second
+	WireRate        float64       // smoothed wire bytes per second
+	ETA             time.Duration // zero until a rate is known
+}
+
+// CompressionRatio returns wire bytes per file byte sent, or 0 if nothing
+// has been sent yet. Values below 1 mean compression is saving bandwidth.
+func (s ProgressStats) CompressionRatio() float64 {
+	if s.Done <= 0 {
+		return 0
+	}
+	return float64(s.WireBytes) / float64(s.Done)
+}
+
+// NewProgress starts tracking an attempt that will send total file bytes.
+// Counters already in tel are excluded.
+func NewProgress(tel *TelemetryCollector, total int64) *Progress {
+	now := time.Now()
+	c := tel.Counters()
+	return &Progress{
+		tel:       tel,
+		total:     total,
+		smoothing: DefaultSmoothing,
+		base:      c,
+		lastAt:    now,
+		last:      c,
+	}
+}
+
+// Sample folds the counters accumulated since the previous call into the
+// smoothed rates and returns the current statistics. It is meant to be
+// called periodically, e.g. from the progress bar refresh loop.
+func (p *Progress) Sample(now time.Time) ProgressStats {
+	c := p.tel.Counters()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if dt := now.Sub(p.lastAt); dt > 0 {
+		payloadRate := float64(c.PayloadBytes-p.last.PayloadBytes) / dt.Seconds()
+		wireRate := float64(c.WireBytes-p.last.WireBytes) / dt.Seconds()
+		if p.rate == 0 && p.wireRate == 0 {
+			// Seed the average with the first measurement instead of
+			// ramping up from zero.
+			p.rate, p.wireRate = payloadRate, wireRate
+		} else {
+			alpha := 1 - math.Exp(-dt.Seconds()/p.smoothing.Seconds())
+			p.rate += alpha * (payloadRate - p.rate)
+			p.wireRate += alpha * (wireRate - p.wireRate)
+		}
+		p.lastAt = now
+		p.last = c
+	}
+
+	stats := ProgressStats{
+		Done:            int64(c.PayloadBytes - p.base.PayloadBytes),
+		Total:           p.total,
+		WireBytes:       int64(c.WireBytes - p.base.WireBytes),
+		RetransmitBytes: int64(c.RetransmitBytes - p.base.RetransmitBytes),
+		Rate:            p.rate,
+		WireRate:        p.wireRate,
+	}
+	if remaining := stats.Total - stats.Done; remaining > 0 && p.rate > 0 {
+		stats.ETA = time.Duration(float64(remaining) / p.rate * float64(time.Second))
+	}
+	return stats
+}
@@ -0,0 +1,49 @@
+package telemetry
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProgressSmoothsRateAndEstimatesETA(t *testing.T) {
+	tel := NewTelemetryCollector()
+	tel.RecordPayloadBytes(1000) // from an earlier attempt; must be excluded
+	p := NewProgress(tel, 10_000)
+	start := p.lastAt
+
+	// 1000 file bytes per second, compressed to 500 on the wire.
+	tel.RecordPayloadBytes(1000)
+	tel.RecordBytesSent(500)
+	s := p.Sample(start.Add(time.Second))
+	if s.Done != 1000 || s.WireBytes != 500 {
+		t.Fatalf("done=%d wire=%d, want 1000 and 500", s.Done, s.WireBytes)
+	}
+	if math.Abs(s.Rate-1000) > 1 {
+		t.Fatalf("rate = %.1f, want 1000", s.Rate)
+	}
+	if s.ETA != 9*time.Second {
+		t.Fatalf("ETA = %v, want 9s", s.ETA)
+	}
+	if s.CompressionRatio() != 0.5 {
+		t.Fatalf("compression ratio = %v, want 0.5", s.CompressionRatio())
+	}
+
+	// A one-second burst at 10x the rate only moves the average part way.
+	tel.RecordPayloadBytes(10_000)
+	s = p.Sample(start.Add(2 * time.Second))
+	if s.Rate <= 1000 || s.Rate >= 10_000/2 {
+		t.Fatalf("smoothed rate = %.1f, want between 1000 and 5000", s.Rate)
+	}
+}
+
+func TestProgressReportsRetransmits(t *testing.T) {
+	tel := NewTelemetryCollector()
+	p := NewProgress(tel, 100)
+	tel.RecordPayloadBytes(50)
+	tel.RecordRetransmitBytes(20)
+	s := p.Sample(time.Now())
+	if s.RetransmitBytes != 20 {
+		t.Fatalf("retransmit bytes = %d, want 20", s.RetransmitBytes)
+	}
+}
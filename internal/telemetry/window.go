@@ -0,0 +1,64 @@
+package telemetry
+
+import "time"
+
+// slidingWindow sums what was counted over the last span, in slots of a
+// fixed width, so a rate follows recent traffic instead of averaging over
+// the whole transfer. It is not safe for concurrent use.
+type slidingWindow struct {
+	width time.Duration
+	slots []uint64
+	// pos is the slot counting from head, the start of the newest slot.
+	pos  int
+	head time.Time
+	// first is when the first count was added.
+	first time.Time
+}
+
+func newSlidingWindow(span, width time.Duration) *slidingWindow {
+	return &slidingWindow{width: width, slots: make([]uint64, max(int(span/width), 1))}
+}
+
+// advance moves the window up to now, clearing the slots it passes.
+func (w *slidingWindow) advance(now time.Time) {
+	if w.head.IsZero() {
+		w.head = now
+		return
+	}
+	steps := int(now.Sub(w.head) / w.width)
+	if steps <= 0 {
+		return
+	}
+	for i := 0; i < min(steps, len(w.slots)); i++ {
+		w.pos = (w.pos + 1) % len(w.slots)
+		w.slots[w.pos] = 0
+	}
+	w.head = w.head.Add(time.Duration(steps) * w.width)
+}
+
+// add counts n at now.
+func (w *slidingWindow) add(now time.Time, n uint64) {
+	w.advance(now)
+	if w.first.IsZero() {
+		w.first = now
+	}
+	w.slots[w.pos] += n
+}
+
+// sum returns what was counted over the window as of now, and the time it
+// covers: the whole span, or less while the first count is more recent,
+// but never under one slot, so a rate taken from a single count is not
+// inflated.
+func (w *slidingWindow) sum(now time.Time) (uint64, time.Duration) {
+	if w.first.IsZero() {
+		return 0, 0
+	}
+	w.advance(now)
+	var total uint64
+	for _, n := range w.slots {
+		total += n
+	}
+	span := time.Duration(len(w.slots)) * w.width
+	covered := min(max(now.Sub(w.first), w.width), span)
+	return total, covered
+}
@@ -0,0 +1,191 @@
+// Package timeouts centralises the socket-level timeouts used by the TCP and
+// UDP transports and the orchestrator HTTP client.
+package timeouts
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Set groups the timeouts applied to one destination. In a configuration a
+// zero field means "use the default" and a negative field disables that
+// timeout; after WithDefaults every field is either positive or disabled.
+type Set struct {
+	// Connect bounds dialing a TCP connection.
+	Connect time.Duration
+	// Read is the longest a connection may go without delivering data while
+	// a frame or feedback packet is expected.
+	Read time.Duration
+	// Write bounds each socket write of at most one segment.
+	Write time.Duration
+	// Handshake bounds each round trip of a control exchange such as clock
+	// synchronisation, and TLS handshakes for HTTP.
+	Handshake time.Duration
+	// HTTP bounds a whole orchestrator API request.
+	HTTP time.Duration
+}
+
+// Defaults returns the timeouts used when nothing is configured.
+func Defaults() Set {
+	return Set{
+		Connect:   10 * time.Second,
+		Read:      2 * time.Minute,
+		Write:     30 * time.Second,
+		Handshake: 2 * time.Second,
+		HTTP:      10 * time.Second,
+	}
+}
+
+// WithDefaults returns s with unset fields taken from Defaults.
+func (s Set) WithDefaults() Set {
+	return s.over(Defaults())
+}
+
+// over returns s with its unset fields taken from base.
+func (s Set) over(base Set) Set {
+	pick := func(v, b time.Duration) time.Duration {
+		if v == 0 {
+			return b
+		}
+		return v
+	}
+	return Set{
+		Connect:   pick(s.Connect, base.Connect),
+		Read:      pick(s.Read, base.Read),
+		Write:     pick(s.Write, base.Write),
+		Handshake: pick(s.Handshake, base.Handshake),
+		HTTP:      pick(s.HTTP, base.HTTP),
+	}
+}
+
+// Deadline returns the deadline for an operation bounded by d starting now,
+// or the zero time (no deadline) if d is disabled.
+func Deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// Dialer returns a dialer honouring s.Connect.
+func (s Set) Dialer() *net.Dialer {
+	d := &net.Dialer{}
+	if s.Connect > 0 {
+		d.Timeout = s.Connect
+	}
+	return d
+}
+
+// HTTPClient returns an HTTP client whose dial, TLS handshake, response
+// header and overall request timeouts follow s.
+func (s Set) HTTPClient() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = s.Dialer().DialContext
+	tr.TLSHandshakeTimeout = max(s.Handshake, 0)
+	tr.ResponseHeaderTimeout = max(s.Read, 0)
+	return &http.Client{Transport: tr, Timeout: max(s.HTTP, 0)}
+}
+
+// Config holds the default timeouts and per-destination overrides.
+type Config struct {
+	Default Set
+	// Overrides are keyed by "host:port" or by host alone; the more specific
+	// key wins. Unset fields fall back to Default.
+	Overrides map[string]Set
+}
+
+// For returns the resolved timeouts for addr, which may be a "host:port"
+// address or a URL.
+func (c *Config) For(addr string) Set {
+	if c == nil {
+		return Defaults()
+	}
+	base := c.Default.WithDefaults()
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = strings.SplitN(addr[i+3:], "/", 2)[0]
+	}
+	if o, ok := c.Overrides[addr]; ok {
+		return o.over(base)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if o, ok := c.Overrides[host]; ok {
+			return o.over(base)
+		}
+	}
+	return base
+}
+
+// Merge parses spec with ParseSet and merges it into the defaults, so it can
+// be used as a flag.Func callback.
+func (c *Config) Merge(spec string) error {
+	s, err := ParseSet(spec)
+	if err != nil {
+		return err
+	}
+	c.Default = s.over(c.Default)
+	return nil
+}
+
+// AddOverride parses "dest=spec", where spec is as for ParseSet, and
+// records it as an override for dest. An IPv6 host may be given bare or
+// bracketed, [::1] as well as ::1.
+func (c *Config) AddOverride(arg string) error {
+	dest, spec, ok := strings.Cut(arg, "=")
+	if !ok || dest == "" {
+		return fmt.Errorf("invalid timeout override %q: want dest=connect=5s,read=1m", arg)
+	}
+	if strings.HasPrefix(dest, "[") && strings.HasSuffix(dest, "]") {
+		dest = dest[1 : len(dest)-1]
+	}
+	s, err := ParseSet(spec)
+	if err != nil {
+		return err
+	}
+	if c.Overrides == nil {
+		c.Overrides = make(map[string]Set)
+	}
+	c.Overrides[dest] = s.over(c.Overrides[dest])
+	return nil
+}
+
+// ParseSet parses a comma-separated list such as
+// "connect=5s,read=1m,write=off". Fields not mentioned are left unset; "off"
+// or "0" disables a timeout.
+func ParseSet(spec string) (Set, error) {
+	var s Set
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Set{}, fmt.Errorf("invalid timeout %q: want name=duration", field)
+		}
+		d := time.Duration(-1)
+		if value != "off" && value != "0" {
+			var err error
+			if d, err = time.ParseDuration(value); err != nil || d <= 0 {
+				return Set{}, fmt.Errorf("invalid timeout %q: want a positive duration or off", field)
+			}
+		}
+		switch strings.ToLower(name) {
+		case "connect":
+			s.Connect = d
+		case "read":
+			s.Read = d
+		case "write":
+			s.Write = d
+		case "handshake":
+			s.Handshake = d
+		case "http":
+			s.HTTP = d
+		default:
+			return Set{}, fmt.Errorf("unknown timeout %q (want connect, read, write, handshake or http)", name)
+		}
+	}
+	return s, nil
+}
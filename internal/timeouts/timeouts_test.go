@@ -0,0 +1,65 @@
+package timeouts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSet(t *testing.T) {
+	s, err := ParseSet("connect=5s, read=1m,write=off")
+	if err != nil {
+		t.Fatalf("ParseSet: %v", err)
+	}
+	want := Set{Connect: 5 * time.Second, Read: time.Minute, Write: -1}
+	if s != want {
+		t.Fatalf("ParseSet = %+v, want %+v", s, want)
+	}
+	for _, bad := range []string{"connect", "connect=fast", "connect=-1s", "linger=1s"} {
+		if _, err := ParseSet(bad); err == nil {
+			t.Fatalf("ParseSet(%q): expected error", bad)
+		}
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	var c Config
+	if err := c.Merge("read=30s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddOverride("slow.example:9000=connect=1m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddOverride("slow.example=write=off"); err != nil {
+		t.Fatal(err)
+	}
+
+	def := Defaults()
+	got := c.For("fast.example:9000")
+	if got.Read != 30*time.Second || got.Connect != def.Connect || got.Write != def.Write {
+		t.Fatalf("For(fast) = %+v", got)
+	}
+	got = c.For("slow.example:9000")
+	if got.Connect != time.Minute || got.Read != 30*time.Second || got.Write != def.Write {
+		t.Fatalf("For(slow:9000) = %+v", got)
+	}
+	got = c.For("http://slow.example:8080/api")
+	if got.Write != -1 || got.Connect != def.Connect {
+		t.Fatalf("For(slow URL) = %+v", got)
+	}
+	if Deadline(got.Write) != (time.Time{}) {
+		t.Fatal("disabled timeout should yield no deadline")
+	}
+}
+
+func TestOverrideIPv6(t *testing.T) {
+	var c Config
+	if err := c.AddOverride("[2001:db8::7]=connect=1m"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.For("[2001:db8::7]:8080"); got.Connect != time.Minute {
+		t.Fatalf("For([2001:db8::7]:8080) = %+v", got)
+	}
+	if got := c.For("http://[2001:db8::7]:8080/api"); got.Connect != time.Minute {
+		t.Fatalf("For(IPv6 URL) = %+v", got)
+	}
+}
@@ -0,0 +1,29 @@
+package transport
+
+import (
+	"fmt"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ChunkHook is called for every chunk a receiver has stored and verified,
+// with the chunk's metadata including any AppData the sender attached.
+// Hooks run on the connection's goroutine and delay the next chunk while
+// they run.
+type ChunkHook func(session *models.TransferSession, meta *models.ChunkMetadata)
+
+// ChunkReceived passes a stored chunk to r.OnChunk, if set.
+func (r *TCPReceiver) ChunkReceived(session *models.TransferSession, meta *models.ChunkMetadata) {
+	if r.OnChunk != nil {
+		r.OnChunk(session, meta)
+	}
+}
+
+// checkAppData rejects frame headers whose application metadata exceeds
+// models.MaxAppDataSize.
+func checkAppData(meta *models.ChunkMetadata) error {
+	if n := len(meta.AppData); n > models.MaxAppDataSize {
+		return fmt.Errorf("chunk %s: app data is %d bytes, limit is %d", meta.ID, n, models.MaxAppDataSize)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestAppDataPassthrough(t *testing.T) {
+	data := bytes.Repeat([]byte("record\n"), 100)
+	h := crypto.HashChunk(data)
+	meta := &models.ChunkMetadata{
+		ID:      "0",
+		Size:    int64(len(data)),
+		SHA256:  fmt.Sprintf("%x", h[:]),
+		AppData: []byte(`{"first_record":0,"records":100}`),
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		defer client.Close()
+		errCh <- NewTCPSender().SendStream(context.Background(), client, bytes.NewReader(data), meta)
+	}()
+
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	var hooked []byte
+	recv.OnChunk = func(_ *models.TransferSession, m *models.ChunkMetadata) { hooked = m.AppData }
+
+	gotMeta, r, err := recv.ReceiveStream(context.Background(), server)
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	recv.ChunkReceived(&models.TransferSession{ID: "s"}, gotMeta)
+	if !bytes.Equal(hooked, meta.AppData) {
+		t.Fatalf("hook got app data %q, want %q", hooked, meta.AppData)
+	}
+}
+
+func TestAppDataLimit(t *testing.T) {
+	meta := &models.ChunkMetadata{ID: "0", Size: 1, SHA256: "x", AppData: make([]byte, models.MaxAppDataSize+1)}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	// The frame is refused before anything is written.
+	if err := NewTCPSender().Send(context.Background(), client, []byte{1}, meta); err == nil {
+		t.Fatal("Send accepted oversized app data")
+	}
+}
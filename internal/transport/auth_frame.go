@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+)
+
+// AuthFrameID identifies the frames of the authentication handshake. A
+// sender with a token sends an auth frame first on each connection; the
+// receiver answers with an auth frame carrying an empty error once the
+// token is accepted. Receivers that require tokens refuse connections
+// whose first frame is anything else.
+const AuthFrameID = "__auth__"
+
+// ErrAuthRejected is returned by Authenticate when the receiver refuses the
+// token.
+var ErrAuthRejected = errors.New("receiver refused the auth token")
+
+// authMessage is the payload of an auth frame: the token from the sender,
+// the error, if any, in the receiver's answer.
+type authMessage struct {
+	Token string `json:"token,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Authenticate presents token to the receiver on conn and waits up to
+// s.Timeouts.Handshake for its answer. It must be the first exchange on
+// the connection.
+func (s *TCPSender) Authenticate(ctx context.Context, conn net.Conn, token string) error {
+	if err := s.SendAuth(ctx, conn, token); err != nil {
+		return fmt.Errorf("send auth frame: %w", err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	if err := conn.SetReadDeadline(timeouts.Deadline(s.Timeouts.Handshake)); err != nil {
+		return err
+	}
+	data, meta, err := (&TCPReceiver{}).Receive(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("read auth reply: %w", err)
+	}
+	if meta.ID != AuthFrameID {
+		return fmt.Errorf("unexpected frame %q during authentication", meta.ID)
+	}
+	var reply authMessage
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("decode auth reply: %w", err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("%w: %s", ErrAuthRejected, reply.Error)
+	}
+	return nil
+}
+
+// SendAuth sends an auth frame on conn without waiting for the answer, for
+// relays passing the answer back on their own.
+func (s *TCPSender) SendAuth(ctx context.Context, conn net.Conn, token string) error {
+	return s.sendControl(ctx, conn, AuthFrameID, authMessage{Token: token})
+}
+
+// DecodeAuth returns the token in the payload of an auth frame.
+func DecodeAuth(payload []byte) (string, error) {
+	var msg authMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return "", fmt.Errorf("decode auth frame: %w", err)
+	}
+	return msg.Token, nil
+}
+
+// AnswerAuth answers an auth frame on conn, accepting the token if err is
+// nil.
+func (s *TCPSender) AnswerAuth(ctx context.Context, conn net.Conn, err error) error {
+	var reply authMessage
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return s.sendControl(ctx, conn, AuthFrameID, reply)
+}
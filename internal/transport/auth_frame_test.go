@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	for token, accept := range map[string]bool{"s3cret": true, "guess": false} {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			meta, data, err := (&TCPReceiver{}).ReceiveStream(context.Background(), server)
+			if err != nil || meta.ID != AuthFrameID {
+				return
+			}
+			payload, _ := io.ReadAll(data)
+			got, err := DecodeAuth(payload)
+			if err == nil && got != "s3cret" {
+				err = errors.New("invalid auth token")
+			}
+			_ = NewTCPSender().AnswerAuth(context.Background(), server, err)
+		}()
+
+		err := NewTCPSender().Authenticate(context.Background(), client, token)
+		client.Close()
+		if accept && err != nil {
+			t.Fatalf("Authenticate(%q): %v", token, err)
+		}
+		if !accept && !errors.Is(err, ErrAuthRejected) {
+			t.Fatalf("Authenticate(%q) = %v, want ErrAuthRejected", token, err)
+		}
+	}
+}
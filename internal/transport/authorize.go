@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrRejected is returned by Authorize when the receiver's Authorizer turns
+// a transfer down.
+var ErrRejected = errors.New("transfer rejected")
+
+// AuthRequest describes a transfer proposed by a sender, as seen once its
+// handshake is over.
+type AuthRequest struct {
+	// Peer is the remote address of the connection: the sender, or the
+	// last relay for relayed transfers.
+	Peer net.Addr
+	// Conn is the connection the transfer arrives on. Applications that
+	// wrap listeners, e.g. with TLS, can inspect it for the peer's
+	// credentials.
+	Conn net.Conn
+	// SessionID is the receiver-side session the transfer was given.
+	SessionID string
+	// File is the metadata proposed by the sender.
+	File models.FileMetadata
+	// Manifest lists the files of a directory transfer, or is nil.
+	Manifest *models.Manifest
+	// OutputPath is where the file, or the root of a directory transfer,
+	// would be written.
+	OutputPath string
+}
+
+// AuthDecision is an Authorizer's answer to an AuthRequest.
+type AuthDecision struct {
+	Accept bool
+	// Reason is logged when a transfer is rejected.
+	Reason string
+	// OutputPath, if set, replaces the requested destination. A relative
+	// path is taken relative to the receiver's OutputDir.
+	OutputPath string
+}
+
+// Authorizer decides whether a transfer may proceed. It is called once per
+// session, from the connection's goroutine, and may block.
+type Authorizer func(AuthRequest) AuthDecision
+
+// Destination returns where the session's file, or the root of its
+// directory tree, ends up once received.
+func (r *TCPReceiver) Destination(session *models.TransferSession) string {
+	switch {
+	case session.OutputPath != "":
+		return session.OutputPath
+	case session.Manifest != nil:
+		return filepath.Join(r.OutputDir, session.Manifest.Root)
+	}
+	return filepath.Join(r.OutputDir, rerooted(session.File.Name))
+}
+
+// Authorize asks r.Authorize, if set, whether the session arriving on conn
+// may proceed. It must be called after the handshake frames (file metadata
+// and manifest) and before any data is stored. A rewritten destination is
+// recorded in session.OutputPath and its parent directory created. Rejected
+// transfers yield an error wrapping ErrRejected.
+func (r *TCPReceiver) Authorize(conn net.Conn, session *models.TransferSession) error {
+	if r.Authorizer == nil {
+		return nil
+	}
+	d := r.Authorizer(AuthRequest{
+		Peer:       conn.RemoteAddr(),
+		Conn:       conn,
+		SessionID:  session.ID,
+		File:       session.File,
+		Manifest:   session.Manifest,
+		OutputPath: r.Destination(session),
+	})
+	if !d.Accept {
+		if d.Reason == "" {
+			return ErrRejected
+		}
+		return fmt.Errorf("%w: %s", ErrRejected, d.Reason)
+	}
+	if d.OutputPath == "" {
+		return nil
+	}
+	dest := d.OutputPath
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(r.OutputDir, dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+	session.OutputPath = dest
+	return nil
+}
+
+// DistinctPath returns a variant of path tagged with id, shortened to 8
+// characters, e.g. "report.1b2c3d4e.pdf" for "report.pdf" and a session ID,
+// for a session whose destination is taken.
+func DistinctPath(path, id string) string {
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	ext := filepath.Ext(path)
+	if ext == path || ext == filepath.Base(path) {
+		ext = ""
+	}
+	return strings.TrimSuffix(path, ext) + "." + id + ext
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestAuthorize(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewTCPReceiver(filepath.Join(dir, "out"), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	sess := &models.TransferSession{ID: "s1", File: models.FileMetadata{Name: "report.csv", Size: 10}}
+
+	// Without an Authorizer every transfer is accepted.
+	if err := r.Authorize(conn, sess); err != nil {
+		t.Fatalf("Authorize without hook: %v", err)
+	}
+
+	var got AuthRequest
+	r.Authorizer = func(req AuthRequest) AuthDecision {
+		got = req
+		if !strings.HasSuffix(req.File.Name, ".csv") {
+			return AuthDecision{Reason: "only csv files"}
+		}
+		return AuthDecision{Accept: true, OutputPath: filepath.Join("tenant-a", req.File.Name)}
+	}
+	if err := r.Authorize(conn, sess); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if got.SessionID != "s1" || got.OutputPath != filepath.Join(r.OutputDir, "report.csv") || got.Peer == nil {
+		t.Fatalf("request = %+v", got)
+	}
+	want := filepath.Join(r.OutputDir, "tenant-a", "report.csv")
+	if sess.OutputPath != want || r.outputPath(sess) != want {
+		t.Fatalf("output path %q, want %q", sess.OutputPath, want)
+	}
+	if fi, err := os.Stat(filepath.Dir(want)); err != nil || !fi.IsDir() {
+		t.Fatalf("destination dir not created: %v", err)
+	}
+
+	other := &models.TransferSession{ID: "s2", File: models.FileMetadata{Name: "run.sh", Size: 10}}
+	err = r.Authorize(conn, other)
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "only csv files") {
+		t.Fatalf("Authorize of rejected transfer: %v", err)
+	}
+	if other.OutputPath != "" {
+		t.Fatalf("rejected session got output path %q", other.OutputPath)
+	}
+}
+
+func TestDestinationOfDirectoryTransfer(t *testing.T) {
+	r := &TCPReceiver{OutputDir: "/data", TempDir: "/data/temp"}
+	sess := &models.TransferSession{ID: "s1", Manifest: &models.Manifest{Root: "photos"}}
+	if got := r.Destination(sess); got != filepath.Join("/data", "photos") {
+		t.Fatalf("Destination = %q", got)
+	}
+	// The stream itself is still staged in TempDir.
+	if got := r.outputPath(sess); got != filepath.Join("/data/temp", "s1.stream") {
+		t.Fatalf("outputPath = %q", got)
+	}
+}
+
+func TestDistinctPath(t *testing.T) {
+	id := "1b2c3d4e-5f60-4718-9abc-def012345678"
+	for path, want := range map[string]string{
+		"/data/report.pdf": "/data/report.1b2c3d4e.pdf",
+		"/data/photos":     "/data/photos.1b2c3d4e",
+		"/data/.bashrc":    "/data/.bashrc.1b2c3d4e",
+		"/data.d/notes":    "/data.d/notes.1b2c3d4e",
+	} {
+		if got := DistinctPath(path, id); got != want {
+			t.Errorf("DistinctPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+)
+
+// aLongTimeAgo is a deadline in the past, which makes blocked I/O on a
+// connection return at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// interruptible runs op, a read or write on a connection whose deadline is
+// set by setDeadline, so that it returns once ctx is done: the deadline is
+// moved into the past and ctx.Err() returned in place of the timeout. The
+// connection is left unusable then, as is usual after a cancellation.
+func interruptible(ctx context.Context, setDeadline func(time.Time) error, op func() (int, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if ctx.Done() == nil {
+		return op()
+	}
+	stop := context.AfterFunc(ctx, func() { setDeadline(aLongTimeAgo) })
+	n, err := op()
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	return n, err
+}
+
+// connReader reads from conn until ctx is done and, with a positive
+// timeout, extends the read deadline before every read, turning it into an
+// idle timeout.
+type connReader struct {
+	ctx     context.Context
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	if cr.timeout > 0 {
+		if err := cr.conn.SetReadDeadline(timeouts.Deadline(cr.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return interruptible(cr.ctx, cr.conn.SetReadDeadline, func() (int, error) { return cr.conn.Read(p) })
+}
+
+// ctxReader reads from r until ctx is done. Unlike connReader it cannot
+// interrupt a read in progress, so it suits sources that do not block for
+// long, such as files.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
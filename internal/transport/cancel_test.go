@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSendStreamCanceled(t *testing.T) {
+	// Nobody reads the other end, so the first write blocks.
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	data := bytes.Repeat([]byte{1}, 1<<16)
+	meta := &models.ChunkMetadata{ID: "0", Size: int64(len(data))}
+	err := NewTCPSender().SendStream(ctx, client, bytes.NewReader(data), meta)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("SendStream = %v, want context.Canceled", err)
+	}
+}
+
+func TestReceiveStreamCanceled(t *testing.T) {
+	// Nothing is sent, so the header read blocks.
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, _, err := recv.ReceiveStream(ctx, server); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReceiveStream = %v, want context.Canceled", err)
+	}
+
+	// A done context fails at once, without touching the connection.
+	if _, err := recv.StoreChunkStream(ctx, "sess", &models.ChunkMetadata{ID: "0", Size: 4}, bytes.NewReader([]byte("data"))); !errors.Is(err, context.Canceled) {
+		t.Fatalf("StoreChunkStream = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ChunkIndexFile is the name of the index written next to stored chunks.
+const ChunkIndexFile = "index.json"
+
+// ChunkStore keeps received chunks on disk under a stable layout so they can
+// be consumed directly (e.g. pushed to a CDN or object store) without
+// assembling the original file:
+//
+//	<root>/<session>/<index>_<offset>_<sha256>
+//	<root>/<session>/index.json
+type ChunkStore struct {
+	Root string
+}
+
+// ChunkIndex describes the chunks of one session held in a ChunkStore.
+type ChunkIndex struct {
+	SessionID string              `json:"session_id"`
+	File      models.FileMetadata `json:"file"`
+	Chunks    []ChunkIndexEntry   `json:"chunks"`
+	CreatedAt time.Time           `json:"created_at"`
+
+	// Manifest is set when the chunks carry a directory transfer.
+	Manifest *models.Manifest `json:"manifest,omitempty"`
+}
+
+// ChunkIndexEntry is a single chunk in a ChunkIndex. Path is relative to the
+// session directory.
+type ChunkIndexEntry struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Path   string `json:"path"`
+}
+
+// NewChunkStore creates a ChunkStore rooted at root.
+func NewChunkStore(root string) (*ChunkStore, error) {
+	if root == "" {
+		return nil, fmt.Errorf("root must not be empty")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &ChunkStore{Root: root}, nil
+}
+
+// SessionDir returns the directory holding the chunks of a session.
+func (s *ChunkStore) SessionDir(sessionID string) string {
+	return filepath.Join(s.Root, sessionID)
+}
+
+// ChunkName returns the file name used for a chunk inside the session directory.
+func ChunkName(meta *models.ChunkMetadata) string {
+	return fmt.Sprintf("%s_%d_%s", meta.ID, meta.Offset, meta.SHA256)
+}
+
+// StoreChunk writes a verified chunk into the session directory.
+func (s *ChunkStore) StoreChunk(sessionID string, meta *models.ChunkMetadata, data []byte) (string, error) {
+	dir := s.SessionDir(sessionID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create session dir: %w", err)
+	}
+	path := filepath.Join(dir, ChunkName(meta))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("write chunk file: %w", err)
+	}
+	return path, nil
+}
+
+// WriteIndex writes the index file for a session, listing chunks by offset.
+func (s *ChunkStore) WriteIndex(session *models.TransferSession) (string, error) {
+	idx := ChunkIndex{
+		SessionID: session.ID,
+		File:      session.File,
+		CreatedAt: time.Now(),
+		Manifest:  session.Manifest,
+	}
+	for _, c := range session.Chunks {
+		idx.Chunks = append(idx.Chunks, ChunkIndexEntry{
+			ID:     c.ID,
+			Offset: c.Offset,
+			Size:   c.Size,
+			SHA256: c.SHA256,
+			Path:   ChunkName(c),
+		})
+	}
+	sort.Slice(idx.Chunks, func(i, j int) bool { return idx.Chunks[i].Offset < idx.Chunks[j].Offset })
+
+	dir := s.SessionDir(session.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create session dir: %w", err)
+	}
+	path := filepath.Join(dir, ChunkIndexFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("open temp index file: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&idx); err != nil {
+		f.Close()
+		return "", fmt.Errorf("encode index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("close temp index file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("atomic rename index file: %w", err)
+	}
+	return path, nil
+}
+
+// ReadChunkIndex loads the index file from a chunk directory laid out as
+// described on ChunkStore, e.g. one produced by another tool.
+func ReadChunkIndex(dir string) (*ChunkIndex, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, ChunkIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("read chunk index: %w", err)
+	}
+	var idx ChunkIndex
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		return nil, fmt.Errorf("decode chunk index: %w", err)
+	}
+	if err := idx.File.Validate(); err != nil {
+		return nil, fmt.Errorf("chunk index file metadata: %w", err)
+	}
+	if len(idx.Chunks) == 0 {
+		return nil, fmt.Errorf("chunk index lists no chunks")
+	}
+	sort.Slice(idx.Chunks, func(i, j int) bool { return idx.Chunks[i].Offset < idx.Chunks[j].Offset })
+	return &idx, nil
+}
+
+// Verify checks that every chunk listed in the index exists in dir with the
+// recorded size and SHA-256 hash.
+func (idx *ChunkIndex) Verify(dir string) error {
+	for _, e := range idx.Chunks {
+		data, err := os.ReadFile(filepath.Join(dir, e.Path))
+		if err != nil {
+			return fmt.Errorf("read chunk %s: %w", e.ID, err)
+		}
+		if int64(len(data)) != e.Size {
+			return fmt.Errorf("chunk %s: size %d does not match index size %d", e.ID, len(data), e.Size)
+		}
+		if got := fmt.Sprintf("%x", crypto.HashChunk(data)); got != e.SHA256 {
+			return fmt.Errorf("chunk %s: hash mismatch", e.ID)
+		}
+	}
+	return nil
+}
+
+// ChunkMetadata converts the index entries into completed chunk metadata.
+func (idx *ChunkIndex) ChunkMetadata() []*models.ChunkMetadata {
+	now := time.Now()
+	out := make([]*models.ChunkMetadata, 0, len(idx.Chunks))
+	for _, e := range idx.Chunks {
+		out = append(out, &models.ChunkMetadata{
+			ID:        e.ID,
+			Size:      e.Size,
+			Offset:    e.Offset,
+			SHA256:    e.SHA256,
+			Status:    models.ChunkStatusCompleted,
+			SessionID: idx.SessionID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	return out
+}
+
+// AssembleChunkDir concatenates the chunks of a chunk directory, in index
+// order, into outPath.
+func AssembleChunkDir(dir string, idx *ChunkIndex, outPath string) error {
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open output file: %w", err)
+	}
+	defer out.Close()
+
+	for _, e := range idx.Chunks {
+		data, err := os.ReadFile(filepath.Join(dir, e.Path))
+		if err != nil {
+			return fmt.Errorf("read chunk %s: %w", e.ID, err)
+		}
+		if _, err := out.WriteAt(data, e.Offset); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+	}
+	return out.Close()
+}
+
+// ChunkDirReader reads the file held in a chunk directory as if it were
+// assembled, opening the chunk files covering each read.
+type ChunkDirReader struct {
+	dir string
+	idx *ChunkIndex
+}
+
+// NewChunkDirReader returns a reader over the chunks of dir listed in idx.
+func NewChunkDirReader(dir string, idx *ChunkIndex) *ChunkDirReader {
+	return &ChunkDirReader{dir: dir, idx: idx}
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *ChunkDirReader) ReadAt(p []byte, off int64) (int, error) {
+	chunks := r.idx.Chunks
+	i := sort.Search(len(chunks), func(i int) bool { return chunks[i].Offset+chunks[i].Size > off })
+	n := 0
+	for ; n < len(p) && i < len(chunks); i++ {
+		e := chunks[i]
+		f, err := os.Open(filepath.Join(r.dir, e.Path))
+		if err != nil {
+			return n, fmt.Errorf("open chunk %s: %w", e.ID, err)
+		}
+		want := min(int64(len(p)-n), e.Offset+e.Size-off)
+		m, err := f.ReadAt(p[n:n+int(want)], off-e.Offset)
+		f.Close()
+		n += m
+		off += int64(m)
+		if int64(m) < want {
+			return n, fmt.Errorf("read chunk %s: %w", e.ID, io.ErrUnexpectedEOF)
+		}
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("read chunk %s: %w", e.ID, err)
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestChunkStoreLayoutAndIndex(t *testing.T) {
+	store, err := NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore: %v", err)
+	}
+
+	sess := &models.TransferSession{
+		ID:     "sess-1",
+		File:   models.FileMetadata{Name: "a.bin", Size: 8, Hash: "abc"},
+		Chunks: make(map[string]*models.ChunkMetadata),
+	}
+	parts := [][]byte{[]byte("efgh"), []byte("abcd")}
+	offsets := []int64{4, 0}
+	for i, data := range parts {
+		h := crypto.HashChunk(data)
+		meta := &models.ChunkMetadata{
+			ID:     fmt.Sprintf("%d", offsets[i]/4),
+			Offset: offsets[i],
+			Size:   int64(len(data)),
+			SHA256: fmt.Sprintf("%x", h[:]),
+		}
+		path, err := store.StoreChunk(sess.ID, meta, data)
+		if err != nil {
+			t.Fatalf("StoreChunk: %v", err)
+		}
+		want := filepath.Join(store.Root, sess.ID, fmt.Sprintf("%s_%d_%s", meta.ID, meta.Offset, meta.SHA256))
+		if path != want {
+			t.Fatalf("expected chunk at %s, got %s", want, path)
+		}
+		sess.Chunks[meta.ID] = meta
+	}
+
+	indexPath, err := store.WriteIndex(sess)
+	if err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	raw, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	var idx ChunkIndex
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		t.Fatalf("decode index: %v", err)
+	}
+	if len(idx.Chunks) != 2 || idx.Chunks[0].Offset != 0 || idx.Chunks[1].Offset != 4 {
+		t.Fatalf("expected chunks ordered by offset, got %+v", idx.Chunks)
+	}
+	if _, err := os.Stat(filepath.Join(store.SessionDir(sess.ID), idx.Chunks[0].Path)); err != nil {
+		t.Fatalf("index path does not resolve: %v", err)
+	}
+}
+
+func TestChunkStoreImportRoundTrip(t *testing.T) {
+	store, err := NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore: %v", err)
+	}
+	content := []byte("hello chunk store import")
+	sess := &models.TransferSession{
+		ID:     "sess-import",
+		File:   models.FileMetadata{Name: "b.bin", Size: int64(len(content)), Hash: "abc"},
+		Chunks: make(map[string]*models.ChunkMetadata),
+	}
+	for i, off := 0, 0; off < len(content); i, off = i+1, off+10 {
+		end := off + 10
+		if end > len(content) {
+			end = len(content)
+		}
+		h := crypto.HashChunk(content[off:end])
+		meta := &models.ChunkMetadata{ID: fmt.Sprintf("%d", i), Offset: int64(off), Size: int64(end - off), SHA256: fmt.Sprintf("%x", h[:])}
+		if _, err := store.StoreChunk(sess.ID, meta, content[off:end]); err != nil {
+			t.Fatalf("StoreChunk: %v", err)
+		}
+		sess.Chunks[meta.ID] = meta
+	}
+	if _, err := store.WriteIndex(sess); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+
+	dir := store.SessionDir(sess.ID)
+	idx, err := ReadChunkIndex(dir)
+	if err != nil {
+		t.Fatalf("ReadChunkIndex: %v", err)
+	}
+	if err := idx.Verify(dir); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := AssembleChunkDir(dir, idx, out); err != nil {
+		t.Fatalf("AssembleChunkDir: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("assembled content mismatch: %q", got)
+	}
+
+	// Reads spanning chunks see the file without assembling it.
+	r := NewChunkDirReader(dir, idx)
+	buf := make([]byte, 12)
+	if n, err := r.ReadAt(buf, 7); err != nil || string(buf[:n]) != string(content[7:19]) {
+		t.Fatalf("ReadAt(7) = %q, %v", buf[:n], err)
+	}
+	if n, err := r.ReadAt(buf, int64(len(content))-4); err != io.EOF || string(buf[:n]) != string(content[len(content)-4:]) {
+		t.Fatalf("ReadAt at the end = %q, %v", buf[:n], err)
+	}
+
+	// Corrupt a chunk and make sure verification notices.
+	if err := os.WriteFile(filepath.Join(dir, idx.Chunks[0].Path), []byte("XXXXXXXXXX"), 0o644); err != nil {
+		t.Fatalf("corrupt chunk: %v", err)
+	}
+	if err := idx.Verify(dir); err == nil {
+		t.Fatalf("expected verification failure for corrupted chunk")
+	}
+}
@@ -0,0 +1,224 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// legacyContent is the file the v1 sender sent in testdata/v1_session.hex,
+// with -chunk-size 1024 (raised to its 5MB minimum, so one chunk).
+var legacyContent = bytes.Repeat([]byte("trackshift v1 chunk "), 200)
+
+// legacyReceive is TCPReceiver.Receive as the v1 build had it: every payload
+// is a whole zstd frame.
+func legacyReceive(conn net.Conn) ([]byte, *models.ChunkMetadata, error) {
+	var metaLen uint32
+	if err := binary.Read(conn, binary.BigEndian, &metaLen); err != nil {
+		if err == io.EOF {
+			return nil, nil, io.EOF
+		}
+		return nil, nil, fmt.Errorf("read meta length: %w", err)
+	}
+	metaBytes := make([]byte, metaLen)
+	if _, err := io.ReadFull(conn, metaBytes); err != nil {
+		return nil, nil, fmt.Errorf("read meta: %w", err)
+	}
+
+	var meta models.ChunkMetadata
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal metadata: %w", err)
+	}
+
+	var dataLen uint64
+	if err := binary.Read(conn, binary.BigEndian, &dataLen); err != nil {
+		return nil, nil, fmt.Errorf("read data length: %w", err)
+	}
+
+	data := make([]byte, dataLen)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, nil, fmt.Errorf("read data: %w", err)
+	}
+
+	decompressed, err := crypto.DecompressChunk(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decompress chunk: %w", err)
+	}
+
+	return decompressed, &meta, nil
+}
+
+// replaySession writes raw into one end of a pipe and returns the other.
+func replaySession(t *testing.T, raw []byte) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		defer client.Close()
+		_, _ = client.Write(raw)
+	}()
+	return server
+}
+
+func TestReceiveRecordedV1Session(t *testing.T) {
+	// Everything the v1 sender wrote to its connection for one file.
+	fixture, err := os.ReadFile("testdata/v1_session.hex")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(fixture)))
+	if err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	conn := replaySession(t, raw)
+	recv := &TCPReceiver{}
+
+	payload, meta, err := recv.Receive(context.Background(), conn)
+	if err != nil || meta.ID != FileMetaFrameID {
+		t.Fatalf("file metadata frame: %v, %v", meta, err)
+	}
+	var file models.FileMetadata
+	if err := json.Unmarshal(payload, &file); err != nil {
+		t.Fatalf("decode file metadata: %v", err)
+	}
+	if file.Name != "legacy.txt" || file.Size != int64(len(legacyContent)) || file.Hash != fmt.Sprintf("%x", crypto.HashChunk(legacyContent)) {
+		t.Fatalf("file metadata %+v", file)
+	}
+	// The v1 sender announced no version and reads nothing back.
+	v, err := protocol.NegotiateVersion(protocol.CurrentVersion, file.ProtocolVersion)
+	if err != nil || v != protocol.Version1 || protocol.SupportsVersionReply(file.ProtocolVersion) {
+		t.Fatalf("negotiated v%d (%v) with a v1 sender", v, err)
+	}
+
+	data, meta, err := recv.Receive(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("chunk frame: %v", err)
+	}
+	if meta.Compression != "" {
+		t.Fatalf("expected legacy frame without compression field, got %q", meta.Compression)
+	}
+	if !bytes.Equal(data, legacyContent) || meta.SHA256 != fmt.Sprintf("%x", crypto.HashChunk(data)) {
+		t.Fatalf("chunk %s does not match the file sent", meta.ID)
+	}
+	if _, _, err := recv.Receive(context.Background(), conn); err != io.EOF {
+		t.Fatalf("expected the session to end, got %v", err)
+	}
+}
+
+func TestV1SessionReadableByV1Receiver(t *testing.T) {
+	// A session stepped down to v1 sends its file metadata and chunks as
+	// whole zstd frames, which the v1 receiver reads.
+	file, _ := json.Marshal(models.FileMetadata{Name: "f.bin", Size: 1280, ProtocolVersion: protocol.Version1})
+	data := bytes.Repeat([]byte("trackshift v2 chunk "), 64)
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		s := NewTCPSender()
+		comp, _ := crypto.CompressChunk(file)
+		_ = s.Send(context.Background(), client, comp, &models.ChunkMetadata{ID: FileMetaFrameID, Compression: models.CompressionZstd})
+		comp, _ = crypto.CompressChunk(data)
+		_ = s.Send(context.Background(), client, comp, &models.ChunkMetadata{ID: "0", Size: int64(len(data)), Compression: models.CompressionZstd})
+	}()
+
+	got, meta, err := legacyReceive(server)
+	if err != nil || meta.ID != FileMetaFrameID || !bytes.Equal(got, file) {
+		t.Fatalf("file metadata frame: %v, %v", meta, err)
+	}
+	got, meta, err = legacyReceive(server)
+	if err != nil || meta.ID != "0" || !bytes.Equal(got, data) {
+		t.Fatalf("chunk frame: %v, %v", meta, err)
+	}
+}
+
+func TestAwaitVersionFromV1Receiver(t *testing.T) {
+	// A v1 receiver reads the announcement and sends nothing back.
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		for {
+			if _, _, err := legacyReceive(server); err != nil {
+				return
+			}
+		}
+	}()
+
+	s := NewTCPSender()
+	s.Timeouts.Handshake = 100 * time.Millisecond
+	file := models.FileMetadata{Name: "f.bin", Size: 1, ProtocolVersion: protocol.CurrentVersion}
+	payload, _ := json.Marshal(file)
+	comp, _ := crypto.CompressChunk(payload)
+	if err := s.Send(context.Background(), client, comp, &models.ChunkMetadata{ID: FileMetaFrameID, Compression: models.CompressionZstd}); err != nil {
+		t.Fatalf("send file metadata: %v", err)
+	}
+	v, answered, err := s.AwaitVersion(context.Background(), client, protocol.CurrentVersion)
+	if err != nil || answered || v != protocol.Version1 {
+		t.Fatalf("AwaitVersion = v%d, %v, %v; want v1 unanswered", v, answered, err)
+	}
+	// The connection is still usable for the v1 session.
+	comp, _ = crypto.CompressChunk([]byte("after"))
+	if err := s.Send(context.Background(), client, comp, &models.ChunkMetadata{ID: "0", Compression: models.CompressionZstd}); err != nil {
+		t.Fatalf("send after fallback: %v", err)
+	}
+}
+
+func TestAwaitVersion(t *testing.T) {
+	for _, tc := range []struct {
+		reply   uint8
+		wantErr bool
+	}{
+		{reply: protocol.Version9},
+		{reply: protocol.CurrentVersion},
+		{reply: protocol.CurrentVersion + 1, wantErr: true},
+		{reply: 0, wantErr: true},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			_ = NewTCPSender().SendVersion(context.Background(), server, tc.reply)
+		}()
+		v, answered, err := NewTCPSender().AwaitVersion(context.Background(), client, protocol.CurrentVersion)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("reply v%d: expected an error, got v%d", tc.reply, v)
+			}
+		} else if err != nil || !answered || v != tc.reply {
+			t.Errorf("reply v%d: got v%d, %v, %v", tc.reply, v, answered, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestReceiveRawFrame(t *testing.T) {
+	data := []byte("already compressed media bytes")
+	meta := &models.ChunkMetadata{ID: "1", Size: int64(len(data)), Compression: models.CompressionNone}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_ = NewTCPSender().Send(context.Background(), client, data, meta)
+	}()
+
+	got, _, err := (&TCPReceiver{}).Receive(context.Background(), server)
+	if err != nil {
+		t.Fatalf("Receive error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("raw payload mismatch")
+	}
+}
@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Delta transfers (protocol v9 and later) skip chunks the receiver already
+// holds in an earlier version of the file:
+//
+//  1. the sender asks for the receiver's chunk hashes with a delta request
+//     frame naming its chunking algorithm and chunk size;
+//  2. the receiver moves its existing copy of the file aside as the base,
+//     chunks it the same way and answers with a delta base frame;
+//  3. for every chunk whose hash the base contains, the sender sends a copy
+//     frame instead of the data, and the receiver stores that chunk from the
+//     base, verified against its hash like any other.
+const (
+	DeltaRequestFrameID = "__deltareq__"
+	DeltaBaseFrameID    = "__deltabase__"
+	CopyFrameID         = "__copy__"
+)
+
+// DeltaRequest is the payload of a delta request frame.
+type DeltaRequest struct {
+	Algorithm string `json:"algorithm"`
+	ChunkSize int64  `json:"chunk_size"`
+}
+
+// DeltaChunk is one chunk of the receiver's base file.
+type DeltaChunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// DeltaBase is the payload of a delta base frame. It is empty when the
+// receiver has no earlier version of the file.
+type DeltaBase struct {
+	Chunks []DeltaChunk `json:"chunks"`
+}
+
+// DeltaCopy is the payload of a copy frame: a chunk of the new file and
+// where the same bytes start in the receiver's base file.
+type DeltaCopy struct {
+	Chunk      models.ChunkMetadata `json:"chunk"`
+	BaseOffset int64                `json:"base_offset"`
+}
+
+// RequestDeltaBase sends a delta request on conn and waits for the
+// receiver's answer. Like SyncClock it must finish before ReadControl
+// starts reading from conn.
+func (s *TCPSender) RequestDeltaBase(ctx context.Context, conn net.Conn, req DeltaRequest) (*DeltaBase, error) {
+	if err := s.sendControl(ctx, conn, DeltaRequestFrameID, req); err != nil {
+		return nil, err
+	}
+	recv := &TCPReceiver{}
+	for {
+		meta, data, err := recv.ReceiveStream(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("read delta base frame: %w", err)
+		}
+		payload, err := io.ReadAll(data)
+		if err != nil {
+			return nil, fmt.Errorf("read delta base frame: %w", err)
+		}
+		if meta.ID != DeltaBaseFrameID {
+			continue
+		}
+		var base DeltaBase
+		if err := json.Unmarshal(payload, &base); err != nil {
+			return nil, fmt.Errorf("invalid delta base frame: %w", err)
+		}
+		return &base, nil
+	}
+}
+
+// SendDeltaBase answers a delta request on conn.
+func (s *TCPSender) SendDeltaBase(ctx context.Context, conn net.Conn, base DeltaBase) error {
+	return s.sendControl(ctx, conn, DeltaBaseFrameID, base)
+}
+
+// SendCopy tells the receiver to take chunk meta from its base file at
+// baseOffset.
+func (s *TCPSender) SendCopy(ctx context.Context, conn net.Conn, meta *models.ChunkMetadata, baseOffset int64) error {
+	return s.sendControl(ctx, conn, CopyFrameID, DeltaCopy{Chunk: *meta, BaseOffset: baseOffset})
+}
+
+// DecodeDeltaRequest parses the payload of a delta request frame.
+func DecodeDeltaRequest(payload []byte) (DeltaRequest, error) {
+	var req DeltaRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return DeltaRequest{}, fmt.Errorf("invalid delta request frame: %w", err)
+	}
+	return req, nil
+}
+
+// DecodeCopy parses the payload of a copy frame.
+func DecodeCopy(payload []byte) (DeltaCopy, error) {
+	var c DeltaCopy
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return DeltaCopy{}, fmt.Errorf("invalid copy frame: %w", err)
+	}
+	if c.BaseOffset < 0 || c.Chunk.Size < 0 {
+		return DeltaCopy{}, fmt.Errorf("invalid copy frame: base offset %d size %d", c.BaseOffset, c.Chunk.Size)
+	}
+	return c, nil
+}
+
+// MoveDeltaBase moves the existing output file of session, if there is one,
+// into TempDir so the new version can be written in its place, and returns
+// its new path. ok is false when there is no earlier version.
+func (r *TCPReceiver) MoveDeltaBase(session *models.TransferSession) (path string, ok bool, err error) {
+	if session.Manifest != nil {
+		return "", false, nil
+	}
+	src := r.outputPath(session)
+	if fi, err := os.Stat(src); errors.Is(err, os.ErrNotExist) || (err == nil && !fi.Mode().IsRegular()) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	path = filepath.Join(r.TempDir, session.ID+".base")
+	if err := os.Rename(src, path); err != nil {
+		return "", false, fmt.Errorf("move delta base aside: %w", err)
+	}
+	return path, true, nil
+}
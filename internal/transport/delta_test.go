@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestDeltaBaseRoundTrip(t *testing.T) {
+	senderSide, receiverSide := net.Pipe()
+	defer senderSide.Close()
+	defer receiverSide.Close()
+
+	want := DeltaBase{Chunks: []DeltaChunk{
+		{Offset: 0, Size: 10, SHA256: "aa"},
+		{Offset: 10, Size: 5, SHA256: "bb"},
+	}}
+	done := make(chan error, 1)
+	go func() {
+		recv := &TCPReceiver{}
+		meta, data, err := recv.ReceiveStream(context.Background(), receiverSide)
+		if err != nil {
+			done <- err
+			return
+		}
+		payload, err := io.ReadAll(data)
+		if err != nil {
+			done <- err
+			return
+		}
+		if meta.ID != DeltaRequestFrameID {
+			t.Errorf("frame %q, want %q", meta.ID, DeltaRequestFrameID)
+		}
+		req, err := DecodeDeltaRequest(payload)
+		if err != nil {
+			done <- err
+			return
+		}
+		if req.Algorithm != "fastcdc" || req.ChunkSize != 1024 {
+			t.Errorf("request = %+v", req)
+		}
+		done <- NewTCPSender().SendDeltaBase(context.Background(), receiverSide, want)
+	}()
+
+	got, err := NewTCPSender().RequestDeltaBase(context.Background(), senderSide, DeltaRequest{Algorithm: "fastcdc", ChunkSize: 1024})
+	if err != nil {
+		t.Fatalf("RequestDeltaBase: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver side: %v", err)
+	}
+	if len(got.Chunks) != len(want.Chunks) {
+		t.Fatalf("got %d chunks, want %d", len(got.Chunks), len(want.Chunks))
+	}
+	for i := range want.Chunks {
+		if got.Chunks[i] != want.Chunks[i] {
+			t.Fatalf("chunk %d = %+v, want %+v", i, got.Chunks[i], want.Chunks[i])
+		}
+	}
+}
+
+func TestDecodeCopyRejectsNegativeRange(t *testing.T) {
+	if _, err := DecodeCopy([]byte(`{"chunk":{"size":-1},"base_offset":0}`)); err == nil {
+		t.Fatal("expected error for negative size")
+	}
+	if _, err := DecodeCopy([]byte(`{"chunk":{"size":1},"base_offset":-5}`)); err == nil {
+		t.Fatal("expected error for negative base offset")
+	}
+}
+
+func TestMoveDeltaBase(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewTCPReceiver(filepath.Join(dir, "out"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	sess := &models.TransferSession{ID: "s1", File: models.FileMetadata{Name: "data.bin"}}
+
+	if _, ok, err := r.MoveDeltaBase(sess); err != nil || ok {
+		t.Fatalf("no earlier version: ok=%v err=%v", ok, err)
+	}
+
+	out := filepath.Join(r.OutputDir, "data.bin")
+	if err := os.WriteFile(out, []byte("old version"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path, ok, err := r.MoveDeltaBase(sess)
+	if err != nil || !ok {
+		t.Fatalf("MoveDeltaBase: ok=%v err=%v", ok, err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "old version" {
+		t.Fatalf("base = %q, %v", b, err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("output still present after move: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ErrFileHashMismatch is returned by FinalizeOutput and AssembleFile when
+// the written file does not match the session's whole-file hash.
+var ErrFileHashMismatch = errors.New("file hash mismatch")
+
+// ErrNoOutput is returned for sessions with no output file being written in
+// place, i.e. not prepared with PrepareOutput or already finalized.
+var ErrNoOutput = errors.New("no output being written in place")
+
+// directOutput is an output file being written in place, together with a
+// whole-file hash that advances over the contiguous prefix of landed chunks.
+// Verification thus happens during the transfer, and FinalizeOutput only has
+// to hash whatever was not yet covered.
+type directOutput struct {
+	f    *os.File
+	size int64
+
+	mu        sync.Mutex
+	hash      hash.Hash
+	next      int64           // bytes of the file covered by hash
+	landed    map[int64]int64 // offset -> size of verified chunks beyond next
+	changed   chan struct{}   // closed and replaced when next grows or finished is set
+	finished  bool            // FinalizeOutput or DiscardOutput is done with the file
+	discarded bool            // the file was closed unfinalized by DiscardOutput
+}
+
+// notify wakes WaitVerifiedPrefix callers; o.mu must be held.
+func (o *directOutput) notify() {
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// advance records a verified chunk and extends the prefix hash over every
+// chunk that is now contiguous with it.
+func (o *directOutput) advance(offset, size int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if offset < o.next {
+		return nil // duplicate of a chunk already hashed
+	}
+	o.landed[offset] = size
+	start := o.next
+	defer func() {
+		if o.next != start {
+			o.notify()
+		}
+	}()
+	for {
+		size, ok := o.landed[o.next]
+		if !ok {
+			return nil
+		}
+		// A prefix hashed partway could never be completed, so this is not
+		// cut short by cancellation.
+		if err := o.hashRange(context.Background(), o.next, size); err != nil {
+			return err
+		}
+		delete(o.landed, o.next)
+		o.next += size
+	}
+}
+
+// hashRange feeds [offset, offset+size) of the output file to the prefix
+// hash, until ctx is done. The data was just written, so it is normally
+// served from page cache.
+func (o *directOutput) hashRange(ctx context.Context, offset, size int64) error {
+	if _, err := io.Copy(o.hash, ctxReader{ctx, io.NewSectionReader(o.f, offset, size)}); err != nil {
+		return fmt.Errorf("hash output at offset %d: %w", offset, err)
+	}
+	return nil
+}
+
+// PrepareOutput creates the final output file for a session and preallocates
+// it to the full file size, so chunks can be written in place with
+// StoreChunkAt and no assembly pass is needed. An existing file, e.g. one
+// left by an earlier attempt of the session, is cut to that size.
+func (r *TCPReceiver) PrepareOutput(session *models.TransferSession) (string, error) {
+	outPath := r.outputPath(session)
+
+	r.outputsMu.Lock()
+	defer r.outputsMu.Unlock()
+	if _, ok := r.outputs[session.ID]; ok {
+		return outPath, nil
+	}
+
+	f, err := os.OpenFile(outPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("open output file: %w", err)
+	}
+	// Preallocating never shrinks a file, and a longer one would keep its
+	// old tail.
+	if err := f.Truncate(session.File.Size); err != nil {
+		f.Close()
+		return "", fmt.Errorf("size output file: %w", err)
+	}
+	if err := preallocate(f, session.File.Size); err != nil {
+		f.Close()
+		return "", fmt.Errorf("preallocate output file: %w", err)
+	}
+	if r.outputs == nil {
+		r.outputs = make(map[string]*directOutput)
+	}
+	r.outputs[session.ID] = &directOutput{
+		f:       f,
+		size:    session.File.Size,
+		hash:    sha256.New(),
+		landed:  make(map[int64]int64),
+		changed: make(chan struct{}),
+	}
+	return outPath, nil
+}
+
+// output returns the prepared output of a session.
+func (r *TCPReceiver) output(sessionID string) (*directOutput, error) {
+	r.outputsMu.Lock()
+	defer r.outputsMu.Unlock()
+	out, ok := r.outputs[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, ErrNoOutput)
+	}
+	return out, nil
+}
+
+// StoreChunkAt verifies chunk data against meta.SHA256 and then writes it
+// directly into the session's output file at meta.Offset. A chunk that does
+// not match leaves the file untouched. PrepareOutput must have been called
+// for the session. It stops once ctx is done.
+func (r *TCPReceiver) StoreChunkAt(ctx context.Context, session *models.TransferSession, meta *models.ChunkMetadata, data io.Reader) error {
+	out, err := r.output(session.ID)
+	if err != nil {
+		return err
+	}
+	if meta.Offset < 0 || meta.Offset+meta.Size > session.File.Size {
+		return fmt.Errorf("chunk %s range [%d, %d) outside file size %d", meta.ID, meta.Offset, meta.Offset+meta.Size, session.File.Size)
+	}
+
+	// The chunk is held until it verifies: a corrupt resend written in
+	// place would overwrite good bytes already hashed into the prefix.
+	h := sha256.New()
+	buf, err := io.ReadAll(io.TeeReader(ctxReader{ctx, io.LimitReader(data, meta.Size+1)}, h))
+	if err != nil {
+		return fmt.Errorf("read chunk %s: %w", meta.ID, err)
+	}
+	if int64(len(buf)) != meta.Size {
+		return fmt.Errorf("chunk %s: got %d bytes, expected %d", meta.ID, len(buf), meta.Size)
+	}
+	if !hashMatches(h, meta.SHA256) {
+		return ErrChunkHashMismatch
+	}
+	if _, err := out.f.WriteAt(buf, meta.Offset); err != nil {
+		return fmt.Errorf("write chunk at offset %d: %w", meta.Offset, err)
+	}
+	return out.advance(meta.Offset, meta.Size)
+}
+
+// VerifiedPrefix returns how many leading bytes of a session's output file
+// have already been folded into the whole-file hash.
+func (r *TCPReceiver) VerifiedPrefix(sessionID string) int64 {
+	out, err := r.output(sessionID)
+	if err != nil {
+		return 0
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return out.next
+}
+
+// OutputPrefix describes a file being written in place and how much of it
+// can already be consumed.
+type OutputPrefix struct {
+	SessionID string `json:"session_id"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	// Verified is the length of the largest prefix of the file made of
+	// chunks that arrived and matched their hashes. Those bytes are final
+	// and may be read while the rest is still arriving.
+	Verified int64 `json:"verified_bytes"`
+}
+
+func (o *directOutput) prefix(sessionID string) OutputPrefix {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return OutputPrefix{SessionID: sessionID, Path: o.f.Name(), Size: o.size, Verified: o.next}
+}
+
+// Outputs lists the files being written in place, by session ID. Sessions
+// are listed from PrepareOutput until FinalizeOutput.
+func (r *TCPReceiver) Outputs() []OutputPrefix {
+	r.outputsMu.Lock()
+	out := make([]OutputPrefix, 0, len(r.outputs))
+	for id, o := range r.outputs {
+		out = append(out, o.prefix(id))
+	}
+	r.outputsMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
+
+// Output describes the file of a session being written in place.
+func (r *TCPReceiver) Output(sessionID string) (OutputPrefix, error) {
+	o, err := r.output(sessionID)
+	if err != nil {
+		return OutputPrefix{}, err
+	}
+	return o.prefix(sessionID), nil
+}
+
+// WaitVerifiedPrefix blocks until at least n leading bytes of a session's
+// output file are verified, so a consumer can read up to that offset, and
+// returns the verified length. It returns early with the current length and
+// ctx's error if ctx is done first. If the file is finalized with a gap
+// before n, it returns ErrFileHashMismatch; if it is discarded, ErrNoOutput.
+func (r *TCPReceiver) WaitVerifiedPrefix(ctx context.Context, sessionID string, n int64) (int64, error) {
+	o, err := r.output(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if n > o.size {
+		return 0, fmt.Errorf("offset %d beyond file size %d", n, o.size)
+	}
+	for {
+		o.mu.Lock()
+		next, finished, discarded, changed := o.next, o.finished, o.discarded, o.changed
+		o.mu.Unlock()
+		switch {
+		case next >= n:
+			return next, nil
+		case discarded:
+			return next, fmt.Errorf("session %s: %w", sessionID, ErrNoOutput)
+		case finished:
+			return next, ErrFileHashMismatch
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return next, ctx.Err()
+		}
+	}
+}
+
+// FinalizeOutput completes the whole-file hash, checks it against the
+// session's file hash, then flushes and closes the output file of a session
+// prepared with PrepareOutput and returns its path. A mismatch is reported
+// as ErrFileHashMismatch after the file has been closed. If ctx is done
+// while the hash is completed, the file is closed unverified and ctx.Err()
+// returned.
+func (r *TCPReceiver) FinalizeOutput(ctx context.Context, session *models.TransferSession) (string, error) {
+	r.outputsMu.Lock()
+	out, ok := r.outputs[session.ID]
+	delete(r.outputs, session.ID)
+	r.outputsMu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("session %s: %w", session.ID, ErrNoOutput)
+	}
+
+	// Hash any tail the prefix did not reach, e.g. after a chunk failed and
+	// a gap remained.
+	out.mu.Lock()
+	var hashErr error
+	if out.next < session.File.Size {
+		hashErr = out.hashRange(ctx, out.next, session.File.Size-out.next)
+	}
+	matches := hashErr == nil && hashMatches(out.hash, session.File.Hash)
+	if matches {
+		out.next = session.File.Size
+	}
+	out.finished = true
+	out.notify()
+	out.mu.Unlock()
+
+	f := out.f
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("sync output file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("close output file: %w", err)
+	}
+	if hashErr != nil {
+		return "", hashErr
+	}
+	if !matches {
+		return f.Name(), fmt.Errorf("%s: %w", f.Name(), ErrFileHashMismatch)
+	}
+	return f.Name(), nil
+}
+
+// DiscardOutput closes the output file of a session prepared with
+// PrepareOutput without finalizing it, e.g. when the connection ends before
+// the file was delivered. The file stays on disk until a resumed session
+// prepares it again. Sessions with no output open are ignored.
+func (r *TCPReceiver) DiscardOutput(sessionID string) error {
+	r.outputsMu.Lock()
+	out, ok := r.outputs[sessionID]
+	delete(r.outputs, sessionID)
+	r.outputsMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	out.mu.Lock()
+	out.finished, out.discarded = true, true
+	out.notify()
+	out.mu.Unlock()
+	if err := out.f.Close(); err != nil {
+		return fmt.Errorf("close output file: %w", err)
+	}
+	return nil
+}
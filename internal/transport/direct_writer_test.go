@@ -0,0 +1,303 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+)
+
+func TestStoreChunkAtWritesInPlace(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("0123456789abcdefghij")
+	sess := &models.TransferSession{
+		ID:   "direct-1",
+		File: models.FileMetadata{Name: "direct.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256(content)},
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+
+	// Write the second half first to exercise out-of-order arrival.
+	for _, off := range []int64{10, 0} {
+		part := content[off : off+10]
+		h := crypto.HashChunk(part)
+		meta := &models.ChunkMetadata{ID: fmt.Sprintf("%d", off/10), Offset: off, Size: 10, SHA256: fmt.Sprintf("%x", h[:])}
+		if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(part)); err != nil {
+			t.Fatalf("StoreChunkAt(%d): %v", off, err)
+		}
+	}
+
+	// A corrupt resend of the first chunk must not reach the file.
+	h := crypto.HashChunk(content[:10])
+	bad := &models.ChunkMetadata{ID: "0", Offset: 0, Size: 10, SHA256: fmt.Sprintf("%x", h[:])}
+	if err := recv.StoreChunkAt(context.Background(), sess, bad, bytes.NewReader([]byte("XXXXXXXXXX"))); !errors.Is(err, ErrChunkHashMismatch) {
+		t.Fatalf("expected ErrChunkHashMismatch, got %v", err)
+	}
+	out, err := recv.Output(sess.ID)
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	if got, err := os.ReadFile(out.Path); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("file after a rejected chunk: %q, %v", got, err)
+	}
+
+	outPath, err := recv.FinalizeOutput(context.Background(), sess)
+	if err != nil {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("output mismatch: %q", got)
+	}
+}
+
+func TestStoreChunkAtVerifiesProgressively(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("aaaaabbbbbcccccddddd")
+	sess := &models.TransferSession{
+		ID:   "direct-2",
+		File: models.FileMetadata{Name: "prog.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256(content)},
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+
+	store := func(off int64) {
+		t.Helper()
+		part := content[off : off+5]
+		h := crypto.HashChunk(part)
+		meta := &models.ChunkMetadata{ID: fmt.Sprintf("%d", off/5), Offset: off, Size: 5, SHA256: fmt.Sprintf("%x", h[:])}
+		if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(part)); err != nil {
+			t.Fatalf("StoreChunkAt(%d): %v", off, err)
+		}
+	}
+
+	// The prefix only advances once the chunks before it have landed.
+	for _, step := range []struct{ off, want int64 }{{5, 0}, {15, 0}, {0, 10}, {0, 10}, {10, 20}} {
+		store(step.off)
+		if got := recv.VerifiedPrefix(sess.ID); got != step.want {
+			t.Fatalf("after chunk at %d: verified prefix %d, want %d", step.off, got, step.want)
+		}
+	}
+	if _, err := recv.FinalizeOutput(context.Background(), sess); err != nil {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+}
+
+func TestFinalizeOutputDetectsFileHashMismatch(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("0123456789")
+	sess := &models.TransferSession{
+		ID:   "direct-3",
+		File: models.FileMetadata{Name: "bad.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256([]byte("other data"))},
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+	h := crypto.HashChunk(content)
+	meta := &models.ChunkMetadata{ID: "0", Size: int64(len(content)), SHA256: fmt.Sprintf("%x", h[:])}
+	if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(content)); err != nil {
+		t.Fatalf("StoreChunkAt: %v", err)
+	}
+	if _, err := recv.FinalizeOutput(context.Background(), sess); !errors.Is(err, ErrFileHashMismatch) {
+		t.Fatalf("expected ErrFileHashMismatch, got %v", err)
+	}
+}
+
+func TestWaitVerifiedPrefix(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("aaaaabbbbbcccccddddd")
+	sess := &models.TransferSession{
+		ID:   "direct-3",
+		File: models.FileMetadata{Name: "wait.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256(content)},
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+	store := func(off int64) {
+		t.Helper()
+		part := content[off : off+5]
+		h := crypto.HashChunk(part)
+		meta := &models.ChunkMetadata{ID: fmt.Sprintf("%d", off/5), Offset: off, Size: 5, SHA256: fmt.Sprintf("%x", h[:])}
+		if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(part)); err != nil {
+			t.Fatalf("StoreChunkAt(%d): %v", off, err)
+		}
+	}
+
+	type result struct {
+		n   int64
+		err error
+	}
+	waited := make(chan result, 1)
+	go func() {
+		n, err := recv.WaitVerifiedPrefix(context.Background(), sess.ID, 12)
+		waited <- result{n, err}
+	}()
+
+	// Chunks past a gap do not extend the prefix.
+	store(5)
+	store(15)
+	if out, err := recv.Output(sess.ID); err != nil || out.Verified != 0 || out.Size != 20 {
+		t.Fatalf("Output = %+v, %v", out, err)
+	}
+	select {
+	case r := <-waited:
+		t.Fatalf("wait returned early: %+v", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+	store(0)
+	store(10)
+	if r := <-waited; r.err != nil || r.n != 20 {
+		t.Fatalf("WaitVerifiedPrefix = %+v, want 20", r)
+	}
+	if outs := recv.Outputs(); len(outs) != 1 || outs[0].Verified != 20 {
+		t.Fatalf("Outputs = %+v", outs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := recv.WaitVerifiedPrefix(ctx, sess.ID, 21); err == nil {
+		t.Fatal("wait beyond the file size succeeded")
+	}
+	if _, err := recv.FinalizeOutput(context.Background(), sess); err != nil {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+	if len(recv.Outputs()) != 0 {
+		t.Fatal("finalized output still listed")
+	}
+}
+
+func TestWaitVerifiedPrefixEndsAtFinalize(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	sess := &models.TransferSession{
+		ID:   "direct-4",
+		File: models.FileMetadata{Name: "gap.bin", Size: 10, Hash: utils.HashBytesSHA256([]byte("0123456789"))},
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		_, err := recv.WaitVerifiedPrefix(context.Background(), sess.ID, 10)
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// No chunk arrived, so the file cannot verify and the wait ends.
+	if _, err := recv.FinalizeOutput(context.Background(), sess); !errors.Is(err, ErrFileHashMismatch) {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+	if err := <-waited; !errors.Is(err, ErrFileHashMismatch) {
+		t.Fatalf("WaitVerifiedPrefix after a failed finalize: %v", err)
+	}
+}
+
+func TestPrepareOutputCutsLongerFile(t *testing.T) {
+	dir := t.TempDir()
+	recv, err := NewTCPReceiver(dir, "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("0123456789")
+	sess := &models.TransferSession{
+		ID:   "direct-5",
+		File: models.FileMetadata{Name: "short.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256(content)},
+	}
+	outPath := recv.outputPath(sess)
+	if err := os.WriteFile(outPath, []byte("an older and much longer file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+	h := crypto.HashChunk(content)
+	meta := &models.ChunkMetadata{ID: "0", Size: int64(len(content)), SHA256: fmt.Sprintf("%x", h[:])}
+	if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(content)); err != nil {
+		t.Fatalf("StoreChunkAt: %v", err)
+	}
+	if _, err := recv.FinalizeOutput(context.Background(), sess); err != nil {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+	if got, err := os.ReadFile(outPath); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("output %q, %v", got, err)
+	}
+}
+
+func TestDiscardOutput(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	content := []byte("aaaaabbbbb")
+	sess := &models.TransferSession{
+		ID:   "direct-6",
+		File: models.FileMetadata{Name: "resume.bin", Size: int64(len(content)), Hash: utils.HashBytesSHA256(content)},
+	}
+	store := func(off int64) {
+		t.Helper()
+		part := content[off : off+5]
+		h := crypto.HashChunk(part)
+		meta := &models.ChunkMetadata{ID: fmt.Sprintf("%d", off/5), Offset: off, Size: 5, SHA256: fmt.Sprintf("%x", h[:])}
+		if err := recv.StoreChunkAt(context.Background(), sess, meta, bytes.NewReader(part)); err != nil {
+			t.Fatalf("StoreChunkAt(%d): %v", off, err)
+		}
+	}
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput: %v", err)
+	}
+	store(0)
+	waited := make(chan error, 1)
+	go func() {
+		_, err := recv.WaitVerifiedPrefix(context.Background(), sess.ID, 10)
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The connection dropped: the output is closed and no longer listed.
+	if err := recv.DiscardOutput(sess.ID); err != nil {
+		t.Fatalf("DiscardOutput: %v", err)
+	}
+	if err := <-waited; !errors.Is(err, ErrNoOutput) {
+		t.Fatalf("WaitVerifiedPrefix after discard: %v", err)
+	}
+	if _, err := recv.Output(sess.ID); !errors.Is(err, ErrNoOutput) {
+		t.Fatalf("Output after discard: %v", err)
+	}
+	if err := recv.DiscardOutput(sess.ID); err != nil {
+		t.Fatalf("second DiscardOutput: %v", err)
+	}
+
+	// A resume prepares the same file again.
+	if _, err := recv.PrepareOutput(sess); err != nil {
+		t.Fatalf("PrepareOutput again: %v", err)
+	}
+	store(5)
+	if _, err := recv.FinalizeOutput(context.Background(), sess); err != nil {
+		t.Fatalf("FinalizeOutput: %v", err)
+	}
+}
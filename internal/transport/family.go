@@ -0,0 +1,27 @@
+package transport
+
+import "fmt"
+
+// Address families a listener can be restricted to, as taken by -family
+// flags. Dual-stack listeners on the unspecified address accept IPv4 and
+// IPv6 peers alike.
+const (
+	FamilyDual = "dual"
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// Network returns the network to listen on for base, "tcp" or "udp",
+// restricted to family: base itself for dual-stack, base with "4" or "6"
+// appended otherwise.
+func Network(base, family string) (string, error) {
+	switch family {
+	case FamilyDual, "":
+		return base, nil
+	case FamilyIPv4:
+		return base + "4", nil
+	case FamilyIPv6:
+		return base + "6", nil
+	}
+	return "", fmt.Errorf("unknown address family %q (want dual, ipv4 or ipv6)", family)
+}
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNetwork(t *testing.T) {
+	for _, tc := range []struct{ base, family, want string }{
+		{"tcp", FamilyDual, "tcp"},
+		{"tcp", "", "tcp"},
+		{"udp", FamilyIPv4, "udp4"},
+		{"tcp", FamilyIPv6, "tcp6"},
+	} {
+		if got, err := Network(tc.base, tc.family); err != nil || got != tc.want {
+			t.Errorf("Network(%q, %q) = %q, %v; want %q", tc.base, tc.family, got, err, tc.want)
+		}
+	}
+	if _, err := Network("tcp", "ipx"); err == nil {
+		t.Error("Network accepted an unknown family")
+	}
+}
+
+func TestUDPReceiverIPv6(t *testing.T) {
+	r, err := NewUDPReceiverAddr("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer r.Close()
+	addr := r.Addr().(*net.UDPAddr)
+	if !addr.IP.Equal(net.IPv6loopback) || addr.Port == 0 {
+		t.Errorf("bound to %v, want [::1] with a port", addr)
+	}
+	if _, err := NewUDPReceiverAddr("udp4", "[::1]:0"); err == nil {
+		t.Error("udp4 receiver bound to an IPv6 address")
+	}
+}
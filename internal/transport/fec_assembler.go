@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/internal/erasure"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// FECBlock is a block of chunk data recovered from erasure-coded shards.
+type FECBlock struct {
+	SessionID [16]byte
+	ChunkID   uint64
+	Block     uint32
+	Offset    int64 // byte offset of the block within the chunk
+	Data      []byte
+}
+
+type fecBlockKey struct {
+	session [16]byte
+	chunk   uint64
+	block   uint32
+}
+
+type fecBlockState struct {
+	hdr     protocol.ShardHeader
+	shards  [][]byte
+	present int
+	done    bool
+}
+
+// FECAssembler collects shards sent by UDPSender.SendChunkFEC and rebuilds
+// each block as soon as enough shards have arrived, without waiting for the
+// lost ones.
+type FECAssembler struct {
+	mu     sync.Mutex
+	blocks map[fecBlockKey]*fecBlockState
+	coders map[[2]int]*erasure.ErasureCoder
+}
+
+// NewFECAssembler creates an empty FECAssembler.
+func NewFECAssembler() *FECAssembler {
+	return &FECAssembler{
+		blocks: make(map[fecBlockKey]*fecBlockState),
+		coders: make(map[[2]int]*erasure.ErasureCoder),
+	}
+}
+
+// AddPacket feeds one DATA packet to the assembler. It returns the recovered
+// block once the packet completes it, and nil otherwise. Shards arriving after
+// their block was recovered are ignored.
+func (a *FECAssembler) AddPacket(p *protocol.Packet) (*FECBlock, error) {
+	hdr, shard, err := protocol.DecodeShard(p.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := fecBlockKey{session: p.SessionID, chunk: p.ChunkID, block: hdr.Block}
+	st, ok := a.blocks[key]
+	if !ok {
+		st = &fecBlockState{
+			hdr:    hdr,
+			shards: make([][]byte, int(hdr.DataShards)+int(hdr.ParityShards)),
+		}
+		a.blocks[key] = st
+	}
+	if st.done {
+		return nil, nil
+	}
+	if hdr.DataShards != st.hdr.DataShards || hdr.ParityShards != st.hdr.ParityShards || hdr.BlockLen != st.hdr.BlockLen {
+		return nil, fmt.Errorf("chunk %d block %d: inconsistent shard header", p.ChunkID, hdr.Block)
+	}
+	if st.shards[hdr.Index] == nil {
+		st.shards[hdr.Index] = append([]byte(nil), shard...)
+		st.present++
+	}
+	if st.present < int(hdr.DataShards) {
+		return nil, nil
+	}
+
+	data, err := a.decode(st)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d block %d: %w", p.ChunkID, hdr.Block, err)
+	}
+	st.done = true
+	st.shards = nil
+
+	return &FECBlock{
+		SessionID: p.SessionID,
+		ChunkID:   p.ChunkID,
+		Block:     hdr.Block,
+		Offset:    int64(hdr.Block) * int64(hdr.DataShards) * protocol.MaxShardSize,
+		Data:      data,
+	}, nil
+}
+
+// decode rebuilds a block's data from its shards and trims the padding.
+func (a *FECAssembler) decode(st *fecBlockState) ([]byte, error) {
+	dataShards := int(st.hdr.DataShards)
+	var data []byte
+	if st.hdr.ParityShards == 0 {
+		for _, s := range st.shards[:dataShards] {
+			data = append(data, s...)
+		}
+	} else {
+		key := [2]int{dataShards, int(st.hdr.ParityShards)}
+		ec, ok := a.coders[key]
+		if !ok {
+			var err error
+			ec, err = erasure.NewErasureCoder(key[0], key[1])
+			if err != nil {
+				return nil, err
+			}
+			a.coders[key] = ec
+		}
+		var err error
+		data, err = ec.Decode(st.shards)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(data) < int(st.hdr.BlockLen) {
+		return nil, fmt.Errorf("recovered %d bytes, want %d", len(data), st.hdr.BlockLen)
+	}
+	return data[:st.hdr.BlockLen], nil
+}
+
+// Forget drops all state for a chunk once the caller has stored it.
+func (a *FECAssembler) Forget(sessionID [16]byte, chunkID uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key := range a.blocks {
+		if key.session == sessionID && key.chunk == chunkID {
+			delete(a.blocks, key)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// sendFEC sends data through a UDPSender with the given telemetry and returns
+// the packets it put on the wire.
+func sendFEC(t *testing.T, tel *telemetry.TelemetryCollector, data []byte) []*protocol.Packet {
+	t.Helper()
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_ = ln.SetReadBuffer(8 << 20)
+
+	s, err := NewUDPSender(UDPSenderConfig{
+		RemoteAddr: ln.LocalAddr().String(),
+		DataShards: 4,
+		Telemetry:  tel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var sid [16]byte
+	sid[0] = 1
+	if err := s.SendChunkFEC(context.Background(), sid, 7, data, 0); err != nil {
+		t.Fatalf("SendChunkFEC: %v", err)
+	}
+
+	var pkts []*protocol.Packet
+	buf := make([]byte, 70*1024)
+	for {
+		_ = ln.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := ln.Read(buf)
+		if err != nil {
+			break
+		}
+		p, err := protocol.DeserializePacket(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts = append(pkts, p)
+	}
+	return pkts
+}
+
+func reassemble(t *testing.T, pkts []*protocol.Packet, size int) []byte {
+	t.Helper()
+	a := NewFECAssembler()
+	out := make([]byte, size)
+	got := 0
+	for _, p := range pkts {
+		blk, err := a.AddPacket(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk != nil {
+			copy(out[blk.Offset:], blk.Data)
+			got += len(blk.Data)
+		}
+	}
+	if got != size {
+		t.Fatalf("recovered %d bytes, want %d", got, size)
+	}
+	return out
+}
+
+func TestSendChunkFECCleanLinkSendsNoParity(t *testing.T) {
+	data := make([]byte, 3*protocol.MaxShardSize+100)
+	_, _ = rand.Read(data)
+
+	pkts := sendFEC(t, telemetry.NewTelemetryCollector(), data)
+	if len(pkts) != 4 {
+		t.Fatalf("got %d packets, want 4 data shards only", len(pkts))
+	}
+	if out := reassemble(t, pkts, len(data)); !bytes.Equal(out, data) {
+		t.Fatal("reassembled data mismatch")
+	}
+}
+
+func TestSendChunkFECRecoversLostShards(t *testing.T) {
+	data := make([]byte, 6*protocol.MaxShardSize+12345)
+	_, _ = rand.Read(data)
+
+	tel := telemetry.NewTelemetryCollector()
+	tel.RecordPacketsSent(100)
+	tel.RecordPacketsLost(10)
+
+	pkts := sendFEC(t, tel, data)
+
+	// Drop the first shard of every block.
+	var kept []*protocol.Packet
+	parity := -1
+	for _, p := range pkts {
+		hdr, _, err := protocol.DecodeShard(p.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parity = int(hdr.ParityShards)
+		if hdr.Index == 0 {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if parity < 1 {
+		t.Fatalf("expected parity shards at 10%% loss, got %d", parity)
+	}
+	if out := reassemble(t, kept, len(data)); !bytes.Equal(out, data) {
+		t.Fatal("reassembled data mismatch")
+	}
+}
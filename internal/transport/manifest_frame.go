@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// ManifestFrameID identifies the control frame carrying a directory
+// manifest. It follows the file metadata frame on protocol v5 and later.
+const ManifestFrameID = "__manifest__"
+
+// SendManifest sends m as a zstd-compressed manifest control frame.
+func (s *TCPSender) SendManifest(ctx context.Context, conn net.Conn, m *models.Manifest) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	comp, err := crypto.CompressChunk(payload)
+	if err != nil {
+		return fmt.Errorf("compress manifest: %w", err)
+	}
+	meta := &models.ChunkMetadata{
+		ID:          ManifestFrameID,
+		SessionID:   s.SessionID,
+		Size:        int64(len(payload)),
+		Status:      models.ChunkStatusPending,
+		Compression: models.CompressionZstd,
+	}
+	return s.Send(ctx, conn, comp, meta)
+}
+
+// DecodeManifest parses the payload of a manifest control frame.
+func DecodeManifest(payload []byte) (*models.Manifest, error) {
+	var m models.Manifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	// The root becomes a directory name on the receiver, so it must be a
+	// single path element.
+	if m.Root == "" || m.Root == "." || m.Root == ".." || strings.ContainsAny(m.Root, `/\`) {
+		return nil, fmt.Errorf("decode manifest: invalid root %q", m.Root)
+	}
+	return &m, nil
+}
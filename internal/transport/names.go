@@ -0,0 +1,115 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// maxNameLen is the longest file name accepted from a sender, in bytes,
+// as most file systems allow no more.
+const maxNameLen = 255
+
+// ErrInvalidName is returned by SafeName for names that cannot be written.
+var ErrInvalidName = errors.New("invalid file name")
+
+// ErrOutputExists is returned by PlaceOutput when the destination exists
+// and the policy is ExistsFail.
+var ErrOutputExists = errors.New("output already exists")
+
+// SafeName checks a file name sent by a peer and re-roots it: any directory
+// part, with either kind of slash, is dropped so the file can only land in
+// the output directory. Empty names, "." and "..", names with NUL or other
+// control characters and names over 255 bytes are refused.
+func SafeName(name string) (string, error) {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	switch {
+	case name == "" || base == "." || base == ".." || base == "/":
+		return "", fmt.Errorf("%w %q", ErrInvalidName, name)
+	case len(base) > maxNameLen:
+		return "", fmt.Errorf("%w: %d bytes, limit is %d", ErrInvalidName, len(base), maxNameLen)
+	}
+	for _, r := range base {
+		if r < 0x20 || r == 0x7f {
+			return "", fmt.Errorf("%w %q: control character", ErrInvalidName, name)
+		}
+	}
+	return base, nil
+}
+
+// rerooted returns the name a session's file is written under in the
+// output directory. Names refused by SafeName are not expected here, as
+// receivers check them on arrival, but are replaced rather than trusted.
+func rerooted(name string) string {
+	safe, err := SafeName(name)
+	if err != nil {
+		return "unnamed"
+	}
+	return safe
+}
+
+// ExistsPolicy decides what happens when a session's destination already
+// exists.
+type ExistsPolicy string
+
+const (
+	// ExistsRename writes the session beside the existing file, as
+	// "report.1.pdf", "report.2.pdf" and so on. It is the default.
+	ExistsRename ExistsPolicy = "rename"
+	// ExistsOverwrite replaces the existing file.
+	ExistsOverwrite ExistsPolicy = "overwrite"
+	// ExistsFail refuses the session.
+	ExistsFail ExistsPolicy = "fail"
+)
+
+// ParseExistsPolicy parses the name of a policy.
+func ParseExistsPolicy(s string) (ExistsPolicy, error) {
+	switch p := ExistsPolicy(s); p {
+	case ExistsRename, ExistsOverwrite, ExistsFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q for existing files (want rename, overwrite or fail)", s)
+}
+
+// PlaceOutput applies r.OnExists to the destination of session before
+// anything is written there, recording a renamed destination in
+// session.OutputPath. replace skips the policy for sessions meant to update
+// the existing file, such as delta transfers.
+func (r *TCPReceiver) PlaceOutput(session *models.TransferSession, replace bool) error {
+	if replace || r.OnExists == ExistsOverwrite {
+		return nil
+	}
+	dest := r.Destination(session)
+	exists, err := pathExists(dest)
+	if err != nil || !exists {
+		return err
+	}
+	if r.OnExists == ExistsFail {
+		return fmt.Errorf("%w: %s", ErrOutputExists, dest)
+	}
+	for i := 1; ; i++ {
+		alt := DistinctPath(dest, strconv.Itoa(i))
+		if exists, err := pathExists(alt); err != nil || !exists {
+			if err == nil {
+				session.OutputPath = alt
+			}
+			return err
+		}
+	}
+}
+
+func pathExists(p string) (bool, error) {
+	_, err := os.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check output %s: %w", p, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSafeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.pdf":             "report.pdf",
+		"../../etc/cron.d/x":     "x",
+		"/etc/passwd":            "passwd",
+		`..\..\windows\win.ini`:  "win.ini",
+		"dir/sub/":               "sub",
+		"héllo wörld.txt":        "héllo wörld.txt",
+		strings.Repeat("a", 255): strings.Repeat("a", 255),
+	} {
+		got, err := SafeName(name)
+		if err != nil || got != want {
+			t.Errorf("SafeName(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../..", "/", "a\x00b", "new\nline", strings.Repeat("a", 256)} {
+		if got, err := SafeName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("SafeName(%q) = %q, %v; want ErrInvalidName", name, got, err)
+		}
+	}
+}
+
+func TestDestinationIsRerooted(t *testing.T) {
+	r := &TCPReceiver{OutputDir: "/data"}
+	sess := &models.TransferSession{File: models.FileMetadata{Name: "../../etc/cron.d/x"}}
+	if got := r.Destination(sess); got != filepath.Join("/data", "x") {
+		t.Fatalf("Destination = %q", got)
+	}
+}
+
+func TestPlaceOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"report.pdf", "report.1.pdf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newSession := func(name string) *models.TransferSession {
+		return &models.TransferSession{ID: "s", File: models.FileMetadata{Name: name}}
+	}
+
+	r := &TCPReceiver{OutputDir: dir}
+	sess := newSession("report.pdf")
+	if err := r.PlaceOutput(sess, false); err != nil {
+		t.Fatalf("PlaceOutput: %v", err)
+	}
+	if want := filepath.Join(dir, "report.2.pdf"); sess.OutputPath != want {
+		t.Fatalf("renamed to %q, want %q", sess.OutputPath, want)
+	}
+	fresh := newSession("new.pdf")
+	if err := r.PlaceOutput(fresh, false); err != nil || fresh.OutputPath != "" {
+		t.Fatalf("new file placed at %q, %v", fresh.OutputPath, err)
+	}
+	// Delta transfers replace the file they update.
+	delta := newSession("report.pdf")
+	if err := r.PlaceOutput(delta, true); err != nil || delta.OutputPath != "" {
+		t.Fatalf("delta session placed at %q, %v", delta.OutputPath, err)
+	}
+
+	r.OnExists = ExistsOverwrite
+	sess = newSession("report.pdf")
+	if err := r.PlaceOutput(sess, false); err != nil || sess.OutputPath != "" {
+		t.Fatalf("overwrite placed at %q, %v", sess.OutputPath, err)
+	}
+
+	r.OnExists = ExistsFail
+	if err := r.PlaceOutput(newSession("report.pdf"), false); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("PlaceOutput with fail policy: %v", err)
+	}
+
+	if _, err := ParseExistsPolicy("clobber"); err == nil {
+		t.Fatal("ParseExistsPolicy accepted an unknown policy")
+	}
+}
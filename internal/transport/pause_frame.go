@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// PauseFrameID identifies control frames announcing that the sender has
+// paused or resumed a transfer (protocol v7 and later). While paused, the
+// sender repeats the frame periodically so the connection does not hit the
+// receiver's idle timeout.
+const PauseFrameID = "__pause__"
+
+// PauseState is the payload of a pause frame.
+type PauseState struct {
+	Paused bool `json:"paused"`
+}
+
+// SendPause sends a pause frame on conn.
+func (s *TCPSender) SendPause(ctx context.Context, conn net.Conn, paused bool) error {
+	return s.sendControl(ctx, conn, PauseFrameID, PauseState{Paused: paused})
+}
+
+// DecodePause parses the payload of a pause frame.
+func DecodePause(payload []byte) (PauseState, error) {
+	var ps PauseState
+	if err := json.Unmarshal(payload, &ps); err != nil {
+		return PauseState{}, fmt.Errorf("decode pause frame: %w", err)
+	}
+	return ps, nil
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPauseFrameRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_ = NewTCPSender().SendPause(context.Background(), client, true)
+	}()
+
+	meta, data, err := (&TCPReceiver{}).ReceiveStream(context.Background(), server)
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	if meta.ID != PauseFrameID {
+		t.Fatalf("frame ID = %q, want %q", meta.ID, PauseFrameID)
+	}
+	payload, _ := io.ReadAll(data)
+	ps, err := DecodePause(payload)
+	if err != nil || !ps.Paused {
+		t.Fatalf("DecodePause = %+v, %v", ps, err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package transport
+
+import "os"
+
+// preallocate sizes f to size bytes. Platforms without fallocate get a
+// (possibly sparse) truncate.
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return f.Truncate(size)
+}
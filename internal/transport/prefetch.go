@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// PrefetchFrameID identifies control frames sent by the receiver back to the
+// sender naming a byte range it needs next (protocol v8 and later), so a
+// consumer reading the output while it arrives, such as a video player
+// seeking or a database paging on demand, is not stuck behind the rest of
+// the file.
+const PrefetchFrameID = "__prefetch__"
+
+// PrefetchHint is the payload of a prefetch frame.
+type PrefetchHint struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// validate rejects ranges that cannot name any data.
+func (h PrefetchHint) validate() error {
+	if h.Offset < 0 || h.Length <= 0 {
+		return fmt.Errorf("invalid prefetch range offset %d length %d", h.Offset, h.Length)
+	}
+	return nil
+}
+
+// SendPrefetch sends a prefetch frame on conn. Like SendRateControl it is
+// used by receivers on the connection a session arrives on and must not be
+// interleaved with other writes to conn.
+func (s *TCPSender) SendPrefetch(ctx context.Context, conn net.Conn, h PrefetchHint) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	return s.sendControl(ctx, conn, PrefetchFrameID, h)
+}
+
+// PrefetchQueue hands out the chunks of a transfer in offset order, or by
+// priority once prioritized, except that chunks named by a prefetch hint
+// jump ahead of those not yet handed out. It is safe for concurrent use.
+type PrefetchQueue struct {
+	mu      sync.Mutex
+	chunks  []*models.ChunkMetadata
+	pending []int // indexes into chunks, in the order they will be sent
+}
+
+// NewPrefetchQueue returns a queue over chunks, initially in their given
+// order.
+func NewPrefetchQueue(chunks []*models.ChunkMetadata) *PrefetchQueue {
+	q := &PrefetchQueue{chunks: chunks, pending: make([]int, len(chunks))}
+	for i := range q.pending {
+		q.pending[i] = i
+	}
+	return q
+}
+
+// Next returns the index of the next chunk to send, or false once every
+// chunk has been handed out.
+func (q *PrefetchQueue) Next() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return 0, false
+	}
+	i := q.pending[0]
+	q.pending = q.pending[1:]
+	return i, true
+}
+
+// Hint moves the chunks overlapping h that have not been handed out yet to
+// the front of the queue, in offset order, and returns how many moved. A
+// later hint takes precedence over an earlier one.
+func (q *PrefetchQueue) Hint(h PrefetchHint) int {
+	if h.validate() != nil {
+		return 0
+	}
+	end := h.Offset + h.Length
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var hit, rest []int
+	for _, i := range q.pending {
+		c := q.chunks[i]
+		if c.Offset < end && c.Offset+c.Size > h.Offset {
+			hit = append(hit, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	sort.SliceStable(hit, func(a, b int) bool { return q.chunks[hit[a]].Offset < q.chunks[hit[b]].Offset })
+	q.pending = append(hit, rest...)
+	return len(hit)
+}
+
+// decodePrefetch parses the payload of a prefetch frame.
+func decodePrefetch(payload []byte) (PrefetchHint, error) {
+	var h PrefetchHint
+	if err := json.Unmarshal(payload, &h); err != nil {
+		return PrefetchHint{}, fmt.Errorf("invalid prefetch frame: %q", payload)
+	}
+	if err := h.validate(); err != nil {
+		return PrefetchHint{}, err
+	}
+	return h, nil
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestPrefetchQueueHint(t *testing.T) {
+	var chunks []*models.ChunkMetadata
+	for i := 0; i < 6; i++ {
+		chunks = append(chunks, &models.ChunkMetadata{Offset: int64(i) * 100, Size: 100})
+	}
+	q := NewPrefetchQueue(chunks)
+	if i, _ := q.Next(); i != 0 {
+		t.Fatalf("first chunk = %d, want 0", i)
+	}
+	// Bytes 350-449 span chunks 3 and 4; chunk 0 was already handed out.
+	if moved := q.Hint(PrefetchHint{Offset: 350, Length: 100}); moved != 2 {
+		t.Fatalf("moved %d chunks, want 2", moved)
+	}
+	if moved := q.Hint(PrefetchHint{Offset: 0, Length: 50}); moved != 0 {
+		t.Fatalf("moved %d already sent chunks", moved)
+	}
+	var order []int
+	for {
+		i, ok := q.Next()
+		if !ok {
+			break
+		}
+		order = append(order, i)
+	}
+	want := []int{3, 4, 1, 2, 5}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for k := range want {
+		if order[k] != want[k] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPrefetchRoundTrip(t *testing.T) {
+	senderSide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+
+	got := make(chan PrefetchHint, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewTCPSender().ReadControlFrames(context.Background(), senderSide, ControlHandlers{
+			Prefetch: func(h PrefetchHint) { got <- h },
+		})
+	}()
+
+	s := NewTCPSender()
+	// Rate frames without a handler are skipped.
+	if err := s.SendRateControl(context.Background(), receiverSide, RateControl{BytesPerSec: 1}); err != nil {
+		t.Fatalf("SendRateControl: %v", err)
+	}
+	if err := s.SendPrefetch(context.Background(), receiverSide, PrefetchHint{Offset: 4096, Length: 1 << 20}); err != nil {
+		t.Fatalf("SendPrefetch: %v", err)
+	}
+	if h := <-got; h.Offset != 4096 || h.Length != 1<<20 {
+		t.Fatalf("hint = %+v", h)
+	}
+	if err := s.SendPrefetch(context.Background(), receiverSide, PrefetchHint{Offset: 0}); err == nil {
+		t.Fatal("expected error for an empty range")
+	}
+
+	receiverSide.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("ReadControlFrames after close: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"sort"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// PriorityPolicy ranks the chunks of a transfer for sending. Chunks with a
+// lower priority are sent first; chunks of equal priority keep their order.
+type PriorityPolicy interface {
+	Priority(meta *models.ChunkMetadata) int
+}
+
+// PriorityFunc adapts a function to a PriorityPolicy.
+type PriorityFunc func(meta *models.ChunkMetadata) int
+
+// Priority returns f(meta).
+func (f PriorityFunc) Priority(meta *models.ChunkMetadata) int { return f(meta) }
+
+// OffsetOrder gives every chunk the same priority, so chunks go out in
+// offset order.
+var OffsetOrder PriorityPolicy = PriorityFunc(func(*models.ChunkMetadata) int { return 0 })
+
+// EdgesFirst sends the chunks holding the first Head and last Tail bytes of
+// each of Files before the rest. Media containers keep their headers and
+// indexes there, such as an MP4's moov atom, so a consumer reading the
+// output while it arrives can start playing or seeking early.
+type EdgesFirst struct {
+	// Files are the files of the session, located by their Offset in the
+	// session stream.
+	Files []models.FileMetadata
+	Head  int64
+	Tail  int64
+}
+
+// Priority returns 0 for chunks overlapping the head or tail of a file and
+// 1 for the others.
+func (p EdgesFirst) Priority(meta *models.ChunkMetadata) int {
+	end := meta.Offset + meta.Size
+	for _, f := range p.Files {
+		if f.Size == 0 || meta.Offset >= f.Offset+f.Size || end <= f.Offset {
+			continue
+		}
+		if meta.Offset < f.Offset+p.Head || end > f.Offset+f.Size-p.Tail {
+			return 0
+		}
+	}
+	return 1
+}
+
+// RetriesFirst raises chunks that failed on an earlier attempt by one
+// priority above what Policy gives them, so a retry does not wait behind
+// chunks sent for the first time.
+type RetriesFirst struct {
+	Policy PriorityPolicy
+}
+
+// Priority returns the priority Policy gives meta, one lower if meta was
+// retried.
+func (p RetriesFirst) Priority(meta *models.ChunkMetadata) int {
+	prio := p.Policy.Priority(meta)
+	if meta.RetryCount > 0 {
+		prio--
+	}
+	return prio
+}
+
+// Prioritize records the priority p gives each chunk not yet handed out in
+// its Priority field and reorders the queue by it, keeping the current
+// order among chunks of equal priority. Prefetch hints received later still
+// move their chunks ahead of all others.
+func (q *PrefetchQueue) Prioritize(p PriorityPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, i := range q.pending {
+		q.chunks[i].Priority = p.Priority(q.chunks[i])
+	}
+	sort.SliceStable(q.pending, func(a, b int) bool {
+		return q.chunks[q.pending[a]].Priority < q.chunks[q.pending[b]].Priority
+	})
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// drain returns the order in which q hands out its chunks.
+func drain(q *PrefetchQueue) []int {
+	var order []int
+	for {
+		i, ok := q.Next()
+		if !ok {
+			return order
+		}
+		order = append(order, i)
+	}
+}
+
+func TestEdgesFirst(t *testing.T) {
+	var chunks []*models.ChunkMetadata
+	for i := 0; i < 10; i++ {
+		chunks = append(chunks, &models.ChunkMetadata{Offset: int64(i) * 100, Size: 100})
+	}
+	// Two files of 500 bytes; the first 150 and last 50 bytes of each come
+	// first.
+	p := EdgesFirst{
+		Files: []models.FileMetadata{{Offset: 0, Size: 500}, {Offset: 500, Size: 500}},
+		Head:  150,
+		Tail:  50,
+	}
+	q := NewPrefetchQueue(chunks)
+	q.Prioritize(p)
+	if got, want := drain(q), []int{0, 1, 4, 5, 6, 9, 2, 3, 7, 8}; !slices.Equal(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+	if chunks[4].Priority != 0 || chunks[2].Priority != 1 {
+		t.Fatalf("recorded priorities %d and %d", chunks[4].Priority, chunks[2].Priority)
+	}
+}
+
+func TestRetriesFirst(t *testing.T) {
+	var chunks []*models.ChunkMetadata
+	for i := 0; i < 5; i++ {
+		chunks = append(chunks, &models.ChunkMetadata{Offset: int64(i) * 100, Size: 100})
+	}
+	chunks[3].RetryCount = 1
+	q := NewPrefetchQueue(chunks)
+	q.Prioritize(RetriesFirst{Policy: OffsetOrder})
+	if got, want := drain(q), []int{3, 0, 1, 2, 4}; !slices.Equal(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPrioritizeThenHint(t *testing.T) {
+	var chunks []*models.ChunkMetadata
+	for i := 0; i < 6; i++ {
+		chunks = append(chunks, &models.ChunkMetadata{Offset: int64(i) * 100, Size: 100})
+	}
+	q := NewPrefetchQueue(chunks)
+	if i, _ := q.Next(); i != 0 {
+		t.Fatalf("first chunk = %d", i)
+	}
+	// Only chunks still pending are ranked; a hint then overrides the ranking.
+	q.Prioritize(PriorityFunc(func(m *models.ChunkMetadata) int { return -int(m.Offset) }))
+	q.Hint(PrefetchHint{Offset: 150, Length: 100})
+	if got, want := drain(q), []int{1, 2, 5, 4, 3}; !slices.Equal(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Hole punching lets a sender reach a receiver that sits behind a NAT.
+// Both learn their public UDP endpoint from a reflector, swap it with their
+// local addresses through the orchestrator, then send punch datagrams to
+// each other's candidates at once: each side's outgoing datagrams open its
+// NAT to the other's, so once both have sent, the other's get through.
+//
+// The datagrams are
+//
+//	punchMagic kind payload
+//
+// where payload is the peer address seen by the reflector in a reflect
+// reply and the rendezvous ID in a punch or punch acknowledgement.
+const punchMagic = "TSPUNCH1"
+
+const (
+	punchReflect    byte = 'r' // to the reflector: which address do you see?
+	punchReflection byte = 'R' // from the reflector: this one
+	punchProbe      byte = 'p'
+	punchAck        byte = 'a'
+)
+
+// PunchInterval is how often punch datagrams are sent to every candidate
+// while punching.
+const PunchInterval = 100 * time.Millisecond
+
+// ErrPunchFailed is returned by Punch when no candidate answered in time.
+var ErrPunchFailed = errors.New("hole punching failed")
+
+func punchPacket(kind byte, payload string) []byte {
+	return append(append([]byte(punchMagic), kind), payload...)
+}
+
+// parsePunch splits a hole punching datagram, reporting false for other
+// traffic.
+func parsePunch(b []byte) (kind byte, payload string, ok bool) {
+	if len(b) < len(punchMagic)+1 || string(b[:len(punchMagic)]) != punchMagic {
+		return 0, "", false
+	}
+	return b[len(punchMagic)], string(b[len(punchMagic)+1:]), true
+}
+
+// ServeReflector answers reflect requests on conn with the address each
+// came from, until conn is closed.
+func ServeReflector(conn *net.UDPConn) error {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if kind, _, ok := parsePunch(buf[:n]); ok && kind == punchReflect {
+			if _, err := conn.WriteToUDP(punchPacket(punchReflection, from.String()), from); err != nil {
+				log.Printf("reflector reply to %s: %v", from, err)
+			}
+		}
+	}
+}
+
+// DiscoverEndpoint asks the reflector at addr which address datagrams from
+// conn arrive from: conn's public endpoint if it is behind a NAT. Requests
+// are repeated every PunchInterval until one is answered or ctx is done.
+func DiscoverEndpoint(ctx context.Context, conn *net.UDPConn, addr string) (*net.UDPAddr, error) {
+	reflector, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 512)
+	for {
+		if _, err := conn.WriteToUDP(punchPacket(punchReflect, ""), reflector); err != nil {
+			return nil, fmt.Errorf("reflector %s: %w", addr, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(PunchInterval))
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break // deadline: ask again
+			}
+			if kind, payload, ok := parsePunch(buf[:n]); ok && kind == punchReflection {
+				return net.ResolveUDPAddr("udp", payload)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("reflector %s: %w", addr, err)
+		}
+	}
+}
+
+// LocalCandidates returns the addresses conn can be reached at on the
+// networks this host is attached to, for a peer on the same network.
+func LocalCandidates(conn *net.UDPConn) []string {
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() || ipnet.IP.IsLinkLocalMulticast() {
+			continue
+		}
+		out = append(out, net.JoinHostPort(ipnet.IP.String(), strconv.Itoa(port)))
+	}
+	return out
+}
+
+// Punch sends punch datagrams for rendezvous id from conn to every one of
+// the peer's candidates every PunchInterval and answers the peer's, until
+// a candidate acknowledges one. It returns the address that did, which
+// reaches the peer through any NATs between, or ErrPunchFailed when ctx
+// is done first. Punches from the peer are acknowledged, so the peer
+// finishes as soon as one of them gets through.
+func Punch(ctx context.Context, conn *net.UDPConn, id string, candidates []string) (*net.UDPAddr, error) {
+	var peers []*net.UDPAddr
+	for _, c := range candidates {
+		if a, err := net.ResolveUDPAddr("udp", c); err == nil {
+			peers = append(peers, a)
+		}
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("%w: no usable candidates in %v", ErrPunchFailed, candidates)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 512)
+	for {
+		for _, p := range peers {
+			conn.WriteToUDP(punchPacket(punchProbe, id), p) // unreachable candidates are expected
+		}
+		conn.SetReadDeadline(time.Now().Add(PunchInterval))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			kind, payload, ok := parsePunch(buf[:n])
+			if !ok || payload != id {
+				continue
+			}
+			switch kind {
+			case punchProbe:
+				conn.WriteToUDP(punchPacket(punchAck, id), from)
+			case punchAck:
+				// Acknowledge the acknowledgement, in case our punches
+				// have not reached the peer yet: this tells it the path
+				// works both ways.
+				conn.WriteToUDP(punchPacket(punchAck, id), from)
+				return from, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: no answer from %v", ErrPunchFailed, candidates)
+		}
+	}
+}
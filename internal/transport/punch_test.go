@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLoopbackUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDiscoverEndpoint(t *testing.T) {
+	reflector := listenLoopbackUDP(t)
+	go ServeReflector(reflector)
+
+	conn := listenLoopbackUDP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := DiscoverEndpoint(ctx, conn, reflector.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("DiscoverEndpoint: %v", err)
+	}
+	if got.String() != conn.LocalAddr().String() {
+		t.Errorf("endpoint %s, want %s", got, conn.LocalAddr())
+	}
+}
+
+func TestPunch(t *testing.T) {
+	a, b := listenLoopbackUDP(t), listenLoopbackUDP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		addr *net.UDPAddr
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// A dead candidate first, as a stale public endpoint would be.
+		addr, err := Punch(ctx, b, "rv-1", []string{"127.0.0.1:1", a.LocalAddr().String()})
+		done <- result{addr, err}
+	}()
+	got, err := Punch(ctx, a, "rv-1", []string{b.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("Punch: %v", err)
+	}
+	if got.String() != b.LocalAddr().String() {
+		t.Errorf("punched to %s, want %s", got, b.LocalAddr())
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("peer Punch: %v", r.err)
+	}
+	if r.addr.String() != a.LocalAddr().String() {
+		t.Errorf("peer punched to %s, want %s", r.addr, a.LocalAddr())
+	}
+}
+
+func TestPunchIgnoresOtherRendezvous(t *testing.T) {
+	a, b := listenLoopbackUDP(t), listenLoopbackUDP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	go Punch(ctx, b, "rv-other", []string{a.LocalAddr().String()})
+	if _, err := Punch(ctx, a, "rv-1", []string{b.LocalAddr().String()}); !errors.Is(err, ErrPunchFailed) {
+		t.Fatalf("Punch = %v, want ErrPunchFailed", err)
+	}
+}
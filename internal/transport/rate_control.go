@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// RateControlFrameID identifies control frames sent by the receiver back to
+// the sender to change its send rate mid-transfer (protocol v6 and later).
+const RateControlFrameID = "__ratecontrol__"
+
+// RateControl is the payload of a rate control frame.
+type RateControl struct {
+	// BytesPerSec is the new send rate; zero removes any limit.
+	BytesPerSec float64 `json:"bytes_per_sec"`
+}
+
+// SendRateControl sends rc on conn. Receivers use it on the connection a
+// session arrives on; it must not be interleaved with other writes to conn.
+func (s *TCPSender) SendRateControl(ctx context.Context, conn net.Conn, rc RateControl) error {
+	if rc.BytesPerSec < 0 {
+		return fmt.Errorf("invalid rate %v", rc.BytesPerSec)
+	}
+	return s.sendControl(ctx, conn, RateControlFrameID, rc)
+}
+
+// sendControl sends v as the uncompressed JSON payload of control frame id.
+func (s *TCPSender) sendControl(ctx context.Context, conn net.Conn, id string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s frame: %w", id, err)
+	}
+	meta := &models.ChunkMetadata{
+		ID:          id,
+		SessionID:   s.SessionID,
+		Size:        int64(len(payload)),
+		Status:      models.ChunkStatusPending,
+		Compression: models.CompressionNone,
+	}
+	return s.Send(ctx, conn, payload, meta)
+}
+
+// ReadControl reads control frames sent back by the receiver on conn and
+// passes rate changes to apply, until conn is closed. Unknown frames are
+// skipped. It must only run once any synchronous exchange such as SyncClock
+// has finished, and is typically run in its own goroutine.
+func (s *TCPSender) ReadControl(ctx context.Context, conn net.Conn, apply func(RateControl)) error {
+	return s.ReadControlFrames(ctx, conn, ControlHandlers{Rate: apply})
+}
+
+// ControlHandlers receive the control frames a receiver sends back to the
+// sender. A nil handler skips its frames.
+type ControlHandlers struct {
+	Rate     func(RateControl)
+	Prefetch func(PrefetchHint)
+	Receipt  func(ReceiptReply)
+}
+
+// ReadControlFrames is ReadControl for every kind of control frame the
+// sender understands.
+func (s *TCPSender) ReadControlFrames(ctx context.Context, conn net.Conn, h ControlHandlers) error {
+	recv := &TCPReceiver{}
+	for {
+		meta, data, err := recv.ReceiveStream(ctx, conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		payload, err := io.ReadAll(data)
+		if err != nil {
+			return fmt.Errorf("read control frame: %w", err)
+		}
+		switch {
+		case meta.ID == RateControlFrameID && h.Rate != nil:
+			var rc RateControl
+			if err := json.Unmarshal(payload, &rc); err != nil || rc.BytesPerSec < 0 {
+				return fmt.Errorf("invalid rate control frame: %q", payload)
+			}
+			h.Rate(rc)
+		case meta.ID == PrefetchFrameID && h.Prefetch != nil:
+			hint, err := decodePrefetch(payload)
+			if err != nil {
+				return err
+			}
+			h.Prefetch(hint)
+		case meta.ID == ReceiptFrameID && h.Receipt != nil:
+			reply, err := decodeReceipt(payload)
+			if err != nil {
+				return err
+			}
+			h.Receipt(reply)
+		}
+	}
+}
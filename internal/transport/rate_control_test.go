@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestRateControlRoundTrip(t *testing.T) {
+	senderSide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+
+	got := make(chan RateControl, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewTCPSender().ReadControl(context.Background(), senderSide, func(rc RateControl) { got <- rc })
+	}()
+
+	s := NewTCPSender()
+	if err := s.SendRateControl(context.Background(), receiverSide, RateControl{BytesPerSec: 1 << 20}); err != nil {
+		t.Fatalf("SendRateControl: %v", err)
+	}
+	// Unrelated frames on the return path are skipped.
+	if err := sendTimeSync(context.Background(), s, receiverSide, timeSyncMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendRateControl(context.Background(), receiverSide, RateControl{}); err != nil {
+		t.Fatalf("SendRateControl: %v", err)
+	}
+	if rc := <-got; rc.BytesPerSec != 1<<20 {
+		t.Fatalf("first rate = %v", rc.BytesPerSec)
+	}
+	if rc := <-got; rc.BytesPerSec != 0 {
+		t.Fatalf("second rate = %v, want 0 (unlimited)", rc.BytesPerSec)
+	}
+	if err := s.SendRateControl(context.Background(), receiverSide, RateControl{BytesPerSec: -1}); err == nil {
+		t.Fatal("expected error for a negative rate")
+	}
+
+	receiverSide.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("ReadControl after close: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+)
+
+// Receipt frames (protocol v10 and later). A sender that wants proof of
+// delivery sends a receipt request after its last chunk and closes its side
+// of the connection; the receiver answers with a receipt frame once the
+// file is verified, or with the reason it could not be.
+const (
+	ReceiptRequestFrameID = "__receiptreq__"
+	ReceiptFrameID        = "__receipt__"
+)
+
+// ReceiptRequest is the payload of a receipt request frame.
+type ReceiptRequest struct {
+	// SessionID is the sender's session, recorded in the receipt.
+	SessionID string `json:"session_id"`
+}
+
+// ReceiptReply is the payload of a receipt frame: a signed receipt, or the
+// error that kept the receiver from issuing one.
+type ReceiptReply struct {
+	Receipt *receipt.Receipt `json:"receipt,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// RequestReceipt sends a receipt request on conn.
+func (s *TCPSender) RequestReceipt(ctx context.Context, conn net.Conn, req ReceiptRequest) error {
+	return s.sendControl(ctx, conn, ReceiptRequestFrameID, req)
+}
+
+// SendReceipt sends a receipt frame on conn. Receivers use it on the
+// connection a session arrived on; it must not be interleaved with other
+// writes to conn.
+func (s *TCPSender) SendReceipt(ctx context.Context, conn net.Conn, reply ReceiptReply) error {
+	return s.sendControl(ctx, conn, ReceiptFrameID, reply)
+}
+
+// DecodeReceiptRequest parses the payload of a receipt request frame.
+func DecodeReceiptRequest(payload []byte) (ReceiptRequest, error) {
+	var req ReceiptRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return ReceiptRequest{}, fmt.Errorf("decode receipt request frame: %w", err)
+	}
+	return req, nil
+}
+
+// decodeReceipt parses the payload of a receipt frame.
+func decodeReceipt(payload []byte) (ReceiptReply, error) {
+	var reply ReceiptReply
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return ReceiptReply{}, fmt.Errorf("decode receipt frame: %w", err)
+	}
+	if reply.Receipt == nil && reply.Error == "" {
+		return ReceiptReply{}, fmt.Errorf("empty receipt frame")
+	}
+	return reply, nil
+}
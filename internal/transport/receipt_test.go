@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+)
+
+func TestReceiptRoundTrip(t *testing.T) {
+	senderSide, receiverSide := net.Pipe()
+	defer senderSide.Close()
+	defer receiverSide.Close()
+	s := NewTCPSender()
+
+	// The request reaches the receiver as a frame it decodes itself.
+	go s.RequestReceipt(context.Background(), senderSide, ReceiptRequest{SessionID: "s-1"})
+	meta, data, err := (&TCPReceiver{}).ReceiveStream(context.Background(), receiverSide)
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	payload, _ := io.ReadAll(data)
+	if meta.ID != ReceiptRequestFrameID {
+		t.Fatalf("frame ID = %q", meta.ID)
+	}
+	req, err := DecodeReceiptRequest(payload)
+	if err != nil || req.SessionID != "s-1" {
+		t.Fatalf("request = %+v, %v", req, err)
+	}
+
+	got := make(chan ReceiptReply, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadControlFrames(context.Background(), senderSide, ControlHandlers{
+			Receipt: func(r ReceiptReply) { got <- r },
+		})
+	}()
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	r := &receipt.Receipt{SessionID: "r-1", SenderSessionID: req.SessionID, FileHash: "abc", Size: 3}
+	if err := r.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendReceipt(context.Background(), receiverSide, ReceiptReply{Receipt: r}); err != nil {
+		t.Fatalf("SendReceipt: %v", err)
+	}
+	if reply := <-got; reply.Receipt == nil || reply.Receipt.Verify(key.Public().(ed25519.PublicKey)) != nil {
+		t.Fatalf("reply = %+v", reply)
+	}
+	if err := s.SendReceipt(context.Background(), receiverSide, ReceiptReply{Error: "hash mismatch"}); err != nil {
+		t.Fatalf("SendReceipt: %v", err)
+	}
+	if reply := <-got; reply.Receipt != nil || reply.Error != "hash mismatch" {
+		t.Fatalf("reply = %+v", reply)
+	}
+
+	receiverSide.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("ReadControlFrames after close: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"time"
+)
+
+// DefaultReplayWindow is how many sequence numbers behind the highest one
+// seen a UDPReceiver still tracks per session.
+const DefaultReplayWindow = 4096
+
+// replayIdle is how long a session's replay window is kept without
+// packets; a later packet for it starts a new window.
+const replayIdle = 10 * time.Minute
+
+// replayWindow is a sliding window over a session's sequence numbers, as
+// used by IPsec (RFC 4303 section 3.4.3): each number is accepted once, and
+// numbers that fell out of the window are refused as stale.
+type replayWindow struct {
+	top      uint32   // highest sequence number accepted
+	bits     []uint64 // bit i is set once top-i was accepted
+	lastSeen time.Time
+}
+
+func newReplayWindow(size int) *replayWindow {
+	return &replayWindow{bits: make([]uint64, (size+63)/64)}
+}
+
+func (w *replayWindow) size() uint32 {
+	return uint32(len(w.bits) * 64)
+}
+
+func (w *replayWindow) has(d uint32) bool {
+	return w.bits[d/64]&(1<<(d%64)) != 0
+}
+
+func (w *replayWindow) set(d uint32) {
+	w.bits[d/64] |= 1 << (d % 64)
+}
+
+// advance moves the window n sequence numbers forward.
+func (w *replayWindow) advance(n uint32) {
+	if n >= w.size() {
+		clear(w.bits)
+		return
+	}
+	words, shift := int(n/64), n%64
+	for i := len(w.bits) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = w.bits[j] << shift
+			if shift > 0 && j > 0 {
+				v |= w.bits[j-1] >> (64 - shift)
+			}
+		}
+		w.bits[i] = v
+	}
+}
+
+// accept reports whether seq is new to the window, recording it if so.
+func (w *replayWindow) accept(seq uint32) bool {
+	if seq > w.top {
+		w.advance(seq - w.top)
+		w.top = seq
+		w.set(0)
+		return true
+	}
+	d := w.top - seq
+	if d >= w.size() || w.has(d) {
+		return false
+	}
+	w.set(d)
+	return true
+}
+
+// replayGuard keeps a replay window per session. It is used by the receive
+// loop only, so it needs no locking.
+type replayGuard struct {
+	size      int
+	windows   map[[16]byte]*replayWindow
+	lastSweep time.Time
+}
+
+func newReplayGuard(size int) *replayGuard {
+	return &replayGuard{size: size, windows: make(map[[16]byte]*replayWindow)}
+}
+
+// accept reports whether the packet numbered seq of session is seen for
+// the first time.
+func (g *replayGuard) accept(session [16]byte, seq uint32, now time.Time) bool {
+	if now.Sub(g.lastSweep) > replayIdle {
+		for id, w := range g.windows {
+			if now.Sub(w.lastSeen) > replayIdle {
+				delete(g.windows, id)
+			}
+		}
+		g.lastSweep = now
+	}
+	w, ok := g.windows[session]
+	if !ok {
+		w = newReplayWindow(g.size)
+		g.windows[session] = w
+		w.top = seq
+		w.set(0)
+		w.lastSeen = now
+		return true
+	}
+	w.lastSeen = now
+	return w.accept(seq)
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+func TestReplayWindow(t *testing.T) {
+	w := newReplayWindow(128)
+	steps := []struct {
+		seq  uint32
+		want bool
+	}{
+		{1, true},
+		{1, false}, // duplicate
+		{3, true},
+		{2, true}, // late but new
+		{2, false},
+		{200, true}, // jumps past the whole window
+		{3, false},  // stale
+		{73, true},  // 127 behind: the oldest number tracked
+		{72, false}, // 128 behind: outside
+		{73, false},
+		{264, true}, // slides by 64, keeping 200
+		{200, false},
+		{201, true},
+	}
+	for i, s := range steps {
+		if got := w.accept(s.seq); got != s.want {
+			t.Fatalf("step %d: accept(%d) = %v, want %v", i, s.seq, got, s.want)
+		}
+	}
+}
+
+func TestReplayGuardPerSession(t *testing.T) {
+	g := newReplayGuard(64)
+	now := time.Now()
+	a, b := [16]byte{1}, [16]byte{2}
+	if !g.accept(a, 10, now) || !g.accept(b, 10, now) {
+		t.Fatal("first packet of a session refused")
+	}
+	if g.accept(a, 10, now) {
+		t.Fatal("replay accepted")
+	}
+	// Idle windows are dropped.
+	later := now.Add(2 * replayIdle)
+	if !g.accept(b, 11, later) || len(g.windows) != 1 {
+		t.Fatalf("idle window kept: %d windows", len(g.windows))
+	}
+}
+
+func TestUDPReceiverDropsReplays(t *testing.T) {
+	r, err := NewUDPReceiver(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := make(chan uint32, 8)
+	r.Handler = func(p *protocol.Packet, _ *net.UDPAddr) { got <- p.Seq }
+	r.Start()
+
+	conn, err := net.DialUDP("udp", nil, r.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send := func(seq uint32) {
+		raw, err := protocol.SerializePacket(&protocol.Packet{
+			Version: protocol.CurrentVersion, Type: protocol.PacketTypeData, Seq: seq, Payload: []byte("x"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, seq := range []uint32{1, 1, 2, 1} {
+		send(seq)
+	}
+	for _, want := range []uint32{1, 2} {
+		select {
+		case seq := <-got:
+			if seq != want {
+				t.Fatalf("handler got seq %d, want %d", seq, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d not delivered", want)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Stats().Replays < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := r.Stats(); st.Packets != 2 || st.Replays != 2 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// A sender resuming a session (protocol v11 and later) asks the receiver
+// which chunks it still holds from the earlier attempt with a resume request
+// frame; the receiver answers with a held chunks frame and the sender skips
+// those chunks.
+const (
+	ResumeRequestFrameID = "__resumereq__"
+	HeldChunksFrameID    = "__held__"
+)
+
+// ResumeRequest is the payload of a resume request frame.
+type ResumeRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// HeldChunk is a chunk the receiver holds, verified against SHA256.
+type HeldChunk struct {
+	ID     string `json:"id"`
+	SHA256 string `json:"sha256"`
+}
+
+// HeldChunks is the payload of a held chunks frame. It is empty when the
+// receiver has nothing from an earlier attempt.
+type HeldChunks struct {
+	Chunks []HeldChunk `json:"chunks"`
+}
+
+// RequestHeldChunks sends a resume request on conn and waits for the
+// receiver's answer. Like SyncClock it must finish before ReadControl
+// starts reading from conn.
+func (s *TCPSender) RequestHeldChunks(ctx context.Context, conn net.Conn, req ResumeRequest) (*HeldChunks, error) {
+	if err := s.SendResumeRequest(ctx, conn, req); err != nil {
+		return nil, err
+	}
+	recv := &TCPReceiver{}
+	for {
+		meta, data, err := recv.ReceiveStream(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("read held chunks frame: %w", err)
+		}
+		payload, err := io.ReadAll(data)
+		if err != nil {
+			return nil, fmt.Errorf("read held chunks frame: %w", err)
+		}
+		if meta.ID != HeldChunksFrameID {
+			continue
+		}
+		var held HeldChunks
+		if err := json.Unmarshal(payload, &held); err != nil {
+			return nil, fmt.Errorf("invalid held chunks frame: %w", err)
+		}
+		return &held, nil
+	}
+}
+
+// SendResumeRequest sends a resume request on conn without waiting for the
+// answer, for relays passing the answer back on their own.
+func (s *TCPSender) SendResumeRequest(ctx context.Context, conn net.Conn, req ResumeRequest) error {
+	return s.sendControl(ctx, conn, ResumeRequestFrameID, req)
+}
+
+// SendHeldChunks answers a resume request on conn.
+func (s *TCPSender) SendHeldChunks(ctx context.Context, conn net.Conn, held HeldChunks) error {
+	return s.sendControl(ctx, conn, HeldChunksFrameID, held)
+}
+
+// DecodeResumeRequest parses the payload of a resume request frame.
+func DecodeResumeRequest(payload []byte) (ResumeRequest, error) {
+	var req ResumeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return ResumeRequest{}, fmt.Errorf("invalid resume request frame: %w", err)
+	}
+	return req, nil
+}
+
+// MissingChunks returns the IDs of the completed chunks of session whose
+// temp files are gone or, with verify, no longer match their hashes, so
+// they can be received again. Sessions are checked after a restart this
+// way before they are resumed.
+func (r *TCPReceiver) MissingChunks(session *models.TransferSession, verify bool) []string {
+	var missing []string
+	for id, c := range session.Chunks {
+		if c.Status != models.ChunkStatusCompleted {
+			continue
+		}
+		if !partHolds(r.PartPath(session.ID, c.ID), c, verify) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// partHolds reports whether the temp file at path holds chunk c.
+func partHolds(path string, c *models.ChunkMetadata, verify bool) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() != c.Size {
+		return false
+	}
+	if !verify {
+		return true
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hashMatches(h, c.SHA256)
+}
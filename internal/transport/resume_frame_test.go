@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestHeldChunksRoundTrip(t *testing.T) {
+	senderSide, receiverSide := net.Pipe()
+	defer senderSide.Close()
+	defer receiverSide.Close()
+
+	want := HeldChunks{Chunks: []HeldChunk{{ID: "c0", SHA256: "aa"}, {ID: "c1", SHA256: "bb"}}}
+	done := make(chan error, 1)
+	go func() {
+		meta, data, err := (&TCPReceiver{}).ReceiveStream(context.Background(), receiverSide)
+		if err != nil {
+			done <- err
+			return
+		}
+		payload, err := io.ReadAll(data)
+		if err != nil {
+			done <- err
+			return
+		}
+		if meta.ID != ResumeRequestFrameID {
+			t.Errorf("frame %q, want %q", meta.ID, ResumeRequestFrameID)
+		}
+		req, err := DecodeResumeRequest(payload)
+		if err != nil {
+			done <- err
+			return
+		}
+		if req.SessionID != "s1" {
+			t.Errorf("request = %+v", req)
+		}
+		done <- NewTCPSender().SendHeldChunks(context.Background(), receiverSide, want)
+	}()
+
+	got, err := NewTCPSender().RequestHeldChunks(context.Background(), senderSide, ResumeRequest{SessionID: "s1"})
+	if err != nil {
+		t.Fatalf("RequestHeldChunks: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("receiver side: %v", err)
+	}
+	if !slices.Equal(got.Chunks, want.Chunks) {
+		t.Fatalf("got %+v, want %+v", got.Chunks, want.Chunks)
+	}
+}
+
+func TestMissingChunks(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewTCPReceiver(filepath.Join(dir, "out"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	sess := &models.TransferSession{ID: "s", Chunks: make(map[string]*models.ChunkMetadata)}
+	add := func(id string, data []byte, status models.ChunkStatus, stored []byte) {
+		sum := sha256.Sum256(data)
+		sess.Chunks[id] = &models.ChunkMetadata{ID: id, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:]), Status: status}
+		if stored != nil {
+			if err := os.WriteFile(filepath.Join(r.TempDir, "s_"+id+".part"), stored, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add("good", []byte("hello"), models.ChunkStatusCompleted, []byte("hello"))
+	add("gone", []byte("world"), models.ChunkStatusCompleted, nil)
+	add("short", []byte("world"), models.ChunkStatusCompleted, []byte("wor"))
+	add("corrupt", []byte("world"), models.ChunkStatusCompleted, []byte("WORLD"))
+	add("pending", []byte("later"), models.ChunkStatusPending, nil)
+
+	got := r.MissingChunks(sess, false)
+	slices.Sort(got)
+	if want := []string{"gone", "short"}; !slices.Equal(got, want) {
+		t.Fatalf("MissingChunks without verifying = %v, want %v", got, want)
+	}
+	got = r.MissingChunks(sess, true)
+	slices.Sort(got)
+	if want := []string{"corrupt", "gone", "short"}; !slices.Equal(got, want) {
+		t.Fatalf("MissingChunks = %v, want %v", got, want)
+	}
+}
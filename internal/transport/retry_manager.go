@@ -1,6 +1,8 @@
 package transport
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"sync"
@@ -16,29 +18,55 @@ const (
 	CircuitHalfOpen
 )
 
-// RetryManager implements exponential backoff with jitter and a simple circuit breaker.
+// ErrCircuitOpen is returned by Run when the circuit for a destination is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// RetryManager implements exponential backoff with jitter and a circuit
+// breaker per destination. A circuit opens after more than MaxRetries
+// consecutive failures and refuses requests for OpenTimeout. It is then
+// half-open: up to HalfOpenProbes trial requests go through, and the first
+// outcome recorded closes it again or reopens it for another OpenTimeout.
 type RetryManager struct {
-	MaxRetries       int
-	BaseBackoff      time.Duration
-	MaxBackoff       time.Duration
+	MaxRetries        int
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
 	BackoffMultiplier float64
-	JitterFactor     float64
+	JitterFactor      float64
+	OpenTimeout       time.Duration
+	HalfOpenProbes    int
+
+	// Abort, when closed, makes Run return the last error instead of
+	// backing off for another attempt.
+	Abort <-chan struct{}
 
-	mu      sync.Mutex
+	mu       sync.Mutex
 	failures map[string]int
 	state    map[string]CircuitState
+	openedAt map[string]time.Time
+	probes   map[string]int // trial requests let through while half-open
+
+	// sleep waits between attempts and now tells the time; tests replace
+	// them to avoid real delays.
+	sleep func(time.Duration)
+	now   func() time.Time
 }
 
 // NewRetryManager creates a new RetryManager with sane defaults.
 func NewRetryManager() *RetryManager {
 	return &RetryManager{
-		MaxRetries:       5,
-		BaseBackoff:      100 * time.Millisecond,
-		MaxBackoff:       30 * time.Second,
+		MaxRetries:        5,
+		BaseBackoff:       100 * time.Millisecond,
+		MaxBackoff:        30 * time.Second,
 		BackoffMultiplier: 2.0,
-		JitterFactor:     0.1,
-		failures:         make(map[string]int),
-		state:            make(map[string]CircuitState),
+		JitterFactor:      0.1,
+		OpenTimeout:       30 * time.Second,
+		HalfOpenProbes:    1,
+		failures:          make(map[string]int),
+		state:             make(map[string]CircuitState),
+		openedAt:          make(map[string]time.Time),
+		probes:            make(map[string]int),
+		sleep:             time.Sleep,
+		now:               time.Now,
 	}
 }
 
@@ -76,27 +104,118 @@ func (r *RetryManager) RecordSuccess(id string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.failures, id)
+	delete(r.openedAt, id)
+	delete(r.probes, id)
 	r.state[id] = CircuitClosed
 }
 
-// RecordFailure increments failure count and may open circuit.
+// RecordFailure increments failure count and may open circuit. A failed
+// trial request reopens a half-open circuit.
 func (r *RetryManager) RecordFailure(id string, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.failures[id]++
-	if r.failures[id] > r.MaxRetries {
+	if r.failures[id] > r.MaxRetries || r.stateLocked(id) == CircuitHalfOpen {
 		r.state[id] = CircuitOpen
+		r.openedAt[id] = r.now()
+		delete(r.probes, id)
 	}
 }
 
-// GetCircuitState returns current circuit state for identifier.
+// GetCircuitState returns current circuit state for identifier. An open
+// circuit whose OpenTimeout has passed is reported half-open.
 func (r *RetryManager) GetCircuitState(id string) CircuitState {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if s, ok := r.state[id]; ok {
-		return s
+	return r.stateLocked(id)
+}
+
+func (r *RetryManager) stateLocked(id string) CircuitState {
+	s := r.state[id]
+	if s == CircuitOpen && r.now().Sub(r.openedAt[id]) >= r.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return s
+}
+
+// Allow reports whether a request to id may be made now: it returns an
+// error wrapping ErrCircuitOpen while the circuit is open, or half-open
+// with all its trial requests under way. A request let through must have
+// its outcome recorded with RecordSuccess or RecordFailure.
+func (r *RetryManager) Allow(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.stateLocked(id) {
+	case CircuitOpen:
+		wait := r.OpenTimeout - r.now().Sub(r.openedAt[id])
+		return fmt.Errorf("%s: %w, next trial in %v", id, ErrCircuitOpen, wait.Round(time.Second))
+	case CircuitHalfOpen:
+		if r.probes[id] >= max(r.HalfOpenProbes, 1) {
+			return fmt.Errorf("%s: %w, trial under way", id, ErrCircuitOpen)
+		}
+		r.state[id] = CircuitHalfOpen
+		r.probes[id]++
+	}
+	return nil
+}
+
+// Run calls fn until it succeeds, retrying up to retries more times with
+// backoff between attempts. Every outcome is recorded against the circuit for
+// id, and no attempt is made while Allow refuses it. fn receives the
+// zero-based attempt number. The last error is returned if all attempts fail
+// or Abort is closed.
+func (r *RetryManager) Run(id string, retries int, fn func(attempt int) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := r.Allow(id); err != nil {
+			return err
+		}
+		err := fn(attempt)
+		if err == nil {
+			r.RecordSuccess(id)
+			return nil
+		}
+		r.RecordFailure(id, err)
+		if attempt >= retries || r.aborted() {
+			return err
+		}
+		if r.GetCircuitState(id) == CircuitOpen {
+			return fmt.Errorf("%s: %w after %d failures: %w", id, ErrCircuitOpen, attempt+1, err)
+		}
+		if !r.wait(r.NextBackoff(attempt+1, 0)) {
+			return err
+		}
 	}
-	return CircuitClosed
 }
 
+// Backoff waits out the backoff before retry number attempt, counted from
+// 1, and reports whether to make it, which callers should not if Abort was
+// closed before or during the wait.
+func (r *RetryManager) Backoff(attempt int) bool {
+	return !r.aborted() && r.wait(r.NextBackoff(attempt, 0))
+}
 
+func (r *RetryManager) aborted() bool {
+	select {
+	case <-r.Abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait sleeps for d and reports whether Run should go on, which it should
+// not if Abort was closed in the meantime.
+func (r *RetryManager) wait(d time.Duration) bool {
+	if r.Abort == nil {
+		r.sleep(d)
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Abort:
+		return false
+	}
+}
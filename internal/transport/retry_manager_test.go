@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRetryManager() (*RetryManager, *[]time.Duration) {
+	r := NewRetryManager()
+	var waits []time.Duration
+	r.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return r, &waits
+}
+
+func TestRunRetriesUntilSuccess(t *testing.T) {
+	r, waits := newTestRetryManager()
+	calls := 0
+	err := r.Run("dest", 3, func(attempt int) error {
+		if attempt != calls {
+			t.Fatalf("attempt %d, want %d", attempt, calls)
+		}
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if calls != 3 || len(*waits) != 2 {
+		t.Fatalf("calls=%d waits=%d, want 3 and 2", calls, len(*waits))
+	}
+	if r.GetCircuitState("dest") != CircuitClosed {
+		t.Fatal("circuit should be closed after success")
+	}
+}
+
+func TestRunGivesUpAfterRetries(t *testing.T) {
+	r, _ := newTestRetryManager()
+	boom := errors.New("boom")
+	calls := 0
+	err := r.Run("dest", 2, func(int) error {
+		calls++
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRunStopsWhenCircuitOpens(t *testing.T) {
+	r, _ := newTestRetryManager()
+	r.MaxRetries = 1 // circuit opens after two consecutive failures
+	calls := 0
+	err := r.Run("dest", 10, func(int) error {
+		calls++
+		return errors.New("down")
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2", calls)
+	}
+
+	// Later runs against the same destination are refused outright.
+	err = r.Run("dest", 10, func(int) error {
+		t.Fatal("fn called with open circuit")
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestRunStopsWhenAborted(t *testing.T) {
+	r := NewRetryManager()
+	r.BaseBackoff = time.Hour
+	r.MaxBackoff = time.Hour
+	abort := make(chan struct{})
+	r.Abort = abort
+	boom := errors.New("boom")
+	calls := 0
+	err := r.Run("dest", 10, func(int) error {
+		calls++
+		close(abort)
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r, waits := newTestRetryManager()
+	r.JitterFactor = 0
+	if !r.Backoff(1) || !r.Backoff(3) {
+		t.Fatal("Backoff declined a retry")
+	}
+	if len(*waits) != 2 || (*waits)[0] != r.BaseBackoff || (*waits)[1] != 4*r.BaseBackoff {
+		t.Fatalf("waits = %v", *waits)
+	}
+
+	abort := make(chan struct{})
+	close(abort)
+	r.Abort = abort
+	if r.Backoff(1) {
+		t.Fatal("Backoff retried after abort")
+	}
+}
+
+func TestCircuitHalfOpen(t *testing.T) {
+	r, _ := newTestRetryManager()
+	r.MaxRetries = 1
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+	down := errors.New("down")
+
+	r.RecordFailure("dest", down)
+	r.RecordFailure("dest", down)
+	if err := r.Allow("dest"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow on open circuit = %v", err)
+	}
+
+	// After OpenTimeout one trial request goes through at a time.
+	now = now.Add(r.OpenTimeout)
+	if s := r.GetCircuitState("dest"); s != CircuitHalfOpen {
+		t.Fatalf("state = %v, want half-open", s)
+	}
+	if err := r.Allow("dest"); err != nil {
+		t.Fatalf("trial refused: %v", err)
+	}
+	if err := r.Allow("dest"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second trial = %v, want refused", err)
+	}
+
+	// A failed trial reopens the circuit for another OpenTimeout.
+	r.RecordFailure("dest", down)
+	if s := r.GetCircuitState("dest"); s != CircuitOpen {
+		t.Fatalf("state after failed trial = %v", s)
+	}
+	now = now.Add(r.OpenTimeout / 2)
+	if err := r.Allow("dest"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow before timeout = %v", err)
+	}
+
+	// A successful trial closes it.
+	now = now.Add(r.OpenTimeout)
+	if err := r.Allow("dest"); err != nil {
+		t.Fatalf("trial refused: %v", err)
+	}
+	r.RecordSuccess("dest")
+	if s := r.GetCircuitState("dest"); s != CircuitClosed {
+		t.Fatalf("state after successful trial = %v", s)
+	}
+	if err := r.Allow("dest"); err != nil {
+		t.Fatalf("Allow on closed circuit: %v", err)
+	}
+}
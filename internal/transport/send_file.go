@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// FileMetaFrameID identifies the frame that opens every session with the
+// JSON-encoded models.FileMetadata of the file being sent.
+const FileMetaFrameID = "__filemeta__"
+
+// SendFile sends a whole session on conn: the file metadata frame followed by
+// every chunk in chunks, streamed from src. It is the minimal send path used
+// when a node serves a file it already holds; file.ProtocolVersion must allow
+// streamed frames, and a receiver answering with an older version fails the
+// session. It stops once ctx is done.
+func (s *TCPSender) SendFile(ctx context.Context, conn net.Conn, src io.ReaderAt, file models.FileMetadata, chunks []*models.ChunkMetadata) error {
+	if err := s.sendControl(ctx, conn, FileMetaFrameID, file); err != nil {
+		return fmt.Errorf("send file metadata frame: %w", err)
+	}
+	if protocol.SupportsVersionReply(file.ProtocolVersion) {
+		v, _, err := s.AwaitVersion(ctx, conn, file.ProtocolVersion)
+		if err != nil {
+			return err
+		}
+		if !protocol.SupportsStreamedFrames(v) {
+			return fmt.Errorf("receiver speaks protocol v%d; streamed frames need v%d", v, protocol.Version3)
+		}
+	}
+	for _, c := range chunks {
+		c.SentAt = time.Now()
+		if err := s.SendStream(ctx, conn, io.NewSectionReader(src, c.Offset, c.Size), c); err != nil {
+			return fmt.Errorf("send chunk %s: %w", c.ID, err)
+		}
+	}
+	return nil
+}
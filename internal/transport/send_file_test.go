@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+func TestSendFile(t *testing.T) {
+	data := bytes.Repeat([]byte("trackshift"), 1000)
+	file := models.FileMetadata{Name: "f.bin", Size: int64(len(data)), Hash: "h", ProtocolVersion: protocol.CurrentVersion}
+	chunks := []*models.ChunkMetadata{
+		{ID: "0", Offset: 0, Size: 6000},
+		{ID: "1", Offset: 6000, Size: int64(len(data)) - 6000},
+	}
+
+	senderSide, receiverSide := net.Pipe()
+	defer receiverSide.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewTCPSender().SendFile(context.Background(), senderSide, bytes.NewReader(data), file, chunks)
+		senderSide.Close()
+	}()
+
+	recv := &TCPReceiver{}
+	meta, r, err := recv.ReceiveStream(context.Background(), receiverSide)
+	if err != nil {
+		t.Fatalf("receive metadata frame: %v", err)
+	}
+	payload, _ := io.ReadAll(r)
+	var got models.FileMetadata
+	if meta.ID != FileMetaFrameID || json.Unmarshal(payload, &got) != nil || got.Name != file.Name {
+		t.Fatalf("metadata frame %s: %q", meta.ID, payload)
+	}
+	if err := NewTCPSender().SendVersion(context.Background(), receiverSide, protocol.CurrentVersion); err != nil {
+		t.Fatalf("SendVersion: %v", err)
+	}
+
+	var out []byte
+	for range chunks {
+		meta, r, err := recv.ReceiveStream(context.Background(), receiverSide)
+		if err != nil {
+			t.Fatalf("receive chunk: %v", err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Offset != int64(len(out)) {
+			t.Fatalf("chunk %s at offset %d, want %d", meta.ID, meta.Offset, len(out))
+		}
+		out = append(out, b...)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("received data differs")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SendFile: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Streamed frames replace the single data block with a sequence of segments
+// so neither side has to hold a whole chunk in memory:
+//
+//	[4 bytes metadata length][metadata JSON][8 bytes 0xFF..FF]
+//	([4 bytes segment length][segment bytes])* [4 bytes 0]
+//
+// Each segment is compressed independently according to meta.Compression.
+const streamedDataLen = ^uint64(0)
+
+// DefaultSegmentSize is the amount of raw chunk data carried per segment.
+const DefaultSegmentSize = 1024 * 1024
+
+// maxSegmentLen bounds the size of a single encoded segment on the wire.
+const maxSegmentLen = 64 * 1024 * 1024
+
+// ErrChunkHashMismatch is returned when streamed chunk data does not match
+// the SHA-256 recorded in its metadata.
+var ErrChunkHashMismatch = errors.New("chunk hash mismatch")
+
+// SendStream sends a chunk read from r using streamed framing, so memory use
+// is bounded by the segment size regardless of chunk size. If
+// metadata.Compression is empty, the first segment is sampled to decide
+// whether compression is worthwhile. It stops once ctx is done.
+func (s *TCPSender) SendStream(ctx context.Context, conn net.Conn, r io.Reader, metadata *models.ChunkMetadata) error {
+	segSize := s.SegmentSize
+	if segSize <= 0 {
+		segSize = DefaultSegmentSize
+	}
+	buf := make([]byte, segSize)
+
+	n, readErr := io.ReadFull(r, buf)
+	if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+		return fmt.Errorf("read chunk data: %w", readErr)
+	}
+	chooseCompression(metadata, buf[:n])
+
+	if err := s.writeStreamHeader(ctx, conn, metadata); err != nil {
+		return err
+	}
+	for n > 0 {
+		seg, err := encodeSegment(buf[:n], metadata.Compression, nil)
+		if err != nil {
+			return err
+		}
+		if err := s.writeSegment(ctx, conn, seg); err != nil {
+			return err
+		}
+		if readErr != nil {
+			break
+		}
+		n, readErr = io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read chunk data: %w", readErr)
+		}
+	}
+	return s.writeStreamEnd(ctx, conn)
+}
+
+// chooseCompression sets an empty metadata.Compression according to whether
+// sample, the start of the chunk, is worth compressing.
+func chooseCompression(metadata *models.ChunkMetadata, sample []byte) {
+	if metadata.Compression != "" {
+		return
+	}
+	metadata.Compression = models.CompressionNone
+	if crypto.ShouldCompress(sample) {
+		metadata.Compression = models.CompressionZstd
+	}
+}
+
+// encodeSegment applies compression to one segment of raw chunk data, with
+// codec or, if nil, the default one.
+func encodeSegment(raw []byte, compression string, codec *crypto.Codec) ([]byte, error) {
+	if compression != models.CompressionZstd {
+		return raw, nil
+	}
+	compress := crypto.CompressChunk
+	if codec != nil {
+		compress = codec.Compress
+	}
+	seg, err := compress(raw)
+	if err != nil {
+		return nil, fmt.Errorf("compress segment: %w", err)
+	}
+	return seg, nil
+}
+
+// writeStreamHeader writes the header of a streamed frame for metadata.
+func (s *TCPSender) writeStreamHeader(ctx context.Context, conn net.Conn, metadata *models.ChunkMetadata) error {
+	if err := checkAppData(metadata); err != nil {
+		return err
+	}
+	metaBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+	var hdr bytes.Buffer
+	if err := binary.Write(&hdr, binary.BigEndian, uint32(len(metaBytes))); err != nil {
+		return fmt.Errorf("write meta length: %w", err)
+	}
+	hdr.Write(metaBytes)
+	if err := binary.Write(&hdr, binary.BigEndian, streamedDataLen); err != nil {
+		return fmt.Errorf("write data length: %w", err)
+	}
+	if err := s.write(ctx, conn, hdr.Bytes()); err != nil {
+		return fmt.Errorf("send frame header: %w", err)
+	}
+	return nil
+}
+
+// writeSegment writes one encoded segment of a streamed frame.
+func (s *TCPSender) writeSegment(ctx context.Context, conn net.Conn, seg []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(seg)))
+	if err := s.write(ctx, conn, lenBuf[:]); err != nil {
+		return fmt.Errorf("send segment length: %w", err)
+	}
+	if err := s.write(ctx, conn, seg); err != nil {
+		return fmt.Errorf("send segment: %w", err)
+	}
+	return nil
+}
+
+// writeStreamEnd writes the empty segment that ends a streamed frame.
+func (s *TCPSender) writeStreamEnd(ctx context.Context, conn net.Conn) error {
+	var end [4]byte
+	if err := s.write(ctx, conn, end[:]); err != nil {
+		return fmt.Errorf("send end of chunk: %w", err)
+	}
+	return nil
+}
+
+// PreparedChunk is a chunk read and encoded into streamed-frame segments
+// ahead of sending, so the CPU work for one chunk can overlap the
+// transmission of another. Unlike SendStream it holds the whole encoded
+// chunk in memory.
+type PreparedChunk struct {
+	Meta     *models.ChunkMetadata
+	segments [][]byte
+	raw      []int // raw data bytes carried by each segment
+}
+
+// WithMeta returns p sent under meta instead, e.g. the same chunk in another
+// session. The encoded segments are shared, not copied; meta's compression
+// must match p.Meta's.
+func (p *PreparedChunk) WithMeta(meta *models.ChunkMetadata) *PreparedChunk {
+	return &PreparedChunk{Meta: meta, segments: p.segments, raw: p.raw}
+}
+
+// PrepareStream reads the chunk for meta from r and encodes it exactly as
+// SendStream would, without writing anything. meta.Compression is decided
+// here if empty.
+func (s *TCPSender) PrepareStream(r io.Reader, meta *models.ChunkMetadata) (*PreparedChunk, error) {
+	return s.PrepareStreamWith(r, meta, nil)
+}
+
+// PrepareStreamWith is PrepareStream compressing with codec, e.g. to choose
+// the compression level per chunk. A nil codec uses the default level. The
+// receiver decodes every level alike.
+func (s *TCPSender) PrepareStreamWith(r io.Reader, meta *models.ChunkMetadata, codec *crypto.Codec) (*PreparedChunk, error) {
+	segSize := s.SegmentSize
+	if segSize <= 0 {
+		segSize = DefaultSegmentSize
+	}
+	p := &PreparedChunk{Meta: meta}
+	for first := true; ; first = false {
+		buf := make([]byte, segSize)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("read chunk data: %w", err)
+		}
+		if first {
+			chooseCompression(meta, buf[:n])
+		}
+		if n == 0 {
+			return p, nil
+		}
+		seg, encErr := encodeSegment(buf[:n], meta.Compression, codec)
+		if encErr != nil {
+			return nil, encErr
+		}
+		p.segments = append(p.segments, seg)
+		p.raw = append(p.raw, n)
+		if err != nil {
+			return p, nil
+		}
+	}
+}
+
+// SendPrepared writes p to conn as a streamed frame. The metadata is encoded
+// at this point, so fields such as SentAt may be set after PrepareStream.
+// progress, if non-nil, is called with the raw size of each segment once it
+// has been written. It stops once ctx is done.
+func (s *TCPSender) SendPrepared(ctx context.Context, conn net.Conn, p *PreparedChunk, progress func(int64)) error {
+	if err := s.writeStreamHeader(ctx, conn, p.Meta); err != nil {
+		return err
+	}
+	for i, seg := range p.segments {
+		if err := s.writeSegment(ctx, conn, seg); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(int64(p.raw[i]))
+		}
+	}
+	return s.writeStreamEnd(ctx, conn)
+}
+
+// write writes p to conn and records it in telemetry. With a Limiter or a
+// write timeout, p is written in pieces so the rate stays smooth, rate
+// changes take effect within a frame and the timeout bounds each piece
+// rather than a whole chunk. Once ctx is done, waiting for the Limiter or
+// the connection fails with ctx.Err().
+func (s *TCPSender) write(ctx context.Context, conn net.Conn, p []byte) error {
+	pieceSize := len(p)
+	if s.Limiter.Rate() > 0 {
+		pieceSize = s.Limiter.Burst()
+	} else if s.Timeouts.Write > 0 {
+		pieceSize = DefaultSegmentSize
+	}
+	for len(p) > 0 {
+		piece := p
+		if len(piece) > pieceSize {
+			piece = piece[:pieceSize]
+		}
+		if err := s.Limiter.WaitN(ctx, len(piece)); err != nil {
+			return err
+		}
+		if s.Timeouts.Write > 0 {
+			if err := conn.SetWriteDeadline(timeouts.Deadline(s.Timeouts.Write)); err != nil {
+				return err
+			}
+		}
+		n, err := interruptible(ctx, conn.SetWriteDeadline, func() (int, error) { return conn.Write(piece) })
+		if s.Telemetry != nil {
+			s.Telemetry.RecordBytesSent(n)
+		}
+		if err != nil {
+			return err
+		}
+		p = p[len(piece):]
+	}
+	return nil
+}
+
+// segmentReader decodes the segments of a streamed frame.
+type segmentReader struct {
+	conn        io.Reader
+	compression string
+	pending     []byte
+	done        bool
+}
+
+func (sr *segmentReader) Read(p []byte) (int, error) {
+	for len(sr.pending) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		var segLen uint32
+		if err := binary.Read(sr.conn, binary.BigEndian, &segLen); err != nil {
+			return 0, fmt.Errorf("read segment length: %w", err)
+		}
+		if segLen == 0 {
+			sr.done = true
+			return 0, io.EOF
+		}
+		if segLen > maxSegmentLen {
+			return 0, fmt.Errorf("segment length %d exceeds limit", segLen)
+		}
+		seg := make([]byte, segLen)
+		if _, err := io.ReadFull(sr.conn, seg); err != nil {
+			return 0, fmt.Errorf("read segment: %w", err)
+		}
+		decoded, err := crypto.DecodeChunk(seg, sr.compression)
+		if err != nil {
+			return 0, fmt.Errorf("decode segment: %w", err)
+		}
+		sr.pending = decoded
+	}
+	n := copy(p, sr.pending)
+	sr.pending = sr.pending[n:]
+	return n, nil
+}
+
+// ReceiveStream reads the header of the next frame from conn and returns its
+// metadata together with a reader for the decoded chunk data. Both streamed
+// and whole-chunk frames are accepted. The reader must be drained before the
+// next frame is read from conn. Reading the frame, and the data, fails with
+// ctx.Err() once ctx is done.
+func (r *TCPReceiver) ReceiveStream(ctx context.Context, conn net.Conn) (*models.ChunkMetadata, io.Reader, error) {
+	meta, dataLen, err := r.readHeader(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataLen == streamedDataLen {
+		return meta, &segmentReader{conn: r.reader(ctx, conn), compression: meta.Compression}, nil
+	}
+	data, err := r.readWholeData(ctx, conn, meta, dataLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, bytes.NewReader(data), nil
+}
+
+// writeVerified copies r into path while hashing it, removing the file and
+// returning ErrChunkHashMismatch if the result does not match expectedHex.
+// The copy stops once ctx is done.
+func writeVerified(ctx context.Context, path string, r io.Reader, expectedHex string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open chunk file: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), ctxReader{ctx, r}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("write chunk file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("close chunk file: %w", err)
+	}
+	if !hashMatches(h, expectedHex) {
+		os.Remove(path)
+		return ErrChunkHashMismatch
+	}
+	return nil
+}
+
+func hashMatches(h hash.Hash, expectedHex string) bool {
+	expected, err := hex.DecodeString(expectedHex)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(h.Sum(nil), expected)
+}
+
+// StoreChunkStream writes chunk data from r to a temp file, verifying it
+// against meta.SHA256 as it is written. It stops once ctx is done.
+func (r *TCPReceiver) StoreChunkStream(ctx context.Context, sessionID string, meta *models.ChunkMetadata, data io.Reader) (string, error) {
+	path := r.PartPath(sessionID, meta.ID)
+	if err := writeVerified(ctx, path, data, meta.SHA256); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// StoreChunkStream writes chunk data from r into the session directory,
+// verifying it against meta.SHA256 as it is written. It stops once ctx is
+// done.
+func (s *ChunkStore) StoreChunkStream(ctx context.Context, sessionID string, meta *models.ChunkMetadata, data io.Reader) (string, error) {
+	dir := s.SessionDir(sessionID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create session dir: %w", err)
+	}
+	path := filepath.Join(dir, ChunkName(meta))
+	if err := writeVerified(ctx, path, data, meta.SHA256); err != nil {
+		return "", err
+	}
+	return path, nil
+}
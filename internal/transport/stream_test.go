@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestSendStreamReceiveStream(t *testing.T) {
+	data := bytes.Repeat([]byte("streamed chunk data "), 1000) // 20KB
+	h := crypto.HashChunk(data)
+	meta := &models.ChunkMetadata{ID: "0", Size: int64(len(data)), SHA256: fmt.Sprintf("%x", h[:])}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		defer client.Close()
+		sender := NewTCPSender()
+		sender.SegmentSize = 6000 // several segments plus a short tail
+		errCh <- sender.SendStream(context.Background(), client, bytes.NewReader(data), meta)
+	}()
+
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	gotMeta, r, err := recv.ReceiveStream(context.Background(), server)
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	if gotMeta.Compression != models.CompressionZstd {
+		t.Fatalf("expected compressible data to use zstd, got %q", gotMeta.Compression)
+	}
+	path, err := recv.StoreChunkStream(context.Background(), "sess", gotMeta, r)
+	if err != nil {
+		t.Fatalf("StoreChunkStream: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read stored chunk: %v", err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored chunk mismatch")
+	}
+}
+
+func TestSendPreparedMatchesSendStream(t *testing.T) {
+	data := bytes.Repeat([]byte("prepared chunk data "), 1000)
+	h := crypto.HashChunk(data)
+	meta := &models.ChunkMetadata{ID: "0", Size: int64(len(data)), SHA256: fmt.Sprintf("%x", h[:])}
+
+	sender := NewTCPSender()
+	sender.SegmentSize = 6000
+	p, err := sender.PrepareStream(bytes.NewReader(data), meta)
+	if err != nil {
+		t.Fatalf("PrepareStream: %v", err)
+	}
+	if meta.Compression != models.CompressionZstd {
+		t.Fatalf("compression = %q, want zstd", meta.Compression)
+	}
+	// Metadata changes after preparation are still sent.
+	meta.SentAt = time.Unix(1700000000, 0)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	var progressed int64
+	errCh := make(chan error, 1)
+	go func() {
+		defer client.Close()
+		errCh <- sender.SendPrepared(context.Background(), client, p, func(n int64) { progressed += n })
+	}()
+
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotMeta, r, err := recv.ReceiveStream(context.Background(), server)
+	if err != nil {
+		t.Fatalf("ReceiveStream: %v", err)
+	}
+	if !gotMeta.SentAt.Equal(meta.SentAt) {
+		t.Fatalf("SentAt = %v, want %v", gotMeta.SentAt, meta.SentAt)
+	}
+	path, err := recv.StoreChunkStream(context.Background(), "sess", gotMeta, r)
+	if err != nil {
+		t.Fatalf("StoreChunkStream: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("SendPrepared: %v", err)
+	}
+	if stored, _ := os.ReadFile(path); !bytes.Equal(stored, data) {
+		t.Fatal("stored chunk mismatch")
+	}
+	if progressed != int64(len(data)) {
+		t.Fatalf("progress reported %d bytes, want %d", progressed, len(data))
+	}
+}
+
+func TestReceiveBuffersStreamedFrame(t *testing.T) {
+	data := bytes.Repeat([]byte{7}, 5000)
+	meta := &models.ChunkMetadata{ID: "1", Size: int64(len(data)), Compression: models.CompressionNone}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		sender := NewTCPSender()
+		sender.SegmentSize = 1024
+		_ = sender.SendStream(context.Background(), client, bytes.NewReader(data), meta)
+	}()
+
+	got, _, err := (&TCPReceiver{}).Receive(context.Background(), server)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestStoreChunkStreamRejectsBadHash(t *testing.T) {
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	h := crypto.HashChunk([]byte("expected"))
+	meta := &models.ChunkMetadata{ID: "2", SHA256: fmt.Sprintf("%x", h[:])}
+
+	_, err = recv.StoreChunkStream(context.Background(), "sess", meta, bytes.NewReader([]byte("tampered")))
+	if !errors.Is(err, ErrChunkHashMismatch) {
+		t.Fatalf("expected ErrChunkHashMismatch, got %v", err)
+	}
+	entries, _ := os.ReadDir(recv.TempDir)
+	if len(entries) != 0 {
+		t.Fatalf("expected rejected chunk file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestReceiveIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	recv, err := NewTCPReceiver(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewTCPReceiver: %v", err)
+	}
+	recv.Timeouts.Read = 50 * time.Millisecond
+
+	// A sender that stalls after the length prefix must not hang the receiver.
+	go client.Write([]byte{0, 0, 0, 10})
+	_, _, err = recv.Receive(context.Background(), server)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Receive on a stalled connection: got %v, want deadline exceeded", err)
+	}
+}
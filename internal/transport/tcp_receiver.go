@@ -1,16 +1,22 @@
 package transport
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/deb2000-sudo/trackshift/internal/crypto"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
@@ -19,6 +25,28 @@ import (
 type TCPReceiver struct {
 	OutputDir string
 	TempDir   string
+
+	// Timeouts.Read, if positive, fails a read when the connection delivers
+	// no data for that long.
+	Timeouts timeouts.Set
+
+	// Authorizer, if set, is consulted by Authorize before a session's data
+	// is accepted. Applications embedding the receiver use it to apply
+	// their own rules and choose where files go.
+	Authorizer Authorizer
+
+	// OnChunk, if set, is called by ChunkReceived for every stored chunk, so
+	// applications can act on chunks and their AppData as they arrive.
+	OnChunk ChunkHook
+
+	// OnExists decides what PlaceOutput does when a session's destination
+	// already exists. The zero value means ExistsRename.
+	OnExists ExistsPolicy
+
+	// outputs holds files opened by PrepareOutput for direct-offset writes,
+	// keyed by session ID.
+	outputsMu sync.Mutex
+	outputs   map[string]*directOutput
 }
 
 // NewTCPReceiver creates a receiver with the specified output and temp directories.
@@ -38,61 +66,114 @@ func NewTCPReceiver(outputDir, tempDir string) (*TCPReceiver, error) {
 	return &TCPReceiver{
 		OutputDir: outputDir,
 		TempDir:   tempDir,
+		Timeouts:  timeouts.Defaults(),
 	}, nil
 }
 
 // Receive reads a single framed chunk from conn.
-// Returns decompressed chunk data and its metadata.
-func (r *TCPReceiver) Receive(conn net.Conn) ([]byte, *models.ChunkMetadata, error) {
+// Returns decompressed chunk data and its metadata. Streamed frames are
+// buffered in full; use ReceiveStream to keep memory bounded.
+func (r *TCPReceiver) Receive(ctx context.Context, conn net.Conn) ([]byte, *models.ChunkMetadata, error) {
+	meta, dataLen, err := r.readHeader(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataLen == streamedDataLen {
+		data, err := io.ReadAll(&segmentReader{conn: r.reader(ctx, conn), compression: meta.Compression})
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, meta, nil
+	}
+	data, err := r.readWholeData(ctx, conn, meta, dataLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, meta, nil
+}
+
+// readHeader reads the metadata and data length that start every frame.
+func (r *TCPReceiver) readHeader(ctx context.Context, conn net.Conn) (*models.ChunkMetadata, uint64, error) {
+	rd := r.reader(ctx, conn)
 	var metaLen uint32
-	if err := binary.Read(conn, binary.BigEndian, &metaLen); err != nil {
+	if err := binary.Read(rd, binary.BigEndian, &metaLen); err != nil {
 		// Treat clean connection close as io.EOF so callers can stop without logging an error.
 		if err == io.EOF {
-			return nil, nil, io.EOF
+			return nil, 0, io.EOF
 		}
-		return nil, nil, fmt.Errorf("read meta length: %w", err)
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("read meta length: %w", err)
 	}
 	metaBytes := make([]byte, metaLen)
-	if _, err := io.ReadFull(conn, metaBytes); err != nil {
-		return nil, nil, fmt.Errorf("read meta: %w", err)
+	if _, err := io.ReadFull(rd, metaBytes); err != nil {
+		return nil, 0, fmt.Errorf("read meta: %w", err)
 	}
 
 	var meta models.ChunkMetadata
 	if err := json.Unmarshal(metaBytes, &meta); err != nil {
-		return nil, nil, fmt.Errorf("unmarshal metadata: %w", err)
+		return nil, 0, fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	if err := checkAppData(&meta); err != nil {
+		return nil, 0, err
 	}
 
 	var dataLen uint64
-	if err := binary.Read(conn, binary.BigEndian, &dataLen); err != nil {
-		return nil, nil, fmt.Errorf("read data length: %w", err)
+	if err := binary.Read(rd, binary.BigEndian, &dataLen); err != nil {
+		return nil, 0, fmt.Errorf("read data length: %w", err)
 	}
+	return &meta, dataLen, nil
+}
 
+// readWholeData reads and decodes a whole-chunk data block.
+func (r *TCPReceiver) readWholeData(ctx context.Context, conn net.Conn, meta *models.ChunkMetadata, dataLen uint64) ([]byte, error) {
 	data := make([]byte, dataLen)
-	if _, err := io.ReadFull(conn, data); err != nil {
-		return nil, nil, fmt.Errorf("read data: %w", err)
+	if _, err := io.ReadFull(r.reader(ctx, conn), data); err != nil {
+		return nil, fmt.Errorf("read data: %w", err)
 	}
 
-	decompressed, err := crypto.DecompressChunk(data)
+	decompressed, err := crypto.DecodeChunk(data, meta.Compression)
 	if err != nil {
-		return nil, nil, fmt.Errorf("decompress chunk: %w", err)
+		return nil, fmt.Errorf("decompress chunk: %w", err)
 	}
+	return decompressed, nil
+}
 
-	return decompressed, &meta, nil
+// reader returns conn wrapped so that reads fail once ctx is done and every
+// read must make progress within r.Timeouts.Read.
+func (r *TCPReceiver) reader(ctx context.Context, conn net.Conn) io.Reader {
+	if r.Timeouts.Read <= 0 && ctx.Done() == nil {
+		return conn
+	}
+	return &connReader{ctx: ctx, conn: conn, timeout: r.Timeouts.Read}
 }
 
 // StoreChunk writes the chunk data to a temp file.
-func (r *TCPReceiver) StoreChunk(sessionID string, meta *models.ChunkMetadata, data []byte) (string, error) {
-	filename := fmt.Sprintf("%s_%s.part", sessionID, meta.ID)
-	path := filepath.Join(r.TempDir, filename)
+func (r *TCPReceiver) StoreChunk(ctx context.Context, sessionID string, meta *models.ChunkMetadata, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	path := r.PartPath(sessionID, meta.ID)
 	if err := os.WriteFile(path, data, 0o644); err != nil {
 		return "", fmt.Errorf("write chunk file: %w", err)
 	}
 	return path, nil
 }
 
-// AssembleFile joins all chunk files into the final output file ordered by offset.
-func (r *TCPReceiver) AssembleFile(session *models.TransferSession) (string, error) {
-	outPath := filepath.Join(r.OutputDir, session.File.Name)
+// PartPath returns the temp file chunk chunkID of session sessionID is kept
+// in until the file is assembled.
+func (r *TCPReceiver) PartPath(sessionID, chunkID string) string {
+	return filepath.Join(r.TempDir, fmt.Sprintf("%s_%s.part", sessionID, chunkID))
+}
+
+// AssembleFile joins all chunk files into the final output file ordered by
+// offset, hashing it as it is written. If the result does not match the
+// session's file hash, the output is removed and ErrFileHashMismatch is
+// returned. It stops between chunks once ctx is done, leaving the output
+// incomplete.
+func (r *TCPReceiver) AssembleFile(ctx context.Context, session *models.TransferSession) (string, error) {
+	outPath := r.outputPath(session)
 	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
 	if err != nil {
 		return "", fmt.Errorf("open output file: %w", err)
@@ -106,19 +187,63 @@ func (r *TCPReceiver) AssembleFile(session *models.TransferSession) (string, err
 	}
 	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
 
+	h := sha256.New()
+	w := io.MultiWriter(out, h)
 	for _, c := range chunks {
-		filename := fmt.Sprintf("%s_%s.part", session.ID, c.ID)
-		path := filepath.Join(r.TempDir, filename)
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		path := r.PartPath(session.ID, c.ID)
 		data, err := os.ReadFile(path)
 		if err != nil {
 			return "", fmt.Errorf("read chunk file %s: %w", path, err)
 		}
-		if _, err := out.Write(data); err != nil {
+		if _, err := w.Write(data); err != nil {
 			return "", fmt.Errorf("write output: %w", err)
 		}
 	}
 
+	// Each chunk was verified as it arrived, but overlapping, repeated or
+	// missing ranges only show in the hash of the whole.
+	if !hashMatches(h, session.File.Hash) {
+		out.Close()
+		os.Remove(outPath)
+		return "", fmt.Errorf("%s: %w", outPath, ErrFileHashMismatch)
+	}
 	return outPath, nil
 }
 
+// TempFiles returns the files a receiver keeps in tempDir for session
+// sessionID: its chunk parts, the earlier version moved aside for a delta
+// transfer and the stream of a directory transfer. They stay there after
+// the file is delivered, until removed along with the session.
+func TempFiles(tempDir, sessionID string) ([]string, error) {
+	entries, err := os.ReadDir(tempDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		part := strings.HasPrefix(name, sessionID+"_") && strings.HasSuffix(name, ".part")
+		if !e.IsDir() && (part || name == sessionID+".base" || name == sessionID+".stream") {
+			paths = append(paths, filepath.Join(tempDir, name))
+		}
+	}
+	return paths, nil
+}
+
+// outputPath returns where the session's data stream is written. Directory
+// transfers are written to TempDir and extracted to their Destination
+// afterwards.
+func (r *TCPReceiver) outputPath(session *models.TransferSession) string {
+	if session.Manifest != nil {
+		return filepath.Join(r.TempDir, session.ID+".stream")
+	}
+	return r.Destination(session)
+}
+
 
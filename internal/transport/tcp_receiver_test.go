@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+func TestTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"s1_0.part", "s1_1.part", "s1.base", "s1.stream", "s12_0.part", "s2.stream", "s1_x"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := TempFiles(dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range got {
+		names = append(names, filepath.Base(p))
+	}
+	slices.Sort(names)
+	if want := []string{"s1.base", "s1.stream", "s1_0.part", "s1_1.part"}; !slices.Equal(names, want) {
+		t.Fatalf("TempFiles = %v, want %v", names, want)
+	}
+
+	if got, err := TempFiles(filepath.Join(dir, "missing"), "s1"); err != nil || got != nil {
+		t.Fatalf("TempFiles of a missing dir = %v, %v", got, err)
+	}
+}
+
+func TestAssembleFileChecksHash(t *testing.T) {
+	dir := t.TempDir()
+	recv, err := NewTCPReceiver(filepath.Join(dir, "out"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("abcdef"))
+	sess := &models.TransferSession{ID: "s1", File: models.FileMetadata{Name: "f.txt", Size: 6, Hash: hex.EncodeToString(sum[:])}}
+	write := func(chunks map[string]string, offsets map[string]int64) {
+		sess.Chunks = make(map[string]*models.ChunkMetadata)
+		for id, data := range chunks {
+			if err := os.WriteFile(recv.PartPath(sess.ID, id), []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			sess.Chunks[id] = &models.ChunkMetadata{ID: id, Offset: offsets[id], Size: int64(len(data))}
+		}
+	}
+
+	write(map[string]string{"0": "abc", "1": "def"}, map[string]int64{"0": 0, "1": 3})
+	out, err := recv.AssembleFile(context.Background(), sess)
+	if err != nil {
+		t.Fatalf("AssembleFile: %v", err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "abcdef" {
+		t.Fatalf("assembled %q", got)
+	}
+
+	// Overlapping chunks cover the size but not the file.
+	write(map[string]string{"0": "abcd", "1": "cdef"}, map[string]int64{"0": 0, "1": 2})
+	if _, err := recv.AssembleFile(context.Background(), sess); !errors.Is(err, ErrFileHashMismatch) {
+		t.Fatalf("expected ErrFileHashMismatch, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("mismatched output kept: %v", err)
+	}
+}
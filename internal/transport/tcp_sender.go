@@ -2,35 +2,62 @@ package transport
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
-	"time"
+	"net/url"
 
+	"github.com/deb2000-sudo/trackshift/internal/proxy"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
 	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 )
 
 // TCPSender sends chunks and associated metadata over a TCP connection.
 type TCPSender struct {
-	DialTimeout time.Duration
+	// Timeouts bounds dialing, each write and control exchanges such as
+	// SyncClock. Zero fields impose no limit.
+	Timeouts timeouts.Set
 
 	// Telemetry, if non-nil, is used to record bytes sent.
 	Telemetry *telemetry.TelemetryCollector
+
+	// SegmentSize is the amount of raw data per segment in SendStream.
+	// Defaults to DefaultSegmentSize.
+	SegmentSize int
+
+	// Limiter, if non-nil, caps the rate at which frames are written. It may
+	// be adjusted while a transfer is running.
+	Limiter *ratelimit.Limiter
+
+	// SessionID, if set, tags the control frames s sends with the session
+	// they belong to, so a receiver can tell sessions sharing a connection
+	// apart. Chunk frames carry their own session ID.
+	SessionID string
+
+	// Proxy, if set, is the SOCKS5 or HTTP proxy Connect goes through; see
+	// proxy.Parse.
+	Proxy *url.URL
 }
 
 // NewTCPSender creates a new TCPSender with sane defaults.
 func NewTCPSender() *TCPSender {
 	return &TCPSender{
-		DialTimeout: 10 * time.Second,
+		Timeouts: timeouts.Defaults(),
 	}
 }
 
-// Connect establishes a TCP connection to the given address.
-func (s *TCPSender) Connect(address string) (net.Conn, error) {
-	d := net.Dialer{Timeout: s.DialTimeout}
-	conn, err := d.Dial("tcp", address)
+// Connect establishes a TCP connection to the given address, giving up
+// when ctx is done.
+func (s *TCPSender) Connect(ctx context.Context, address string) (net.Conn, error) {
+	var dialer proxy.ContextDialer = s.Timeouts.Dialer()
+	if s.Proxy != nil {
+		dialer = &proxy.Dialer{URL: s.Proxy, Forward: dialer}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
 		return nil, fmt.Errorf("dial tcp %s: %w", address, err)
 	}
@@ -39,8 +66,12 @@ func (s *TCPSender) Connect(address string) (net.Conn, error) {
 
 // Send sends a single chunk with its metadata over an existing connection.
 // Wire format:
-//   [4 bytes metadata length][metadata JSON][8 bytes data length][data bytes]
-func (s *TCPSender) Send(conn net.Conn, chunk []byte, metadata *models.ChunkMetadata) error {
+//
+//	[4 bytes metadata length][metadata JSON][8 bytes data length][data bytes]
+func (s *TCPSender) Send(ctx context.Context, conn net.Conn, chunk []byte, metadata *models.ChunkMetadata) error {
+	if err := checkAppData(metadata); err != nil {
+		return err
+	}
 	metaBytes, err := json.Marshal(metadata)
 	if err != nil {
 		return fmt.Errorf("marshal metadata: %w", err)
@@ -65,16 +96,9 @@ func (s *TCPSender) Send(conn net.Conn, chunk []byte, metadata *models.ChunkMeta
 		return fmt.Errorf("write data: %w", err)
 	}
 
-	n, err := conn.Write(buf.Bytes())
-	if err != nil {
+	if err := s.write(ctx, conn, buf.Bytes()); err != nil {
 		return fmt.Errorf("send frame: %w", err)
 	}
 
-	if s.Telemetry != nil {
-		s.Telemetry.RecordBytesSent(n)
-	}
-
 	return nil
 }
-
-
@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+)
+
+// TimeSyncFrameID identifies clock synchronisation control frames.
+const TimeSyncFrameID = "__timesync__"
+
+// timeSyncMessage is the payload of a time sync frame. Requests carry T1,
+// replies add T2 and T3 from the receiver clock, and the final message
+// carries the sender's estimate so the receiver can correct its own
+// one-way delay measurements.
+type timeSyncMessage struct {
+	T1     time.Time     `json:"t1"`
+	T2     time.Time     `json:"t2,omitempty"`
+	T3     time.Time     `json:"t3,omitempty"`
+	Final  bool          `json:"final,omitempty"`
+	Offset time.Duration `json:"offset,omitempty"`
+	RTT    time.Duration `json:"rtt,omitempty"`
+}
+
+// sendTimeSync writes a time sync frame to conn.
+func sendTimeSync(ctx context.Context, s *TCPSender, conn net.Conn, msg timeSyncMessage) error {
+	return s.sendControl(ctx, conn, TimeSyncFrameID, msg)
+}
+
+// SyncClock runs rounds of NTP-style exchanges with the receiver on conn and
+// returns the estimated receiver clock offset and RTT. The estimate is also
+// sent to the receiver. Receivers that do not answer within timeout (if
+// positive) cause an error; callers should then assume a zero offset.
+func (s *TCPSender) SyncClock(ctx context.Context, conn net.Conn, rounds int, timeout time.Duration) (offset, rtt time.Duration, err error) {
+	if rounds <= 0 {
+		rounds = 1
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	recv := &TCPReceiver{}
+	samples := make([]telemetry.ClockSample, 0, rounds)
+	for i := 0; i < rounds; i++ {
+		t1 := time.Now()
+		if err := sendTimeSync(ctx, s, conn, timeSyncMessage{T1: t1}); err != nil {
+			return 0, 0, fmt.Errorf("send time sync: %w", err)
+		}
+		if err := conn.SetReadDeadline(timeouts.Deadline(timeout)); err != nil {
+			return 0, 0, err
+		}
+		data, meta, err := recv.Receive(ctx, conn)
+		if err != nil {
+			return 0, 0, fmt.Errorf("read time sync reply: %w", err)
+		}
+		t4 := time.Now()
+		if meta.ID != TimeSyncFrameID {
+			return 0, 0, fmt.Errorf("unexpected frame %q during time sync", meta.ID)
+		}
+		var reply timeSyncMessage
+		if err := json.Unmarshal(data, &reply); err != nil {
+			return 0, 0, fmt.Errorf("decode time sync reply: %w", err)
+		}
+		samples = append(samples, telemetry.ClockSample{T1: reply.T1, T2: reply.T2, T3: reply.T3, T4: t4})
+	}
+
+	offset, rtt, err = telemetry.EstimateClockOffset(samples)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := sendTimeSync(ctx, s, conn, timeSyncMessage{Final: true, Offset: offset, RTT: rtt}); err != nil {
+		return 0, 0, fmt.Errorf("send time sync result: %w", err)
+	}
+	return offset, rtt, nil
+}
+
+// AnswerTimeSync handles a time sync frame received at receivedAt. Requests
+// are answered on conn; for the final message the sender's estimate of this
+// receiver's clock offset is returned with done set to true.
+func (r *TCPReceiver) AnswerTimeSync(ctx context.Context, conn net.Conn, payload []byte, receivedAt time.Time) (offset time.Duration, done bool, err error) {
+	var msg timeSyncMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return 0, false, fmt.Errorf("decode time sync frame: %w", err)
+	}
+	if msg.Final {
+		return msg.Offset, true, nil
+	}
+	msg.T2 = receivedAt
+	msg.T3 = time.Now()
+	if err := sendTimeSync(ctx, NewTCPSender(), conn, msg); err != nil {
+		return 0, false, fmt.Errorf("answer time sync: %w", err)
+	}
+	return 0, false, nil
+}
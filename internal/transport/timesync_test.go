@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSyncClockExchange(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	reported := make(chan time.Duration, 1)
+	go func() {
+		defer server.Close()
+		recv := &TCPReceiver{}
+		for {
+			meta, data, err := recv.ReceiveStream(context.Background(), server)
+			if err != nil {
+				return
+			}
+			payload, _ := io.ReadAll(data)
+			if meta.ID != TimeSyncFrameID {
+				continue
+			}
+			offset, done, err := recv.AnswerTimeSync(context.Background(), server, payload, time.Now())
+			if err != nil {
+				return
+			}
+			if done {
+				reported <- offset
+				return
+			}
+		}
+	}()
+
+	offset, rtt, err := NewTCPSender().SyncClock(context.Background(), client, 3, time.Second)
+	if err != nil {
+		t.Fatalf("SyncClock: %v", err)
+	}
+	if rtt < 0 {
+		t.Fatalf("expected non-negative rtt, got %v", rtt)
+	}
+	// Both ends share a clock here, so the offset should be tiny.
+	if offset > 50*time.Millisecond || offset < -50*time.Millisecond {
+		t.Fatalf("unexpected offset %v for a shared clock", offset)
+	}
+	if got := <-reported; got != offset {
+		t.Fatalf("receiver got offset %v, sender computed %v", got, offset)
+	}
+}
+
+func TestSyncClockTimesOutOnLegacyReceiver(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	// A legacy receiver reads frames but never answers.
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	if _, _, err := NewTCPSender().SyncClock(context.Background(), client, 1, 50*time.Millisecond); err == nil {
+		t.Fatalf("expected timeout error from silent receiver")
+	}
+}
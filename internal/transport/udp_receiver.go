@@ -1,11 +1,17 @@
 package transport
 
 import (
-	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/ipfilter"
 	"github.com/deb2000-sudo/trackshift/pkg/protocol"
 )
 
@@ -18,27 +24,72 @@ type UDPReceiver struct {
 
 	// Handler is invoked for each successfully decoded packet.
 	Handler func(p *protocol.Packet, from *net.UDPAddr)
+	// Filter, if set, drops packets from refused peers before decoding.
+	Filter *ipfilter.Filter
+	// ReplayWindow is how many sequence numbers behind the highest one seen
+	// in a session are tracked. Packets whose number was seen already or
+	// fell behind the window are dropped as replays, e.g. datagrams
+	// duplicated by a relay. Zero means DefaultReplayWindow; a negative
+	// value disables the check. It must be set before Start.
+	ReplayWindow int
+	// Events, if non-nil, receives a rejected event for every replay.
+	Events *eventlog.Logger
+
+	packets atomic.Uint64
+	replays atomic.Uint64
 }
 
-// NewUDPReceiver creates a new UDPReceiver bound to the given port.
+// UDPReceiverStats counts the packets a UDPReceiver decoded.
+type UDPReceiverStats struct {
+	Packets uint64 // packets passed to the handler
+	Replays uint64 // packets dropped as replays
+}
+
+// Stats returns the receiver's packet counts so far.
+func (r *UDPReceiver) Stats() UDPReceiverStats {
+	return UDPReceiverStats{Packets: r.packets.Load(), Replays: r.replays.Load()}
+}
+
+// NewUDPReceiver creates a new UDPReceiver bound to the given port on all
+// addresses, IPv4 and IPv6.
 func NewUDPReceiver(port int) (*UDPReceiver, error) {
-	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort("", fmt.Sprintf("%d", port)))
+	return NewUDPReceiverAddr("udp", net.JoinHostPort("", strconv.Itoa(port)))
+}
+
+// NewUDPReceiverAddr creates a new UDPReceiver bound to addr, a host:port
+// address whose host may be empty or a bracketed IPv6 literal, on network
+// "udp", "udp4" or "udp6"; see Network.
+func NewUDPReceiverAddr(network, addr string) (*UDPReceiver, error) {
+	laddr, err := net.ResolveUDPAddr(network, addr)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := net.ListenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
 	return &UDPReceiver{
-		addr:   addr,
+		addr:   laddr,
 		conn:   conn,
 		closed: make(chan struct{}),
 	}, nil
 }
 
+// Addr returns the address the receiver is bound to, including the port
+// chosen by the system when it was created with port 0.
+func (r *UDPReceiver) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
 // Start begins the main receive loop in a background goroutine.
 func (r *UDPReceiver) Start() {
+	var replay *replayGuard
+	switch {
+	case r.ReplayWindow == 0:
+		replay = newReplayGuard(DefaultReplayWindow)
+	case r.ReplayWindow > 0:
+		replay = newReplayGuard(r.ReplayWindow)
+	}
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
@@ -54,6 +105,9 @@ func (r *UDPReceiver) Start() {
 					continue
 				}
 			}
+			if !r.Filter.Allowed(from) {
+				continue
+			}
 			raw := make([]byte, n)
 			copy(raw, buf[:n])
 			p, err := protocol.DeserializePacket(raw)
@@ -61,6 +115,19 @@ func (r *UDPReceiver) Start() {
 				log.Printf("udp packet decode error: %v", err)
 				continue
 			}
+			if replay != nil && !replay.accept(p.SessionID, p.Seq, time.Now()) {
+				r.replays.Add(1)
+				r.Events.Log(eventlog.Event{
+					Type:    eventlog.EventRejected,
+					Session: uuid.UUID(p.SessionID).String(),
+					Chunk:   strconv.FormatUint(p.ChunkID, 10),
+					Seq:     p.Seq,
+					Bytes:   int64(len(p.Payload)),
+					Cause:   eventlog.CauseReplay,
+				})
+				continue
+			}
+			r.packets.Add(1)
 			if r.Handler != nil {
 				r.Handler(p, from)
 			}
@@ -75,5 +142,3 @@ func (r *UDPReceiver) Close() error {
 	r.wg.Wait()
 	return err
 }
-
-
@@ -1,45 +1,93 @@
 package transport
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/deb2000-sudo/trackshift/internal/erasure"
+	"github.com/deb2000-sudo/trackshift/internal/eventlog"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
 	"github.com/deb2000-sudo/trackshift/pkg/models"
 	"github.com/deb2000-sudo/trackshift/pkg/protocol"
 )
 
 // UDPSenderConfig configures the UDP sender behaviour.
 type UDPSenderConfig struct {
-	RemoteAddr        string
+	RemoteAddr         string
 	MaxParallelStreams int
 	RetransmitTimeout  time.Duration
 	MaxRetries         int
 	WindowSize         int
+
+	// DataShards is the number of data shards per FEC block. The number of
+	// parity shards is derived from the observed loss rate.
+	DataShards int
+
+	// Telemetry, if non-nil, receives packet counts and NACK-reported losses
+	// and drives the adaptive FEC ratio.
+	Telemetry *telemetry.TelemetryCollector
+
+	// Events, if non-nil, receives a protocol event for every packet sent
+	// and every ACK or NACK received.
+	Events *eventlog.Logger
+
+	// Limiter, if non-nil, caps the rate at which packets are emitted. It
+	// may be adjusted while a transfer is running.
+	Limiter *ratelimit.Limiter
+
+	// Timeouts.Read bounds how long ReadFeedback waits without hearing from
+	// the receiver; Timeouts.Write bounds each packet write. Zero fields
+	// take their value from timeouts.Defaults.
+	Timeouts timeouts.Set
 }
 
 // TransferStats holds simple statistics about a transfer.
 type TransferStats struct {
-	Sent         uint64
-	Acked        uint64
-	Retransmits  uint64
-	LastRTT      time.Duration
+	Sent        uint64
+	Acked       uint64
+	Retransmits uint64
+	LastRTT     time.Duration
 }
 
 // UDPSender implements a basic sliding-window UDP sender.
 // This is intentionally conservative for the first implementation and can be
 // extended with full RTT-based congestion control later.
 type UDPSender struct {
-	cfg   UDPSenderConfig
-	conn  *net.UDPConn
+	cfg  UDPSenderConfig
+	conn *net.UDPConn
 
 	mu    sync.RWMutex
 	stats TransferStats
 
 	seqMu sync.Mutex
 	seq   uint32
+
+	// fecMu guards coders, which caches one erasure coder per parity count.
+	fecMu  sync.Mutex
+	coders map[int]*erasure.ErasureCoder
+
+	// sentAt holds send times of unacknowledged packets by sequence number,
+	// so acknowledgements can be logged with their RTT and feed telemetry.
+	// It is only populated when event logging or telemetry is enabled, and
+	// entries left unacknowledged are dropped once stale; prunedAt is when
+	// that last happened.
+	sentMu   sync.Mutex
+	sentAt   map[uint32]time.Time
+	prunedAt time.Time
 }
 
+// sentAtRTOs is how many retransmit timeouts a packet's send time is kept
+// awaiting its ACK. Later ACKs are logged without an RTT.
+const sentAtRTOs = 4
+
 // NewUDPSender creates a new UDPSender with the given config.
 func NewUDPSender(cfg UDPSenderConfig) (*UDPSender, error) {
 	if cfg.MaxParallelStreams <= 0 {
@@ -54,6 +102,10 @@ func NewUDPSender(cfg UDPSenderConfig) (*UDPSender, error) {
 	if cfg.WindowSize <= 0 {
 		cfg.WindowSize = 256
 	}
+	if cfg.DataShards <= 0 {
+		cfg.DataShards = 20
+	}
+	cfg.Timeouts = cfg.Timeouts.WithDefaults()
 
 	raddr, err := net.ResolveUDPAddr("udp", cfg.RemoteAddr)
 	if err != nil {
@@ -65,8 +117,10 @@ func NewUDPSender(cfg UDPSenderConfig) (*UDPSender, error) {
 	}
 
 	s := &UDPSender{
-		cfg:  cfg,
-		conn: conn,
+		cfg:    cfg,
+		conn:   conn,
+		coders: make(map[int]*erasure.ErasureCoder),
+		sentAt: make(map[uint32]time.Time),
 	}
 	return s, nil
 }
@@ -87,22 +141,60 @@ func (s *UDPSender) Close() error {
 // SendChunk sends a single chunk as a DATA packet.
 // For now this is a simple fire-and-forget send; higher-level reliability will
 // be handled by erasure coding and retry logic in later phases.
-func (s *UDPSender) SendChunk(sessionID [16]byte, chunkID uint64, data []byte, priority uint8) error {
+func (s *UDPSender) SendChunk(ctx context.Context, sessionID [16]byte, chunkID uint64, data []byte, priority uint8) error {
+	return s.sendPacket(ctx, sessionID, chunkID, data, priority)
+}
+
+// Route asks the relay at RemoteAddr to forward the session's packets
+// through hops, the further relays to chain through, if any, then the
+// receiver, and the receiver's feedback back here. Senders going through a
+// relay send it before the session's data; datagrams may be lost, so it is
+// worth repeating until feedback arrives.
+func (s *UDPSender) Route(sessionID [16]byte, hops ...string) error {
+	raw, err := protocol.SerializePacket(&protocol.Packet{
+		Version:   protocol.CurrentVersion,
+		Type:      protocol.PacketTypeRoute,
+		SessionID: sessionID,
+		Payload:   protocol.EncodeRoute(hops...),
+	})
+	if err != nil {
+		return err
+	}
+	if s.cfg.Timeouts.Write > 0 {
+		if err := s.conn.SetWriteDeadline(timeouts.Deadline(s.cfg.Timeouts.Write)); err != nil {
+			return err
+		}
+	}
+	_, err = s.conn.Write(raw)
+	return err
+}
+
+// sendPacket wraps payload in a DATA packet and writes it, unless ctx is
+// done before the Limiter lets it go.
+func (s *UDPSender) sendPacket(ctx context.Context, sessionID [16]byte, chunkID uint64, payload []byte, priority uint8) error {
 	seq := s.nextSeq()
 	p := &protocol.Packet{
-		Version:   1,
+		Version:   protocol.CurrentVersion,
 		Type:      protocol.PacketTypeData,
 		SessionID: sessionID,
 		ChunkID:   chunkID,
 		Seq:       seq,
 		Priority:  priority,
-		Payload:   data,
+		Payload:   payload,
 	}
 	raw, err := protocol.SerializePacket(p)
 	if err != nil {
 		return err
 	}
 
+	if err := s.cfg.Limiter.WaitN(ctx, len(raw)); err != nil {
+		return err
+	}
+	if s.cfg.Timeouts.Write > 0 {
+		if err := s.conn.SetWriteDeadline(timeouts.Deadline(s.cfg.Timeouts.Write)); err != nil {
+			return err
+		}
+	}
 	n, err := s.conn.Write(raw)
 	if err != nil {
 		return err
@@ -111,9 +203,225 @@ func (s *UDPSender) SendChunk(sessionID [16]byte, chunkID uint64, data []byte, p
 	s.mu.Lock()
 	s.stats.Sent += uint64(n)
 	s.mu.Unlock()
+	if s.cfg.Telemetry != nil {
+		s.cfg.Telemetry.RecordPacketsSent(1)
+		s.cfg.Telemetry.RecordBytesSent(n)
+	}
+	now := time.Now()
+	if s.cfg.Events != nil || s.cfg.Telemetry != nil {
+		s.sentMu.Lock()
+		s.sentAt[seq] = now
+		s.pruneSentAt(now)
+		s.sentMu.Unlock()
+	}
+	if s.cfg.Events != nil {
+		s.cfg.Events.Log(eventlog.Event{
+			Time:    now,
+			Type:    eventlog.EventSent,
+			Session: uuid.UUID(sessionID).String(),
+			Chunk:   strconv.FormatUint(chunkID, 10),
+			Seq:     seq,
+			Bytes:   int64(n),
+		})
+	}
+	return nil
+}
+
+// pruneSentAt drops the send times of packets unacknowledged for
+// sentAtRTOs retransmit timeouts, at most once per timeout, so a receiver
+// that sends no ACKs cannot grow sentAt without bound. s.sentMu must be held.
+func (s *UDPSender) pruneSentAt(now time.Time) {
+	rto := s.cfg.RetransmitTimeout
+	if now.Sub(s.prunedAt) < rto {
+		return
+	}
+	s.prunedAt = now
+	cutoff := now.Add(-sentAtRTOs * rto)
+	for seq, t := range s.sentAt {
+		if t.Before(cutoff) {
+			delete(s.sentAt, seq)
+		}
+	}
+}
+
+// takeSentAt removes and returns the send time of seq, if known.
+func (s *UDPSender) takeSentAt(seq uint32) (time.Time, bool) {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	t, ok := s.sentAt[seq]
+	delete(s.sentAt, seq)
+	return t, ok
+}
+
+// ParityShards returns the parity shard count currently used per FEC block,
+// based on the loss rate reported to telemetry over its recent window, so
+// parity drops again once a burst of loss has passed.
+func (s *UDPSender) ParityShards() int {
+	var loss float64
+	if s.cfg.Telemetry != nil {
+		loss = s.cfg.Telemetry.WindowLossRate()
+	}
+	return erasure.ParityShardsForLoss(s.cfg.DataShards, loss)
+}
+
+// coder returns a cached erasure coder for the given parity count.
+func (s *UDPSender) coder(parity int) (*erasure.ErasureCoder, error) {
+	if ec, ok := s.coders[parity]; ok {
+		return ec, nil
+	}
+	ec, err := erasure.NewErasureCoder(s.cfg.DataShards, parity)
+	if err != nil {
+		return nil, err
+	}
+	s.coders[parity] = ec
+	return ec, nil
+}
+
+// SendChunkFEC sends data as erasure-coded shards, one shard per packet.
+// The chunk is split into blocks of DataShards shards and the parity count is
+// re-evaluated for every block, so protection follows the observed loss rate:
+// none on clean links, more as loss grows. It stops once ctx is done.
+func (s *UDPSender) SendChunkFEC(ctx context.Context, sessionID [16]byte, chunkID uint64, data []byte, priority uint8) error {
+	dataShards := s.cfg.DataShards
+	if dataShards > 255 {
+		return fmt.Errorf("data shards %d exceeds 255", dataShards)
+	}
+	blockSize := dataShards * protocol.MaxShardSize
+
+	for block, off := 0, 0; off < len(data); block, off = block+1, off+blockSize {
+		end := off + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		shards, parity, err := s.encodeBlock(data[off:end])
+		if err != nil {
+			return fmt.Errorf("encode block %d: %w", block, err)
+		}
+		hdr := protocol.ShardHeader{
+			Block:        uint32(block),
+			DataShards:   uint8(dataShards),
+			ParityShards: uint8(parity),
+			BlockLen:     uint32(end - off),
+		}
+		for i, shard := range shards {
+			hdr.Index = uint8(i)
+			if err := s.sendPacket(ctx, sessionID, chunkID, protocol.EncodeShard(hdr, shard), priority); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// encodeBlock splits one block into data shards plus the parity shards
+// currently warranted by the loss rate.
+func (s *UDPSender) encodeBlock(block []byte) ([][]byte, int, error) {
+	parity := s.ParityShards()
+	if parity == 0 {
+		return splitShards(block, s.cfg.DataShards), 0, nil
+	}
+
+	s.fecMu.Lock()
+	defer s.fecMu.Unlock()
+	ec, err := s.coder(parity)
+	if err != nil {
+		return nil, 0, err
+	}
+	ec.CalculateShardSize(int64(len(block)))
+	shards, err := ec.Encode(block)
+	if err != nil {
+		return nil, 0, err
+	}
+	return shards, parity, nil
+}
+
+// splitShards splits data into n equally sized, zero-padded shards.
+func splitShards(data []byte, n int) [][]byte {
+	size := (len(data) + n - 1) / n
+	shards := make([][]byte, n)
+	for i := range shards {
+		shard := make([]byte, size)
+		start := i * size
+		if start < len(data) {
+			end := start + size
+			if end > len(data) {
+				end = len(data)
+			}
+			copy(shard, data[start:end])
+		}
+		shards[i] = shard
+	}
+	return shards
+}
+
+// HandleFeedback processes an ACK or NACK packet from the receiver. NACKed
+// sequence numbers are reported to telemetry as lost packets.
+func (s *UDPSender) HandleFeedback(p *protocol.Packet) error {
+	var rtt time.Duration
+	switch p.Type {
+	case protocol.PacketTypeAck:
+		s.mu.Lock()
+		s.stats.Acked++
+		s.mu.Unlock()
+		if sent, ok := s.takeSentAt(p.Seq); ok {
+			rtt = time.Since(sent)
+			if s.cfg.Telemetry != nil {
+				s.cfg.Telemetry.RecordRTT(rtt)
+			}
+		}
+		if s.cfg.Events != nil {
+			s.cfg.Events.Log(eventlog.Event{
+				Type:       eventlog.EventAcked,
+				Session:    uuid.UUID(p.SessionID).String(),
+				Chunk:      strconv.FormatUint(p.ChunkID, 10),
+				Seq:        p.Seq,
+				DurationMs: eventlog.Millis(rtt),
+			})
+		}
+	case protocol.PacketTypeNack:
+		seqs, err := protocol.DecodeNack(p.Payload)
+		if err != nil {
+			return err
+		}
+		if s.cfg.Telemetry != nil {
+			s.cfg.Telemetry.RecordPacketsLost(len(seqs))
+		}
+		for _, seq := range seqs {
+			s.takeSentAt(seq)
+			if s.cfg.Events != nil {
+				s.cfg.Events.Log(eventlog.Event{
+					Type:    eventlog.EventNacked,
+					Session: uuid.UUID(p.SessionID).String(),
+					Chunk:   strconv.FormatUint(p.ChunkID, 10),
+					Seq:     seq,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// ReadFeedback reads ACK/NACK packets from the receiver until ctx is done,
+// the sender is closed or, with a read timeout, the receiver has been
+// silent for that long. It is typically run in its own goroutine.
+func (s *UDPSender) ReadFeedback(ctx context.Context) {
+	buf := make([]byte, 64*1024+256)
+	for {
+		if err := s.conn.SetReadDeadline(timeouts.Deadline(s.cfg.Timeouts.Read)); err != nil {
+			return
+		}
+		n, err := interruptible(ctx, s.conn.SetReadDeadline, func() (int, error) { return s.conn.Read(buf) })
+		if err != nil {
+			return
+		}
+		p, err := protocol.DeserializePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		_ = s.HandleFeedback(p)
+	}
+}
+
 // GetStats returns a snapshot of current stats.
 func (s *UDPSender) GetStats() TransferStats {
 	s.mu.RLock()
@@ -132,5 +440,3 @@ func ChunkPriority(meta *models.ChunkMetadata) uint8 {
 	}
 	return 3
 }
-
-
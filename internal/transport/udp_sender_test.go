@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/telemetry"
+)
+
+func TestSentAtPrunedWithoutAcks(t *testing.T) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The receiver never answers, so no send time is taken by an ACK.
+	s, err := NewUDPSender(UDPSenderConfig{
+		RemoteAddr:        ln.LocalAddr().String(),
+		RetransmitTimeout: 10 * time.Millisecond,
+		Telemetry:         telemetry.NewTelemetryCollector(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var sid [16]byte
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := s.SendChunk(context.Background(), sid, uint64(i), []byte("x"), 0); err != nil {
+				t.Fatalf("SendChunk: %v", err)
+			}
+		}
+	}
+	send(100)
+	time.Sleep(sentAtRTOs*10*time.Millisecond + 10*time.Millisecond)
+	send(1)
+
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	if len(s.sentAt) != 1 {
+		t.Fatalf("sentAt holds %d send times, want only the packet sent since", len(s.sentAt))
+	}
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/timeouts"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+)
+
+// A receiver answers a file metadata frame announcing protocol v12 or later
+// with a version frame naming the version it picked for the session, which
+// the sender then speaks. Receivers predating v12 send nothing back, so a
+// sender that hears nothing falls back to v1, which every receiver reads.
+const VersionFrameID = "__version__"
+
+// VersionReply is the payload of a version frame.
+type VersionReply struct {
+	Version uint8 `json:"version"`
+}
+
+// SendVersion answers a file metadata frame on conn with the version picked
+// for the session.
+func (s *TCPSender) SendVersion(ctx context.Context, conn net.Conn, version uint8) error {
+	return s.sendControl(ctx, conn, VersionFrameID, VersionReply{Version: version})
+}
+
+// DecodeVersion parses the payload of a version frame.
+func DecodeVersion(payload []byte) (VersionReply, error) {
+	var reply VersionReply
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return VersionReply{}, fmt.Errorf("invalid version frame: %w", err)
+	}
+	return reply, nil
+}
+
+// AwaitVersion waits for the version frame answering a file metadata frame
+// that announced version announced, and returns the version to speak. If
+// nothing arrives within s.Timeouts.Handshake, the receiver predates the
+// exchange: AwaitVersion returns protocol.Version1 with answered false.
+// Like SyncClock it must finish before ReadControl starts reading from conn.
+func (s *TCPSender) AwaitVersion(ctx context.Context, conn net.Conn, announced uint8) (version uint8, answered bool, err error) {
+	// Without a bound a sender would wait on an older receiver for good.
+	wait := s.Timeouts.Handshake
+	if wait <= 0 {
+		wait = timeouts.Defaults().Handshake
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return 0, false, err
+	}
+	recv := &TCPReceiver{}
+	for {
+		data, meta, err := recv.Receive(ctx, conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return protocol.Version1, false, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("read version frame: %w", err)
+		}
+		if meta.ID != VersionFrameID {
+			continue
+		}
+		reply, err := DecodeVersion(data)
+		if err != nil {
+			return 0, false, err
+		}
+		if err := protocol.CheckVersion(reply.Version); err != nil {
+			return 0, false, err
+		}
+		if reply.Version > announced {
+			return 0, false, fmt.Errorf("receiver picked protocol v%d, above the v%d announced", reply.Version, announced)
+		}
+		return reply.Version, true, nil
+	}
+}
@@ -0,0 +1,124 @@
+// Package xattr reads and restores extended file attributes, such as user
+// metadata and macOS resource forks (com.apple.ResourceFork), so they can
+// travel with a transfer. NTFS alternate data streams are not supported.
+package xattr
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrNotSupported is returned when attributes cannot be set on this platform
+// or file system.
+var ErrNotSupported = errors.New("extended attributes not supported")
+
+// DefaultExclude lists the namespaces owned by the kernel or security
+// modules, which are neither meaningful on another host nor settable by
+// unprivileged users.
+var DefaultExclude = []string{"security.*", "system.*", "trusted.*"}
+
+// Filter selects attributes by name using path.Match patterns. An attribute
+// is selected if it matches any Include pattern (or Include is empty) and no
+// Exclude pattern.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// ParseFilter builds a Filter from comma-separated include and exclude
+// pattern lists.
+func ParseFilter(include, exclude string) (Filter, error) {
+	f := Filter{Include: splitList(include), Exclude: splitList(exclude)}
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return Filter{}, fmt.Errorf("invalid attribute pattern %q: %w", p, err)
+		}
+	}
+	return f, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Match reports whether the attribute name is selected.
+func (f Filter) Match(name string) bool {
+	for _, p := range f.Exclude {
+		if ok, _ := path.Match(p, name); ok {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the selected subset of attrs, or nil if none are selected.
+func (f Filter) Select(attrs map[string][]byte) map[string][]byte {
+	var out map[string][]byte
+	for name, v := range attrs {
+		if f.Match(name) {
+			if out == nil {
+				out = make(map[string][]byte)
+			}
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// Read returns the attributes of the file at p selected by f, or nil if it
+// has none. Platforms without extended attributes report none.
+func Read(p string, f Filter) (map[string][]byte, error) {
+	names, err := list(p)
+	if err != nil {
+		return nil, fmt.Errorf("list attributes of %s: %w", p, err)
+	}
+	var out map[string][]byte
+	for _, name := range names {
+		if !f.Match(name) {
+			continue
+		}
+		v, err := get(p, name)
+		if err != nil {
+			return nil, fmt.Errorf("read attribute %s of %s: %w", name, p, err)
+		}
+		if out == nil {
+			out = make(map[string][]byte)
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+// Write sets attrs on the file at p, in name order. All attributes are
+// attempted; the errors of those that could not be set are joined.
+func Write(p string, attrs map[string][]byte) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := set(p, name, attrs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("set attribute %s on %s: %w", name, p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
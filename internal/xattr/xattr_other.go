@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package xattr
+
+func list(p string) ([]string, error) { return nil, nil }
+
+func get(p, name string) ([]byte, error) { return nil, ErrNotSupported }
+
+func set(p, name string, value []byte) error { return ErrNotSupported }
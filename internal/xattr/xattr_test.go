@@ -0,0 +1,56 @@
+package xattr
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := ParseFilter("user.*, com.apple.*", "user.secret*,security.*")
+	if err != nil {
+		t.Fatalf("ParseFilter: %v", err)
+	}
+	cases := map[string]bool{
+		"user.comment":            true,
+		"com.apple.ResourceFork":  true,
+		"user.secret.key":         false,
+		"security.selinux":        false,
+		"trusted.overlay.opaque":  false,
+		"com.apple.quarantine.ok": true,
+	}
+	for name, want := range cases {
+		if got := f.Match(name); got != want {
+			t.Fatalf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got := (Filter{}).Select(map[string][]byte{"a": nil}); len(got) != 1 {
+		t.Fatalf("empty filter should select everything, got %v", got)
+	}
+	if _, err := ParseFilter("[", ""); err == nil {
+		t.Fatal("expected error for a malformed pattern")
+	}
+}
+
+func TestReadWriteRoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	attrs := map[string][]byte{"user.color": []byte("red"), "user.empty": {}}
+	if err := Write(p, attrs); err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			t.Skip("file system does not support extended attributes")
+		}
+		t.Fatalf("Write: %v", err)
+	}
+
+	got, err := Read(p, Filter{Include: []string{"user.*"}, Exclude: []string{"user.empty"}})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(got) != 1 || string(got["user.color"]) != "red" {
+		t.Fatalf("Read = %v", got)
+	}
+}
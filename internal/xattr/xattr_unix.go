@@ -0,0 +1,62 @@
+//go:build linux || darwin
+
+package xattr
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func list(p string) ([]string, error) {
+	buf, err := readSized(func(b []byte) (int, error) { return unix.Listxattr(p, b) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range bytes.Split(buf, []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+	return names, nil
+}
+
+func get(p, name string) ([]byte, error) {
+	return readSized(func(b []byte) (int, error) { return unix.Getxattr(p, name, b) })
+}
+
+func set(p, name string, value []byte) error {
+	err := unix.Setxattr(p, name, value, 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrNotSupported
+	}
+	return err
+}
+
+// readSized calls read with a nil buffer to learn the size, then with a
+// buffer of that size, retrying if the value grew in between.
+func readSized(read func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n)
+		n, err = read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
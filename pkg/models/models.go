@@ -2,6 +2,8 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
@@ -26,33 +28,160 @@ const (
 	SessionStatusFailed       SessionStatus = "failed"
 )
 
+// SessionControl is an action requested of a session's endpoints through
+// the orchestrator, which they carry out when they next report progress.
+type SessionControl string
+
+const (
+	// SessionControlPause asks the sender to pause after the current
+	// chunk. Clearing it resumes the transfer.
+	SessionControlPause SessionControl = "pause"
+	// SessionControlCancel asks the sender to stop for good.
+	SessionControlCancel SessionControl = "cancel"
+)
+
+// Compression algorithms recorded per chunk. An empty value means the chunk
+// was produced by a sender that always applied zstd.
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+// ArchiveTar marks a transferred file as a tar archive generated by the
+// sender from a directory.
+const ArchiveTar = "tar"
+
+// OriginTus marks a session received as a tus upload over HTTP rather than
+// from a trackshift sender; see TransferSession.Origin.
+const OriginTus = "tus"
+
+// ErrNoFileHash is returned by FileMetadata.Validate for a file without a
+// hash.
+var ErrNoFileHash = errors.New("file hash must not be empty")
+
 // FileMetadata describes the file being transferred.
 type FileMetadata struct {
+	// ID identifies the file among the files of a multi-file session; see
+	// TransferSession.Files. It is empty for the file of a single-file
+	// session and for the stream describing a multi-file one.
+	ID       string `json:"id,omitempty"`
 	Name     string `json:"name"`
 	Size     int64  `json:"size"`
 	Hash     string `json:"hash"`      // hex-encoded SHA-256 of full file
 	MimeType string `json:"mime_type"` // optional, best-effort
+
+	// ProtocolVersion is announced by the sender in the file metadata frame.
+	// Zero means the sender predates version negotiation.
+	ProtocolVersion uint8 `json:"protocol_version,omitempty"`
+
+	// Reservation, if set, names the capacity reservation on the receiver
+	// the transfer claims; see package reservation.
+	Reservation string `json:"reservation,omitempty"`
+
+	// Archive is set when the sender packed a directory into the file, so
+	// the receiver may unpack it. The only value is ArchiveTar.
+	Archive string `json:"archive,omitempty"`
+
+	// Xattrs holds the file's extended attributes when the sender was asked
+	// to preserve them. Values are base64-encoded in JSON.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+
+	// Offset is the position of the file's data in the session stream of a
+	// multi-file session.
+	Offset int64 `json:"offset,omitempty"`
+
+	// SenderSession is the sender's ID for the session, announced in the
+	// file metadata frame so a receiver can pick up its own session for an
+	// earlier attempt.
+	SenderSession string `json:"sender_session,omitempty"`
+}
+
+// ManifestEntry describes one file or directory of a directory transfer.
+type ManifestEntry struct {
+	Path   string `json:"path"`           // slash-separated, relative to the transfer root
+	Dir    bool   `json:"dir,omitempty"`  // true for directories, which carry no data
+	Size   int64  `json:"size"`           // file size in bytes
+	Mode   uint32 `json:"mode"`           // permission bits
+	Hash   string `json:"hash,omitempty"` // hex-encoded SHA-256 of the file
+	Offset int64  `json:"offset"`         // position of the file's data in the session stream
+
+	Xattrs map[string][]byte `json:"xattrs,omitempty"` // extended attributes, if preserved
+}
+
+// Manifest lists the contents of a directory transfer. File data is sent as a
+// single stream in manifest order, so chunking and resume work exactly as for
+// a single file; FileMetadata then describes that stream.
+type Manifest struct {
+	Root    string          `json:"root"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// Files returns the files of m, in stream order, as the file list of a
+// multi-file session. Each file's ID is its path.
+func (m *Manifest) Files() []FileMetadata {
+	var files []FileMetadata
+	for _, e := range m.Entries {
+		if e.Dir {
+			continue
+		}
+		files = append(files, FileMetadata{
+			ID:     e.Path,
+			Name:   e.Path,
+			Size:   e.Size,
+			Hash:   e.Hash,
+			Offset: e.Offset,
+			Xattrs: e.Xattrs,
+		})
+	}
+	return files
+}
+
+// TotalSize returns the length of the session stream described by m.
+func (m *Manifest) TotalSize() int64 {
+	var n int64
+	for _, e := range m.Entries {
+		n += e.Size
+	}
+	return n
 }
 
 // ChunkMetadata describes a single chunk of a file.
 type ChunkMetadata struct {
-	ID         string       `json:"id"`
-	Size       int64        `json:"size"`
-	Offset     int64        `json:"offset"`
-	SHA256     string       `json:"sha256"`      // hex-encoded SHA-256 of the chunk
-	IsParity   bool         `json:"is_parity"`   // true for parity chunks when erasure coding enabled
-	Status     ChunkStatus  `json:"status"`      // current status of this chunk
-	UpdatedAt  time.Time    `json:"updated_at"`  // last status change time
-	CreatedAt  time.Time    `json:"created_at"`  // creation time
-	SessionID  string       `json:"session_id"`  // owning session
-	Priority   int          `json:"priority"`    // used by priority sender
-	RetryCount int          `json:"retry_count"` // number of send retries
-	Error      string       `json:"error"`       // last error, if any
+	ID          string      `json:"id"`
+	Size        int64       `json:"size"`
+	Offset      int64       `json:"offset"`
+	SHA256      string      `json:"sha256"`                // hex-encoded SHA-256 of the chunk
+	IsParity    bool        `json:"is_parity"`             // true for parity chunks when erasure coding enabled
+	Status      ChunkStatus `json:"status"`                // current status of this chunk
+	UpdatedAt   time.Time   `json:"updated_at"`            // last status change time
+	CreatedAt   time.Time   `json:"created_at"`            // creation time
+	SessionID   string      `json:"session_id"`            // owning session
+	Priority    int         `json:"priority"`              // used by priority sender
+	RetryCount  int         `json:"retry_count"`           // number of send retries
+	Error       string      `json:"error"`                 // last error, if any
+	Compression string      `json:"compression,omitempty"` // compression applied on the wire ("zstd", "none")
+	SentAt      time.Time   `json:"sent_at,omitempty"`     // sender clock when the chunk was put on the wire
+
+	// FileID is the ID of the file the chunk starts in, in a multi-file
+	// session. A chunk of a session stream may run on into the next files.
+	FileID string `json:"file_id,omitempty"`
+
+	// AppData is opaque application metadata attached by the sender, such
+	// as record boundaries or a media segment index. It travels in the chunk
+	// header and is handed to receiver-side hooks untouched. At most
+	// MaxAppDataSize bytes.
+	AppData []byte `json:"app_data,omitempty"`
 }
 
+// MaxAppDataSize bounds ChunkMetadata.AppData, which is repeated in every
+// frame header and session file.
+const MaxAppDataSize = 1024
+
 // TransferSession tracks the state of a file transfer.
 type TransferSession struct {
-	ID            string                    `json:"id"`
+	ID string `json:"id"`
+	// File describes the data transferred. For a multi-file session it
+	// describes the stream the files are concatenated into.
 	File          FileMetadata              `json:"file"`
 	Status        SessionStatus             `json:"status"`
 	Chunks        map[string]*ChunkMetadata `json:"chunks"`          // chunkID -> metadata
@@ -64,6 +193,149 @@ type TransferSession struct {
 	Failed        int                       `json:"failed"`
 	BytesSent     int64                     `json:"bytes_sent"`
 	BytesReceived int64                     `json:"bytes_received"`
+
+	// ProtocolVersion is the wire version the session was started with.
+	// Resumes keep using it so an upgrade never changes the format mid-session.
+	ProtocolVersion uint8 `json:"protocol_version,omitempty"`
+
+	// ChunkSize is the size the sender cuts the file's chunks to. Resumes
+	// keep it so the chunks match those sent before. It is zero for
+	// receiver sessions and adaptively chunked ones.
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+
+	// Files lists the files of a multi-file session in stream order, each
+	// with an ID and its offset in the stream. It is empty for single-file
+	// sessions.
+	Files []FileMetadata `json:"files,omitempty"`
+
+	// Manifest is set for directory transfers and lists the files whose
+	// concatenated contents make up the transferred stream.
+	Manifest *Manifest `json:"manifest,omitempty"`
+
+	// Route is the path the session last reached its receiver by. Resumes
+	// try it first so relay-side state such as NAT mappings stays valid.
+	Route *Route `json:"route,omitempty"`
+
+	// OutputPath, if set, is where the receiver writes the file (or
+	// recreates the directory) instead of its default output location.
+	OutputPath string `json:"output_path,omitempty"`
+
+	// BranchOf is the session this one was branched from to send the same
+	// data to another destination; see session.SessionManager.BranchSession.
+	BranchOf string `json:"branch_of,omitempty"`
+
+	// SchemaVersion is the layout of the session file the session was
+	// persisted in; see session.SchemaVersion.
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	// Control is the action the orchestrator last asked the session's
+	// endpoints to take, if it is still pending.
+	Control SessionControl `json:"control,omitempty"`
+
+	// Origin is OriginTus for a session received as a tus upload, and empty
+	// for one received from a sender. A tus upload's file hash is unknown
+	// until all of it arrived, when the receiver computes it.
+	Origin string `json:"origin,omitempty"`
+}
+
+// AllFiles returns the files of s: its file list, or its single file.
+func (s *TransferSession) AllFiles() []FileMetadata {
+	if len(s.Files) > 0 {
+		return s.Files
+	}
+	return []FileMetadata{s.File}
+}
+
+// FileAt returns the file holding byte offset of the session stream.
+// Empty files hold no bytes and are never returned.
+func (s *TransferSession) FileAt(offset int64) (*FileMetadata, bool) {
+	if len(s.Files) == 0 {
+		if offset < 0 || offset >= s.File.Size {
+			return nil, false
+		}
+		return &s.File, true
+	}
+	// The last file starting at or before offset, skipping empty files
+	// that share its offset.
+	i := sort.Search(len(s.Files), func(i int) bool { return s.Files[i].Offset > offset }) - 1
+	for ; i >= 0; i-- {
+		f := &s.Files[i]
+		if offset < f.Offset+f.Size {
+			return f, true
+		}
+		if f.Size > 0 {
+			break
+		}
+	}
+	return nil, false
+}
+
+// FileIDAt returns the ID of the file holding byte offset of a multi-file
+// session's stream, for ChunkMetadata.FileID. It is empty for single-file
+// sessions.
+func (s *TransferSession) FileIDAt(offset int64) string {
+	if len(s.Files) == 0 {
+		return ""
+	}
+	if f, ok := s.FileAt(offset); ok {
+		return f.ID
+	}
+	return ""
+}
+
+// FileChunks returns the chunks of s holding data of the file with the given
+// ID, ordered by offset. They include chunks that start in earlier files.
+func (s *TransferSession) FileChunks(id string) []*ChunkMetadata {
+	var f *FileMetadata
+	for i := range s.Files {
+		if s.Files[i].ID == id {
+			f = &s.Files[i]
+			break
+		}
+	}
+	if f == nil || f.Size == 0 {
+		return nil
+	}
+	var out []*ChunkMetadata
+	for _, c := range s.Chunks {
+		if c.Offset < f.Offset+f.Size && f.Offset < c.Offset+c.Size {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Offset < out[j].Offset })
+	return out
+}
+
+// Route describes how a sender reaches its receiver.
+type Route struct {
+	// Relay is the address of the relay the sender connects to, or empty
+	// for a direct connection.
+	Relay string `json:"relay,omitempty"`
+	// Receiver is the address of the final receiver.
+	Receiver string `json:"receiver"`
+	// Since is when the session started using this route.
+	Since time.Time `json:"since"`
+}
+
+// FirstHop returns the address a sender connects to for r.
+func (r Route) FirstHop() string {
+	if r.Relay != "" {
+		return r.Relay
+	}
+	return r.Receiver
+}
+
+// Same reports whether r and o take the same path.
+func (r Route) Same(o Route) bool {
+	return r.Relay == o.Relay && r.Receiver == o.Receiver
+}
+
+// String renders r for logs, e.g. "relay 10.0.0.2:9001 -> 10.0.0.9:8080".
+func (r Route) String() string {
+	if r.Relay == "" {
+		return "direct -> " + r.Receiver
+	}
+	return "relay " + r.Relay + " -> " + r.Receiver
 }
 
 // Validate validates the FileMetadata.
@@ -75,7 +347,7 @@ func (f *FileMetadata) Validate() error {
 		return errors.New("file size must be greater than zero")
 	}
 	if f.Hash == "" {
-		return errors.New("file hash must not be empty")
+		return ErrNoFileHash
 	}
 	return nil
 }
@@ -94,6 +366,9 @@ func (c *ChunkMetadata) Validate() error {
 	if c.SHA256 == "" {
 		return errors.New("chunk sha256 must not be empty")
 	}
+	if len(c.AppData) > MaxAppDataSize {
+		return fmt.Errorf("chunk app data is %d bytes, limit is %d", len(c.AppData), MaxAppDataSize)
+	}
 	switch c.Status {
 	case ChunkStatusPending, ChunkStatusInProgress, ChunkStatusCompleted, ChunkStatusFailed:
 	default:
@@ -107,7 +382,7 @@ func (s *TransferSession) Validate() error {
 	if s.ID == "" {
 		return errors.New("session id must not be empty")
 	}
-	if err := s.File.Validate(); err != nil {
+	if err := s.File.Validate(); err != nil && !(errors.Is(err, ErrNoFileHash) && s.Origin == OriginTus) {
 		return err
 	}
 	switch s.Status {
@@ -128,4 +403,33 @@ func (s *TransferSession) Validate() error {
 	return nil
 }
 
+// ValidateFiles checks the file list of a multi-file session: every file
+// has a unique ID and a name, and the files follow each other in the
+// session stream without overlapping or running past its end. It is not
+// part of Validate, which runs on every chunk update.
+func (s *TransferSession) ValidateFiles() error {
+	ids := make(map[string]bool, len(s.Files))
+	var end int64
+	for i, f := range s.Files {
+		switch {
+		case f.ID == "":
+			return fmt.Errorf("file %d: id must not be empty", i)
+		case ids[f.ID]:
+			return fmt.Errorf("file %d: duplicate id %q", i, f.ID)
+		case f.Name == "":
+			return fmt.Errorf("file %s: name must not be empty", f.ID)
+		case f.Size < 0:
+			return fmt.Errorf("file %s: size must be non-negative", f.ID)
+		case f.Offset < end:
+			return fmt.Errorf("file %s: offset %d overlaps the previous file", f.ID, f.Offset)
+		}
+		ids[f.ID] = true
+		end = f.Offset + f.Size
+	}
+	if end > s.File.Size {
+		return fmt.Errorf("files end at %d, past the %d-byte session stream", end, s.File.Size)
+	}
+	return nil
+}
+
 
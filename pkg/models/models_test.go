@@ -1,6 +1,9 @@
 package models
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestFileMetadataValidate(t *testing.T) {
 	f := FileMetadata{
@@ -55,6 +58,96 @@ func TestTransferSessionValidate(t *testing.T) {
 	if err := s.Validate(); err == nil {
 		t.Fatalf("expected error for invalid status")
 	}
+
+	// A tus upload is hashed once it is complete.
+	s.Status, s.File.Hash = SessionStatusCreated, ""
+	if err := s.Validate(); err == nil {
+		t.Fatalf("expected error for a session without a file hash")
+	}
+	s.Origin = OriginTus
+	if err := s.Validate(); err != nil {
+		t.Fatalf("expected valid tus upload without a file hash, got error: %v", err)
+	}
 }
 
+func TestRoute(t *testing.T) {
+	direct := Route{Receiver: "10.0.0.9:8080"}
+	relayed := Route{Relay: "10.0.0.2:9001", Receiver: "10.0.0.9:8080"}
+
+	if got := direct.FirstHop(); got != "10.0.0.9:8080" {
+		t.Fatalf("direct first hop = %q", got)
+	}
+	if got := relayed.FirstHop(); got != "10.0.0.2:9001" {
+		t.Fatalf("relayed first hop = %q", got)
+	}
+	if direct.Same(relayed) {
+		t.Fatalf("direct and relayed routes reported the same")
+	}
+	if !relayed.Same(Route{Relay: "10.0.0.2:9001", Receiver: "10.0.0.9:8080", Since: time.Now()}) {
+		t.Fatalf("routes differing only in Since reported different")
+	}
+	if got := relayed.String(); got != "relay 10.0.0.2:9001 -> 10.0.0.9:8080" {
+		t.Fatalf("String() = %q", got)
+	}
+}
+
+func TestMultiFileSession(t *testing.T) {
+	m := &Manifest{Entries: []ManifestEntry{
+		{Path: "a", Dir: true},
+		{Path: "a/one", Size: 10, Offset: 0},
+		{Path: "a/empty", Size: 0, Offset: 10},
+		{Path: "a/two", Size: 5, Offset: 10},
+	}}
+	s := &TransferSession{
+		File:  FileMetadata{Name: "a.tar", Size: 15, Hash: "abc"},
+		Files: m.Files(),
+		Chunks: map[string]*ChunkMetadata{
+			"c1": {ID: "c1", Offset: 0, Size: 8},
+			"c2": {ID: "c2", Offset: 8, Size: 7},
+		},
+	}
+	if len(s.Files) != 3 || s.Files[0].ID != "a/one" {
+		t.Fatalf("Files() = %+v", s.Files)
+	}
+	if err := s.ValidateFiles(); err != nil {
+		t.Fatalf("ValidateFiles: %v", err)
+	}
+
+	for offset, want := range map[int64]string{0: "a/one", 9: "a/one", 10: "a/two", 14: "a/two", 15: ""} {
+		if got := s.FileIDAt(offset); got != want {
+			t.Errorf("FileIDAt(%d) = %q, want %q", offset, got, want)
+		}
+	}
+	if got := s.FileChunks("a/two"); len(got) != 1 || got[0].ID != "c2" {
+		t.Errorf("FileChunks(a/two) = %v", got)
+	}
+	if got := s.FileChunks("a/one"); len(got) != 2 {
+		t.Errorf("FileChunks(a/one) has %d chunks, want 2", len(got))
+	}
+	if got := s.FileChunks("a/empty"); len(got) != 0 {
+		t.Errorf("FileChunks(a/empty) = %v", got)
+	}
 
+	s.Files[2].ID = "a/one"
+	if err := s.ValidateFiles(); err == nil {
+		t.Error("expected error for duplicate file id")
+	}
+	s.Files[2].ID = "a/two"
+	s.Files[2].Offset = 9
+	if err := s.ValidateFiles(); err == nil {
+		t.Error("expected error for overlapping files")
+	}
+	s.Files[2].Offset = 11
+	if err := s.ValidateFiles(); err == nil {
+		t.Error("expected error for files past the end of the stream")
+	}
+
+	// Single-file sessions have no file IDs.
+	single := &TransferSession{File: FileMetadata{Name: "x", Size: 4}}
+	if single.FileIDAt(0) != "" || len(single.AllFiles()) != 1 {
+		t.Error("single-file session")
+	}
+	if f, ok := single.FileAt(3); !ok || f.Name != "x" {
+		t.Error("FileAt in single-file session")
+	}
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ShardHeader prefixes the payload of DATA packets that carry erasure-coded
+// shards. A chunk is split into blocks of DataShards shards, and each block is
+// protected by ParityShards parity shards.
+//
+// Layout (12 bytes, big endian):
+//
+//	Block        uint32 // block index within the chunk
+//	Index        uint8  // shard index within the block (parity follows data)
+//	DataShards   uint8
+//	ParityShards uint8
+//	_reserved    uint8
+//	BlockLen     uint32 // length of the block's data before padding
+type ShardHeader struct {
+	Block        uint32
+	Index        uint8
+	DataShards   uint8
+	ParityShards uint8
+	BlockLen     uint32
+}
+
+// ShardHeaderSize is the encoded size of a ShardHeader.
+const ShardHeaderSize = 12
+
+// MaxShardSize is the largest shard whose packet fits in a single Ethernet
+// frame (1500 byte MTU less 28 bytes of IPv4/UDP headers). Keeping shards
+// below the MTU avoids IP fragmentation, so one lost frame costs one shard.
+const MaxShardSize = 1472 - headerSize - checksumSize - ShardHeaderSize
+
+// EncodeShard returns a packet payload carrying shard with header h.
+func EncodeShard(h ShardHeader, shard []byte) []byte {
+	out := make([]byte, ShardHeaderSize+len(shard))
+	binary.BigEndian.PutUint32(out[0:4], h.Block)
+	out[4] = h.Index
+	out[5] = h.DataShards
+	out[6] = h.ParityShards
+	binary.BigEndian.PutUint32(out[8:12], h.BlockLen)
+	copy(out[ShardHeaderSize:], shard)
+	return out
+}
+
+// DecodeShard splits a packet payload into its ShardHeader and shard bytes.
+func DecodeShard(payload []byte) (ShardHeader, []byte, error) {
+	if len(payload) < ShardHeaderSize {
+		return ShardHeader{}, nil, errors.New("shard payload too small")
+	}
+	h := ShardHeader{
+		Block:        binary.BigEndian.Uint32(payload[0:4]),
+		Index:        payload[4],
+		DataShards:   payload[5],
+		ParityShards: payload[6],
+		BlockLen:     binary.BigEndian.Uint32(payload[8:12]),
+	}
+	if h.DataShards == 0 || int(h.Index) >= int(h.DataShards)+int(h.ParityShards) {
+		return ShardHeader{}, nil, errors.New("invalid shard header")
+	}
+	return h, payload[ShardHeaderSize:], nil
+}
+
+// EncodeNack returns a NACK payload listing missing sequence numbers.
+func EncodeNack(seqs []uint32) []byte {
+	out := make([]byte, 4*len(seqs))
+	for i, s := range seqs {
+		binary.BigEndian.PutUint32(out[4*i:], s)
+	}
+	return out
+}
+
+// DecodeNack parses a NACK payload produced by EncodeNack.
+func DecodeNack(payload []byte) ([]uint32, error) {
+	if len(payload)%4 != 0 {
+		return nil, errors.New("invalid nack payload length")
+	}
+	seqs := make([]uint32, len(payload)/4)
+	for i := range seqs {
+		seqs[i] = binary.BigEndian.Uint32(payload[4*i:])
+	}
+	return seqs, nil
+}
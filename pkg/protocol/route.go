@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MaxRouteHops is the most hops a route packet may list, which bounds the
+// relay chains a session can be sent through and ends routing loops.
+const MaxRouteHops = 8
+
+// EncodeRoute returns the payload of a PacketTypeRoute packet routing the
+// packet's session through hops, host:port addresses in order: the relays
+// to chain through, if any, then the receiver. A relay routes the session to
+// the first hop and passes a route packet with the rest on to it.
+func EncodeRoute(hops ...string) []byte {
+	return []byte(strings.Join(hops, ","))
+}
+
+// DecodeRoute parses a payload produced by EncodeRoute.
+func DecodeRoute(payload []byte) ([]string, error) {
+	hops := strings.Split(string(payload), ",")
+	if len(hops) > MaxRouteHops {
+		return nil, fmt.Errorf("invalid route payload: %d hops, at most %d allowed", len(hops), MaxRouteHops)
+	}
+	for _, hop := range hops {
+		if _, _, err := net.SplitHostPort(hop); err != nil {
+			return nil, errors.New("invalid route payload: " + err.Error())
+		}
+	}
+	return hops, nil
+}
+
+// PacketHeader is the part of a packet's header relays route by.
+type PacketHeader struct {
+	Type      PacketType
+	SessionID [16]byte
+	Seq       uint32
+}
+
+// PeekHeader returns the header of a serialized packet without parsing the
+// rest of it or verifying its checksum, for relays that only route packets.
+// ok is false if data is not a packet of this protocol.
+func PeekHeader(data []byte) (h PacketHeader, ok bool) {
+	if len(data) < headerSize+checksumSize || [4]byte(data[:4]) != magic {
+		return h, false
+	}
+	h.Type = PacketType(data[5])
+	copy(h.SessionID[:], data[6:22])
+	h.Seq = binary.BigEndian.Uint32(data[30:34])
+	return h, true
+}
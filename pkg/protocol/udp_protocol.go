@@ -16,21 +16,25 @@ const (
 	PacketTypeAck     PacketType = 0x02
 	PacketTypeNack    PacketType = 0x03
 	PacketTypeControl PacketType = 0x04
+	// PacketTypeRoute asks a relay to forward the packets of its session to
+	// the address in its payload; see EncodeRoute. The relay consumes it.
+	PacketTypeRoute PacketType = 0x05
 )
 
 // Packet represents a TrackShift UDP packet.
 //
 // Header layout (not exported directly, used by serialization):
-//   Magic       [4]byte  // "TSFT"
-//   Version     uint8
-//   Type        uint8
-//   SessionID   [16]byte // UUID
-//   ChunkID     uint64
-//   Seq         uint32
-//   Priority    uint8
-//   _pad        [3]byte  // padding for alignment / future use
-//   Payload     []byte   // up to 64KB
-//   Checksum    uint32   // CRC32 over header+payload (checksum field zeroed)
+//
+//	Magic       [4]byte  // "TSFT"
+//	Version     uint8
+//	Type        uint8
+//	SessionID   [16]byte // UUID
+//	ChunkID     uint64
+//	Seq         uint32
+//	Priority    uint8
+//	_pad        [3]byte  // padding for alignment / future use
+//	Payload     []byte   // up to 64KB
+//	Checksum    uint32   // CRC32 over header+payload (checksum field zeroed)
 type Packet struct {
 	Version   uint8
 	Type      PacketType
@@ -47,7 +51,7 @@ var magic = [4]byte{'T', 'S', 'F', 'T'}
 const (
 	headerSize   = 4 + 1 + 1 + 16 + 8 + 4 + 1 + 3 // 38 bytes
 	maxPayload   = 64 * 1024
-	currentVer   = 1
+	currentVer   = CurrentVersion
 	checksumSize = 4
 )
 
@@ -123,6 +127,10 @@ func DeserializePacket(data []byte) (*Packet, error) {
 		return nil, err
 	}
 
+	if err := CheckVersion(version); err != nil {
+		return nil, err
+	}
+
 	var t uint8
 	if err := binary.Read(buf, binary.BigEndian, &t); err != nil {
 		return nil, err
@@ -192,5 +200,3 @@ func CalculateChecksum(data []byte) uint32 {
 func VerifyChecksum(data []byte, checksum uint32) bool {
 	return CalculateChecksum(data) == checksum
 }
-
-
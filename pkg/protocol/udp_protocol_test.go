@@ -58,4 +58,45 @@ func TestChecksumVerificationFailure(t *testing.T) {
 	}
 }
 
+func TestPeekHeaderAndRoute(t *testing.T) {
+	var sessID [16]byte
+	copy(sessID[:], []byte("session-12345678"))
+	data, err := SerializePacket(&Packet{
+		Version:   currentVer,
+		Type:      PacketTypeRoute,
+		SessionID: sessID,
+		Seq:       9,
+		Payload:   EncodeRoute("10.0.0.7:9090"),
+	})
+	if err != nil {
+		t.Fatalf("SerializePacket error: %v", err)
+	}
+	h, ok := PeekHeader(data)
+	if !ok || h.Type != PacketTypeRoute || h.SessionID != sessID || h.Seq != 9 {
+		t.Fatalf("PeekHeader = %+v, %v", h, ok)
+	}
+	p, err := DeserializePacket(data)
+	if err != nil {
+		t.Fatalf("DeserializePacket error: %v", err)
+	}
+	if hops, err := DecodeRoute(p.Payload); err != nil || len(hops) != 1 || hops[0] != "10.0.0.7:9090" {
+		t.Fatalf("DecodeRoute = %q, %v", hops, err)
+	}
+	if hops, err := DecodeRoute(EncodeRoute("relay-b:9001", "10.0.0.7:9090")); err != nil || len(hops) != 2 || hops[0] != "relay-b:9001" {
+		t.Fatalf("DecodeRoute of a chain = %q, %v", hops, err)
+	}
+	long := make([]string, MaxRouteHops+1)
+	for i := range long {
+		long[i] = "relay:9001"
+	}
+	if _, err := DecodeRoute(EncodeRoute(long...)); err == nil {
+		t.Fatal("DecodeRoute accepted a route past MaxRouteHops")
+	}
 
+	if _, ok := PeekHeader([]byte("not a trackshift packet at all, though long enough")); ok {
+		t.Fatal("PeekHeader accepted a foreign datagram")
+	}
+	if _, err := DecodeRoute([]byte("no-port")); err == nil {
+		t.Fatal("DecodeRoute accepted an address without a port")
+	}
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol versions understood by this build. The version is carried in the
+// UDP packet header and in the file metadata control frame on TCP.
+const (
+	// Version1 is the original wire format: every chunk payload is zstd
+	// compressed and chunk metadata carries no compression field.
+	Version1 uint8 = 1
+	// Version2 records the compression applied per chunk, allowing
+	// incompressible chunks to be sent raw.
+	Version2 uint8 = 2
+	// Version3 adds streamed TCP frames, where chunk data is sent as a
+	// sequence of independently compressed segments.
+	Version3 uint8 = 3
+	// Version4 adds the clock synchronisation exchange at session start and
+	// per-chunk send timestamps.
+	Version4 uint8 = 4
+	// Version5 adds the manifest control frame used for directory transfers.
+	Version5 uint8 = 5
+	// Version6 lets the receiver send rate control frames back to the sender
+	// during a transfer.
+	Version6 uint8 = 6
+	// Version7 adds the pause frame, sent while a transfer is paused.
+	Version7 uint8 = 7
+	// Version8 lets the receiver send prefetch hints naming byte ranges the
+	// sender should send next.
+	Version8 uint8 = 8
+	// Version9 adds delta transfers, where the receiver reports the chunk
+	// hashes of an earlier version of the file and unchanged chunks are
+	// copied from it instead of being sent.
+	Version9 uint8 = 9
+	// Version10 adds delivery receipts: a sender may ask for one at the end
+	// of a session and the receiver answers with a signed receipt once the
+	// file is verified.
+	Version10 uint8 = 10
+	// Version11 lets a resuming sender ask which chunks the receiver still
+	// holds from an earlier attempt at the session, and skip them.
+	Version11 uint8 = 11
+	// Version12 has the receiver answer the file metadata frame with the
+	// version it picked, so a sender newer than its receiver steps down.
+	Version12 uint8 = 12
+
+	// CurrentVersion is the version new sessions are created with.
+	CurrentVersion = Version12
+	// MinSupportedVersion is the oldest version this build can still talk to.
+	MinSupportedVersion = Version1
+)
+
+// ErrUnsupportedVersion is returned when a peer speaks a version outside
+// [MinSupportedVersion, CurrentVersion].
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// CheckVersion returns ErrUnsupportedVersion if v cannot be handled.
+func CheckVersion(v uint8) error {
+	if v < MinSupportedVersion || v > CurrentVersion {
+		return fmt.Errorf("%w: %d (supported %d-%d)", ErrUnsupportedVersion, v, MinSupportedVersion, CurrentVersion)
+	}
+	return nil
+}
+
+// NegotiateVersion picks the version two peers should use: the lower of the
+// two, as long as both sides support it. A zero version is treated as
+// Version1, since peers predating negotiation never announced one.
+func NegotiateVersion(local, peer uint8) (uint8, error) {
+	if local == 0 {
+		local = Version1
+	}
+	if peer == 0 {
+		peer = Version1
+	}
+	v := local
+	if peer < v {
+		v = peer
+	}
+	if err := CheckVersion(v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// SupportsChunkCompressionField reports whether peers on version v understand
+// the per-chunk compression field and accept raw (uncompressed) payloads.
+func SupportsChunkCompressionField(v uint8) bool {
+	return v >= Version2
+}
+
+// SupportsStreamedFrames reports whether peers on version v accept streamed
+// (segmented) TCP chunk frames.
+func SupportsStreamedFrames(v uint8) bool {
+	return v >= Version3
+}
+
+// SupportsTimeSync reports whether peers on version v answer time sync frames.
+func SupportsTimeSync(v uint8) bool {
+	return v >= Version4
+}
+
+// SupportsManifest reports whether peers on version v accept the manifest
+// control frame and can recreate a directory tree.
+func SupportsManifest(v uint8) bool {
+	return v >= Version5
+}
+
+// SupportsRateControl reports whether senders on version v read rate control
+// frames from the receiver.
+func SupportsRateControl(v uint8) bool {
+	return v >= Version6
+}
+
+// SupportsPause reports whether receivers on version v accept pause frames.
+func SupportsPause(v uint8) bool {
+	return v >= Version7
+}
+
+// SupportsPrefetch reports whether senders on version v read prefetch frames
+// from the receiver.
+func SupportsPrefetch(v uint8) bool {
+	return v >= Version8
+}
+
+// SupportsDelta reports whether receivers on version v answer delta requests
+// and accept copy frames.
+func SupportsDelta(v uint8) bool {
+	return v >= Version9
+}
+
+// SupportsReceipts reports whether receivers on version v answer receipt
+// requests.
+func SupportsReceipts(v uint8) bool {
+	return v >= Version10
+}
+
+// SupportsResume reports whether receivers on version v answer resume
+// requests with the chunks they hold.
+func SupportsResume(v uint8) bool {
+	return v >= Version11
+}
+
+// SupportsVersionReply reports whether receivers on version v answer the file
+// metadata frame with a version frame.
+func SupportsVersionReply(v uint8) bool {
+	return v >= Version12
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readHexFixture(t *testing.T, path string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	return data
+}
+
+func TestDeserializeRecordedV1Packet(t *testing.T) {
+	// The first packet a UDPSender of the v1 build sends for chunk 3 at
+	// priority 2, as it arrived at a UDP socket.
+	data := readHexFixture(t, "testdata/v1_data_packet.hex")
+
+	p, err := DeserializePacket(data)
+	if err != nil {
+		t.Fatalf("DeserializePacket error: %v", err)
+	}
+	if p.Version != Version1 || p.Type != PacketTypeData || p.ChunkID != 3 || p.Seq != 1 || p.Priority != 2 {
+		t.Fatalf("unexpected header: %+v", p)
+	}
+	if string(p.SessionID[:14]) != "legacy-session" {
+		t.Fatalf("session ID %x", p.SessionID)
+	}
+	if !bytes.Equal(p.Payload, []byte("trackshift v1 payload")) {
+		t.Fatalf("payload mismatch: %q", p.Payload)
+	}
+}
+
+func TestCurrentPacketReadableByV1Layout(t *testing.T) {
+	// The header layout is unchanged since v1, so re-encoding the
+	// recorded packet with the current version must only differ in that byte.
+	recorded := readHexFixture(t, "testdata/v1_data_packet.hex")
+	p, err := DeserializePacket(recorded)
+	if err != nil {
+		t.Fatalf("DeserializePacket error: %v", err)
+	}
+	p.Version = CurrentVersion
+	data, err := SerializePacket(p)
+	if err != nil {
+		t.Fatalf("SerializePacket error: %v", err)
+	}
+	if len(data) != len(recorded) {
+		t.Fatalf("packet length changed: %d != %d", len(data), len(recorded))
+	}
+	if !bytes.Equal(data[:4], recorded[:4]) || !bytes.Equal(data[6:len(data)-checksumSize], recorded[6:len(recorded)-checksumSize]) {
+		t.Fatalf("packet layout changed beyond the version byte")
+	}
+}
+
+func TestDeserializeRejectsUnknownVersion(t *testing.T) {
+	p := &Packet{Version: CurrentVersion + 1, Type: PacketTypeData, Payload: []byte("x")}
+	data, err := SerializePacket(p)
+	if err != nil {
+		t.Fatalf("SerializePacket error: %v", err)
+	}
+	if _, err := DeserializePacket(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		local, peer uint8
+		want        uint8
+		wantErr     bool
+	}{
+		{CurrentVersion, CurrentVersion, CurrentVersion, false},
+		{CurrentVersion, Version1, Version1, false},
+		{Version1, CurrentVersion, Version1, false},
+		{CurrentVersion, 0, Version1, false}, // peer predates negotiation
+		{CurrentVersion, CurrentVersion + 1, CurrentVersion, false},
+		{CurrentVersion + 1, CurrentVersion + 2, 0, true},
+	}
+	for _, tc := range cases {
+		got, err := NegotiateVersion(tc.local, tc.peer)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("NegotiateVersion(%d, %d): expected error", tc.local, tc.peer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("NegotiateVersion(%d, %d) error: %v", tc.local, tc.peer, err)
+		}
+		if got != tc.want {
+			t.Fatalf("NegotiateVersion(%d, %d) = %d, want %d", tc.local, tc.peer, got, tc.want)
+		}
+	}
+}
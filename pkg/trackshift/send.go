@@ -0,0 +1,159 @@
+package trackshift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/chunker"
+	"github.com/deb2000-sudo/trackshift/internal/ratelimit"
+	"github.com/deb2000-sudo/trackshift/internal/receipt"
+	"github.com/deb2000-sudo/trackshift/internal/transport"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+	"github.com/deb2000-sudo/trackshift/pkg/protocol"
+	"github.com/deb2000-sudo/trackshift/pkg/utils"
+	"github.com/google/uuid"
+)
+
+// Send sends the file at opts.Path to opts.Receiver over TCP as a new
+// session and waits for the receiver to verify it and sign a delivery
+// receipt. If ctx is done first, the connection is closed and ctx.Err()
+// returned; the receiver keeps what arrived, but a later Send starts over.
+func Send(ctx context.Context, opts SendOptions) (*Result, error) {
+	f, err := os.Open(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", opts.Path)
+	}
+	hash, err := utils.HashReaderSHA256(f)
+	if err != nil {
+		return nil, fmt.Errorf("hash %s: %w", opts.Path, err)
+	}
+	chunks, err := chunker.NewChunker(chunker.ChunkerConfig{}).ChunkReaderAt(f, info.Size(), opts.ChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("chunk file: %w", err)
+	}
+	id := uuid.NewString()
+	file := models.FileMetadata{
+		Name:            info.Name(),
+		Size:            info.Size(),
+		Hash:            hash,
+		SenderSession:   id,
+		ProtocolVersion: protocol.CurrentVersion,
+	}
+
+	sender := transport.NewTCPSender()
+	if opts.MaxBandwidth > 0 {
+		sender.Limiter = ratelimit.New(opts.MaxBandwidth)
+	}
+	started := time.Now()
+	conn, err := sender.Connect(ctx, opts.Receiver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	r, err := send(ctx, conn, sender, f, file, chunks, opts.AuthToken, opts.OnProgress)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Verify(opts.TrustedKey); err != nil {
+		return nil, err
+	}
+	if r.FileHash != file.Hash || r.Size != file.Size {
+		return nil, fmt.Errorf("receipt is for a different file (hash %s, %d bytes)", r.FileHash, r.Size)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Session:     id,
+		Bytes:       file.Size,
+		Duration:    time.Since(started),
+		Receiver:    r.Receiver,
+		ReceiverKey: r.PublicKey,
+		Receipt:     data,
+	}, nil
+}
+
+// send sends file on conn in chunks, then asks for a receipt and returns
+// it once the receiver has verified the file.
+func send(ctx context.Context, conn net.Conn, sender *transport.TCPSender, src io.ReaderAt, file models.FileMetadata, chunks []*models.ChunkMetadata, token string, progress func(Progress)) (*receipt.Receipt, error) {
+	if token != "" {
+		if err := sender.Authenticate(ctx, conn, token); err != nil {
+			return nil, err
+		}
+	}
+	// SendFile without chunks sends only the file metadata, so the chunks
+	// can be counted as they go.
+	if err := sender.SendFile(ctx, conn, src, file, nil); err != nil {
+		return nil, err
+	}
+	sent := int64(0)
+	for _, c := range chunks {
+		c.SentAt = time.Now()
+		r := io.Reader(io.NewSectionReader(src, c.Offset, c.Size))
+		if progress != nil {
+			r = &progressReader{r: r, n: &sent, report: func(n int64) {
+				progress(Progress{Session: file.SenderSession, File: file.Name, Bytes: n, Total: file.Size})
+			}}
+		}
+		if err := sender.SendStream(ctx, conn, r, c); err != nil {
+			return nil, fmt.Errorf("send chunk %s: %w", c.ID, err)
+		}
+	}
+
+	if err := sender.RequestReceipt(ctx, conn, transport.ReceiptRequest{SessionID: file.SenderSession}); err != nil {
+		return nil, fmt.Errorf("request receipt: %w", err)
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil {
+			return nil, err
+		}
+	}
+	var reply *transport.ReceiptReply
+	err := sender.ReadControlFrames(ctx, conn, transport.ControlHandlers{
+		Receipt: func(r transport.ReceiptReply) { reply = &r },
+	})
+	switch {
+	case reply != nil && reply.Error != "":
+		return nil, fmt.Errorf("receiver issued no receipt: %s", reply.Error)
+	case reply != nil:
+		return reply.Receipt, nil
+	case err != nil:
+		return nil, fmt.Errorf("wait for receipt: %w", err)
+	}
+	return nil, errors.New("receiver closed the connection without a receipt")
+}
+
+// progressReader reports the running total of n as r is read.
+type progressReader struct {
+	r      io.Reader
+	n      *int64
+	report func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		*p.n += int64(n)
+		p.report(*p.n)
+	}
+	return n, err
+}
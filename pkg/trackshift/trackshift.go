@@ -0,0 +1,152 @@
+// Package trackshift embeds the transfer engine in other programs: Send
+// transfers a file to a receiver, as trackshift send does, and Receive
+// accepts transfers, as trackshift receive does. Both report progress
+// through a callback and stop when their context is done.
+//
+// The engine logs through the standard log package, like the commands.
+package trackshift
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"time"
+
+	"github.com/deb2000-sudo/trackshift/internal/cli/receive"
+	"github.com/deb2000-sudo/trackshift/pkg/models"
+)
+
+// Progress reports how much of a file has been sent or received.
+type Progress struct {
+	// Session is the session's ID: the sender's in Send, the receiver's in
+	// Receive.
+	Session string
+	File    string
+	// Bytes is the file data sent, or stored by the receiver, so far, out
+	// of Total.
+	Bytes int64
+	Total int64
+}
+
+// SendOptions configures Send.
+type SendOptions struct {
+	// Path is the file to send. Directories are not supported; send an
+	// archive of one instead.
+	Path string
+	// Receiver is the receiver's host:port.
+	Receiver string
+	// ChunkSize is the size of the chunks the file is sent in, clamped to
+	// 5MB-200MB (default 50MB).
+	ChunkSize int64
+	// AuthToken is presented to a receiver that requires one.
+	AuthToken string
+	// MaxBandwidth, if positive, caps the sending rate in bytes per second.
+	MaxBandwidth float64
+	// TrustedKey, if set, is the only key the receiver's delivery receipt
+	// may be signed with; otherwise any valid signature is accepted.
+	TrustedKey ed25519.PublicKey
+	// OnProgress, if non-nil, is called as the file is sent.
+	OnProgress func(Progress)
+}
+
+// Result describes a file delivered by Send.
+type Result struct {
+	Session string
+	Bytes   int64
+	// Duration is the time from connecting to the receipt.
+	Duration time.Duration
+	// Receiver is the receiving node as named in its receipt, and
+	// ReceiverKey the key that signed the receipt.
+	Receiver    string
+	ReceiverKey ed25519.PublicKey
+	// Receipt is the signed delivery receipt as JSON, as saved by
+	// trackshift send -receipt and checked by trackshift verify.
+	Receipt []byte
+}
+
+// ReceiveOptions configures Receive.
+type ReceiveOptions struct {
+	// Addr is the address to listen on (default ":8080"). Listener, if
+	// set, is used instead; Receive closes it.
+	Addr     string
+	Listener net.Listener
+	// OutputDir is where received files are written (default "received"),
+	// TempDir where chunks are kept until then (default OutputDir/temp),
+	// and SessionDir where sessions are recorded so interrupted transfers
+	// resume (default "sessions").
+	OutputDir  string
+	TempDir    string
+	SessionDir string
+	// Direct writes chunks in place into the output file instead of
+	// assembling it from temp chunks at the end.
+	Direct bool
+	// AuthTokens, if any, are the tokens senders must present one of, each
+	// SECRET[,max-size=SIZE][,subdir=DIR][,name=NAME].
+	AuthTokens []string
+	// NodeID names this receiver in the delivery receipts it signs
+	// (default the hostname), with the key in SessionDir/receipt.key.
+	NodeID string
+
+	// OnProgress, if non-nil, is called as each chunk is stored, and
+	// OnDelivery as each session ends. Sessions on different connections
+	// call them concurrently.
+	OnProgress func(Progress)
+	OnDelivery func(Delivery)
+}
+
+// Delivery is how a session received by Receive ended.
+type Delivery struct {
+	Session string
+	File    string
+	Size    int64
+	// Hash is the SHA-256 of the file, in hex.
+	Hash string
+	// Path is where the file was written, or Err why it was not.
+	Path string
+	Err  error
+}
+
+// Receive accepts transfers until ctx is done, then stops accepting, ends
+// the transfers in progress and returns ctx.Err(). It returns early if the
+// receiver cannot listen or be set up.
+func Receive(ctx context.Context, opts ReceiveOptions) error {
+	ln := opts.Listener
+	if ln == nil {
+		addr := opts.Addr
+		if addr == "" {
+			addr = ":8080"
+		}
+		var err error
+		if ln, err = (&net.ListenConfig{}).Listen(ctx, "tcp", addr); err != nil {
+			return err
+		}
+	}
+	defer ln.Close()
+
+	ropts := receive.Options{
+		OutputDir:  opts.OutputDir,
+		TempDir:    opts.TempDir,
+		SessionDir: opts.SessionDir,
+		Direct:     opts.Direct,
+		AuthTokens: opts.AuthTokens,
+		NodeID:     opts.NodeID,
+	}
+	if opts.OnProgress != nil {
+		ropts.OnProgress = func(sess *models.TransferSession, received int64) {
+			opts.OnProgress(Progress{Session: sess.ID, File: sess.File.Name, Bytes: received, Total: sess.File.Size})
+		}
+	}
+	if opts.OnDelivery != nil {
+		ropts.OnSession = func(sess *models.TransferSession, output string, err error) {
+			opts.OnDelivery(Delivery{
+				Session: sess.ID,
+				File:    sess.File.Name,
+				Size:    sess.File.Size,
+				Hash:    sess.File.Hash,
+				Path:    output,
+				Err:     err,
+			})
+		}
+	}
+	return receive.Serve(ctx, ln, ropts)
+}
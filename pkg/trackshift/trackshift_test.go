@@ -0,0 +1,128 @@
+package trackshift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startReceiver runs Receive on a local port until the test ends and
+// returns its address and the sessions it delivered.
+func startReceiver(t *testing.T, dir string) (string, <-chan Delivery, *[]Progress) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries := make(chan Delivery, 1)
+	var progress []Progress
+	done := make(chan error, 1)
+	go func() {
+		done <- Receive(ctx, ReceiveOptions{
+			Listener:   ln,
+			OutputDir:  filepath.Join(dir, "received"),
+			SessionDir: filepath.Join(dir, "sessions"),
+			OnProgress: func(p Progress) { progress = append(progress, p) },
+			OnDelivery: func(d Delivery) { deliveries <- d },
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Receive = %v, want context.Canceled", err)
+		}
+	})
+	return ln.Addr().String(), deliveries, &progress
+}
+
+func writeFile(t *testing.T, dir string, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	path := filepath.Join(dir, "payload.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestSendReceive(t *testing.T) {
+	dir := t.TempDir()
+	addr, deliveries, recvProgress := startReceiver(t, dir)
+	path, data := writeFile(t, dir, 12<<20)
+
+	var sent []Progress
+	res, err := Send(context.Background(), SendOptions{
+		Path:       path,
+		Receiver:   addr,
+		ChunkSize:  5 << 20,
+		OnProgress: func(p Progress) { sent = append(sent, p) },
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if res.Bytes != int64(len(data)) || len(res.Receipt) == 0 || len(res.ReceiverKey) == 0 {
+		t.Fatalf("result %+v", res)
+	}
+	if len(sent) == 0 || sent[len(sent)-1].Bytes != int64(len(data)) || sent[0].Session != res.Session {
+		t.Fatalf("send progress %+v", sent)
+	}
+
+	d := <-deliveries
+	if d.Err != nil {
+		t.Fatalf("delivery: %v", d.Err)
+	}
+	got, err := os.ReadFile(d.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || d.File != "payload.bin" || d.Size != int64(len(data)) {
+		t.Fatalf("delivered %s (%d bytes of %s)", d.Path, len(got), d.File)
+	}
+	// Three chunks of at most 5MB were stored.
+	if p := *recvProgress; len(p) != 3 || p[2].Bytes != int64(len(data)) || p[2].Session != d.Session {
+		t.Fatalf("receive progress %+v", p)
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	dir := t.TempDir()
+	addr, deliveries, _ := startReceiver(t, dir)
+	path, _ := writeFile(t, dir, 12<<20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	_, err := Send(ctx, SendOptions{
+		Path:         path,
+		Receiver:     addr,
+		MaxBandwidth: 4 << 20,
+		OnProgress: func(p Progress) {
+			if p.Bytes >= 1<<20 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Send = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("Send took %v to stop", time.Since(start))
+	}
+	if d := <-deliveries; d.Err == nil {
+		t.Fatalf("canceled session delivered to %s", d.Path)
+	}
+
+	if _, err := Send(ctx, SendOptions{Path: path, Receiver: addr}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Send with a done context = %v", err)
+	}
+}
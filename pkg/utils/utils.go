@@ -23,6 +23,15 @@ func HashFileSHA256(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// HashReaderSHA256 returns the hex-encoded SHA-256 hash of everything read from r.
+func HashReaderSHA256(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // HashBytesSHA256 returns the hex-encoded SHA-256 hash of the given bytes.
 func HashBytesSHA256(b []byte) string {
 	h := sha256.Sum256(b)
@@ -53,5 +62,3 @@ func HumanBytes(n int64) string {
 		return fmt.Sprintf("%dB", n)
 	}
 }
-
-